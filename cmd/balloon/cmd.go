@@ -0,0 +1,158 @@
+package balloon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/cobra"
+)
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "balloon",
+	Short: "Updates the memory balloon of a running VMM",
+	Run:   run,
+	Long: `The VMM must be started with --balloon.
+Inflating the balloon reclaims the guest memory, deflating the balloon returns the memory to the guest.`,
+}
+
+var (
+	commandConfig  = configs.NewBalloonCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	runCache       = configs.NewRunCacheConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-balloon")
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(runCache.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Dynamic completion:
+	Command.RegisterFlagCompletionFunc("vmm-id", completion.RunningVMMIDs(profilesConfig, runCache))
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("balloon")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanBalloon := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("balloon"))
+	spanBalloon.SetTag("vmm-id", commandConfig.VMMID)
+	cleanup.Add(func() {
+		spanBalloon.Finish()
+	})
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		runCache,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			spanBalloon.SetBaggageItem("error", err.Error())
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	spanFetchMetadata := tracer.StartSpan("fetch-metadata", opentracing.ChildOf(spanBalloon.Context()))
+
+	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), commandConfig.VMMID))
+	if metadataErr != nil {
+		rootLogger.Error("failed loading metadata", "reason", metadataErr, "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.SetBaggageItem("error", metadataErr.Error())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.SetTag("has-metadata", hasMetadata)
+
+	if !hasMetadata {
+		rootLogger.Error("run cache directory did not contain the VMM metadata", "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.Finish()
+
+	isRunning, runningErr := vmmMetadata.PID.IsRunning()
+	if runningErr != nil || !isRunning {
+		rootLogger.Error("VMM is not running", "vmm-id", commandConfig.VMMID, "reason", runningErr)
+		return 1
+	}
+
+	if vmmMetadata.IsPaused() {
+		rootLogger.Error("VMM is paused, resume it with the resume command", "vmm-id", commandConfig.VMMID)
+		return 1
+	}
+
+	if vmmMetadata.Configs.Machine == nil || !vmmMetadata.Configs.Machine.Balloon {
+		rootLogger.Error("VMM was started without --balloon", "vmm-id", commandConfig.VMMID)
+		return 1
+	}
+
+	if commandConfig.TargetMib >= vmmMetadata.Configs.Machine.Mem {
+		rootLogger.Error("balloon target must be lower than the VMM memory", "target-mib", commandConfig.TargetMib, "mem", vmmMetadata.Configs.Machine.Mem)
+		return 1
+	}
+
+	spanUpdate := tracer.StartSpan("balloon-update", opentracing.ChildOf(spanFetchMetadata.Context()))
+	spanUpdate.SetTag("target-mib", commandConfig.TargetMib)
+
+	vmmProvider := vmm.NewDefaultProvider(vmmMetadata.Configs.CNI, vmmMetadata.Configs.Jailer, vmmMetadata.Configs.Machine)
+
+	if err := vmmProvider.UpdateBalloon(context.Background(), commandConfig.VMMID, commandConfig.TargetMib); err != nil {
+		rootLogger.Error("failed updating balloon", "reason", err)
+		spanUpdate.SetBaggageItem("error", err.Error())
+		spanUpdate.Finish()
+		return 1
+	}
+
+	spanUpdate.Finish()
+
+	rootLogger.Info("balloon updated", "target-mib", commandConfig.TargetMib)
+
+	return 0
+
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -22,6 +21,8 @@ import (
 	"github.com/combust-labs/firebuild/pkg/storage/resolver"
 	"github.com/combust-labs/firebuild/pkg/tracing"
 	"github.com/combust-labs/firebuild/pkg/utils"
+	docker "github.com/docker/docker/client"
+	"github.com/hashicorp/go-hclog"
 	"github.com/opentracing/opentracing-go"
 	"github.com/spf13/cobra"
 )
@@ -36,8 +37,10 @@ var Command = &cobra.Command{
 
 var (
 	commandConfig  = configs.NewBaseOSCommandConfig()
+	dockerConfig   = configs.NewDockerConfig()
 	logConfig      = configs.NewLogginConfig()
 	profilesConfig = configs.NewProfileCommandConfig()
+	retryConfig    = configs.NewRetryConfig()
 	tracingConfig  = configs.NewTracingConfig("firebuild-baseos")
 
 	storageResolver = resolver.NewDefaultResolver()
@@ -45,8 +48,10 @@ var (
 
 func initFlags() {
 	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(dockerConfig.FlagSet())
 	Command.Flags().AddFlagSet(logConfig.FlagSet())
 	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(retryConfig.FlagSet())
 	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
 	// Storage provider flags:
 	resolver.AddStorageFlags(Command.Flags())
@@ -99,6 +104,52 @@ func processCommand() int {
 		spanBuild.Finish()
 	})
 
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		dockerConfig,
+		retryConfig,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			rootLogger.Error("configuration is invalid", "reason", err)
+			spanBuild.SetBaggageItem("error", err.Error())
+			return 1
+		}
+	}
+
+	if commandConfig.ExportMountTarget != "" {
+		if err := containers.ValidateBaseOSExportMountTarget(commandConfig.ExportMountTarget); err != nil {
+			rootLogger.Error("--export-mount-target is invalid", "reason", err)
+			spanBuild.SetBaggageItem("error", err.Error())
+			return 1
+		}
+	}
+
+	if dockerConfig.Platform != "" {
+		platform, err := containers.NormalizePlatform(dockerConfig.Platform)
+		if err != nil {
+			rootLogger.Error("--platform is invalid", "reason", err)
+			spanBuild.SetBaggageItem("error", err.Error())
+			return 1
+		}
+		dockerConfig.Platform = platform
+		if err := containers.CheckBinfmtEmulation(platform); err != nil {
+			rootLogger.Error("platform can't be built on this host", "platform", platform, "host-platform", containers.HostPlatform(), "reason", err)
+			spanBuild.SetBaggageItem("error", err.Error())
+			return 1
+		}
+	}
+
+	// the base OS rootfs is stored for the architecture of the platform:
+	buildArch, archErr := containers.PlatformArchitecture(dockerConfig.Platform)
+	if archErr != nil {
+		rootLogger.Error("--platform is invalid", "reason", archErr)
+		spanBuild.SetBaggageItem("error", archErr.Error())
+		return 1
+	}
+	spanBuild.SetTag("arch", buildArch)
+
 	dockerStat, statErr := os.Stat(commandConfig.Dockerfile)
 	if statErr != nil {
 		rootLogger.Error("error while resolving --dockerfile path", "reason", statErr)
@@ -113,6 +164,8 @@ func processCommand() int {
 	}
 
 	if commandConfig.Tag != "" {
+		// the base OS is stored under the normalized tag:
+		commandConfig.Tag = utils.NormalizeTag(commandConfig.Tag)
 		if !utils.IsValidTag(commandConfig.Tag) {
 			rootLogger.Error("--tag value is invalid", "tag", commandConfig.Tag)
 			spanBuild.SetBaggageItem("error", fmt.Errorf("--tag value is invalid: '%s'", commandConfig.Tag).Error())
@@ -120,16 +173,22 @@ func processCommand() int {
 		}
 	}
 
-	storageImpl, resolveErr := storageResolver.GetStorageImpl(rootLogger)
+	storageImpl, resolveErr := storageResolver.WithRetryConfig(retryConfig.Policy()).GetStorageImpl(rootLogger)
 	if resolveErr != nil {
 		rootLogger.Error("failed resolving storage provider", "reason", resolveErr)
 		spanBuild.SetBaggageItem("error", resolveErr.Error())
 		return 1
 	}
 
+	// the mounts of the interrupted builds block the loop devices:
+	if staleMounts, err := utils.StaleMounts(); err == nil && len(staleMounts) > 0 {
+		rootLogger.Warn("stale mounts of interrupted builds found, run cleanup-mounts to remove them", "count", len(staleMounts))
+	}
+
 	spanTempDir := tracer.StartSpan("baseos-temp-dir", opentracing.ChildOf(spanBuild.Context()))
 
-	tempDirectory, err := ioutil.TempDir("", "")
+	// the mount directory is created in the temp directory, the name identifies the stale mounts:
+	tempDirectory, err := utils.MkdirMountTemp()
 	if err != nil {
 		rootLogger.Error("failed creating temporary build directory", "reason", err)
 		spanTempDir.SetBaggageItem("error", err.Error())
@@ -210,7 +269,7 @@ func processCommand() int {
 	spanGetDockerClient := tracer.StartSpan("baseos-get-docker-client", opentracing.ChildOf(spanReadStages.Context()))
 
 	// we have to build the Docker image, we can use the dependency builder here:
-	client, clientErr := containers.GetDefaultClient()
+	client, clientErr := containers.GetDefaultClientWithTimeout(dockerConfig.ClientTimeout)
 	if clientErr != nil {
 		rootLogger.Error("failed creating a Docker client", "reason", clientErr)
 		spanGetDockerClient.SetBaggageItem("error", clientErr.Error())
@@ -220,7 +279,21 @@ func processCommand() int {
 
 	spanGetDockerClient.Finish()
 
-	tagName := strings.ToLower(utils.RandStringBytes(32)) + ":build"
+	// the build would pull the missing base image without retries, pull it upfront:
+	if fromToBuild.BaseImage != "scratch" {
+		spanPull := tracer.StartSpan("baseos-docker-pull", opentracing.ChildOf(spanGetDockerClient.Context()))
+		spanPull.SetTag("image", fromToBuild.BaseImage)
+		if err := pullBaseImage(client, rootLogger, fromToBuild.BaseImage); err != nil {
+			rootLogger.Error("failed pulling base OS Docker image", "image", fromToBuild.BaseImage, "reason", err)
+			spanPull.SetBaggageItem("error", err.Error())
+			spanPull.Finish()
+			return 1
+		}
+		spanPull.Finish()
+	}
+
+	buildID := strings.ToLower(utils.RandStringBytes(32))
+	tagName := buildID + ":build"
 
 	spanBuild.SetTag("docker-tag", tagName)
 
@@ -229,8 +302,11 @@ func processCommand() int {
 	spanDockerBuild := tracer.StartSpan("baseos-docker-build", opentracing.ChildOf(spanGetDockerClient.Context()))
 	spanDockerBuild.SetTag("docker-tag", tagName)
 
-	if err := containers.ImageBuild(context.Background(), client, rootLogger,
-		filepath.Dir(commandConfig.Dockerfile), "Dockerfile", tagName); err != nil {
+	buildCtx, buildCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationBuild, dockerConfig.BuildTimeout)
+	defer buildCtxCancelFunc()
+
+	if err := containers.ImageBuild(buildCtx, client, rootLogger,
+		filepath.Dir(commandConfig.Dockerfile), "Dockerfile", tagName, buildID, dockerConfig.Platform, false); err != nil {
 		rootLogger.Error("failed building base OS Docker image", "reason", err)
 		spanDockerBuild.SetBaggageItem("error", err.Error())
 		spanDockerBuild.Finish()
@@ -242,7 +318,9 @@ func processCommand() int {
 	cleanup.Add(func() {
 		span := tracer.StartSpan("baseos-docker-image-cleanup", opentracing.ChildOf(spanDockerBuild.Context()))
 		span.SetTag("docker-tag", tagName)
-		if err := containers.ImageRemove(context.Background(), client, rootLogger, tagName); err != nil {
+		removeCtx, removeCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationInspect, dockerConfig.InspectTimeout)
+		defer removeCtxCancelFunc()
+		if err := containers.ImageRemove(removeCtx, client, rootLogger, tagName, buildID); err != nil {
 			rootLogger.Error("failed post-build image clean up", "reason", err)
 			span.SetBaggageItem("error", err.Error())
 		}
@@ -252,7 +330,10 @@ func processCommand() int {
 	spanDockerImageLookup := tracer.StartSpan("baseos-docker-lookup", opentracing.ChildOf(spanGetDockerClient.Context()))
 	spanDockerImageLookup.SetTag("docker-tag", tagName)
 
-	if _, findErr := containers.FindImageIDByTag(context.Background(), client, tagName); findErr != nil {
+	lookupCtx, lookupCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationInspect, dockerConfig.InspectTimeout)
+	defer lookupCtxCancelFunc()
+
+	if _, findErr := containers.FindImageIDByTagAndBuildID(lookupCtx, client, tagName, buildID); findErr != nil {
 		// be extra careful:
 		rootLogger.Error("expected docker image not found", "reason", findErr)
 		spanDockerImageLookup.SetBaggageItem("error", findErr.Error())
@@ -262,6 +343,36 @@ func processCommand() int {
 
 	spanDockerImageLookup.Finish()
 
+	if commandConfig.FSSizeMBs == 0 {
+		spanEstimateSize := tracer.StartSpan("baseos-estimate-rootfs-size", opentracing.ChildOf(spanDockerImageLookup.Context()))
+		readCtx, readCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationSave, dockerConfig.SaveTimeout)
+		defer readCtxCancelFunc()
+		imageMetadata, readErr := containers.ReadImageConfig(readCtx, client, rootLogger, tagName)
+		if readErr != nil {
+			rootLogger.Error("failed estimating file system size, reading the image failed; set --filesystem-size-mbs explicitly", "reason", readErr)
+			spanEstimateSize.SetBaggageItem("error", readErr.Error())
+			spanEstimateSize.Finish()
+			return 1
+		}
+		imageBytes, sizeErr := imageMetadata.LayersSize()
+		if sizeErr != nil {
+			rootLogger.Error("failed estimating file system size; set --filesystem-size-mbs explicitly", "reason", sizeErr)
+			spanEstimateSize.SetBaggageItem("error", sizeErr.Error())
+			spanEstimateSize.Finish()
+			return 1
+		}
+		commandConfig.FSSizeMBs = commandConfig.EstimateFSSizeMBs(imageBytes)
+		rootLogger.Info("file system size estimated from the image size",
+			"image-bytes", imageBytes,
+			"factor", commandConfig.FSSizeFactor,
+			"margin-mb", commandConfig.FSSizeMarginMBs,
+			"size-mb", commandConfig.FSSizeMBs)
+		spanEstimateSize.SetTag("size-mb", commandConfig.FSSizeMBs)
+		spanEstimateSize.Finish()
+	} else {
+		rootLogger.Info("using explicit file system size", "size-mb", commandConfig.FSSizeMBs)
+	}
+
 	rootLogger.Info("image ready, creating EXT4 root file system file", "os", fromToBuild.BaseImage)
 	rootFSFile := filepath.Join(tempDirectory, naming.RootfsFileName)
 
@@ -303,7 +414,10 @@ func processCommand() int {
 		return 1
 	}
 
-	if err := utils.Mount(rootFSFile, mountDir); err != nil {
+	spanMountRootfs.SetTag("loop-direct-io", commandConfig.LoopDirectIO)
+	spanMountRootfs.SetTag("loop-sector-size", commandConfig.LoopSectorSize)
+
+	if err := utils.MountWithLoopOptions(rootFSFile, mountDir, commandConfig.LoopOptions()); err != nil {
 		rootLogger.Error("failed mounting rootfs file in mount dir", "reason", err)
 		spanMountRootfs.SetBaggageItem("error", err.Error())
 		spanMountRootfs.Finish()
@@ -314,20 +428,41 @@ func processCommand() int {
 
 	rootLogger.Info("EXT4 file mounted in mount dir", "rootfs", rootFSFile, "mount-dir", mountDir)
 
-	cleanup.Add(func() {
+	// the file system is unmounted before the shrink, unmount only once:
+	mounted := true
+	unmount := func() error {
+		if !mounted {
+			return nil
+		}
 		span := tracer.StartSpan("baseos-unmount-rootfs", opentracing.ChildOf(spanMountRootfs.Context()))
+		defer span.Finish()
 		if err := utils.Umount(mountDir); err != nil {
 			rootLogger.Error("failed unmounting rootfs mount dir", "reason", err)
 			span.SetBaggageItem("error", err.Error())
+			return err
 		}
-		span.Finish()
+		mounted = false
 		rootLogger.Info("EXT4 file unmounted from mount dir", "rootfs", rootFSFile, "mount-dir", mountDir)
+		return nil
+	}
+
+	cleanup.Add(func() {
+		unmount()
 	})
 
 	spanDockerImageExport := tracer.StartSpan("baseos-docker-export", opentracing.ChildOf(spanMountRootfs.Context()))
 
-	if err := containers.ImageBaseOSExport(context.Background(), client, rootLogger, mountDir, tagName,
-		tracer, spanDockerImageExport.Context()); err != nil {
+	exportCtx, exportCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationSave, dockerConfig.SaveTimeout)
+	defer exportCtxCancelFunc()
+
+	if err := containers.ImageBaseOSExport(exportCtx, client, rootLogger, mountDir, tagName,
+		containers.BaseOSExportOptions{
+			Mode:        commandConfig.ExportMode,
+			MountTarget: commandConfig.ExportMountTarget,
+			Platform:    dockerConfig.Platform,
+			Shell:       commandConfig.ExportShell,
+			StopTimeout: dockerConfig.StopTimeout,
+		}, tracer, spanDockerImageExport.Context()); err != nil {
 		rootLogger.Error("failed building root file system for the base OS", "reason", err)
 		spanDockerImageExport.SetBaggageItem("error", err.Error())
 		return 1
@@ -335,6 +470,33 @@ func processCommand() int {
 
 	spanDockerImageExport.Finish()
 
+	if commandConfig.Shrink {
+		spanRootfsShrink := tracer.StartSpan("baseos-rootfs-shrink", opentracing.ChildOf(spanDockerImageExport.Context()))
+		// resize2fs shrinks only an unmounted file system:
+		if err := unmount(); err != nil {
+			spanRootfsShrink.SetBaggageItem("error", err.Error())
+			spanRootfsShrink.Finish()
+			return 1
+		}
+		rootLogger.Info("shrinking EXT4 file system", "path", rootFSFile, "free-space-mb", commandConfig.FreeSpaceMBs)
+		if err := utils.ShrinkExt4(rootFSFile, commandConfig.FreeSpaceMBs); err != nil {
+			rootLogger.Error("failed shrinking EXT4 file system", "reason", err)
+			spanRootfsShrink.SetBaggageItem("error", err.Error())
+			spanRootfsShrink.Finish()
+			return 1
+		}
+		if err := utils.CheckExt4(rootFSFile); err != nil {
+			rootLogger.Error("EXT4 file system check failed after shrink", "reason", err)
+			spanRootfsShrink.SetBaggageItem("error", err.Error())
+			spanRootfsShrink.Finish()
+			return 1
+		}
+		if shrunkSize, err := utils.Ext4Size(rootFSFile); err == nil {
+			rootLogger.Info("EXT4 file system shrunk", "path", rootFSFile, "size-mb", shrunkSize/1024/1024)
+		}
+		spanRootfsShrink.Finish()
+	}
+
 	spanRootfsPersist := tracer.StartSpan("baseos-rootfs-persist", opentracing.ChildOf(spanMountRootfs.Context()))
 
 	structuredBase := fromToBuild.ToStructuredFrom()
@@ -365,6 +527,7 @@ func processCommand() int {
 				Org:     structuredBase.Org(),
 				Image:   structuredBase.Image(),
 				Version: structuredBase.Version(),
+				Arch:    buildArch,
 			},
 			Labels: map[string]string{},
 			Type:   metadata.MetadataTypeBaseOS,
@@ -372,6 +535,7 @@ func processCommand() int {
 		Org:     resultOrg,
 		Image:   resultImage,
 		Version: resultVersion,
+		Arch:    buildArch,
 	})
 	if storeErr != nil {
 		rootLogger.Error("failed storing built rootfs", "reason", storeErr)
@@ -386,3 +550,26 @@ func processCommand() int {
 
 	return 0
 }
+
+// pullBaseImage pulls the base image with retries and the registry credentials, unless the image exists locally.
+// An image for an explicit platform is always pulled so the matching variant is used.
+func pullBaseImage(client *docker.Client, logger hclog.Logger, image string) error {
+	if dockerConfig.Platform == "" {
+		inspectCtx, inspectCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationInspect, dockerConfig.InspectTimeout)
+		defer inspectCtxCancelFunc()
+		exists, err := containers.ImageExists(inspectCtx, client, image)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+	registryAuths, err := containers.ResolveRegistryAuths(dockerConfig.ConfigFile, dockerConfig.RegistryAuths, dockerConfig.RegistryTokens)
+	if err != nil {
+		return err
+	}
+	pullCtx, pullCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationPull, dockerConfig.PullTimeout)
+	defer pullCtxCancelFunc()
+	return containers.ImagePullWithRetry(pullCtx, client, logger, image, dockerConfig.Platform, registryAuths, retryConfig.Policy())
+}
@@ -0,0 +1,185 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/combust-labs/firebuild/cmd/internal/subcommand"
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/bench"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/hashicorp/go-hclog"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "bench",
+	Short: "Measures the build and the boot timing",
+	Run:   run,
+	Long: `Runs the rootfs build given with --rootfs-arg and the boot cycle given with --run-arg
+--iterations times and prints the percentiles of every phase. The phase durations are the
+durations of the tracing spans of the rootfs and run commands. The VMM is started with --daemonize
+and killed once the run command exits.`,
+}
+
+var (
+	commandConfig  = configs.NewBenchCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-bench")
+)
+
+// benchResult is the result of the benchmark.
+type benchResult struct {
+	Iterations int                   `json:"Iterations"`
+	Phases     []*bench.PhaseSummary `json:"Phases"`
+}
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	rootLogger := logConfig.NewLogger("bench")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+	defer tracerCleanupFunc()
+
+	rootLogger, spanBench := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("bench"))
+	spanBench.SetTag("iterations", commandConfig.Iterations)
+	defer spanBench.Finish()
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			spanBench.SetBaggageItem("error", err.Error())
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		rootLogger.Error("failed resolving the firebuild executable", "reason", err)
+		return 1
+	}
+
+	tempDir, err := ioutil.TempDir("", "firebuild-bench")
+	if err != nil {
+		rootLogger.Error("failed creating temporary directory", "reason", err)
+		return 1
+	}
+	defer os.RemoveAll(tempDir)
+
+	allSpans := []*tracing.SpanRecord{}
+	for iteration := 1; iteration <= commandConfig.Iterations; iteration++ {
+		spanIteration := tracer.StartSpan("bench-iteration", opentracing.ChildOf(spanBench.Context()))
+		spanIteration.SetTag("iteration", iteration)
+		spans, err := runIteration(rootLogger.With("iteration", iteration), subcommand.NewRunner(executable, profilesConfig),
+			filepath.Join(tempDir, fmt.Sprintf("spans-%d.jsonl", iteration)))
+		if err != nil {
+			rootLogger.Error("benchmark iteration failed", "iteration", iteration, "reason", err)
+			spanIteration.SetBaggageItem("error", err.Error())
+			spanIteration.Finish()
+			return 1
+		}
+		spanIteration.Finish()
+		allSpans = append(allSpans, spans...)
+	}
+
+	result := &benchResult{
+		Iterations: commandConfig.Iterations,
+		Phases:     bench.Summarize(allSpans),
+	}
+
+	if commandConfig.JSON {
+		bytes, jsonErr := json.MarshalIndent(result, "", "  ")
+		if jsonErr != nil {
+			rootLogger.Error("failed serializing benchmark result to JSON", "reason", jsonErr)
+			return 1
+		}
+		fmt.Println(string(bytes))
+		return 0
+	}
+
+	for _, phase := range result.Phases {
+		rootLogger.Info("phase",
+			"phase", phase.Phase,
+			"samples", phase.Samples,
+			"min-ms", phase.MinMs,
+			"mean-ms", phase.MeanMs,
+			"p50-ms", phase.P50Ms,
+			"p90-ms", phase.P90Ms,
+			"p99-ms", phase.P99Ms,
+			"max-ms", phase.MaxMs)
+	}
+
+	return 0
+}
+
+// runIteration executes the build and the boot cycle once and returns the recorded spans.
+func runIteration(logger hclog.Logger, runner *subcommand.Runner, spansFile string) ([]*tracing.SpanRecord, error) {
+	if len(commandConfig.RootfsArgs) > 0 {
+		logger.Info("building rootfs")
+		if err := runner.Run("rootfs", nil, append([]string{"--tracing-spans-file=" + spansFile}, commandConfig.RootfsArgs...)...); err != nil {
+			return nil, err
+		}
+	}
+	if len(commandConfig.RunArgs) > 0 {
+		logger.Info("starting VMM")
+		runErr := runner.Run("run", nil, append([]string{"--tracing-spans-file=" + spansFile, "--daemonize"}, commandConfig.RunArgs...)...)
+		// the VMM may have been started even if the command failed:
+		if vmmID := subcommand.StartedVMMID(spansFile); vmmID != "" {
+			logger.Info("killing VMM", "vmm-id", vmmID)
+			if err := runner.Run("kill", nil, append([]string{"--vmm-id=" + vmmID}, commandConfig.KillArgs...)...); err != nil {
+				logger.Warn("failed killing VMM", "vmm-id", vmmID, "reason", err)
+			}
+		}
+		if runErr != nil {
+			return nil, runErr
+		}
+	}
+	spans, err := tracing.ReadSpansFile(spansFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading recorded spans")
+	}
+	return spans, nil
+}
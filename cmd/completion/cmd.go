@@ -0,0 +1,59 @@
+package completion
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Command is the completion command declaration.
+var Command = &cobra.Command{
+	Use:   "completion [bash|zsh|fish|powershell]",
+	Short: "Generates the shell completion script",
+	Long: `Generates the completion script for the shell and writes it to the standard output.
+
+Bash:
+  source <(firebuild completion bash)
+  # load for every session:
+  firebuild completion bash > /etc/bash_completion.d/firebuild
+
+Zsh:
+  # enable the completion, if not enabled yet:
+  echo "autoload -U compinit; compinit" >> ~/.zshrc
+  firebuild completion zsh > "${fpath[1]}/_firebuild"
+
+Fish:
+  firebuild completion fish > ~/.config/fish/completions/firebuild.fish
+
+PowerShell:
+  firebuild completion powershell | Out-String | Invoke-Expression
+`,
+	DisableFlagsInUseLine: true,
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	Args:                  cobra.ExactValidArgs(1),
+	Run:                   run,
+}
+
+func run(cobraCommand *cobra.Command, args []string) {
+	os.Exit(processCommand(cobraCommand.Root(), args[0]))
+}
+
+func processCommand(root *cobra.Command, shell string) int {
+	var err error
+	switch shell {
+	case "bash":
+		err = root.GenBashCompletion(os.Stdout)
+	case "zsh":
+		err = root.GenZshCompletion(os.Stdout)
+	case "fish":
+		err = root.GenFishCompletion(os.Stdout, true)
+	case "powershell":
+		err = root.GenPowerShellCompletion(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed generating completion script:", err)
+		return 1
+	}
+	return 0
+}
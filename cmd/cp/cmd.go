@@ -0,0 +1,209 @@
+package cp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/remote"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/cobra"
+)
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "cp [flags] <vmm-id>:/remote/path ./local/path | ./local/path <vmm-id>:/remote/path",
+	Short: "Copies files and directories in and out of a running VMM",
+	Run:   run,
+	Long:  ``,
+}
+
+var (
+	commandConfig  = configs.NewCpCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	retryConfig    = configs.NewRetryConfig()
+	runCache       = configs.NewRunCacheConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-cp")
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(retryConfig.FlagSet())
+	Command.Flags().AddFlagSet(runCache.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, args []string) {
+	os.Exit(processCommand(args))
+}
+
+func processCommand(args []string) int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("cp")
+
+	if len(args) != 2 {
+		rootLogger.Error("source and destination are required")
+		return 1
+	}
+
+	sourceVMMID, sourcePath := splitVMMPath(args[0])
+	targetVMMID, targetPath := splitVMMPath(args[1])
+
+	if (sourceVMMID == "") == (targetVMMID == "") {
+		rootLogger.Error("exactly one of source and destination must be in the <vmm-id>:/path format")
+		return 1
+	}
+
+	vmmID := sourceVMMID
+	if vmmID == "" {
+		vmmID = targetVMMID
+	}
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanCp := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("cp"))
+	spanCp.SetTag("vmm-id", vmmID)
+	cleanup.Add(func() {
+		spanCp.Finish()
+	})
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		retryConfig,
+		runCache,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			spanCp.SetBaggageItem("error", err.Error())
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	spanFetchMetadata := tracer.StartSpan("fetch-metadata", opentracing.ChildOf(spanCp.Context()))
+
+	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), vmmID))
+	if metadataErr != nil {
+		rootLogger.Error("failed loading metadata", "reason", metadataErr, "vmm-id", vmmID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.SetBaggageItem("error", metadataErr.Error())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.SetTag("has-metadata", hasMetadata)
+
+	if !hasMetadata {
+		rootLogger.Error("run cache directory did not contain the VMM metadata", "vmm-id", vmmID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.Finish()
+
+	isRunning, runningErr := vmmMetadata.PID.IsRunning()
+	if runningErr != nil || !isRunning {
+		rootLogger.Error("VMM is not running", "vmm-id", vmmID, "reason", runningErr)
+		return 1
+	}
+
+	if vmmMetadata.IsPaused() {
+		rootLogger.Error("VMM is paused, resume it with the resume command", "vmm-id", vmmID)
+		return 1
+	}
+
+	connectConfig, connectConfigErr := remote.ConnectConfigFromRunMetadata(vmmMetadata)
+	if connectConfigErr != nil {
+		rootLogger.Error("failed resolving VMM connection details", "vmm-id", vmmID, "reason", connectConfigErr)
+		return 1
+	}
+	connectConfig.IdentityFile = commandConfig.IdentityFile
+	connectConfig.InsecureIgnoreHostKey = commandConfig.InsecureIgnoreHostKey
+	connectConfig.KnownHostsFile = commandConfig.KnownHostsFile
+	if connectConfig.KnownHostsFile == "" {
+		// the VMM host keys are generated on the first boot and the IP addresses are reused,
+		// the keys are trusted on the first use and remembered for the lifetime of the VMM:
+		connectConfig.KnownHostsFile = filepath.Join(runCache.LocationRuns(), vmmID, "known_hosts")
+	}
+	connectConfig.StrictHostKeyChecking = commandConfig.StrictHostKeyChecking
+	connectConfig.Port = commandConfig.SSHPort
+	connectConfig.Timeout = commandConfig.ConnectTimeout
+	if commandConfig.SSHUser != "" {
+		connectConfig.User = commandConfig.SSHUser
+	}
+	if connectConfig.User == "" {
+		rootLogger.Error("VMM was started without SSH user, use --ssh-user", "vmm-id", vmmID)
+		return 1
+	}
+
+	spanCopy := tracer.StartSpan("cp-copy", opentracing.ChildOf(spanFetchMetadata.Context()))
+
+	// the transfer failing on a connection error is retried over a new connection:
+	var copyErr error
+	if sourceVMMID != "" {
+		rootLogger.Info("downloading", "source", sourcePath, "target", targetPath)
+		copyErr = remote.GetResourceWithRetry(context.Background(), connectConfig, retryConfig.Policy(), sourcePath, targetPath)
+	} else {
+		rootLogger.Info("uploading", "source", sourcePath, "target", targetPath)
+		copyErr = remote.PutResourceWithRetry(context.Background(), connectConfig, retryConfig.Policy(), sourcePath, targetPath)
+	}
+
+	if copyErr != nil {
+		rootLogger.Error("copy failed", "reason", copyErr)
+		spanCopy.SetBaggageItem("error", copyErr.Error())
+		spanCopy.Finish()
+		return 1
+	}
+
+	spanCopy.Finish()
+
+	return 0
+
+}
+
+// splitVMMPath splits the <vmm-id>:/path argument into the VMM ID and the path.
+// If the argument does not point at a VMM, the VMM ID is empty.
+func splitVMMPath(input string) (string, string) {
+	parts := strings.SplitN(input, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.Contains(parts[0], "/") {
+		return "", input
+	}
+	if parts[1] == "" {
+		return parts[0], "/"
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,29 @@
+package cp
+
+import "testing"
+
+func TestSplitVMMPath(t *testing.T) {
+	testCases := []struct {
+		input        string
+		expectedVMM  string
+		expectedPath string
+	}{
+		{input: "abcdef:/etc/hosts", expectedVMM: "abcdef", expectedPath: "/etc/hosts"},
+		{input: "abcdef:/tmp/", expectedVMM: "abcdef", expectedPath: "/tmp/"},
+		{input: "abcdef:relative/path", expectedVMM: "abcdef", expectedPath: "relative/path"},
+		{input: "abcdef:", expectedVMM: "abcdef", expectedPath: "/"},
+		{input: "abcdef:/path:with:colons", expectedVMM: "abcdef", expectedPath: "/path:with:colons"},
+		{input: "./local/path", expectedVMM: "", expectedPath: "./local/path"},
+		{input: "/local/path", expectedVMM: "", expectedPath: "/local/path"},
+		{input: "local", expectedVMM: "", expectedPath: "local"},
+		{input: "./local:with:colons", expectedVMM: "", expectedPath: "./local:with:colons"},
+		{input: ":/path", expectedVMM: "", expectedPath: ":/path"},
+	}
+	for _, testCase := range testCases {
+		vmmID, path := splitVMMPath(testCase.input)
+		if vmmID != testCase.expectedVMM || path != testCase.expectedPath {
+			t.Fatalf("expected '%s' to split into '%s' and '%s', got '%s' and '%s'",
+				testCase.input, testCase.expectedVMM, testCase.expectedPath, vmmID, path)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package doctor
+
+import (
+	"os"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/containers"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm/cni"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+)
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks the host setup required by firebuild",
+	Run:   run,
+	Long:  ``,
+}
+
+var (
+	cniConfig     = configs.NewCNIConfig()
+	commandConfig = configs.NewDoctorCommandConfig()
+	logConfig     = configs.NewLogginConfig()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(cniConfig.FlagSet())
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("doctor")
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			rootLogger.Error("Configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	platforms := commandConfig.Platforms
+	if len(platforms) == 0 {
+		platforms = containers.SupportedPlatforms
+	}
+
+	rootLogger.Info("host platform", "platform", containers.HostPlatform())
+
+	failed := 0
+	for _, input := range platforms {
+		platform, err := containers.NormalizePlatform(input)
+		if err != nil {
+			rootLogger.Error("platform invalid", "platform", input, "reason", err)
+			failed = failed + 1
+			continue
+		}
+		requiresEmulation, _ := containers.PlatformRequiresEmulation(platform)
+		if err := containers.CheckBinfmtEmulation(platform); err != nil {
+			rootLogger.Error("platform builds not supported", "platform", platform, "emulated", requiresEmulation, "reason", err)
+			failed = failed + 1
+			continue
+		}
+		rootLogger.Info("platform builds supported", "platform", platform, "emulated", requiresEmulation)
+	}
+
+	failed = failed + checkCNI(rootLogger)
+
+	if failed > 0 {
+		rootLogger.Error("host checks failed", "failed", failed)
+		return 1
+	}
+
+	rootLogger.Info("host checks passed")
+	return 0
+}
+
+// checkCNI checks the CNI directories and networks, returns the number of failed checks.
+func checkCNI(logger hclog.Logger) int {
+	failed := 0
+	if _, err := utils.CheckIfExistsAndIsDirectory(cniConfig.BinDir); err != nil {
+		logger.Error("CNI binaries directory invalid", "cni-bin-dir", cniConfig.BinDir, "reason", err)
+		failed = failed + 1
+	}
+	if _, err := utils.CheckIfExistsAndIsDirectory(cniConfig.CacheDir); err != nil {
+		if os.IsNotExist(err) {
+			logger.Warn("CNI cache directory does not exist, created when a VMM starts", "cni-cache-dir", cniConfig.CacheDir)
+		} else {
+			logger.Error("CNI cache directory invalid", "cni-cache-dir", cniConfig.CacheDir, "reason", err)
+			failed = failed + 1
+		}
+	}
+	if _, err := utils.CheckIfExistsAndIsDirectory(cniConfig.ConfDir); err != nil {
+		logger.Error("CNI configuration directory invalid", "cni-conf-dir", cniConfig.ConfDir, "reason", err)
+		return failed + 1
+	}
+
+	networkNames := commandConfig.CNINetworkNames
+	if len(networkNames) == 0 {
+		names, err := cni.ListNetworks(cniConfig)
+		if err != nil {
+			logger.Error("CNI networks could not be listed", "reason", err)
+			return failed + 1
+		}
+		if len(names) == 0 {
+			logger.Error("no CNI networks configured", "cni-conf-dir", cniConfig.ConfDir)
+			return failed + 1
+		}
+		networkNames = names
+	}
+	for _, networkName := range networkNames {
+		if err := cni.CheckNetwork(cniConfig, networkName); err != nil {
+			logger.Error("CNI network invalid", "network", networkName, "reason", err)
+			failed = failed + 1
+			continue
+		}
+		logger.Info("CNI network valid", "network", networkName)
+	}
+	return failed
+}
@@ -0,0 +1,194 @@
+package exec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/remote"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/cobra"
+)
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "exec [flags] -- command [args...]",
+	Short: "Executes a command in a running VMM",
+	Run:   run,
+	Long:  ``,
+}
+
+var (
+	commandConfig  = configs.NewExecCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	retryConfig    = configs.NewRetryConfig()
+	runCache       = configs.NewRunCacheConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-exec")
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(retryConfig.FlagSet())
+	Command.Flags().AddFlagSet(runCache.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Dynamic completion:
+	Command.RegisterFlagCompletionFunc("vmm-id", completion.RunningVMMIDs(profilesConfig, runCache))
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, args []string) {
+	os.Exit(processCommand(args))
+}
+
+func processCommand(args []string) int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("exec")
+
+	if len(args) == 0 {
+		rootLogger.Error("command to execute is required")
+		return 1
+	}
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanExec := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("exec"))
+	spanExec.SetTag("vmm-id", commandConfig.VMMID)
+	cleanup.Add(func() {
+		spanExec.Finish()
+	})
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		retryConfig,
+		runCache,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			spanExec.SetBaggageItem("error", err.Error())
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	spanFetchMetadata := tracer.StartSpan("fetch-metadata", opentracing.ChildOf(spanExec.Context()))
+
+	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), commandConfig.VMMID))
+	if metadataErr != nil {
+		rootLogger.Error("failed loading metadata", "reason", metadataErr, "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.SetBaggageItem("error", metadataErr.Error())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.SetTag("has-metadata", hasMetadata)
+
+	if !hasMetadata {
+		rootLogger.Error("run cache directory did not contain the VMM metadata", "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.Finish()
+
+	isRunning, runningErr := vmmMetadata.PID.IsRunning()
+	if runningErr != nil || !isRunning {
+		rootLogger.Error("VMM is not running", "vmm-id", commandConfig.VMMID, "reason", runningErr)
+		return 1
+	}
+
+	if vmmMetadata.IsPaused() {
+		rootLogger.Error("VMM is paused, resume it with the resume command", "vmm-id", commandConfig.VMMID)
+		return 1
+	}
+
+	connectConfig, connectConfigErr := remote.ConnectConfigFromRunMetadata(vmmMetadata)
+	if connectConfigErr != nil {
+		rootLogger.Error("failed resolving VMM connection details", "vmm-id", commandConfig.VMMID, "reason", connectConfigErr)
+		return 1
+	}
+	connectConfig.IdentityFile = commandConfig.IdentityFile
+	connectConfig.InsecureIgnoreHostKey = commandConfig.InsecureIgnoreHostKey
+	connectConfig.KnownHostsFile = commandConfig.KnownHostsFile
+	if connectConfig.KnownHostsFile == "" {
+		// the VMM host keys are generated on the first boot and the IP addresses are reused,
+		// the keys are trusted on the first use and remembered for the lifetime of the VMM:
+		connectConfig.KnownHostsFile = filepath.Join(runCache.LocationRuns(), commandConfig.VMMID, "known_hosts")
+	}
+	connectConfig.StrictHostKeyChecking = commandConfig.StrictHostKeyChecking
+	connectConfig.Port = commandConfig.SSHPort
+	connectConfig.Timeout = commandConfig.ConnectTimeout
+	if commandConfig.SSHUser != "" {
+		connectConfig.User = commandConfig.SSHUser
+	}
+	if connectConfig.User == "" {
+		rootLogger.Error("VMM was started without SSH user, use --ssh-user", "vmm-id", commandConfig.VMMID)
+		return 1
+	}
+
+	spanConnect := tracer.StartSpan("exec-connect", opentracing.ChildOf(spanFetchMetadata.Context()))
+
+	// the command is not retried, only the connection:
+	remoteClient, connectErr := remote.ConnectWithRetry(context.Background(), connectConfig, retryConfig.Policy())
+	if connectErr != nil {
+		rootLogger.Error("failed connecting to VMM", "reason", connectErr)
+		spanConnect.SetBaggageItem("error", connectErr.Error())
+		spanConnect.Finish()
+		return 1
+	}
+
+	cleanup.Add(func() {
+		remoteClient.Close()
+	})
+
+	spanConnect.Finish()
+
+	spanRemoteExec := tracer.StartSpan("exec-remote", opentracing.ChildOf(spanConnect.Context()))
+
+	exitCode, execErr := remoteClient.Exec(utils.ShellJoin(args), os.Stdout, os.Stderr)
+	if execErr != nil {
+		rootLogger.Error("failed executing remote command", "reason", execErr)
+		spanRemoteExec.SetBaggageItem("error", execErr.Error())
+		spanRemoteExec.Finish()
+		return 1
+	}
+
+	spanRemoteExec.SetTag("exit-code", exitCode)
+	spanRemoteExec.Finish()
+
+	return exitCode
+
+}
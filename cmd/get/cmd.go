@@ -0,0 +1,179 @@
+package get
+
+import (
+	"io"
+	"os"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/containers"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/storage/resolver"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+/*
+sudo /usr/local/go/bin/go run ./main.go get \
+	--profile=standard \
+	tests/postgres:13 deploy.json
+*/
+
+// Command is the get command declaration.
+var Command = &cobra.Command{
+	Use:   "get <tag> <name>",
+	Short: "Writes a file attached to a rootfs",
+	Args:  cobra.ExactArgs(2),
+	Run:   run,
+	Long: `Writes the contents of the file attached to the rootfs with rootfs --attach name=path
+to stdout or to the --output file. The attachment of the rootfs of the host architecture is written.`,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completion.RootfsTags(profilesConfig, storageResolver)(cmd, args, toComplete)
+	},
+}
+
+var (
+	commandConfig  = configs.NewGetCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-get")
+
+	storageResolver = resolver.NewDefaultResolver()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Storage provider flags:
+	resolver.AddStorageFlags(Command.Flags())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, args []string) {
+	os.Exit(processCommand(args))
+}
+
+func processCommand(args []string) int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("get")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+		storageResolver.
+			WithConfigurationOverride(profile.GetMergedStorageConfig()).
+			WithTypeOverride(profile.Profile().StorageProvider)
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanGet := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("get"))
+	cleanup.Add(func() {
+		spanGet.Finish()
+	})
+
+	if err := commandConfig.ParseArgs(args); err != nil {
+		spanGet.SetBaggageItem("error", err.Error())
+		rootLogger.Error("arguments are invalid", "reason", err)
+		return 1
+	}
+
+	spanGet.SetTag("tag", commandConfig.Tag)
+	spanGet.SetTag("name", commandConfig.Name)
+
+	if err := commandConfig.Validate(); err != nil {
+		rootLogger.Error("configuration is invalid", "reason", err)
+		spanGet.SetBaggageItem("error", err.Error())
+		return 1
+	}
+
+	storageImpl, resolveErr := storageResolver.GetStorageImpl(rootLogger)
+	if resolveErr != nil {
+		rootLogger.Error("failed resolving storage provider", "reason", resolveErr)
+		spanGet.SetBaggageItem("error", resolveErr.Error())
+		return 1
+	}
+
+	arch, archErr := containers.PlatformArchitecture(commandConfig.Platform)
+	if archErr != nil {
+		rootLogger.Error("failed resolving platform architecture", "reason", archErr)
+		spanGet.SetBaggageItem("error", archErr.Error())
+		return 1
+	}
+
+	_, org, image, version := utils.ReferenceDecompose(commandConfig.Tag, storage.LatestVersion)
+	// the latest version and the version constraint resolve to a stored version:
+	rootfsLookup, lookupErr := storage.ResolveRootfsLookup(storageImpl, &storage.RootfsLookup{
+		Org:     org,
+		Image:   image,
+		Version: version,
+		Arch:    arch,
+	})
+	if lookupErr != nil {
+		rootLogger.Error("failed resolving rootfs version", "reason", lookupErr, "tag", commandConfig.Tag)
+		spanGet.SetBaggageItem("error", lookupErr.Error())
+		return 1
+	}
+
+	var writer io.Writer = os.Stdout
+	if commandConfig.Output != "" {
+		outputFile, err := os.Create(commandConfig.Output)
+		if err != nil {
+			rootLogger.Error("failed creating output file", "reason", err, "output", commandConfig.Output)
+			spanGet.SetBaggageItem("error", err.Error())
+			return 1
+		}
+		cleanup.Add(func() {
+			outputFile.Close()
+		})
+		writer = outputFile
+	}
+
+	fetchErr := storageImpl.FetchRootfsAttachment(rootfsLookup, commandConfig.Name, writer)
+	if fetchErr != nil {
+		if errors.Is(fetchErr, storage.ErrAttachmentNotFound) {
+			rootLogger.Error("rootfs does not have the attachment", "tag", commandConfig.Tag, "name", commandConfig.Name)
+		} else {
+			rootLogger.Error("failed fetching attachment", "reason", fetchErr, "tag", commandConfig.Tag, "name", commandConfig.Name)
+		}
+		spanGet.SetBaggageItem("error", fetchErr.Error())
+		if commandConfig.Output != "" {
+			os.Remove(commandConfig.Output)
+		}
+		return 1
+	}
+
+	if commandConfig.Output != "" {
+		rootLogger.Info("attachment written", "tag", commandConfig.Tag, "name", commandConfig.Name, "output", commandConfig.Output)
+	}
+
+	return 0
+
+}
@@ -1,16 +1,22 @@
 package inspect
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/format"
+	"github.com/combust-labs/firebuild/pkg/metadata"
 	"github.com/combust-labs/firebuild/pkg/profiles"
 	"github.com/combust-labs/firebuild/pkg/tracing"
 	"github.com/combust-labs/firebuild/pkg/utils"
 	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/hashicorp/go-hclog"
 	"github.com/opentracing/opentracing-go"
 	"github.com/spf13/cobra"
 )
@@ -31,12 +37,22 @@ var (
 	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-inspect")
 )
 
+// inspectResult extends the run metadata with the live VMM details.
+type inspectResult struct {
+	*metadata.MDRun
+	BalloonStats *vmm.BalloonStats `json:"BalloonStats,omitempty"`
+	// State replaces the recorded run state, dead if the VMM process is gone.
+	State string `json:"State"`
+}
+
 func initFlags() {
 	Command.Flags().AddFlagSet(commandConfig.FlagSet())
 	Command.Flags().AddFlagSet(logConfig.FlagSet())
 	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
 	Command.Flags().AddFlagSet(runCache.FlagSet())
 	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Dynamic completion:
+	Command.RegisterFlagCompletionFunc("vmm-id", completion.RunningVMMIDs(profilesConfig, runCache))
 }
 
 func init() {
@@ -85,6 +101,7 @@ func processCommand() int {
 	})
 
 	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
 		runCache,
 	}
 
@@ -98,27 +115,76 @@ func processCommand() int {
 
 	spanFetchMetadata := tracer.StartSpan("fetch-metadata", opentracing.ChildOf(spanInspect.Context()))
 
-	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), commandConfig.VMMID))
-	if metadataErr != nil {
-		rootLogger.Error("failed loading metadata", "reason", metadataErr, "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
-		spanFetchMetadata.SetBaggageItem("error", metadataErr.Error())
-		spanFetchMetadata.Finish()
-		return 1
-	}
+	vmmMetadatas := []*metadata.MDRun{}
 
-	spanFetchMetadata.SetTag("has-metadata", hasMetadata)
+	if commandConfig.VMMID != "" {
+		vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), commandConfig.VMMID))
+		if metadataErr != nil {
+			rootLogger.Error("failed loading metadata", "reason", metadataErr, "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+			spanFetchMetadata.SetBaggageItem("error", metadataErr.Error())
+			spanFetchMetadata.Finish()
+			return 1
+		}
 
-	if !hasMetadata {
-		rootLogger.Error("run cache directory did not contain the VMM metadata", "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
-		spanFetchMetadata.Finish()
-		return 1
+		spanFetchMetadata.SetTag("has-metadata", hasMetadata)
+
+		if !hasMetadata {
+			rootLogger.Error("run cache directory did not contain the VMM metadata", "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+			spanFetchMetadata.Finish()
+			return 1
+		}
+
+		vmmMetadatas = append(vmmMetadatas, vmmMetadata)
+	} else {
+		labeledMetadatas, metadataErr := labeledVMMs(commandConfig.Labels)
+		if metadataErr != nil {
+			rootLogger.Error("failed listing run cache directory", "reason", metadataErr, "run-cache", runCache.LocationRuns())
+			spanFetchMetadata.SetBaggageItem("error", metadataErr.Error())
+			spanFetchMetadata.Finish()
+			return 1
+		}
+		spanFetchMetadata.SetTag("vmm-count", len(labeledMetadatas))
+		vmmMetadatas = append(vmmMetadatas, labeledMetadatas...)
 	}
 
 	spanFetchMetadata.Finish()
 
+	inspectResults := []*inspectResult{}
+	for _, vmmMetadata := range vmmMetadatas {
+		inspectResults = append(inspectResults, inspectVMM(rootLogger, tracer, spanFetchMetadata.Context(), vmmMetadata))
+	}
+
 	spanMarshalMetadata := tracer.StartSpan("marshal-metadata", opentracing.ChildOf(spanFetchMetadata.Context()))
 
-	bytes, jsonErr := json.MarshalIndent(vmmMetadata, "", "  ")
+	if commandConfig.Format != "" {
+		tmpl, parseErr := format.Parse(commandConfig.Format)
+		if parseErr != nil {
+			rootLogger.Error("--format is invalid", "reason", parseErr)
+			spanMarshalMetadata.SetBaggageItem("error", parseErr.Error())
+			spanMarshalMetadata.Finish()
+			return 1
+		}
+		// with --label, the template is executed for every VMM:
+		for _, result := range inspectResults {
+			output, executeErr := format.Execute(tmpl, result)
+			if executeErr != nil {
+				rootLogger.Error("failed formatting VMM metadata", "vmm-id", result.VMMID, "reason", executeErr)
+				spanMarshalMetadata.SetBaggageItem("error", executeErr.Error())
+				spanMarshalMetadata.Finish()
+				return 1
+			}
+			fmt.Println(output)
+		}
+		spanMarshalMetadata.Finish()
+		return 0
+	}
+
+	var output interface{} = inspectResults
+	if commandConfig.VMMID != "" {
+		output = inspectResults[0]
+	}
+
+	bytes, jsonErr := json.MarshalIndent(output, "", "  ")
 	if jsonErr != nil {
 		rootLogger.Error("failed serializing VMM metadata to JSON", "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns(), "reason", jsonErr)
 		spanFetchMetadata.SetBaggageItem("error", jsonErr.Error())
@@ -133,3 +199,51 @@ func processCommand() int {
 	return 0
 
 }
+
+// inspectVMM extends the VMM metadata with the balloon statistics of a running VMM.
+func inspectVMM(logger hclog.Logger, tracer opentracing.Tracer, parent opentracing.SpanContext, vmmMetadata *metadata.MDRun) *inspectResult {
+	alive, err := vmm.IsAlive(vmmMetadata)
+	if err != nil {
+		logger.Warn("failed checking if the VMM is alive", "vmm-id", vmmMetadata.VMMID, "reason", err)
+	}
+	result := &inspectResult{MDRun: vmmMetadata, State: vmm.State(vmmMetadata, alive)}
+	if vmmMetadata.Configs.Machine == nil || !vmmMetadata.Configs.Machine.Balloon {
+		return result
+	}
+	if !alive {
+		return result
+	}
+	spanBalloonStats := tracer.StartSpan("balloon-stats", opentracing.ChildOf(parent))
+	defer spanBalloonStats.Finish()
+	vmmProvider := vmm.NewDefaultProvider(vmmMetadata.Configs.CNI, vmmMetadata.Configs.Jailer, vmmMetadata.Configs.Machine)
+	stats, statsErr := vmmProvider.BalloonStats(context.Background(), vmmMetadata.VMMID)
+	if statsErr != nil {
+		// stats are not available when the stats polling interval is not set:
+		logger.Warn("failed fetching balloon stats", "vmm-id", vmmMetadata.VMMID, "reason", statsErr)
+		spanBalloonStats.SetBaggageItem("error", statsErr.Error())
+	}
+	result.BalloonStats = stats
+	return result
+}
+
+// labeledVMMs returns the metadata of the VMMs in the run cache matching the label filters.
+func labeledVMMs(filters []string) ([]*metadata.MDRun, error) {
+	result := []*metadata.MDRun{}
+	fileInfos, readDirErr := ioutil.ReadDir(runCache.LocationRuns())
+	if readDirErr != nil {
+		if os.IsNotExist(readDirErr) {
+			return result, nil
+		}
+		return nil, readDirErr
+	}
+	for _, fileInfo := range fileInfos {
+		vmmMetadata, hasMetadata, err := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), fileInfo.Name()))
+		if err != nil || !hasMetadata {
+			continue
+		}
+		if utils.MatchesLabelFilters(vmmMetadata.Labels, filters) {
+			result = append(result, vmmMetadata)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,59 @@
+// Package subcommand runs the firebuild commands as child processes of the current command,
+// like the bench and selftest commands do.
+package subcommand
+
+import (
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/pkg/errors"
+)
+
+// Runner runs the firebuild subcommands with the executable and the selected profile.
+type Runner struct {
+	Executable string
+	Profile    *configs.ProfileCommandConfig
+}
+
+// NewRunner returns a runner for the executable, the profile is passed to every subcommand, if selected.
+func NewRunner(executable string, profile *configs.ProfileCommandConfig) *Runner {
+	return &Runner{Executable: executable, Profile: profile}
+}
+
+// Run executes the firebuild subcommand, the stdout is written to the writer, if given.
+// Otherwise, the output goes to stderr so the result of the parent command is the only stdout output.
+func (r *Runner) Run(subcommand string, stdout io.Writer, args ...string) error {
+	commandArgs := []string{subcommand}
+	if r.Profile != nil && r.Profile.Profile != "" {
+		commandArgs = append(commandArgs, "--profile="+r.Profile.Profile, "--profile-conf-dir="+r.Profile.ProfileConfDir)
+	}
+	cmd := exec.Command(r.Executable, append(commandArgs, args...)...)
+	cmd.Stdout = os.Stderr
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "%s command failed", subcommand)
+	}
+	return nil
+}
+
+// StartedVMMID returns the ID of the VMM started by the run command from the recorded run span.
+// Returns an empty string if the spans can't be read or the run command did not start a VMM.
+func StartedVMMID(spansFile string) string {
+	spans, err := tracing.ReadSpansFile(spansFile)
+	if err != nil {
+		return ""
+	}
+	vmmID := ""
+	for _, span := range spans {
+		if span.Operation == "run" {
+			vmmID = span.Tags["vmm-id"]
+		}
+	}
+	return vmmID
+}
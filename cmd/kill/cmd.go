@@ -2,12 +2,19 @@ package kill
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
 	"github.com/combust-labs/firebuild/pkg/fw"
+	"github.com/combust-labs/firebuild/pkg/metadata"
 	"github.com/combust-labs/firebuild/pkg/profiles"
 	"github.com/combust-labs/firebuild/pkg/tracing"
 	"github.com/combust-labs/firebuild/pkg/utils"
@@ -16,6 +23,7 @@ import (
 	"github.com/combust-labs/firebuild/pkg/vmm/cni"
 	"github.com/firecracker-microvm/firecracker-go-sdk"
 	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/hashicorp/go-hclog"
 	"github.com/opentracing/opentracing-go"
 	"github.com/spf13/cobra"
 )
@@ -25,9 +33,14 @@ var Command = &cobra.Command{
 	Use:   "kill",
 	Short: "Kills a running VMM",
 	Run:   run,
-	Long:  ``,
+	Long: `Stops the VMM given with --vmm-id and removes its network, run cache and jailer directory.
+With --all, or a --vmm-id glob pattern, all matching VMMs in the run cache are killed concurrently.
+A VMM not shut down within --shutdown-timeout is killed.`,
 }
 
+// forceStopTimeout is how long to wait for the killed VMM process to exit.
+const forceStopTimeout = time.Second * 5
+
 var (
 	commandConfig  = configs.NewKillCommandConfig()
 	logConfig      = configs.NewLogginConfig()
@@ -42,6 +55,8 @@ func initFlags() {
 	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
 	Command.Flags().AddFlagSet(runCache.FlagSet())
 	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Dynamic completion:
+	Command.RegisterFlagCompletionFunc("vmm-id", completion.RunningVMMIDs(profilesConfig, runCache))
 }
 
 func init() {
@@ -86,6 +101,7 @@ func processCommand() int {
 	})
 
 	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
 		runCache,
 	}
 
@@ -97,6 +113,10 @@ func processCommand() int {
 		}
 	}
 
+	if commandConfig.IsBatch() {
+		return killBatch(rootLogger, tracer, spanKill)
+	}
+
 	spanFetchMetadata := tracer.StartSpan("fetch-metadata", opentracing.ChildOf(spanKill.Context()))
 
 	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), commandConfig.VMMID))
@@ -117,58 +137,160 @@ func processCommand() int {
 
 	spanFetchMetadata.Finish()
 
-	spanInspectChroot := tracer.StartSpan("vmm-inspect-chroot", opentracing.ChildOf(spanFetchMetadata.Context()))
-	spanInspectChroot.SetTag("vmm-id", vmmMetadata.VMMID)
+	if err := killVMM(rootLogger, tracer, spanFetchMetadata, vmmMetadata); err != nil {
+		return 1
+	}
 
-	chrootInst := chroot.NewWithLocation(chroot.LocationFromComponents(vmmMetadata.Configs.Jailer.ChrootBase,
-		vmmMetadata.Configs.Jailer.BinaryFirecracker,
-		vmmMetadata.VMMID))
+	return 0
+}
 
-	chrootExists, chrootErr := chrootInst.Exists()
-	if chrootErr != nil {
-		rootLogger.Error("error while checking VMM chroot", "reason", chrootErr)
-		spanInspectChroot.SetBaggageItem("chroot-fetch-error", chrootErr.Error())
-		spanInspectChroot.Finish()
+// killBatch kills the VMMs selected with --all or the --vmm-id pattern, at most --parallelism
+// at the same time, and reports the summary.
+func killBatch(logger hclog.Logger, tracer opentracing.Tracer, spanKill opentracing.Span) int {
+
+	fileInfos, readDirErr := ioutil.ReadDir(runCache.LocationRuns())
+	if readDirErr != nil {
+		logger.Error("error listing run cache directory", "reason", readDirErr)
+		spanKill.SetBaggageItem("error", readDirErr.Error())
 		return 1
 	}
 
-	spanInspectChroot.SetTag("chroot-existed", chrootExists)
+	failed := []string{}
+	targets := []*metadata.MDRun{}
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() || !commandConfig.Matches(fileInfo.Name()) {
+			continue
+		}
+		vmmMetadata, hasMetadata, err := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), fileInfo.Name()))
+		if err != nil {
+			logger.Error("metadata error for cache entry", "fs-entry", fileInfo.Name(), "reason", err)
+			failed = append(failed, fileInfo.Name())
+			continue
+		}
+		if !hasMetadata {
+			logger.Debug("skipping cache entry without metadata", "fs-entry", fileInfo.Name())
+			continue
+		}
+		targets = append(targets, vmmMetadata)
+	}
 
-	if !chrootExists {
-		rootLogger.Error("VMM not found, nothing to do")
-		spanInspectChroot.Finish()
-		return 0
+	spanKill.SetTag("matched", len(targets))
+
+	var (
+		lock      sync.Mutex
+		wg        sync.WaitGroup
+		killed    int
+		semaphore = make(chan struct{}, commandConfig.Parallelism)
+	)
+	for _, vmmMetadata := range targets {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(vmmMetadata *metadata.MDRun) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			err := killVMM(logger.With("vmm-id", vmmMetadata.VMMID), tracer, spanKill, vmmMetadata)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				failed = append(failed, vmmMetadata.VMMID)
+				return
+			}
+			killed = killed + 1
+		}(vmmMetadata)
 	}
+	wg.Wait()
 
-	if err := chrootInst.IsValid(); err != nil {
-		rootLogger.Error("error while inspecting chroot", "reason", err, "chroot", chrootInst.FullPath())
-		spanInspectChroot.SetBaggageItem("chroot-invalid-error", err.Error())
-		spanInspectChroot.Finish()
+	sort.Strings(failed)
+	spanKill.SetTag("killed", killed)
+	spanKill.SetTag("failed", len(failed))
+
+	if len(failed) > 0 {
+		logger.Error("kill finished with failures", "killed", killed, "failed", len(failed), "failed-vmm-ids", strings.Join(failed, ","))
 		return 1
 	}
+	logger.Info("kill finished", "killed", killed)
+	return 0
+}
 
-	// Do I have the socket file?
-	socketPath, hasSocket, existsErr := chrootInst.SocketPathIfExists()
-	if existsErr != nil {
-		rootLogger.Error("failed checking if the VMM socket file exists", "reason", existsErr)
-		spanInspectChroot.SetBaggageItem("chroot-socket-error", existsErr.Error())
-		spanInspectChroot.Finish()
-		return 1
+// killVMM stops the VMM, gracefully within the shutdown timeout, then forcefully,
+// and cleans up the CNI, the IPT rules, the egress policy, the run cache and the jailer directory.
+// The VMM without the chroot or the API socket is stopped by killing the VMM process, the cleanup runs regardless.
+func killVMM(logger hclog.Logger, tracer opentracing.Tracer, parentSpan opentracing.Span, vmmMetadata *metadata.MDRun) error {
+
+	if vmmMetadata.Configs.Jailer == nil || vmmMetadata.Configs.CNI == nil {
+		err := fmt.Errorf("the VMM metadata has no jailer or CNI configuration")
+		logger.Error("VMM metadata incomplete", "reason", err)
+		return err
 	}
 
+	spanInspectChroot := tracer.StartSpan("vmm-inspect-chroot", opentracing.ChildOf(parentSpan.Context()))
+	spanInspectChroot.SetTag("vmm-id", vmmMetadata.VMMID)
+
+	chrootInst := chroot.NewWithLocation(chroot.LocationFromComponents(vmmMetadata.Configs.Jailer.ChrootBase,
+		vmmMetadata.Configs.Jailer.BinaryFirecracker,
+		vmmMetadata.VMMID))
+
+	// only the stop depends on the chroot, the network and the run cache are always cleaned up:
+	var (
+		socketPath string
+		hasSocket  bool
+	)
+	chrootExists, chrootErr := chrootInst.Exists()
+	switch {
+	case chrootErr != nil:
+		logger.Warn("error while checking VMM chroot, stopping the VMM process", "reason", chrootErr)
+		spanInspectChroot.SetBaggageItem("chroot-fetch-error", chrootErr.Error())
+	case !chrootExists:
+		logger.Warn("VMM chroot not found, stopping the VMM process", "chroot", chrootInst.FullPath())
+	default:
+		if err := chrootInst.IsValid(); err != nil {
+			logger.Warn("error while inspecting chroot, stopping the VMM process", "reason", err, "chroot", chrootInst.FullPath())
+			spanInspectChroot.SetBaggageItem("chroot-invalid-error", err.Error())
+			break
+		}
+		var existsErr error
+		socketPath, hasSocket, existsErr = chrootInst.SocketPathIfExists()
+		if existsErr != nil {
+			logger.Warn("failed checking if the VMM socket file exists, stopping the VMM process", "reason", existsErr)
+			spanInspectChroot.SetBaggageItem("chroot-socket-error", existsErr.Error())
+		}
+	}
+
+	spanInspectChroot.SetTag("chroot-existed", chrootExists)
 	spanInspectChroot.SetTag("has-socket", hasSocket)
 	spanInspectChroot.Finish()
 
+	if !hasSocket {
+		// without the API socket, the VMM can't be stopped gracefully:
+		spanVMMStop := tracer.StartSpan("vmm-stop", opentracing.ChildOf(spanInspectChroot.Context()))
+		spanVMMStop.SetTag("vmm-id", vmmMetadata.VMMID)
+		if err := forceStop(vmmMetadata); err != nil {
+			logger.Error("failed killing the VMM process", "reason", err)
+			spanVMMStop.SetBaggageItem("kill-error", err.Error())
+			spanVMMStop.Finish()
+			return err
+		}
+		spanVMMStop.Finish()
+	}
+
 	if hasSocket {
 
 		spanVMMStop := tracer.StartSpan("vmm-stop", opentracing.ChildOf(spanInspectChroot.Context()))
 		spanVMMStop.SetTag("vmm-id", vmmMetadata.VMMID)
 
-		rootLogger.Info("stopping VMM")
+		logger.Info("stopping VMM")
 
 		spanVMMStopCall := tracer.StartSpan("vmm-stop-call", opentracing.ChildOf(spanInspectChroot.Context()))
 		spanVMMStopCall.SetTag("vmm-id", vmmMetadata.VMMID)
 
+		// the paused VMM does not handle the CtrlAltDel until resumed:
+		if vmmMetadata.IsPaused() {
+			vmmProvider := vmm.NewDefaultProvider(vmmMetadata.Configs.CNI, vmmMetadata.Configs.Jailer, vmmMetadata.Configs.Machine)
+			if err := vmmProvider.Resume(context.Background(), vmmMetadata.VMMID); err != nil {
+				logger.Warn("failed resuming the paused VMM before stopping", "reason", err)
+			}
+		}
+
 		fcClient := firecracker.NewClient(socketPath, nil, false)
 		ok, actionErr := fcClient.CreateSyncAction(context.Background(), &models.InstanceActionInfo{
 			ActionType: firecracker.String("SendCtrlAltDel"),
@@ -178,18 +300,24 @@ func processCommand() int {
 
 		if actionErr != nil {
 			if !strings.Contains(actionErr.Error(), "connect: connection refused") {
-				rootLogger.Error("failed sending CtrlAltDel to the VMM", "reason", actionErr)
+				logger.Error("failed sending CtrlAltDel to the VMM, killing the VMM process", "reason", actionErr)
 				spanVMMStop.SetBaggageItem("error", actionErr.Error())
-				spanVMMStop.Finish()
-				return 1
+				if err := forceStop(vmmMetadata); err != nil {
+					logger.Error("failed killing the VMM process", "reason", err)
+					spanVMMStop.SetBaggageItem("kill-error", err.Error())
+					spanVMMStop.Finish()
+					return err
+				}
+				logger.Info("VMM process killed")
+			} else {
+				logger.Info("VMM is already stopped")
 			}
-			rootLogger.Info("VMM is already stopped")
 		} else {
 
 			spanVMMStopWait := tracer.StartSpan("vmm-stop-wait", opentracing.ChildOf(spanVMMStopCall.Context()))
 			spanVMMStopWait.SetTag("vmm-id", vmmMetadata.VMMID)
 
-			rootLogger.Info("VMM with pid, waiting for process to exit")
+			logger.Info("VMM with pid, waiting for process to exit")
 
 			waitCtx, cancelFunc := context.WithTimeout(context.Background(), commandConfig.ShutdownTimeout)
 			defer cancelFunc()
@@ -205,21 +333,29 @@ func processCommand() int {
 					spanVMMStopWait.SetBaggageItem("wait-error", waitCtx.Err().Error())
 				}
 				spanVMMStopWait.SetTag("clean-exit", false)
-				rootLogger.Error("VMM shutdown wait timed out, unclean shutdown", "reason", waitCtx.Err())
+				logger.Error("VMM shutdown wait timed out, unclean shutdown, killing the VMM process", "reason", waitCtx.Err())
+				if err := forceStop(vmmMetadata); err != nil {
+					logger.Error("failed killing the VMM process", "reason", err)
+					spanVMMStopWait.SetBaggageItem("kill-error", err.Error())
+					spanVMMStopWait.Finish()
+					spanVMMStop.Finish()
+					return err
+				}
+				logger.Info("VMM process killed")
 			case err := <-chanErr:
 				if err != nil {
 					spanVMMStopWait.SetBaggageItem("wait-error", err.Error())
 					spanVMMStopWait.SetTag("clean-exit", false)
-					rootLogger.Error("VMM process exit with an error", "reason", err)
+					logger.Error("VMM process exit with an error", "reason", err)
 				} else {
 					spanVMMStopWait.SetTag("clean-exit", true)
-					rootLogger.Info("VMM process exit clean")
+					logger.Info("VMM process exit clean")
 				}
 			}
 
 			spanVMMStopWait.Finish()
 
-			rootLogger.Info("VMM stopped with response", "response", ok)
+			logger.Info("VMM stopped with response", "response", ok)
 		}
 
 		spanVMMStop.Finish()
@@ -228,17 +364,17 @@ func processCommand() int {
 	spanKillCNI := tracer.StartSpan("vmm-kill-cni", opentracing.ChildOf(spanInspectChroot.Context()))
 	spanKillCNI.SetTag("vmm-id", vmmMetadata.VMMID)
 
-	rootLogger.Info("cleaning up CNI")
-	if err := cni.CleanupCNI(rootLogger,
+	logger.Info("cleaning up CNI")
+	if err := cni.CleanupCNI(logger,
 		vmmMetadata.Configs.CNI,
-		commandConfig.VMMID, vmmMetadata.CNI.VethName,
+		vmmMetadata.VMMID, vmmMetadata.CNI.VethName,
 		vmmMetadata.CNI.NetName, vmmMetadata.CNI.NetNS); err != nil {
-		rootLogger.Error("failed cleaning up CNI", "reason", err)
+		logger.Error("failed cleaning up CNI", "reason", err)
 		spanKillCNI.SetBaggageItem("error", err.Error())
 		spanKillCNI.Finish()
-		return 1
+		return err
 	}
-	rootLogger.Info("CNI cleaned up")
+	logger.Info("CNI cleaned up")
 
 	spanKillCNI.Finish()
 
@@ -247,41 +383,47 @@ func processCommand() int {
 
 	if len(vmmMetadata.Configs.RunConfig.Ports) > 0 {
 		if len(vmmMetadata.NetworkInterfaces) > 0 {
-			rootLogger.Info("cleaning up IPT")
-			mgr, err := fw.NewManager(vmmMetadata.VMMID, vmmMetadata.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IP)
+			logger.Info("cleaning up IPT")
+			mgr, err := fw.NewManager(vmmMetadata.VMMID, vmmMetadata.PublishAddresses()...)
 			if err != nil {
-				rootLogger.Warn("cleaning up IPT failed", "reason", err)
+				logger.Warn("cleaning up IPT failed", "reason", err)
 			} else {
 				ports := []fw.ExposedPort{}
 				for _, port := range vmmMetadata.Configs.RunConfig.Ports {
 					parsedPort, parseErr := fw.ExposedPortFromString(port)
 					if parseErr != nil {
-						rootLogger.Warn("IP cleanup: port failed to parse", "reason", parseErr, "raw-input", port)
+						logger.Warn("IP cleanup: port failed to parse", "reason", parseErr, "raw-input", port)
 					} else {
 						ports = append(ports, parsedPort)
 					}
 				}
 				if err := mgr.Unpublish(ports); err != nil {
-					rootLogger.Warn("cleaning up IPT failed", "reason", err)
+					logger.Warn("cleaning up IPT failed", "reason", err)
 				}
 			}
-			rootLogger.Info("IPT cleaned up")
+			logger.Info("IPT cleaned up")
 		}
 	}
 
+	if vmmMetadata.Egress != nil {
+		logger.Info("removing egress policy")
+		vmm.RemoveEgressPolicy(logger, vmmMetadata)
+		logger.Info("egress policy removed")
+	}
+
 	spanKillIPT.Finish()
 
 	spanKillCache := tracer.StartSpan("vmm-kill-cache", opentracing.ChildOf(spanKillIPT.Context()))
 	spanKillCache.SetTag("vmm-id", vmmMetadata.VMMID)
 
 	// have to clean up the cache
-	rootLogger.Info("removing the cache directory")
-	cacheDirectory := filepath.Join(runCache.LocationRuns(), commandConfig.VMMID)
+	logger.Info("removing the cache directory")
+	cacheDirectory := filepath.Join(runCache.LocationRuns(), vmmMetadata.VMMID)
 	if err := os.RemoveAll(cacheDirectory); err != nil {
-		rootLogger.Error("failed removing cache directroy", "reason", err, "path", cacheDirectory)
+		logger.Error("failed removing cache directroy", "reason", err, "path", cacheDirectory)
 		spanKillCache.SetBaggageItem("error", err.Error())
 	}
-	rootLogger.Info("cache directory removed")
+	logger.Info("cache directory removed")
 
 	spanKillCache.Finish()
 
@@ -289,14 +431,30 @@ func processCommand() int {
 	spanKillChroot.SetTag("vmm-id", vmmMetadata.VMMID)
 
 	// have to clean up the jailer
-	rootLogger.Info("removing the jailer directory")
-	if err := chrootInst.RemoveAll(); err != nil {
-		rootLogger.Error("failed removing jailer directroy", "reason", err, "path", chrootInst.FullPath())
-		spanKillChroot.SetBaggageItem("error", err.Error())
+	if chrootExists {
+		logger.Info("removing the jailer directory")
+		if err := chrootInst.RemoveAll(); err != nil {
+			logger.Error("failed removing jailer directroy", "reason", err, "path", chrootInst.FullPath())
+			spanKillChroot.SetBaggageItem("error", err.Error())
+		}
+		logger.Info("jailer directory removed")
 	}
-	rootLogger.Info("jailer directory removed")
 
 	spanKillChroot.Finish()
 
-	return 0
+	return nil
+}
+
+// forceStop kills the VMM process and waits for it to exit.
+// A PID reused by an unrelated process is never killed.
+func forceStop(vmmMetadata *metadata.MDRun) error {
+	if alive, err := vmm.IsAlive(vmmMetadata); err == nil && !alive {
+		return nil
+	}
+	if err := vmmMetadata.PID.Kill(); err != nil {
+		return err
+	}
+	waitCtx, cancelFunc := context.WithTimeout(context.Background(), forceStopTimeout)
+	defer cancelFunc()
+	return vmmMetadata.PID.Wait(waitCtx)
 }
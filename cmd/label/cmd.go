@@ -0,0 +1,145 @@
+package label
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/spf13/cobra"
+)
+
+// Command is the label command declaration.
+var Command = &cobra.Command{
+	Use:   "label <vmm-id> [key=value...]",
+	Short: "Adds, updates or removes the labels of a VMM",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   run,
+	Long: `Adds or updates the key=value labels of a VMM in the run cache metadata.
+The labels given with --remove are removed. The VMMs can be selected by the labels with ls --label and inspect --label.`,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completion.RunningVMMIDs(profilesConfig, runCache)(cmd, args, toComplete)
+	},
+}
+
+var (
+	commandConfig  = configs.NewLabelCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	runCache       = configs.NewRunCacheConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-label")
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(runCache.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, args []string) {
+	os.Exit(processCommand(args))
+}
+
+func processCommand(args []string) int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("label")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanLabel := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("label"))
+	cleanup.Add(func() {
+		spanLabel.Finish()
+	})
+
+	if err := commandConfig.ParseArgs(args); err != nil {
+		spanLabel.SetBaggageItem("error", err.Error())
+		rootLogger.Error("arguments are invalid", "reason", err)
+		return 1
+	}
+
+	spanLabel.SetTag("vmm-id", commandConfig.VMMID)
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		runCache,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			spanLabel.SetBaggageItem("error", err.Error())
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), commandConfig.VMMID))
+	if metadataErr != nil {
+		rootLogger.Error("failed loading metadata", "reason", metadataErr, "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanLabel.SetBaggageItem("error", metadataErr.Error())
+		return 1
+	}
+	if !hasMetadata {
+		rootLogger.Error("run cache directory did not contain the VMM metadata", "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		return 1
+	}
+
+	if vmmMetadata.Labels == nil {
+		vmmMetadata.Labels = map[string]string{}
+	}
+	for key, value := range commandConfig.Labels {
+		vmmMetadata.Labels[key] = value
+	}
+	for _, key := range commandConfig.Remove {
+		delete(vmmMetadata.Labels, key)
+	}
+
+	if err := vmm.WriteMetadataToFile(vmmMetadata); err != nil {
+		rootLogger.Error("failed writing VMM metadata", "reason", err, "vmm-id", commandConfig.VMMID)
+		spanLabel.SetBaggageItem("error", err.Error())
+		return 1
+	}
+
+	keys := []string{}
+	for key := range vmmMetadata.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rootLogger.Info("labels updated", "vmm-id", commandConfig.VMMID, "labels", keys)
+
+	return 0
+}
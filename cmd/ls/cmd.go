@@ -1,6 +1,7 @@
 package ls
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -25,6 +26,7 @@ var Command = &cobra.Command{
 }
 
 var (
+	commandConfig  = configs.NewLsCommandConfig()
 	logConfig      = configs.NewLogginConfig()
 	profilesConfig = configs.NewProfileCommandConfig()
 	runCache       = configs.NewRunCacheConfig()
@@ -32,6 +34,7 @@ var (
 )
 
 func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
 	Command.Flags().AddFlagSet(logConfig.FlagSet())
 	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
 	Command.Flags().AddFlagSet(runCache.FlagSet())
@@ -78,8 +81,15 @@ func processCommand() int {
 		spanLs.Finish()
 	})
 
+	if err := commandConfig.Validate(); err != nil {
+		rootLogger.Error("configuration is invalid", "reason", err)
+		spanLs.SetBaggageItem("error", err.Error())
+		return 1
+	}
+
 	itemsWithMetadata := 0
 	itemsWithoutMetadata := 0
+	listed := []*vmm.ListedVMM{}
 
 	fileInfos, readDirErr := ioutil.ReadDir(runCache.LocationRuns())
 	if readDirErr != nil {
@@ -104,12 +114,21 @@ func processCommand() int {
 		spanVMM.SetTag("has-metadata", hasMetadata)
 		spanVMM.Finish()
 
+		if len(commandConfig.Labels) > 0 && (!hasMetadata || !utils.MatchesLabelFilters(vmmMetadata.Labels, commandConfig.Labels)) {
+			continue
+		}
+
+		if len(commandConfig.Filters) > 0 && (!hasMetadata || vmmMetadata.Rootfs == nil ||
+			!utils.MatchesImageFilters(vmmMetadata.Rootfs.Image.Org, vmmMetadata.Rootfs.Image.Image, vmmMetadata.Rootfs.Labels, commandConfig.Filters)) {
+			continue
+		}
+
 		if hasMetadata {
 
 			spanVMMPID := tracer.StartSpan("vmm-pid-check", opentracing.ChildOf(spanVMM.Context()))
 
 			itemsWithMetadata = itemsWithMetadata + 1
-			running, err := vmmMetadata.PID.IsRunning()
+			running, err := vmm.IsAlive(vmmMetadata)
 			if err != nil {
 				rootLogger.Error("failed checking pid status for possible VMM", "vmm-id", vmmID, "reason", err)
 				spanVMMPID.SetBaggageItem("error", err.Error())
@@ -117,23 +136,73 @@ func processCommand() int {
 				continue
 			}
 
-			rootLogger.Info("vmm", "id", vmmID,
-				"running", running,
-				"pid", vmmMetadata.PID.Pid,
-				"image", fmt.Sprintf("%s/%s:%s", vmmMetadata.Rootfs.Image.Org, vmmMetadata.Rootfs.Image.Image, vmmMetadata.Rootfs.Image.Version),
-				"started", time.Unix(vmmMetadata.StartedAtUTC, 0).UTC().String(),
-				"ip-address", vmmMetadata.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IP)
-
 			spanVMMPID.SetTag("is-running", running)
 			spanVMMPID.Finish()
 
+			if !running && commandConfig.PruneDead {
+				vmm.PurgeStopped(rootLogger.With("vmm-id", vmmID), vmmMetadata, filepath.Join(runCache.LocationRuns(), vmmID),
+					tracer, spanVMMPID.Context())
+				rootLogger.Info("dead VMM pruned", "vmm-id", vmmID)
+				continue
+			}
+
+			listed = append(listed, &vmm.ListedVMM{ID: vmmID, Running: running, Metadata: vmmMetadata})
+
 		} else {
 			itemsWithoutMetadata = itemsWithoutMetadata + 1
-			rootLogger.Info("vmm", "id", vmmID, "running", "???", "pid", "???")
+			listed = append(listed, &vmm.ListedVMM{ID: vmmID})
 		}
 
 	}
 
+	vmm.SortListing(listed, commandConfig.Sort, commandConfig.Reverse)
+
+	if commandConfig.Output == "json" {
+		entries := []*vmmEntry{}
+		for _, item := range listed {
+			if item.Metadata == nil {
+				entries = append(entries, &vmmEntry{ID: item.ID})
+				continue
+			}
+			entries = append(entries, newVMMEntry(item.ID, item.Running, item.Metadata))
+		}
+		bytes, jsonErr := json.MarshalIndent(entries, "", "  ")
+		if jsonErr != nil {
+			rootLogger.Error("failed serializing VMMs to JSON", "reason", jsonErr)
+			spanLs.SetBaggageItem("error", jsonErr.Error())
+			return 1
+		}
+		fmt.Println(string(bytes))
+	} else {
+		for _, item := range listed {
+			if item.Metadata == nil {
+				rootLogger.Info("vmm", "id", item.ID, "running", "???", "pid", "???")
+				continue
+			}
+			vmmMetadata := item.Metadata
+			logArgs := []interface{}{"id", item.ID,
+				"running", item.Running,
+				"pid", vmmMetadata.PID.Pid,
+				"image", fmt.Sprintf("%s/%s:%s", vmmMetadata.Rootfs.Image.Org, vmmMetadata.Rootfs.Image.Image, vmmMetadata.Rootfs.Image.Version),
+				"started", time.Unix(vmmMetadata.StartedAtUTC, 0).UTC().String(),
+				"ip-address", vmmMetadata.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IP}
+			if vmmMetadata.Rootfs.BuildStats != nil {
+				logArgs = append(logArgs, "image-build-duration", (time.Duration(vmmMetadata.Rootfs.BuildStats.DurationMs) * time.Millisecond).String())
+			}
+			logArgs = append(logArgs, "state", vmm.State(vmmMetadata, item.Running))
+			if len(vmmMetadata.Labels) > 0 {
+				logArgs = append(logArgs, "labels", vmmMetadata.Labels)
+			}
+			if vmmMetadata.Snapshot != nil {
+				logArgs = append(logArgs,
+					"snapshot", vmmMetadata.Snapshot.SnapshotPath,
+					"snapshot-mem-file", vmmMetadata.Snapshot.MemFilePath,
+					"snapshot-created", time.Unix(vmmMetadata.Snapshot.CreatedAtUTC, 0).UTC().String())
+			}
+			rootLogger.Info("vmm", logArgs...)
+		}
+	}
+
 	spanLs.SetBaggageItem("with-metadata", fmt.Sprintf("%d", itemsWithMetadata))
 	spanLs.SetBaggageItem("without-metadata", fmt.Sprintf("%d", itemsWithoutMetadata))
 
@@ -0,0 +1,88 @@
+package ls
+
+import (
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+)
+
+// vmmEntry is a VMM listed with --output json.
+type vmmEntry struct {
+	ID        string            `json:"ID"`
+	Running   *bool             `json:"Running"`
+	State     string            `json:"State,omitempty"`
+	Pid       int               `json:"Pid,omitempty"`
+	Started   string            `json:"Started,omitempty"`
+	IPAddress string            `json:"IPAddress,omitempty"`
+	Labels    map[string]string `json:"Labels,omitempty"`
+	Image     *imageEntry       `json:"Image,omitempty"`
+	Snapshot  *snapshotEntry    `json:"Snapshot,omitempty"`
+}
+
+// imageEntry is the rootfs image of a listed VMM.
+type imageEntry struct {
+	Tag      string             `json:"Tag"`
+	Parent   string             `json:"Parent,omitempty"`
+	Created  string             `json:"Created,omitempty"`
+	Size     int64              `json:"Size,omitempty"`
+	Metadata *metadata.MDRootfs `json:"Metadata,omitempty"`
+}
+
+// snapshotEntry is the snapshot of a listed VMM.
+type snapshotEntry struct {
+	Path    string `json:"Path"`
+	MemFile string `json:"MemFile"`
+	Created string `json:"Created"`
+}
+
+func newVMMEntry(vmmID string, running bool, vmmMetadata *metadata.MDRun) *vmmEntry {
+	entry := &vmmEntry{
+		ID:      vmmID,
+		Running: &running,
+		Pid:     vmmMetadata.PID.Pid,
+		Started: formatTimestamp(vmmMetadata.StartedAtUTC),
+		State:   vmm.State(vmmMetadata, running),
+		Labels:  vmmMetadata.Labels,
+	}
+	if len(vmmMetadata.NetworkInterfaces) > 0 {
+		entry.IPAddress = vmmMetadata.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IP
+	}
+	if vmmMetadata.Rootfs != nil {
+		entry.Image = &imageEntry{
+			Tag:      vmm.ImageTag(vmmMetadata.Rootfs.Image),
+			Parent:   parentTag(vmmMetadata.Rootfs.Parent),
+			Created:  formatTimestamp(vmmMetadata.Rootfs.CreatedAtUTC),
+			Size:     vmm.RootDriveSize(vmmMetadata),
+			Metadata: vmmMetadata.Rootfs,
+		}
+	}
+	if vmmMetadata.Snapshot != nil {
+		entry.Snapshot = &snapshotEntry{
+			Path:    vmmMetadata.Snapshot.SnapshotPath,
+			MemFile: vmmMetadata.Snapshot.MemFilePath,
+			Created: formatTimestamp(vmmMetadata.Snapshot.CreatedAtUTC),
+		}
+	}
+	return entry
+}
+
+// parentTag returns the tag of the rootfs or the base OS the image was built from,
+// an empty string if the parent metadata has no image.
+func parentTag(parent interface{}) string {
+	if parent == nil {
+		return ""
+	}
+	mdParent, err := metadata.MDRootfsFromInterface(parent)
+	if err != nil || mdParent.Image.Image == "" {
+		return ""
+	}
+	return vmm.ImageTag(mdParent.Image)
+}
+
+func formatTimestamp(unixSeconds int64) string {
+	if unixSeconds == 0 {
+		return ""
+	}
+	return time.Unix(unixSeconds, 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,115 @@
+package cleanup
+
+import (
+	"os"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+/*
+sudo /usr/local/go/bin/go run ./main.go cleanup-mounts --dry-run
+*/
+
+// Command is the cleanup-mounts command declaration.
+var Command = &cobra.Command{
+	Use:   "cleanup-mounts",
+	Short: "Unmounts the stale mounts and detaches the loop devices of interrupted builds",
+	Run:   run,
+	Long: `A build killed while the rootfs file is mounted leaves the mount and its loop device behind.
+The mounts and the loop devices under the temporary directories of the exited firebuild processes are removed,
+the temporary directories are removed once nothing is mounted under them.`,
+}
+
+var (
+	commandConfig = configs.NewCleanupMountsCommandConfig()
+	logConfig     = configs.NewLogginConfig()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	rootLogger := logConfig.NewLogger("cleanup-mounts")
+
+	staleMounts, err := utils.StaleMounts()
+	if err != nil {
+		rootLogger.Error("failed listing mounts", "reason", err)
+		return 1
+	}
+
+	failed := 0
+	tempDirs := map[string]bool{}
+	for _, staleMount := range staleMounts {
+		mountLogger := rootLogger.With("mount-point", staleMount.MountPoint, "source", staleMount.Source, "pid", staleMount.PID)
+		if commandConfig.DryRun {
+			mountLogger.Info("stale mount")
+			continue
+		}
+		if err := utils.Umount(staleMount.MountPoint); err != nil {
+			mountLogger.Error("failed unmounting stale mount", "reason", err)
+			failed = failed + 1
+			tempDirs[staleMount.TempDir] = false
+			continue
+		}
+		mountLogger.Info("stale mount unmounted")
+		if _, ok := tempDirs[staleMount.TempDir]; !ok {
+			tempDirs[staleMount.TempDir] = true
+		}
+	}
+
+	// the loop devices are listed after unmounting, unmounting releases the loop devices of the mounts:
+	staleLoopDevices, err := utils.StaleLoopDevices()
+	if err != nil {
+		rootLogger.Error("failed listing loop devices", "reason", err)
+		return 1
+	}
+
+	for _, staleLoopDevice := range staleLoopDevices {
+		deviceLogger := rootLogger.With("device", staleLoopDevice.Device, "backing-file", staleLoopDevice.BackingFile, "pid", staleLoopDevice.PID)
+		if commandConfig.DryRun {
+			deviceLogger.Info("stale loop device")
+			continue
+		}
+		if err := utils.DetachLoopDevice(staleLoopDevice.Device); err != nil {
+			deviceLogger.Error("failed detaching stale loop device", "reason", err)
+			failed = failed + 1
+			tempDirs[staleLoopDevice.TempDir] = false
+			continue
+		}
+		deviceLogger.Info("stale loop device detached")
+		if _, ok := tempDirs[staleLoopDevice.TempDir]; !ok {
+			tempDirs[staleLoopDevice.TempDir] = true
+		}
+	}
+
+	for tempDir, unmounted := range tempDirs {
+		if !unmounted {
+			rootLogger.Warn("temporary directory kept, not all mounts and loop devices removed", "path", tempDir)
+			continue
+		}
+		if err := os.RemoveAll(tempDir); err != nil {
+			rootLogger.Error("failed removing temporary directory", "path", tempDir, "reason", err)
+			failed = failed + 1
+		}
+	}
+
+	if failed > 0 {
+		rootLogger.Error("stale mounts cleanup failed", "failed", failed)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,105 @@
+package parse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/build/parsed"
+	"github.com/combust-labs/firebuild/pkg/build/reader"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+/*
+go run ./main.go parse ./Dockerfile --json
+*/
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "parse [flags] <dockerfile>",
+	Short: "Parses a Dockerfile and outputs the parsed commands and stages",
+	Run:   run,
+	Long: `The Dockerfile can be given in any form supported by the rootfs --dockerfile flag.
+The JSON output has the following schema:
+
+{
+  "Source": "the Dockerfile source",
+  "Commands": [ { "Type": "RUN", "Line": 1, "Original": "RUN ...", "Fields": { ... } } ],
+  "Stages": [ { "Name": "stage name", "DependsOn": [ "other stage" ], "Commands": [ ... ] } ],
+  "ExcludePatterns": [ ".dockerignore patterns" ]
+}`,
+}
+
+var (
+	commandConfig = configs.NewParseCommandConfig()
+	logConfig     = configs.NewLogginConfig()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, args []string) {
+	os.Exit(processCommand(args))
+}
+
+func processCommand(args []string) int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("parse")
+
+	if len(args) != 1 {
+		rootLogger.Error("exactly one Dockerfile is required")
+		return 1
+	}
+
+	// the reader clones git sources to the temp directory:
+	tempDirectory, err := ioutil.TempDir("", "")
+	if err != nil {
+		rootLogger.Error("failed creating temp directory", "reason", err)
+		return 1
+	}
+	cleanup.Add(func() {
+		if err := os.RemoveAll(tempDirectory); err != nil {
+			rootLogger.Warn("temp directory removal status", "error", err)
+		}
+	})
+
+	readResult, err := reader.ReadFromString(args[0], tempDirectory)
+	if err != nil {
+		rootLogger.Error("failed parsing Dockerfile", "reason", err)
+		return 1
+	}
+
+	parsedDockerfile, errs := parsed.NewParsedDockerfile(args[0], readResult)
+	for _, err := range errs {
+		rootLogger.Warn("stage read error", "reason", err)
+	}
+
+	if commandConfig.JSON {
+		bytes, jsonErr := json.MarshalIndent(parsedDockerfile, "", "  ")
+		if jsonErr != nil {
+			rootLogger.Error("failed serializing parsed Dockerfile to JSON", "reason", jsonErr)
+			return 1
+		}
+		fmt.Println(string(bytes))
+		return 0
+	}
+
+	for _, cmd := range parsedDockerfile.Commands {
+		fmt.Printf("%d\t%s\t%s\n", cmd.Line, cmd.Type, cmd.Original)
+	}
+
+	return 0
+
+}
@@ -2,6 +2,7 @@ package create
 
 import (
 	"os"
+	"strings"
 
 	"github.com/combust-labs/firebuild/configs"
 	"github.com/combust-labs/firebuild/pkg/profiles"
@@ -81,6 +82,20 @@ func processCommand() int {
 		}
 	}
 
+	if profileCreateConfig.Inherits != "" {
+		chain, err := profiles.InheritanceChain(profileCreateConfig.Inherits, profileSelectionConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("inherited profile invalid", "reason", err)
+			return 1
+		}
+		for _, inherited := range chain {
+			if inherited == strings.ToLower(profileSelectionConfig.Profile) {
+				rootLogger.Error("profile can't inherit from itself", "chain", strings.Join(chain, " -> "))
+				return 1
+			}
+		}
+	}
+
 	if err := profiles.WriteProfileFile(profileSelectionConfig.Profile, profileSelectionConfig.ProfileConfDir, profileCreateConfig); err != nil {
 		rootLogger.Error("profile not created", "reason", err)
 		return 1
@@ -0,0 +1,108 @@
+package rm
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+/*
+sudo /usr/local/go/bin/go run ./main.go profile-rm \
+	--profile=standard \
+	--yes
+*/
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "profile-rm",
+	Short: "Remove a firebuild profile",
+	Run:   run,
+	Long:  ``,
+}
+
+var (
+	commandConfig          = configs.NewProfileRmCommandConfig()
+	profileSelectionConfig = configs.NewProfileCommandConfig()
+	logConfig              = configs.NewLogginConfig()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(profileSelectionConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("profile-rm")
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		profileSelectionConfig,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			rootLogger.Error("profile configuration invalid", "reason", err)
+			return 1
+		}
+	}
+
+	if _, err := utils.CheckIfExistsAndIsDirectory(profileSelectionConfig.ProfileConfDir); err != nil {
+		rootLogger.Error("error validating profile configuration directory", "reason", err)
+		return 1
+	}
+
+	if !commandConfig.Yes {
+		confirmed, err := confirm(fmt.Sprintf("Remove profile '%s'? [y/N]: ", strings.ToLower(profileSelectionConfig.Profile)))
+		if err != nil {
+			rootLogger.Error("profile not removed, confirmation could not be read, use --yes to remove without confirmation", "reason", err)
+			return 1
+		}
+		if !confirmed {
+			rootLogger.Info("profile not removed")
+			return 1
+		}
+	}
+
+	if err := profiles.RemoveProfile(profileSelectionConfig.Profile, profileSelectionConfig.ProfileConfDir); err != nil {
+		rootLogger.Error("profile remove failed", "reason", err)
+		return 1
+	}
+
+	rootLogger.Info("profile removed", "profile", strings.ToLower(profileSelectionConfig.Profile))
+
+	return 0
+
+}
+
+// confirm asks the question on stderr and reads the answer from stdin, only y and yes confirm.
+func confirm(question string) (bool, error) {
+	fmt.Fprint(os.Stderr, question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
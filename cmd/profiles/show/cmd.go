@@ -0,0 +1,96 @@
+package show
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+/*
+sudo /usr/local/go/bin/go run ./main.go profile-show \
+	--profile=standard \
+	--output=yaml
+*/
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "profile-show",
+	Short: "Show the effective configuration of a firebuild profile",
+	Run:   run,
+	Long: `Prints the configuration applied for the profile: the profile merged with the inherited profiles,
+with the environment variables expanded with the current environment, the inheritance chain
+and the storage provider configuration.`,
+}
+
+var (
+	commandConfig          = configs.NewProfileShowCommandConfig()
+	profileSelectionConfig = configs.NewProfileCommandConfig()
+	logConfig              = configs.NewLogginConfig()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(profileSelectionConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("profile-show")
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		profileSelectionConfig,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			rootLogger.Error("profile configuration invalid", "reason", err)
+			return 1
+		}
+	}
+
+	effective, err := profiles.ReadEffectiveProfile(profileSelectionConfig.Profile, profileSelectionConfig.ProfileConfDir)
+	if err != nil {
+		rootLogger.Error("profile show failed", "reason", err)
+		return 1
+	}
+
+	if commandConfig.Output == "yaml" {
+		bytes, yamlErr := yaml.Marshal(effective)
+		if yamlErr != nil {
+			rootLogger.Error("profile show failed", "reason", yamlErr)
+			return 1
+		}
+		fmt.Print(string(bytes))
+		return 0
+	}
+
+	bytes, jsonErr := json.MarshalIndent(effective, "", "  ")
+	if jsonErr != nil {
+		rootLogger.Error("profile show failed", "reason", jsonErr)
+		return 1
+	}
+
+	fmt.Println(string(bytes))
+
+	return 0
+
+}
@@ -0,0 +1,237 @@
+package publish
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/fw"
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/hashicorp/go-hclog"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/cobra"
+)
+
+// Command is the publish command declaration.
+var Command = &cobra.Command{
+	Use:   "publish <vmm-id>",
+	Short: "Publishes or removes the ports of a running VMM",
+	Args:  cobra.ExactArgs(1),
+	Run:   run,
+	Long: `Publishes the ports given with --port and removes the published ports given with --remove
+without restarting the VMM. The iptables rules and the ports stored in the VMM metadata are updated.
+Publishing an already published port and removing a port which is not published are no-ops.`,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completion.RunningVMMIDs(profilesConfig, runCache)(cmd, args, toComplete)
+	},
+}
+
+var (
+	commandConfig  = configs.NewPublishCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	runCache       = configs.NewRunCacheConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-publish")
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(runCache.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, args []string) {
+	commandConfig.VMMID = args[0]
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("publish")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanPublish := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("publish"))
+	spanPublish.SetTag("vmm-id", commandConfig.VMMID)
+	cleanup.Add(func() {
+		spanPublish.Finish()
+	})
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		runCache,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			spanPublish.SetBaggageItem("error", err.Error())
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), commandConfig.VMMID))
+	if metadataErr != nil {
+		rootLogger.Error("failed loading metadata", "reason", metadataErr, "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanPublish.SetBaggageItem("error", metadataErr.Error())
+		return 1
+	}
+	if !hasMetadata {
+		rootLogger.Error("run cache directory did not contain the VMM metadata", "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		return 1
+	}
+	if isRunning, _ := vmmMetadata.PID.IsRunning(); !isRunning {
+		rootLogger.Error("VMM is not running", "vmm-id", commandConfig.VMMID)
+		return 1
+	}
+	if vmmMetadata.Configs.RunConfig == nil || len(vmmMetadata.NetworkInterfaces) == 0 {
+		rootLogger.Error("VMM has no network interfaces to publish the ports to", "vmm-id", commandConfig.VMMID)
+		return 1
+	}
+
+	portsToPublish, parseErr := resolvePorts(rootLogger, vmmMetadata, commandConfig.Ports)
+	if parseErr != nil {
+		rootLogger.Error("exposed port input is invalid", "reason", parseErr)
+		spanPublish.SetBaggageItem("error", parseErr.Error())
+		return 1
+	}
+	portsToRemove, parseErr := resolvePorts(rootLogger, vmmMetadata, commandConfig.Remove)
+	if parseErr != nil {
+		rootLogger.Error("removed port input is invalid", "reason", parseErr)
+		spanPublish.SetBaggageItem("error", parseErr.Error())
+		return 1
+	}
+	for _, port := range portsToPublish {
+		if vmmMetadata.Configs.RunConfig.PublishIPv4Only && port.Family() == fw.FamilyIPv6 {
+			rootLogger.Error("exposed port input is invalid", "reason", "VMM started with --publish-ipv4-only", "port", port.String())
+			return 1
+		}
+	}
+	if err := fw.CheckPrivilegedPorts(portsToPublish, commandConfig.AllowPrivilegedPorts); err != nil {
+		rootLogger.Error("exposed port input is invalid", "reason", err)
+		return 1
+	}
+
+	portsManager, managerErr := fw.NewManager(vmmMetadata.VMMID, vmmMetadata.PublishAddresses()...)
+	if managerErr != nil {
+		rootLogger.Error("handling iptables failed", "reason", managerErr)
+		spanPublish.SetBaggageItem("error", managerErr.Error())
+		return 1
+	}
+
+	spanUpdate := tracer.StartSpan("publish-update-ports", opentracing.ChildOf(spanPublish.Context()))
+	defer spanUpdate.Finish()
+
+	if len(portsToRemove) > 0 {
+		if err := portsManager.Unpublish(portsToRemove); err != nil {
+			rootLogger.Error("port removal failed", "reason", err)
+			spanUpdate.SetBaggageItem("error", err.Error())
+			return 1
+		}
+	}
+	if len(portsToPublish) > 0 {
+		// the rules are appended only if they do not exist yet:
+		if err := portsManager.Publish(portsToPublish); err != nil {
+			rootLogger.Error("port publishing failed", "reason", err)
+			spanUpdate.SetBaggageItem("error", err.Error())
+			return 1
+		}
+	}
+
+	vmmMetadata.Configs.RunConfig.Ports = updatedPorts(vmmMetadata.Configs.RunConfig.Ports, portsToPublish, portsToRemove)
+	if err := vmm.WriteMetadataToFile(vmmMetadata); err != nil {
+		rootLogger.Error("failed writing VMM metadata", "reason", err, "vmm-id", commandConfig.VMMID)
+		spanUpdate.SetBaggageItem("error", err.Error())
+		return 1
+	}
+
+	rootLogger.Info("published ports updated", "vmm-id", commandConfig.VMMID, "ports", vmmMetadata.Configs.RunConfig.Ports)
+
+	return 0
+}
+
+// resolvePorts parses the ports, the ports given without a protocol
+// default to the protocol exposed by the rootfs, like for the run command.
+func resolvePorts(logger hclog.Logger, md *metadata.MDRun, inputs []string) ([]fw.ExposedPort, error) {
+	ports := []fw.ExposedPort{}
+	for _, input := range inputs {
+		port, err := fw.ExposedPortFromString(input)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+	if md.Rootfs == nil {
+		return ports, nil
+	}
+	rootfsExposedPorts := []fw.ExposedPort{}
+	for _, rootfsPort := range md.Rootfs.Ports {
+		port, err := fw.ExposedPortFromString(rootfsPort)
+		if err != nil {
+			logger.Warn("rootfs exposed port could not be parsed, ignoring", "reason", err, "raw-input", rootfsPort)
+			continue
+		}
+		rootfsExposedPorts = append(rootfsExposedPorts, port)
+	}
+	return fw.WithExposedProtocols(ports, rootfsExposedPorts), nil
+}
+
+// updatedPorts returns the stored ports with the published ports added once
+// and the removed ports removed.
+func updatedPorts(current []string, published, removed []fw.ExposedPort) []string {
+	removedPorts := map[string]bool{}
+	for _, port := range removed {
+		removedPorts[port.String()] = true
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	add := func(port string) {
+		if removedPorts[port] || seen[port] {
+			return
+		}
+		seen[port] = true
+		result = append(result, port)
+	}
+	for _, port := range current {
+		add(port)
+	}
+	for _, port := range published {
+		add(port.String())
+	}
+	return result
+}
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/combust-labs/firebuild/configs"
 	"github.com/combust-labs/firebuild/pkg/fw"
@@ -13,6 +14,7 @@ import (
 	"github.com/combust-labs/firebuild/pkg/vmm"
 	"github.com/combust-labs/firebuild/pkg/vmm/chroot"
 	"github.com/combust-labs/firebuild/pkg/vmm/cni"
+	"github.com/hashicorp/go-hclog"
 	"github.com/opentracing/opentracing-go"
 	"github.com/spf13/cobra"
 )
@@ -20,19 +22,28 @@ import (
 // Command is the build command declaration.
 var Command = &cobra.Command{
 	Use:   "purge",
-	Short: "Purges all remains of a VMM, if the VMM is stopped",
+	Short: "Purges all remains of the stopped VMMs and the orphaned VMM resources",
 	Run:   run,
-	Long:  ``,
+	Long: `Removes the jail directory, the CNI network allocation, the iptables rules and the run cache
+of every stopped VMM of the run cache. The jail directories, the CNI cache directories and the iptables
+chains of the VMMs without a run cache entry and without a running process are removed as orphaned.
+Requires --force, use --dry-run to list what would be removed.`,
 }
 
 var (
-	logConfig      = configs.NewLogginConfig()
-	profilesConfig = configs.NewProfileCommandConfig()
-	runCache       = configs.NewRunCacheConfig()
-	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-purge")
+	cniConfig       = configs.NewCNIConfig()
+	commandConfig   = configs.NewPurgeCommandConfig()
+	jailingFcConfig = configs.NewJailingFirecrackerConfig()
+	logConfig       = configs.NewLogginConfig()
+	profilesConfig  = configs.NewProfileCommandConfig()
+	runCache        = configs.NewRunCacheConfig()
+	tracingConfig   = configs.NewTracingConfig("firebuild-vmm-purge")
 )
 
 func initFlags() {
+	Command.Flags().AddFlagSet(cniConfig.FlagSet())
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(jailingFcConfig.FlagSet())
 	Command.Flags().AddFlagSet(logConfig.FlagSet())
 	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
 	Command.Flags().AddFlagSet(runCache.FlagSet())
@@ -60,13 +71,13 @@ func processCommand() int {
 			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
 			return 1
 		}
-		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+		if err := profile.UpdateConfigs(jailingFcConfig, runCache, tracingConfig); err != nil {
 			rootLogger.Error("error updating configuration from profile", "reason", err)
 			return 1
 		}
 	}
 
-	rootLogger = rootLogger.With("run-cache", runCache.LocationRuns())
+	rootLogger = rootLogger.With("run-cache", runCache.LocationRuns(), "dry-run", commandConfig.DryRun)
 
 	// tracing:
 
@@ -86,6 +97,7 @@ func processCommand() int {
 	})
 
 	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
 		runCache,
 	}
 
@@ -104,6 +116,12 @@ func processCommand() int {
 		return 1
 	}
 
+	state := &reconcileState{
+		knownIDs:      map[string]bool{},
+		liveIDs:       map[string]bool{},
+		liveAddresses: []string{},
+	}
+
 	for _, fileInfo := range fileInfos {
 
 		// see if the metadata file can be loaded:
@@ -117,112 +135,201 @@ func processCommand() int {
 			rootLogger.Error("metadata error for cache entry, skipping", "fs-entry", fsentry, "reason", err)
 			spanMetadata.SetBaggageItem("error", err.Error())
 			spanMetadata.Finish()
+			// the entry belongs to a VMM, its resources are not orphaned:
+			state.knownIDs[fsentry] = true
 			continue
 		}
 
 		spanMetadata.SetTag("has-metadata", hasMetadata)
 		spanMetadata.Finish()
 
-		if hasMetadata {
-
-			vmmLogger := rootLogger.With("vmm-id", vmmMetadata.VMMID)
-
-			running, err := vmmMetadata.PID.IsRunning()
-			if err != nil {
-				vmmLogger.Error("pid error for cache entry", "reason", err)
-				continue
-			}
-
-			if running {
-				vmmLogger.Debug("skipping running VMM")
-				continue
-			}
-
-			spanPurgeChroot := tracer.StartSpan("vmm-purge-chroot", opentracing.ChildOf(spanMetadata.Context()))
-			spanPurgeChroot.SetTag("fs-entry", fsentry)
-
-			// get the chroot:
-			chrootInst := chroot.NewWithLocation(chroot.LocationFromComponents(vmmMetadata.Configs.Jailer.ChrootBase,
-				vmmMetadata.Configs.Jailer.BinaryFirecracker,
-				vmmMetadata.VMMID))
-			chrootExists, chrootErr := chrootInst.Exists()
-
-			if chrootErr != nil {
-				spanPurgeChroot.SetBaggageItem("chroot-fetch-error", chrootErr.Error())
-				vmmLogger.Error("error while checking VMM chroot, skipping", "reason", chrootErr)
-			}
-
-			spanPurgeChroot.SetTag("chroot-existed", chrootExists)
-
-			if chrootErr == nil && chrootExists {
-				if err := chrootInst.RemoveAll(); err != nil {
-					spanPurgeChroot.SetBaggageItem("chroot-purge-error", err.Error())
-					vmmLogger.Error("error removing chroot directory fro stopped VMM", "reason", err)
-				}
-			}
-
-			spanPurgeChroot.Finish()
-
-			spanPurgeCNI := tracer.StartSpan("vmm-purge-cni", opentracing.ChildOf(spanPurgeChroot.Context()))
-			spanPurgeCNI.SetTag("fs-entry", fsentry)
-
-			if err := cni.CleanupCNI(rootLogger,
-				vmmMetadata.Configs.CNI,
-				vmmMetadata.VMMID, vmmMetadata.CNI.VethName,
-				vmmMetadata.CNI.NetName, vmmMetadata.CNI.NetNS); err != nil {
-				spanPurgeCNI.SetBaggageItem("cni-purge-error", err.Error())
-				vmmLogger.Error("failed cleaning up CNI", "reason", err)
-			}
-
-			spanPurgeCNI.Finish()
-
-			spanPurgeIPT := tracer.StartSpan("vmm-purge-ipt", opentracing.ChildOf(spanPurgeCNI.Context()))
-			spanPurgeIPT.SetTag("vmm-id", vmmMetadata.VMMID)
-
-			if len(vmmMetadata.Configs.RunConfig.Ports) > 0 {
-				if len(vmmMetadata.NetworkInterfaces) > 0 {
-					rootLogger.Info("cleaning up IPT")
-					mgr, err := fw.NewManager(vmmMetadata.VMMID, vmmMetadata.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IP)
-					if err != nil {
-						rootLogger.Warn("cleaning up IPT failed", "reason", err)
-					} else {
-						ports := []fw.ExposedPort{}
-						for _, port := range vmmMetadata.Configs.RunConfig.Ports {
-							parsedPort, parseErr := fw.ExposedPortFromString(port)
-							if parseErr != nil {
-								rootLogger.Warn("IP cleanup: port failed to parse", "reason", parseErr, "raw-input", port)
-							} else {
-								ports = append(ports, parsedPort)
-							}
-						}
-						if err := mgr.Unpublish(ports); err != nil {
-							rootLogger.Warn("cleaning up IPT failed", "reason", err)
-						}
-					}
-					rootLogger.Info("IPT cleaned up")
-				}
-			}
-
-			spanPurgeIPT.Finish()
-
-			spanPurgeCache := tracer.StartSpan("vmm-purge-cache", opentracing.ChildOf(spanPurgeIPT.Context()))
-			spanPurgeCache.SetTag("fs-entry", fsentry)
-
-			// have to clean up the cache
-			cacheDirectory := filepath.Join(runCache.LocationRuns(), vmmMetadata.VMMID)
-			if err := os.RemoveAll(cacheDirectory); err != nil {
-				spanPurgeCache.SetBaggageItem("cache-purge-error", err.Error())
-				vmmLogger.Error("failed removing cache directroy", "reason", err, "path", cacheDirectory)
-			}
-
-			spanPurgeCache.Finish()
-
-			vmmLogger.Info(vmmMetadata.VMMID)
-
-		} else {
+		if !hasMetadata {
 			rootLogger.Warn("no metadata for entry, skipping", "fs-entry", fsentry)
+			state.knownIDs[fsentry] = true
+			continue
+		}
+
+		vmmLogger := rootLogger.With("vmm-id", vmmMetadata.VMMID)
+		state.knownIDs[vmmMetadata.VMMID] = true
+
+		running, err := vmm.IsAlive(vmmMetadata)
+		if err != nil {
+			vmmLogger.Error("pid error for cache entry", "reason", err)
+			continue
 		}
+
+		if running {
+			vmmLogger.Debug("skipping running VMM")
+			state.liveIDs[vmmMetadata.VMMID] = true
+			state.liveAddresses = append(state.liveAddresses, vmmMetadata.GuestAddresses()...)
+			continue
+		}
+
+		state.stopped++
+
+		if commandConfig.DryRun {
+			vmmLogger.Info("would purge the remains of the stopped VMM")
+			continue
+		}
+
+		vmm.PurgeStopped(vmmLogger, vmmMetadata, filepath.Join(runCache.LocationRuns(), vmmMetadata.VMMID),
+			tracer, spanMetadata.Context())
+
+		vmmLogger.Info(vmmMetadata.VMMID)
+	}
+
+	spanOrphans := tracer.StartSpan("purge-orphans", opentracing.ChildOf(spanPurge.Context()))
+	reconcileOrphans(rootLogger, state)
+	spanOrphans.SetTag("orphaned-chroots", state.chroots)
+	spanOrphans.SetTag("orphaned-cni", state.cniAttachments)
+	spanOrphans.SetTag("orphaned-chains", state.chains)
+	spanOrphans.SetTag("failed", state.failed)
+	spanOrphans.Finish()
+
+	rootLogger.Info("purge finished",
+		"stopped-vmms", state.stopped,
+		"orphaned-chroots", state.chroots,
+		"orphaned-cni", state.cniAttachments,
+		"orphaned-chains", state.chains,
+		"failed", state.failed)
+
+	if commandConfig.DryRun {
+		rootLogger.Info("nothing removed, run with --force to remove")
 	}
 
 	return 0
 }
+
+// reconcileState is the state of the orphaned resources reconciliation.
+type reconcileState struct {
+	// knownIDs are the IDs of the VMMs with a run cache entry, purged with the run cache.
+	knownIDs map[string]bool
+	// liveIDs are the IDs of the VMMs with a running process.
+	liveIDs map[string]bool
+	// liveAddresses are the addresses of the running VMMs, their forward rules are never removed.
+	liveAddresses []string
+
+	stopped        int
+	chroots        int
+	cniAttachments int
+	chains         int
+	failed         int
+}
+
+func (s *reconcileState) isOrphan(vmmID string) bool {
+	return !s.knownIDs[vmmID] && !s.liveIDs[vmmID]
+}
+
+// reconcileOrphans removes the jail directories, the CNI network allocations and the iptables chains
+// of the VMMs without a run cache entry and without a running process. The jail directories and
+// the CNI cache directories younger than --orphan-min-age may belong to a VMM being started,
+// such VMMs are skipped entirely.
+func reconcileOrphans(logger hclog.Logger, state *reconcileState) {
+
+	runningIDs, err := chroot.RunningVMMIDs(jailingFcConfig.ChrootBase)
+	if err != nil {
+		logger.Error("failed listing the processes running in a jail, orphaned resources not reconciled", "reason", err)
+		state.failed++
+		return
+	}
+	for vmmID := range runningIDs {
+		state.liveIDs[vmmID] = true
+	}
+
+	locations, err := chroot.List(jailingFcConfig.ChrootBase)
+	if err != nil {
+		logger.Error("failed listing the jail directories, orphaned resources not reconciled", "reason", err, "chroot-base", jailingFcConfig.ChrootBase)
+		state.failed++
+		return
+	}
+	attachments, err := cni.CachedAttachments(cniConfig)
+	if err != nil {
+		logger.Error("failed listing the CNI cache, orphaned resources not reconciled", "reason", err)
+		state.failed++
+		return
+	}
+
+	recentIDs := map[string]bool{}
+	for _, location := range locations {
+		if stat, err := os.Stat(location.FullPath()); err == nil && isRecent(stat.ModTime()) {
+			recentIDs[location.VMMID] = true
+		}
+	}
+	for _, attachment := range attachments {
+		if isRecent(attachment.ModTime) {
+			recentIDs[attachment.ContainerID] = true
+		}
+	}
+	for vmmID := range recentIDs {
+		if state.isOrphan(vmmID) {
+			logger.Debug("skipping recently created VMM resources", "vmm-id", vmmID)
+		}
+	}
+
+	for _, location := range locations {
+		if !state.isOrphan(location.VMMID) || recentIDs[location.VMMID] {
+			continue
+		}
+		state.chroots++
+		orphanLogger := logger.With("vmm-id", location.VMMID, "chroot", location.FullPath())
+		if commandConfig.DryRun {
+			orphanLogger.Info("would remove orphaned jail directory")
+			continue
+		}
+		if err := chroot.NewWithLocation(location).RemoveAll(); err != nil {
+			orphanLogger.Error("failed removing orphaned jail directory", "reason", err)
+			state.failed++
+			continue
+		}
+		orphanLogger.Info("orphaned jail directory removed")
+	}
+
+	for _, attachment := range attachments {
+		if !state.isOrphan(attachment.ContainerID) || recentIDs[attachment.ContainerID] {
+			continue
+		}
+		state.cniAttachments++
+		orphanLogger := logger.With("vmm-id", attachment.ContainerID, "net-name", attachment.NetworkName, "iface-name", attachment.IfName)
+		if commandConfig.DryRun {
+			orphanLogger.Info("would remove orphaned CNI network allocation", "path", attachment.Path)
+			continue
+		}
+		if err := cni.CleanupCNI(logger, cniConfig,
+			attachment.ContainerID, attachment.IfName,
+			attachment.NetworkName, jailingFcConfig.NetNS); err != nil {
+			orphanLogger.Error("failed removing orphaned CNI network allocation", "reason", err)
+			state.failed++
+			continue
+		}
+		orphanLogger.Info("orphaned CNI network allocation removed")
+	}
+
+	chainIDs, err := fw.VMMChainIDs()
+	if err != nil {
+		logger.Warn("failed listing the iptables chains, orphaned chains not reconciled", "reason", err)
+		return
+	}
+	for _, vmmID := range chainIDs {
+		if !state.isOrphan(vmmID) || recentIDs[vmmID] {
+			continue
+		}
+		state.chains++
+		orphanLogger := logger.With("vmm-id", vmmID)
+		if commandConfig.DryRun {
+			orphanLogger.Info("would remove orphaned iptables chains",
+				"ports-chain", fw.PortsChainName(vmmID),
+				"egress-chain", fw.EgressChainName(vmmID))
+			continue
+		}
+		if err := fw.RemoveVMMChains(vmmID, state.liveAddresses...); err != nil {
+			orphanLogger.Error("failed removing orphaned iptables chains", "reason", err)
+			state.failed++
+			continue
+		}
+		orphanLogger.Info("orphaned iptables chains removed")
+	}
+}
+
+func isRecent(modTime time.Time) bool {
+	return time.Since(modTime) < commandConfig.OrphanMinAge
+}
@@ -0,0 +1,276 @@
+package restore
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/combust-labs/firebuild/pkg/vmm/chroot"
+	"github.com/hashicorp/go-hclog"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/cobra"
+)
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "restore",
+	Short: "Restores a VMM from a snapshot",
+	Run:   run,
+	Long: `Loads the VMM snapshot created with the snapshot command into a fresh jailer chroot and resumes the VMM.
+The VMM must not be running. The restored VMM reuses the network and the run cache of the snapshotted VMM.`,
+}
+
+var (
+	commandConfig  = configs.NewRestoreCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	runCache       = configs.NewRunCacheConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-restore")
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(runCache.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("restore")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	// the tracer must outlive the cleanup functions using it:
+	defer tracerCleanupFunc()
+
+	rootLogger, spanRestore := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("restore"))
+	spanRestore.SetTag("vmm-id", commandConfig.VMMID)
+	defer spanRestore.Finish()
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		runCache,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			spanRestore.SetBaggageItem("error", err.Error())
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	spanFetchMetadata := tracer.StartSpan("fetch-metadata", opentracing.ChildOf(spanRestore.Context()))
+
+	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), commandConfig.VMMID))
+	if metadataErr != nil {
+		rootLogger.Error("failed loading metadata", "reason", metadataErr, "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.SetBaggageItem("error", metadataErr.Error())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.SetTag("has-metadata", hasMetadata)
+
+	if !hasMetadata {
+		rootLogger.Error("run cache directory did not contain the VMM metadata", "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.Finish()
+
+	if vmmMetadata.Snapshot == nil {
+		rootLogger.Error("VMM has no snapshot", "vmm-id", commandConfig.VMMID)
+		return 1
+	}
+
+	if isRunning, _ := vmmMetadata.PID.IsRunning(); isRunning {
+		rootLogger.Error("VMM is running, stop it with snapshot --stop before restoring", "vmm-id", commandConfig.VMMID)
+		return 1
+	}
+
+	for _, snapshotFile := range []string{vmmMetadata.Snapshot.SnapshotPath, vmmMetadata.Snapshot.MemFilePath} {
+		if _, err := utils.CheckIfExistsAndIsRegular(snapshotFile); err != nil {
+			rootLogger.Error("snapshot file not found", "reason", err, "path", snapshotFile)
+			return 1
+		}
+	}
+
+	spanChrootCleanup := tracer.StartSpan("restore-chroot-cleanup", opentracing.ChildOf(spanFetchMetadata.Context()))
+
+	// the jail of the snapshotted VMM is left behind when the VMM is stopped:
+	staleChroot := chroot.NewWithLocation(chroot.LocationFromComponents(vmmMetadata.Configs.Jailer.ChrootBase,
+		vmmMetadata.Configs.Jailer.BinaryFirecracker,
+		vmmMetadata.VMMID))
+	if err := staleChroot.RemoveAll(); err != nil {
+		rootLogger.Error("failed removing stale jailer directory", "reason", err, "path", staleChroot.FullPath())
+		spanChrootCleanup.SetBaggageItem("error", err.Error())
+		spanChrootCleanup.Finish()
+		return 1
+	}
+
+	spanChrootCleanup.Finish()
+
+	jailingFcConfig := vmmMetadata.Configs.Jailer.WithVMMID(vmmMetadata.VMMID)
+	jailingFcConfig.NetNS = vmmMetadata.CNI.NetNS
+
+	machineConfig := vmmMetadata.Configs.Machine.
+		WithDaemonize(commandConfig.Daemonize).
+		WithRootfsOverride(filepath.Join(vmmMetadata.RunCache, naming.RootfsFileName))
+
+	vmmMetadata.Configs.RunConfig.Daemonize = commandConfig.Daemonize
+
+	vmmLogger := rootLogger.With("vmm-id", jailingFcConfig.VMMID(), "veth-name", vmmMetadata.CNI.VethName)
+
+	vmmLogger.Info("restoring VMM",
+		"snapshot", vmmMetadata.Snapshot.SnapshotPath,
+		"jail", jailingFcConfig.JailerChrootDirectory())
+
+	// the snapshot refers to the additional drives too:
+	vmmProvider := vmm.NewDefaultProvider(vmmMetadata.Configs.CNI, jailingFcConfig, machineConfig).
+		WithDrives(vmmMetadata.AttachedDrives).
+		WithVethIfaceName(vmmMetadata.CNI.VethName)
+
+	vmmCtx, vmmCancel := context.WithCancel(context.Background())
+	cleanup.Add(func() {
+		vmmCancel()
+	})
+
+	cleanup.Add(func() {
+		span := tracer.StartSpan("restore-cleanup-cache-dir", opentracing.ChildOf(spanRestore.Context()))
+		vmmLogger.Info("cleaning up cache directory")
+		if err := os.RemoveAll(vmmMetadata.RunCache); err != nil {
+			vmmLogger.Error("cache directory removal status", "error", err)
+			span.SetBaggageItem("error", err.Error())
+		}
+		span.Finish()
+	})
+
+	cleanup.Add(func() {
+		span := tracer.StartSpan("restore-cleanup-jail", opentracing.ChildOf(spanRestore.Context()))
+		vmmLogger.Info("cleaning up jail directory")
+		if err := os.RemoveAll(jailingFcConfig.JailerChrootDirectory()); err != nil {
+			vmmLogger.Error("jail directory removal status", "error", err)
+			span.SetBaggageItem("error", err.Error())
+		}
+		span.Finish()
+	})
+
+	spanVMMRestore := tracer.StartSpan("restore-vmm-restore", opentracing.ChildOf(spanChrootCleanup.Context()))
+
+	startedMachine, restoreErr := vmmProvider.RestoreSnapshot(vmmCtx,
+		vmmMetadata.Snapshot.MemFilePath,
+		vmmMetadata.Snapshot.SnapshotPath)
+	if restoreErr != nil {
+		// keep the run cache so the restore can be retried:
+		cleanup.Trigger(false)
+		vmmCancel()
+		if err := os.RemoveAll(jailingFcConfig.JailerChrootDirectory()); err != nil {
+			vmmLogger.Error("jail directory removal status", "error", err)
+		}
+		vmmLogger.Error("firecracker VMM did not restore", "reason", restoreErr)
+		spanVMMRestore.SetBaggageItem("error", restoreErr.Error())
+		spanVMMRestore.Finish()
+		return 1
+	}
+
+	spanVMMRestore.Finish()
+
+	if err := startedMachine.DecorateMetadata(vmmMetadata); err != nil {
+		startedMachine.Stop(vmmCtx)
+		vmmLogger.Error("Failed fetching machine metadata", "reason", err)
+		return 1
+	}
+
+	if err := vmm.WriteMetadataToFile(vmmMetadata); err != nil {
+		vmmLogger.Error("failed writing machine metadata to file", "reason", err, "metadata", vmmMetadata)
+	}
+
+	if commandConfig.Daemonize {
+		vmmLogger.Info("VMM running as a daemon",
+			"jailer-dir", jailingFcConfig.JailerChrootDirectory(),
+			"cache-dir", vmmMetadata.RunCache)
+		cleanup.Trigger(false) // do not trigger cleanup defers
+		return 0
+	}
+
+	// the ports published and the egress policy applied for the snapshotted VMM are still in place:
+	cleanup.Add(func() {
+		vmm.UnpublishPorts(vmmLogger, vmmMetadata)
+		vmm.RemoveEgressPolicy(vmmLogger, vmmMetadata)
+	})
+
+	vmmLogger.Info("VMM running",
+		"jailer-dir", jailingFcConfig.JailerChrootDirectory(),
+		"cache-dir", vmmMetadata.RunCache)
+
+	chanStopStatus := installSignalHandlers(context.Background(), vmmLogger, startedMachine)
+
+	spanVMMStop := tracer.StartSpan("restore-vmm-stop", opentracing.ChildOf(spanVMMRestore.Context()))
+
+	startedMachine.Wait(context.Background())
+	startedMachine.Cleanup(chanStopStatus)
+
+	vmmLogger.Info("machine is stopped", "gracefully", <-chanStopStatus)
+
+	spanVMMStop.Finish()
+
+	return 0
+
+}
+
+func installSignalHandlers(ctx context.Context, logger hclog.Logger, m vmm.StartedMachine) chan bool {
+	chanStopped := make(chan bool, 1)
+	go func() {
+		// Clear selected default handlers installed by the firecracker SDK:
+		signal.Reset(os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		for {
+			switch s := <-c; {
+			case s == syscall.SIGTERM || s == os.Interrupt:
+				logger.Info("Caught SIGINT, requesting clean shutdown")
+				chanStopped <- m.Stop(ctx)
+			}
+		}
+	}()
+	return chanStopped
+}
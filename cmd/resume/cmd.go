@@ -0,0 +1,155 @@
+package resume
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/cobra"
+)
+
+// Command is the resume command declaration.
+var Command = &cobra.Command{
+	Use:   "resume",
+	Short: "Resumes a paused VMM",
+	Run:   run,
+	Long: `Resumes the VMM paused with the pause command. A VMM not recorded as paused is resumed too,
+for example a VMM left paused by a failed snapshot.`,
+}
+
+var (
+	commandConfig  = configs.NewResumeCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	runCache       = configs.NewRunCacheConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-resume")
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(runCache.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Dynamic completion:
+	Command.RegisterFlagCompletionFunc("vmm-id", completion.RunningVMMIDs(profilesConfig, runCache))
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("resume")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanResume := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("resume"))
+	spanResume.SetTag("vmm-id", commandConfig.VMMID)
+	cleanup.Add(func() {
+		spanResume.Finish()
+	})
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		runCache,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			spanResume.SetBaggageItem("error", err.Error())
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	spanFetchMetadata := tracer.StartSpan("fetch-metadata", opentracing.ChildOf(spanResume.Context()))
+
+	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), commandConfig.VMMID))
+	if metadataErr != nil {
+		rootLogger.Error("failed loading metadata", "reason", metadataErr, "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.SetBaggageItem("error", metadataErr.Error())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.SetTag("has-metadata", hasMetadata)
+
+	if !hasMetadata {
+		rootLogger.Error("run cache directory did not contain the VMM metadata", "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.Finish()
+
+	isRunning, runningErr := vmmMetadata.PID.IsRunning()
+	if runningErr != nil || !isRunning {
+		rootLogger.Error("VMM is not running", "vmm-id", commandConfig.VMMID, "reason", runningErr)
+		return 1
+	}
+
+	spanResumeCall := tracer.StartSpan("resume-vmm", opentracing.ChildOf(spanFetchMetadata.Context()))
+
+	vmmProvider := vmm.NewDefaultProvider(vmmMetadata.Configs.CNI, vmmMetadata.Configs.Jailer, vmmMetadata.Configs.Machine)
+
+	if err := vmmProvider.Resume(context.Background(), commandConfig.VMMID); err != nil {
+		if errors.Is(err, vmm.ErrVMMSocketNotFound) {
+			rootLogger.Error("VMM socket not found, the VMM is gone, purge the remains with the purge command", "reason", err)
+		} else {
+			rootLogger.Error("failed resuming the VMM", "reason", err)
+		}
+		spanResumeCall.SetBaggageItem("error", err.Error())
+		spanResumeCall.Finish()
+		return 1
+	}
+
+	spanResumeCall.Finish()
+
+	vmmMetadata.State = metadata.StateRunning
+	if err := vmm.WriteMetadataToFile(vmmMetadata); err != nil {
+		rootLogger.Error("VMM resumed but failed writing machine metadata to file", "reason", err)
+		spanResume.SetBaggageItem("error", err.Error())
+		return 1
+	}
+
+	rootLogger.Info("VMM resumed")
+
+	return 0
+
+}
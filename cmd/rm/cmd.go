@@ -0,0 +1,239 @@
+package rm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/containers"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/storage/resolver"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/hashicorp/go-hclog"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+/*
+sudo /usr/local/go/bin/go run ./main.go rm \
+	--profile=standard \
+	--tag=tests/postgres:13
+*/
+
+// Command is the rm command declaration.
+var Command = &cobra.Command{
+	Use:   "rm [tag...]",
+	Short: "Delete stored rootfs images",
+	Run:   run,
+	Long: `Deletes the rootfs and the metadata of the tags from the storage.
+Tags can be given with --tag or as arguments.
+A rootfs used by a running VMM is not deleted unless --force is given.`,
+}
+
+var (
+	commandConfig  = configs.NewRmCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	runCache       = configs.NewRunCacheConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-rm")
+
+	storageResolver = resolver.NewDefaultResolver()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(runCache.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Storage provider flags:
+	resolver.AddStorageFlags(Command.Flags())
+	// Dynamic completion:
+	Command.RegisterFlagCompletionFunc("tag", completion.RootfsTags(profilesConfig, storageResolver))
+	Command.ValidArgsFunction = completion.RootfsTags(profilesConfig, storageResolver)
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, args []string) {
+	commandConfig.Tags = append(commandConfig.Tags, args...)
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("rm")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+		storageResolver.
+			WithConfigurationOverride(profile.GetMergedStorageConfig()).
+			WithTypeOverride(profile.Profile().StorageProvider)
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanRm := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("rm"))
+	cleanup.Add(func() {
+		spanRm.Finish()
+	})
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		runCache,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			rootLogger.Error("configuration is invalid", "reason", err)
+			spanRm.SetBaggageItem("error", err.Error())
+			return 1
+		}
+	}
+
+	arch, archErr := containers.PlatformArchitecture(commandConfig.Platform)
+	if archErr != nil {
+		rootLogger.Error("failed resolving platform architecture", "reason", archErr)
+		spanRm.SetBaggageItem("error", archErr.Error())
+		return 1
+	}
+
+	storageImpl, resolveErr := storageResolver.GetStorageImpl(rootLogger)
+	if resolveErr != nil {
+		rootLogger.Error("failed resolving storage provider", "reason", resolveErr)
+		spanRm.SetBaggageItem("error", resolveErr.Error())
+		return 1
+	}
+
+	spanRunning := tracer.StartSpan("rm-running-vmms", opentracing.ChildOf(spanRm.Context()))
+	runningVMMs := runningVMMsByTag(rootLogger, runCache.LocationRuns())
+	spanRunning.Finish()
+
+	exitCode := 0
+	totalFreedBytes := int64(0)
+
+	for _, tag := range commandConfig.Tags {
+
+		_, org, image, requestedVersion := utils.ReferenceDecompose(tag, storage.LatestVersion)
+		tagLogger := rootLogger.With("tag", tag)
+
+		// the latest version and the version constraint resolve to a stored version
+		// of the rootfs of the platform architecture:
+		rootfsLookup, lookupErr := storage.ResolveRootfsLookup(storageImpl, &storage.RootfsLookup{
+			Org:     org,
+			Image:   image,
+			Version: requestedVersion,
+			Arch:    arch,
+		})
+		if lookupErr != nil {
+			if errors.Is(lookupErr, storage.ErrRootfsNotFound) {
+				tagLogger.Error("rootfs not found")
+			} else {
+				tagLogger.Error("failed resolving rootfs version", "reason", lookupErr)
+			}
+			exitCode = 1
+			continue
+		}
+		version := rootfsLookup.Version
+		if version != requestedVersion {
+			tagLogger = tagLogger.With("version", version)
+		}
+
+		// the VMMs run the rootfs of the host architecture:
+		if vmmIDs, ok := runningVMMs[fmt.Sprintf("%s/%s:%s", org, image, version)]; ok && arch == runtime.GOARCH {
+			if !commandConfig.Force {
+				tagLogger.Error("rootfs is used by running VMMs, use --force to delete", "vmm-ids", vmmIDs)
+				exitCode = 1
+				continue
+			}
+			tagLogger.Warn("deleting rootfs used by running VMMs", "vmm-ids", vmmIDs)
+		}
+
+		spanDelete := tracer.StartSpan("rm-delete", opentracing.ChildOf(spanRm.Context()))
+		spanDelete.SetTag("tag", tag)
+
+		deleteResult, deleteErr := storageImpl.DeleteRootfs(rootfsLookup)
+		if deleteErr != nil {
+			if errors.Is(deleteErr, storage.ErrRootfsNotFound) {
+				tagLogger.Error("rootfs not found")
+			} else {
+				tagLogger.Error("failed deleting rootfs", "reason", deleteErr)
+			}
+			spanDelete.SetBaggageItem("error", deleteErr.Error())
+			spanDelete.Finish()
+			exitCode = 1
+			continue
+		}
+
+		spanDelete.Finish()
+
+		totalFreedBytes = totalFreedBytes + deleteResult.FreedBytes
+		tagLogger.Info("rootfs deleted", "provider", deleteResult.Provider, "freed-bytes", deleteResult.FreedBytes)
+	}
+
+	rootLogger.Info("freed bytes", "freed-bytes", totalFreedBytes)
+
+	return exitCode
+
+}
+
+// runningVMMsByTag returns the IDs of the running VMMs keyed by the rootfs tag they were started from.
+func runningVMMsByTag(logger hclog.Logger, runsDirectory string) map[string][]string {
+	result := map[string][]string{}
+	fileInfos, readDirErr := ioutil.ReadDir(runsDirectory)
+	if readDirErr != nil {
+		if !os.IsNotExist(readDirErr) {
+			logger.Error("error listing run cache directory", "reason", readDirErr)
+		}
+		return result
+	}
+	for _, fileInfo := range fileInfos {
+		vmmMetadata, hasMetadata, err := vmm.FetchMetadataIfExists(filepath.Join(runsDirectory, fileInfo.Name()))
+		if err != nil {
+			logger.Warn("metadata error for cache entry, skipping", "fs-entry", fileInfo.Name(), "reason", err)
+			continue
+		}
+		if !hasMetadata || vmmMetadata.Rootfs == nil {
+			continue
+		}
+		// a reused PID of a stopped VMM is not a running VMM:
+		running, err := vmm.IsAlive(vmmMetadata)
+		if err != nil {
+			logger.Warn("pid error for cache entry, skipping", "vmm-id", vmmMetadata.VMMID, "reason", err)
+			continue
+		}
+		if !running {
+			continue
+		}
+		tag := fmt.Sprintf("%s/%s:%s", vmmMetadata.Rootfs.Image.Org, vmmMetadata.Rootfs.Image.Image, vmmMetadata.Rootfs.Image.Version)
+		result[tag] = append(result[tag], vmmMetadata.VMMID)
+	}
+	return result
+}
@@ -13,10 +13,10 @@ import (
 	"github.com/combust-labs/firebuild-embedded-ca/ca"
 	"github.com/combust-labs/firebuild-mmds/mmds"
 	"github.com/combust-labs/firebuild-shared/build/commands"
-	"github.com/combust-labs/firebuild-shared/build/resources"
 	"github.com/combust-labs/firebuild-shared/build/rootfs"
 	"github.com/combust-labs/firebuild/configs"
 	"github.com/combust-labs/firebuild/pkg/build"
+	bcErrors "github.com/combust-labs/firebuild/pkg/build/errors"
 	"github.com/combust-labs/firebuild/pkg/build/reader"
 	"github.com/combust-labs/firebuild/pkg/build/stage"
 	"github.com/combust-labs/firebuild/pkg/containers"
@@ -32,7 +32,9 @@ import (
 	"github.com/combust-labs/firebuild/pkg/strategy/arbitrary"
 	"github.com/combust-labs/firebuild/pkg/utils"
 	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/combust-labs/firebuild/pkg/vmm/cni"
 	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/hashicorp/go-hclog"
 	"github.com/spf13/cobra"
 )
 
@@ -44,13 +46,19 @@ var Command = &cobra.Command{
 	Long:  ``,
 }
 
+// buildTmpfsHeadroom is the space added to the tmpfs on top of the kernel and rootfs sizes
+// to accommodate the jail log files and sockets.
+const buildTmpfsHeadroom = 64 * 1024 * 1024
+
 var (
 	cniConfig       = configs.NewCNIConfig()
 	commandConfig   = configs.NewRootfsCommandConfig()
+	dockerConfig    = configs.NewDockerConfig()
 	jailingFcConfig = configs.NewJailingFirecrackerConfig()
 	logConfig       = configs.NewLogginConfig()
 	machineConfig   = configs.NewMachineConfig()
 	profilesConfig  = configs.NewProfileCommandConfig()
+	retryConfig     = configs.NewRetryConfig()
 	runCache        = configs.NewRunCacheConfig()
 	tracingConfig   = configs.NewTracingConfig("firebuild-rootfs")
 
@@ -60,10 +68,12 @@ var (
 func initFlags() {
 	Command.Flags().AddFlagSet(cniConfig.FlagSet())
 	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(dockerConfig.FlagSet())
 	Command.Flags().AddFlagSet(jailingFcConfig.FlagSet())
 	Command.Flags().AddFlagSet(logConfig.FlagSet())
 	Command.Flags().AddFlagSet(machineConfig.FlagSet())
 	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(retryConfig.FlagSet())
 	Command.Flags().AddFlagSet(runCache.FlagSet())
 	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
 	// Storage provider flags:
@@ -91,7 +101,7 @@ func processCommand() int {
 			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
 			return 1
 		}
-		if err := profile.UpdateConfigs(jailingFcConfig, runCache, tracingConfig); err != nil {
+		if err := profile.UpdateConfigs(jailingFcConfig, machineConfig, runCache, tracingConfig); err != nil {
 			rootLogger.Error("error updating configuration from profile", "reason", err)
 			return 1
 		}
@@ -117,7 +127,9 @@ func processCommand() int {
 		spanBuild.Finish()
 	})
 
-	storageImpl, resolveErr := storageResolver.GetStorageImpl(rootLogger)
+	buildStats := metadata.NewMDRootfsBuildStats()
+
+	storageImpl, resolveErr := storageResolver.WithRetryConfig(retryConfig.Policy()).GetStorageImpl(rootLogger)
 	if resolveErr != nil {
 		rootLogger.Error("failed resolving storage provider", "reason", resolveErr)
 		spanBuild.SetBaggageItem("error", resolveErr.Error())
@@ -127,6 +139,8 @@ func processCommand() int {
 	validatingConfigs := []configs.ValidatingConfig{
 		jailingFcConfig,
 		commandConfig,
+		dockerConfig,
+		retryConfig,
 	}
 
 	for _, validatingConfig := range validatingConfigs {
@@ -137,18 +151,76 @@ func processCommand() int {
 		}
 	}
 
+	if err := cni.Prepare(cniConfig, machineConfig.CNINetworkName); err != nil {
+		rootLogger.Error("CNI setup invalid", "reason", err)
+		spanBuild.SetBaggageItem("error", err.Error())
+		return 1
+	}
+
 	if commandConfig.Tag == "" {
 		rootLogger.Error("--tag is required")
 		spanBuild.SetBaggageItem("error", "--tag is required")
 		return 1
 	}
 
+	// the rootfs is stored and recorded in the metadata under the normalized tag:
+	commandConfig.Tag = utils.NormalizeTag(commandConfig.Tag)
+
 	if !utils.IsValidTag(commandConfig.Tag) {
 		rootLogger.Error("--tag value is invalid", "tag", commandConfig.Tag)
 		spanBuild.SetBaggageItem("error", fmt.Errorf("--tag value is invalid: '%s'", commandConfig.Tag).Error())
 		return 1
 	}
 
+	if dockerConfig.Platform != "" {
+		platform, err := containers.NormalizePlatform(dockerConfig.Platform)
+		if err != nil {
+			rootLogger.Error("--platform is invalid", "reason", err)
+			spanBuild.SetBaggageItem("error", err.Error())
+			return 1
+		}
+		dockerConfig.Platform = platform
+		if err := containers.CheckBinfmtEmulation(platform); err != nil {
+			rootLogger.Error("platform can't be built on this host", "platform", platform, "host-platform", containers.HostPlatform(), "reason", err)
+			spanBuild.SetBaggageItem("error", err.Error())
+			return 1
+		}
+	}
+
+	// the base rootfs is looked up and the built rootfs is stored for the architecture of the platform:
+	buildArch, archErr := containers.PlatformArchitecture(dockerConfig.Platform)
+	if archErr != nil {
+		rootLogger.Error("--platform is invalid", "reason", archErr)
+		spanBuild.SetBaggageItem("error", archErr.Error())
+		return 1
+	}
+	spanBuild.SetTag("arch", buildArch)
+
+	if commandConfig.ValidateBootstrap {
+		spanValidateBootstrap := tracer.StartSpan("rootfs-validate-bootstrap", opentracing.ChildOf(spanBuild.Context()))
+		report, err := build.ValidateBootstrapTLS(bootstrapCAConfig(), jailingFcConfig.VMMID(), rootLogger.Named("embedded-ca"))
+		if err != nil {
+			rootLogger.Error("bootstrap TLS validation failed", "reason", err)
+			spanValidateBootstrap.SetBaggageItem("error", err.Error())
+			spanValidateBootstrap.Finish()
+			return 1
+		}
+		rootLogger.Info("bootstrap TLS validated",
+			"key-size", report.KeySize,
+			"chain-length", report.ChainLength,
+			"tls-version", report.TLSVersion,
+			"server-name", report.ServerName,
+			"not-before", report.NotBefore.UTC().Format(time.RFC3339),
+			"not-after", report.NotAfter.UTC().Format(time.RFC3339),
+			"handshake-duration", report.HandshakeDuration)
+		if commandConfig.BootstrapCertsValidity < commandConfig.BootstrapInitialCommunicationTimeout {
+			rootLogger.Warn("bootstrap certificates may expire before the guest connects, --bootstrap-certs-validity is shorter than --bootstrap-initial-communication-timeout",
+				"bootstrap-certs-validity", commandConfig.BootstrapCertsValidity,
+				"bootstrap-initial-communication-timeout", commandConfig.BootstrapInitialCommunicationTimeout)
+		}
+		spanValidateBootstrap.Finish()
+	}
+
 	spanTempDir := tracer.StartSpan("rootfs-temp-dir", opentracing.ChildOf(spanBuild.Context()))
 
 	// create cache directory:
@@ -172,21 +244,38 @@ func processCommand() int {
 
 	spanTempDir.Finish()
 
+	// the limit is shared by all concurrent phases of the build:
+	concurrencyLimit := build.NewConcurrencyLimit(commandConfig.Concurrency())
+
 	// -- Command specific:
 
 	if commandConfig.DockerImage != "" {
 		// prepare the build context based on the Docker image provided:
-		dockerClient, err := containers.GetDefaultClient()
+		dockerClient, err := containers.GetDefaultClientWithTimeout(dockerConfig.ClientTimeout)
 		if err != nil {
 			rootLogger.Error("failed fetching Docker client for image pull", "reason", err)
 			return 1
 		}
-		if err := containers.ImagePull(context.Background(), dockerClient, rootLogger, commandConfig.DockerImage); err != nil {
+		registryAuths, err := containers.ResolveRegistryAuths(dockerConfig.ConfigFile, dockerConfig.RegistryAuths, dockerConfig.RegistryTokens)
+		if err != nil {
+			rootLogger.Error("failed resolving registry credentials", "reason", err)
+			return 1
+		}
+		spanDockerImagePull := tracer.StartSpan("rootfs-docker-image-pull", opentracing.ChildOf(spanTempDir.Context()))
+		pullCtx, pullCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationPull, dockerConfig.PullTimeout)
+		defer pullCtxCancelFunc()
+		if err := containers.ImagePullWithRetry(pullCtx, dockerClient, rootLogger, commandConfig.DockerImage, dockerConfig.Platform, registryAuths, retryConfig.Policy()); err != nil {
 			rootLogger.Error("failed pulling Docker image", "image", commandConfig.DockerImage, "reason", err)
+			spanDockerImagePull.SetBaggageItem("error", err.Error())
+			spanDockerImagePull.Finish()
 			return 1
 		}
+		spanDockerImagePull.Finish()
+		buildStats.RecordPhase("docker-image-pull", tracing.SpanDuration(spanDockerImagePull))
 
-		imageMetadata, readErr := containers.ReadImageConfig(context.Background(), dockerClient, rootLogger, commandConfig.DockerImage)
+		readCtx, readCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationSave, dockerConfig.SaveTimeout)
+		defer readCtxCancelFunc()
+		imageMetadata, readErr := containers.ReadImageConfig(readCtx, dockerClient, rootLogger, commandConfig.DockerImage)
 		if readErr != nil {
 			rootLogger.Error("failed reading Docker image config", "image", commandConfig.DockerImage, "reason", readErr)
 			return 1
@@ -215,11 +304,18 @@ func processCommand() int {
 			exportResources = append(exportResources, imageExportResource)
 		}
 
-		_, exportErr := containers.ImageExportResources(context.Background(),
+		exportCtx, exportCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationSave, dockerConfig.SaveTimeout)
+		defer exportCtxCancelFunc()
+		if err := concurrencyLimit.Acquire(exportCtx); err != nil {
+			rootLogger.Error("failed exporting resources for Docker image", "image", commandConfig.DockerImage, "reason", err)
+			return 1
+		}
+		_, exportErr := containers.ImageExportResources(exportCtx,
 			dockerClient,
 			rootLogger,
 			cacheDirectory,
 			exportResources, commandConfig.DockerImage)
+		concurrencyLimit.Release()
 		if exportErr != nil {
 			rootLogger.Error("failed exporting resources for Docker image", "image", commandConfig.DockerImage, "reason", exportErr)
 			return 1
@@ -244,8 +340,15 @@ func processCommand() int {
 
 	spanReadStages := tracer.StartSpan("rootfs-read-stages", opentracing.ChildOf(spanParseDockerfile.Context()))
 
-	scs, errs := stage.ReadStages(readResults.Commands())
+	scs, errs := stage.ReadStagesWithBuildArgs(readResults.Commands(), commandConfig.BuildArgs)
 	for _, err := range errs {
+		// a COPY --from typo would fail the build only after the dependencies are built:
+		if copySourceErr, ok := err.(*bcErrors.UnknownCopySourceError); ok {
+			rootLogger.Error("invalid COPY --from", "reason", copySourceErr, "location", readResults.SourceLocations().Locate(copySourceErr.Command).String())
+			spanReadStages.SetBaggageItem("error", copySourceErr.Error())
+			spanReadStages.Finish()
+			return 1
+		}
 		rootLogger.Warn("stages read contained an error", "reason", err)
 	}
 
@@ -279,7 +382,25 @@ func processCommand() int {
 	spanBuildContext := tracer.StartSpan("rootfs-build-context", opentracing.ChildOf(spanReadStages.Context()))
 
 	// The first thing to do is to resolve the Dockerfile:
-	contextBuilder := build.NewDefaultBuild()
+	contextBuilder := build.NewDefaultBuild().
+		WithBuildArgs(commandConfig.BuildArgs).
+		WithExcludes(readResults.ExcludePatterns()).
+		WithMaxResourceSize(commandConfig.MaxResourceSize).
+		WithSourceLocations(readResults.SourceLocations())
+	// firebuild's own files in the build cache directory are never copied:
+	contextBuilder.WithInternalPaths(filepath.Join(cacheDirectory, "Dockerfile"),
+		filepath.Join(cacheDirectory, "sources"),
+		filepath.Join(cacheDirectory, naming.RootfsFileName))
+	for _, buildStage := range scs.All() {
+		if buildStage.Name() != "" {
+			contextBuilder.WithInternalPaths(build.DependencyExportsRoot(cacheDirectory, buildStage.Name()))
+		}
+		for _, dependency := range buildStage.DependsOn() {
+			if stage.IsExternalImage(scs, dependency) {
+				contextBuilder.WithInternalPaths(build.ImageExportsRoot(cacheDirectory, dependency))
+			}
+		}
+	}
 	if err := contextBuilder.AddInstructions(stageToBuild.Commands()...); err != nil {
 		rootLogger.Error("commands could not be processed", "reason", err)
 		spanBuildContext.SetBaggageItem("error", err.Error())
@@ -303,10 +424,22 @@ func processCommand() int {
 	}
 
 	// resolve dependencies:
-	dependencyResources := map[string][]resources.ResolvedResource{}
-	for _, stage := range scs.All() {
-		for _, dependency := range stage.DependsOn() {
-			if _, ok := dependencyResources[dependency]; !ok {
+	// the dependency stages are built from the same context as the main stage:
+	dependencyContextDirectory := readResults.ContextDirectory()
+	if dependencyContextDirectory == "" {
+		dependencyContextDirectory = filepath.Join(cacheDirectory, "sources")
+	}
+	// independent stages are built concurrently:
+	dependencyBuilders := map[string]build.DependencyBuild{}
+	// the COPY --from images are pulled and exported concurrently with the stage builds:
+	dependencyImages := map[string]bool{}
+	for _, buildStage := range scs.All() {
+		for _, dependency := range buildStage.DependsOn() {
+			if scs.NamedStage(dependency) == nil && stage.IsExternalImage(scs, dependency) {
+				dependencyImages[dependency] = true
+				continue
+			}
+			if _, ok := dependencyBuilders[dependency]; !ok {
 				dependencyStage := scs.NamedStage(dependency)
 				if dependencyStage == nil {
 					rootLogger.Error("main build stage depends on non-existent stage", "dependency", dependency)
@@ -314,22 +447,90 @@ func processCommand() int {
 					spanBuildContext.Finish()
 					return 1
 				}
-				spanDependencyBuild := tracer.StartSpan("rootfs-build-dependency", opentracing.ChildOf(spanBuildContext.Context()))
-				dependencyBuilder := build.NewDefaultDependencyBuild(dependencyStage, cacheDirectory, filepath.Join(cacheDirectory, "sources"))
-				resolvedResources, buildError := dependencyBuilder.Build(requiredCopies)
-				if buildError != nil {
-					rootLogger.Error("failed building stage dependency", "stage", stage.Name(), "dependency", dependency, "reason", buildError)
-					spanDependencyBuild.SetBaggageItem("error", buildError.Error())
-					spanDependencyBuild.Finish()
-					spanBuildContext.Finish()
-					return 1
+				dependencyBuilders[dependency] = build.NewDefaultDependencyBuild(dependencyStage, cacheDirectory, dependencyContextDirectory).
+					WithBuildID(jailingFcConfig.VMMID()).
+					WithDockerConfig(dockerConfig).
+					WithEscapeToken(readResults.EscapeToken()).
+					WithKeepContainers(commandConfig.KeepBuildContainers).
+					WithLogger(rootLogger.Named("dependency").With("stage", dependency))
+				if !commandConfig.NoStageCache {
+					dependencyBuilders[dependency].WithStageCacheDirectory(runCache.LocationStageCache())
 				}
-				dependencyResources[dependency] = resolvedResources
-				spanDependencyBuild.Finish()
 			}
 		}
 	}
 
+	postBuildCommands := []commands.Run{}
+	for _, cmd := range commandConfig.PostBuildCommands {
+		postBuildCommands = append(postBuildCommands, commands.RunWithDefaults(cmd))
+	}
+	preBuildCommands := []commands.Run{}
+	for _, cmd := range commandConfig.PreBuildCommands {
+		preBuildCommands = append(preBuildCommands, commands.RunWithDefaults(cmd))
+	}
+
+	if commandConfig.DryRun {
+		dependencies := []string{}
+		for dependency := range dependencyBuilders {
+			dependencies = append(dependencies, dependency)
+		}
+		for dependency := range dependencyImages {
+			dependencies = append(dependencies, dependency)
+		}
+		plan := planBuild(contextBuilder.
+			WithLogger(rootLogger.Named("builder")).
+			WithRetryConfig(retryConfig.Policy()).
+			WithPostBuildCommands(postBuildCommands...).
+			WithPreBuildCommands(preBuildCommands...),
+			stageToBuild.Commands(), requiredCopies, dependencies, storageImpl, machineConfig.VMLinuxID, buildArch)
+		spanBuildContext.Finish()
+		if err := printPlan(rootLogger, plan); err != nil {
+			rootLogger.Error("failed printing build plan", "reason", err)
+			return 1
+		}
+		if len(plan.Errors) > 0 {
+			spanBuild.SetBaggageItem("error", strings.Join(plan.Errors, "; "))
+			return 1
+		}
+		return 0
+	}
+
+	if len(dependencyImages) > 0 {
+		registryAuths, err := containers.ResolveRegistryAuths(dockerConfig.ConfigFile, dockerConfig.RegistryAuths, dockerConfig.RegistryTokens)
+		if err != nil {
+			rootLogger.Error("failed resolving registry credentials", "reason", err)
+			spanBuildContext.SetBaggageItem("error", err.Error())
+			spanBuildContext.Finish()
+			return 1
+		}
+		for dependency := range dependencyImages {
+			dependencyBuilders[dependency] = build.NewImageDependencyBuild(dependency, cacheDirectory, registryAuths, retryConfig.Policy()).
+				WithDockerConfig(dockerConfig).
+				WithLogger(rootLogger.Named("dependency").With("image", dependency))
+		}
+	}
+
+	spanDependencyBuild := tracer.StartSpan("rootfs-build-dependencies", opentracing.ChildOf(spanBuildContext.Context()))
+	spanDependencyBuild.SetTag("dependencies", len(dependencyBuilders))
+	spanDependencyBuild.SetTag("dependency-images", len(dependencyImages))
+	spanDependencyBuild.SetTag("max-parallel-stages", commandConfig.StageParallelism())
+	spanDependencyBuild.SetTag("max-concurrency", concurrencyLimit.Max())
+	dependencyResources, buildError := build.BuildDependencies(context.Background(), dependencyBuilders,
+		requiredCopies, commandConfig.StageParallelism(), concurrencyLimit)
+	if buildError != nil {
+		rootLogger.Error("failed building stage dependencies", "reason", buildError)
+		spanDependencyBuild.SetBaggageItem("error", buildError.Error())
+		spanDependencyBuild.Finish()
+		spanBuildContext.Finish()
+		return 1
+	}
+	spanDependencyBuild.Finish()
+	buildStats.RecordPhase("build-dependencies", tracing.SpanDuration(spanDependencyBuild))
+
+	if !commandConfig.NoStageCache && len(dependencyBuilders) > 0 {
+		evictStageCache(rootLogger)
+	}
+
 	spanBuildContext.Finish()
 
 	// -- Command specific // END
@@ -358,6 +559,7 @@ func processCommand() int {
 		Org:     structuredFrom.Org(),
 		Image:   structuredFrom.Image(),
 		Version: structuredFrom.Version(),
+		Arch:    buildArch,
 	})
 	if rootfsResolveErr != nil {
 		rootLogger.Error("failed resolving rootfs", "reason", rootfsResolveErr)
@@ -370,12 +572,99 @@ func processCommand() int {
 
 	spanResolveRootfs.Finish()
 
-	spanRootfsCopy := tracer.StartSpan("rootfs-copy", opentracing.ChildOf(spanResolveRootfs.Context()))
+	spanChrootBaseCheck := tracer.StartSpan("rootfs-chroot-base-check", opentracing.ChildOf(spanResolveRootfs.Context()))
+
+	if err := jailingFcConfig.ValidateChrootBaseForRootfs(resolvedRootfs.HostPath()); err != nil {
+		rootLogger.Error("chroot base can't hold the rootfs", "reason", err)
+		spanChrootBaseCheck.SetBaggageItem("error", err.Error())
+		spanChrootBaseCheck.Finish()
+		return 1
+	}
+
+	spanChrootBaseCheck.Finish()
+
+	// by default, the kernel is linked from the storage and the rootfs copy lives in the build cache:
+	buildWorkArea := cacheDirectory
+	buildKernel := resolvedKernel.HostPath()
+
+	if commandConfig.BuildOnTmpfs {
+		spanTmpfs := tracer.StartSpan("rootfs-tmpfs", opentracing.ChildOf(spanChrootBaseCheck.Context()))
+
+		rootfsStat, statErr := os.Stat(resolvedRootfs.HostPath())
+		if statErr != nil {
+			rootLogger.Error("failed checking resolved rootfs size", "reason", statErr)
+			spanTmpfs.SetBaggageItem("error", statErr.Error())
+			spanTmpfs.Finish()
+			return 1
+		}
+		kernelStat, statErr := os.Stat(resolvedKernel.HostPath())
+		if statErr != nil {
+			rootLogger.Error("failed checking resolved kernel size", "reason", statErr)
+			spanTmpfs.SetBaggageItem("error", statErr.Error())
+			spanTmpfs.Finish()
+			return 1
+		}
+
+		// the jailer hard links the kernel and the rootfs into the jail
+		// so both have to be placed on the same tmpfs as the jail:
+		tmpfsSize := rootfsStat.Size() + kernelStat.Size() + buildTmpfsHeadroom
+		memAvailable, memErr := utils.MemAvailable()
+		if memErr != nil {
+			rootLogger.Warn("failed checking available memory, building on disk", "reason", memErr)
+		} else if uint64(tmpfsSize) > memAvailable {
+			rootLogger.Warn("not enough memory for tmpfs, building on disk",
+				"required", tmpfsSize,
+				"available", memAvailable)
+		} else {
+			tmpfsDirectory := jailingFcConfig.JailerChrootDirectory()
+			if err := os.MkdirAll(tmpfsDirectory, 0755); err != nil {
+				rootLogger.Error("failed creating tmpfs mount point", "reason", err)
+				spanTmpfs.SetBaggageItem("error", err.Error())
+				spanTmpfs.Finish()
+				return 1
+			}
+			if err := utils.MountTmpfs(tmpfsDirectory, tmpfsSize); err != nil {
+				rootLogger.Error("failed mounting tmpfs", "mount-point", tmpfsDirectory, "reason", err)
+				spanTmpfs.SetBaggageItem("error", err.Error())
+				spanTmpfs.Finish()
+				return 1
+			}
+			cleanup.Add(func() {
+				span := tracer.StartSpan("rootfs-tmpfs-cleanup", opentracing.ChildOf(spanTmpfs.Context()))
+				rootLogger.Info("cleaning up tmpfs")
+				if err := utils.Umount(tmpfsDirectory); err != nil {
+					rootLogger.Info("tmpfs umount status", "error", err)
+					span.SetBaggageItem("error", err.Error())
+				}
+				if err := os.RemoveAll(tmpfsDirectory); err != nil {
+					rootLogger.Info("tmpfs mount point removal status", "error", err)
+					span.SetBaggageItem("error", err.Error())
+				}
+				span.Finish()
+			})
+			buildKernel = filepath.Join(tmpfsDirectory, filepath.Base(resolvedKernel.HostPath()))
+			if err := utils.CopyFile(resolvedKernel.HostPath(), buildKernel, utils.RootFSCopyBufferSize); err != nil {
+				rootLogger.Error("failed copying kernel to tmpfs",
+					"source", resolvedKernel.HostPath(),
+					"target", buildKernel,
+					"reason", err)
+				spanTmpfs.SetBaggageItem("error", err.Error())
+				spanTmpfs.Finish()
+				return 1
+			}
+			buildWorkArea = tmpfsDirectory
+			rootLogger.Info("building on tmpfs", "mount-point", tmpfsDirectory, "size", tmpfsSize)
+		}
+
+		spanTmpfs.Finish()
+	}
+
+	spanRootfsCopy := tracer.StartSpan("rootfs-copy", opentracing.ChildOf(spanChrootBaseCheck.Context()))
 
 	// we do need to copy the rootfs file to a temp directory
 	// because the jailer directory indeed links to the target rootfs
 	// and changes are persisted
-	buildRootfs := filepath.Join(cacheDirectory, naming.RootfsFileName)
+	buildRootfs := filepath.Join(buildWorkArea, naming.RootfsFileName)
 	if err := utils.CopyFile(resolvedRootfs.HostPath(), buildRootfs, utils.RootFSCopyBufferSize); err != nil {
 		rootLogger.Error("failed copying requested rootfs to temp build location",
 			"source", resolvedRootfs.HostPath(),
@@ -387,34 +676,30 @@ func processCommand() int {
 	}
 
 	spanRootfsCopy.Finish()
+	buildStats.RecordPhase("rootfs-copy", tracing.SpanDuration(spanRootfsCopy))
 
 	// don't use resolvedRootfs.HostPath() below this point:
 	machineConfig.
-		WithKernelOverride(resolvedKernel.HostPath()).
+		WithKernelOverride(buildKernel).
 		WithRootfsOverride(buildRootfs)
 
 	// gather the running vmm metadata:
 	runMetadata := &metadata.MDRun{
 		Type: metadata.MetadataTypeRun,
 	}
+	if !machineConfig.NoMMDS {
+		runMetadata.MMDSVersion = machineConfig.MMDSVersionOrDefault()
+	}
 
 	// --
 	// Prepare build context and start the build time server:
 	// --
 
-	postBuildCommands := []commands.Run{}
-	for _, cmd := range commandConfig.PostBuildCommands {
-		postBuildCommands = append(postBuildCommands, commands.RunWithDefaults(cmd))
-	}
-	preBuildCommands := []commands.Run{}
-	for _, cmd := range commandConfig.PreBuildCommands {
-		preBuildCommands = append(preBuildCommands, commands.RunWithDefaults(cmd))
-	}
-
 	spanWorkContext := tracer.StartSpan("rootfs-build-exec", opentracing.ChildOf(spanRootfsCopy.Context()))
 
 	executionCtx, buildErr := contextBuilder.
 		WithLogger(rootLogger.Named("builder")).
+		WithRetryConfig(retryConfig.Policy()).
 		WithPostBuildCommands(postBuildCommands...).
 		WithPreBuildCommands(preBuildCommands...).
 		CreateContext(dependencyResources)
@@ -430,13 +715,7 @@ func processCommand() int {
 
 	spanEmbeddedCA := tracer.StartSpan("embedded-ca-setup", opentracing.ChildOf(spanWorkContext.Context()))
 
-	embeddedCAConfig := &ca.EmbeddedCAConfig{
-		Addresses:     []string{jailingFcConfig.VMMID()},
-		CertsValidFor: commandConfig.BootstrapCertsValidity,
-		KeySize:       commandConfig.BootstrapCertsKeySize,
-	}
-
-	embeddedCA, caSetupErr := ca.NewDefaultEmbeddedCAWithLogger(embeddedCAConfig, rootLogger.Named("embedded-ca"))
+	embeddedCA, caSetupErr := ca.NewDefaultEmbeddedCAWithLogger(bootstrapCAConfig(), rootLogger.Named("embedded-ca"))
 	if caSetupErr != nil {
 		rootLogger.Error("failed setting up VM build embedded CA", "reason", caSetupErr)
 		spanEmbeddedCA.SetBaggageItem("error", caSetupErr.Error())
@@ -514,6 +793,11 @@ func processCommand() int {
 		return 1
 	}
 
+	// the guest verifies the certificates with its own clock, the window helps diagnosing the clock skew:
+	rootLogger.Info("bootstrap certificates issued",
+		"not-before", clientCertData.Certificate().NotBefore.UTC().Format(time.RFC3339),
+		"not-after", clientCertData.Certificate().NotAfter.UTC().Format(time.RFC3339))
+
 	spanClientTLSConfig.Finish()
 
 	spanRootfsBuildMetadata := tracer.StartSpan("rootfs-build-metadata", opentracing.ChildOf(spanClientTLSConfig.Context()))
@@ -596,6 +880,7 @@ func processCommand() int {
 	}
 
 	spanVMMStart.Finish()
+	buildStats.RecordPhase("vmm-start", tracing.SpanDuration(spanVMMStart))
 
 	spanBootstrapping := tracer.StartSpan("rootfs-boostrapping", opentracing.FollowsFrom(spanRootfsServerStart.Context()))
 
@@ -670,6 +955,7 @@ func processCommand() int {
 	}
 
 	spanBootstrapping.Finish()
+	buildStats.RecordPhase("bootstrap", tracing.SpanDuration(spanBootstrapping))
 
 	// --
 	// END / Waiting for bootstrap to complete
@@ -695,11 +981,31 @@ func processCommand() int {
 
 	buildEntrypointInfo := contextBuilder.EntrypointInfo()
 
+	attachments, attachmentsErr := metadata.NewMDRootfsAttachments(commandConfig.Attachments)
+	if attachmentsErr != nil {
+		vmmLogger.Error("failed reading rootfs attachments", "reason", attachmentsErr)
+		spanPersist.SetBaggageItem("error", attachmentsErr.Error())
+		spanPersist.Finish()
+		return 1
+	}
+
+	// the persist phase is not included, the stats are stored with the rootfs:
+	buildStats.DurationMs = time.Since(tracing.SpanStartTime(spanBuild)).Milliseconds()
+
 	fsFileName := filepath.Base(machineConfig.RootfsOverride())
 	createdRootfsFile := filepath.Join(jailingFcConfig.JailerChrootDirectory(), "root", fsFileName)
+	// the upload takes a slot of the limit shared by the phases of the build:
+	if err := concurrencyLimit.Acquire(context.Background()); err != nil {
+		vmmLogger.Error("failed storing built rootfs", "reason", err)
+		spanPersist.SetBaggageItem("error", err.Error())
+		spanPersist.Finish()
+		return 1
+	}
 	storeResult, storeErr := storageImpl.StoreRootfsFile(&storage.RootfsStore{
-		LocalPath: createdRootfsFile,
+		Attachments: commandConfig.Attachments,
+		LocalPath:   createdRootfsFile,
 		Metadata: metadata.MDRootfs{
+			Attachments: attachments,
 			BuildConfig: metadata.MDRootfsConfig{
 				BuildArgs:         commandConfig.BuildArgs,
 				Dockerfile:        commandConfig.Dockerfile,
@@ -708,6 +1014,7 @@ func processCommand() int {
 				PreBuildCommands:  commandConfig.PreBuildCommands,
 				PostBuildCommands: commandConfig.PostBuildCommands,
 			},
+			BuildStats:   buildStats,
 			CreatedAtUTC: time.Now().UTC().Unix(),
 			EntrypointInfo: &mmds.MMDSRootfsEntrypointInfo{
 				Cmd:        buildEntrypointInfo.Cmd.Values,
@@ -721,18 +1028,23 @@ func processCommand() int {
 				Org:     org,
 				Image:   name,
 				Version: version,
+				Arch:    buildArch,
 			},
-			Labels:  contextBuilder.Metadata(),
-			Parent:  resolvedRootfs.Metadata(),
-			Ports:   contextBuilder.ExposedPorts(),
-			Tag:     commandConfig.Tag,
-			Type:    metadata.MetadataTypeRootfs,
-			Volumes: contextBuilder.Volumes(),
+			Labels:         contextBuilder.Metadata(),
+			Parent:         resolvedRootfs.Metadata(),
+			Ports:          contextBuilder.ExposedPorts(),
+			ServiceEnvPath: commandConfig.ServiceEnvPath,
+			StopSignal:     buildEntrypointInfo.StopSignal.Value,
+			Tag:            commandConfig.Tag,
+			Type:           metadata.MetadataTypeRootfs,
+			Volumes:        contextBuilder.Volumes(),
 		},
 		Org:     org,
 		Image:   name,
 		Version: version,
+		Arch:    buildArch,
 	})
+	concurrencyLimit.Release()
 
 	if storeErr != nil {
 		vmmLogger.Error("failed storing built rootfs", "reason", storeErr)
@@ -748,3 +1060,34 @@ func processCommand() int {
 	return 0
 
 }
+
+// evictStageCache removes the cached stage dependency images according to the cache policy.
+// The eviction failure does not fail the build.
+func evictStageCache(logger hclog.Logger) {
+	client, clientErr := containers.GetDefaultClientWithTimeout(dockerConfig.ClientTimeout)
+	if clientErr != nil {
+		logger.Warn("failed creating Docker client for stage cache eviction", "reason", clientErr)
+		return
+	}
+	evictCtx, evictCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationInspect, dockerConfig.InspectTimeout)
+	defer evictCtxCancelFunc()
+	evicted, evictErr := build.EvictStageCache(evictCtx, client, logger, runCache.LocationStageCache(), build.StageCachePolicy{
+		MaxImages: commandConfig.StageCacheMaxImages,
+		TTL:       commandConfig.StageCacheTTL,
+	})
+	if evictErr != nil {
+		logger.Warn("failed evicting cached stage images", "reason", evictErr)
+		return
+	}
+	logger.Debug("stage cache evicted", "evicted", evicted)
+}
+
+// bootstrapCAConfig returns the configuration of the embedded CA issuing the bootstrap certificates.
+// The guest verifies the bootstrap server certificate with the VMM ID as the server name.
+func bootstrapCAConfig() *ca.EmbeddedCAConfig {
+	return &ca.EmbeddedCAConfig{
+		Addresses:     []string{jailingFcConfig.VMMID()},
+		CertsValidFor: commandConfig.BootstrapCertsValidity,
+		KeySize:       commandConfig.BootstrapCertsKeySize,
+	}
+}
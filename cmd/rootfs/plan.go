@@ -0,0 +1,215 @@
+package rootfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/combust-labs/firebuild-shared/build/rootfs"
+	"github.com/combust-labs/firebuild/pkg/build"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/hashicorp/go-hclog"
+)
+
+// buildPlan is the result of a dry run.
+type buildPlan struct {
+	Tag          string        `json:"Tag"`
+	Dockerfile   string        `json:"Dockerfile"`
+	Stage        string        `json:"Stage,omitempty"`
+	From         string        `json:"From"`
+	Dependencies []string      `json:"Dependencies"`
+	Kernel       *planArtifact `json:"Kernel"`
+	Rootfs       *planArtifact `json:"Rootfs"`
+	Steps        []*planStep   `json:"Steps"`
+	Errors       []string      `json:"Errors"`
+}
+
+// planArtifact is a kernel or a rootfs resolved from the storage.
+type planArtifact struct {
+	ID       string `json:"ID"`
+	HostPath string `json:"HostPath,omitempty"`
+	Error    string `json:"Error,omitempty"`
+}
+
+// planStep is an ADD, COPY or RUN command executed in the build VMM.
+type planStep struct {
+	Command   string   `json:"Command"`
+	Original  string   `json:"Original"`
+	Source    string   `json:"Source,omitempty"`
+	Target    string   `json:"Target,omitempty"`
+	Stage     string   `json:"Stage,omitempty"`
+	Resources []string `json:"Resources,omitempty"`
+	Run       string   `json:"Run,omitempty"`
+	User      string   `json:"User"`
+	Workdir   string   `json:"Workdir"`
+}
+
+// planBuild resolves what the build would do without building the stage dependencies
+// and without starting the build VMM. The base rootfs is resolved for the architecture. The resources of the COPY commands from
+// the dependency stages are not resolved, the stages are not built.
+func planBuild(contextBuilder build.Build, stageCommands []interface{}, requiredCopies []commands.Copy,
+	dependencies []string, storageImpl storage.Provider, kernelID, arch string) *buildPlan {
+
+	from := contextBuilder.From()
+	structuredFrom := from.ToStructuredFrom()
+	sort.Strings(dependencies)
+
+	plan := &buildPlan{
+		Tag:          commandConfig.Tag,
+		Dockerfile:   commandConfig.Dockerfile,
+		Stage:        commandConfig.DockerfileStage,
+		From:         from.BaseImage,
+		Dependencies: dependencies,
+		Kernel:       &planArtifact{ID: kernelID},
+		Rootfs:       &planArtifact{ID: fmt.Sprintf("%s/%s:%s", structuredFrom.Org(), structuredFrom.Image(), structuredFrom.Version())},
+		Steps:        []*planStep{},
+		Errors:       []string{},
+	}
+
+	if resolvedKernel, err := storageImpl.FetchKernel(&storage.KernelLookup{ID: kernelID}); err != nil {
+		plan.Kernel.Error = err.Error()
+		plan.Errors = append(plan.Errors, fmt.Sprintf("kernel %q not resolved: %v", kernelID, err))
+	} else {
+		plan.Kernel.HostPath = resolvedKernel.HostPath()
+	}
+
+	if resolvedRootfs, err := storageImpl.FetchRootfs(&storage.RootfsLookup{
+		Org:     structuredFrom.Org(),
+		Image:   structuredFrom.Image(),
+		Version: structuredFrom.Version(),
+		Arch:    arch,
+	}); err != nil {
+		plan.Rootfs.Error = err.Error()
+		plan.Errors = append(plan.Errors, fmt.Sprintf("base rootfs %q not resolved: %v", plan.Rootfs.ID, err))
+	} else {
+		plan.Rootfs.HostPath = resolvedRootfs.HostPath()
+	}
+
+	// the sources not found are skipped by the build context, check them first:
+	resolver := resources.NewDefaultResolver()
+	for _, stageCommand := range stageCommands {
+		var resolved []resources.ResolvedResource
+		var err error
+		var original, source string
+		switch tcommand := stageCommand.(type) {
+		case commands.Add:
+			original, source = tcommand.OriginalCommand, tcommand.Source
+			resolved, err = resolver.ResolveAdd(tcommand)
+		case commands.Copy:
+			if tcommand.Stage != "" {
+				continue
+			}
+			original, source = tcommand.OriginalCommand, tcommand.Source
+			resolved, err = resolver.ResolveCopy(tcommand)
+		default:
+			continue
+		}
+		if err != nil {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("%s: source %q not resolved: %v", original, source, err))
+		} else if len(resolved) == 0 {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("%s: source %q not found", original, source))
+		}
+	}
+
+	// the dependency stages are not built, their resources are placeholders:
+	dependencyResources := make(rootfs.Resources)
+	for _, copy := range requiredCopies {
+		dependencyResources[copy.Stage] = append(dependencyResources[copy.Stage],
+			resources.NewResolvedFileResourceWithPath(func() (io.ReadCloser, error) {
+				return nil, fmt.Errorf("dependency stage resource not built in dry run")
+			}, 0644, copy.Source, copy.Target, copy.Workdir, copy.User, ""))
+	}
+
+	workContext, err := contextBuilder.CreateContext(dependencyResources)
+	if err != nil {
+		plan.Errors = append(plan.Errors, err.Error())
+		return plan
+	}
+
+	for _, executable := range workContext.ExecutableCommands {
+		switch tcommand := executable.(type) {
+		case commands.Add:
+			plan.Steps = append(plan.Steps, &planStep{
+				Command:   "ADD",
+				Original:  tcommand.OriginalCommand,
+				Source:    tcommand.Source,
+				Target:    tcommand.Target,
+				Resources: resolvedPaths(workContext.ResourcesResolved[tcommand.Source]),
+				User:      userOf(tcommand.User, tcommand.UserFromLocalChown),
+				Workdir:   tcommand.Workdir.Value,
+			})
+		case commands.Copy:
+			plan.Steps = append(plan.Steps, &planStep{
+				Command:   "COPY",
+				Original:  tcommand.OriginalCommand,
+				Source:    tcommand.Source,
+				Target:    tcommand.Target,
+				Stage:     tcommand.Stage,
+				Resources: resolvedPaths(workContext.ResourcesResolved[tcommand.Source]),
+				User:      userOf(tcommand.User, tcommand.UserFromLocalChown),
+				Workdir:   tcommand.Workdir.Value,
+			})
+		case commands.Run:
+			plan.Steps = append(plan.Steps, &planStep{
+				Command:  "RUN",
+				Original: tcommand.OriginalCommand,
+				Run:      tcommand.Command,
+				User:     tcommand.User.Value,
+				Workdir:  tcommand.Workdir.Value,
+			})
+		}
+	}
+
+	return plan
+}
+
+// printPlan prints the plan as JSON to stdout or as log lines.
+func printPlan(logger hclog.Logger, plan *buildPlan) error {
+	if commandConfig.Output == "json" {
+		bytes, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(bytes))
+		return nil
+	}
+	logger.Info("plan", "tag", plan.Tag, "dockerfile", plan.Dockerfile, "from", plan.From, "dependencies", plan.Dependencies)
+	logger.Info("kernel", "id", plan.Kernel.ID, "host-path", plan.Kernel.HostPath)
+	logger.Info("base rootfs", "id", plan.Rootfs.ID, "host-path", plan.Rootfs.HostPath)
+	for idx, step := range plan.Steps {
+		stepLogger := logger.With("step", idx+1, "command", step.Command, "user", step.User, "workdir", step.Workdir)
+		if step.Command == "RUN" {
+			stepLogger.Info("step", "run", step.Run)
+			continue
+		}
+		if step.Stage != "" {
+			stepLogger.Info("step", "source", step.Source, "target", step.Target, "stage", step.Stage)
+			continue
+		}
+		stepLogger.Info("step", "source", step.Source, "target", step.Target, "resources", step.Resources)
+	}
+	for _, planError := range plan.Errors {
+		logger.Error("plan error", "reason", planError)
+	}
+	return nil
+}
+
+func resolvedPaths(input []resources.ResolvedResource) []string {
+	output := []string{}
+	for _, resource := range input {
+		if resource.ResolvedURIOrPath() != "" {
+			output = append(output, resource.ResolvedURIOrPath())
+		}
+	}
+	return output
+}
+
+func userOf(user commands.User, fromLocalChown *commands.User) string {
+	if fromLocalChown != nil {
+		return fromLocalChown.Value
+	}
+	return user.Value
+}
@@ -2,17 +2,25 @@ package run
 
 import (
 	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/combust-labs/firebuild-shared/build/commands"
 	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
 	"github.com/combust-labs/firebuild/pkg/fw"
 	"github.com/combust-labs/firebuild/pkg/metadata"
 	"github.com/combust-labs/firebuild/pkg/naming"
 	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/remote"
 	"github.com/combust-labs/firebuild/pkg/storage"
 	"github.com/combust-labs/firebuild/pkg/storage/resolver"
 	"github.com/combust-labs/firebuild/pkg/strategy"
@@ -20,9 +28,13 @@ import (
 	"github.com/combust-labs/firebuild/pkg/tracing"
 	"github.com/combust-labs/firebuild/pkg/utils"
 	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/combust-labs/firebuild/pkg/vmm/cni"
+	"github.com/combust-labs/firebuild/pkg/vmm/drives"
+	"github.com/combust-labs/firebuild/pkg/vmm/passthrough"
 	"github.com/firecracker-microvm/firecracker-go-sdk"
 	"github.com/hashicorp/go-hclog"
 	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -41,12 +53,16 @@ var (
 	logConfig       = configs.NewLogginConfig()
 	machineConfig   = configs.NewMachineConfig()
 	profilesConfig  = configs.NewProfileCommandConfig()
+	retryConfig     = configs.NewRetryConfig()
 	runCache        = configs.NewRunCacheConfig()
 	tracingConfig   = configs.NewTracingConfig("firebuild-vmm-run")
 
 	storageResolver = resolver.NewDefaultResolver()
 )
 
+// randomSeedSize is the size of the random seed in bytes, the size of the systemd random seed.
+const randomSeedSize = 512
+
 func initFlags() {
 	Command.Flags().AddFlagSet(cniConfig.FlagSet())
 	Command.Flags().AddFlagSet(commandConfig.FlagSet())
@@ -54,10 +70,13 @@ func initFlags() {
 	Command.Flags().AddFlagSet(logConfig.FlagSet())
 	Command.Flags().AddFlagSet(machineConfig.FlagSet())
 	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(retryConfig.FlagSet())
 	Command.Flags().AddFlagSet(runCache.FlagSet())
 	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
 	// Storage provider flags:
 	resolver.AddStorageFlags(Command.Flags())
+	// Dynamic completion:
+	Command.RegisterFlagCompletionFunc("from", completion.RootfsTags(profilesConfig, storageResolver))
 }
 
 func init() {
@@ -88,7 +107,7 @@ func processCommand(args []string) int {
 			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
 			return 1
 		}
-		if err := profile.UpdateConfigs(jailingFcConfig, runCache, tracingConfig); err != nil {
+		if err := profile.UpdateConfigs(jailingFcConfig, machineConfig, runCache, tracingConfig); err != nil {
 			rootLogger.Error("error updating configuration from profile", "reason", err)
 			return 1
 		}
@@ -101,6 +120,7 @@ func processCommand(args []string) int {
 		commandConfig,
 		jailingFcConfig,
 		machineConfig,
+		retryConfig,
 		runCache,
 	}
 
@@ -111,6 +131,11 @@ func processCommand(args []string) int {
 		}
 	}
 
+	if err := cni.Prepare(cniConfig, machineConfig.CNINetworkName); err != nil {
+		rootLogger.Error("CNI setup invalid", "reason", err)
+		return 1
+	}
+
 	// explicitly name the VM, if name given:
 	if commandConfig.Name != "" {
 		jailingFcConfig.WithVMMID(commandConfig.Name)
@@ -127,6 +152,82 @@ func processCommand(args []string) int {
 		}
 		exposedPorts = append(exposedPorts, port)
 	}
+	for _, port := range exposedPorts {
+		if commandConfig.PublishIPv4Only && port.Family() == fw.FamilyIPv6 {
+			rootLogger.Error("exposed port input is invalid", "reason", "IPv6 host address given with --publish-ipv4-only", "port", port.String())
+			return 1
+		}
+	}
+	if err := fw.CheckPrivilegedPorts(exposedPorts, commandConfig.AllowPrivilegedPorts); err != nil {
+		rootLogger.Error("exposed port input is invalid", "reason", err)
+		return 1
+	}
+	for _, port := range exposedPorts {
+		if fw.IsPrivileged(port) {
+			rootLogger.Warn("publishing privileged host port, binding requires elevated privileges", "port", port.String())
+		}
+	}
+
+	egressPolicy, egressErr := vmm.EgressPolicy(commandConfig)
+	if egressErr != nil {
+		rootLogger.Error("egress policy input is invalid", "reason", egressErr)
+		return 1
+	}
+
+	attachedDrives, drivesErr := drives.ParseAll(commandConfig.Drives)
+	if drivesErr != nil {
+		rootLogger.Error("drive input is invalid", "reason", drivesErr)
+		return 1
+	}
+
+	// the jailer drops the privileges so the devices must be accessible by the jailer UID and GID:
+	passthroughDevices, passthroughErr := passthrough.ResolveAll(machineConfig.PassthroughDevices,
+		jailingFcConfig.JailerUID, jailingFcConfig.JailerGID)
+	if passthroughErr != nil {
+		rootLogger.Error("passthrough device is invalid", "reason", passthroughErr)
+		return 1
+	}
+
+	// resolve the SSH public keys before the VMM is started,
+	// the keys imported from GitHub require network access:
+	publicKeys, publicKeysErr := commandConfig.PublicKeys()
+	if publicKeysErr != nil {
+		rootLogger.Error("failed resolving SSH public keys", "reason", publicKeysErr)
+		return 1
+	}
+	rootLogger.Debug("resolved SSH public keys", "count", len(publicKeys))
+	userPublicKeys, userPublicKeysErr := commandConfig.UserPublicKeys(machineConfig.SSHUser)
+	if userPublicKeysErr != nil {
+		rootLogger.Error("failed resolving user SSH public keys", "reason", userPublicKeysErr)
+		return 1
+	}
+	for user, keys := range userPublicKeys {
+		rootLogger.Debug("resolved user SSH public keys", "user", user, "count", len(keys))
+	}
+
+	// provisioning connects with a key generated for this run only:
+	var provisionPrivateKey []byte
+	if len(commandConfig.Provision) > 0 {
+		if machineConfig.SSHUser == "" {
+			rootLogger.Error("configuration is invalid", "reason", "--provision requires --ssh-user")
+			return 1
+		}
+		privateKey, err := utils.GenerateRSAPrivateKey(utils.RSABitSize)
+		if err != nil {
+			rootLogger.Error("failed generating provisioning SSH key", "reason", err)
+			return 1
+		}
+		publicKey, err := utils.GetSSHKey(privateKey)
+		if err != nil {
+			rootLogger.Error("failed generating provisioning SSH key", "reason", err)
+			return 1
+		}
+		if err := commandConfig.AddPublicKey(publicKey); err != nil {
+			rootLogger.Error("failed adding provisioning SSH key", "reason", err)
+			return 1
+		}
+		provisionPrivateKey = utils.EncodePrivateKeyToPEM(privateKey)
+	}
 
 	// tracing:
 
@@ -146,7 +247,7 @@ func processCommand(args []string) int {
 	defer spanRun.Finish()
 
 	// storage:
-	storageImpl, resolveErr := storageResolver.GetStorageImpl(rootLogger)
+	storageImpl, resolveErr := storageResolver.WithRetryConfig(retryConfig.Policy()).GetStorageImpl(rootLogger)
 	if resolveErr != nil {
 		spanRun.SetBaggageItem("error", resolveErr.Error())
 		rootLogger.Error("failed resolving storage provider", "reason", resolveErr)
@@ -201,13 +302,27 @@ func processCommand(args []string) int {
 	spanResolveRootfs := tracer.StartSpan("run-resolve-rootfs", opentracing.ChildOf(spanResolveKernel.Context()))
 
 	// resolve rootfs:
-	from := commands.From{BaseImage: commandConfig.From}
+	from := commands.From{BaseImage: utils.TagWithDefaultVersion(utils.NormalizeTag(commandConfig.From), storage.LatestVersion)}
 	structuredFrom := from.ToStructuredFrom()
-	resolvedRootfs, rootfsResolveErr := storageImpl.FetchRootfs(&storage.RootfsLookup{
+	// the VMM runs on the host, the rootfs is resolved for the architecture of the host;
+	// the latest version and the version constraint resolve to a stored version:
+	rootfsLookup, lookupErr := storage.ResolveRootfsLookup(storageImpl, &storage.RootfsLookup{
 		Org:     structuredFrom.Org(),
 		Image:   structuredFrom.Image(),
 		Version: structuredFrom.Version(),
+		Arch:    runtime.GOARCH,
 	})
+	if lookupErr != nil {
+		rootLogger.Error("failed resolving rootfs version", "reason", lookupErr)
+		spanResolveRootfs.SetBaggageItem("error", lookupErr.Error())
+		spanResolveRootfs.Finish()
+		return 1
+	}
+	if rootfsLookup.Version != structuredFrom.Version() {
+		rootLogger.Info("rootfs version resolved", "requested", structuredFrom.Version(), "version", rootfsLookup.Version)
+		spanResolveRootfs.SetTag("version", rootfsLookup.Version)
+	}
+	resolvedRootfs, rootfsResolveErr := storageImpl.FetchRootfs(rootfsLookup)
 	if rootfsResolveErr != nil {
 		rootLogger.Error("failed resolving rootfs", "reason", rootfsResolveErr)
 		spanResolveRootfs.SetBaggageItem("error", rootfsResolveErr.Error())
@@ -215,6 +330,16 @@ func processCommand(args []string) int {
 		return 1
 	}
 
+	if commandConfig.Verify {
+		if verifyErr := storage.VerifyRootfs(resolvedRootfs); verifyErr != nil {
+			rootLogger.Error("rootfs verification failed", "reason", verifyErr, "host-path", resolvedRootfs.HostPath())
+			spanResolveRootfs.SetBaggageItem("error", verifyErr.Error())
+			spanResolveRootfs.Finish()
+			return 1
+		}
+		rootLogger.Info("rootfs verified", "host-path", resolvedRootfs.HostPath())
+	}
+
 	spanResolveRootfs.Finish()
 
 	spanRootfsMetadata := tracer.StartSpan("run-rootfs-metadata", opentracing.ChildOf(spanResolveRootfs.Context()))
@@ -228,13 +353,66 @@ func processCommand(args []string) int {
 		return 1
 	}
 
+	// ports given without a protocol default to the protocol exposed by the rootfs:
+	rootfsExposedPorts := []fw.ExposedPort{}
+	for _, rootfsPort := range mdRootfs.Ports {
+		port, portParseErr := fw.ExposedPortFromString(rootfsPort)
+		if portParseErr != nil {
+			rootLogger.Warn("rootfs exposed port could not be parsed, ignoring", "reason", portParseErr, "raw-input", rootfsPort)
+			continue
+		}
+		rootfsExposedPorts = append(rootfsExposedPorts, port)
+	}
+	exposedPorts = fw.WithExposedProtocols(exposedPorts, rootfsExposedPorts)
+
+	// store the resolved ports so the ports are unpublished with the same protocol:
+	commandConfig.Ports = []string{}
+	for _, port := range exposedPorts {
+		commandConfig.Ports = append(commandConfig.Ports, port.String())
+	}
+
 	spanRootfsMetadata.Finish()
 
-	spanRootfsCopy := tracer.StartSpan("run-rootfs-copy", opentracing.ChildOf(spanRootfsMetadata.Context()))
+	spanChrootBaseCheck := tracer.StartSpan("run-chroot-base-check", opentracing.ChildOf(spanRootfsMetadata.Context()))
+
+	if err := jailingFcConfig.ValidateChrootBaseForRootfs(resolvedRootfs.HostPath()); err != nil {
+		rootLogger.Error("chroot base can't hold the rootfs", "reason", err)
+		spanChrootBaseCheck.SetBaggageItem("error", err.Error())
+		spanChrootBaseCheck.Finish()
+		return 1
+	}
+
+	spanChrootBaseCheck.Finish()
+
+	if len(attachedDrives) > 0 {
+		spanDrivesPrepare := tracer.StartSpan("run-drives-prepare", opentracing.ChildOf(spanChrootBaseCheck.Context()))
+		// the missing drive files are created on first use, the jailer links the drives into the jail:
+		for _, drive := range attachedDrives {
+			if err := drive.Prepare(); err != nil {
+				rootLogger.Error("failed preparing drive", "reason", err, "drive", drive.String())
+				spanDrivesPrepare.SetBaggageItem("error", err.Error())
+				spanDrivesPrepare.Finish()
+				return 1
+			}
+			if drive.Created {
+				rootLogger.Info("drive created", "host-path", drive.HostPath, "size-bytes", drive.SizeBytes)
+			}
+			if err := drive.CheckLinkable(utils.ClosestExistingDirectory(jailingFcConfig.ChrootBase)); err != nil {
+				rootLogger.Error("drive can't be attached", "reason", err, "drive", drive.String())
+				spanDrivesPrepare.SetBaggageItem("error", err.Error())
+				spanDrivesPrepare.Finish()
+				return 1
+			}
+		}
+		spanDrivesPrepare.Finish()
+	}
+
+	spanRootfsCopy := tracer.StartSpan("run-rootfs-copy", opentracing.ChildOf(spanChrootBaseCheck.Context()))
 
 	// we do need to copy the rootfs file to a temp directory
 	// because the jailer directory indeed links to the target rootfs
-	// and changes are persisted
+	// and changes are persisted, the VMMs started concurrently
+	// from the same rootfs never share the rootfs file
 	runRootfs := filepath.Join(cacheDirectory, naming.RootfsFileName)
 	if err := utils.CopyFile(resolvedRootfs.HostPath(), runRootfs, utils.RootFSCopyBufferSize); err != nil {
 		rootLogger.Error("failed copying requested rootfs to temp build location",
@@ -248,6 +426,18 @@ func processCommand(args []string) int {
 
 	spanRootfsCopy.Finish()
 
+	if commandConfig.RandomSeed {
+		spanRandomSeed := tracer.StartSpan("run-random-seed", opentracing.ChildOf(spanRootfsCopy.Context()))
+		if err := injectRandomSeed(runRootfs, cacheDirectory); err != nil {
+			rootLogger.Error("failed writing random seed to the rootfs", "reason", err)
+			spanRandomSeed.SetBaggageItem("error", err.Error())
+			spanRandomSeed.Finish()
+			return 1
+		}
+		rootLogger.Info("random seed written to the rootfs", "paths", commandConfig.RandomSeedPaths)
+		spanRandomSeed.Finish()
+	}
+
 	// get the veth interface name and write to also to a file:
 	vethIfaceName := naming.GetRandomVethName()
 	spanRun.SetTag("ifname", vethIfaceName)
@@ -273,9 +463,15 @@ func processCommand(args []string) int {
 			Machine:   machineConfig,
 			RunConfig: commandConfig,
 		},
-		Rootfs:   mdRootfs,
-		RunCache: cacheDirectory,
-		Type:     metadata.MetadataTypeRun,
+		AttachedDrives:     attachedDrives,
+		Labels:             commandConfig.VMLabels,
+		PassthroughDevices: passthroughDevices,
+		Rootfs:             mdRootfs,
+		RunCache:           cacheDirectory,
+		Type:               metadata.MetadataTypeRun,
+	}
+	if !machineConfig.NoMMDS {
+		runMetadata.MMDSVersion = machineConfig.MMDSVersionOrDefault()
 	}
 
 	vmmStrategy := configs.DefaultFirectackerStrategy(machineConfig).
@@ -285,12 +481,25 @@ func processCommand(args []string) int {
 			return arbitrary.NewHandlerPlacement(strategy.
 				NewMetadataExtractorHandler(rootLogger, runMetadata), firecracker.CreateBootSourceHandlerName)
 		})
+	if egressPolicy != nil {
+		// the policy is in place before the instance starts, the guest addresses come from the extracted metadata:
+		vmmStrategy = vmmStrategy.AddRequirements(func() *arbitrary.HandlerPlacement {
+			return arbitrary.NewHandlerPlacement(vmm.NewEgressPolicyHandler(runMetadata, egressPolicy),
+				strategy.MetadataExtractorName)
+		})
+	}
 
 	spanVMMCreate := tracer.StartSpan("run-vmm-create", opentracing.ChildOf(spanRootfsCopy.Context()))
 
+	// boot failures are often visible only on the serial console:
+	consoleCapture := vmm.NewConsoleCapture(commandConfig.ConsoleCaptureLines)
 	vmmProvider := vmm.NewDefaultProvider(cniConfig, jailingFcConfig, machineConfig).
+		WithDrives(attachedDrives).
 		WithHandlersAdapter(vmmStrategy).
 		WithVethIfaceName(vethIfaceName)
+	if commandConfig.ConsoleCaptureLines > 0 {
+		vmmProvider = vmmProvider.WithConsoleOutput(consoleCapture)
+	}
 
 	vmmCtx, vmmCancel := context.WithCancel(context.Background())
 	cleanup.Add(func() {
@@ -309,10 +518,16 @@ func processCommand(args []string) int {
 		span.Finish()
 	})
 
+	egressCleanupFunc := func() {
+		vmm.RemoveEgressPolicy(rootLogger, runMetadata)
+	}
+
 	spanVMMStart := tracer.StartSpan("run-vmm-start", opentracing.ChildOf(spanVMMCreate.Context()))
 
 	startedMachine, runErr := vmmProvider.Start(vmmCtx)
 	if runErr != nil {
+		egressCleanupFunc()
+		runErr = consoleCapture.WrapError(runErr)
 		vmmLogger.Error("firecracker VMM did not start, run failed", "reason", runErr)
 		spanVMMStart.SetBaggageItem("error", runErr.Error())
 		spanVMMStart.Finish()
@@ -324,7 +539,7 @@ func processCommand(args []string) int {
 	metadataErr := startedMachine.DecorateMetadata(runMetadata)
 	if metadataErr != nil {
 		startedMachine.Stop(vmmCtx)
-		vmmLogger.Error("Failed fetching machine metadata", "reason", metadataErr)
+		vmmLogger.Error("Failed fetching machine metadata", "reason", consoleCapture.WrapError(metadataErr))
 		return 1
 	}
 
@@ -333,25 +548,26 @@ func processCommand(args []string) int {
 
 	spanVMMStarted := tracer.StartSpan("run-vmm-started", opentracing.ChildOf(spanVMMStart.Context()))
 
-	portsCleanupFunc := func() {}
 	if len(commandConfig.Ports) > 0 {
 		// on error, do not fail the complete command, just let it roll
-		portsManager, managerErr := fw.NewManager(jailingFcConfig.VMMID(),
-			runMetadata.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IP)
+		portsManager, managerErr := fw.NewManager(jailingFcConfig.VMMID(), runMetadata.PublishAddresses()...)
 		if managerErr != nil {
 			rootLogger.Warn("ports not published, handling iptables failed", "reason", managerErr)
 		} else {
 			if err := portsManager.Publish(exposedPorts); err != nil {
 				rootLogger.Warn("port publishing failed", "reason", err)
-			} else {
-				portsCleanupFunc = func() {
-					if err := portsManager.Unpublish(exposedPorts); err != nil {
-						rootLogger.Warn("port cleanup failed", "reason", err)
-					}
-				}
 			}
 		}
 	}
+	// the ports can be changed with the publish command while the VMM runs:
+	portsCleanupFunc := func() {
+		vmm.UnpublishPorts(rootLogger, runMetadata)
+	}
+
+	if runMetadata.Egress != nil {
+		vmmLogger.Info("egress policy applied", "allow", runMetadata.Egress.Allow,
+			"deny", runMetadata.Egress.Deny, "default", runMetadata.Egress.Default)
+	}
 
 	if err := vmm.WriteMetadataToFile(runMetadata); err != nil {
 		vmmLogger.Error("failed writing machine metadata to file", "reason", err, "metadata", runMetadata)
@@ -359,6 +575,25 @@ func processCommand(args []string) int {
 
 	spanVMMStarted.Finish()
 
+	if len(commandConfig.Provision) > 0 {
+		spanProvision := tracer.StartSpan("run-vmm-provision", opentracing.ChildOf(spanVMMStarted.Context()))
+		if err := provision(vmmLogger, runMetadata, provisionPrivateKey); err != nil {
+			err = consoleCapture.WrapError(err)
+			spanProvision.SetBaggageItem("error", err.Error())
+			spanProvision.Finish()
+			if !commandConfig.ProvisionBestEffort {
+				vmmLogger.Error("provisioning failed, stopping VMM", "reason", err)
+				portsCleanupFunc()
+				egressCleanupFunc()
+				startedMachine.Stop(vmmCtx)
+				return 1
+			}
+			vmmLogger.Warn("provisioning failed, VMM keeps running", "reason", err)
+		} else {
+			spanProvision.Finish()
+		}
+	}
+
 	if commandConfig.Daemonize {
 		vmmLogger.Info("VMM running as a daemon",
 			"jailer-dir", jailingFcConfig.JailerChrootDirectory(),
@@ -368,16 +603,21 @@ func processCommand(args []string) int {
 	}
 
 	cleanup.Add(portsCleanupFunc)
+	cleanup.Add(egressCleanupFunc)
 
 	vmmLogger.Info("VMM running",
 		"jailer-dir", jailingFcConfig.JailerChrootDirectory(),
 		"cache-dir", cacheDirectory)
 
-	chanStopStatus := installSignalHandlers(context.Background(), vmmLogger, startedMachine)
+	chanStopStatus := installSignalHandlers(context.Background(), vmmLogger.With("guest-stop-signal", mdRootfs.GuestStopSignal()), startedMachine)
 
 	spanVMMStop := tracer.StartSpan("run-vmm-stop", opentracing.ChildOf(spanVMMStarted.Context()))
 
 	startedMachine.Wait(context.Background())
+	if !startedMachine.Stopped() {
+		vmmLogger.Warn("VMM exited without a stop request",
+			"console-output", strings.Join(consoleCapture.Lines(), "\n"))
+	}
 	startedMachine.Cleanup(chanStopStatus)
 
 	vmmLogger.Info("machine is stopped", "gracefully", <-chanStopStatus)
@@ -398,6 +638,8 @@ func installSignalHandlers(ctx context.Context, logger hclog.Logger, m vmm.Start
 		for {
 			switch s := <-c; {
 			case s == syscall.SIGTERM || s == os.Interrupt:
+				// the guest service manager stops the main process
+				// with the signal delivered to the guest via MMDS:
 				logger.Info("Caught SIGINT, requesting clean shutdown")
 				chanStopped <- m.Stop(ctx)
 			}
@@ -405,3 +647,57 @@ func installSignalHandlers(ctx context.Context, logger hclog.Logger, m vmm.Start
 	}()
 	return chanStopped
 }
+
+// provision runs the provisioning scripts in order over SSH, once the VMM accepts the SSH connections.
+// The host key is added to the known hosts file of the VMM run so later connections can verify it.
+func provision(logger hclog.Logger, runMetadata *metadata.MDRun, privateKey []byte) error {
+	connectConfig, err := remote.ConnectConfigFromRunMetadata(runMetadata)
+	if err != nil {
+		return err
+	}
+	connectConfig.PrivateKey = privateKey
+	connectConfig.KnownHostsFile = filepath.Join(runMetadata.RunCache, "known_hosts")
+	connectConfig.Timeout = time.Second * 10
+
+	logger.Info("waiting for SSH", "timeout", commandConfig.ProvisionTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), commandConfig.ProvisionTimeout)
+	defer cancel()
+	connected, err := remote.ConnectWithRetry(ctx, connectConfig, retryConfig.Policy())
+	if err != nil {
+		return err
+	}
+	defer connected.Close()
+
+	for _, script := range commandConfig.Provision {
+		logger.Info("running provisioning script", "script", script)
+		exitCode, err := remote.RunScript(connected, script, os.Stdout, os.Stderr)
+		if err != nil {
+			return errors.Wrapf(err, "provisioning script '%s' failed", script)
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("provisioning script '%s' exited with code %d", script, exitCode)
+		}
+	}
+	logger.Info("provisioning finished", "scripts", len(commandConfig.Provision))
+	return nil
+}
+
+// injectRandomSeed writes fresh random bytes generated on the host to the random seed files of the rootfs.
+// Every VMM gets its own seed, the seed file on the host is removed once written.
+func injectRandomSeed(rootfsPath, cacheDirectory string) error {
+	seed := make([]byte, randomSeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return errors.Wrap(err, "failed generating random seed")
+	}
+	seedFile := filepath.Join(cacheDirectory, "random-seed")
+	if err := ioutil.WriteFile(seedFile, seed, 0600); err != nil {
+		return errors.Wrap(err, "failed writing random seed")
+	}
+	defer os.Remove(seedFile)
+	for _, seedPath := range commandConfig.RandomSeedPaths {
+		if err := utils.WriteFileToExt4(rootfsPath, seedFile, seedPath, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package selftest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/combust-labs/firebuild/cmd/internal/subcommand"
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/selftest"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "selftest",
+	Short: "Validates the host with an end-to-end build and run cycle",
+	Run:   run,
+	Long: `Builds a trivial rootfs FROM --base-image, runs it with a readiness check,
+executes a command in the VMM and tears everything down, reporting every step as passed,
+failed or skipped. The steps execute the firebuild commands so Docker, CNI and Firecracker
+are exercised for real. The base OS build requires network access and runs only with --with-baseos.`,
+}
+
+var (
+	commandConfig  = configs.NewSelftestCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+)
+
+// selftestResult is the result of the self-test.
+type selftestResult struct {
+	Passed bool                   `json:"Passed"`
+	Steps  []*selftest.StepResult `json:"Steps"`
+}
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	rootLogger := logConfig.NewLogger("selftest")
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		rootLogger.Error("failed resolving the firebuild executable", "reason", err)
+		return 1
+	}
+
+	tempDir, err := ioutil.TempDir("", "firebuild-selftest")
+	if err != nil {
+		rootLogger.Error("failed creating temporary directory", "reason", err)
+		return 1
+	}
+	defer os.RemoveAll(tempDir)
+
+	state := &selftestState{
+		marker:  utils.RandStringBytes(16),
+		runner:  subcommand.NewRunner(executable, profilesConfig),
+		tag:     utils.NormalizeTag(commandConfig.Tag),
+		tempDir: tempDir,
+	}
+
+	results := selftest.Execute(state.steps(), func(result *selftest.StepResult) {
+		logResult(rootLogger, result)
+	})
+
+	result := &selftestResult{
+		Passed: selftest.Passed(results),
+		Steps:  results,
+	}
+
+	if commandConfig.JSON {
+		bytes, jsonErr := json.MarshalIndent(result, "", "  ")
+		if jsonErr != nil {
+			rootLogger.Error("failed serializing self-test result to JSON", "reason", jsonErr)
+			return 1
+		}
+		fmt.Println(string(bytes))
+	}
+
+	if !result.Passed {
+		rootLogger.Error("self-test failed")
+		return 1
+	}
+	rootLogger.Info("self-test passed")
+	return 0
+}
+
+func logResult(logger hclog.Logger, result *selftest.StepResult) {
+	logArgs := []interface{}{"step", result.Step, "status", result.Status, "duration-ms", result.DurationMs}
+	if result.Reason != "" {
+		logArgs = append(logArgs, "reason", result.Reason)
+	}
+	if result.Status == selftest.StatusFailed {
+		logger.Error("step", logArgs...)
+		return
+	}
+	logger.Info("step", logArgs...)
+}
+
+// selftestState is the state shared by the steps.
+type selftestState struct {
+	marker  string
+	runner  *subcommand.Runner
+	tag     string
+	tempDir string
+
+	rootfsBuilt bool
+	vmmID       string
+}
+
+func (s *selftestState) steps() []*selftest.Step {
+	return []*selftest.Step{
+		{Name: "baseos", Run: s.baseos},
+		{Name: "rootfs", Run: s.rootfs},
+		{Name: "run", Run: s.run},
+		{Name: "exec", Run: s.exec},
+		{Name: "kill", Run: s.kill, Teardown: true},
+		{Name: "rm", Run: s.rm, Teardown: true},
+	}
+}
+
+func (s *selftestState) baseos() error {
+	if !commandConfig.WithBaseOS {
+		return selftest.Skip("requires network access, enable with --with-baseos")
+	}
+	return s.runner.Run("baseos", nil, "--dockerfile="+commandConfig.BaseOSDockerfile)
+}
+
+func (s *selftestState) rootfs() error {
+	dockerfile := filepath.Join(s.tempDir, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfile, []byte(selftest.RootfsDockerfile(commandConfig.BaseImage, s.marker)), 0644); err != nil {
+		return errors.Wrap(err, "failed writing the Dockerfile")
+	}
+	args := []string{
+		"--dockerfile=" + dockerfile,
+		"--cni-network-name=" + commandConfig.CNINetworkName,
+		"--vmlinux-id=" + commandConfig.VMLinuxID,
+		"--tag=" + s.tag,
+	}
+	if err := s.runner.Run("rootfs", nil, append(args, commandConfig.RootfsArgs...)...); err != nil {
+		return err
+	}
+	s.rootfsBuilt = true
+	return nil
+}
+
+func (s *selftestState) run() error {
+	publicKey, err := s.generateIdentity()
+	if err != nil {
+		return err
+	}
+	readinessScript := filepath.Join(s.tempDir, "readiness.sh")
+	if err := ioutil.WriteFile(readinessScript, []byte(selftest.ReadinessScript()), 0755); err != nil {
+		return errors.Wrap(err, "failed writing the readiness script")
+	}
+	spansFile := filepath.Join(s.tempDir, "spans.jsonl")
+	args := []string{
+		"--daemonize",
+		"--tracing-spans-file=" + spansFile,
+		"--from=" + s.tag,
+		"--cni-network-name=" + commandConfig.CNINetworkName,
+		"--vmlinux-id=" + commandConfig.VMLinuxID,
+		"--ssh-user=" + commandConfig.SSHUser,
+		"--identity-file=" + publicKey,
+		"--provision=" + readinessScript,
+	}
+	runErr := s.runner.Run("run", nil, append(args, commandConfig.RunArgs...)...)
+	// the VMM may have been started even if the command failed:
+	s.vmmID = subcommand.StartedVMMID(spansFile)
+	return runErr
+}
+
+func (s *selftestState) exec() error {
+	output := &bytes.Buffer{}
+	if err := s.runner.Run("exec", output,
+		"--vmm-id="+s.vmmID,
+		"--identity-file="+s.privateKeyPath(),
+		"--ssh-user="+commandConfig.SSHUser,
+		"--", "cat", selftest.MarkerFile); err != nil {
+		return err
+	}
+	if strings.TrimSpace(output.String()) != s.marker {
+		return fmt.Errorf("unexpected %s content: %q", selftest.MarkerFile, output.String())
+	}
+	return nil
+}
+
+func (s *selftestState) kill() error {
+	if s.vmmID == "" {
+		return selftest.Skip("no VMM started")
+	}
+	return s.runner.Run("kill", nil, "--vmm-id="+s.vmmID)
+}
+
+func (s *selftestState) rm() error {
+	if !s.rootfsBuilt {
+		return selftest.Skip("no rootfs built")
+	}
+	return s.runner.Run("rm", nil, "--tag="+s.tag)
+}
+
+func (s *selftestState) privateKeyPath() string {
+	return filepath.Join(s.tempDir, "id_rsa")
+}
+
+// generateIdentity writes a new SSH key pair used to connect to the VMM, returns the public key path.
+func (s *selftestState) generateIdentity() (string, error) {
+	privateKey, err := utils.GenerateRSAPrivateKey(utils.RSABitSize)
+	if err != nil {
+		return "", errors.Wrap(err, "failed generating the SSH key")
+	}
+	publicKey, err := utils.GetSSHKey(privateKey)
+	if err != nil {
+		return "", errors.Wrap(err, "failed generating the SSH public key")
+	}
+	if err := ioutil.WriteFile(s.privateKeyPath(), utils.EncodePrivateKeyToPEM(privateKey), 0600); err != nil {
+		return "", errors.Wrap(err, "failed writing the SSH key")
+	}
+	publicKeyPath := s.privateKeyPath() + ".pub"
+	if err := ioutil.WriteFile(publicKeyPath, utils.MarshalSSHPublicKey(publicKey), 0644); err != nil {
+		return "", errors.Wrap(err, "failed writing the SSH public key")
+	}
+	return publicKeyPath, nil
+}
@@ -0,0 +1,224 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/cobra"
+)
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Creates a snapshot of a running VMM",
+	Run:   run,
+	Long: `Pauses the VMM and creates a full Firecracker snapshot stored in the VMM run cache directory.
+Unless --stop is given, the VMM is resumed after the snapshot is created.
+With --stop, the VMM process is stopped but the network and the run cache are kept so the VMM can be restored with the restore command.`,
+}
+
+var (
+	commandConfig  = configs.NewSnapshotCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	runCache       = configs.NewRunCacheConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-snapshot")
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(runCache.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Dynamic completion:
+	Command.RegisterFlagCompletionFunc("vmm-id", completion.RunningVMMIDs(profilesConfig, runCache))
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("snapshot")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanSnapshot := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("snapshot"))
+	spanSnapshot.SetTag("vmm-id", commandConfig.VMMID)
+	cleanup.Add(func() {
+		spanSnapshot.Finish()
+	})
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		runCache,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			spanSnapshot.SetBaggageItem("error", err.Error())
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	spanFetchMetadata := tracer.StartSpan("fetch-metadata", opentracing.ChildOf(spanSnapshot.Context()))
+
+	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), commandConfig.VMMID))
+	if metadataErr != nil {
+		rootLogger.Error("failed loading metadata", "reason", metadataErr, "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.SetBaggageItem("error", metadataErr.Error())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.SetTag("has-metadata", hasMetadata)
+
+	if !hasMetadata {
+		rootLogger.Error("run cache directory did not contain the VMM metadata", "vmm-id", commandConfig.VMMID, "run-cache", runCache.LocationRuns())
+		spanFetchMetadata.Finish()
+		return 1
+	}
+
+	spanFetchMetadata.Finish()
+
+	isRunning, runningErr := vmmMetadata.PID.IsRunning()
+	if runningErr != nil || !isRunning {
+		rootLogger.Error("VMM is not running", "vmm-id", commandConfig.VMMID, "reason", runningErr)
+		return 1
+	}
+
+	if vmmMetadata.IsPaused() {
+		rootLogger.Error("VMM is paused, resume it with the resume command", "vmm-id", commandConfig.VMMID)
+		return 1
+	}
+
+	if commandConfig.Stop && !vmmMetadata.Configs.RunConfig.Daemonize {
+		// the controlling run command cleans up the network and the run cache when the VMM exits:
+		rootLogger.Error("--stop requires a VMM started with --daemonize", "vmm-id", commandConfig.VMMID)
+		return 1
+	}
+
+	spanCreate := tracer.StartSpan("snapshot-create", opentracing.ChildOf(spanFetchMetadata.Context()))
+
+	vmmProvider := vmm.NewDefaultProvider(vmmMetadata.Configs.CNI, vmmMetadata.Configs.Jailer, vmmMetadata.Configs.Machine)
+
+	snapshotMetadata := &metadata.MDRunSnapshot{
+		MemFilePath:  filepath.Join(vmmMetadata.RunCache, naming.SnapshotMemFileName),
+		SnapshotPath: filepath.Join(vmmMetadata.RunCache, naming.SnapshotFileName),
+	}
+
+	rootLogger.Info("creating snapshot", "snapshot", snapshotMetadata.SnapshotPath, "mem-file", snapshotMetadata.MemFilePath)
+
+	if err := vmmProvider.CreateSnapshot(context.Background(), commandConfig.VMMID, snapshotMetadata.MemFilePath, snapshotMetadata.SnapshotPath); err != nil {
+		rootLogger.Error("failed creating snapshot", "reason", err)
+		spanCreate.SetBaggageItem("error", err.Error())
+		spanCreate.Finish()
+		return 1
+	}
+
+	snapshotMetadata.CreatedAtUTC = time.Now().UTC().Unix()
+
+	spanCreate.Finish()
+
+	spanMetadata := tracer.StartSpan("snapshot-metadata", opentracing.ChildOf(spanCreate.Context()))
+
+	vmmMetadata.Snapshot = snapshotMetadata
+	if err := vmm.WriteMetadataToFile(vmmMetadata); err != nil {
+		// the VMM is still paused, carry on so the VMM is resumed or stopped:
+		rootLogger.Error("failed writing machine metadata to file", "reason", err)
+		spanMetadata.SetBaggageItem("error", err.Error())
+	}
+
+	spanMetadata.Finish()
+
+	if !commandConfig.Stop {
+		spanResume := tracer.StartSpan("snapshot-resume", opentracing.ChildOf(spanMetadata.Context()))
+		if err := vmmProvider.Resume(context.Background(), commandConfig.VMMID); err != nil {
+			rootLogger.Error("failed resuming the VMM", "reason", err)
+			spanResume.SetBaggageItem("error", err.Error())
+			spanResume.Finish()
+			return 1
+		}
+		rootLogger.Info("snapshot created, VMM resumed")
+		spanResume.Finish()
+		return 0
+	}
+
+	spanStop := tracer.StartSpan("snapshot-stop", opentracing.ChildOf(spanMetadata.Context()))
+
+	// the VMM is still paused, the guest must not run past the snapshot
+	// so the disk state matches the snapshot:
+	if err := vmmMetadata.PID.Kill(); err != nil {
+		rootLogger.Error("failed stopping the VMM", "reason", err)
+		spanStop.SetBaggageItem("error", err.Error())
+		spanStop.Finish()
+		return 1
+	}
+
+	stopDeadline := time.Now().Add(commandConfig.StopTimeout)
+	for {
+		isRunning, runningErr := vmmMetadata.PID.IsRunning()
+		if runningErr != nil {
+			rootLogger.Error("failed checking the VMM process status", "reason", runningErr)
+			spanStop.SetBaggageItem("error", runningErr.Error())
+			spanStop.Finish()
+			return 1
+		}
+		if !isRunning {
+			break
+		}
+		if time.Now().After(stopDeadline) {
+			rootLogger.Error("VMM process did not exit within timeout", "timeout", commandConfig.StopTimeout)
+			spanStop.SetBaggageItem("error", "stop timeout")
+			spanStop.Finish()
+			return 1
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	rootLogger.Info("snapshot created, VMM stopped")
+
+	spanStop.Finish()
+
+	return 0
+
+}
@@ -0,0 +1,201 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/hashicorp/go-hclog"
+	"github.com/opentracing/opentracing-go"
+	"github.com/spf13/cobra"
+)
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "stats",
+	Short: "Prints the Firecracker metrics of running VMMs",
+	Run:   run,
+	Long: `Prints the CPU, block and network counters of the latest Firecracker metrics flush.
+Without --vmm-id, the counters of all running VMMs in the run cache are aggregated.`,
+}
+
+var (
+	commandConfig  = configs.NewStatsCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	runCache       = configs.NewRunCacheConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-vmm-stats")
+)
+
+// statsResult is the metrics of one or more VMMs.
+type statsResult struct {
+	VMMIDs  []string     `json:"VMMIDs"`
+	Metrics *vmm.Metrics `json:"Metrics"`
+}
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(runCache.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Dynamic completion:
+	Command.RegisterFlagCompletionFunc("vmm-id", completion.RunningVMMIDs(profilesConfig, runCache))
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("stats")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(runCache, tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanStats := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("stats"))
+	spanStats.SetTag("vmm-id", commandConfig.VMMID)
+	cleanup.Add(func() {
+		spanStats.Finish()
+	})
+
+	validatingConfigs := []configs.ValidatingConfig{
+		commandConfig,
+		runCache,
+	}
+
+	for _, validatingConfig := range validatingConfigs {
+		if err := validatingConfig.Validate(); err != nil {
+			spanStats.SetBaggageItem("error", err.Error())
+			rootLogger.Error("configuration is invalid", "reason", err)
+			return 1
+		}
+	}
+
+	chanSignal := make(chan os.Signal, 1)
+	signal.Notify(chanSignal, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		spanCollect := tracer.StartSpan("stats-collect", opentracing.ChildOf(spanStats.Context()))
+		result, err := collectStats(rootLogger)
+		if err != nil {
+			rootLogger.Error("failed collecting metrics", "reason", err)
+			spanCollect.SetBaggageItem("error", err.Error())
+			spanCollect.Finish()
+			return 1
+		}
+		spanCollect.SetTag("vmm-count", len(result.VMMIDs))
+		spanCollect.Finish()
+
+		bytes, jsonErr := json.MarshalIndent(result, "", "  ")
+		if jsonErr != nil {
+			rootLogger.Error("failed serializing metrics to JSON", "reason", jsonErr)
+			return 1
+		}
+		fmt.Println(string(bytes))
+
+		if !commandConfig.Watch {
+			return 0
+		}
+
+		select {
+		case <-chanSignal:
+			return 0
+		case <-time.After(commandConfig.WatchInterval):
+		}
+	}
+
+}
+
+// collectStats reads the latest metrics of the VMM given with --vmm-id
+// or aggregates the latest metrics of all running VMMs.
+func collectStats(logger hclog.Logger) (*statsResult, error) {
+	if commandConfig.VMMID != "" {
+		metrics, err := vmmMetrics(logger, commandConfig.VMMID)
+		if err != nil {
+			return nil, err
+		}
+		return &statsResult{VMMIDs: []string{commandConfig.VMMID}, Metrics: metrics}, nil
+	}
+
+	fileInfos, readDirErr := ioutil.ReadDir(runCache.LocationRuns())
+	if readDirErr != nil {
+		return nil, readDirErr
+	}
+	result := &statsResult{VMMIDs: []string{}}
+	allMetrics := []*vmm.Metrics{}
+	for _, fileInfo := range fileInfos {
+		vmmID := fileInfo.Name()
+		metrics, err := vmmMetrics(logger, vmmID)
+		if err != nil {
+			// stopped VMMs and VMMs started without the metrics are skipped:
+			logger.Debug("skipping VMM", "vmm-id", vmmID, "reason", err)
+			continue
+		}
+		result.VMMIDs = append(result.VMMIDs, vmmID)
+		allMetrics = append(allMetrics, metrics)
+	}
+	result.Metrics = vmm.AggregateMetrics(allMetrics...)
+	return result, nil
+}
+
+// vmmMetrics flushes the metrics of a running VMM and reads the latest metrics.
+func vmmMetrics(logger hclog.Logger, vmmID string) (*vmm.Metrics, error) {
+	vmmMetadata, hasMetadata, metadataErr := vmm.FetchMetadataIfExists(filepath.Join(runCache.LocationRuns(), vmmID))
+	if metadataErr != nil {
+		return nil, metadataErr
+	}
+	if !hasMetadata {
+		return nil, fmt.Errorf("run cache directory did not contain the VMM metadata")
+	}
+	isRunning, runningErr := vmmMetadata.PID.IsRunning()
+	if runningErr != nil || !isRunning {
+		return nil, fmt.Errorf("VMM is not running")
+	}
+	if vmmMetadata.MetricsPath == "" {
+		return nil, fmt.Errorf("VMM was started without the metrics")
+	}
+	vmmProvider := vmm.NewDefaultProvider(vmmMetadata.Configs.CNI, vmmMetadata.Configs.Jailer, vmmMetadata.Configs.Machine)
+	if err := vmmProvider.FlushMetrics(context.Background(), vmmID); err != nil {
+		// the previously flushed metrics are still available:
+		logger.Warn("failed flushing metrics", "vmm-id", vmmID, "reason", err)
+	}
+	return vmm.ReadLatestMetrics(vmmMetadata.MetricsPath)
+}
@@ -0,0 +1,89 @@
+package dedup
+
+import (
+	"os"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/storage/resolver"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+/*
+sudo /usr/local/go/bin/go run ./main.go storage-dedup --profile=standard
+*/
+
+// Command is the storage-dedup command declaration.
+var Command = &cobra.Command{
+	Use:   "storage-dedup",
+	Short: "Convert the stored root file systems to the deduplicated layout",
+	Run:   run,
+	Long: `Every stored rootfs file is moved to the blob storage, identical files are stored once.
+Only storage providers supporting deduplication can be migrated.`,
+}
+
+var (
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+
+	storageResolver = resolver.NewDefaultResolver()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	// Storage provider flags:
+	resolver.AddStorageFlags(Command.Flags())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("storage-dedup")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		storageResolver.
+			WithConfigurationOverride(profile.GetMergedStorageConfig()).
+			WithTypeOverride(profile.Profile().StorageProvider)
+	}
+
+	storageImpl, resolveErr := storageResolver.GetStorageImpl(rootLogger)
+	if resolveErr != nil {
+		rootLogger.Error("failed resolving storage provider", "reason", resolveErr)
+		return 1
+	}
+
+	deduplicating, ok := storageImpl.(storage.DeduplicatingProvider)
+	if !ok {
+		rootLogger.Error("storage provider does not support deduplication")
+		return 1
+	}
+
+	converted, err := deduplicating.DeduplicateRootfs()
+	if err != nil {
+		rootLogger.Error("failed deduplicating rootfs files", "reason", err, "converted", converted)
+		return 1
+	}
+
+	rootLogger.Info("rootfs files deduplicated", "converted", converted)
+
+	return 0
+
+}
@@ -0,0 +1,191 @@
+package tag
+
+import (
+	"os"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/containers"
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/storage/resolver"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+/*
+sudo /usr/local/go/bin/go run ./main.go tag \
+	--profile=standard \
+	--source=tests/postgres:13 \
+	--target=tests/postgres:latest
+*/
+
+// Command is the tag command declaration.
+var Command = &cobra.Command{
+	Use:   "tag",
+	Short: "Create a tag pointing at an existing rootfs",
+	Run:   run,
+	Long: `The new tag points at the same rootfs as the source tag, the rootfs is not rebuilt.
+The metadata of the source tag is copied with the image and tag updated.`,
+}
+
+var (
+	commandConfig  = configs.NewTagCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-tag")
+
+	storageResolver = resolver.NewDefaultResolver()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Storage provider flags:
+	resolver.AddStorageFlags(Command.Flags())
+	// Dynamic completion:
+	Command.RegisterFlagCompletionFunc("source", completion.RootfsTags(profilesConfig, storageResolver))
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("tag")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+		storageResolver.
+			WithConfigurationOverride(profile.GetMergedStorageConfig()).
+			WithTypeOverride(profile.Profile().StorageProvider)
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanTag := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("tag"))
+	spanTag.SetTag("source", commandConfig.Source)
+	spanTag.SetTag("target", commandConfig.Target)
+	cleanup.Add(func() {
+		spanTag.Finish()
+	})
+
+	if err := commandConfig.Validate(); err != nil {
+		rootLogger.Error("configuration is invalid", "reason", err)
+		spanTag.SetBaggageItem("error", err.Error())
+		return 1
+	}
+
+	storageImpl, resolveErr := storageResolver.GetStorageImpl(rootLogger)
+	if resolveErr != nil {
+		rootLogger.Error("failed resolving storage provider", "reason", resolveErr)
+		spanTag.SetBaggageItem("error", resolveErr.Error())
+		return 1
+	}
+
+	arch, archErr := containers.PlatformArchitecture(commandConfig.Platform)
+	if archErr != nil {
+		rootLogger.Error("failed resolving platform architecture", "reason", archErr)
+		spanTag.SetBaggageItem("error", archErr.Error())
+		return 1
+	}
+
+	_, sourceOrg, sourceImage, sourceVersion := utils.ReferenceDecompose(commandConfig.Source, storage.LatestVersion)
+	_, targetOrg, targetImage, targetVersion := utils.TagDecompose(commandConfig.Target)
+
+	spanMetadata := tracer.StartSpan("tag-source-metadata", opentracing.ChildOf(spanTag.Context()))
+
+	// the latest version and the version constraint resolve to a stored version:
+	sourceLookup, lookupErr := storage.ResolveRootfsLookup(storageImpl, &storage.RootfsLookup{
+		Org:     sourceOrg,
+		Image:   sourceImage,
+		Version: sourceVersion,
+		Arch:    arch,
+	})
+	if lookupErr != nil {
+		rootLogger.Error("failed resolving source rootfs version", "reason", lookupErr, "source", commandConfig.Source)
+		spanMetadata.SetBaggageItem("error", lookupErr.Error())
+		spanMetadata.Finish()
+		return 1
+	}
+	if sourceLookup.Version != sourceVersion {
+		rootLogger.Info("source rootfs version resolved", "requested", sourceVersion, "version", sourceLookup.Version)
+		spanMetadata.SetTag("version", sourceLookup.Version)
+	}
+	sourceMetadata, metadataErr := storageImpl.FetchRootfsMetadata(sourceLookup)
+	if metadataErr != nil {
+		rootLogger.Error("failed resolving source rootfs metadata", "reason", metadataErr, "source", commandConfig.Source)
+		spanMetadata.SetBaggageItem("error", metadataErr.Error())
+		spanMetadata.Finish()
+		return 1
+	}
+
+	targetMetadata, retagErr := metadata.RetagRootfsMetadata(sourceMetadata, targetOrg, targetImage, targetVersion)
+	if retagErr != nil {
+		rootLogger.Error("failed updating rootfs metadata", "reason", retagErr, "source", commandConfig.Source)
+		spanMetadata.SetBaggageItem("error", retagErr.Error())
+		spanMetadata.Finish()
+		return 1
+	}
+
+	spanMetadata.Finish()
+
+	spanStore := tracer.StartSpan("tag-store", opentracing.ChildOf(spanMetadata.Context()))
+
+	// the source is resolved for the architecture, the target is tagged for the same architecture:
+	tagResult, tagErr := storageImpl.TagRootfs(&storage.RootfsTag{
+		Source:    sourceLookup,
+		Metadata:  targetMetadata,
+		Overwrite: commandConfig.Force,
+		Org:       targetOrg,
+		Image:     targetImage,
+		Version:   targetVersion,
+		Arch:      arch,
+	})
+	if tagErr != nil {
+		if errors.Is(tagErr, storage.ErrRootfsExists) {
+			rootLogger.Error("target tag exists, use --force to overwrite", "target", commandConfig.Target)
+		} else {
+			rootLogger.Error("failed tagging rootfs", "reason", tagErr)
+		}
+		spanStore.SetBaggageItem("error", tagErr.Error())
+		spanStore.Finish()
+		return 1
+	}
+
+	spanStore.Finish()
+
+	rootLogger.Info("rootfs tagged", "source", commandConfig.Source, "target", commandConfig.Target,
+		"rootfs", tagResult.RootfsLocation, "metadata", tagResult.MetadataLocation)
+
+	return 0
+
+}
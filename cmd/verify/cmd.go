@@ -0,0 +1,199 @@
+package verify
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/completion"
+	"github.com/combust-labs/firebuild/pkg/containers"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/storage/resolver"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+/*
+sudo /usr/local/go/bin/go run ./main.go verify \
+	--profile=standard \
+	tests/postgres:13
+*/
+
+// Command is the verify command declaration.
+var Command = &cobra.Command{
+	Use:   "verify [tag...]",
+	Short: "Verify the stored rootfs files against the recorded checksums",
+	Run:   run,
+	Long: `Recomputes the SHA-256 of the rootfs files and compares it with the checksum recorded
+when the rootfs was stored. Without the tags, all stored rootfs files are verified, this requires
+a storage provider capable of listing the rootfs tags. The rootfs of the given tag is resolved
+for the host architecture. The remote rootfs files are fetched to the local cache to be verified.`,
+	ValidArgsFunction: completion.RootfsTags(profilesConfig, storageResolver),
+}
+
+var (
+	commandConfig  = configs.NewVerifyCommandConfig()
+	logConfig      = configs.NewLogginConfig()
+	profilesConfig = configs.NewProfileCommandConfig()
+	tracingConfig  = configs.NewTracingConfig("firebuild-verify")
+
+	storageResolver = resolver.NewDefaultResolver()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+	Command.Flags().AddFlagSet(logConfig.FlagSet())
+	Command.Flags().AddFlagSet(profilesConfig.FlagSet())
+	Command.Flags().AddFlagSet(tracingConfig.FlagSet())
+	// Storage provider flags:
+	resolver.AddStorageFlags(Command.Flags())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, args []string) {
+	os.Exit(processCommand(args))
+}
+
+func processCommand(args []string) int {
+
+	cleanup := utils.NewDefers()
+	defer cleanup.CallAll()
+
+	rootLogger := logConfig.NewLogger("verify")
+
+	if profilesConfig.Profile != "" {
+		profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+		if err != nil {
+			rootLogger.Error("failed resolving profile", "reason", err, "profile", profilesConfig.Profile)
+			return 1
+		}
+		if err := profile.UpdateConfigs(tracingConfig); err != nil {
+			rootLogger.Error("error updating configuration from profile", "reason", err)
+			return 1
+		}
+		storageResolver.
+			WithConfigurationOverride(profile.GetMergedStorageConfig()).
+			WithTypeOverride(profile.Profile().StorageProvider)
+	}
+
+	tracer, tracerCleanupFunc, tracerErr := tracing.GetTracer(rootLogger.Named("tracer"), tracingConfig)
+	if tracerErr != nil {
+		rootLogger.Error("failed constructing tracer", "reason", tracerErr)
+		return 1
+	}
+
+	cleanup.Add(tracerCleanupFunc)
+
+	rootLogger, spanVerify := tracing.ApplyTraceLogDiscovery(rootLogger, tracer.StartSpan("verify"))
+	cleanup.Add(func() {
+		spanVerify.Finish()
+	})
+
+	if err := commandConfig.ParseArgs(args); err != nil {
+		spanVerify.SetBaggageItem("error", err.Error())
+		rootLogger.Error("arguments are invalid", "reason", err)
+		return 1
+	}
+
+	if err := commandConfig.Validate(); err != nil {
+		rootLogger.Error("configuration is invalid", "reason", err)
+		spanVerify.SetBaggageItem("error", err.Error())
+		return 1
+	}
+
+	storageImpl, resolveErr := storageResolver.GetStorageImpl(rootLogger)
+	if resolveErr != nil {
+		rootLogger.Error("failed resolving storage provider", "reason", resolveErr)
+		spanVerify.SetBaggageItem("error", resolveErr.Error())
+		return 1
+	}
+
+	arch, archErr := containers.PlatformArchitecture(commandConfig.Platform)
+	if archErr != nil {
+		rootLogger.Error("failed resolving platform architecture", "reason", archErr)
+		spanVerify.SetBaggageItem("error", archErr.Error())
+		return 1
+	}
+
+	lookups := []*storage.RootfsLookup{}
+	if len(commandConfig.Tags) == 0 {
+		listingImpl, ok := storageImpl.(storage.ListingProvider)
+		if !ok {
+			rootLogger.Error("storage provider does not support listing, give the tags to verify")
+			spanVerify.SetBaggageItem("error", "listing not supported")
+			return 1
+		}
+		// the listed rootfs files are the stored rootfs files, with the architecture if stored with one:
+		listed, err := listingImpl.ListRootfs()
+		if err != nil {
+			rootLogger.Error("failed listing rootfs files", "reason", err)
+			spanVerify.SetBaggageItem("error", err.Error())
+			return 1
+		}
+		lookups = append(lookups, listed...)
+	} else {
+		for _, tag := range commandConfig.Tags {
+			_, org, image, version := utils.ReferenceDecompose(tag, storage.LatestVersion)
+			// the latest version and the version constraint resolve to a stored version:
+			lookup, err := storage.ResolveRootfsLookup(storageImpl, &storage.RootfsLookup{
+				Org:     org,
+				Image:   image,
+				Version: version,
+				Arch:    arch,
+			})
+			if err != nil {
+				rootLogger.Error("failed resolving rootfs version", "reason", err, "tag", tag)
+				spanVerify.SetBaggageItem("error", err.Error())
+				return 1
+			}
+			lookups = append(lookups, lookup)
+		}
+	}
+
+	failed := 0
+	for _, lookup := range lookups {
+		rootfsID := fmt.Sprintf("%s/%s:%s", lookup.Org, lookup.Image, lookup.Version)
+		spanRootfs := tracer.StartSpan("verify-rootfs", opentracing.ChildOf(spanVerify.Context()))
+		spanRootfs.SetTag("rootfs-id", rootfsID)
+		rootfsLogger := rootLogger.With("rootfs-id", rootfsID)
+		// the listed rootfs files of all architectures are verified:
+		if lookup.Arch != "" {
+			spanRootfs.SetTag("arch", lookup.Arch)
+			rootfsLogger = rootfsLogger.With("arch", lookup.Arch)
+		}
+
+		resolvedRootfs, err := storageImpl.FetchRootfs(lookup)
+		if err == nil {
+			err = storage.VerifyRootfs(resolvedRootfs)
+		}
+		switch {
+		case err == nil:
+			rootfsLogger.Info("rootfs verified")
+		case errors.Is(err, storage.ErrRootfsChecksumMissing) && !commandConfig.RequireChecksum:
+			rootfsLogger.Warn("rootfs stored without the checksum, skipped")
+		default:
+			failed = failed + 1
+			rootfsLogger.Error("rootfs verification failed", "reason", err)
+			spanRootfs.SetBaggageItem("error", err.Error())
+		}
+		spanRootfs.Finish()
+	}
+
+	spanVerify.SetBaggageItem("verified", fmt.Sprintf("%d", len(lookups)-failed))
+	spanVerify.SetBaggageItem("failed", fmt.Sprintf("%d", failed))
+
+	if failed > 0 {
+		rootLogger.Error("rootfs verification failed", "failed", failed, "total", len(lookups))
+		return 1
+	}
+
+	return 0
+
+}
@@ -0,0 +1,50 @@
+package version
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/combust-labs/firebuild/configs"
+	buildVersion "github.com/combust-labs/firebuild/pkg/version"
+	"github.com/spf13/cobra"
+)
+
+// Command is the build command declaration.
+var Command = &cobra.Command{
+	Use:   "version",
+	Short: "Prints the firebuild version and build information",
+	Run:   run,
+	Long:  ``,
+}
+
+var (
+	commandConfig = configs.NewVersionCommandConfig()
+)
+
+func initFlags() {
+	Command.Flags().AddFlagSet(commandConfig.FlagSet())
+}
+
+func init() {
+	initFlags()
+}
+
+func run(cobraCommand *cobra.Command, _ []string) {
+	os.Exit(processCommand())
+}
+
+func processCommand() int {
+	info := buildVersion.Get()
+	if commandConfig.JSON {
+		bytes, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed serializing version information:", err)
+			return 1
+		}
+		fmt.Println(string(bytes))
+		return 0
+	}
+	fmt.Println(info.String())
+	return 0
+}
@@ -1,103 +1,820 @@
 package configs
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 
+	"github.com/combust-labs/firebuild/pkg/containers"
+	"github.com/combust-labs/firebuild/pkg/format"
+	"github.com/combust-labs/firebuild/pkg/naming"
 	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm/drives"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 	"github.com/subosito/gotenv"
 )
 
+// BalloonCommandConfig is the balloon command configuration.
+type BalloonCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	TargetMib int64
+	VMMID     string
+}
+
+// NewBalloonCommandConfig returns new command configuration.
+func NewBalloonCommandConfig() *BalloonCommandConfig {
+	return &BalloonCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *BalloonCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.Int64Var(&c.TargetMib, "target-mib", -1, "New balloon target size in MiB")
+		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the VMM to update the balloon of")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *BalloonCommandConfig) Validate() error {
+	if c.VMMID == "" {
+		return fmt.Errorf("--vmm-id can't be empty")
+	}
+	if c.TargetMib < 0 {
+		return fmt.Errorf("--target-mib is required and can't be negative")
+	}
+	return nil
+}
+
+// BenchCommandConfig is the bench command configuration.
+type BenchCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	Iterations int
+	JSON       bool
+	KillArgs   []string
+	RootfsArgs []string
+	RunArgs    []string
+}
+
+// NewBenchCommandConfig returns new command configuration.
+func NewBenchCommandConfig() *BenchCommandConfig {
+	return &BenchCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *BenchCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.IntVar(&c.Iterations, "iterations", 5, "How many times the build and the boot cycle are executed")
+		c.flagSet.BoolVar(&c.JSON, "json", false, "When set, outputs the phase percentiles as JSON")
+		c.flagSet.StringArrayVar(&c.KillArgs, "kill-arg", []string{}, "Argument of the kill command stopping the VMM started with --run-arg, for example --kill-arg=--run-cache=/path, multiple OK")
+		c.flagSet.StringArrayVar(&c.RootfsArgs, "rootfs-arg", []string{}, "Argument of the rootfs command executed in every iteration, for example --rootfs-arg=--dockerfile=/path/Dockerfile, multiple OK")
+		c.flagSet.StringArrayVar(&c.RunArgs, "run-arg", []string{}, "Argument of the run command executed in every iteration, the VMM is daemonized and killed once started; use --provision to measure the readiness, multiple OK")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *BenchCommandConfig) Validate() error {
+	if c.Iterations < 1 {
+		return fmt.Errorf("--iterations must be at least 1")
+	}
+	if len(c.RootfsArgs) == 0 && len(c.RunArgs) == 0 {
+		return fmt.Errorf("at least one --rootfs-arg or --run-arg is required")
+	}
+	return nil
+}
+
 // BaseOSCommandConfig is the baseos command configuration.
 type BaseOSCommandConfig struct {
 	flagBase
+	ValidatingConfig
+
+	Dockerfile        string
+	ExportMode        string
+	ExportMountTarget string
+	ExportShell       string
+	FreeSpaceMBs      int
+	FSSizeFactor      float64
+	FSSizeMarginMBs   int
+	FSSizeMBs         int
+	LoopDirectIO      bool
+	LoopSectorSize    int
+	Shrink            bool
+	Tag               string
+}
+
+// NewBaseOSCommandConfig returns new command configuration.
+func NewBaseOSCommandConfig() *BaseOSCommandConfig {
+	return &BaseOSCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *BaseOSCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringVar(&c.Dockerfile, "dockerfile", "", "Full path to the base OS Dockerfile")
+		c.flagSet.StringVar(&c.ExportMode, "export-mode", "auto", "File system export mode: auto, exec or archive; archive does not require a shell, find or tar in the base OS image")
+		c.flagSet.StringVar(&c.ExportMountTarget, "export-mount-target", "", "Top level directory in the base OS container under which the file system is exported; if empty, a random directory is used")
+		c.flagSet.StringVar(&c.ExportShell, "export-shell", "/bin/sh", "Full path to the shell in the base OS image used to export the file system, for example /bin/ash")
+		c.flagSet.IntVar(&c.FreeSpaceMBs, "free-space-mb", 0, "Free space in megabytes left in the file system shrunk with --shrink")
+		c.flagSet.Float64Var(&c.FSSizeFactor, "filesystem-size-factor", 1.5, "Factor applied to the Docker image size when the file system size is estimated")
+		c.flagSet.IntVar(&c.FSSizeMarginMBs, "filesystem-size-margin-mbs", 128, "Megabytes added to the estimated file system size")
+		c.flagSet.IntVar(&c.FSSizeMBs, "filesystem-size-mbs", 0, "File system size in megabytes; if 0, the size is estimated from the Docker image size")
+		c.flagSet.BoolVar(&c.LoopDirectIO, "loop-direct-io", false, "When set, the rootfs file is mounted with the direct I/O enabled on the loop device")
+		c.flagSet.IntVar(&c.LoopSectorSize, "loop-sector-size", 0, "Logical sector size of the loop device the rootfs file is mounted with: 512, 1024, 2048 or 4096; the file system block size can't be smaller; if 0, the loop driver default is used")
+		c.flagSet.BoolVar(&c.Shrink, "shrink", false, "When set, the file system is shrunk to the minimum size after the export, requires resize2fs and e2fsck")
+		c.flagSet.StringVar(&c.Tag, "tag", "", "Tag name; if empty, the name FROM value from the Dockerfile will be used")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *BaseOSCommandConfig) Validate() error {
+	if c.FreeSpaceMBs < 0 {
+		return fmt.Errorf("--free-space-mb can't be negative")
+	}
+	if c.FreeSpaceMBs > 0 && !c.Shrink {
+		return fmt.Errorf("--free-space-mb requires --shrink")
+	}
+	if c.FSSizeMBs < 0 {
+		return fmt.Errorf("--filesystem-size-mbs can't be negative")
+	}
+	if c.FSSizeFactor < 0 {
+		return fmt.Errorf("--filesystem-size-factor can't be negative")
+	}
+	if c.FSSizeMarginMBs < 0 {
+		return fmt.Errorf("--filesystem-size-margin-mbs can't be negative")
+	}
+	switch c.ExportMode {
+	case "auto", "archive", "exec":
+	default:
+		return fmt.Errorf("--export-mode must be one of auto, exec or archive, got %q", c.ExportMode)
+	}
+	if c.ExportShell == "" {
+		return fmt.Errorf("--export-shell is required")
+	}
+	if err := c.LoopOptions().Validate(); err != nil {
+		return errors.Wrap(err, "--loop-sector-size invalid")
+	}
+	if !filepath.IsAbs(c.ExportShell) || strings.ContainsAny(c.ExportShell, " \t") {
+		return fmt.Errorf("--export-shell must be an absolute path without arguments, got %q", c.ExportShell)
+	}
+	return nil
+}
+
+// LoopOptions returns the options of the loop device the rootfs file is mounted with.
+func (c *BaseOSCommandConfig) LoopOptions() *utils.LoopOptions {
+	return &utils.LoopOptions{DirectIO: c.LoopDirectIO, SectorSize: c.LoopSectorSize}
+}
+
+// EstimateFSSizeMBs returns the file system size in megabytes for the image size in bytes:
+// the image size multiplied by the factor plus the margin.
+func (c *BaseOSCommandConfig) EstimateFSSizeMBs(imageBytes int64) int {
+	return int(math.Ceil(float64(imageBytes)*c.FSSizeFactor/1024/1024)) + c.FSSizeMarginMBs
+}
+
+// CleanupMountsCommandConfig is the cleanup-mounts command configuration.
+type CleanupMountsCommandConfig struct {
+	flagBase
+
+	DryRun bool
+}
+
+// NewCleanupMountsCommandConfig returns new command configuration.
+func NewCleanupMountsCommandConfig() *CleanupMountsCommandConfig {
+	return &CleanupMountsCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *CleanupMountsCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.DryRun, "dry-run", false, "When set, the stale mounts and loop devices are listed but not removed")
+	}
+	return c.flagSet
+}
+
+// CpCommandConfig is the cp command configuration.
+type CpCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	ConnectTimeout        time.Duration
+	IdentityFile          string
+	InsecureIgnoreHostKey bool
+	KnownHostsFile        string
+	SSHPort               int
+	SSHUser               string
+	StrictHostKeyChecking bool
+}
+
+// NewCpCommandConfig returns new command configuration.
+func NewCpCommandConfig() *CpCommandConfig {
+	return &CpCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *CpCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.DurationVar(&c.ConnectTimeout, "connect-timeout", time.Second*10, "How long to wait for the SSH connection to the VMM")
+		c.flagSet.StringVar(&c.IdentityFile, "identity-file", "", "Path to the SSH private key; if empty, the SSH agent is used")
+		c.flagSet.BoolVar(&c.InsecureIgnoreHostKey, "insecure-ignore-host-key", false, "When set, the VMM SSH host key is not verified")
+		c.flagSet.StringVar(&c.KnownHostsFile, "known-hosts-file", "", "Path to the known hosts file used to verify the VMM SSH host key; if empty, the known_hosts file in the VMM run cache directory is used")
+		c.flagSet.IntVar(&c.SSHPort, "ssh-port", 22, "SSH port of the VMM")
+		c.flagSet.StringVar(&c.SSHUser, "ssh-user", "", "SSH user; if empty, the SSH user of the VMM run is used")
+		c.flagSet.BoolVar(&c.StrictHostKeyChecking, "strict-host-key-checking", false, "When set, a VMM not in the known hosts file is rejected; otherwise its key is added on the first connect")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *CpCommandConfig) Validate() error {
+	if c.IdentityFile != "" {
+		if _, err := utils.CheckIfExistsAndIsRegular(c.IdentityFile); err != nil {
+			return errors.Wrap(err, "--identity-file")
+		}
+	}
+	if c.InsecureIgnoreHostKey && c.StrictHostKeyChecking {
+		return fmt.Errorf("--insecure-ignore-host-key and --strict-host-key-checking can't be used together")
+	}
+	return nil
+}
+
+// DoctorCommandConfig is the doctor command configuration.
+type DoctorCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	CNINetworkNames []string
+	Platforms       []string
+}
+
+// NewDoctorCommandConfig returns new command configuration.
+func NewDoctorCommandConfig() *DoctorCommandConfig {
+	return &DoctorCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *DoctorCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringArrayVar(&c.CNINetworkNames, "cni-network-name", []string{}, "CNI network to check, multiple OK; when empty, all networks in the CNI configuration directory are checked")
+		c.flagSet.StringArrayVar(&c.Platforms, "platform", []string{}, "Platform to check the build support for, for example linux/arm64, multiple OK; when empty, all supported platforms are checked")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *DoctorCommandConfig) Validate() error {
+	return nil
+}
+
+// ExecCommandConfig is the exec command configuration.
+type ExecCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	ConnectTimeout        time.Duration
+	IdentityFile          string
+	InsecureIgnoreHostKey bool
+	KnownHostsFile        string
+	SSHPort               int
+	SSHUser               string
+	StrictHostKeyChecking bool
+	VMMID                 string
+}
+
+// NewExecCommandConfig returns new command configuration.
+func NewExecCommandConfig() *ExecCommandConfig {
+	return &ExecCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *ExecCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.DurationVar(&c.ConnectTimeout, "connect-timeout", time.Second*10, "How long to wait for the SSH connection to the VMM")
+		c.flagSet.StringVar(&c.IdentityFile, "identity-file", "", "Path to the SSH private key; if empty, the SSH agent is used")
+		c.flagSet.BoolVar(&c.InsecureIgnoreHostKey, "insecure-ignore-host-key", false, "When set, the VMM SSH host key is not verified")
+		c.flagSet.StringVar(&c.KnownHostsFile, "known-hosts-file", "", "Path to the known hosts file used to verify the VMM SSH host key; if empty, the known_hosts file in the VMM run cache directory is used")
+		c.flagSet.IntVar(&c.SSHPort, "ssh-port", 22, "SSH port of the VMM")
+		c.flagSet.StringVar(&c.SSHUser, "ssh-user", "", "SSH user; if empty, the SSH user of the VMM run is used")
+		c.flagSet.BoolVar(&c.StrictHostKeyChecking, "strict-host-key-checking", false, "When set, a VMM not in the known hosts file is rejected; otherwise its key is added on the first connect")
+		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the VMM to execute the command in")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *ExecCommandConfig) Validate() error {
+	if c.VMMID == "" {
+		return fmt.Errorf("--vmm-id can't be empty")
+	}
+	if c.IdentityFile != "" {
+		if _, err := utils.CheckIfExistsAndIsRegular(c.IdentityFile); err != nil {
+			return errors.Wrap(err, "--identity-file")
+		}
+	}
+	if c.InsecureIgnoreHostKey && c.StrictHostKeyChecking {
+		return fmt.Errorf("--insecure-ignore-host-key and --strict-host-key-checking can't be used together")
+	}
+	return nil
+}
+
+// GetCommandConfig is the get command configuration.
+type GetCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	Name     string
+	Output   string
+	Platform string
+	Tag      string
+}
+
+// NewGetCommandConfig returns new command configuration.
+func NewGetCommandConfig() *GetCommandConfig {
+	return &GetCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *GetCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringVar(&c.Output, "output", "", "File to write the attachment to; if empty, the attachment is written to stdout")
+		c.flagSet.StringVar(&c.Platform, "platform", "", "Platform of the rootfs in the os/arch format, for example linux/arm64; empty for the host platform")
+	}
+	return c.flagSet
+}
+
+// ParseArgs parses the rootfs tag and the attachment name from the command arguments.
+func (c *GetCommandConfig) ParseArgs(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected the rootfs tag and the attachment name")
+	}
+	c.Tag = args[0]
+	c.Name = args[1]
+	return nil
+}
+
+// Validate validates the correctness of the configuration.
+func (c *GetCommandConfig) Validate() error {
+	if ok, _, _, _ := utils.ReferenceDecompose(c.Tag, "latest"); !ok {
+		return fmt.Errorf("tag '%s' is invalid", c.Tag)
+	}
+	if !utils.IsValidAttachmentName(c.Name) {
+		return fmt.Errorf("attachment name '%s' is invalid", c.Name)
+	}
+	if _, err := containers.NormalizePlatform(c.Platform); err != nil {
+		return errors.Wrap(err, "--platform value is invalid")
+	}
+	return nil
+}
+
+// KillCommandConfig is the kill command configuration.
+type KillCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	All             bool
+	Parallelism     int
+	ShutdownTimeout time.Duration
+	VMMID           string
+}
+
+// NewKillCommandConfig returns new command configuration.
+func NewKillCommandConfig() *KillCommandConfig {
+	return &KillCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *KillCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.All, "all", false, "When set, all VMMs in the run cache are killed; mutually exclusive with --vmm-id")
+		c.flagSet.IntVar(&c.Parallelism, "parallelism", 4, "Maximum number of VMMs killed concurrently with --all or a --vmm-id pattern")
+		c.flagSet.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", time.Second*15, "If the VMM is running and shutdown is called, how long to wait for clean shutdown before the VMM process is killed")
+		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the VMM to kill; may be a glob pattern, for example: abc*, to kill all matching VMMs")
+	}
+	return c.flagSet
+}
+
+// IsBatch returns true if the configuration selects the VMMs with --all or a --vmm-id pattern.
+func (c *KillCommandConfig) IsBatch() bool {
+	return c.All || strings.ContainsAny(c.VMMID, "*?[")
+}
+
+// Matches returns true if the VMM ID is selected by the configuration.
+func (c *KillCommandConfig) Matches(vmmID string) bool {
+	if c.All {
+		return true
+	}
+	matched, err := filepath.Match(c.VMMID, vmmID)
+	return err == nil && matched
+}
+
+// Validate validates the correctness of the configuration.
+func (c *KillCommandConfig) Validate() error {
+	if c.All && c.VMMID != "" {
+		return fmt.Errorf("--all and --vmm-id are mutually exclusive")
+	}
+	if !c.All && c.VMMID == "" {
+		return fmt.Errorf("--vmm-id can't be empty")
+	}
+	if _, err := filepath.Match(c.VMMID, ""); err != nil {
+		return fmt.Errorf("--vmm-id pattern invalid: %v", err)
+	}
+	if c.Parallelism < 1 {
+		return fmt.Errorf("--parallelism must be at least 1")
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("--shutdown-timeout must be positive")
+	}
+	return nil
+}
+
+// InspectCommandConfig is the inspect command configuration.
+type InspectCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	Format string
+	Labels []string
+	VMMID  string
+}
+
+// NewInspectCommandConfig returns new command configuration.
+func NewInspectCommandConfig() *InspectCommandConfig {
+	return &InspectCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *InspectCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringVar(&c.Format, "format", "", "Format the output using the given Go template, for example: {{(index .NetworkInterfaces 0).StaticConfiguration.IPConfiguration.IP}}")
+		c.flagSet.StringArrayVar(&c.Labels, "label", []string{}, "Inspect the VMMs with the label instead of --vmm-id, format: key or key=value, multiple OK, all must match")
+		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the VMM to inspect")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *InspectCommandConfig) Validate() error {
+	if c.VMMID == "" && len(c.Labels) == 0 {
+		return fmt.Errorf("--vmm-id or --label is required")
+	}
+	if c.VMMID != "" && len(c.Labels) > 0 {
+		return fmt.Errorf("--vmm-id and --label can't be used together")
+	}
+	if err := validateLabelFilters(c.Labels); err != nil {
+		return err
+	}
+	if c.Format != "" {
+		if _, err := format.Parse(c.Format); err != nil {
+			return errors.Wrap(err, "--format is invalid")
+		}
+	}
+	return nil
+}
+
+// LabelCommandConfig is the label command configuration.
+type LabelCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	Labels map[string]string
+	Remove []string
+	VMMID  string
+}
+
+// NewLabelCommandConfig returns new command configuration.
+func NewLabelCommandConfig() *LabelCommandConfig {
+	return &LabelCommandConfig{Labels: map[string]string{}}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *LabelCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringArrayVar(&c.Remove, "remove", []string{}, "Key of the label to remove, multiple OK")
+	}
+	return c.flagSet
+}
+
+// ParseArgs parses the VMM ID and the key=value labels from the command arguments.
+func (c *LabelCommandConfig) ParseArgs(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("VMM ID is required")
+	}
+	c.VMMID = args[0]
+	for _, arg := range args[1:] {
+		key, value, err := utils.ParseLabel(arg)
+		if err != nil {
+			return err
+		}
+		c.Labels[key] = value
+	}
+	return nil
+}
+
+// Validate validates the correctness of the configuration.
+func (c *LabelCommandConfig) Validate() error {
+	if c.VMMID == "" {
+		return fmt.Errorf("VMM ID can't be empty")
+	}
+	if len(c.Labels) == 0 && len(c.Remove) == 0 {
+		return fmt.Errorf("at least one key=value label or --remove is required")
+	}
+	for _, key := range c.Remove {
+		if !utils.IsValidLabelKey(key) {
+			return fmt.Errorf("--remove label key '%s' is invalid", key)
+		}
+		if _, ok := c.Labels[key]; ok {
+			return fmt.Errorf("label '%s' can't be set and removed at the same time", key)
+		}
+	}
+	return nil
+}
+
+// LsCommandConfig is the ls command configuration.
+type LsCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	Filters   []string
+	Labels    []string
+	Output    string
+	PruneDead bool
+	Reverse   bool
+	Sort      string
+}
+
+// NewLsCommandConfig returns new command configuration.
+func NewLsCommandConfig() *LsCommandConfig {
+	return &LsCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *LsCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringArrayVar(&c.Filters, "filter", []string{}, "List only the VMMs of the matching rootfs image, format: org=value, image=value, label.key or label.key=value, multiple OK, all must match")
+		c.flagSet.StringArrayVar(&c.Labels, "label", []string{}, "List only the VMMs with the label, format: key or key=value, multiple OK, all must match")
+		c.flagSet.StringVar(&c.Output, "output", "table", "Output format: table or json")
+		c.flagSet.BoolVar(&c.PruneDead, "prune-dead", false, "When set, the remains of the dead VMMs are removed, like with the purge command, and the dead VMMs are not listed")
+		c.flagSet.BoolVar(&c.Reverse, "reverse", false, "When set, reverses the --sort order")
+		c.flagSet.StringVar(&c.Sort, "sort", "", "Sort by the rootfs image: created, size, name or semver, the image version in the semantic version order; by default, sorted by the VMM ID")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *LsCommandConfig) Validate() error {
+	if c.Output != "" && c.Output != "table" && c.Output != "json" {
+		return fmt.Errorf("--output must be table or json, got %q", c.Output)
+	}
+	if c.Sort != "" && c.Sort != "created" && c.Sort != "size" && c.Sort != "name" && c.Sort != "semver" {
+		return fmt.Errorf("--sort must be created, size, name or semver, got %q", c.Sort)
+	}
+	for _, filter := range c.Filters {
+		if _, _, _, err := utils.ParseImageFilter(filter); err != nil {
+			return errors.Wrap(err, "--filter is invalid")
+		}
+	}
+	return validateLabelFilters(c.Labels)
+}
+
+// ParseCommandConfig is the parse command configuration.
+type ParseCommandConfig struct {
+	flagBase
+
+	JSON bool
+}
+
+// NewParseCommandConfig returns new command configuration.
+func NewParseCommandConfig() *ParseCommandConfig {
+	return &ParseCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *ParseCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.JSON, "json", false, "When set, outputs the parsed commands and stages as JSON")
+	}
+	return c.flagSet
+}
+
+// PauseCommandConfig is the pause command configuration.
+type PauseCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	VMMID string
+}
+
+// NewPauseCommandConfig returns new command configuration.
+func NewPauseCommandConfig() *PauseCommandConfig {
+	return &PauseCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *PauseCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the VMM to pause")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *PauseCommandConfig) Validate() error {
+	if c.VMMID == "" {
+		return fmt.Errorf("--vmm-id can't be empty")
+	}
+	return nil
+}
+
+// PublishCommandConfig is the publish command configuration.
+type PublishCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	AllowPrivilegedPorts bool
+	Ports                []string
+	Remove               []string
+	VMMID                string
+}
+
+// NewPublishCommandConfig returns new command configuration.
+func NewPublishCommandConfig() *PublishCommandConfig {
+	return &PublishCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *PublishCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.AllowPrivilegedPorts, "allow-privileged-ports", false, "When set, ports may be published on privileged host ports, lower than 1024")
+		c.flagSet.StringArrayVar(&c.Ports, "port", []string{}, "Port to publish, same format as the run --port flag, multiple OK")
+		c.flagSet.StringArrayVar(&c.Remove, "remove", []string{}, "Published port to remove, same format as the run --port flag, multiple OK")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *PublishCommandConfig) Validate() error {
+	if c.VMMID == "" {
+		return fmt.Errorf("VMM ID can't be empty")
+	}
+	if len(c.Ports) == 0 && len(c.Remove) == 0 {
+		return fmt.Errorf("at least one --port or --remove is required")
+	}
+	return nil
+}
+
+// PurgeCommandConfig is the purge command configuration.
+type PurgeCommandConfig struct {
+	flagBase
+	ValidatingConfig
 
-	Dockerfile string
-	FSSizeMBs  int
-	Tag        string
+	DryRun       bool
+	Force        bool
+	OrphanMinAge time.Duration
 }
 
-// NewBaseOSCommandConfig returns new command configuration.
-func NewBaseOSCommandConfig() *BaseOSCommandConfig {
-	return &BaseOSCommandConfig{}
+// NewPurgeCommandConfig returns new command configuration.
+func NewPurgeCommandConfig() *PurgeCommandConfig {
+	return &PurgeCommandConfig{}
 }
 
 // FlagSet returns an instance of the flag set for the configuration.
-func (c *BaseOSCommandConfig) FlagSet() *pflag.FlagSet {
+func (c *PurgeCommandConfig) FlagSet() *pflag.FlagSet {
 	if c.initFlagSet() {
-		c.flagSet.StringVar(&c.Dockerfile, "dockerfile", "", "Full path to the base OS Dockerfile")
-		c.flagSet.IntVar(&c.FSSizeMBs, "filesystem-size-mbs", 500, "File system size in megabytes")
-		c.flagSet.StringVar(&c.Tag, "tag", "", "Tag name; if empty, the name FROM value from the Dockerfile will be used")
+		c.flagSet.BoolVar(&c.DryRun, "dry-run", false, "When set, lists what would be removed without removing anything")
+		c.flagSet.BoolVar(&c.Force, "force", false, "Required to remove the remains of the stopped VMMs and the orphaned resources")
+		c.flagSet.DurationVar(&c.OrphanMinAge, "orphan-min-age", time.Minute*10, "Minimum age of a jail directory or CNI cache directory without a run cache entry to be considered orphaned, protects the VMMs being started")
 	}
 	return c.flagSet
 }
 
-// KillCommandConfig is the kill command configuration.
-type KillCommandConfig struct {
+// Validate validates the correctness of the configuration.
+func (c *PurgeCommandConfig) Validate() error {
+	if c.DryRun && c.Force {
+		return fmt.Errorf("--dry-run and --force are mutually exclusive")
+	}
+	if !c.DryRun && !c.Force {
+		return fmt.Errorf("--force is required to remove anything, use --dry-run to list what would be removed")
+	}
+	if c.OrphanMinAge < 0 {
+		return fmt.Errorf("--orphan-min-age can't be negative")
+	}
+	return nil
+}
+
+// RestoreCommandConfig is the restore command configuration.
+type RestoreCommandConfig struct {
 	flagBase
 	ValidatingConfig
 
-	ShutdownTimeout time.Duration
-	VMMID           string
+	Daemonize bool
+	VMMID     string
 }
 
-// NewKillCommandConfig returns new command configuration.
-func NewKillCommandConfig() *KillCommandConfig {
-	return &KillCommandConfig{}
+// NewRestoreCommandConfig returns new command configuration.
+func NewRestoreCommandConfig() *RestoreCommandConfig {
+	return &RestoreCommandConfig{}
 }
 
 // FlagSet returns an instance of the flag set for the configuration.
-func (c *KillCommandConfig) FlagSet() *pflag.FlagSet {
+func (c *RestoreCommandConfig) FlagSet() *pflag.FlagSet {
 	if c.initFlagSet() {
-		c.flagSet.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", time.Second*15, "If the VMM is running and shutdown is called, how long to wait for clean shutdown")
-		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the VMM to kill")
+		c.flagSet.BoolVar(&c.Daemonize, "daemonize", false, "When set, runs the restored VMM in the detached mode")
+		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the VMM to restore")
 	}
 	return c.flagSet
 }
 
 // Validate validates the correctness of the configuration.
-func (c *KillCommandConfig) Validate() error {
+func (c *RestoreCommandConfig) Validate() error {
 	if c.VMMID == "" {
 		return fmt.Errorf("--vmm-id can't be empty")
 	}
 	return nil
 }
 
-// InspectCommandConfig is the inspect command configuration.
-type InspectCommandConfig struct {
+// ResumeCommandConfig is the resume command configuration.
+type ResumeCommandConfig struct {
 	flagBase
 	ValidatingConfig
 
 	VMMID string
 }
 
-// NewInspectCommandConfig returns new command configuration.
-func NewInspectCommandConfig() *InspectCommandConfig {
-	return &InspectCommandConfig{}
+// NewResumeCommandConfig returns new command configuration.
+func NewResumeCommandConfig() *ResumeCommandConfig {
+	return &ResumeCommandConfig{}
 }
 
 // FlagSet returns an instance of the flag set for the configuration.
-func (c *InspectCommandConfig) FlagSet() *pflag.FlagSet {
+func (c *ResumeCommandConfig) FlagSet() *pflag.FlagSet {
 	if c.initFlagSet() {
-		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the VMM to inspect")
+		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the paused VMM to resume")
 	}
 	return c.flagSet
 }
 
 // Validate validates the correctness of the configuration.
-func (c *InspectCommandConfig) Validate() error {
+func (c *ResumeCommandConfig) Validate() error {
 	if c.VMMID == "" {
 		return fmt.Errorf("--vmm-id can't be empty")
 	}
 	return nil
 }
 
+// RmCommandConfig is the rm command configuration.
+type RmCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	Force    bool
+	Platform string
+	Tags     []string
+}
+
+// NewRmCommandConfig returns new command configuration.
+func NewRmCommandConfig() *RmCommandConfig {
+	return &RmCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *RmCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.Force, "force", false, "When set, a rootfs used by a running VMM is deleted")
+		c.flagSet.StringVar(&c.Platform, "platform", "", "Platform of the rootfs in the os/arch format, for example linux/arm64; empty for the host platform")
+		c.flagSet.StringArrayVar(&c.Tags, "tag", []string{}, "Tag of the rootfs to delete, for example: org/image:version, multiple OK")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *RmCommandConfig) Validate() error {
+	if len(c.Tags) == 0 {
+		return fmt.Errorf("at least one tag is required")
+	}
+	for _, tag := range c.Tags {
+		if ok, _, _, _ := utils.ReferenceDecompose(tag, "latest"); !ok {
+			return fmt.Errorf("--tag value is invalid: '%s'", tag)
+		}
+	}
+	if _, err := containers.NormalizePlatform(c.Platform); err != nil {
+		return errors.Wrap(err, "--platform value is invalid")
+	}
+	return nil
+}
+
 // RootfsCommandConfig is the rootfs command configuration.
 type RootfsCommandConfig struct {
 	flagBase
@@ -108,18 +825,31 @@ type RootfsCommandConfig struct {
 	BootstrapServerBindInterface         string
 
 	// Dockerfile build:
-	BuildArgs       map[string]string
-	Dockerfile      string
-	DockerfileStage string
+	BuildArgs           map[string]string
+	Dockerfile          string
+	DockerfileStage     string
+	KeepBuildContainers bool
+	MaxParallelStages   int
+	MaxResourceSize     int64
+	NoStageCache        bool
+	StageCacheMaxImages int
+	StageCacheTTL       time.Duration
 
 	// Docker image build:
 	DockerImage     string
 	DockerImageBase string
 
 	// Shared settings:
+	Attachments       map[string]string
+	BuildOnTmpfs      bool
+	DryRun            bool
+	MaxConcurrency    int
+	Output            string
 	PostBuildCommands []string
 	PreBuildCommands  []string
+	ServiceEnvPath    string
 	Tag               string
+	ValidateBootstrap bool
 }
 
 // NewRootfsCommandConfig returns new command configuration.
@@ -138,13 +868,26 @@ func (c *RootfsCommandConfig) FlagSet() *pflag.FlagSet {
 		c.flagSet.StringToStringVar(&c.BuildArgs, "build-arg", map[string]string{}, "Build arguments, Multiple OK")
 		c.flagSet.StringVar(&c.Dockerfile, "dockerfile", "", "Local or remote (HTTP / HTTP) path; if the Dockerfile uses ADD or COPY commands, it's recommended to use a local file")
 		c.flagSet.StringVar(&c.DockerfileStage, "dockerfile-stage", "", "The Dockerfile stage name to build from")
+		c.flagSet.BoolVar(&c.KeepBuildContainers, "keep-build-containers", false, "When set, the intermediate Docker containers of the stage dependency builds are not removed, even if the build fails")
+		c.flagSet.IntVar(&c.MaxParallelStages, "max-parallel-stages", 0, "Maximum number of the stage dependencies and COPY --from images processed concurrently; 0 means --max-concurrency")
+		c.flagSet.Int64Var(&c.MaxResourceSize, "max-resource-size", 0, "Maximum size in bytes of a single ADD or COPY file, a larger file fails the build; 0 means no limit")
+		c.flagSet.BoolVar(&c.NoStageCache, "no-stage-cache", false, "When set, the stage dependency Docker images are not cached and reused across builds")
+		c.flagSet.IntVar(&c.StageCacheMaxImages, "stage-cache-max-images", 20, "Maximum number of cached stage dependency Docker images, the least recently used are removed; 0 means no limit")
+		c.flagSet.DurationVar(&c.StageCacheTTL, "stage-cache-ttl", time.Hour*24*7, "Cached stage dependency Docker images not used for longer than this are removed; 0 means forever")
 		// Docker image build:
 		c.flagSet.StringVar(&c.DockerImage, "docker-image", "", "Docker image tag name to build from; mutually exclusive with --dockerfile")
 		c.flagSet.StringVar(&c.DockerImageBase, "docker-image-base", "", "Rootfs base when building from Docker image, required because the base operating system can't be established from a Docker image; for example alpine:3.13")
 		// Shared settings:
+		c.flagSet.StringToStringVar(&c.Attachments, "attach", map[string]string{}, "Named file stored with the rootfs, outside of the file system, in the name=path format, retrieved with firebuild get; multiple OK")
+		c.flagSet.BoolVar(&c.BuildOnTmpfs, "build-on-tmpfs", false, "When set, the kernel and rootfs copies and the jail are placed on a tmpfs sized to fit them; falls back to disk if there isn't enough RAM; the build result is lost on crash")
+		c.flagSet.BoolVar(&c.DryRun, "dry-run", false, "When set, the build plan is printed: the stages, the resolved ADD and COPY resources, the RUN commands, the base rootfs and the kernel; the build VMM is not started and the stage dependencies are not built")
+		c.flagSet.IntVar(&c.MaxConcurrency, "max-concurrency", runtime.NumCPU(), "Maximum number of the concurrent heavy operations of the whole build, shared by all phases; bounds the per-phase limits; 0 means the number of CPUs")
+		c.flagSet.StringVar(&c.Output, "output", "text", "Output format of --dry-run: text or json")
 		c.flagSet.StringArrayVar(&c.PostBuildCommands, "post-build-command", []string{}, "OS specific commands to run after Dockerfile commands but before the file system is persisted, multiple OK")
 		c.flagSet.StringArrayVar(&c.PreBuildCommands, "pre-build-command", []string{}, "OS specific commands to run before any Dockerfile command, multiple OK")
+		c.flagSet.StringVar(&c.ServiceEnvPath, "service-env-path", naming.ServiceEnvFile, "Absolute path of the env file of the entrypoint service in the VMM, for the init systems expecting the env file elsewhere")
 		c.flagSet.StringVar(&c.Tag, "tag", "", "Tag name of the build, required; must be org/name:version")
+		c.flagSet.BoolVar(&c.ValidateBootstrap, "validate-bootstrap", false, "When set, the bootstrap CA, the server and client certificates are created and a TLS handshake is verified locally before anything is built; the build fails fast if the handshake fails")
 	}
 	return c.flagSet
 }
@@ -159,24 +902,90 @@ func (c *RootfsCommandConfig) Validate() error {
 			return fmt.Errorf("--docker-image-base is required when using --docker-image")
 		}
 	}
+	if c.Output != "" && c.Output != "text" && c.Output != "json" {
+		return fmt.Errorf("--output must be text or json")
+	}
+	if c.MaxParallelStages < 0 {
+		return fmt.Errorf("--max-parallel-stages must not be negative")
+	}
+	if c.MaxConcurrency < 0 {
+		return fmt.Errorf("--max-concurrency must not be negative")
+	}
+	for name, path := range c.Attachments {
+		if !utils.IsValidAttachmentName(name) {
+			return fmt.Errorf("--attach name '%s' is invalid", name)
+		}
+		if _, err := utils.CheckIfExistsAndIsRegular(path); err != nil {
+			return fmt.Errorf("--attach file '%s' of '%s' is not a regular file: %v", path, name, err)
+		}
+	}
+	if c.MaxResourceSize < 0 {
+		return fmt.Errorf("--max-resource-size can't be negative")
+	}
+	if c.StageCacheMaxImages < 0 {
+		return fmt.Errorf("--stage-cache-max-images can't be negative")
+	}
+	if c.StageCacheTTL < 0 {
+		return fmt.Errorf("--stage-cache-ttl can't be negative")
+	}
+	if c.ServiceEnvPath != "" && (!filepath.IsAbs(c.ServiceEnvPath) || filepath.Clean(c.ServiceEnvPath) != c.ServiceEnvPath || c.ServiceEnvPath == "/") {
+		return fmt.Errorf("--service-env-path must be a clean absolute file path")
+	}
 	return nil
 }
 
+// Concurrency returns the maximum number of the concurrent heavy operations of the build.
+func (c *RootfsCommandConfig) Concurrency() int {
+	if c.MaxConcurrency < 1 {
+		return runtime.NumCPU()
+	}
+	return c.MaxConcurrency
+}
+
+// StageParallelism returns the maximum number of the stage dependencies processed concurrently,
+// --max-concurrency when not set, never more than --max-concurrency.
+func (c *RootfsCommandConfig) StageParallelism() int {
+	if c.MaxParallelStages < 1 || c.MaxParallelStages > c.Concurrency() {
+		return c.Concurrency()
+	}
+	return c.MaxParallelStages
+}
+
 // RunCommandConfig is the run command configuration.
 type RunCommandConfig struct {
 	flagBase
 	ValidatingConfig
 
-	Daemonize     bool
-	EnvFiles      []string
-	EnvVars       map[string]string
-	From          string
-	IdentityFiles []string
-	Hostname      string
-	Name          string
-	Ports         []string
+	AllowPrivilegedPorts bool
+	ConsoleCaptureLines  int
+	Daemonize            bool
+	Drives               []string
+	EgressAllow          []string
+	EgressDefault        string
+	EgressDeny           []string
+	EntrypointArgs       []string
+	EnvFiles             []string
+	EnvVars              map[string]string
+	From                 string
+	IdentityDirs         []string
+	IdentityFiles        []string
+	Hostname             string
+	Name                 string
+	Ports                []string
+	Provision            []string
+	ProvisionBestEffort  bool
+	ProvisionTimeout     time.Duration
+	PublishIPv4Only      bool
+	RandomSeed           bool
+	RandomSeedPaths      []string
+	SSHImportIDs         []string
+	UserIdentityFiles    []string
+	Verify               bool
+	VMLabels             map[string]string
 
-	cmdOverride []string
+	cmdOverride    []string
+	publicKeys     []ssh.PublicKey
+	userPublicKeys map[string][]ssh.PublicKey
 }
 
 // NewRunCommandConfig returns new command configuration.
@@ -187,18 +996,53 @@ func NewRunCommandConfig() *RunCommandConfig {
 // FlagSet returns an instance of the flag set for the configuration.
 func (c *RunCommandConfig) FlagSet() *pflag.FlagSet {
 	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.AllowPrivilegedPorts, "allow-privileged-ports", false, "When set, ports may be published on privileged host ports, lower than 1024")
+		c.flagSet.IntVar(&c.ConsoleCaptureLines, "console-capture-lines", 50, "Number of the last VMM console output lines included in the error when the VMM fails to boot or exits on its own; 0 disables the capture; the console is not captured with --daemonize once the command exits")
 		c.flagSet.BoolVar(&c.Daemonize, "daemonize", false, "When set, runs the VMM in the detached mode")
+		c.flagSet.StringArrayVar(&c.Drives, "drive", []string{}, "Additional drive attached to the VMM, format: path:ro|rw[:partuuid][:size=<size>], for example: /var/lib/data.ext4:rw:size=10GiB; with the size, a missing drive file is created with an EXT4 file system; multiple OK")
+		c.flagSet.StringArrayVar(&c.EgressAllow, "egress-allow", []string{}, "Destination the VMM may connect to, format: cidr[:port[-port]][/tcp|udp], IPv6 CIDR in square brackets; without the protocol, the port applies to tcp and udp; with an allowed destination, the other egress traffic is denied by default, multiple OK")
+		c.flagSet.StringVar(&c.EgressDefault, "egress-default", "", "Egress traffic not matching any --egress-allow or --egress-deny destination: allow or deny; if empty, deny with --egress-allow, allow otherwise")
+		c.flagSet.StringArrayVar(&c.EgressDeny, "egress-deny", []string{}, "Destination the VMM may not connect to, format like --egress-allow, evaluated before --egress-allow, multiple OK")
+		c.flagSet.StringArrayVar(&c.EntrypointArgs, "arg", []string{}, "Argument appended to the rootfs entrypoint, the CMD is kept; without an entrypoint, the argument is appended to the CMD; multiple OK, applied in order")
 		c.flagSet.StringArrayVar(&c.EnvFiles, "env-file", []string{}, "Full path to an environment file to apply to the VMM during bootstrap, multiple OK")
 		c.flagSet.StringToStringVar(&c.EnvVars, "env", map[string]string{}, "Additional environment variables to apply to the VMM during bootstrap, multiple OK")
-		c.flagSet.StringVar(&c.From, "from", "", "The image to launch from, for example: tests/postgres:13")
+		c.flagSet.StringVar(&c.From, "from", "", "The image to launch from, for example: tests/postgres:13; the version may be a constraint resolved to the highest matching stored version, for example: tests/postgres:^13.1; without the version or with the latest version, the newest stored version is used")
+		c.flagSet.StringArrayVar(&c.IdentityDirs, "identity-dir", []string{}, "Full path to a directory with the SSH public keys to deploy to the machine during bootstrap, all *.pub files are used, multiple OK")
 		c.flagSet.StringArrayVar(&c.IdentityFiles, "identity-file", []string{}, "Full path to the SSH public key to deploy to the machine during bootstrap, must be regular file, multiple OK")
 		c.flagSet.StringVar(&c.Hostname, "hostname", "", "Hostname to apply to the VMM during bootstrap; if empty, a random name will be assigned")
 		c.flagSet.StringVar(&c.Name, "name", "", "Name of the VM, maximum 20 characters; allowed characters: letters and digits")
-		c.flagSet.StringArrayVar(&c.Ports, "port", []string{}, "Ports to expose on the host")
+		c.flagSet.StringArrayVar(&c.Ports, "port", []string{}, "Ports to expose on the host, format: [interface|host-address:][host-port:]port[/tcp|udp|both], IPv6 host addresses in square brackets, ports may be ranges: 8000-8010; without a protocol, the protocol exposed by the rootfs or tcp is used, multiple OK")
+		c.flagSet.StringArrayVar(&c.Provision, "provision", []string{}, "Full path to a script to run in the VMM over SSH once the VMM is up, requires --ssh-user, multiple OK, executed in order")
+		c.flagSet.BoolVar(&c.ProvisionBestEffort, "provision-best-effort", false, "When set, a failed provisioning script is logged and the VMM keeps running")
+		c.flagSet.DurationVar(&c.ProvisionTimeout, "provision-timeout", time.Minute*2, "How long to wait for the VMM to accept SSH connections before provisioning fails")
+		c.flagSet.BoolVar(&c.PublishIPv4Only, "publish-ipv4-only", false, "When set, ports are published only with iptables to the IPv4 address of the VMM; ports with an IPv6 host address are rejected")
+		c.flagSet.BoolVar(&c.RandomSeed, "random-seed", false, "When set, a random seed generated on the host is written to the rootfs before the VMM starts so the guest gathers entropy faster")
+		c.flagSet.StringArrayVar(&c.RandomSeedPaths, "random-seed-path", []string{"/var/lib/systemd/random-seed"}, "Full path in the rootfs of the random seed file written with --random-seed, multiple OK")
+		c.flagSet.StringArrayVar(&c.SSHImportIDs, "ssh-import-id", []string{}, "Import the public SSH keys of a GitHub user to deploy to the machine during bootstrap, format: gh:username, multiple OK")
+		c.flagSet.StringArrayVar(&c.UserIdentityFiles, "user-identity-file", []string{}, "SSH public key of a guest user to deploy to the machine during bootstrap, format: user:path, the key is added to the authorized keys of the user, multiple OK")
+		c.flagSet.BoolVar(&c.Verify, "verify", false, "When set, the SHA-256 of the rootfs file is compared with the checksum recorded when the rootfs was stored before the VMM starts; a mismatch or a missing checksum fails the command")
+		c.flagSet.StringToStringVar(&c.VMLabels, "vm-label", map[string]string{}, "Label of the VM, format: key=value, multiple OK; the labels can be changed with the label command")
 	}
 	return c.flagSet
 }
 
+// HasEgressPolicy returns true if the egress traffic of the VMM is restricted.
+func (c *RunCommandConfig) HasEgressPolicy() bool {
+	return len(c.EgressAllow) > 0 || len(c.EgressDeny) > 0 || c.EgressDefault != ""
+}
+
+// EgressDefaultPolicy returns the policy of the egress traffic not matching any egress destination.
+// Unless given, the traffic is denied when the allowed destinations are given and allowed otherwise.
+func (c *RunCommandConfig) EgressDefaultPolicy() string {
+	if c.EgressDefault != "" {
+		return c.EgressDefault
+	}
+	if len(c.EgressAllow) > 0 {
+		return "deny"
+	}
+	return "allow"
+}
+
 // CapturedCmd retrieves the captured command override.
 func (c *RunCommandConfig) CapturedCmd() []string {
 	return c.cmdOverride
@@ -237,8 +1081,13 @@ func (c *RunCommandConfig) MergedEnvironment() (map[string]string, error) {
 	return env, nil
 }
 
-// PublicKeys returns an array of ssh.PublicKey obtainer from identity files.
+// PublicKeys returns an array of ssh.PublicKey obtained from identity files,
+// identity directories and SSH import IDs. The keys are resolved once,
+// subsequent calls return the same keys without fetching them again.
 func (c *RunCommandConfig) PublicKeys() ([]ssh.PublicKey, error) {
+	if c.publicKeys != nil {
+		return c.publicKeys, nil
+	}
 	keys := []ssh.PublicKey{}
 	for _, identityFile := range c.IdentityFiles {
 		sshPublicKey, readErr := utils.SSHPublicKeyFromFile(identityFile)
@@ -247,9 +1096,76 @@ func (c *RunCommandConfig) PublicKeys() ([]ssh.PublicKey, error) {
 		}
 		keys = append(keys, sshPublicKey)
 	}
+	for _, identityDir := range c.IdentityDirs {
+		sshPublicKeys, readErr := utils.SSHPublicKeysFromDirectory(identityDir)
+		if readErr != nil {
+			return keys, readErr
+		}
+		keys = append(keys, sshPublicKeys...)
+	}
+	for _, importID := range c.SSHImportIDs {
+		username, parseErr := utils.ParseSSHImportID(importID)
+		if parseErr != nil {
+			return keys, parseErr
+		}
+		sshPublicKeys, fetchErr := utils.SSHPublicKeysFromGitHub(username)
+		if fetchErr != nil {
+			return keys, fetchErr
+		}
+		keys = append(keys, sshPublicKeys...)
+	}
+	c.publicKeys = keys
 	return keys, nil
 }
 
+// UserPublicKeys returns the SSH public keys of every guest user.
+// The keys of the --identity-file, --identity-dir and --ssh-import-id flags
+// are the keys of the default user, usually the --ssh-user, the keys given with
+// --user-identity-file are added to the keys of their users. The duplicate keys of a user are skipped.
+// The default user is included even without keys.
+func (c *RunCommandConfig) UserPublicKeys(defaultUser string) (map[string][]ssh.PublicKey, error) {
+	keys, err := c.PublicKeys()
+	if err != nil {
+		return nil, err
+	}
+	if c.userPublicKeys == nil {
+		userKeys := map[string][]ssh.PublicKey{}
+		for _, userIdentityFile := range c.UserIdentityFiles {
+			user, path, parseErr := utils.ParseUserIdentityFile(userIdentityFile)
+			if parseErr != nil {
+				return nil, parseErr
+			}
+			sshPublicKey, readErr := utils.SSHPublicKeyFromFile(path)
+			if readErr != nil {
+				return nil, readErr
+			}
+			userKeys[user] = append(userKeys[user], sshPublicKey)
+		}
+		c.userPublicKeys = userKeys
+	}
+	result := map[string][]ssh.PublicKey{}
+	if defaultUser != "" {
+		result[defaultUser] = append([]ssh.PublicKey{}, keys...)
+	}
+	for user, userKeys := range c.userPublicKeys {
+		for _, key := range userKeys {
+			if !containsPublicKey(result[user], key) {
+				result[user] = append(result[user], key)
+			}
+		}
+	}
+	return result, nil
+}
+
+// AddPublicKey adds the key to the public keys deployed to the machine during bootstrap.
+func (c *RunCommandConfig) AddPublicKey(key ssh.PublicKey) error {
+	if _, err := c.PublicKeys(); err != nil {
+		return err
+	}
+	c.publicKeys = append(c.publicKeys, key)
+	return nil
+}
+
 // Validate validates the correctness of the configuration.
 func (c *RunCommandConfig) Validate() error {
 	nameRegex := regexp.MustCompile("^[a-zA-Z0-9]{1,20}$")
@@ -258,13 +1174,331 @@ func (c *RunCommandConfig) Validate() error {
 			return fmt.Errorf("--name is not a valid name")
 		}
 	}
+	if c.ConsoleCaptureLines < 0 {
+		return fmt.Errorf("--console-capture-lines can't be negative")
+	}
+	if _, err := drives.ParseAll(c.Drives); err != nil {
+		return errors.Wrap(err, "--drive invalid")
+	}
+	if c.EgressDefault != "" && c.EgressDefault != "allow" && c.EgressDefault != "deny" {
+		return fmt.Errorf("--egress-default must be allow or deny, got %q", c.EgressDefault)
+	}
+	for key := range c.VMLabels {
+		if !utils.IsValidLabelKey(key) {
+			return fmt.Errorf("--vm-label key '%s' is invalid", key)
+		}
+	}
+	// the --from version may be a constraint resolved to the highest matching stored version:
+	if idx := strings.LastIndex(c.From, ":"); idx > -1 && utils.IsVersionConstraint(c.From[idx+1:]) {
+		if _, err := utils.ParseVersionConstraint(c.From[idx+1:]); err != nil {
+			return errors.Wrap(err, "--from version constraint is invalid")
+		}
+	}
+	// the stored tags are lowercase, an uppercase --from must resolve to a valid tag when normalized:
+	// without the version, the --from resolves to the latest stored version:
+	if c.From != strings.ToLower(c.From) && !utils.IsValidTag(utils.TagWithDefaultVersion(c.From, "latest")) {
+		return fmt.Errorf("--from '%s' contains uppercase characters and its lowercase form '%s' is not a valid tag", c.From, utils.NormalizeTag(c.From))
+	}
 	for _, envFile := range c.EnvFiles {
 		if _, statErr := utils.CheckIfExistsAndIsRegular(envFile); statErr != nil {
 			return errors.Wrapf(statErr, "environment file '%s' stat error", envFile)
 		}
 	}
+	for _, identityDir := range c.IdentityDirs {
+		if _, statErr := utils.CheckIfExistsAndIsDirectory(identityDir); statErr != nil {
+			return errors.Wrapf(statErr, "--identity-dir '%s' stat error", identityDir)
+		}
+	}
+	for _, importID := range c.SSHImportIDs {
+		if _, parseErr := utils.ParseSSHImportID(importID); parseErr != nil {
+			return errors.Wrap(parseErr, "--ssh-import-id invalid")
+		}
+	}
+	for _, userIdentityFile := range c.UserIdentityFiles {
+		_, path, parseErr := utils.ParseUserIdentityFile(userIdentityFile)
+		if parseErr != nil {
+			return errors.Wrap(parseErr, "--user-identity-file invalid")
+		}
+		if _, statErr := utils.CheckIfExistsAndIsRegular(path); statErr != nil {
+			return errors.Wrapf(statErr, "--user-identity-file '%s' stat error", path)
+		}
+	}
+	for _, script := range c.Provision {
+		if _, statErr := utils.CheckIfExistsAndIsRegular(script); statErr != nil {
+			return errors.Wrapf(statErr, "--provision script '%s' stat error", script)
+		}
+	}
+	if len(c.Provision) > 0 && c.ProvisionTimeout <= 0 {
+		return fmt.Errorf("--provision-timeout must be positive")
+	}
+	for _, seedPath := range c.RandomSeedPaths {
+		if !filepath.IsAbs(seedPath) || strings.ContainsAny(seedPath, " \t\n\"") {
+			return fmt.Errorf("--random-seed-path must be an absolute path without whitespace, got %q", seedPath)
+		}
+	}
 	if !utils.IsValidHostname(c.Hostname) {
 		return fmt.Errorf("string '%s' is not a valid hostname", c.Hostname)
 	}
 	return nil
 }
+
+// SelftestCommandConfig is the selftest command configuration.
+type SelftestCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	BaseImage        string
+	BaseOSDockerfile string
+	CNINetworkName   string
+	JSON             bool
+	RootfsArgs       []string
+	RunArgs          []string
+	SSHUser          string
+	Tag              string
+	VMLinuxID        string
+	WithBaseOS       bool
+}
+
+// NewSelftestCommandConfig returns new command configuration.
+func NewSelftestCommandConfig() *SelftestCommandConfig {
+	return &SelftestCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *SelftestCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringVar(&c.BaseImage, "base-image", "alpine:3.13", "Base OS the self-test rootfs is built FROM; must be stored, or built with --with-baseos")
+		c.flagSet.StringVar(&c.BaseOSDockerfile, "baseos-dockerfile", "", "Full path to the base OS Dockerfile built with --with-baseos, its FROM must be the --base-image")
+		c.flagSet.StringVar(&c.CNINetworkName, "cni-network-name", "", "CNI network within which the self-test rootfs is built and the VMM runs")
+		c.flagSet.BoolVar(&c.JSON, "json", false, "When set, outputs the step results as JSON")
+		c.flagSet.StringArrayVar(&c.RootfsArgs, "rootfs-arg", []string{}, "Additional argument of the rootfs command, multiple OK")
+		c.flagSet.StringArrayVar(&c.RunArgs, "run-arg", []string{}, "Additional argument of the run command, multiple OK")
+		c.flagSet.StringVar(&c.SSHUser, "ssh-user", "alpine", "SSH user of the base OS")
+		c.flagSet.StringVar(&c.Tag, "tag", "firebuild/selftest:1.0.0", "Tag of the self-test rootfs, removed when the self-test finishes")
+		c.flagSet.StringVar(&c.VMLinuxID, "vmlinux-id", "", "Kernel ID / name")
+		c.flagSet.BoolVar(&c.WithBaseOS, "with-baseos", false, "When set, the base OS is built from --baseos-dockerfile; pulls the Docker images and downloads the Dockerfile resources, requires network access")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *SelftestCommandConfig) Validate() error {
+	if c.BaseImage == "" {
+		return fmt.Errorf("--base-image can't be empty")
+	}
+	if c.WithBaseOS {
+		if c.BaseOSDockerfile == "" {
+			return fmt.Errorf("--with-baseos requires --baseos-dockerfile")
+		}
+		if !filepath.IsAbs(c.BaseOSDockerfile) {
+			return fmt.Errorf("--baseos-dockerfile must be an absolute path")
+		}
+	}
+	if c.CNINetworkName == "" {
+		return fmt.Errorf("--cni-network-name can't be empty")
+	}
+	if c.SSHUser == "" {
+		return fmt.Errorf("--ssh-user can't be empty")
+	}
+	if c.VMLinuxID == "" {
+		return fmt.Errorf("--vmlinux-id can't be empty")
+	}
+	if !utils.IsValidTag(utils.NormalizeTag(c.Tag)) {
+		return fmt.Errorf("--tag value is invalid: '%s'", c.Tag)
+	}
+	return nil
+}
+
+// SnapshotCommandConfig is the snapshot command configuration.
+type SnapshotCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	Stop        bool
+	StopTimeout time.Duration
+	VMMID       string
+}
+
+// NewSnapshotCommandConfig returns new command configuration.
+func NewSnapshotCommandConfig() *SnapshotCommandConfig {
+	return &SnapshotCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *SnapshotCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.Stop, "stop", false, "When set, the VMM is stopped after the snapshot is created instead of being resumed; the network and the run cache are kept for restore")
+		c.flagSet.DurationVar(&c.StopTimeout, "stop-timeout", time.Second*15, "When --stop is set, how long to wait for the VMM process to exit")
+		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the VMM to snapshot")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *SnapshotCommandConfig) Validate() error {
+	if c.VMMID == "" {
+		return fmt.Errorf("--vmm-id can't be empty")
+	}
+	return nil
+}
+
+// StatsCommandConfig is the stats command configuration.
+type StatsCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	VMMID         string
+	Watch         bool
+	WatchInterval time.Duration
+}
+
+// NewStatsCommandConfig returns new command configuration.
+func NewStatsCommandConfig() *StatsCommandConfig {
+	return &StatsCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *StatsCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringVar(&c.VMMID, "vmm-id", "", "ID of the VMM to show the metrics of; if empty, the metrics of all running VMMs are aggregated")
+		c.flagSet.BoolVar(&c.Watch, "watch", false, "When set, the metrics are printed every --watch-interval until interrupted")
+		c.flagSet.DurationVar(&c.WatchInterval, "watch-interval", time.Second*5, "How often to print the metrics with --watch")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *StatsCommandConfig) Validate() error {
+	if c.Watch && c.WatchInterval < time.Second {
+		return fmt.Errorf("--watch-interval must be at least 1s")
+	}
+	return nil
+}
+
+// TagCommandConfig is the tag command configuration.
+type TagCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	Force    bool
+	Platform string
+	Source   string
+	Target   string
+}
+
+// NewTagCommandConfig returns new command configuration.
+func NewTagCommandConfig() *TagCommandConfig {
+	return &TagCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *TagCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.Force, "force", false, "When set, an existing target tag is overwritten")
+		c.flagSet.StringVar(&c.Platform, "platform", "", "Platform of the source rootfs in the os/arch format, for example linux/arm64, the target is tagged for the same platform; empty for the host platform")
+		c.flagSet.StringVar(&c.Source, "source", "", "Tag of the existing rootfs, for example: org/image:version")
+		c.flagSet.StringVar(&c.Target, "target", "", "New tag of the rootfs, for example: org/image:version")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *TagCommandConfig) Validate() error {
+	if ok, _, _, _ := utils.ReferenceDecompose(c.Source, "latest"); !ok {
+		return fmt.Errorf("--source value is invalid: '%s'", c.Source)
+	}
+	if !utils.IsValidTag(c.Target) {
+		return fmt.Errorf("--target value is invalid: '%s'", c.Target)
+	}
+	if utils.NormalizeTag(c.Source) == utils.NormalizeTag(c.Target) {
+		return fmt.Errorf("--source and --target can't be the same")
+	}
+	if _, err := containers.NormalizePlatform(c.Platform); err != nil {
+		return errors.Wrap(err, "--platform value is invalid")
+	}
+	return nil
+}
+
+// VerifyCommandConfig is the verify command configuration.
+type VerifyCommandConfig struct {
+	flagBase
+	ValidatingConfig
+
+	Platform        string
+	RequireChecksum bool
+	Tags            []string
+}
+
+// NewVerifyCommandConfig returns new command configuration.
+func NewVerifyCommandConfig() *VerifyCommandConfig {
+	return &VerifyCommandConfig{Tags: []string{}}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *VerifyCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringVar(&c.Platform, "platform", "", "Platform of the rootfs given by tag in the os/arch format, for example linux/arm64; empty for the host platform")
+		c.flagSet.BoolVar(&c.RequireChecksum, "require-checksum", false, "When set, a rootfs stored without the checksum fails the verification; by default, it is skipped with a warning")
+	}
+	return c.flagSet
+}
+
+// ParseArgs parses the rootfs tags from the command arguments.
+// Without the tags, all stored rootfs files are verified.
+func (c *VerifyCommandConfig) ParseArgs(args []string) error {
+	c.Tags = append([]string{}, args...)
+	return nil
+}
+
+// Validate validates the correctness of the configuration.
+func (c *VerifyCommandConfig) Validate() error {
+	for _, tag := range c.Tags {
+		if ok, _, _, _ := utils.ReferenceDecompose(tag, "latest"); !ok {
+			return fmt.Errorf("tag '%s' is invalid", tag)
+		}
+	}
+	if _, err := containers.NormalizePlatform(c.Platform); err != nil {
+		return errors.Wrap(err, "--platform value is invalid")
+	}
+	return nil
+}
+
+// VersionCommandConfig is the version command configuration.
+type VersionCommandConfig struct {
+	flagBase
+
+	JSON bool
+}
+
+// NewVersionCommandConfig returns new command configuration.
+func NewVersionCommandConfig() *VersionCommandConfig {
+	return &VersionCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *VersionCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.JSON, "json", false, "When set, outputs the version information as JSON")
+	}
+	return c.flagSet
+}
+
+// validateLabelFilters validates the --label filters.
+func validateLabelFilters(filters []string) error {
+	for _, filter := range filters {
+		if _, _, _, err := utils.ParseLabelFilter(filter); err != nil {
+			return errors.Wrap(err, "--label is invalid")
+		}
+	}
+	return nil
+}
+
+// containsPublicKey returns true if the keys contain the key.
+func containsPublicKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, existing := range keys {
+		if bytes.Equal(existing.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/utils"
 )
 
 func TestEnvironmentMerger(t *testing.T) {
@@ -84,3 +88,592 @@ func writeEnvFile(t *testing.T, env map[string]string) (*os.File, error) {
 	tempFile.Close()
 	return tempFile, nil
 }
+
+func TestBaseOSExportShellValidation(t *testing.T) {
+	for _, shell := range []string{"/bin/sh", "/bin/ash", "/busybox/sh"} {
+		cfg := &BaseOSCommandConfig{ExportMode: "auto", ExportShell: shell}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Expected export shell %q to be valid, got error: %v", shell, err)
+		}
+	}
+	for _, shell := range []string{"", "sh", "/bin/sh -x", "bin/ash"} {
+		cfg := &BaseOSCommandConfig{ExportMode: "auto", ExportShell: shell}
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("Expected export shell %q to be invalid", shell)
+		}
+	}
+}
+
+func TestBaseOSExportModeValidation(t *testing.T) {
+	for _, mode := range []string{"auto", "archive", "exec"} {
+		cfg := &BaseOSCommandConfig{ExportMode: mode, ExportShell: "/bin/sh"}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Expected export mode %q to be valid, got error: %v", mode, err)
+		}
+	}
+	for _, mode := range []string{"", "tar", "Auto"} {
+		cfg := &BaseOSCommandConfig{ExportMode: mode, ExportShell: "/bin/sh"}
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("Expected export mode %q to be invalid", mode)
+		}
+	}
+}
+
+func TestBaseOSEstimateFSSizeMBs(t *testing.T) {
+	cfg := &BaseOSCommandConfig{FSSizeFactor: 1.5, FSSizeMarginMBs: 128}
+	if size := cfg.EstimateFSSizeMBs(100 * 1024 * 1024); size != 278 {
+		t.Fatalf("Expected estimated size 278, got %d", size)
+	}
+	// partial megabytes are rounded up:
+	if size := cfg.EstimateFSSizeMBs(1); size != 129 {
+		t.Fatalf("Expected estimated size 129, got %d", size)
+	}
+	if err := (&BaseOSCommandConfig{ExportMode: "auto", ExportShell: "/bin/sh", FSSizeMBs: -1}).Validate(); err == nil {
+		t.Fatal("Expected negative file system size to be invalid")
+	}
+}
+
+func TestMachineMMDSVersionValidation(t *testing.T) {
+	for input, expected := range map[string]string{"": MMDSVersionV1, "v1": MMDSVersionV1, "V2": MMDSVersionV2, "v2": MMDSVersionV2} {
+		machineConfig := NewMachineConfig()
+		machineConfig.Mem = 128
+		machineConfig.MMDSVersion = input
+		if err := machineConfig.Validate(); err != nil {
+			t.Fatalf("Expected MMDS version %q to be valid, got error: %v", input, err)
+		}
+		if machineConfig.MMDSVersionOrDefault() != expected {
+			t.Fatalf("Expected MMDS version %q to resolve to %q, got %q", input, expected, machineConfig.MMDSVersionOrDefault())
+		}
+	}
+	machineConfig := NewMachineConfig()
+	machineConfig.MMDSVersion = "v3"
+	if err := machineConfig.Validate(); err == nil {
+		t.Fatalf("Expected MMDS version v3 to be invalid")
+	}
+}
+
+func TestMachineRateLimitValidation(t *testing.T) {
+	machineConfig := NewMachineConfig()
+	machineConfig.Mem = 128
+	machineConfig.DriveBandwidth = "50MiB/s"
+	machineConfig.DriveOps = "1000/s"
+	machineConfig.NetRxBandwidth = "10MiB/s"
+	machineConfig.NetTxBandwidth = "0"
+	if err := machineConfig.Validate(); err != nil {
+		t.Fatalf("Expected rate limits to be valid, got error: %v", err)
+	}
+	for _, update := range []func(*MachineConfig){
+		func(c *MachineConfig) { c.DriveBandwidth = "-1MiB/s" },
+		func(c *MachineConfig) { c.DriveOps = "-100" },
+		func(c *MachineConfig) { c.NetRxBandwidth = "10Mbit/s" },
+		func(c *MachineConfig) { c.NetTxBandwidth = "fast" },
+	} {
+		machineConfig := NewMachineConfig()
+		machineConfig.Mem = 128
+		update(machineConfig)
+		if err := machineConfig.Validate(); err == nil {
+			t.Fatalf("Expected invalid rate limit to be rejected: %+v", machineConfig)
+		}
+	}
+}
+
+func TestMachineVsockValidation(t *testing.T) {
+	machineConfig := NewMachineConfig()
+	machineConfig.Mem = 128
+	if machineConfig.HasVsock() {
+		t.Fatalf("Expected no vsock device without --vsock-cid")
+	}
+	machineConfig.VsockCID = 3
+	if err := machineConfig.Validate(); err != nil {
+		t.Fatalf("Expected vsock configuration to be valid, got error: %v", err)
+	}
+	if path := machineConfig.VsockPathOrDefault(); path != DefaultVsockPath {
+		t.Fatalf("Expected default vsock path, got: %q", path)
+	}
+	for _, update := range []func(*MachineConfig){
+		func(c *MachineConfig) { c.VsockCID = 2 },
+		func(c *MachineConfig) { c.VsockPath = "vsock.sock" },
+		func(c *MachineConfig) { c.VsockPath = "/run/firebuild/vsock/guest.sock" },
+	} {
+		machineConfig := NewMachineConfig()
+		machineConfig.Mem = 128
+		machineConfig.VsockCID = 3
+		update(machineConfig)
+		if err := machineConfig.Validate(); err == nil {
+			t.Fatalf("Expected invalid vsock configuration to be rejected: %+v", machineConfig)
+		}
+	}
+}
+
+func TestMixedCaseTagValidation(t *testing.T) {
+	if err := (&RunCommandConfig{From: "Tests/Postgres:13", Hostname: "vmm"}).Validate(); err != nil {
+		t.Fatalf("Expected mixed-case --from resolving to a valid tag to be accepted, got error: %v", err)
+	}
+	if err := (&RunCommandConfig{From: "Tests/Pöstgres:13", Hostname: "vmm"}).Validate(); err == nil {
+		t.Fatalf("Expected mixed-case --from not resolving to a valid tag to be rejected")
+	}
+	if err := (&TagCommandConfig{Source: "tests/image:1.0", Target: "Tests/Image:1.0"}).Validate(); err == nil {
+		t.Fatalf("Expected --source and --target differing only in case to be rejected")
+	}
+}
+
+func TestRootfsPlatformValidation(t *testing.T) {
+	for _, platform := range []string{"", "linux/arm64", "aarch64", "amd64"} {
+		if err := (&RmCommandConfig{Tags: []string{"tests/image:1.0"}, Platform: platform}).Validate(); err != nil {
+			t.Fatalf("Expected --platform '%s' to be accepted, got error: %v", platform, err)
+		}
+	}
+	if err := (&RmCommandConfig{Tags: []string{"tests/image:1.0"}, Platform: "linux/s390x"}).Validate(); err == nil {
+		t.Fatalf("Expected unsupported --platform to be rejected")
+	}
+	if err := (&GetCommandConfig{Tag: "tests/image:1.0", Name: "deploy.json", Platform: "windows/amd64"}).Validate(); err == nil {
+		t.Fatalf("Expected --platform of another OS to be rejected")
+	}
+	if err := (&TagCommandConfig{Source: "tests/image:1.0", Target: "tests/image:1.1", Platform: "arm"}).Validate(); err == nil {
+		t.Fatalf("Expected unsupported --platform to be rejected")
+	}
+	if err := (&VerifyCommandConfig{Tags: []string{"tests/image:1.0"}, Platform: "linux/arm64/v8"}).Validate(); err == nil {
+		t.Fatalf("Expected invalid --platform to be rejected")
+	}
+}
+
+func TestLabelValidation(t *testing.T) {
+	labelConfig := NewLabelCommandConfig()
+	if err := labelConfig.ParseArgs([]string{"vmmid", "env=prod", "team="}); err != nil {
+		t.Fatalf("Expected label arguments to parse, got error: %v", err)
+	}
+	if labelConfig.VMMID != "vmmid" || labelConfig.Labels["env"] != "prod" || labelConfig.Labels["team"] != "" {
+		t.Fatalf("Expected VMM ID and labels from the arguments, got: %q %v", labelConfig.VMMID, labelConfig.Labels)
+	}
+	if err := labelConfig.Validate(); err != nil {
+		t.Fatalf("Expected label configuration to be valid, got error: %v", err)
+	}
+	if err := NewLabelCommandConfig().ParseArgs([]string{"vmmid", "-env=prod"}); err == nil {
+		t.Fatalf("Expected invalid label key to be rejected")
+	}
+	if err := NewLabelCommandConfig().ParseArgs([]string{"vmmid", "env"}); err == nil {
+		t.Fatalf("Expected label without a value to be rejected")
+	}
+	onlyVMMID := NewLabelCommandConfig()
+	onlyVMMID.ParseArgs([]string{"vmmid"})
+	if err := onlyVMMID.Validate(); err == nil {
+		t.Fatalf("Expected label command without labels to be rejected")
+	}
+	setAndRemove := NewLabelCommandConfig()
+	setAndRemove.ParseArgs([]string{"vmmid", "env=prod"})
+	setAndRemove.Remove = []string{"env"}
+	if err := setAndRemove.Validate(); err == nil {
+		t.Fatalf("Expected label set and removed at the same time to be rejected")
+	}
+
+	if err := (&RunCommandConfig{Hostname: "vmm", VMLabels: map[string]string{"env": "prod"}}).Validate(); err != nil {
+		t.Fatalf("Expected --vm-label to be valid, got error: %v", err)
+	}
+	if err := (&RunCommandConfig{Hostname: "vmm", VMLabels: map[string]string{"env prod": ""}}).Validate(); err == nil {
+		t.Fatalf("Expected invalid --vm-label key to be rejected")
+	}
+	if err := (&LsCommandConfig{Labels: []string{"env=prod", "team"}}).Validate(); err != nil {
+		t.Fatalf("Expected --label filters to be valid, got error: %v", err)
+	}
+	if err := (&InspectCommandConfig{VMMID: "vmmid", Labels: []string{"env"}}).Validate(); err == nil {
+		t.Fatalf("Expected --vmm-id and --label together to be rejected")
+	}
+	if err := (&InspectCommandConfig{}).Validate(); err == nil {
+		t.Fatalf("Expected inspect without --vmm-id and --label to be rejected")
+	}
+}
+
+func TestUserPublicKeys(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Expected temp directory to be created, got error: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	writeKey := func(name string) string {
+		privateKey, err := utils.GenerateRSAPrivateKey(1024)
+		if err != nil {
+			t.Fatalf("Expected private key to be generated, got error: %v", err)
+		}
+		publicKey, err := utils.GetSSHKey(privateKey)
+		if err != nil {
+			t.Fatalf("Expected public key, got error: %v", err)
+		}
+		path := filepath.Join(tempDir, name)
+		if err := ioutil.WriteFile(path, utils.MarshalSSHPublicKey(publicKey), 0644); err != nil {
+			t.Fatalf("Expected public key to be written, got error: %v", err)
+		}
+		return path
+	}
+
+	defaultKey := writeKey("default.pub")
+	deployKey := writeKey("deploy.pub")
+
+	cfg := &RunCommandConfig{
+		IdentityFiles: []string{defaultKey},
+		UserIdentityFiles: []string{
+			"deploy:" + deployKey,
+			"deploy:" + deployKey,
+			"admin:" + defaultKey,
+			"admin:" + deployKey,
+			"alpine:" + deployKey,
+		},
+	}
+	userKeys, err := cfg.UserPublicKeys("alpine")
+	if err != nil {
+		t.Fatalf("Expected user keys to be resolved, got error: %v", err)
+	}
+	expectedCounts := map[string]int{"alpine": 2, "deploy": 1, "admin": 2}
+	if len(userKeys) != len(expectedCounts) {
+		t.Fatalf("Expected keys of %d users, got: %d", len(expectedCounts), len(userKeys))
+	}
+	for user, count := range expectedCounts {
+		if len(userKeys[user]) != count {
+			t.Fatalf("Expected %d keys for user '%s', got: %d", count, user, len(userKeys[user]))
+		}
+	}
+
+	// without the default user, the identity file keys are not deployed:
+	userKeys, err = (&RunCommandConfig{IdentityFiles: []string{defaultKey}}).UserPublicKeys("")
+	if err != nil {
+		t.Fatalf("Expected user keys to be resolved, got error: %v", err)
+	}
+	if len(userKeys) != 0 {
+		t.Fatalf("Expected no user keys without the default user, got: %d", len(userKeys))
+	}
+
+	if err := (&RunCommandConfig{Hostname: "vmm", UserIdentityFiles: []string{"deploy"}}).Validate(); err == nil {
+		t.Fatalf("Expected --user-identity-file without the path to be rejected")
+	}
+	if err := (&RunCommandConfig{Hostname: "vmm", UserIdentityFiles: []string{"deploy:" + filepath.Join(tempDir, "missing.pub")}}).Validate(); err == nil {
+		t.Fatalf("Expected --user-identity-file with a missing file to be rejected")
+	}
+}
+
+func TestPublishValidation(t *testing.T) {
+	if err := (&PublishCommandConfig{Ports: []string{"8080:80"}}).Validate(); err == nil {
+		t.Fatalf("Expected publish without VMM ID to be rejected")
+	}
+	if err := (&PublishCommandConfig{VMMID: "vmmid"}).Validate(); err == nil {
+		t.Fatalf("Expected publish without --port and --remove to be rejected")
+	}
+	if err := (&PublishCommandConfig{VMMID: "vmmid", Ports: []string{"8080:80"}}).Validate(); err != nil {
+		t.Fatalf("Expected publish with --port to be valid, got error: %v", err)
+	}
+	if err := (&PublishCommandConfig{VMMID: "vmmid", Remove: []string{"8080:80"}}).Validate(); err != nil {
+		t.Fatalf("Expected publish with --remove to be valid, got error: %v", err)
+	}
+}
+
+func TestBenchValidation(t *testing.T) {
+	if err := (&BenchCommandConfig{Iterations: 1}).Validate(); err == nil {
+		t.Fatalf("Expected bench without --rootfs-arg and --run-arg to be rejected")
+	}
+	if err := (&BenchCommandConfig{Iterations: 0, RunArgs: []string{"--from=tests/alpine:3.13"}}).Validate(); err == nil {
+		t.Fatalf("Expected bench with no iterations to be rejected")
+	}
+	if err := (&BenchCommandConfig{Iterations: 3, RunArgs: []string{"--from=tests/alpine:3.13"}}).Validate(); err != nil {
+		t.Fatalf("Expected bench with --run-arg to be valid, got error: %v", err)
+	}
+}
+
+func TestKillValidation(t *testing.T) {
+	newConfig := func(all bool, vmmID string) *KillCommandConfig {
+		return &KillCommandConfig{All: all, Parallelism: 4, ShutdownTimeout: time.Second, VMMID: vmmID}
+	}
+	if err := newConfig(false, "").Validate(); err == nil {
+		t.Fatalf("Expected kill without --vmm-id and --all to be rejected")
+	}
+	if err := newConfig(true, "abc").Validate(); err == nil {
+		t.Fatalf("Expected kill with --vmm-id and --all to be rejected")
+	}
+	if err := newConfig(false, "abc[").Validate(); err == nil {
+		t.Fatalf("Expected invalid --vmm-id pattern to be rejected")
+	}
+	noParallelism := newConfig(true, "")
+	noParallelism.Parallelism = 0
+	if err := noParallelism.Validate(); err == nil {
+		t.Fatalf("Expected --parallelism 0 to be rejected")
+	}
+
+	single := newConfig(false, "abc")
+	if err := single.Validate(); err != nil {
+		t.Fatalf("Expected kill with --vmm-id to be valid, got error: %v", err)
+	}
+	if single.IsBatch() {
+		t.Fatalf("Expected kill with a VMM ID not to be a batch")
+	}
+	pattern := newConfig(false, "ab*")
+	if !pattern.IsBatch() || !pattern.Matches("abc") || pattern.Matches("xabc") {
+		t.Fatalf("Expected --vmm-id pattern to match the VMM IDs by the glob")
+	}
+	all := newConfig(true, "")
+	if !all.IsBatch() || !all.Matches("abc") {
+		t.Fatalf("Expected --all to match every VMM ID")
+	}
+}
+
+func TestPurgeValidation(t *testing.T) {
+	if err := (&PurgeCommandConfig{}).Validate(); err == nil {
+		t.Fatalf("Expected purge without --force and --dry-run to be rejected")
+	}
+	if err := (&PurgeCommandConfig{DryRun: true, Force: true}).Validate(); err == nil {
+		t.Fatalf("Expected purge with --force and --dry-run to be rejected")
+	}
+	if err := (&PurgeCommandConfig{Force: true, OrphanMinAge: -time.Second}).Validate(); err == nil {
+		t.Fatalf("Expected negative --orphan-min-age to be rejected")
+	}
+	if err := (&PurgeCommandConfig{DryRun: true}).Validate(); err != nil {
+		t.Fatalf("Expected purge with --dry-run to be valid, got error: %v", err)
+	}
+	if err := (&PurgeCommandConfig{Force: true, OrphanMinAge: time.Minute}).Validate(); err != nil {
+		t.Fatalf("Expected purge with --force to be valid, got error: %v", err)
+	}
+}
+
+func TestSelftestValidation(t *testing.T) {
+	valid := func() *SelftestCommandConfig {
+		return &SelftestCommandConfig{
+			BaseImage:      "alpine:3.13",
+			CNINetworkName: "machine-builds",
+			SSHUser:        "alpine",
+			Tag:            "firebuild/selftest:1.0.0",
+			VMLinuxID:      "vmlinux-v5.8",
+		}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Expected selftest to be valid, got error: %v", err)
+	}
+	withBaseOS := valid()
+	withBaseOS.WithBaseOS = true
+	if err := withBaseOS.Validate(); err == nil {
+		t.Fatalf("Expected --with-baseos without --baseos-dockerfile to be rejected")
+	}
+	withBaseOS.BaseOSDockerfile = "baseos/_/alpine/3.13/Dockerfile"
+	if err := withBaseOS.Validate(); err == nil {
+		t.Fatalf("Expected relative --baseos-dockerfile to be rejected")
+	}
+	withBaseOS.BaseOSDockerfile = "/baseos/_/alpine/3.13/Dockerfile"
+	if err := withBaseOS.Validate(); err != nil {
+		t.Fatalf("Expected --with-baseos with --baseos-dockerfile to be valid, got error: %v", err)
+	}
+	noNetwork := valid()
+	noNetwork.CNINetworkName = ""
+	if err := noNetwork.Validate(); err == nil {
+		t.Fatalf("Expected selftest without --cni-network-name to be rejected")
+	}
+	invalidTag := valid()
+	invalidTag.Tag = "selftest"
+	if err := invalidTag.Validate(); err == nil {
+		t.Fatalf("Expected invalid --tag to be rejected")
+	}
+}
+
+func TestRootfsOutputValidation(t *testing.T) {
+	if err := (&RootfsCommandConfig{MaxParallelStages: 1, Output: "yaml"}).Validate(); err == nil {
+		t.Fatalf("Expected unsupported --output to be rejected")
+	}
+	for _, output := range []string{"text", "json"} {
+		if err := (&RootfsCommandConfig{MaxParallelStages: 1, Output: output}).Validate(); err != nil {
+			t.Fatalf("Expected --output %s to be valid, got error: %v", output, err)
+		}
+	}
+}
+
+func TestLsOutputValidation(t *testing.T) {
+	if err := (&LsCommandConfig{Output: "yaml"}).Validate(); err == nil {
+		t.Fatalf("Expected unsupported --output to be rejected")
+	}
+	for _, output := range []string{"table", "json"} {
+		if err := (&LsCommandConfig{Output: output}).Validate(); err != nil {
+			t.Fatalf("Expected --output %s to be valid, got error: %v", output, err)
+		}
+	}
+}
+
+func TestRootfsServiceEnvPathValidation(t *testing.T) {
+	for _, path := range []string{"etc/cmd.env", "/etc/../cmd.env", "/"} {
+		if err := (&RootfsCommandConfig{MaxParallelStages: 1, ServiceEnvPath: path}).Validate(); err == nil {
+			t.Fatalf("Expected --service-env-path '%s' to be rejected", path)
+		}
+	}
+	if err := (&RootfsCommandConfig{MaxParallelStages: 1, ServiceEnvPath: "/etc/conf.d/app"}).Validate(); err != nil {
+		t.Fatalf("Expected --service-env-path to be valid, got error: %v", err)
+	}
+}
+
+func TestRootfsConcurrency(t *testing.T) {
+	for _, invalid := range []*RootfsCommandConfig{{MaxParallelStages: -1}, {MaxConcurrency: -1}} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("Expected negative limits to be rejected: %+v", invalid)
+		}
+	}
+	config := &RootfsCommandConfig{MaxConcurrency: 3}
+	if config.StageParallelism() != 3 {
+		t.Fatalf("Expected --max-parallel-stages to default to --max-concurrency, got %d", config.StageParallelism())
+	}
+	config.MaxParallelStages = 2
+	if config.StageParallelism() != 2 {
+		t.Fatalf("Expected --max-parallel-stages 2, got %d", config.StageParallelism())
+	}
+	config.MaxParallelStages = 8
+	if config.StageParallelism() != 3 {
+		t.Fatalf("Expected --max-parallel-stages to be capped at --max-concurrency, got %d", config.StageParallelism())
+	}
+}
+
+func TestRootfsMaxResourceSizeValidation(t *testing.T) {
+	if err := (&RootfsCommandConfig{MaxParallelStages: 1, MaxResourceSize: -1}).Validate(); err == nil {
+		t.Fatalf("Expected negative --max-resource-size to be rejected")
+	}
+}
+
+func TestLsFilterAndSortValidation(t *testing.T) {
+	if err := (&LsCommandConfig{Sort: "pid"}).Validate(); err == nil {
+		t.Fatalf("Expected unsupported --sort to be rejected")
+	}
+	if err := (&LsCommandConfig{Filters: []string{"version=13"}}).Validate(); err == nil {
+		t.Fatalf("Expected unsupported --filter to be rejected")
+	}
+	valid := &LsCommandConfig{Filters: []string{"org=tests", "image=postgres", "label.env=prod", "label.team"}, Sort: "created", Reverse: true}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Expected filters and sort to be valid, got error: %v", err)
+	}
+}
+
+func TestRunFromVersionConstraintValidation(t *testing.T) {
+	if err := (&RunCommandConfig{From: "tests/postgres:^13.1", Hostname: "vmm"}).Validate(); err != nil {
+		t.Fatalf("Expected --from version constraint to be valid, got error: %v", err)
+	}
+	if err := (&RunCommandConfig{From: "tests/postgres:^13.a", Hostname: "vmm"}).Validate(); err == nil {
+		t.Fatalf("Expected invalid --from version constraint to be rejected")
+	}
+	if err := (&RunCommandConfig{From: "Tests/Postgres", Hostname: "vmm"}).Validate(); err != nil {
+		t.Fatalf("Expected --from without the version to be valid, got error: %v", err)
+	}
+	if err := (&LsCommandConfig{Sort: "semver"}).Validate(); err != nil {
+		t.Fatalf("Expected semver --sort to be valid, got error: %v", err)
+	}
+}
+
+func TestRootfsAttachValidation(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Expected temp file to be created, got error: %v", err)
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	if err := (&RootfsCommandConfig{MaxParallelStages: 1, Attachments: map[string]string{"deploy.json": tempFile.Name()}}).Validate(); err != nil {
+		t.Fatalf("Expected --attach to be valid, got error: %v", err)
+	}
+	if err := (&RootfsCommandConfig{MaxParallelStages: 1, Attachments: map[string]string{"../deploy.json": tempFile.Name()}}).Validate(); err == nil {
+		t.Fatalf("Expected invalid --attach name to be rejected")
+	}
+	if err := (&RootfsCommandConfig{MaxParallelStages: 1, Attachments: map[string]string{"deploy.json": filepath.Dir(tempFile.Name())}}).Validate(); err == nil {
+		t.Fatalf("Expected --attach directory to be rejected")
+	}
+}
+
+func TestGetArgs(t *testing.T) {
+	config := NewGetCommandConfig()
+	if err := config.ParseArgs([]string{"tests/postgres:13"}); err == nil {
+		t.Fatalf("Expected missing attachment name to be rejected")
+	}
+	if err := config.ParseArgs([]string{"tests/postgres:13", "deploy.json"}); err != nil {
+		t.Fatalf("Expected arguments to be parsed, got error: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected configuration to be valid, got error: %v", err)
+	}
+	config.Name = "../deploy.json"
+	if err := config.Validate(); err == nil {
+		t.Fatalf("Expected invalid attachment name to be rejected")
+	}
+}
+
+func TestVerifyArgs(t *testing.T) {
+	commandConfig := NewVerifyCommandConfig()
+	if err := commandConfig.ParseArgs([]string{}); err != nil {
+		t.Fatalf("Expected no tags to be valid, got error: %v", err)
+	}
+	if err := commandConfig.Validate(); err != nil {
+		t.Fatalf("Expected verifying all rootfs files to be valid, got error: %v", err)
+	}
+	// without the version, the tag resolves to the latest stored version:
+	if err := commandConfig.ParseArgs([]string{"tests/postgres:13", "tests/postgres", "tests/postgres:^13"}); err != nil {
+		t.Fatalf("Expected tags to parse, got error: %v", err)
+	}
+	if err := commandConfig.Validate(); err != nil {
+		t.Fatalf("Expected tags and version constraints to be valid, got error: %v", err)
+	}
+	if err := commandConfig.ParseArgs([]string{"tests/postgres:13", "postgres"}); err != nil {
+		t.Fatalf("Expected tags to parse, got error: %v", err)
+	}
+	if err := commandConfig.Validate(); err == nil {
+		t.Fatalf("Expected invalid tag to be rejected")
+	}
+}
+
+func TestRunEgressDefaultPolicy(t *testing.T) {
+	if err := (&RunCommandConfig{EgressDefault: "reject", Hostname: "vmm"}).Validate(); err == nil {
+		t.Fatalf("Expected unsupported --egress-default to be rejected")
+	}
+	if (&RunCommandConfig{}).HasEgressPolicy() {
+		t.Fatalf("Expected no egress policy without the egress flags")
+	}
+	if policy := (&RunCommandConfig{EgressAllow: []string{"10.0.0.0/8:443"}}).EgressDefaultPolicy(); policy != "deny" {
+		t.Fatalf("Expected egress denied by default with the allowed destinations, got: %q", policy)
+	}
+	if policy := (&RunCommandConfig{EgressDeny: []string{"10.0.0.0/8"}}).EgressDefaultPolicy(); policy != "allow" {
+		t.Fatalf("Expected egress allowed by default with only the denied destinations, got: %q", policy)
+	}
+	if policy := (&RunCommandConfig{EgressDefault: "deny"}).EgressDefaultPolicy(); policy != "deny" {
+		t.Fatalf("Expected the given egress default, got: %q", policy)
+	}
+}
+
+func TestRunDrivesValidation(t *testing.T) {
+	if err := (&RunCommandConfig{Drives: []string{"/data/disk.ext4:rw", "/data/seed.ext4:ro"}, Hostname: "vmm"}).Validate(); err != nil {
+		t.Fatalf("Expected valid drives to be accepted, got error: %v", err)
+	}
+	if err := (&RunCommandConfig{Drives: []string{"/data/disk.ext4:rw", "/other/disk.ext4:ro"}, Hostname: "vmm"}).Validate(); err == nil {
+		t.Fatalf("Expected drives with the same file name to be rejected")
+	}
+	if err := (&RunCommandConfig{Drives: []string{"data/disk.ext4:rw"}, Hostname: "vmm"}).Validate(); err == nil {
+		t.Fatalf("Expected a relative drive path to be rejected")
+	}
+}
+
+func TestRetryConfig(t *testing.T) {
+	retryConfig := NewRetryConfig()
+	if err := retryConfig.FlagSet().Parse([]string{}); err != nil {
+		t.Fatalf("Expected flags to parse, got error: %v", err)
+	}
+	if err := retryConfig.Validate(); err != nil {
+		t.Fatalf("Expected default retry configuration to be valid, got error: %v", err)
+	}
+	policy := retryConfig.Policy()
+	if policy.Attempts != 4 || policy.InitialBackoff != time.Second*2 || policy.MaxBackoff != time.Minute {
+		t.Fatalf("Expected default retry policy, got: %+v", policy)
+	}
+
+	// the deprecated pull retry flags take precedence:
+	retryConfig = NewRetryConfig()
+	if err := retryConfig.FlagSet().Parse([]string{"--retry-attempts=2", "--pull-retries=0", "--pull-retry-backoff=5s"}); err != nil {
+		t.Fatalf("Expected flags to parse, got error: %v", err)
+	}
+	policy = retryConfig.Policy()
+	if policy.Attempts != 1 || policy.InitialBackoff != time.Second*5 {
+		t.Fatalf("Expected deprecated pull retry flags to apply, got: %+v", policy)
+	}
+
+	for _, args := range [][]string{{"--retry-attempts=0"}, {"--retry-jitter=1.5"}, {"--retry-max-backoff=-1s"}, {"--pull-retries=-2"}} {
+		retryConfig := NewRetryConfig()
+		if err := retryConfig.FlagSet().Parse(args); err != nil {
+			t.Fatalf("Expected flags to parse, got error: %v", err)
+		}
+		if err := retryConfig.Validate(); err == nil {
+			t.Fatalf("Expected invalid retry configuration to be rejected: %v", args)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package configs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// DockerConfig contains the Docker client settings.
+type DockerConfig struct {
+	flagBase
+	ValidatingConfig
+
+	BuildTimeout   time.Duration
+	ClientTimeout  time.Duration
+	ConfigFile     string
+	InspectTimeout time.Duration
+	Platform       string
+	PullTimeout    time.Duration
+	RegistryAuths  []string
+	RegistryTokens []string
+	SaveTimeout    time.Duration
+	StopTimeout    time.Duration
+}
+
+// NewDockerConfig returns new Docker configuration.
+func NewDockerConfig() *DockerConfig {
+	return &DockerConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *DockerConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.DurationVar(&c.BuildTimeout, "docker-build-timeout", time.Minute*30, "Maximum duration of a Docker image build, 0 disables the timeout")
+		c.flagSet.DurationVar(&c.ClientTimeout, "docker-client-timeout", time.Second*10, "Maximum duration of the Docker client API version negotiation, 0 disables the timeout")
+		c.flagSet.StringVar(&c.ConfigFile, "docker-config", "", "Path to the Docker CLI config.json with the registry credentials; if empty, $DOCKER_CONFIG/config.json or ~/.docker/config.json")
+		c.flagSet.DurationVar(&c.InspectTimeout, "docker-inspect-timeout", time.Minute, "Maximum duration of a Docker image lookup or removal, 0 disables the timeout")
+		c.flagSet.StringVar(&c.Platform, "platform", "", "Target platform of the Docker operations in the os/arch format, for example linux/arm64; empty for the host platform, other platforms require QEMU binfmt_misc emulation")
+		c.flagSet.DurationVar(&c.PullTimeout, "docker-pull-timeout", time.Minute*15, "Maximum duration of a Docker image pull, 0 disables the timeout")
+		c.flagSet.StringArrayVar(&c.RegistryAuths, "registry-auth", []string{}, "Registry credentials in the hostname=username:password format, take precedence over the Docker config, multiple OK")
+		c.flagSet.StringArrayVar(&c.RegistryTokens, "registry-token", []string{}, "Registry bearer token in the hostname=token format, takes precedence over the Docker config, multiple OK")
+		c.flagSet.DurationVar(&c.SaveTimeout, "docker-save-timeout", time.Minute*15, "Maximum duration of a Docker image save or file system export, 0 disables the timeout")
+		c.flagSet.DurationVar(&c.StopTimeout, "docker-stop-timeout", time.Second*30, "Amount of time a Docker container is given to stop gracefully before it is killed, 0 kills the container immediately")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *DockerConfig) Validate() error {
+	for flag, values := range map[string][]string{
+		"--registry-auth":  c.RegistryAuths,
+		"--registry-token": c.RegistryTokens,
+	} {
+		for _, value := range values {
+			// the value holds credentials, do not include it in the error:
+			if parts := strings.SplitN(value, "=", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("%s value invalid: expected hostname=credentials", flag)
+			}
+		}
+	}
+	for flag, value := range map[string]time.Duration{
+		"--docker-build-timeout":   c.BuildTimeout,
+		"--docker-client-timeout":  c.ClientTimeout,
+		"--docker-inspect-timeout": c.InspectTimeout,
+		"--docker-pull-timeout":    c.PullTimeout,
+		"--docker-save-timeout":    c.SaveTimeout,
+		"--docker-stop-timeout":    c.StopTimeout,
+	} {
+		if value < 0 {
+			return fmt.Errorf("%s can't be negative", flag)
+		}
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/combust-labs/firebuild/pkg/strategy/arbitrary"
+	"github.com/combust-labs/firebuild/pkg/vmm/drives"
 	"github.com/firecracker-microvm/firecracker-go-sdk"
 	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
 )
@@ -25,6 +26,9 @@ func DefaultFirectackerStrategy(machineConfig *MachineConfig) arbitrary.PlacingS
 // FcConfigProvider is a Firecracker SDK configuration builder provider.
 type FcConfigProvider interface {
 	ToSDKConfig() firecracker.Config
+	WithCNIConfig(*CNIConfig) FcConfigProvider
+	WithConsoleOutput(io.Writer) FcConfigProvider
+	WithDrives([]*drives.Drive) FcConfigProvider
 	WithHandlersAdapter(firecracker.HandlersAdapter) FcConfigProvider
 	WithVethIfaceName(string) FcConfigProvider
 }
@@ -33,6 +37,9 @@ type defaultFcConfigProvider struct {
 	jailingFcConfig *JailingFirecrackerConfig
 	machineConfig   *MachineConfig
 
+	cniConfig     *CNIConfig
+	consoleOutput io.Writer
+	drives        []*drives.Drive
 	fcStrategy    firecracker.HandlersAdapter
 	vethIfaceName string
 }
@@ -59,15 +66,22 @@ func (c *defaultFcConfigProvider) ToSDKConfig() firecracker.Config {
 		KernelImagePath: c.machineConfig.KernelOverride(),
 		KernelArgs:      c.machineConfig.KernelArgs,
 		NetNS:           c.jailingFcConfig.NetNS,
-		Drives: []models.Drive{
-			{
-				DriveID:      firecracker.String("1"),
-				PathOnHost:   firecracker.String(c.machineConfig.RootfsOverride()),
-				IsRootDevice: firecracker.Bool(true),
-				IsReadOnly:   firecracker.Bool(false),
-				Partuuid:     c.machineConfig.RootDrivePartUUID,
-			},
-		},
+		Drives: func() []models.Drive {
+			result := []models.Drive{
+				{
+					DriveID:      firecracker.String("1"),
+					PathOnHost:   firecracker.String(c.machineConfig.RootfsOverride()),
+					IsRootDevice: firecracker.Bool(true),
+					IsReadOnly:   firecracker.Bool(false),
+					Partuuid:     c.machineConfig.RootDrivePartUUID,
+				},
+			}
+			// the additional drives follow the root device:
+			for _, drive := range c.drives {
+				result = append(result, drive.ToSDKDrive())
+			}
+			return result
+		}(),
 		NetworkInterfaces: []firecracker.NetworkInterface{{
 			AllowMMDS: !c.machineConfig.NoMMDS,
 			CNIConfiguration: &firecracker.CNIConfiguration{
@@ -81,9 +95,38 @@ func (c *defaultFcConfigProvider) ToSDKConfig() firecracker.Config {
 					}
 					return [][2]string{}
 				}(),
+				BinPath: func() []string {
+					if c.cniConfig == nil {
+						return nil
+					}
+					return []string{c.cniConfig.BinDir}
+				}(),
+				ConfDir: func() string {
+					if c.cniConfig == nil {
+						return ""
+					}
+					return c.cniConfig.ConfDir
+				}(),
+				// the SDK caches the results in a directory per VMM, the CNI cleanup removes it:
+				CacheDir: func() string {
+					if c.cniConfig == nil {
+						return ""
+					}
+					return filepath.Join(c.cniConfig.CacheDir, c.jailingFcConfig.VMMID())
+				}(),
 			},
 		}},
-		VsockDevices: []firecracker.VsockDevice{},
+		VsockDevices: func() []firecracker.VsockDevice {
+			if !c.machineConfig.HasVsock() {
+				return []firecracker.VsockDevice{}
+			}
+			// the socket path is in the jail:
+			return []firecracker.VsockDevice{{
+				ID:   "1",
+				Path: c.machineConfig.VsockPathOrDefault(),
+				CID:  c.machineConfig.VsockCID,
+			}}
+		}(),
 		MachineCfg: models.MachineConfiguration{
 			VcpuCount:   firecracker.Int64(c.machineConfig.CPU),
 			CPUTemplate: models.CPUTemplate(c.machineConfig.CPUTemplate),
@@ -105,7 +148,13 @@ func (c *defaultFcConfigProvider) ToSDKConfig() firecracker.Config {
 				}
 				return c.fcStrategy
 			}(),
-			Stdout: os.Stdout,
+			// the serial console is the standard output of the VMM:
+			Stdout: func() io.Writer {
+				if c.consoleOutput == nil {
+					return os.Stdout
+				}
+				return io.MultiWriter(os.Stdout, c.consoleOutput)
+			}(),
 			Stderr: os.Stderr,
 			// do not pass stdin because the build VMM does not require input
 			// and it messes up the terminal
@@ -115,6 +164,21 @@ func (c *defaultFcConfigProvider) ToSDKConfig() firecracker.Config {
 	}
 }
 
+func (c *defaultFcConfigProvider) WithCNIConfig(input *CNIConfig) FcConfigProvider {
+	c.cniConfig = input
+	return c
+}
+
+func (c *defaultFcConfigProvider) WithConsoleOutput(input io.Writer) FcConfigProvider {
+	c.consoleOutput = input
+	return c
+}
+
+func (c *defaultFcConfigProvider) WithDrives(input []*drives.Drive) FcConfigProvider {
+	c.drives = input
+	return c
+}
+
 func (c *defaultFcConfigProvider) WithHandlersAdapter(input firecracker.HandlersAdapter) FcConfigProvider {
 	c.fcStrategy = input
 	return c
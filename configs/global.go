@@ -0,0 +1,93 @@
+package configs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// GlobalConfigFlagName is the name of the global configuration file flag.
+const GlobalConfigFlagName = "config"
+
+// DefaultGlobalConfigFile returns the default location of the global configuration file:
+// ~/.firebuild/config.yaml. Returns an empty string if the home directory can't be resolved.
+func DefaultGlobalConfigFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".firebuild", "config.yaml")
+}
+
+// ApplyGlobalConfig reads the global configuration file and sets the flags
+// which were not explicitly set on the command line to the values from the file.
+//
+// The keys of the file are the flag names, keys without a matching flag are ignored
+// so a single file can provide the defaults for all commands.
+// The flags set from the file are not marked as changed, the profile settings
+// take precedence over them: config file < profile < command line.
+//
+// A missing file is an error only when required is true.
+func ApplyGlobalConfig(flags *pflag.FlagSet, path string, required bool) error {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return errors.Wrapf(err, "--%s '%s' can't be read", GlobalConfigFlagName, path)
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if filepath.Ext(path) == "" {
+		v.SetConfigType("yaml")
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return errors.Wrapf(err, "--%s '%s' can't be parsed", GlobalConfigFlagName, path)
+	}
+	var applyErr error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if applyErr != nil || f.Changed || f.Name == GlobalConfigFlagName || !v.IsSet(f.Name) {
+			return
+		}
+		if err := setFlagFromConfig(f, v.Get(f.Name)); err != nil {
+			applyErr = errors.Wrapf(err, "--%s '%s': invalid value for '%s'", GlobalConfigFlagName, path, f.Name)
+		}
+	})
+	return applyErr
+}
+
+// setFlagFromConfig sets the flag value without marking the flag as changed.
+func setFlagFromConfig(f *pflag.Flag, value interface{}) error {
+	if sliceValue, ok := f.Value.(pflag.SliceValue); ok {
+		items, err := cast.ToStringSliceE(value)
+		if err != nil {
+			return err
+		}
+		return sliceValue.Replace(items)
+	}
+	switch tvalue := value.(type) {
+	case map[string]interface{}:
+		items := []string{}
+		for k, v := range tvalue {
+			items = append(items, fmt.Sprintf("%s=%s", k, cast.ToString(v)))
+		}
+		sort.Strings(items)
+		return f.Value.Set(strings.Join(items, ","))
+	case []interface{}:
+		return fmt.Errorf("a list is not supported by the flag")
+	}
+	stringValue, err := cast.ToStringE(value)
+	if err != nil {
+		return err
+	}
+	return f.Value.Set(stringValue)
+}
@@ -0,0 +1,160 @@
+package configs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	profileModel "github.com/combust-labs/firebuild/pkg/profiles/model"
+	"github.com/spf13/pflag"
+)
+
+func TestGlobalConfigPrecedence(t *testing.T) {
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Expected temp directory to be created, got error: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	configFile := filepath.Join(tempDir, "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(`binary-firecracker: /config/firecracker
+binary-jailer: /config/jailer
+chroot-base: /config/jailer
+run-cache: /config/run-cache
+tracing-enable: true
+`), 0644); err != nil {
+		t.Fatalf("Expected config file to be written, got error: %v", err)
+	}
+
+	jailerConfig := NewJailingFirecrackerConfig()
+	runCacheConfig := NewRunCacheConfig()
+	tracingConfig := NewTracingConfig("test")
+
+	flags := &pflag.FlagSet{}
+	flags.AddFlagSet(jailerConfig.FlagSet())
+	flags.AddFlagSet(runCacheConfig.FlagSet())
+	flags.AddFlagSet(tracingConfig.FlagSet())
+	if err := flags.Parse([]string{"--binary-jailer=/flag/jailer", "--run-cache=/flag/run-cache"}); err != nil {
+		t.Fatalf("Expected flags to be parsed, got error: %v", err)
+	}
+
+	if err := ApplyGlobalConfig(flags, configFile, true); err != nil {
+		t.Fatalf("Expected global config to be applied, got error: %v", err)
+	}
+
+	profile := &profileModel.Profile{
+		BinaryJailer: "/profile/jailer",
+		ChrootBase:   "/profile/jailer",
+		RunCache:     "/profile/run-cache",
+	}
+	for _, cfg := range []ProfileInheriting{jailerConfig, runCacheConfig, tracingConfig} {
+		if err := cfg.UpdateFromProfile(profile); err != nil {
+			t.Fatalf("Expected profile to be applied, got error: %v", err)
+		}
+	}
+
+	// config file only:
+	if jailerConfig.BinaryFirecracker != "/config/firecracker" {
+		t.Fatalf("Expected binary firecracker from the config file, got: '%s'", jailerConfig.BinaryFirecracker)
+	}
+	if !tracingConfig.Enable {
+		t.Fatalf("Expected tracing enabled from the config file")
+	}
+	// profile over config file:
+	if jailerConfig.ChrootBase != "/profile/jailer" {
+		t.Fatalf("Expected chroot base from the profile, got: '%s'", jailerConfig.ChrootBase)
+	}
+	// flag over profile and config file:
+	if jailerConfig.BinaryJailer != "/flag/jailer" {
+		t.Fatalf("Expected binary jailer from the flag, got: '%s'", jailerConfig.BinaryJailer)
+	}
+	if runCacheConfig.RunCache != "/flag/run-cache" {
+		t.Fatalf("Expected run cache from the flag, got: '%s'", runCacheConfig.RunCache)
+	}
+	// default when not configured:
+	if jailerConfig.NetNS != "/var/lib/netns" {
+		t.Fatalf("Expected default netns, got: '%s'", jailerConfig.NetNS)
+	}
+}
+
+func TestMachineConfigFromProfile(t *testing.T) {
+
+	machineConfig := NewMachineConfig()
+	flags := &pflag.FlagSet{}
+	flags.AddFlagSet(machineConfig.FlagSet())
+	if err := flags.Parse([]string{"--cpu=4"}); err != nil {
+		t.Fatalf("Expected flags to be parsed, got error: %v", err)
+	}
+
+	profile := &profileModel.Profile{
+		MachineCPU:        2,
+		MachineKernelArgs: "console=ttyS0 reboot=k panic=1",
+		MachineMem:        512,
+	}
+	if err := machineConfig.UpdateFromProfile(profile); err != nil {
+		t.Fatalf("Expected profile to be applied, got error: %v", err)
+	}
+
+	// profile over default:
+	if machineConfig.Mem != 512 {
+		t.Fatalf("Expected memory from the profile, got: %d", machineConfig.Mem)
+	}
+	if machineConfig.KernelArgs != "console=ttyS0 reboot=k panic=1" {
+		t.Fatalf("Expected kernel args from the profile, got: '%s'", machineConfig.KernelArgs)
+	}
+	// flag over profile:
+	if machineConfig.CPU != 4 {
+		t.Fatalf("Expected CPU from the flag, got: %d", machineConfig.CPU)
+	}
+	// default when not in the profile:
+	if machineConfig.CPUTemplate != "" {
+		t.Fatalf("Expected default CPU template, got: '%s'", machineConfig.CPUTemplate)
+	}
+
+	// as written by profile-create:
+	stored := &profileModel.Profile{}
+	if err := json.Unmarshal([]byte(`{"run-cache":"/var/lib/firebuild","mem":1024}`), stored); err != nil {
+		t.Fatalf("Expected profile to be unmarshaled, got error: %v", err)
+	}
+	if err := machineConfig.UpdateFromProfile(stored); err != nil {
+		t.Fatalf("Expected profile to be applied, got error: %v", err)
+	}
+	if machineConfig.Mem != 1024 {
+		t.Fatalf("Expected memory from the stored profile, got: %d", machineConfig.Mem)
+	}
+}
+
+func TestGlobalConfigSliceAndMissingFile(t *testing.T) {
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Expected temp directory to be created, got error: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	configFile := filepath.Join(tempDir, "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte("values:\n  - a\n  - b\n"), 0644); err != nil {
+		t.Fatalf("Expected config file to be written, got error: %v", err)
+	}
+
+	values := []string{}
+	flags := &pflag.FlagSet{}
+	flags.StringArrayVar(&values, "values", []string{"default"}, "")
+	if err := ApplyGlobalConfig(flags, configFile, true); err != nil {
+		t.Fatalf("Expected global config to be applied, got error: %v", err)
+	}
+	if len(values) != 2 || values[0] != "a" || values[1] != "b" {
+		t.Fatalf("Expected values to be replaced by the config file, got: %v", values)
+	}
+
+	missing := filepath.Join(tempDir, "missing.yaml")
+	if err := ApplyGlobalConfig(flags, missing, false); err != nil {
+		t.Fatalf("Expected missing default config file to be ignored, got error: %v", err)
+	}
+	if err := ApplyGlobalConfig(flags, missing, true); err == nil {
+		t.Fatalf("Expected missing explicit config file to fail")
+	}
+}
@@ -22,6 +22,17 @@ func (fb *flagBase) initFlagSet() bool {
 	return false
 }
 
+// isFlagChanged returns true if the flag with a given name was explicitly set on the command line.
+func (fb *flagBase) isFlagChanged(name string) bool {
+	if fb.flagSet == nil {
+		return false
+	}
+	if f := fb.flagSet.Lookup(name); f != nil {
+		return f.Changed
+	}
+	return false
+}
+
 // ValidatingConfig is a config which can be validated.
 type ValidatingConfig interface {
 	Validate() error
@@ -2,12 +2,14 @@ package configs
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 
 	profileModel "github.com/combust-labs/firebuild/pkg/profiles/model"
 	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 )
 
@@ -69,7 +71,7 @@ func (c *JailingFirecrackerConfig) FlagSet() *pflag.FlagSet {
 	if c.initFlagSet() {
 		c.flagSet.StringVar(&c.BinaryFirecracker, "binary-firecracker", "", "Path to the Firecracker binary to use")
 		c.flagSet.StringVar(&c.BinaryJailer, "binary-jailer", "", "Path to the Firecracker Jailer binary to use")
-		c.flagSet.StringVar(&c.ChrootBase, "chroot-base", "/srv/jailer", "chroot base directory; can't be empty or /")
+		c.flagSet.StringVar(&c.ChrootBase, "chroot-base", "/srv/jailer", "chroot base directory; can't be empty or /; when set explicitly, takes precedence over the profile setting")
 		c.flagSet.IntVar(&c.JailerGID, "jailer-gid", 0, "Jailer GID value")
 		c.flagSet.IntVar(&c.JailerNumeNode, "jailer-numa-node", 0, "Jailer NUMA node")
 		c.flagSet.IntVar(&c.JailerUID, "jailer-uid", 0, "Jailer UID value")
@@ -79,14 +81,17 @@ func (c *JailingFirecrackerConfig) FlagSet() *pflag.FlagSet {
 }
 
 // UpdateFromProfile updates the configuration from a profile.
+// The flags explicitly set on the command line take precedence over the profile.
 func (c *JailingFirecrackerConfig) UpdateFromProfile(input *profileModel.Profile) error {
-	if input.BinaryFirecracker != "" {
+	if input.BinaryFirecracker != "" && !c.isFlagChanged("binary-firecracker") {
 		c.BinaryFirecracker = input.BinaryFirecracker
 	}
-	if input.BinaryJailer != "" {
+	if input.BinaryJailer != "" && !c.isFlagChanged("binary-jailer") {
 		c.BinaryJailer = input.BinaryJailer
 	}
-	if input.ChrootBase != "" {
+	// explicit --chroot-base allows a single run to target a different chroot
+	// without having to create a dedicated profile:
+	if input.ChrootBase != "" && !c.isFlagChanged("chroot-base") {
 		c.ChrootBase = input.ChrootBase
 	}
 	return nil
@@ -103,6 +108,35 @@ func (c *JailingFirecrackerConfig) Validate() error {
 	return nil
 }
 
+// ValidateChrootBaseCapacity validates that the chroot base can be written to
+// and that the file system it resides on has at least the required number of bytes free.
+// If the chroot base does not exist yet, the closest existing parent directory is checked
+// because the jailer creates the chroot base when the VMM starts.
+func (c *JailingFirecrackerConfig) ValidateChrootBaseCapacity(required int64) error {
+	location := utils.ClosestExistingDirectory(c.ChrootBase)
+	if err := utils.CheckIfDirectoryWritable(location); err != nil {
+		return errors.Wrapf(err, "--chroot-base '%s' is not writable", c.ChrootBase)
+	}
+	available, err := utils.DirectoryFreeSpace(location)
+	if err != nil {
+		return errors.Wrapf(err, "failed checking free space for --chroot-base '%s'", c.ChrootBase)
+	}
+	if uint64(required) > available {
+		return fmt.Errorf("--chroot-base '%s' has %d bytes available but %d bytes are required", c.ChrootBase, available, required)
+	}
+	return nil
+}
+
+// ValidateChrootBaseForRootfs validates that the chroot base can hold a copy of the rootfs file,
+// the rootfs copy ends up in the jail.
+func (c *JailingFirecrackerConfig) ValidateChrootBaseForRootfs(rootfsPath string) error {
+	rootfsStat, err := os.Stat(rootfsPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed checking rootfs '%s' size", rootfsPath)
+	}
+	return c.ValidateChrootBaseCapacity(rootfsStat.Size())
+}
+
 // WithVMMID allows overriding the VMM ID.
 func (c *JailingFirecrackerConfig) WithVMMID(input string) *JailingFirecrackerConfig {
 	c.vmmID = input
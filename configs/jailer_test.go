@@ -0,0 +1,31 @@
+package configs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateChrootBaseForRootfs(t *testing.T) {
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Expected temp directory to be created, got error: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rootfsPath := filepath.Join(tempDir, "rootfs")
+	if err := ioutil.WriteFile(rootfsPath, []byte("rootfs"), 0644); err != nil {
+		t.Fatalf("Expected rootfs file to be written, got error: %v", err)
+	}
+
+	// the chroot base does not exist yet, the closest existing parent is checked:
+	config := &JailingFirecrackerConfig{ChrootBase: filepath.Join(tempDir, "jailer")}
+	if err := config.ValidateChrootBaseForRootfs(rootfsPath); err != nil {
+		t.Fatalf("Expected the chroot base to hold the rootfs, got error: %v", err)
+	}
+	if err := config.ValidateChrootBaseForRootfs(filepath.Join(tempDir, "missing")); err == nil {
+		t.Fatal("Expected a missing rootfs to fail the validation")
+	}
+}
@@ -3,15 +3,43 @@ package configs
 import (
 	"fmt"
 	"net"
+	"path/filepath"
+	"strings"
 
+	profileModel "github.com/combust-labs/firebuild/pkg/profiles/model"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm/passthrough"
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 )
 
+const (
+	// MMDSVersionV1 is the MMDS version serving the metadata without a session token.
+	MMDSVersionV1 = "V1"
+	// MMDSVersionV2 is the MMDS version serving the metadata only to the requests with a session token.
+	MMDSVersionV2 = "V2"
+)
+
+const (
+	// DefaultVsockPath is the default path of the vsock unix socket in the jail.
+	DefaultVsockPath = "/vsock.sock"
+	// VsockMinCID is the minimum guest CID, the CIDs 0 to 2 are reserved.
+	VsockMinCID = 3
+	// VsockPathMaxLength is the maximum length of the vsock unix socket path in the jail,
+	// the length of the Firecracker API socket path the chroot base length is limited for.
+	VsockPathMaxLength = len("/run/firecracker.socket")
+)
+
 // MachineConfig provides machine configuration options.
 type MachineConfig struct {
 	flagBase
 	ValidatingConfig `json:"-"`
 
+	Balloon                            bool  `json:"Balloon" mapstructure:"Balloon"`
+	BalloonDeflateOnOOM                bool  `json:"BalloonDeflateOnOOM" mapstructure:"BalloonDeflateOnOOM"`
+	BalloonStatsPollingIntervalSeconds int64 `json:"BalloonStatsPollingIntervalSeconds" mapstructure:"BalloonStatsPollingIntervalSeconds"`
+	BalloonTargetMib                   int64 `json:"BalloonTargetMib" mapstructure:"BalloonTargetMib"`
+
 	CNINetworkName    string `json:"CniNetworkName" mapstructure:"CniNetworkName"`
 	CPU               int64  `json:"CPU" mapstructure:"CPU"`
 	CPUTemplate       string `json:"CPUTemplate" mapstructure:"CPUTemplate"`
@@ -19,11 +47,22 @@ type MachineConfig struct {
 	IPAddress         string `json:"IPAddress" mapstructure:"IPAddress"`
 	KernelArgs        string `json:"KernelArgs" mapstructure:"KernelArgs"`
 	Mem               int64  `json:"Mem" mapstructure:"Mem"`
+	MMDSVersion       string `json:"MMDSVersion" mapstructure:"MMDSVersion"`
 	NoMMDS            bool   `json:"NoMMDS" mapstructure:"NoMMDS"` // TODO: remove
 	RootDrivePartUUID string `json:"RootDrivePartuuid" mapstructure:"RootDrivePartuuid"`
 	SSHUser           string `json:"SSHUser" mapstructure:"SSHUser"`
 	VMLinuxID         string `json:"VMLinux" mapstructure:"VMLinux"`
 
+	DriveBandwidth string `json:"DriveBandwidth,omitempty" mapstructure:"DriveBandwidth"`
+	DriveOps       string `json:"DriveOps,omitempty" mapstructure:"DriveOps"`
+	NetRxBandwidth string `json:"NetRxBandwidth,omitempty" mapstructure:"NetRxBandwidth"`
+	NetTxBandwidth string `json:"NetTxBandwidth,omitempty" mapstructure:"NetTxBandwidth"`
+
+	PassthroughDevices []string `json:"PassthroughDevices,omitempty" mapstructure:"PassthroughDevices"`
+
+	VsockCID  uint32 `json:"VsockCID,omitempty" mapstructure:"VsockCID"`
+	VsockPath string `json:"VsockPath,omitempty" mapstructure:"VsockPath"`
+
 	LogFcHTTPCalls                 bool `json:"LogFirecrackerHTTPCalls" mapstructure:"LogFirecrackerHTTPCalls"`
 	ShutdownGracefulTimeoutSeconds int  `json:"ShutdownGracefulTimeoutSeconds" mapstructure:"ShutdownGracefulTimeoutSeconds"`
 
@@ -43,6 +82,10 @@ func NewMachineConfig() *MachineConfig {
 // FlagSet returns an instance of the flag set for the configuration.
 func (c *MachineConfig) FlagSet() *pflag.FlagSet {
 	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.Balloon, "balloon", false, "When set, attaches a memory balloon device to the VMM")
+		c.flagSet.BoolVar(&c.BalloonDeflateOnOOM, "balloon-deflate-on-oom", false, "When set, the balloon deflates when the guest runs out of memory")
+		c.flagSet.Int64Var(&c.BalloonStatsPollingIntervalSeconds, "balloon-stats-polling-interval-seconds", 0, "Balloon statistics polling interval in seconds; 0 disables the statistics")
+		c.flagSet.Int64Var(&c.BalloonTargetMib, "balloon-target-mib", 0, "Initial balloon target size in MiB")
 		c.flagSet.StringVar(&c.CNINetworkName, "cni-network-name", "", "CNI network within which the build should run; it's recommended to use a dedicated network for build process")
 		c.flagSet.Int64Var(&c.CPU, "cpu", 1, "Number of CPUs for the build VMM")
 		c.flagSet.StringVar(&c.CPUTemplate, "cpu-template", "", "CPU template (empty, C2 or T3)")
@@ -50,10 +93,18 @@ func (c *MachineConfig) FlagSet() *pflag.FlagSet {
 		c.flagSet.StringVar(&c.IPAddress, "ip-address", "", "IP address to try to allocate to the VM; if not given, a new IP will be allocated")
 		c.flagSet.StringVar(&c.KernelArgs, "kernel-args", "console=ttyS0 noapic reboot=k panic=1 pci=off nomodules rw", "Kernel arguments")
 		c.flagSet.Int64Var(&c.Mem, "mem", 128, "Amount of memory for the VMM")
+		c.flagSet.StringVar(&c.MMDSVersion, "mmds-version", "v2", "MMDS version: v1 or v2; v2 requires the guest to request a session token and Firecracker v1.0.0 or newer")
 		c.flagSet.BoolVar(&c.NoMMDS, "no-mmds", false, "If set, disables MMDS")
 		c.flagSet.StringVar(&c.RootDrivePartUUID, "root-drive-partuuid", "", "Root drive part UUID")
 		c.flagSet.StringVar(&c.SSHUser, "ssh-user", "", "SSH user")
 		c.flagSet.StringVar(&c.VMLinuxID, "vmlinux-id", "", "Kernel ID / name")
+		c.flagSet.StringVar(&c.DriveBandwidth, "drive-bw", "", "Bandwidth limit of the VMM block devices, for example: 50MiB/s; empty or 0 for no limit")
+		c.flagSet.StringVar(&c.DriveOps, "drive-ops", "", "Operations per second limit of the VMM block devices, for example: 1000/s; empty or 0 for no limit")
+		c.flagSet.StringVar(&c.NetRxBandwidth, "net-rx-bw", "", "Bandwidth limit of the traffic received by the VMM network interface, for example: 10MiB/s; empty or 0 for no limit")
+		c.flagSet.StringVar(&c.NetTxBandwidth, "net-tx-bw", "", "Bandwidth limit of the traffic sent by the VMM network interface, for example: 10MiB/s; empty or 0 for no limit")
+		c.flagSet.StringArrayVar(&c.PassthroughDevices, "passthrough-device", []string{}, "VFIO group device to pass through to the VMM, format: /dev/vfio/<group>, multiple OK; the device is validated but Firecracker does not support device passthrough")
+		c.flagSet.Uint32Var(&c.VsockCID, "vsock-cid", 0, "Guest CID of the VMM vsock device, 3 or higher; 0 for no vsock device")
+		c.flagSet.StringVar(&c.VsockPath, "vsock-path", DefaultVsockPath, fmt.Sprintf("Path of the vsock unix socket in the jail, maximum %d characters", VsockPathMaxLength))
 
 		c.flagSet.BoolVar(&c.LogFcHTTPCalls, "log-firecracker-http-calls", false, "If set, logs Firecracker HTTP client calls in debug mode")
 		c.flagSet.IntVar(&c.ShutdownGracefulTimeoutSeconds, "shutdown-graceful-timeout-seconds", 30, "Graceful shutdown timeout before vmm is stopped forcefully")
@@ -66,16 +117,38 @@ func (c *MachineConfig) Daemonize() bool {
 	return c.daemonize
 }
 
+// HasVsock returns true if the VMM has a vsock device.
+func (c *MachineConfig) HasVsock() bool {
+	return c.VsockCID > 0
+}
+
 // KernelOverride returns the configured kernel setting.
 func (c *MachineConfig) KernelOverride() string {
 	return c.kernelOverride
 }
 
+// MMDSVersionOrDefault returns the configured MMDS version, V1 or V2.
+// The VMMs started before the version was configurable use V1.
+func (c *MachineConfig) MMDSVersionOrDefault() string {
+	if c.MMDSVersion == "" {
+		return MMDSVersionV1
+	}
+	return strings.ToUpper(c.MMDSVersion)
+}
+
 // RootfsOverride returns the configured rootfs setting.
 func (c *MachineConfig) RootfsOverride() string {
 	return c.rootfsOverride
 }
 
+// VsockPathOrDefault returns the configured path of the vsock unix socket in the jail.
+func (c *MachineConfig) VsockPathOrDefault() string {
+	if c.VsockPath == "" {
+		return DefaultVsockPath
+	}
+	return c.VsockPath
+}
+
 // WithDaemonize sets the daemonize setting.
 func (c *MachineConfig) WithDaemonize(input bool) *MachineConfig {
 	c.daemonize = input
@@ -94,8 +167,66 @@ func (c *MachineConfig) WithRootfsOverride(input string) *MachineConfig {
 	return c
 }
 
+// UpdateFromProfile updates the configuration from a profile.
+// The flags explicitly set on the command line take precedence over the profile.
+func (c *MachineConfig) UpdateFromProfile(input *profileModel.Profile) error {
+	if input.MachineCPU > 0 && !c.isFlagChanged("cpu") {
+		c.CPU = input.MachineCPU
+	}
+	if input.MachineCPUTemplate != "" && !c.isFlagChanged("cpu-template") {
+		c.CPUTemplate = input.MachineCPUTemplate
+	}
+	if input.MachineKernelArgs != "" && !c.isFlagChanged("kernel-args") {
+		c.KernelArgs = input.MachineKernelArgs
+	}
+	if input.MachineMem > 0 && !c.isFlagChanged("mem") {
+		c.Mem = input.MachineMem
+	}
+	return nil
+}
+
 // Validate validates the correctness of the configuration.
 func (c *MachineConfig) Validate() error {
+	if c.BalloonTargetMib < 0 || (c.BalloonTargetMib > 0 && c.BalloonTargetMib >= c.Mem) {
+		return fmt.Errorf("value of --balloon-target-mib must be between 0 and --mem")
+	}
+	if c.BalloonStatsPollingIntervalSeconds < 0 {
+		return fmt.Errorf("value of --balloon-stats-polling-interval-seconds can't be negative")
+	}
+	if version := c.MMDSVersionOrDefault(); version != MMDSVersionV1 && version != MMDSVersionV2 {
+		return fmt.Errorf("value of --mmds-version must be v1 or v2")
+	}
+	for _, item := range []struct{ flag, value string }{
+		{"--drive-bw", c.DriveBandwidth},
+		{"--net-rx-bw", c.NetRxBandwidth},
+		{"--net-tx-bw", c.NetTxBandwidth},
+	} {
+		if item.value == "" {
+			continue
+		}
+		if _, err := utils.ParseBandwidth(item.value); err != nil {
+			return errors.Wrapf(err, "value of %s is invalid", item.flag)
+		}
+	}
+	if c.DriveOps != "" {
+		if _, err := utils.ParseOpsRate(c.DriveOps); err != nil {
+			return errors.Wrap(err, "value of --drive-ops is invalid")
+		}
+	}
+	for _, device := range c.PassthroughDevices {
+		if _, err := passthrough.Resolve(device); err != nil {
+			return errors.Wrap(err, "--passthrough-device invalid")
+		}
+	}
+	if c.HasVsock() {
+		if c.VsockCID < VsockMinCID {
+			return fmt.Errorf("value of --vsock-cid must be %d or higher", VsockMinCID)
+		}
+		vsockPath := c.VsockPathOrDefault()
+		if !filepath.IsAbs(vsockPath) || len(vsockPath) > VsockPathMaxLength {
+			return fmt.Errorf("value of --vsock-path must be an absolute path of maximum %d characters", VsockPathMaxLength)
+		}
+	}
 	if c.IPAddress != "" {
 		if parsedIP := net.ParseIP(c.IPAddress); parsedIP == nil {
 			return fmt.Errorf("value of --ip-address is not an IP address")
@@ -1,7 +1,10 @@
 package configs
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"strings"
 
 	profilesModel "github.com/combust-labs/firebuild/pkg/profiles/model"
 	"github.com/combust-labs/firebuild/pkg/storage/resolver"
@@ -64,6 +67,11 @@ func (c *ProfileCreateConfig) FlagSet() *pflag.FlagSet {
 		c.flagSet.StringVar(&c.BinaryFirecracker, "binary-firecracker", "", "Path to the Firecracker binary to use")
 		c.flagSet.StringVar(&c.BinaryJailer, "binary-jailer", "", "Path to the Firecracker Jailer binary to use")
 		c.flagSet.StringVar(&c.ChrootBase, "chroot-base", "", "chroot base directory; can't be empty or /")
+		c.flagSet.StringVar(&c.Inherits, "inherits", "", "Name of an existing profile to extend, the values set for this profile override the inherited values")
+		c.flagSet.Int64Var(&c.MachineCPU, "cpu", 0, "Number of CPUs of the VMMs started and the build VMMs; 0 keeps the command default")
+		c.flagSet.StringVar(&c.MachineCPUTemplate, "cpu-template", "", "CPU template of the VMMs (empty, C2 or T3)")
+		c.flagSet.StringVar(&c.MachineKernelArgs, "kernel-args", "", "Kernel arguments of the VMMs; empty keeps the command default")
+		c.flagSet.Int64Var(&c.MachineMem, "mem", 0, "Amount of memory of the VMMs; 0 keeps the command default")
 		c.flagSet.StringVar(&c.RunCache, "run-cache", "", "Firebuild run cache directory")
 		c.flagSet.StringVar(&c.StorageProvider, "storage-provider", "", "Storage provider to use for the profile")
 		c.flagSet.StringToStringVar(&c.StorageProviderConfigStrings, "storage-provider-property-string", map[string]string{}, "Storage provider configuration string property, multiple OK")
@@ -79,27 +87,50 @@ func (c *ProfileCreateConfig) FlagSet() *pflag.FlagSet {
 // Validate validates the correctness of the configuration.
 func (c *ProfileCreateConfig) Validate() error {
 
+	// the values may reference environment variables expanded when the profile is read,
+	// validate them as expanded for the current environment:
+	expanded := map[string]string{}
+	for flag, value := range map[string]string{
+		"--binary-firecracker": c.BinaryFirecracker,
+		"--binary-jailer":      c.BinaryJailer,
+		"--chroot-base":        c.ChrootBase,
+		"--run-cache":          c.RunCache,
+	} {
+		expandedValue, err := utils.ExpandEnv(value)
+		if err != nil {
+			return errors.Wrapf(err, "%s is invalid", flag)
+		}
+		expanded[flag] = expandedValue
+	}
+
 	// these must point to an existing location:
 	if c.BinaryFirecracker != "" {
-		if _, err := utils.CheckIfExistsAndIsRegular(c.BinaryFirecracker); err != nil {
+		if _, err := utils.CheckIfExistsAndIsRegular(expanded["--binary-firecracker"]); err != nil {
 			return errors.Wrap(err, "--binary-firecracker points to a non-existing location or not a regular file")
 		}
 	}
 	if c.BinaryJailer != "" {
-		if _, err := utils.CheckIfExistsAndIsRegular(c.BinaryJailer); err != nil {
+		if _, err := utils.CheckIfExistsAndIsRegular(expanded["--binary-jailer"]); err != nil {
 			return errors.Wrap(err, "--binary-jailer points to a non-existing location or not a regular file")
 		}
 	}
 	if c.ChrootBase != "" {
-		if len(c.ChrootBase) > ChrootBaseMaxLength {
+		if len(expanded["--chroot-base"]) > ChrootBaseMaxLength {
 			return fmt.Errorf("--chroot-base must cannot be longer than %d characters", ChrootBaseMaxLength)
 		}
-		if _, err := utils.CheckIfExistsAndIsDirectory(c.ChrootBase); err != nil {
+		if _, err := utils.CheckIfExistsAndIsDirectory(expanded["--chroot-base"]); err != nil {
 			return errors.Wrap(err, "--chroot-base points to a non-existing location or not a directory")
 		}
 	}
-	if c.RunCache == "" {
-		if _, err := utils.CheckIfExistsAndIsDirectory(c.RunCache); err != nil {
+	if c.MachineCPU < 0 {
+		return fmt.Errorf("--cpu can't be negative")
+	}
+	if c.MachineMem < 0 {
+		return fmt.Errorf("--mem can't be negative")
+	}
+	// an inheriting profile may leave the run cache to the inherited profile:
+	if c.RunCache != "" {
+		if _, err := utils.CheckIfExistsAndIsDirectory(expanded["--run-cache"]); err != nil {
 			return errors.Wrap(err, "--run-cache points to a non-existing location or not a directory")
 		}
 	}
@@ -112,3 +143,79 @@ func (c *ProfileCreateConfig) Validate() error {
 
 	return nil
 }
+
+// MarshalJSON serializes the profile. The values of the flags set explicitly are serialized
+// even if empty, so an inheriting profile can override an inherited value with false or empty.
+func (c *ProfileCreateConfig) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(&c.Profile)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	profileValue := reflect.ValueOf(c.Profile)
+	for idx := 0; idx < profileValue.NumField(); idx++ {
+		key := strings.Split(profileValue.Type().Field(idx).Tag.Get("json"), ",")[0]
+		if _, ok := values[key]; !ok && c.isFlagChanged(key) {
+			values[key] = profileValue.Field(idx).Interface()
+		}
+	}
+	return json.Marshal(values)
+}
+
+// ProfileRmCommandConfig represents the profile remove command configuration.
+type ProfileRmCommandConfig struct {
+	flagBase
+	ValidatingConfig `json:"-"`
+
+	Yes bool
+}
+
+// NewProfileRmCommandConfig returns an initialized configuration instance.
+func NewProfileRmCommandConfig() *ProfileRmCommandConfig {
+	return &ProfileRmCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *ProfileRmCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.BoolVar(&c.Yes, "yes", false, "Remove the profile without asking for confirmation")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *ProfileRmCommandConfig) Validate() error {
+	return nil
+}
+
+// ProfileShowCommandConfig represents the profile show command configuration.
+type ProfileShowCommandConfig struct {
+	flagBase
+	ValidatingConfig `json:"-"`
+
+	Output string
+}
+
+// NewProfileShowCommandConfig returns an initialized configuration instance.
+func NewProfileShowCommandConfig() *ProfileShowCommandConfig {
+	return &ProfileShowCommandConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *ProfileShowCommandConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		c.flagSet.StringVar(&c.Output, "output", "json", "Output format: json or yaml")
+	}
+	return c.flagSet
+}
+
+// Validate validates the correctness of the configuration.
+func (c *ProfileShowCommandConfig) Validate() error {
+	if c.Output != "json" && c.Output != "yaml" {
+		return fmt.Errorf("--output must be json or yaml")
+	}
+	return nil
+}
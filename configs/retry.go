@@ -0,0 +1,87 @@
+package configs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/spf13/pflag"
+)
+
+// RetryConfig contains the retry settings of the network operations: the Docker image pulls,
+// the storage requests, the ADD URL downloads and the SSH connections and transfers.
+type RetryConfig struct {
+	flagBase
+	ValidatingConfig
+
+	Attempts       int
+	InitialBackoff time.Duration
+	Jitter         float64
+	MaxBackoff     time.Duration
+
+	// deprecated Docker image pull retry flags, -1 and 0 when not given:
+	pullRetries      int
+	pullRetryBackoff time.Duration
+}
+
+// NewRetryConfig returns new retry configuration.
+func NewRetryConfig() *RetryConfig {
+	return &RetryConfig{}
+}
+
+// FlagSet returns an instance of the flag set for the configuration.
+func (c *RetryConfig) FlagSet() *pflag.FlagSet {
+	if c.initFlagSet() {
+		defaults := utils.DefaultRetryConfig()
+		c.flagSet.IntVar(&c.Attempts, "retry-attempts", defaults.Attempts, "Maximum number of attempts of a network operation failing on a transient error, including the first attempt; 1 disables retries")
+		c.flagSet.DurationVar(&c.InitialBackoff, "retry-initial-backoff", defaults.InitialBackoff, "Delay before the first retry, doubled for every next retry")
+		c.flagSet.Float64Var(&c.Jitter, "retry-jitter", defaults.Jitter, "Fraction of the retry delay, between 0 and 1, by which the delay is randomly shortened")
+		c.flagSet.DurationVar(&c.MaxBackoff, "retry-max-backoff", defaults.MaxBackoff, "Upper bound of the delay between the retries, 0 for no bound")
+		c.flagSet.IntVar(&c.pullRetries, "pull-retries", -1, "Number of Docker image pull retries after a transient failure")
+		c.flagSet.MarkDeprecated("pull-retries", "use --retry-attempts, the number of attempts including the first one")
+		c.flagSet.DurationVar(&c.pullRetryBackoff, "pull-retry-backoff", 0, "Delay before the first Docker image pull retry")
+		c.flagSet.MarkDeprecated("pull-retry-backoff", "use --retry-initial-backoff")
+	}
+	return c.flagSet
+}
+
+// Policy returns the retry configuration of the network operations.
+// The deprecated Docker image pull retry flags take precedence when given.
+func (c *RetryConfig) Policy() utils.RetryConfig {
+	policy := utils.RetryConfig{
+		Attempts:       c.Attempts,
+		InitialBackoff: c.InitialBackoff,
+		Jitter:         c.Jitter,
+		MaxBackoff:     c.MaxBackoff,
+	}
+	if c.pullRetries > -1 {
+		policy.Attempts = c.pullRetries + 1
+	}
+	if c.pullRetryBackoff > 0 {
+		policy.InitialBackoff = c.pullRetryBackoff
+	}
+	return policy
+}
+
+// Validate validates the correctness of the configuration.
+func (c *RetryConfig) Validate() error {
+	if c.Attempts < 1 {
+		return fmt.Errorf("--retry-attempts must be at least 1")
+	}
+	if c.InitialBackoff < 0 {
+		return fmt.Errorf("--retry-initial-backoff can't be negative")
+	}
+	if c.MaxBackoff < 0 {
+		return fmt.Errorf("--retry-max-backoff can't be negative")
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return fmt.Errorf("--retry-jitter must be between 0 and 1")
+	}
+	if c.pullRetries < -1 {
+		return fmt.Errorf("--pull-retries can't be negative")
+	}
+	if c.pullRetryBackoff < 0 {
+		return fmt.Errorf("--pull-retry-backoff can't be negative")
+	}
+	return nil
+}
@@ -31,8 +31,9 @@ func (c *RunCacheConfig) FlagSet() *pflag.FlagSet {
 }
 
 // UpdateFromProfile updates the configuration from a profile.
+// The flags explicitly set on the command line take precedence over the profile.
 func (c *RunCacheConfig) UpdateFromProfile(input *profileModel.Profile) error {
-	if input.RunCache != "" {
+	if input.RunCache != "" && !c.isFlagChanged("run-cache") {
 		c.RunCache = input.RunCache
 	}
 	return nil
@@ -51,6 +52,11 @@ func (c *RunCacheConfig) LocationBuilds() string {
 	return filepath.Join(c.RunCache, "builds")
 }
 
+// LocationStageCache returns a full path to the dependency stage image cache index.
+func (c *RunCacheConfig) LocationStageCache() string {
+	return filepath.Join(c.RunCache, "stage-cache")
+}
+
 // LocationRuns returns a full path to the runs run cache.
 func (c *RunCacheConfig) LocationRuns() string {
 	return filepath.Join(c.RunCache, "runs")
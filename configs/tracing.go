@@ -14,6 +14,7 @@ type TracingConfig struct {
 	Enable          bool
 	HostPort        string
 	LogEnable       bool
+	SpansFile       string
 }
 
 // NewTracingConfig returns a new instance of the configuration.
@@ -24,14 +25,15 @@ func NewTracingConfig(appName string) *TracingConfig {
 }
 
 // UpdateFromProfile updates the configuration from a profile.
+// The flags explicitly set on the command line take precedence over the profile.
 func (c *TracingConfig) UpdateFromProfile(input *profileModel.Profile) error {
-	if input.TracingEnable {
+	if input.TracingEnable && !c.isFlagChanged("tracing-enable") {
 		c.Enable = input.TracingEnable
 	}
-	if input.TracingLogEnable {
+	if input.TracingLogEnable && !c.isFlagChanged("tracing-log-enable") {
 		c.LogEnable = input.TracingLogEnable
 	}
-	if input.TracingCollectorHostPort != "" {
+	if input.TracingCollectorHostPort != "" && !c.isFlagChanged("tracing-collector-host-port") {
 		c.HostPort = input.TracingCollectorHostPort
 	}
 	return nil
@@ -43,6 +45,7 @@ func (c *TracingConfig) FlagSet() *pflag.FlagSet {
 		c.flagSet.BoolVar(&c.Enable, "tracing-enable", false, "If set, enables tracing")
 		c.flagSet.StringVar(&c.HostPort, "tracing-collector-host-port", "127.0.0.1:6831", "Host port of the collector")
 		c.flagSet.BoolVar(&c.LogEnable, "tracing-log-enable", false, "If set, enables tracer logging")
+		c.flagSet.StringVar(&c.SpansFile, "tracing-spans-file", "", "If set, the operation name, duration and tags of every finished span are appended to the file as JSON lines, also when tracing is not enabled")
 	}
 	return c.flagSet
 }
@@ -8,21 +8,29 @@ require (
 	github.com/combust-labs/firebuild-shared v0.0.8
 	github.com/containernetworking/cni v0.8.0
 	github.com/coreos/go-iptables v0.5.0
+	github.com/docker/distribution v2.7.1+incompatible
 	github.com/docker/docker v20.10.4+incompatible
 	github.com/firecracker-microvm/firecracker-go-sdk v0.22.0
 	github.com/go-git/go-git/v5 v5.2.0
 	github.com/hashicorp/go-hclog v0.15.0
+	github.com/hashicorp/go-multierror v1.0.0
+	github.com/klauspost/compress v1.11.13
 	github.com/mitchellh/mapstructure v1.4.1
 	github.com/moby/buildkit v0.8.1
+	github.com/opencontainers/image-spec v1.0.1
 	github.com/opentracing/opentracing-go v1.2.0
 	github.com/pkg/errors v0.9.1
+	github.com/pkg/sftp v1.11.0
 	github.com/sirupsen/logrus v1.7.0
+	github.com/spf13/cast v1.3.1
 	github.com/spf13/cobra v1.1.3
 	github.com/spf13/pflag v1.0.5
+	github.com/spf13/viper v1.7.1
 	github.com/stretchr/testify v1.6.1
 	github.com/subosito/gotenv v1.2.0
 	github.com/uber/jaeger-client-go v2.25.0+incompatible
 	golang.org/x/crypto v0.0.0-20201117144127-c1f2f97bffc9
 	golang.org/x/net v0.0.0-20210324205630-d1beb07c2056 // indirect
 	google.golang.org/genproto v0.0.0-20210325141258-5636347f2b14 // indirect
+	sigs.k8s.io/yaml v1.2.0
 )
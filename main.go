@@ -4,23 +4,56 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/combust-labs/firebuild/cmd/balloon"
 	"github.com/combust-labs/firebuild/cmd/baseos"
+	"github.com/combust-labs/firebuild/cmd/bench"
+	"github.com/combust-labs/firebuild/cmd/completion"
+	"github.com/combust-labs/firebuild/cmd/cp"
+	"github.com/combust-labs/firebuild/cmd/doctor"
+	"github.com/combust-labs/firebuild/cmd/exec"
+	"github.com/combust-labs/firebuild/cmd/get"
 	"github.com/combust-labs/firebuild/cmd/inspect"
 	"github.com/combust-labs/firebuild/cmd/kill"
+	"github.com/combust-labs/firebuild/cmd/label"
 	"github.com/combust-labs/firebuild/cmd/ls"
+	mountsCleanup "github.com/combust-labs/firebuild/cmd/mounts/cleanup"
+	"github.com/combust-labs/firebuild/cmd/parse"
+	"github.com/combust-labs/firebuild/cmd/pause"
 
 	profileCreate "github.com/combust-labs/firebuild/cmd/profiles/create"
 	profileInspect "github.com/combust-labs/firebuild/cmd/profiles/inspect"
 	profileLs "github.com/combust-labs/firebuild/cmd/profiles/ls"
+	profileRm "github.com/combust-labs/firebuild/cmd/profiles/rm"
+	profileShow "github.com/combust-labs/firebuild/cmd/profiles/show"
 
+	"github.com/combust-labs/firebuild/cmd/publish"
 	"github.com/combust-labs/firebuild/cmd/purge"
+	"github.com/combust-labs/firebuild/cmd/restore"
+	"github.com/combust-labs/firebuild/cmd/resume"
+	"github.com/combust-labs/firebuild/cmd/rm"
 	"github.com/combust-labs/firebuild/cmd/rootfs"
 	"github.com/combust-labs/firebuild/cmd/run"
+	"github.com/combust-labs/firebuild/cmd/selftest"
+	"github.com/combust-labs/firebuild/cmd/snapshot"
+	"github.com/combust-labs/firebuild/cmd/stats"
+	storageDedup "github.com/combust-labs/firebuild/cmd/storage/dedup"
+	"github.com/combust-labs/firebuild/cmd/tag"
+	"github.com/combust-labs/firebuild/cmd/verify"
+	versionCmd "github.com/combust-labs/firebuild/cmd/version"
+	"github.com/combust-labs/firebuild/configs"
+	buildVersion "github.com/combust-labs/firebuild/pkg/version"
 	"github.com/spf13/cobra"
 
 	_ "github.com/combust-labs/firebuild/pkg/utils/randinit"
 )
 
+// Build information, set with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "firebuild",
 	Short: "firebuild",
@@ -29,21 +62,57 @@ var rootCmd = &cobra.Command{
 		cmd.Help()
 		os.Exit(1)
 	},
+	// the global configuration file provides the defaults for the flags of every command:
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return configs.ApplyGlobalConfig(cmd.Flags(), globalConfigFile, cmd.Flags().Changed(configs.GlobalConfigFlagName))
+	},
 }
 
+var globalConfigFile string
+
 func init() {
+	buildVersion.Set(version, commit, buildDate)
+	rootCmd.Version = buildVersion.Get().Version
+	rootCmd.SetVersionTemplate(buildVersion.Get().String() + "\n")
+	rootCmd.PersistentFlags().StringVar(&globalConfigFile, configs.GlobalConfigFlagName, configs.DefaultGlobalConfigFile(),
+		"Global configuration file with the flag defaults for all commands; command flags and profiles take precedence")
+
+	rootCmd.AddCommand(balloon.Command)
 	rootCmd.AddCommand(baseos.Command)
+	rootCmd.AddCommand(bench.Command)
+	rootCmd.AddCommand(completion.Command)
+	rootCmd.AddCommand(cp.Command)
+	rootCmd.AddCommand(doctor.Command)
+	rootCmd.AddCommand(exec.Command)
+	rootCmd.AddCommand(get.Command)
 	rootCmd.AddCommand(inspect.Command)
 	rootCmd.AddCommand(kill.Command)
+	rootCmd.AddCommand(label.Command)
 	rootCmd.AddCommand(ls.Command)
+	rootCmd.AddCommand(mountsCleanup.Command)
+	rootCmd.AddCommand(parse.Command)
+	rootCmd.AddCommand(pause.Command)
 
 	rootCmd.AddCommand(profileCreate.Command)
 	rootCmd.AddCommand(profileInspect.Command)
 	rootCmd.AddCommand(profileLs.Command)
+	rootCmd.AddCommand(profileRm.Command)
+	rootCmd.AddCommand(profileShow.Command)
 
+	rootCmd.AddCommand(publish.Command)
 	rootCmd.AddCommand(purge.Command)
+	rootCmd.AddCommand(restore.Command)
+	rootCmd.AddCommand(resume.Command)
+	rootCmd.AddCommand(rm.Command)
 	rootCmd.AddCommand(rootfs.Command)
 	rootCmd.AddCommand(run.Command)
+	rootCmd.AddCommand(selftest.Command)
+	rootCmd.AddCommand(snapshot.Command)
+	rootCmd.AddCommand(stats.Command)
+	rootCmd.AddCommand(storageDedup.Command)
+	rootCmd.AddCommand(tag.Command)
+	rootCmd.AddCommand(verify.Command)
+	rootCmd.AddCommand(versionCmd.Command)
 }
 
 func main() {
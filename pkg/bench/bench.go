@@ -0,0 +1,93 @@
+package bench
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/tracing"
+)
+
+// Phase is a measured phase of the build and the boot cycle and the span measuring it.
+type Phase struct {
+	Name      string
+	Operation string
+}
+
+// Phases are the measured phases, in the order of execution.
+var Phases = []Phase{
+	{Name: "build", Operation: "build-rootfs"},
+	{Name: "build-docker-export", Operation: "rootfs-build-dependencies"},
+	{Name: "build-rootfs-copy", Operation: "rootfs-copy"},
+	{Name: "build-bootstrap", Operation: "rootfs-boostrapping"},
+	{Name: "build-persist", Operation: "rootfs-persist"},
+	{Name: "run", Operation: "run"},
+	{Name: "run-rootfs-copy", Operation: "run-rootfs-copy"},
+	{Name: "run-boot", Operation: "run-vmm-start"},
+	{Name: "run-readiness", Operation: "run-vmm-provision"},
+}
+
+// PhaseSummary is the duration distribution of a phase, the durations are in milliseconds.
+type PhaseSummary struct {
+	Phase     string  `json:"Phase"`
+	Operation string  `json:"Operation"`
+	Samples   int     `json:"Samples"`
+	MinMs     float64 `json:"MinMs"`
+	MeanMs    float64 `json:"MeanMs"`
+	P50Ms     float64 `json:"P50Ms"`
+	P90Ms     float64 `json:"P90Ms"`
+	P99Ms     float64 `json:"P99Ms"`
+	MaxMs     float64 `json:"MaxMs"`
+}
+
+// Summarize returns the summaries of the phases with at least one sample.
+// Every recorded span of a phase operation is a sample.
+func Summarize(spans []*tracing.SpanRecord) []*PhaseSummary {
+	durations := map[string][]time.Duration{}
+	for _, span := range spans {
+		durations[span.Operation] = append(durations[span.Operation], span.Duration)
+	}
+	result := []*PhaseSummary{}
+	for _, phase := range Phases {
+		samples := durations[phase.Operation]
+		if len(samples) == 0 {
+			continue
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		total := time.Duration(0)
+		for _, sample := range samples {
+			total = total + sample
+		}
+		result = append(result, &PhaseSummary{
+			Phase:     phase.Name,
+			Operation: phase.Operation,
+			Samples:   len(samples),
+			MinMs:     toMs(samples[0]),
+			MeanMs:    toMs(total / time.Duration(len(samples))),
+			P50Ms:     toMs(Percentile(samples, 50)),
+			P90Ms:     toMs(Percentile(samples, 90)),
+			P99Ms:     toMs(Percentile(samples, 99)),
+			MaxMs:     toMs(samples[len(samples)-1]),
+		})
+	}
+	return result
+}
+
+// Percentile returns the nearest-rank percentile of the sorted durations.
+func Percentile(sorted []time.Duration, percentile float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func toMs(input time.Duration) float64 {
+	return float64(input) / float64(time.Millisecond)
+}
@@ -0,0 +1,45 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{}
+	for i := 1; i <= 10; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Second)
+	}
+	assert.Equal(t, time.Second*5, Percentile(sorted, 50))
+	assert.Equal(t, time.Second*9, Percentile(sorted, 90))
+	assert.Equal(t, time.Second*10, Percentile(sorted, 99))
+	assert.Equal(t, time.Second, Percentile(sorted, 0))
+	assert.Equal(t, time.Duration(0), Percentile([]time.Duration{}, 50))
+}
+
+func TestSummarize(t *testing.T) {
+	spans := []*tracing.SpanRecord{
+		{Operation: "run-vmm-start", Duration: time.Millisecond * 300},
+		{Operation: "run-vmm-start", Duration: time.Millisecond * 100},
+		{Operation: "run-vmm-start", Duration: time.Millisecond * 200},
+		{Operation: "rootfs-copy", Duration: time.Millisecond * 50},
+		{Operation: "not-a-phase", Duration: time.Second},
+	}
+	summaries := Summarize(spans)
+	assert.Equal(t, 2, len(summaries))
+
+	assert.Equal(t, "build-rootfs-copy", summaries[0].Phase)
+	assert.Equal(t, 1, summaries[0].Samples)
+	assert.Equal(t, float64(50), summaries[0].P99Ms)
+
+	assert.Equal(t, "run-boot", summaries[1].Phase)
+	assert.Equal(t, 3, summaries[1].Samples)
+	assert.Equal(t, float64(100), summaries[1].MinMs)
+	assert.Equal(t, float64(200), summaries[1].MeanMs)
+	assert.Equal(t, float64(200), summaries[1].P50Ms)
+	assert.Equal(t, float64(300), summaries[1].P90Ms)
+	assert.Equal(t, float64(300), summaries[1].MaxMs)
+}
@@ -0,0 +1,148 @@
+package build
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/combust-labs/firebuild-embedded-ca/ca"
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+)
+
+// bootstrapHandshakeTimeout bounds the local bootstrap TLS handshake.
+const bootstrapHandshakeTimeout = time.Second * 30
+
+// minimumPlausibleClock is the earliest time the host clock is expected to show,
+// an earlier clock is most likely unset and produces certificates the guest rejects.
+var minimumPlausibleClock = time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// BootstrapTLSReport describes the bootstrap TLS setup verified by ValidateBootstrapTLS.
+type BootstrapTLSReport struct {
+	ChainLength       int
+	KeySize           int
+	NotAfter          time.Time
+	NotBefore         time.Time
+	ServerName        string
+	TLSVersion        string
+	HandshakeDuration time.Duration
+}
+
+// ValidateBootstrapTLS constructs an embedded CA with the bootstrap configuration, the server TLS config
+// of the bootstrap server and the client certificate handed to the guest over MMDS, then verifies
+// a TLS handshake between the two locally. The client is configured like the guest configures it:
+// from the PEM encoded CA chain, the client certificate and key, and the server name.
+// Returns an error describing the failed step, the certificate validity window is included
+// in the handshake errors to surface the clock issues.
+func ValidateBootstrapTLS(caConfig *ca.EmbeddedCAConfig, serverName string, logger hclog.Logger) (*BootstrapTLSReport, error) {
+
+	now := time.Now()
+	if now.Before(minimumPlausibleClock) {
+		return nil, fmt.Errorf("host clock reads %s, the bootstrap certificates would not be valid in the guest; set the host clock", now.UTC().Format(time.RFC3339))
+	}
+
+	embeddedCA, err := ca.NewDefaultEmbeddedCAWithLogger(caConfig, logger)
+	if err != nil {
+		return nil, errors.Wrapf(err, "embedded CA setup failed, key size %d", caConfig.KeySize)
+	}
+
+	serverTLSConfig, err := embeddedCA.NewServerCertTLSConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "bootstrap server TLS config setup failed")
+	}
+
+	clientCertData, err := embeddedCA.NewClientCert()
+	if err != nil {
+		return nil, errors.Wrap(err, "bootstrap client certificate setup failed")
+	}
+
+	report := &BootstrapTLSReport{
+		ChainLength: len(embeddedCA.CAPEMChain()),
+		NotAfter:    clientCertData.Certificate().NotAfter,
+		NotBefore:   clientCertData.Certificate().NotBefore,
+		ServerName:  serverName,
+	}
+	if publicKey, ok := clientCertData.Certificate().PublicKey.(*rsa.PublicKey); ok {
+		report.KeySize = publicKey.N.BitLen()
+	}
+
+	clientTLSConfig, err := guestClientTLSConfig(embeddedCA.CAPEMChain(), clientCertData.CertificatePEM(), clientCertData.KeyPEM(), serverName)
+	if err != nil {
+		return report, err
+	}
+
+	started := time.Now()
+	version, err := handshake(serverTLSConfig, clientTLSConfig)
+	report.HandshakeDuration = time.Since(started)
+	if err != nil {
+		return report, errors.Wrapf(err, "bootstrap TLS handshake failed, certificates valid from %s to %s, host clock %s",
+			report.NotBefore.UTC().Format(time.RFC3339),
+			report.NotAfter.UTC().Format(time.RFC3339),
+			time.Now().UTC().Format(time.RFC3339))
+	}
+	report.TLSVersion = tls.VersionName(version)
+
+	return report, nil
+}
+
+// guestClientTLSConfig builds the client TLS config from the bootstrap data the guest receives.
+func guestClientTLSConfig(caChain []string, certificatePEM, keyPEM []byte, serverName string) (*tls.Config, error) {
+	rootCAs := x509.NewCertPool()
+	for idx, caPEM := range caChain {
+		if block, _ := pem.Decode([]byte(caPEM)); block == nil {
+			return nil, fmt.Errorf("CA chain certificate %d is not PEM encoded", idx)
+		}
+		if !rootCAs.AppendCertsFromPEM([]byte(caPEM)) {
+			return nil, fmt.Errorf("CA chain certificate %d could not be parsed", idx)
+		}
+	}
+	clientCertificate, err := tls.X509KeyPair(certificatePEM, keyPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "client certificate and key do not form a valid key pair")
+	}
+	return &tls.Config{
+		ServerName:   serverName,
+		RootCAs:      rootCAs,
+		Certificates: []tls.Certificate{clientCertificate},
+	}, nil
+}
+
+// handshake completes a TLS handshake between the server and the client config over an in-memory connection.
+// Both sides must complete, the server verifies the client certificate after the client finished.
+func handshake(serverTLSConfig, clientTLSConfig *tls.Config) (uint16, error) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	deadline := time.Now().Add(bootstrapHandshakeTimeout)
+	serverConn.SetDeadline(deadline)
+	clientConn.SetDeadline(deadline)
+
+	chanServerErr := make(chan error, 1)
+	go func() {
+		server := tls.Server(serverConn, serverTLSConfig)
+		err := server.Handshake()
+		if err == nil {
+			// with TLS 1.3 the server reads the client certificate with the first read:
+			_, err = server.Read(make([]byte, 1))
+		}
+		chanServerErr <- err
+		server.Close()
+	}()
+
+	client := tls.Client(clientConn, clientTLSConfig)
+	if err := client.Handshake(); err != nil {
+		return 0, errors.Wrap(err, "client")
+	}
+	if _, err := client.Write([]byte{0}); err != nil {
+		return 0, errors.Wrap(err, "client")
+	}
+	if err := <-chanServerErr; err != nil {
+		return 0, errors.Wrap(err, "server")
+	}
+	return client.ConnectionState().Version, nil
+}
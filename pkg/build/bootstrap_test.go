@@ -0,0 +1,58 @@
+package build
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild-embedded-ca/ca"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBootstrapTLS(t *testing.T) {
+	report, err := ValidateBootstrapTLS(&ca.EmbeddedCAConfig{
+		Addresses:     []string{"vmm-id"},
+		CertsValidFor: time.Minute * 5,
+		KeySize:       2048,
+	}, "vmm-id", hclog.NewNullLogger())
+	assert.Nil(t, err)
+	assert.Equal(t, 2048, report.KeySize)
+	assert.Equal(t, "vmm-id", report.ServerName)
+	assert.True(t, report.ChainLength > 0)
+	assert.NotEmpty(t, report.TLSVersion)
+	assert.Equal(t, time.Minute*5, report.NotAfter.Sub(report.NotBefore).Round(time.Second))
+}
+
+func TestValidateBootstrapTLSServerNameMismatch(t *testing.T) {
+	report, err := ValidateBootstrapTLS(&ca.EmbeddedCAConfig{
+		Addresses:     []string{"vmm-id"},
+		CertsValidFor: time.Minute,
+		KeySize:       2048,
+	}, "other-vmm-id", hclog.NewNullLogger())
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "bootstrap TLS handshake failed"), err.Error())
+	assert.True(t, strings.Contains(err.Error(), "certificates valid from"), err.Error())
+	assert.NotNil(t, report)
+}
+
+func TestValidateBootstrapTLSExpired(t *testing.T) {
+	// a negative validity produces certificates expired when issued, like a guest clock ahead of the host:
+	_, err := ValidateBootstrapTLS(&ca.EmbeddedCAConfig{
+		Addresses:     []string{"vmm-id"},
+		CertsValidFor: -time.Minute,
+		KeySize:       2048,
+	}, "vmm-id", hclog.NewNullLogger())
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "expired or is not yet valid"), err.Error())
+}
+
+func TestValidateBootstrapTLSInvalidKeySize(t *testing.T) {
+	_, err := ValidateBootstrapTLS(&ca.EmbeddedCAConfig{
+		Addresses:     []string{"vmm-id"},
+		CertsValidFor: time.Minute,
+		KeySize:       8,
+	}, "vmm-id", hclog.NewNullLogger())
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "key size 8"), err.Error())
+}
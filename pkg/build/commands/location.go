@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+)
+
+// SourceLocation is the location of a parsed command in the Dockerfile.
+type SourceLocation struct {
+	// SourceFile is the Dockerfile source, empty for literal Dockerfiles.
+	SourceFile string
+	// Line is the Dockerfile line the command starts at, 0 if not known.
+	Line int
+}
+
+// IsKnown returns true if the location is known.
+func (l SourceLocation) IsKnown() bool {
+	return l.Line > 0
+}
+
+// String returns a file:line representation of the location.
+func (l SourceLocation) String() string {
+	if l.SourceFile == "" {
+		return fmt.Sprintf("line %d", l.Line)
+	}
+	return fmt.Sprintf("%s:%d", l.SourceFile, l.Line)
+}
+
+// SourceLocations resolves source locations of the parsed commands.
+//
+// The commands are copied by value when processed, for example by the stages reader,
+// so the locations can't be attached to the commands. Instead, the commands are matched
+// by their type and original command, in the order of appearance.
+type SourceLocations interface {
+	// Locate returns the location of the command. Consecutive calls are expected
+	// to follow the order of appearance in the Dockerfile, commands repeated
+	// out of order, for example an ARG before the first FROM, are still located.
+	Locate(interface{}) SourceLocation
+}
+
+type locatedCommand struct {
+	commandType string
+	original    string
+	location    SourceLocation
+}
+
+type defaultSourceLocations struct {
+	cursor   int
+	commands []locatedCommand
+}
+
+// NewSourceLocations returns source locations for the parsed commands.
+// The lines are the start lines of the commands, in order of the commands.
+func NewSourceLocations(parsedCommands []interface{}, lines []int, sourceFile string) SourceLocations {
+	located := []locatedCommand{}
+	for idx, cmd := range parsedCommands {
+		location := SourceLocation{SourceFile: sourceFile}
+		if idx < len(lines) {
+			location.Line = lines[idx]
+		}
+		located = append(located, locatedCommand{
+			commandType: commandTypeName(cmd),
+			original:    commandOriginal(cmd),
+			location:    location,
+		})
+	}
+	return &defaultSourceLocations{commands: located}
+}
+
+// NoSourceLocations returns source locations resolving every command to an unknown location.
+func NoSourceLocations() SourceLocations {
+	return &defaultSourceLocations{commands: []locatedCommand{}}
+}
+
+func (l *defaultSourceLocations) Locate(cmd interface{}) SourceLocation {
+	commandType, original := commandTypeName(cmd), commandOriginal(cmd)
+	if found := l.find(l.cursor, commandType, original); found > -1 {
+		l.cursor = found + 1
+		return l.commands[found].location
+	}
+	if found := l.find(0, commandType, original); found > -1 {
+		return l.commands[found].location
+	}
+	return SourceLocation{}
+}
+
+func (l *defaultSourceLocations) find(from int, commandType, original string) int {
+	for idx := from; idx < len(l.commands); idx++ {
+		if l.commands[idx].commandType == commandType && l.commands[idx].original == original {
+			return idx
+		}
+	}
+	return -1
+}
+
+func commandTypeName(cmd interface{}) string {
+	if cmd == nil {
+		return ""
+	}
+	return reflect.TypeOf(cmd).Name()
+}
+
+func commandOriginal(cmd interface{}) string {
+	if serializable, ok := cmd.(commands.DockerfileSerializable); ok {
+		return serializable.GetOriginal()
+	}
+	return ""
+}
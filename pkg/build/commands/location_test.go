@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceLocations(t *testing.T) {
+	argVersion := commands.Arg{OriginalCommand: "ARG VERSION=1"}
+	parsed := []interface{}{
+		argVersion,
+		commands.From{OriginalCommand: "FROM alpine:3.13 as builder"},
+		commands.Run{OriginalCommand: "RUN echo 1"},
+		commands.From{OriginalCommand: "FROM alpine:3.13"},
+		commands.Run{OriginalCommand: "RUN echo 1"},
+		StopSignal{OriginalCommand: "STOPSIGNAL SIGTERM"},
+	}
+	locations := NewSourceLocations(parsed, []int{1, 3, 4, 6, 7, 8}, "Dockerfile")
+
+	assert.Equal(t, SourceLocation{SourceFile: "Dockerfile", Line: 3}, locations.Locate(parsed[1]))
+	assert.Equal(t, 4, locations.Locate(parsed[2]).Line)
+	// the global ARG repeated within a stage is located out of order:
+	assert.Equal(t, 1, locations.Locate(argVersion).Line)
+	assert.Equal(t, 6, locations.Locate(parsed[3]).Line)
+	// identical commands resolve to the next occurrence:
+	assert.Equal(t, 7, locations.Locate(parsed[4]).Line)
+	assert.Equal(t, 8, locations.Locate(parsed[5]).Line)
+	assert.Equal(t, "Dockerfile:8", locations.Locate(parsed[5]).String())
+
+	unknown := locations.Locate(commands.Run{OriginalCommand: "RUN not parsed"})
+	assert.False(t, unknown.IsKnown())
+	assert.False(t, NoSourceLocations().Locate(parsed[1]).IsKnown())
+}
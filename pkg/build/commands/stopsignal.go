@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DefaultStopSignal is the signal used to stop the main process
+// when the Dockerfile does not define the STOPSIGNAL.
+const DefaultStopSignal = "SIGTERM"
+
+// The real-time signals are named relative to the first and the last one, like by kill -l,
+// for example SIGRTMIN+3 or SIGRTMAX-2.
+const (
+	sigRTMin = 34
+	sigRTMax = 64
+)
+
+var knownSignals = map[string]syscall.Signal{
+	"SIGABRT":   syscall.SIGABRT,
+	"SIGALRM":   syscall.SIGALRM,
+	"SIGBUS":    syscall.SIGBUS,
+	"SIGCHLD":   syscall.SIGCHLD,
+	"SIGCONT":   syscall.SIGCONT,
+	"SIGFPE":    syscall.SIGFPE,
+	"SIGHUP":    syscall.SIGHUP,
+	"SIGILL":    syscall.SIGILL,
+	"SIGINT":    syscall.SIGINT,
+	"SIGIO":     syscall.SIGIO,
+	"SIGKILL":   syscall.SIGKILL,
+	"SIGPIPE":   syscall.SIGPIPE,
+	"SIGPROF":   syscall.SIGPROF,
+	"SIGPWR":    syscall.SIGPWR,
+	"SIGQUIT":   syscall.SIGQUIT,
+	"SIGSEGV":   syscall.SIGSEGV,
+	"SIGSTKFLT": syscall.SIGSTKFLT,
+	"SIGSTOP":   syscall.SIGSTOP,
+	"SIGSYS":    syscall.SIGSYS,
+	"SIGTERM":   syscall.SIGTERM,
+	"SIGTRAP":   syscall.SIGTRAP,
+	"SIGTSTP":   syscall.SIGTSTP,
+	"SIGTTIN":   syscall.SIGTTIN,
+	"SIGTTOU":   syscall.SIGTTOU,
+	"SIGURG":    syscall.SIGURG,
+	"SIGUSR1":   syscall.SIGUSR1,
+	"SIGUSR2":   syscall.SIGUSR2,
+	"SIGVTALRM": syscall.SIGVTALRM,
+	"SIGWINCH":  syscall.SIGWINCH,
+	"SIGXCPU":   syscall.SIGXCPU,
+	"SIGXFSZ":   syscall.SIGXFSZ,
+}
+
+// StopSignal represents the STOPSIGNAL instruction.
+// The value is stored in the symbolic form, for example SIGKILL. A value referencing
+// the build args, for example $SIG, is stored as is and normalized by the build.
+type StopSignal struct {
+	OriginalCommand string `json:"OriginalCommand" mapstructure:"OriginalCommand"`
+	Value           string `json:"Value" mapstructure:"Value"`
+}
+
+// GetOriginal returns the original string command the command was parsed from.
+func (cmd StopSignal) GetOriginal() string {
+	return cmd.OriginalCommand
+}
+
+// ReferencesArgs returns true when the value references the build args and must be expanded.
+func (cmd StopSignal) ReferencesArgs() bool {
+	return strings.Contains(cmd.Value, "$")
+}
+
+// NewStopSignal returns a new parsed STOPSIGNAL from the raw input.
+// The input can be given in the numeric form, for example 9, in the symbolic form,
+// for example SIGKILL, KILL or SIGRTMIN+3, or reference the build args, for example $SIG.
+func NewStopSignal(input string) (StopSignal, error) {
+	if strings.Contains(input, "$") {
+		return StopSignal{Value: strings.TrimSpace(input)}, nil
+	}
+	value, err := NormalizeStopSignal(input)
+	if err != nil {
+		return StopSignal{}, err
+	}
+	return StopSignal{Value: value}, nil
+}
+
+// NormalizeStopSignal validates the signal and returns its symbolic form.
+func NormalizeStopSignal(input string) (string, error) {
+	candidate := strings.ToUpper(strings.TrimSpace(input))
+	if candidate == "" {
+		return "", fmt.Errorf("stopsignal: missing value")
+	}
+	if number, err := strconv.Atoi(candidate); err == nil {
+		for name, sig := range knownSignals {
+			if int(sig) == number {
+				return name, nil
+			}
+		}
+		if number >= sigRTMin && number <= sigRTMax {
+			return rtSignalName(number), nil
+		}
+		return "", fmt.Errorf("stopsignal: unsupported signal number %d", number)
+	}
+	if !strings.HasPrefix(candidate, "SIG") {
+		candidate = "SIG" + candidate
+	}
+	if number, ok := parseRTSignal(candidate); ok {
+		return rtSignalName(number), nil
+	}
+	if _, ok := knownSignals[candidate]; !ok {
+		return "", fmt.Errorf("stopsignal: unsupported signal %q", input)
+	}
+	return candidate, nil
+}
+
+// parseRTSignal returns the number of the real-time signal given as SIGRTMIN+n or SIGRTMAX-n.
+func parseRTSignal(candidate string) (int, bool) {
+	for _, base := range []struct {
+		name   string
+		number int
+		sign   string
+	}{{"SIGRTMIN", sigRTMin, "+"}, {"SIGRTMAX", sigRTMax, "-"}} {
+		if !strings.HasPrefix(candidate, base.name) {
+			continue
+		}
+		offset := strings.TrimPrefix(candidate, base.name)
+		if offset == "" {
+			return base.number, true
+		}
+		if !strings.HasPrefix(offset, base.sign) {
+			return 0, false
+		}
+		value, err := strconv.Atoi(strings.TrimPrefix(offset, base.sign))
+		if err != nil || value < 0 || value > sigRTMax-sigRTMin {
+			return 0, false
+		}
+		if base.sign == "-" {
+			return base.number - value, true
+		}
+		return base.number + value, true
+	}
+	return 0, false
+}
+
+// rtSignalName returns the name of the real-time signal, the lower half is named relative to SIGRTMIN,
+// the upper half relative to SIGRTMAX.
+func rtSignalName(number int) string {
+	switch {
+	case number == sigRTMin:
+		return "SIGRTMIN"
+	case number == sigRTMax:
+		return "SIGRTMAX"
+	case number-sigRTMin <= (sigRTMax-sigRTMin)/2:
+		return fmt.Sprintf("SIGRTMIN+%d", number-sigRTMin)
+	default:
+		return fmt.Sprintf("SIGRTMAX-%d", sigRTMax-number)
+	}
+}
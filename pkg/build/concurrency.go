@@ -0,0 +1,53 @@
+package build
+
+import "context"
+
+// ConcurrencyLimit is a semaphore shared by the phases of the build pipeline.
+// It bounds the total number of the concurrent heavy operations, like the stage builds
+// and the image exports, regardless of the limits of the individual phases.
+// A nil limit does not bound anything.
+type ConcurrencyLimit struct {
+	slots chan struct{}
+}
+
+// NewConcurrencyLimit returns a limit allowing at most max concurrent operations, at least 1.
+func NewConcurrencyLimit(max int) *ConcurrencyLimit {
+	if max < 1 {
+		max = 1
+	}
+	return &ConcurrencyLimit{slots: make(chan struct{}, max)}
+}
+
+// Acquire blocks until an operation slot is available or the context is done.
+// Every successful Acquire must be followed by a Release.
+func (l *ConcurrencyLimit) Acquire(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case l.slots <- struct{}{}:
+		if err := ctx.Err(); err != nil {
+			<-l.slots
+			return err
+		}
+		return nil
+	}
+}
+
+// Release returns the operation slot to the limit.
+func (l *ConcurrencyLimit) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// Max returns the maximum number of concurrent operations, 0 for a nil limit.
+func (l *ConcurrencyLimit) Max() int {
+	if l == nil {
+		return 0
+	}
+	return cap(l.slots)
+}
@@ -0,0 +1,28 @@
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	limit := NewConcurrencyLimit(0)
+	assert.Equal(t, 1, limit.Max())
+	assert.Nil(t, limit.Acquire(context.Background()))
+
+	// the only slot is taken, the acquire gives up with the context:
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+	assert.Equal(t, context.Canceled, limit.Acquire(ctx))
+
+	limit.Release()
+	assert.Nil(t, limit.Acquire(context.Background()))
+	limit.Release()
+
+	var unbounded *ConcurrencyLimit
+	assert.Equal(t, 0, unbounded.Max())
+	assert.Nil(t, unbounded.Acquire(context.Background()))
+	unbounded.Release()
+}
@@ -0,0 +1,80 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/hashicorp/go-multierror"
+)
+
+// BuildDependencies builds the stage dependencies concurrently, at most maxParallel at a time.
+// Every build also takes a slot of the limit shared with the other phases of the build, if any.
+// The builders are keyed by the stage name. The first failed build cancels the builds
+// in progress and the builds which have not started yet.
+// Returns the resolved resources keyed by the stage name and the aggregated build errors.
+func BuildDependencies(ctx context.Context, builders map[string]DependencyBuild, externalCopies []commands.Copy, maxParallel int, limit *ConcurrencyLimit) (map[string][]resources.ResolvedResource, error) {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+
+	buildCtx, buildCtxCancelFunc := context.WithCancel(ctx)
+	defer buildCtxCancelFunc()
+
+	var (
+		buildErrors *multierror.Error
+		lock        sync.Mutex
+		wg          sync.WaitGroup
+	)
+	dependencyResources := map[string][]resources.ResolvedResource{}
+	semaphore := make(chan struct{}, maxParallel)
+
+	// start the builds in a stable order:
+	stageNames := []string{}
+	for stageName := range builders {
+		stageNames = append(stageNames, stageName)
+	}
+	sort.Strings(stageNames)
+
+schedule:
+	for _, stageName := range stageNames {
+		select {
+		case <-buildCtx.Done():
+			break schedule
+		case semaphore <- struct{}{}:
+			if buildCtx.Err() != nil {
+				<-semaphore
+				break schedule
+			}
+		}
+		if err := limit.Acquire(buildCtx); err != nil {
+			<-semaphore
+			break schedule
+		}
+		wg.Add(1)
+		go func(stageName string, builder DependencyBuild) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			defer limit.Release()
+			resolvedResources, buildErr := builder.WithContext(buildCtx).Build(externalCopies)
+			lock.Lock()
+			defer lock.Unlock()
+			if buildErr != nil {
+				buildErrors = multierror.Append(buildErrors, fmt.Errorf("stage %q: %v", stageName, buildErr))
+				buildCtxCancelFunc()
+				return
+			}
+			dependencyResources[stageName] = resolvedResources
+		}(stageName, builders[stageName])
+	}
+
+	wg.Wait()
+
+	if buildErrors == nil && ctx.Err() != nil {
+		return dependencyResources, ctx.Err()
+	}
+	return dependencyResources, buildErrors.ErrorOrNil()
+}
@@ -0,0 +1,175 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildDependenciesBounded(t *testing.T) {
+	tracker := &concurrencyTracker{}
+	builders := map[string]DependencyBuild{}
+	for i := 0; i < 6; i++ {
+		builders[fmt.Sprintf("stage%d", i)] = &fakeDependencyBuild{
+			build: func(ctx context.Context) error {
+				tracker.enter()
+				defer tracker.leave()
+				time.Sleep(time.Millisecond * 20)
+				return nil
+			},
+		}
+	}
+	dependencyResources, err := BuildDependencies(context.Background(), builders, []commands.Copy{}, 2, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(dependencyResources))
+	assert.Equal(t, 2, tracker.maxActive)
+}
+
+func TestBuildDependenciesSharedLimit(t *testing.T) {
+	tracker := &concurrencyTracker{}
+	newBuilders := func() map[string]DependencyBuild {
+		builders := map[string]DependencyBuild{}
+		for i := 0; i < 4; i++ {
+			builders[fmt.Sprintf("stage%d", i)] = &fakeDependencyBuild{
+				build: func(ctx context.Context) error {
+					tracker.enter()
+					defer tracker.leave()
+					time.Sleep(time.Millisecond * 20)
+					return nil
+				},
+			}
+		}
+		return builders
+	}
+	// two concurrent phases, each allowed 4 operations, share the limit of 3:
+	limit := NewConcurrencyLimit(3)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dependencyResources, err := BuildDependencies(context.Background(), newBuilders(), []commands.Copy{}, 4, limit)
+			assert.Nil(t, err)
+			assert.Equal(t, 4, len(dependencyResources))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, tracker.maxActive)
+}
+
+func TestBuildDependenciesFailureCancels(t *testing.T) {
+	started := make(chan struct{}, 2)
+	builders := map[string]DependencyBuild{
+		"blocking1": &fakeDependencyBuild{
+			build: func(ctx context.Context) error {
+				started <- struct{}{}
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+		"blocking2": &fakeDependencyBuild{
+			build: func(ctx context.Context) error {
+				started <- struct{}{}
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+		"failing": &fakeDependencyBuild{
+			build: func(ctx context.Context) error {
+				// fail once the independent stages are in progress:
+				<-started
+				<-started
+				return fmt.Errorf("build failed")
+			},
+		},
+	}
+	chanResult := make(chan error, 1)
+	go func() {
+		_, err := BuildDependencies(context.Background(), builders, []commands.Copy{}, 3, nil)
+		chanResult <- err
+	}()
+	select {
+	case err := <-chanResult:
+		assert.NotNil(t, err)
+		assert.True(t, strings.Contains(err.Error(), `stage "failing": build failed`), err.Error())
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected the failed stage to cancel the builds in progress")
+	}
+}
+
+func TestBuildDependenciesFailureSkipsPending(t *testing.T) {
+	calls := 0
+	lock := &sync.Mutex{}
+	newBuilder := func(err error) DependencyBuild {
+		return &fakeDependencyBuild{
+			build: func(ctx context.Context) error {
+				lock.Lock()
+				defer lock.Unlock()
+				calls = calls + 1
+				return err
+			},
+		}
+	}
+	builders := map[string]DependencyBuild{
+		"a": newBuilder(fmt.Errorf("build failed")),
+		"b": newBuilder(nil),
+		"c": newBuilder(nil),
+	}
+	_, err := BuildDependencies(context.Background(), builders, []commands.Copy{}, 1, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls, "expected the pending builds not to start")
+}
+
+type concurrencyTracker struct {
+	sync.Mutex
+	active    int
+	maxActive int
+}
+
+func (c *concurrencyTracker) enter() {
+	c.Lock()
+	defer c.Unlock()
+	c.active = c.active + 1
+	if c.active > c.maxActive {
+		c.maxActive = c.active
+	}
+}
+
+func (c *concurrencyTracker) leave() {
+	c.Lock()
+	defer c.Unlock()
+	c.active = c.active - 1
+}
+
+type fakeDependencyBuild struct {
+	build func(context.Context) error
+	ctx   context.Context
+}
+
+func (f *fakeDependencyBuild) Build([]commands.Copy) ([]resources.ResolvedResource, error) {
+	if err := f.build(f.ctx); err != nil {
+		return nil, err
+	}
+	return []resources.ResolvedResource{}, nil
+}
+
+func (f *fakeDependencyBuild) WithBuildID(string) DependencyBuild { return f }
+func (f *fakeDependencyBuild) WithContext(input context.Context) DependencyBuild {
+	f.ctx = input
+	return f
+}
+func (f *fakeDependencyBuild) WithDockerConfig(*configs.DockerConfig) DependencyBuild { return f }
+func (f *fakeDependencyBuild) WithEscapeToken(rune) DependencyBuild                   { return f }
+func (f *fakeDependencyBuild) WithKeepContainers(bool) DependencyBuild                { return f }
+func (f *fakeDependencyBuild) WithLogger(hclog.Logger) DependencyBuild                { return f }
+func (f *fakeDependencyBuild) WithStageCacheDirectory(string) DependencyBuild         { return f }
+func (f *fakeDependencyBuild) getDependencyDockerfileContent() []string               { return []string{} }
@@ -5,15 +5,18 @@ import (
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/combust-labs/firebuild-shared/build/commands"
 	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/combust-labs/firebuild/configs"
 	"github.com/combust-labs/firebuild/pkg/build/stage"
 	"github.com/combust-labs/firebuild/pkg/containers"
 	"github.com/combust-labs/firebuild/pkg/utils"
 	"github.com/hashicorp/go-hclog"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
 )
 
 // DependencyBuild represents the build process of the main FS dependency.
@@ -21,21 +24,42 @@ import (
 // the build.
 type DependencyBuild interface {
 	Build([]commands.Copy) ([]resources.ResolvedResource, error)
+	WithBuildID(string) DependencyBuild
+	WithContext(context.Context) DependencyBuild
+	WithDockerConfig(*configs.DockerConfig) DependencyBuild
+	WithEscapeToken(rune) DependencyBuild
+	WithKeepContainers(bool) DependencyBuild
 	WithLogger(hclog.Logger) DependencyBuild
+	WithStageCacheDirectory(string) DependencyBuild
 	getDependencyDockerfileContent() []string
 }
 
 type defaultDependencyBuild struct {
+	buildID          string
 	contextDirectory string
+	ctx              context.Context
+	dockerConfig     *configs.DockerConfig
+	escapeToken      rune
+	keepContainers   bool
 	logger           hclog.Logger
 	stage            stage.Stage
+	stageCacheDir    string
 	tempDir          string
 }
 
+// DependencyExportsRoot returns the directory the resources of the dependency stage are exported to.
+func DependencyExportsRoot(tempDir, stageName string) string {
+	return filepath.Join(tempDir, fmt.Sprintf("%s-export", stageName))
+}
+
 // NewDefaultDependencyBuild creates a new dependency builder using the default implementation.
 func NewDefaultDependencyBuild(st stage.Stage, tempDir, contextDir string) DependencyBuild {
 	return &defaultDependencyBuild{
+		buildID:          strings.ToLower(utils.RandStringBytes(32)),
 		contextDirectory: contextDir,
+		ctx:              context.Background(),
+		dockerConfig:     configs.NewDockerConfig(),
+		escapeToken:      parser.DefaultEscapeToken,
 		logger:           hclog.Default(),
 		stage:            st,
 		tempDir:          tempDir,
@@ -46,7 +70,7 @@ func (ddb *defaultDependencyBuild) Build(externalCopies []commands.Copy) ([]reso
 
 	emptyResponse := []resources.ResolvedResource{}
 
-	client, clientErr := containers.GetDefaultClient()
+	client, clientErr := containers.GetDefaultClientWithTimeout(ddb.dockerConfig.ClientTimeout)
 	if clientErr != nil {
 		return emptyResponse, fmt.Errorf("error fetching Docker client: %+v", clientErr)
 	}
@@ -55,30 +79,79 @@ func (ddb *defaultDependencyBuild) Build(externalCopies []commands.Copy) ([]reso
 	// using the multistage build but without extracting actual resources.
 	// This is perfectly possible with Docker.
 
-	// The cloned sources reside in .../sources directory, let's write our stage Dockerfile in there
+	// The stage Dockerfile is written to the context directory
 	randFileName := strings.ToLower(utils.RandStringBytes(32))
-	stageDockerfile := filepath.Join(ddb.contextDirectory, randFileName)
 	fullTagName := fmt.Sprintf("%s:build", randFileName)
-
-	if err := ioutil.WriteFile(stageDockerfile, []byte(strings.Join(ddb.getDependencyDockerfileContent(), "\n")), fs.ModePerm); err != nil {
-		return emptyResponse, fmt.Errorf("Failed writing stage Dockerfile: %+v", err)
+	imageBuildID := ddb.buildID
+
+	cacheKey := ""
+	if ddb.stageCacheDir != "" {
+		key, keyErr := ddb.stageCacheKey()
+		if keyErr != nil {
+			ddb.logger.Warn("Failed computing stage cache key, building without cache", "reason", keyErr)
+		} else {
+			cacheKey = key
+			fullTagName = stageCacheTag(cacheKey)
+		}
 	}
 
-	if buildError := containers.ImageBuild(context.Background(), client, ddb.logger,
-		ddb.contextDirectory, randFileName, fullTagName); buildError != nil {
-		return emptyResponse, fmt.Errorf("Failed building stage Docker image: %+v", buildError)
+	cacheHit := false
+	if cacheKey != "" {
+		lookupCtx, lookupCtxCancelFunc := containers.NewOperationContext(ddb.ctx, containers.OperationInspect, ddb.dockerConfig.InspectTimeout)
+		_, lookupErr := containers.FindImageIDByTag(lookupCtx, client, fullTagName)
+		lookupCtxCancelFunc()
+		if lookupErr == nil {
+			ddb.logger.Info("Using cached stage image", "tag-name", fullTagName)
+			cacheHit = true
+			// the cached image was labelled by the build which created it:
+			imageBuildID = ""
+		} else if lookupErr != containers.ErrImageNotFound {
+			return emptyResponse, fmt.Errorf("Failed looking up cached stage image: %+v", lookupErr)
+		}
 	}
 
-	defer func() {
-		if removeError := containers.ImageRemove(context.Background(), client, ddb.logger, fullTagName); removeError != nil {
-			ddb.logger.Error("Failed deleting stage Docker image", "reason", removeError)
+	if !cacheHit {
+		stageDockerfile := filepath.Join(ddb.contextDirectory, randFileName)
+
+		if err := ioutil.WriteFile(stageDockerfile, []byte(strings.Join(ddb.getDependencyDockerfileContent(), "\n")), fs.ModePerm); err != nil {
+			return emptyResponse, fmt.Errorf("Failed writing stage Dockerfile: %+v", err)
+		}
+
+		// the context directory may be the directory of a local Dockerfile, do not leave the stage Dockerfile behind:
+		defer func() {
+			if removeError := os.Remove(stageDockerfile); removeError != nil {
+				ddb.logger.Warn("Failed deleting stage Dockerfile", "reason", removeError)
+			}
+		}()
+
+		buildCtx, buildCtxCancelFunc := containers.NewOperationContext(ddb.ctx, containers.OperationBuild, ddb.dockerConfig.BuildTimeout)
+		defer buildCtxCancelFunc()
+
+		if buildError := containers.ImageBuild(buildCtx, client, ddb.logger,
+			ddb.contextDirectory, randFileName, fullTagName, ddb.buildID, ddb.dockerConfig.Platform, ddb.keepContainers); buildError != nil {
+			return emptyResponse, fmt.Errorf("Failed building stage Docker image: %+v", buildError)
 		}
-	}()
+	}
 
-	exportsRoot := filepath.Join(ddb.tempDir, fmt.Sprintf("%s-export", ddb.stage.Name()))
+	if cacheKey == "" {
+		defer func() {
+			removeCtx, removeCtxCancelFunc := containers.NewOperationContext(context.Background(), containers.OperationInspect, ddb.dockerConfig.InspectTimeout)
+			defer removeCtxCancelFunc()
+			if removeError := containers.ImageRemove(removeCtx, client, ddb.logger, fullTagName, ddb.buildID); removeError != nil {
+				ddb.logger.Error("Failed deleting stage Docker image", "reason", removeError)
+			}
+		}()
+	} else if err := touchStageCacheEntry(ddb.stageCacheDir, cacheKey); err != nil {
+		ddb.logger.Warn("Failed recording stage cache use", "reason", err)
+	}
 
-	resolvedResources, exportErr := containers.ImageExportStageDependentResources(context.Background(),
-		client, ddb.logger, ddb.stage, exportsRoot, externalCopies, fullTagName)
+	exportsRoot := DependencyExportsRoot(ddb.tempDir, ddb.stage.Name())
+
+	exportCtx, exportCtxCancelFunc := containers.NewOperationContext(ddb.ctx, containers.OperationSave, ddb.dockerConfig.SaveTimeout)
+	defer exportCtxCancelFunc()
+
+	resolvedResources, exportErr := containers.ImageExportStageDependentResources(exportCtx,
+		client, ddb.logger, ddb.stage, exportsRoot, externalCopies, fullTagName, imageBuildID)
 	if exportErr != nil {
 		return emptyResponse, fmt.Errorf("Failed exporting prefixes from the image: %+v", exportErr)
 	}
@@ -86,15 +159,61 @@ func (ddb *defaultDependencyBuild) Build(externalCopies []commands.Copy) ([]reso
 	return resolvedResources, nil
 }
 
+// WithBuildID sets the ID used to label the stage images so the image lookups
+// of concurrent builds do not interfere with each other.
+func (ddb *defaultDependencyBuild) WithBuildID(input string) DependencyBuild {
+	ddb.buildID = input
+	return ddb
+}
+
+// WithContext sets the parent context of the stage build and export operations.
+// Cancelling the context stops the build.
+func (ddb *defaultDependencyBuild) WithContext(input context.Context) DependencyBuild {
+	ddb.ctx = input
+	return ddb
+}
+
+// WithDockerConfig sets the Docker client, operation timeouts and the build platform.
+func (ddb *defaultDependencyBuild) WithDockerConfig(input *configs.DockerConfig) DependencyBuild {
+	ddb.dockerConfig = input
+	return ddb
+}
+
+// WithEscapeToken sets the escape character of the source Dockerfile.
+// The stage Dockerfile is written with the escape parser directive so Docker
+// processes the original commands with the same escape character.
+func (ddb *defaultDependencyBuild) WithEscapeToken(input rune) DependencyBuild {
+	ddb.escapeToken = input
+	return ddb
+}
+
+// WithKeepContainers controls if the intermediate stage build containers are kept for inspection.
+func (ddb *defaultDependencyBuild) WithKeepContainers(input bool) DependencyBuild {
+	ddb.keepContainers = input
+	return ddb
+}
+
 func (ddb *defaultDependencyBuild) WithLogger(input hclog.Logger) DependencyBuild {
 	ddb.logger = input
 	return ddb
 }
 
+// WithStageCacheDirectory enables the stage image cache, the directory holds the cache index.
+// The stage image is reused when the stage commands and the sources are unchanged.
+// An empty directory disables the cache, the stage image is removed after the build.
+func (ddb *defaultDependencyBuild) WithStageCacheDirectory(input string) DependencyBuild {
+	ddb.stageCacheDir = input
+	return ddb
+}
+
 // This function converts commands for a given stage back to the Dockerfile format
 // but removes `as ...` from the FROM command.
 func (ddb *defaultDependencyBuild) getDependencyDockerfileContent() []string {
 	stringCommands := []string{}
+	if ddb.escapeToken != parser.DefaultEscapeToken {
+		// parser directives must be at the top of the Dockerfile:
+		stringCommands = append(stringCommands, fmt.Sprintf("# escape=%c", ddb.escapeToken))
+	}
 	for _, cmd := range ddb.stage.Commands() {
 		switch tcmd := cmd.(type) {
 		case commands.From:
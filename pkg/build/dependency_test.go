@@ -78,3 +78,31 @@ func TestDependencyBuild(t *testing.T) {
 	}
 
 }
+
+func TestDependencyDockerfileEscapeDirective(t *testing.T) {
+	readResults, err := reader.ReadFromString("# escape=`\nFROM alpine:3.13 as builder\nRUN echo C:\\dir `\n  && echo done\n", "")
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	stages, errs := stage.ReadStages(readResults.Commands())
+	if len(errs) > 0 {
+		t.Fatal("Unexpected errors while processing stages", errs)
+	}
+
+	content := NewDefaultDependencyBuild(stages.NamedStage("builder"), "", "").
+		WithEscapeToken(readResults.EscapeToken()).(*defaultDependencyBuild).getDependencyDockerfileContent()
+	expected := []string{"# escape=`", "FROM alpine:3.13", "RUN echo C:\\dir   && echo done"}
+	if len(content) != len(expected) {
+		t.Fatalf("Expected stage Dockerfile %q, got %q", expected, content)
+	}
+	for idx := range expected {
+		if content[idx] != expected[idx] {
+			t.Fatalf("Expected stage Dockerfile %q, got %q", expected, content)
+		}
+	}
+
+	defaultContent := NewDefaultDependencyBuild(stages.NamedStage("builder"), "", "").(*defaultDependencyBuild).getDependencyDockerfileContent()
+	if defaultContent[0] != "FROM alpine:3.13" {
+		t.Fatalf("Expected no escape directive for the default escape token, got %q", defaultContent)
+	}
+}
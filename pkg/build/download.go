@@ -0,0 +1,72 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+)
+
+type downloadingResolver struct {
+	client     *http.Client
+	underlying resources.Resolver
+}
+
+// NewDownloadingResolver returns a resolver downloading the URL resources resolved by the underlying resolver
+// with the HTTP client. The download fails unless the server responds with the status 200.
+func NewDownloadingResolver(underlying resources.Resolver, client *http.Client) resources.Resolver {
+	return &downloadingResolver{
+		client:     client,
+		underlying: underlying,
+	}
+}
+
+// ResolveAdd resolves an ADD command resource.
+func (r *downloadingResolver) ResolveAdd(res commands.Add) ([]resources.ResolvedResource, error) {
+	resolved, err := r.underlying.ResolveAdd(res)
+	if err != nil {
+		return nil, err
+	}
+	return r.withDownloads(resolved), nil
+}
+
+// ResolveCopy resolves a COPY command resource.
+func (r *downloadingResolver) ResolveCopy(res commands.Copy) ([]resources.ResolvedResource, error) {
+	resolved, err := r.underlying.ResolveCopy(res)
+	if err != nil {
+		return nil, err
+	}
+	return r.withDownloads(resolved), nil
+}
+
+func (r *downloadingResolver) withDownloads(input []resources.ResolvedResource) []resources.ResolvedResource {
+	output := []resources.ResolvedResource{}
+	for _, resource := range input {
+		if resource.IsDir() || !isURL(resource.ResolvedURIOrPath()) {
+			output = append(output, resource)
+			continue
+		}
+		output = append(output, resources.NewResolvedFileResourceWithPath(r.download(resource.ResolvedURIOrPath()),
+			resource.TargetMode(), resource.SourcePath(), resource.TargetPath(), resource.TargetWorkdir(), resource.TargetUser(),
+			resource.ResolvedURIOrPath()))
+	}
+	return output
+}
+
+func (r *downloadingResolver) download(url string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		response, err := r.client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusOK {
+			io.Copy(ioutil.Discard, io.LimitReader(response.Body, 4096))
+			response.Body.Close()
+			return nil, fmt.Errorf("http resource failed: GET '%s' responded with status %d", url, response.StatusCode)
+		}
+		return response.Body, nil
+	}
+}
@@ -0,0 +1,53 @@
+package build
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadingResolverRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = requests + 1
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if requests < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("downloaded"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: utils.NewRetryingTransport(nil, utils.RetryConfig{Attempts: 3, InitialBackoff: time.Millisecond}, hclog.NewNullLogger())}
+	resolver := NewDownloadingResolver(resources.NewDefaultResolver(), client)
+
+	resolved, err := resolver.ResolveAdd(commands.Add{OriginalSource: "/tmp/Dockerfile", Source: server.URL + "/file", Target: "/file"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resolved))
+	assert.Equal(t, "/file", resolved[0].TargetPath())
+	reader, err := resolved[0].Contents()
+	assert.Nil(t, err)
+	defer reader.Close()
+	contents, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, "downloaded", string(contents))
+	assert.Equal(t, 3, requests)
+
+	// the error status fails the download instead of storing the response body:
+	resolved, err = resolver.ResolveAdd(commands.Add{OriginalSource: "/tmp/Dockerfile", Source: server.URL + "/missing", Target: "/missing"})
+	assert.Nil(t, err)
+	_, err = resolved[0].Contents()
+	assert.NotNil(t, err)
+}
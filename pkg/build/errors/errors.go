@@ -1,6 +1,9 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // ErrorIsDirectory is a builder directory input type string error.
 type ErrorIsDirectory struct {
@@ -19,3 +22,26 @@ type CommandOutOfScopeError struct {
 func (e *CommandOutOfScopeError) Error() string {
 	return fmt.Sprintf("command out of scope: %v", e.Command)
 }
+
+// UnresolvedBuildArgError is a FROM command referencing an undefined build arg.
+type UnresolvedBuildArgError struct {
+	Command interface{}
+}
+
+func (e *UnresolvedBuildArgError) Error() string {
+	return fmt.Sprintf("unresolved build arg: %v", e.Command)
+}
+
+// UnknownCopySourceError is a COPY --from command referencing neither a stage of the Dockerfile nor an image.
+type UnknownCopySourceError struct {
+	Command interface{}
+	Source  string
+	Stages  []string
+}
+
+func (e *UnknownCopySourceError) Error() string {
+	if len(e.Stages) == 0 {
+		return fmt.Sprintf("COPY --from=%q: not a valid image reference and the Dockerfile has no named stages", e.Source)
+	}
+	return fmt.Sprintf("COPY --from=%q: no stage named %q and not a valid image reference, named stages: %s", e.Source, e.Source, strings.Join(e.Stages, ", "))
+}
@@ -0,0 +1,179 @@
+package build
+
+import (
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/docker/docker/pkg/fileutils"
+)
+
+// excludeResources applies the .dockerignore patterns to the resolved resources of an ADD or COPY command.
+//
+// Like with Docker, the patterns are matched against the paths relative to the build context,
+// the directory of the Dockerfile. A directory resource is walked and every contained path
+// is matched so the negations of the excluded directory contents are respected.
+// A directory resource with excluded paths is replaced with the resources of the included files
+// and the included empty directories.
+//
+// The resources without the build context, for example HTTP resources, are matched by the command source.
+func excludeResources(matcher *fileutils.PatternMatcher, originalSource, source string, resolved []resources.ResolvedResource) ([]resources.ResolvedResource, error) {
+	contextDir := ""
+	if originalSource != "" && !isURL(originalSource) {
+		contextDir = filepath.Dir(originalSource)
+	}
+	result := []resources.ResolvedResource{}
+	for _, resource := range resolved {
+		if contextDir == "" || isURL(resource.ResolvedURIOrPath()) {
+			excluded, err := matcher.Matches(source)
+			if err != nil {
+				return nil, err
+			}
+			if !excluded {
+				result = append(result, resource)
+			}
+			continue
+		}
+		relativePath, err := filepath.Rel(contextDir, resource.ResolvedURIOrPath())
+		if err != nil {
+			return nil, err
+		}
+		if !resource.IsDir() {
+			excluded, err := matcher.Matches(relativePath)
+			if err != nil {
+				return nil, err
+			}
+			if !excluded {
+				result = append(result, resource)
+			}
+			continue
+		}
+		included, err := excludeDirectoryResource(matcher, relativePath, resource)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, included...)
+	}
+	return result, nil
+}
+
+// excludeDirectoryResource walks the directory resource and returns the resources not excluded by the patterns.
+// The directory resource is returned as is when none of the contained paths is excluded.
+func excludeDirectoryResource(matcher *fileutils.PatternMatcher, relativePath string, resource resources.ResolvedResource) ([]resources.ResolvedResource, error) {
+	root := resource.ResolvedURIOrPath()
+	hasExclusions := false
+	included := []resources.ResolvedResource{}
+
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		remainingPath := strings.TrimPrefix(strings.TrimPrefix(path, root), string(os.PathSeparator))
+		excluded, err := matcher.Matches(filepath.Join(relativePath, remainingPath))
+		if err != nil {
+			return err
+		}
+		if excluded {
+			hasExclusions = true
+			// without the negations, nothing in an excluded directory is included:
+			if d.IsDir() && !matcher.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sourcePath := filepath.Join(resource.SourcePath(), remainingPath)
+		targetPath := filepath.Join(resource.TargetPath(), remainingPath)
+		if d.IsDir() {
+			// the directories with the files are created with the files:
+			entries, err := ioutil.ReadDir(path)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				included = append(included, resources.NewResolvedDirectoryResourceWithPath(info.Mode().Perm(),
+					path, sourcePath, targetPath, resource.TargetWorkdir(), resource.TargetUser()))
+			}
+			return nil
+		}
+		filePath := path
+		included = append(included, resources.NewResolvedFileResourceWithPath(func() (io.ReadCloser, error) {
+			return os.Open(filePath)
+		}, info.Mode().Perm(), sourcePath, targetPath, resource.TargetWorkdir(), resource.TargetUser(), filePath))
+		return nil
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	if !hasExclusions {
+		return []resources.ResolvedResource{resource}, nil
+	}
+	return included, nil
+}
+
+// excludeInternalPaths removes the files created by firebuild in the build cache directory,
+// for example the rootfs copy or the exported stage resources, from the resolved resources
+// of an ADD or COPY command. These are excluded regardless of the .dockerignore file,
+// a warning is logged when the command would have included them.
+func (b *defaultBuild) excludeInternalPaths(idx int, originalSource, source string, resolved []resources.ResolvedResource) ([]resources.ResolvedResource, error) {
+	if originalSource == "" || isURL(originalSource) {
+		return resolved, nil
+	}
+	patterns := internalPatterns(filepath.Dir(originalSource), b.internalPaths)
+	if len(patterns) == 0 {
+		return resolved, nil
+	}
+	matcher, err := fileutils.NewPatternMatcher(patterns)
+	if err != nil {
+		return nil, err
+	}
+	included, err := excludeResources(matcher, originalSource, source, resolved)
+	if err != nil {
+		return nil, err
+	}
+	if !sameResources(resolved, included) {
+		b.instructionLogger(idx).Warn("source includes firebuild build cache files, these are not copied", "source", source, "excluded", patterns)
+	}
+	return included, nil
+}
+
+// internalPatterns returns the patterns of the internal paths contained in the context directory.
+// The paths outside of the context directory can't be copied so they are skipped.
+func internalPatterns(contextDir string, internalPaths []string) []string {
+	patterns := []string{}
+	for _, internalPath := range internalPaths {
+		absInternalPath, err := filepath.Abs(internalPath)
+		if err != nil {
+			continue
+		}
+		relativePath, err := filepath.Rel(contextDir, absInternalPath)
+		if err != nil || relativePath == "." || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(os.PathSeparator)) {
+			continue
+		}
+		patterns = append(patterns, relativePath)
+	}
+	return patterns
+}
+
+func sameResources(a, b []resources.ResolvedResource) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func isURL(input string) bool {
+	return strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://")
+}
@@ -0,0 +1,133 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExcludeResourcesNegation(t *testing.T) {
+	tempDir := mustExcludesContext(t, "a.log", "keep.log", "main.go", "logs/b.log")
+	defer os.RemoveAll(tempDir)
+
+	targets := mustExcludedTargets(t, tempDir, []string{"*.log", "!keep.log"}, ".", "/app/")
+	assert.Equal(t, []string{"/app/Dockerfile", "/app/keep.log", "/app/logs/b.log", "/app/main.go"}, targets)
+
+	// a file source is matched by its path:
+	targets = mustExcludedTargets(t, tempDir, []string{"*.log", "!keep.log"}, "a.log", "/app/a.log")
+	assert.Equal(t, []string{}, targets)
+	targets = mustExcludedTargets(t, tempDir, []string{"*.log", "!keep.log"}, "keep.log", "/app/keep.log")
+	assert.Equal(t, []string{"/app/keep.log"}, targets)
+}
+
+func TestExcludeResourcesNestedDirectories(t *testing.T) {
+	tempDir := mustExcludesContext(t, "src/main.go", "src/main.go.bak", "src/tmp/cache", "src/tmp/keep", "src/lib/util.go")
+	defer os.RemoveAll(tempDir)
+	if err := os.MkdirAll(filepath.Join(tempDir, "src", "empty"), 0755); err != nil {
+		t.Fatal("expected empty directory to be created, got error", err)
+	}
+
+	targets := mustExcludedTargets(t, tempDir, []string{"src/tmp", "**/*.bak"}, "src", "/app")
+	assert.Equal(t, []string{"/app/empty", "/app/lib/util.go", "/app/main.go"}, targets)
+
+	// a negation includes a path of an excluded directory:
+	targets = mustExcludedTargets(t, tempDir, []string{"src/tmp", "!src/tmp/keep"}, "src", "/app")
+	assert.Equal(t, []string{"/app/empty", "/app/lib/util.go", "/app/main.go", "/app/main.go.bak", "/app/tmp/keep"}, targets)
+
+	// the directory is kept as is without the excluded paths:
+	targets = mustExcludedTargets(t, tempDir, []string{"other"}, "src", "/app")
+	assert.Equal(t, []string{"/app"}, targets)
+
+	// the excluded directory is skipped:
+	targets = mustExcludedTargets(t, tempDir, []string{"src"}, "src", "/app")
+	assert.Equal(t, []string{}, targets)
+}
+
+func TestCreateContextExcludesInternalPaths(t *testing.T) {
+	tempDir := mustExcludesContext(t, "main.go", "rootfs", "stage-export/bin/app", "sources/repo/file")
+	defer os.RemoveAll(tempDir)
+
+	logOutput := bytes.NewBuffer([]byte{})
+	b := NewDefaultBuild().
+		WithLogger(hclog.New(&hclog.LoggerOptions{Output: logOutput})).
+		WithInternalPaths(filepath.Join(tempDir, "rootfs"), filepath.Join(tempDir, "stage-export"), filepath.Join(tempDir, "sources"),
+			filepath.Join(tempDir, ".."))
+	assert.Nil(t, b.AddInstructions(commands.From{BaseImage: "alpine:3.13"},
+		commands.Copy{
+			OriginalCommand: "COPY . /app/",
+			OriginalSource:  filepath.Join(tempDir, "Dockerfile"),
+			Source:          ".",
+			Target:          "/app/",
+		},
+		commands.Copy{
+			OriginalCommand: "COPY main.go /main.go",
+			OriginalSource:  filepath.Join(tempDir, "Dockerfile"),
+			Source:          "main.go",
+			Target:          "/main.go",
+		}))
+
+	ctx, err := b.CreateContext(nil)
+	assert.Nil(t, err)
+
+	targets := []string{}
+	for _, resource := range ctx.ResourcesResolved["."] {
+		targets = append(targets, resource.TargetPath())
+	}
+	sort.Strings(targets)
+	assert.Equal(t, []string{"/app/Dockerfile", "/app/main.go"}, targets)
+	assert.Equal(t, 1, len(ctx.ResourcesResolved["main.go"]))
+	assert.Equal(t, 1, strings.Count(logOutput.String(), "build cache files"))
+}
+
+func mustExcludesContext(t *testing.T, files ...string) string {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	for _, file := range append([]string{"Dockerfile"}, files...) {
+		fullPath := filepath.Join(tempDir, file)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal("expected directory to be created, got error", err)
+		}
+		if err := ioutil.WriteFile(fullPath, []byte(file), 0644); err != nil {
+			t.Fatal("expected file to be written, got error", err)
+		}
+	}
+	return tempDir
+}
+
+func mustExcludedTargets(t *testing.T, contextDir string, patterns []string, source, target string) []string {
+	matcher, err := fileutils.NewPatternMatcher(patterns)
+	if err != nil {
+		t.Fatal("expected pattern matcher to be created, got error", err)
+	}
+	copy := commands.Copy{
+		OriginalSource: filepath.Join(contextDir, "Dockerfile"),
+		Source:         source,
+		Target:         target,
+	}
+	resolved, err := resources.NewDefaultResolver().ResolveCopy(copy)
+	if err != nil {
+		t.Fatal("expected resource to be resolved, got error", err)
+	}
+	included, err := excludeResources(matcher, copy.OriginalSource, copy.Source, resolved)
+	if err != nil {
+		t.Fatal("expected excludes to be applied, got error", err)
+	}
+	targets := []string{}
+	for _, resource := range included {
+		targets = append(targets, resource.TargetPath())
+	}
+	sort.Strings(targets)
+	return targets
+}
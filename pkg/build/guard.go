@@ -0,0 +1,117 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/hashicorp/go-hclog"
+)
+
+// guardResources checks the local resources of an ADD or COPY command before they are uploaded.
+//
+// A file larger than the maximum size fails the command, a maximum size of 0 means no limit.
+// Files which are not regular files, sockets, FIFOs and devices, are skipped with a warning,
+// reading them could block the upload forever. A directory resource with such files
+// is replaced with the resources of the regular files and the empty directories.
+//
+// The HTTP resources and the resources without a local path are not checked.
+func guardResources(logger hclog.Logger, maxSize int64, resolved []resources.ResolvedResource) ([]resources.ResolvedResource, error) {
+	result := []resources.ResolvedResource{}
+	for _, resource := range resolved {
+		localPath := resource.ResolvedURIOrPath()
+		if localPath == "" || isURL(localPath) {
+			result = append(result, resource)
+			continue
+		}
+		if resource.IsDir() {
+			guarded, err := guardDirectoryResource(logger, maxSize, resource)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, guarded...)
+			continue
+		}
+		statResult, err := os.Stat(localPath)
+		if err != nil {
+			return nil, err
+		}
+		if !statResult.Mode().IsRegular() {
+			logger.Warn("skipping resource, not a regular file", "path", localPath, "mode", statResult.Mode().String())
+			continue
+		}
+		if err := checkResourceSize(maxSize, localPath, statResult.Size()); err != nil {
+			return nil, err
+		}
+		result = append(result, resource)
+	}
+	return result, nil
+}
+
+// guardDirectoryResource walks the directory resource and checks every contained file.
+// The directory resource is returned as is when all contained files are regular files.
+func guardDirectoryResource(logger hclog.Logger, maxSize int64, resource resources.ResolvedResource) ([]resources.ResolvedResource, error) {
+	root := resource.ResolvedURIOrPath()
+	hasSkipped := false
+	included := []resources.ResolvedResource{}
+
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		remainingPath := strings.TrimPrefix(strings.TrimPrefix(path, root), string(os.PathSeparator))
+		sourcePath := filepath.Join(resource.SourcePath(), remainingPath)
+		targetPath := filepath.Join(resource.TargetPath(), remainingPath)
+		if d.IsDir() {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				included = append(included, resources.NewResolvedDirectoryResourceWithPath(info.Mode().Perm(),
+					path, sourcePath, targetPath, resource.TargetWorkdir(), resource.TargetUser()))
+			}
+			return nil
+		}
+		// the symbolic links are followed by the upload:
+		statResult, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !statResult.Mode().IsRegular() {
+			logger.Warn("skipping resource, not a regular file", "path", path, "mode", statResult.Mode().String())
+			hasSkipped = true
+			return nil
+		}
+		if err := checkResourceSize(maxSize, path, statResult.Size()); err != nil {
+			return err
+		}
+		filePath := path
+		included = append(included, resources.NewResolvedFileResourceWithPath(func() (io.ReadCloser, error) {
+			return os.Open(filePath)
+		}, statResult.Mode().Perm(), sourcePath, targetPath, resource.TargetWorkdir(), resource.TargetUser(), filePath))
+		return nil
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	if !hasSkipped {
+		return []resources.ResolvedResource{resource}, nil
+	}
+	return included, nil
+}
+
+func checkResourceSize(maxSize int64, path string, size int64) error {
+	if maxSize > 0 && size > maxSize {
+		return fmt.Errorf("resource '%s' size %d bytes exceeds the maximum resource size of %d bytes", path, size, maxSize)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuardResourcesOversized(t *testing.T) {
+	tempDir := mustExcludesContext(t, "small.txt", "dir/large.txt")
+	defer os.RemoveAll(tempDir)
+
+	// the context files contain their names:
+	_, err := mustGuardedTargets(t, tempDir, int64(len("dir/large.txt")-1), "dir", "/app")
+	assert.NotNil(t, err)
+	_, err = mustGuardedTargets(t, tempDir, int64(len("dir/large.txt")-1), "dir/large.txt", "/app/large.txt")
+	assert.NotNil(t, err)
+
+	targets, err := mustGuardedTargets(t, tempDir, int64(len("dir/large.txt")), "dir", "/app")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/app"}, targets)
+
+	// no limit:
+	targets, err = mustGuardedTargets(t, tempDir, 0, "dir/large.txt", "/app/large.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/app/large.txt"}, targets)
+}
+
+func TestGuardResourcesSkipsSpecialFiles(t *testing.T) {
+	tempDir := mustExcludesContext(t, "dir/file.txt", "dir/nested/other.txt")
+	defer os.RemoveAll(tempDir)
+	if err := syscall.Mkfifo(filepath.Join(tempDir, "dir", "nested", "fifo"), 0644); err != nil {
+		t.Fatal("expected FIFO to be created, got error", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tempDir, "dir", "empty"), 0755); err != nil {
+		t.Fatal("expected empty directory to be created, got error", err)
+	}
+
+	targets, err := mustGuardedTargets(t, tempDir, 0, "dir", "/app")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/app/empty", "/app/file.txt", "/app/nested/other.txt"}, targets)
+
+	targets, err = mustGuardedTargets(t, tempDir, 0, "dir/nested/fifo", "/app/fifo")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, targets)
+}
+
+func TestCreateContextMaxResourceSize(t *testing.T) {
+	tempDir := mustExcludesContext(t, "large.txt")
+	defer os.RemoveAll(tempDir)
+
+	newBuild := func(maxSize int64) Build {
+		b := NewDefaultBuild().WithMaxResourceSize(maxSize)
+		assert.Nil(t, b.AddInstructions(commands.From{BaseImage: "alpine:3.13"}, commands.Copy{
+			OriginalCommand: "COPY large.txt /large.txt",
+			OriginalSource:  filepath.Join(tempDir, "Dockerfile"),
+			Source:          "large.txt",
+			Target:          "/large.txt",
+		}))
+		return b
+	}
+
+	_, err := newBuild(1).CreateContext(nil)
+	assert.NotNil(t, err)
+
+	ctx, err := newBuild(0).CreateContext(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ctx.ResourcesResolved["large.txt"]))
+}
+
+func mustGuardedTargets(t *testing.T, contextDir string, maxSize int64, source, target string) ([]string, error) {
+	copy := commands.Copy{
+		OriginalSource: filepath.Join(contextDir, "Dockerfile"),
+		Source:         source,
+		Target:         target,
+	}
+	resolved, err := resources.NewDefaultResolver().ResolveCopy(copy)
+	if err != nil {
+		t.Fatal("expected resources to be resolved, got error", err)
+	}
+	guarded, err := guardResources(hclog.NewNullLogger(), maxSize, resolved)
+	if err != nil {
+		return nil, err
+	}
+	targets := []string{}
+	for _, resource := range guarded {
+		targets = append(targets, resource.TargetPath())
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
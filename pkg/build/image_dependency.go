@@ -0,0 +1,159 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/containers"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/hashicorp/go-hclog"
+)
+
+var imageExportsRootUnsafe = regexp.MustCompile("[^a-zA-Z0-9_.-]+")
+
+// ImageExportsRoot returns the directory the resources copied from an external image are exported to.
+func ImageExportsRoot(tempDir, imageRef string) string {
+	return filepath.Join(tempDir, fmt.Sprintf("image-%s-export", imageExportsRootUnsafe.ReplaceAllString(imageRef, "-")))
+}
+
+// ExportImageResources exports the resources of the COPY --from commands referencing
+// an external image instead of a build stage. The image is pulled only when it does
+// not exist in the local Docker image store and it is kept after the export
+// so the next build copying from the same image does not pull it again.
+func ExportImageResources(ctx context.Context, logger hclog.Logger,
+	dockerConfig *configs.DockerConfig, auths *containers.RegistryAuths, retryConfig utils.RetryConfig,
+	tempDir, imageRef string, externalCopies []commands.Copy) ([]resources.ResolvedResource, error) {
+
+	emptyResponse := []resources.ResolvedResource{}
+
+	opCopies := []*containers.ImageResourceExportCommand{}
+	for _, externalCopy := range externalCopies {
+		if externalCopy.Stage != imageRef {
+			continue
+		}
+		resourceExport, err := containers.ImageResourceExportFromCommand(externalCopy)
+		if err != nil {
+			return emptyResponse, err
+		}
+		opCopies = append(opCopies, resourceExport)
+	}
+	if len(opCopies) == 0 {
+		return emptyResponse, nil // shortcircuit, nothing to look up
+	}
+
+	client, clientErr := containers.GetDefaultClientWithTimeout(dockerConfig.ClientTimeout)
+	if clientErr != nil {
+		return emptyResponse, fmt.Errorf("error fetching Docker client: %+v", clientErr)
+	}
+
+	lookupCtx, lookupCtxCancelFunc := containers.NewOperationContext(ctx, containers.OperationInspect, dockerConfig.InspectTimeout)
+	exists, existsErr := containers.ImageExists(lookupCtx, client, imageRef)
+	lookupCtxCancelFunc()
+	if existsErr != nil {
+		return emptyResponse, fmt.Errorf("Failed looking up image %q: %+v", imageRef, existsErr)
+	}
+
+	if exists {
+		logger.Debug("image found in the local Docker image store, not pulling")
+	} else {
+		logger.Info("pulling image")
+		pullCtx, pullCtxCancelFunc := containers.NewOperationContext(ctx, containers.OperationPull, dockerConfig.PullTimeout)
+		pullErr := containers.ImagePullWithRetry(pullCtx, client, logger, imageRef, dockerConfig.Platform, auths, retryConfig)
+		pullCtxCancelFunc()
+		if pullErr != nil {
+			return emptyResponse, fmt.Errorf("Failed pulling image %q: %+v", imageRef, pullErr)
+		}
+	}
+
+	exportsRoot := ImageExportsRoot(tempDir, imageRef)
+	if err := os.MkdirAll(exportsRoot, fs.ModePerm); err != nil {
+		return emptyResponse, fmt.Errorf("Failed creating exports root directory: %+v", err)
+	}
+
+	exportCtx, exportCtxCancelFunc := containers.NewOperationContext(ctx, containers.OperationSave, dockerConfig.SaveTimeout)
+	defer exportCtxCancelFunc()
+
+	resolvedResources, exportErr := containers.ImageExportResourcesByReference(exportCtx, client, logger, exportsRoot, opCopies, imageRef)
+	if exportErr != nil {
+		return emptyResponse, fmt.Errorf("Failed exporting prefixes from image %q: %+v", imageRef, exportErr)
+	}
+	return resolvedResources, nil
+}
+
+type imageDependencyBuild struct {
+	auths        *containers.RegistryAuths
+	ctx          context.Context
+	dockerConfig *configs.DockerConfig
+	imageRef     string
+	logger       hclog.Logger
+	retryConfig  utils.RetryConfig
+	tempDir      string
+}
+
+// NewImageDependencyBuild creates a dependency builder for an external image referenced by COPY --from.
+// Nothing is built, the build exports the copied resources with ExportImageResources
+// so the image exports are scheduled and bounded together with the stage builds.
+func NewImageDependencyBuild(imageRef, tempDir string, auths *containers.RegistryAuths, retryConfig utils.RetryConfig) DependencyBuild {
+	return &imageDependencyBuild{
+		auths:        auths,
+		ctx:          context.Background(),
+		dockerConfig: configs.NewDockerConfig(),
+		imageRef:     imageRef,
+		logger:       hclog.Default(),
+		retryConfig:  retryConfig,
+		tempDir:      tempDir,
+	}
+}
+
+func (idb *imageDependencyBuild) Build(externalCopies []commands.Copy) ([]resources.ResolvedResource, error) {
+	return ExportImageResources(idb.ctx, idb.logger, idb.dockerConfig, idb.auths, idb.retryConfig,
+		idb.tempDir, idb.imageRef, externalCopies)
+}
+
+// WithBuildID is a noop, the external image is not built.
+func (idb *imageDependencyBuild) WithBuildID(string) DependencyBuild {
+	return idb
+}
+
+// WithContext sets the parent context of the image pull and export operations.
+func (idb *imageDependencyBuild) WithContext(input context.Context) DependencyBuild {
+	idb.ctx = input
+	return idb
+}
+
+// WithDockerConfig sets the Docker client, operation timeouts and the pull platform.
+func (idb *imageDependencyBuild) WithDockerConfig(input *configs.DockerConfig) DependencyBuild {
+	idb.dockerConfig = input
+	return idb
+}
+
+// WithEscapeToken is a noop, the external image is not built.
+func (idb *imageDependencyBuild) WithEscapeToken(rune) DependencyBuild {
+	return idb
+}
+
+// WithKeepContainers is a noop, the external image is not built.
+func (idb *imageDependencyBuild) WithKeepContainers(bool) DependencyBuild {
+	return idb
+}
+
+func (idb *imageDependencyBuild) WithLogger(input hclog.Logger) DependencyBuild {
+	idb.logger = input
+	return idb
+}
+
+// WithStageCacheDirectory is a noop, the pulled external image is always kept.
+func (idb *imageDependencyBuild) WithStageCacheDirectory(string) DependencyBuild {
+	return idb
+}
+
+func (idb *imageDependencyBuild) getDependencyDockerfileContent() []string {
+	return []string{}
+}
@@ -0,0 +1,97 @@
+package build
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/containers"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestExportImageResources(t *testing.T) {
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level: hclog.Debug,
+	})
+
+	client, err := containers.GetDefaultClient()
+	if err != nil {
+		t.Skip("Docker client not available", err)
+	}
+	if _, err := client.Ping(context.Background()); err != nil {
+		t.Skip("Docker daemon not available", err)
+	}
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("Expected temp directory but received an error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	copyCommands := []commands.Copy{
+		{
+			OriginalCommand: "COPY --from=alpine:3.13 /etc/alpine-release /etc/alpine-release",
+			Stage:           "alpine:3.13",
+			Source:          "/etc/alpine-release",
+			Target:          "/etc",
+			Workdir:         commands.DefaultWorkdir(),
+			User:            commands.DefaultUser(),
+		},
+		{
+			OriginalCommand: "COPY --from=builder /go/bin /usr/bin",
+			Stage:           "builder",
+			Source:          "/go/bin",
+			Target:          "/usr/bin",
+			Workdir:         commands.DefaultWorkdir(),
+			User:            commands.DefaultUser(),
+		},
+	}
+
+	resolvedResources, exportErr := ExportImageResources(context.Background(), logger,
+		configs.NewDockerConfig(), nil, utils.RetryConfig{Attempts: 1},
+		tempDir, "alpine:3.13", copyCommands)
+	if exportErr != nil {
+		t.Fatal("Image resources export failed", exportErr)
+	}
+
+	if len(resolvedResources) != 1 {
+		t.Fatal("Expected exactly one resolved resource, got", resolvedResources)
+	}
+	resource := resolvedResources[0]
+	if resource.TargetPath() != "/etc/alpine-release" {
+		t.Fatal("Expected the resource target to be /etc/alpine-release, got", resource.TargetPath())
+	}
+	if !strings.HasPrefix(resource.ResolvedURIOrPath(), ImageExportsRoot(tempDir, "alpine:3.13")) {
+		t.Fatal("Expected the resource to be exported to the image exports root, got", resource.ResolvedURIOrPath())
+	}
+	content, readErr := ioutil.ReadFile(resource.ResolvedURIOrPath())
+	if readErr != nil {
+		t.Fatal("Expected the exported resource to be readable", readErr)
+	}
+	if !strings.HasPrefix(string(content), "3.13") {
+		t.Fatal("Expected the alpine release file, got", string(content))
+	}
+
+	// the pulled image is kept, the next export does not pull it again:
+	exists, existsErr := containers.ImageExists(context.Background(), client, "alpine:3.13")
+	if existsErr != nil || !exists {
+		t.Fatal("Expected the pulled image to be kept", existsErr)
+	}
+}
+
+func TestImageExportsRoot(t *testing.T) {
+	exportsRoot := ImageExportsRoot("/tmp/build", "registry.example.com:5000/library/nginx:1.21")
+	if filepath.Dir(exportsRoot) != "/tmp/build" {
+		t.Fatal("Expected the exports root in the build directory, got", exportsRoot)
+	}
+	if filepath.Base(exportsRoot) != "image-registry.example.com-5000-library-nginx-1.21-export" {
+		t.Fatal("Expected a flat exports root directory name, got", filepath.Base(exportsRoot))
+	}
+}
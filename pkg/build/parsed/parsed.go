@@ -0,0 +1,105 @@
+package parsed
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild/pkg/build/reader"
+	"github.com/combust-labs/firebuild/pkg/build/stage"
+)
+
+// ParsedDockerfile is the JSON representation of a parsed Dockerfile.
+// The schema is consumed by external tooling, fields must not be renamed or removed.
+type ParsedDockerfile struct {
+	// Source is the Dockerfile source as given to the reader.
+	Source string `json:"Source"`
+	// Commands contains all parsed commands in the order of appearance.
+	Commands []ParsedCommand `json:"Commands"`
+	// Stages contains the build stages in the order of appearance.
+	Stages []ParsedStage `json:"Stages"`
+	// ExcludePatterns contains the .dockerignore patterns, if any.
+	ExcludePatterns []string `json:"ExcludePatterns"`
+}
+
+// ParsedCommand is the JSON representation of a single parsed command.
+type ParsedCommand struct {
+	// Type is the Dockerfile instruction, for example RUN.
+	Type string `json:"Type"`
+	// Line is the Dockerfile line the command starts at.
+	Line int `json:"Line"`
+	// Original is the original command the command was parsed from.
+	Original string `json:"Original"`
+	// Fields contains the command specific fields.
+	Fields interface{} `json:"Fields"`
+}
+
+// ParsedStage is the JSON representation of a build stage.
+type ParsedStage struct {
+	// Name is the stage name, empty for the unnamed stage.
+	Name string `json:"Name"`
+	// DependsOn lists the names of the stages this stage copies resources from.
+	DependsOn []string `json:"DependsOn"`
+	// Commands contains the commands of the stage.
+	Commands []ParsedCommand `json:"Commands"`
+}
+
+// NewParsedDockerfile converts the read result to the JSON representation.
+// Returns the stage read errors, if any.
+func NewParsedDockerfile(source string, readResult reader.ReadResult) (*ParsedDockerfile, []error) {
+	result := &ParsedDockerfile{
+		Source:          source,
+		Commands:        []ParsedCommand{},
+		Stages:          []ParsedStage{},
+		ExcludePatterns: readResult.ExcludePatterns(),
+	}
+
+	lines := readResult.CommandLines()
+	for idx, cmd := range readResult.Commands() {
+		parsed := newParsedCommand(cmd)
+		if idx < len(lines) {
+			parsed.Line = lines[idx]
+		}
+		result.Commands = append(result.Commands, parsed)
+	}
+
+	stages, errs := stage.ReadStages(readResult.Commands())
+	// stages contain copies of the commands so the lines are located
+	// by walking the top level commands in order:
+	locations := readResult.SourceLocations()
+	for _, st := range stages.All() {
+		parsedStage := ParsedStage{
+			Name:      st.Name(),
+			DependsOn: st.DependsOn(),
+			Commands:  []ParsedCommand{},
+		}
+		for _, cmd := range st.Commands() {
+			parsed := newParsedCommand(cmd)
+			parsed.Line = locations.Locate(cmd).Line
+			parsedStage.Commands = append(parsedStage.Commands, parsed)
+		}
+		result.Stages = append(result.Stages, parsedStage)
+	}
+
+	return result, errs
+}
+
+func newParsedCommand(cmd interface{}) ParsedCommand {
+	parsed := ParsedCommand{
+		Type:   strings.ToUpper(reflect.TypeOf(cmd).Name()),
+		Fields: cmd,
+	}
+	if serializable, ok := cmd.(commands.DockerfileSerializable); ok {
+		parsed.Original = serializable.GetOriginal()
+	}
+	if arg, ok := cmd.(commands.Arg); ok {
+		// the ARG key and value are not exported:
+		value, hasValue := arg.Value()
+		fields := map[string]interface{}{"Key": arg.Key()}
+		if hasValue {
+			fields["Value"] = value
+		}
+		parsed.Fields = fields
+	}
+	return parsed
+}
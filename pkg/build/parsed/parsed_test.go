@@ -0,0 +1,49 @@
+package parsed
+
+import (
+	"testing"
+
+	"github.com/combust-labs/firebuild/pkg/build/reader"
+)
+
+func TestParsedDockerfileLines(t *testing.T) {
+	readResult, err := reader.ReadFromString(dockerfileMultiStage, "")
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	parsed, errs := NewParsedDockerfile("literal", readResult)
+	if len(errs) > 0 {
+		t.Fatal("Expected no stage errors, got", errs)
+	}
+	expected := []struct {
+		t    string
+		line int
+	}{
+		{"ARG", 1}, {"FROM", 2}, {"RUN", 3}, {"FROM", 5}, {"COPY", 6}, {"ENV", 7}, {"ENV", 7},
+	}
+	if len(parsed.Commands) != len(expected) {
+		t.Fatalf("Expected %d commands, got %d", len(expected), len(parsed.Commands))
+	}
+	for idx, exp := range expected {
+		if parsed.Commands[idx].Type != exp.t || parsed.Commands[idx].Line != exp.line {
+			t.Fatalf("Expected %s at line %d, got %s at line %d", exp.t, exp.line, parsed.Commands[idx].Type, parsed.Commands[idx].Line)
+		}
+	}
+	if len(parsed.Stages) != 2 {
+		t.Fatalf("Expected 2 stages, got %d", len(parsed.Stages))
+	}
+	if parsed.Stages[1].Commands[0].Type != "ARG" || parsed.Stages[1].Commands[0].Line != 1 {
+		t.Fatal("Expected the ARG before the first FROM to be added to the second stage with its original line")
+	}
+	if parsed.Stages[1].Commands[2].Type != "COPY" || parsed.Stages[1].Commands[2].Line != 6 {
+		t.Fatal("Expected the COPY of the second stage at line 6")
+	}
+}
+
+var dockerfileMultiStage = `ARG VERSION=1
+FROM alpine:3.13 as builder
+RUN echo build
+
+FROM alpine:3.13
+COPY --from=builder /etc/hosts /etc/hosts
+ENV A=1 B=2`
@@ -0,0 +1,274 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild/pkg/utils"
+)
+
+// heredocInstructionRegexp matches the instructions supporting the here-documents.
+var heredocInstructionRegexp = regexp.MustCompile(`(?i)^\s*(run|copy)\s`)
+
+// heredocCopyRegexp matches the COPY instruction, the COPY sources are not passed to a shell
+// so a << in the COPY command is always a here-document marker.
+var heredocCopyRegexp = regexp.MustCompile(`(?i)^\s*copy\s`)
+
+// heredocMarkerRegexp matches a here-document marker: <<EOF, <<-EOF, <<"EOF" or <<'EOF'.
+var heredocMarkerRegexp = regexp.MustCompile(`^<<(-?)(["']?)([a-zA-Z_][a-zA-Z0-9_.-]*)(["']?)`)
+
+// escapeDirectiveRegexp matches the escape parser directive.
+var escapeDirectiveRegexp = regexp.MustCompile(`^#\s*(?i:escape)\s*=\s*(\S)\s*$`)
+
+// heredoc is a here-document of a RUN or COPY command.
+type heredoc struct {
+	// marker is the marker as given in the command, for example <<-EOF.
+	marker string
+	name   string
+	// chomp is set for <<-, the leading tabs are removed from the content.
+	chomp bool
+	// lines are the raw content lines, without the terminating line.
+	lines []string
+}
+
+// content returns the here-document content.
+func (h heredoc) content() string {
+	lines := []string{}
+	for _, line := range h.lines {
+		if h.chomp {
+			line = strings.TrimLeft(line, "\t")
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// raw returns the here-document as written in the Dockerfile, with the terminating line.
+func (h heredoc) raw() string {
+	return strings.Join(append(append([]string{}, h.lines...), h.name), "\n")
+}
+
+// extractHeredocs blanks the here-document lines of the RUN and COPY commands.
+// The Dockerfile parser does not support the here-documents, with the content
+// removed, it reads the command line only. The content lines are replaced with
+// empty lines so the line numbers of the commands do not change.
+// A RUN command with markers not followed by their terminators is left as is,
+// unless the command starts with the marker; an unterminated COPY here-document fails.
+// Returns the here-documents by the start line of the command.
+func extractHeredocs(input []byte) ([]byte, map[int][]heredoc, error) {
+	found := map[int][]heredoc{}
+	escapeToken := "\\"
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(input)+1)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	output := []string{}
+	inDirectives := true
+	continued := false
+	startLine := 0
+	commandLines := []string{}
+
+	for idx := 0; idx < len(lines); idx++ {
+		line := lines[idx]
+		lineNumber := idx + 1
+
+		trimmed := strings.TrimSpace(line)
+		if inDirectives {
+			if match := escapeDirectiveRegexp.FindStringSubmatch(trimmed); match != nil {
+				escapeToken = match[1]
+			} else if !strings.HasPrefix(trimmed, "#") || trimmed == "#" {
+				inDirectives = false
+			}
+		}
+
+		if strings.HasPrefix(trimmed, "#") {
+			// comments do not change the line continuation:
+			output = append(output, line)
+			continue
+		}
+		if !continued {
+			startLine = lineNumber
+			commandLines = []string{}
+		}
+		commandLines = append(commandLines, line)
+		continued = strings.HasSuffix(trimmed, escapeToken)
+		output = append(output, line)
+		if continued || !heredocInstructionRegexp.MatchString(firstLine(output, startLine, line)) {
+			continue
+		}
+
+		command := strings.Join(commandLines, "\n")
+		markers := findHeredocMarkers(command)
+		if len(markers) == 0 {
+			continue
+		}
+		docs, next, terminated := readHeredocs(lines, idx+1, markers)
+		if !terminated {
+			if heredocCopyRegexp.MatchString(command) ||
+				heredocInstructionRegexp.ReplaceAllString(strings.TrimSpace(command), "") == markers[0].marker {
+				return nil, nil, fmt.Errorf("line %d: here-document %q not terminated", startLine, markers[0].name)
+			}
+			// not a here-document, for example an unquoted << in a command:
+			continue
+		}
+		found[startLine] = docs
+		for ; idx+1 < next; idx++ {
+			output = append(output, "")
+		}
+	}
+	return []byte(strings.Join(output, "\n")), found, nil
+}
+
+// readHeredocs reads the content of the here-documents starting at the line index from,
+// the here-documents follow each other. Returns the here-documents, the index of the line
+// after the last terminator and false if a here-document is not terminated.
+func readHeredocs(lines []string, from int, markers []heredoc) ([]heredoc, int, bool) {
+	docs := []heredoc{}
+	idx := from
+	for _, marker := range markers {
+		current := marker
+		terminated := false
+		for ; idx < len(lines); idx++ {
+			terminator := lines[idx]
+			if current.chomp {
+				terminator = strings.TrimLeft(terminator, "\t")
+			}
+			if terminator == current.name {
+				terminated = true
+				idx++
+				break
+			}
+			current.lines = append(current.lines, lines[idx])
+		}
+		if !terminated {
+			return nil, idx, false
+		}
+		docs = append(docs, current)
+	}
+	return docs, idx, true
+}
+
+// findHeredocMarkers returns the here-document markers of a command, in the order of appearance.
+// The << in the quoted strings, in the arithmetic expressions and the <<< here-strings are not markers.
+func findHeredocMarkers(command string) []heredoc {
+	markers := []heredoc{}
+	inSingleQuotes := false
+	inDoubleQuotes := false
+	arithmetic := 0
+	for idx := 0; idx < len(command); idx++ {
+		char := command[idx]
+		if inSingleQuotes {
+			inSingleQuotes = char != '\''
+			continue
+		}
+		if char == '\\' {
+			idx++
+			continue
+		}
+		if inDoubleQuotes {
+			inDoubleQuotes = char != '"'
+			continue
+		}
+		remaining := command[idx:]
+		switch {
+		case char == '\'':
+			inSingleQuotes = true
+		case char == '"':
+			inDoubleQuotes = true
+		case strings.HasPrefix(remaining, "(("):
+			// $((...)) and ((...)):
+			arithmetic++
+			idx++
+		case arithmetic > 0 && strings.HasPrefix(remaining, "))"):
+			arithmetic--
+			idx++
+		case arithmetic == 0 && strings.HasPrefix(remaining, "<<<"):
+			idx += 2
+		case arithmetic == 0 && strings.HasPrefix(remaining, "<<"):
+			match := heredocMarkerRegexp.FindStringSubmatch(remaining)
+			if match == nil || match[2] != match[4] {
+				idx++
+				continue
+			}
+			markers = append(markers, heredoc{marker: match[0], name: match[3], chomp: match[1] == "-"})
+			idx += len(match[0]) - 1
+		}
+	}
+	return markers
+}
+
+// firstLine returns the line the command starts at.
+func firstLine(output []string, startLine int, current string) string {
+	if startLine-1 < len(output) {
+		return output[startLine-1]
+	}
+	return current
+}
+
+// heredocRun returns the RUN command with the here-documents.
+// A command consisting of a single here-document only runs the content as a script,
+// otherwise the here-documents are passed to the shell with the command.
+func heredocRun(command, original string, heredocs []heredoc) commands.Run {
+	originalCommand := original
+	for _, doc := range heredocs {
+		originalCommand = originalCommand + "\n" + doc.raw()
+	}
+	if len(heredocs) == 1 && strings.TrimSpace(command) == heredocs[0].marker {
+		return commands.Run{OriginalCommand: originalCommand, Command: heredocs[0].content()}
+	}
+	for _, doc := range heredocs {
+		command = command + "\n" + doc.raw()
+	}
+	return commands.Run{OriginalCommand: originalCommand, Command: command}
+}
+
+// heredocCopy returns a RUN command writing the here-documents of a COPY command
+// to the target. The here-document name is the file name when the target is a directory.
+// The guest distinguishes the commands by the original command so the original command
+// is the RUN command, written in a single line so the stages built with Docker can use it.
+func heredocCopy(sources []string, target string, chown *commands.User, heredocs []heredoc) (commands.Run, error) {
+	if len(sources) != len(heredocs) {
+		return commands.Run{}, fmt.Errorf("COPY with the here-documents and the files is not supported")
+	}
+	if len(heredocs) > 1 && !strings.HasSuffix(target, "/") {
+		return commands.Run{}, fmt.Errorf("COPY with multiple here-documents requires a directory target ending with /")
+	}
+	scripts := []string{}
+	for idx, doc := range heredocs {
+		if sources[idx] != doc.marker {
+			return commands.Run{}, fmt.Errorf("COPY here-document %q is not a source", doc.name)
+		}
+		targetFile := target
+		if strings.HasSuffix(target, "/") {
+			targetFile = path.Join(target, doc.name)
+		}
+		// the content is the %b argument, not the format, a content starting with - is not an option:
+		script := fmt.Sprintf("mkdir -p %s && printf '%%b' %s > %s",
+			utils.ShellQuote(path.Dir(targetFile)), utils.ShellQuote(printfEscape(doc.content())), utils.ShellQuote(targetFile))
+		if chown != nil {
+			script = fmt.Sprintf("%s && chown %s %s", script, utils.ShellQuote(chown.Value), utils.ShellQuote(targetFile))
+		}
+		scripts = append(scripts, script)
+	}
+	command := strings.Join(scripts, " && ")
+	return commands.Run{OriginalCommand: "RUN " + command, Command: command}, nil
+}
+
+// printfEscape escapes the input for the printf %b argument so the content fits in a single line.
+func printfEscape(input string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\t", "\\t").Replace(input)
+}
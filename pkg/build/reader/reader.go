@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/combust-labs/firebuild-shared/build/commands"
+	bcCommands "github.com/combust-labs/firebuild/pkg/build/commands"
 	bcErrors "github.com/combust-labs/firebuild/pkg/build/errors"
 	"github.com/docker/docker/builder/dockerignore"
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
@@ -22,28 +23,54 @@ import (
 // ReadResult contains the parsed commands and optionally .dockerignore patterns.
 type ReadResult interface {
 	Commands() []interface{}
+	// CommandLines returns the Dockerfile start line of every command, in the order of Commands().
+	CommandLines() []int
+	// ContextDirectory returns the absolute path of the directory the ADD and COPY
+	// resources are resolved from, empty if the Dockerfile is not a local file.
+	ContextDirectory() string
+	// EscapeToken returns the escape character of the Dockerfile,
+	// the backslash unless changed with the escape parser directive.
+	EscapeToken() rune
 	ExcludePatterns() []string
+	// SourceLocations returns new source locations for the commands.
+	SourceLocations() bcCommands.SourceLocations
 }
 
 type defaultReadResult struct {
-	commands        []interface{}
-	excludePatterns []string
+	commands         []interface{}
+	commandLines     []int
+	contextDirectory string
+	escapeToken      rune
+	excludePatterns  []string
+	sourceFile       string
 }
 
-func newDefaultReadResult(commands []interface{}) ReadResult {
-	return &defaultReadResult{commands: commands, excludePatterns: []string{}}
+func newDefaultReadResult(parsed *parsedDockerfile, sourceFile string) ReadResult {
+	return &defaultReadResult{commands: parsed.commands, commandLines: parsed.lines, escapeToken: parsed.escapeToken, excludePatterns: []string{}, sourceFile: sourceFile}
 }
 
-func newDefaultReadResultWithExcludePatterns(commands []interface{}, patterns []string) ReadResult {
-	return &defaultReadResult{commands: commands, excludePatterns: patterns}
+func newDefaultReadResultWithContext(parsed *parsedDockerfile, patterns []string, sourceFile, contextDirectory string) ReadResult {
+	return &defaultReadResult{commands: parsed.commands, commandLines: parsed.lines, contextDirectory: contextDirectory, escapeToken: parsed.escapeToken, excludePatterns: patterns, sourceFile: sourceFile}
 }
 
 func (dr *defaultReadResult) Commands() []interface{} {
 	return dr.commands
 }
+func (dr *defaultReadResult) CommandLines() []int {
+	return dr.commandLines
+}
+func (dr *defaultReadResult) ContextDirectory() string {
+	return dr.contextDirectory
+}
+func (dr *defaultReadResult) EscapeToken() rune {
+	return dr.escapeToken
+}
 func (dr *defaultReadResult) ExcludePatterns() []string {
 	return dr.excludePatterns
 }
+func (dr *defaultReadResult) SourceLocations() bcCommands.SourceLocations {
+	return bcCommands.NewSourceLocations(dr.commands, dr.commandLines, dr.sourceFile)
+}
 
 // ReadFromString reads commands from string.
 //
@@ -52,7 +79,9 @@ func (dr *defaultReadResult) ExcludePatterns() []string {
 // - SPECIAL: git+http:// and git+https:// URL
 //   the format is: git+http(s)://host:port/path/to/repo.git:/path/to/Dockerfile[#<commit-hash | branch-name | tag-name>]
 // - ssh://, git:// or git+ssh:// URL
-// - absolute path to the local file
+// - path to the local file, relative paths are resolved against the current working directory
+//
+// The ADD and COPY resources of a local or git Dockerfile are resolved relative to the directory of the Dockerfile.
 func ReadFromString(input string, tempDirectory string) (ReadResult, error) {
 
 	if strings.HasPrefix(input, "git+http://") ||
@@ -79,7 +108,12 @@ func ReadFromString(input string, tempDirectory string) (ReadResult, error) {
 			repoURL = repoURL[4:]
 		}
 
-		repoDestDir := filepath.Join(tempDirectory, "sources")
+		absTempDirectory, err := filepath.Abs(tempDirectory)
+		if err != nil {
+			return nil, err
+		}
+
+		repoDestDir := filepath.Join(absTempDirectory, "sources")
 		repo, err := git.PlainClone(repoDestDir, false, &git.CloneOptions{
 			URL:      repoURL,
 			Progress: os.Stdout,
@@ -116,29 +150,7 @@ func ReadFromString(input string, tempDirectory string) (ReadResult, error) {
 		}
 
 		// the Dockerfile is basically:
-		filePath := filepath.Join(repoDestDir, pathInRepo)
-		statResult, statErr := os.Stat(filePath)
-		if statErr != nil {
-			return nil, statErr
-		}
-		if statResult.IsDir() {
-			return nil, &bcErrors.ErrorIsDirectory{Input: filePath}
-		}
-		bytes, err := ioutil.ReadFile(filePath)
-		if err != nil && err != io.EOF {
-			return nil, err
-		}
-
-		excludes, excludesErr := readExcludes(filePath)
-		if excludesErr != nil {
-			return nil, excludesErr
-		}
-		commands, commandsErr := ReadFromBytesWithOriginalSource(bytes, filePath)
-		if commandsErr != nil {
-			return nil, commandsErr
-		}
-
-		return newDefaultReadResultWithExcludePatterns(commands, excludes), nil
+		return readFromFile(filepath.Join(repoDestDir, pathInRepo), input)
 	}
 
 	if strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://") {
@@ -151,22 +163,22 @@ func ReadFromString(input string, tempDirectory string) (ReadResult, error) {
 		if err != nil && err != io.EOF {
 			return nil, err
 		}
-		commands, commandsErr := ReadFromBytesWithOriginalSource(bytes, input)
-		if commandsErr != nil {
-			return nil, commandsErr
+		parsed, parseErr := readFromBytes(bytes, input)
+		if parseErr != nil {
+			return nil, parseErr
 		}
-		return newDefaultReadResult(commands), nil
+		return newDefaultReadResult(parsed, input), nil
 	}
 
 	statResult, statErr := os.Stat(input)
 	if statErr != nil {
 		if os.IsNotExist(statErr) {
 			// assume literal input:
-			commands, commandsErr := ReadFromBytes([]byte(input))
-			if commandsErr != nil {
-				return nil, commandsErr
+			parsed, parseErr := readFromBytes([]byte(input), "")
+			if parseErr != nil {
+				return nil, parseErr
 			}
-			return newDefaultReadResult(commands), nil
+			return newDefaultReadResult(parsed, ""), nil
 		}
 		return nil, statErr
 	}
@@ -175,49 +187,109 @@ func ReadFromString(input string, tempDirectory string) (ReadResult, error) {
 		return nil, &bcErrors.ErrorIsDirectory{Input: input}
 	}
 
-	bytes, err := ioutil.ReadFile(input)
+	// resources are resolved relative to the Dockerfile, regardless of the working directory:
+	absInput, err := filepath.Abs(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return readFromFile(absInput, absInput)
+
+}
+
+// readFromFile reads the Dockerfile from an absolute file path.
+func readFromFile(filePath, sourceFile string) (ReadResult, error) {
+	statResult, statErr := os.Stat(filePath)
+	if statErr != nil {
+		return nil, statErr
+	}
+	if statResult.IsDir() {
+		return nil, &bcErrors.ErrorIsDirectory{Input: filePath}
+	}
+	bytes, err := ioutil.ReadFile(filePath)
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
 
-	excludes, excludesErr := readExcludes(input)
+	excludes, excludesErr := readExcludes(filePath)
 	if excludesErr != nil {
 		return nil, excludesErr
 	}
-	commands, commandsErr := ReadFromBytesWithOriginalSource(bytes, input)
-	if commandsErr != nil {
-		return nil, commandsErr
+	parsed, parseErr := readFromBytes(bytes, filePath)
+	if parseErr != nil {
+		return nil, parseErr
 	}
 
-	return newDefaultReadResultWithExcludePatterns(commands, excludes), nil
-
+	return newDefaultReadResultWithContext(parsed, excludes, sourceFile, filepath.Dir(filePath)), nil
 }
 
 // ReadFromBytes reads commands from bytes.
 // The bytes most often will be the Dockerfile string literal converted to bytes.
 func ReadFromBytes(input []byte) ([]interface{}, error) {
-	parserResult, err := parser.Parse(bytes.NewReader(input))
+	parsed, err := readFromBytes(input, "")
 	if err != nil {
 		return nil, err
 	}
-	return ReadFromParserResult(parserResult, "")
+	return parsed.commands, nil
 }
 
 // ReadFromBytesWithOriginalSource reads commands from bytes and passes
 // the original source to the build context.
 // Use this method to automatically resolve the ADD / COPY dependencies.
 func ReadFromBytesWithOriginalSource(input []byte, originalSource string) ([]interface{}, error) {
-	parserResult, err := parser.Parse(bytes.NewReader(input))
+	parsed, err := readFromBytes(input, originalSource)
 	if err != nil {
 		return nil, err
 	}
-	return ReadFromParserResult(parserResult, originalSource)
+	return parsed.commands, nil
 }
 
 // ReadFromParserResult reads commands from the Dockerfile parser result.
+// The parser does not support the here-documents, use ReadFromBytes for a Dockerfile with the here-documents.
 func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([]interface{}, error) {
-	output := []interface{}{}
+	output, _, err := readFromParserResult(parserResult, originalSource, map[int][]heredoc{})
+	return output, err
+}
+
+// parsedDockerfile is the result of reading the Dockerfile bytes.
+type parsedDockerfile struct {
+	commands    []interface{}
+	lines       []int
+	escapeToken rune
+}
+
+// readFromBytes parses the Dockerfile. The parser handles the parser directives:
+// the escape directive changes the line continuation and the escape character,
+// the syntax directive selects the BuildKit frontend and has no effect here.
+// The here-documents of the RUN and COPY commands are extracted before parsing.
+func readFromBytes(input []byte, originalSource string) (*parsedDockerfile, error) {
+	withoutHeredocs, heredocs, err := extractHeredocs(input)
+	if err != nil {
+		return nil, err
+	}
+	parserResult, err := parser.Parse(bytes.NewReader(withoutHeredocs))
+	if err != nil {
+		return nil, err
+	}
+	commands, lines, err := readFromParserResult(parserResult, originalSource, heredocs)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedDockerfile{commands: commands, lines: lines, escapeToken: parserResult.EscapeToken}, nil
+}
+
+func readFromParserResult(parserResult *parser.Result, originalSource string, heredocs map[int][]heredoc) ([]interface{}, []int, error) {
+	output, lines := []interface{}{}, []int{}
+	// every command appended while handling a child originates from the child's start line:
+	currentLine := 0
+	fillLines := func() {
+		for len(lines) < len(output) {
+			lines = append(lines, currentLine)
+		}
+	}
 	for _, child := range parserResult.AST.Children {
+		fillLines()
+		currentLine = child.StartLine
 		switch child.Value {
 		case "add":
 			values := []string{}
@@ -229,21 +301,23 @@ func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([
 				values = append(values, current.Value)
 				current = current.Next
 			}
-			if len(values) == 2 {
-				flags := readFlags(child.Flags)
+			if err := validateCopyTarget(values); err != nil {
+				return output, lines, fmt.Errorf("invalid ADD %q: %d: %v", strings.Join(values, " "), child.StartLine, err)
+			}
+			flags := readFlags(child.Flags)
+			// every source is a separate command with the same target:
+			for _, source := range values[0 : len(values)-1] {
 				add := commands.Add{
 					OriginalCommand: child.Original,
 					OriginalSource:  originalSource,
-					Source:          values[0],
-					Target:          values[1],
+					Source:          source,
+					Target:          values[len(values)-1],
 				}
 				if chownVal, ok := flags.get("--chown"); ok {
 					add.UserFromLocalChown = &commands.User{Value: chownVal}
 				}
 				output = append(output, add)
-				continue
 			}
-			return output, fmt.Errorf("invalid ADD %q: %d", strings.Join(values, " "), child.StartLine)
 		case "arg":
 			current := child.Next
 			for {
@@ -252,7 +326,7 @@ func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([
 				}
 				arg, argErr := commands.NewRawArg(current.Value)
 				if argErr != nil {
-					return output, fmt.Errorf("arg at %d: %+v", child.StartLine, argErr)
+					return output, lines, fmt.Errorf("arg at %d: %+v", child.StartLine, argErr)
 				}
 				arg.OriginalCommand = child.Original
 				output = append(output, arg)
@@ -279,22 +353,36 @@ func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([
 				values = append(values, current.Value)
 				current = current.Next
 			}
-			if len(values) == 2 {
-				flags := readFlags(child.Flags)
+			if childHeredocs, ok := heredocs[child.StartLine]; ok && len(values) > 1 {
+				var chown *commands.User
+				if chownVal, ok := readFlags(child.Flags).get("--chown"); ok {
+					chown = &commands.User{Value: chownVal}
+				}
+				run, err := heredocCopy(values[0:len(values)-1], values[len(values)-1], chown, childHeredocs)
+				if err != nil {
+					return output, lines, fmt.Errorf("invalid COPY at %d: %+v", child.StartLine, err)
+				}
+				output = append(output, run)
+				continue
+			}
+			if err := validateCopyTarget(values); err != nil {
+				return output, lines, fmt.Errorf("invalid COPY %q: %d: %v", strings.Join(values, " "), child.StartLine, err)
+			}
+			flags := readFlags(child.Flags)
+			// every source is a separate command with the same target:
+			for _, source := range values[0 : len(values)-1] {
 				copy := commands.Copy{
 					OriginalCommand: child.Original,
 					OriginalSource:  originalSource,
-					Source:          values[0],
+					Source:          source,
 					Stage:           flags.getOrDefault("--from", ""),
-					Target:          values[1],
+					Target:          values[len(values)-1],
 				}
 				if chownVal, ok := flags.get("--chown"); ok {
 					copy.UserFromLocalChown = &commands.User{Value: chownVal}
 				}
 				output = append(output, copy)
-				continue
 			}
-			return output, fmt.Errorf("invalid COPY %q: %d", strings.Join(values, " "), child.StartLine)
 		case "entrypoint":
 			entrypoint := commands.Entrypoint{Values: []string{}, OriginalCommand: child.Original}
 			current := child.Next
@@ -317,7 +405,7 @@ func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([
 				current = current.Next
 			}
 			if len(extracted)%2 != 0 {
-				return nil, fmt.Errorf("the env at %d is not complete", child.StartLine)
+				return nil, nil, fmt.Errorf("the env at %d is not complete", child.StartLine)
 			}
 			for i := 0; i < len(extracted); i = i + 2 {
 				//name, value := env.put(, )
@@ -333,7 +421,8 @@ func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([
 				if current == nil {
 					break
 				}
-				output = append(output, commands.Expose{RawValue: current.Value, OriginalCommand: child.Original})
+				// the protocol suffix is retained so the ports can be published with the exposed protocol:
+				output = append(output, commands.Expose{RawValue: normalizeExposedPort(current.Value), OriginalCommand: child.Original})
 				current = current.Next
 			}
 		case "from":
@@ -360,7 +449,7 @@ func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([
 					OriginalCommand: child.Original})
 				continue
 			}
-			return output, fmt.Errorf("invalid FROM %q: %d", strings.Join(values, " "), child.StartLine)
+			return output, lines, fmt.Errorf("invalid FROM %q: %d", strings.Join(values, " "), child.StartLine)
 		case "healthcheck":
 			// ignore for now
 			// TODO: these can be for sure used but at a higher level
@@ -375,7 +464,7 @@ func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([
 				current = current.Next
 			}
 			if len(extracted)%2 != 0 {
-				return nil, fmt.Errorf("the label at %d is not complete", child.StartLine)
+				return nil, nil, fmt.Errorf("the label at %d is not complete", child.StartLine)
 			}
 			for i := 0; i < len(extracted); i = i + 2 {
 				output = append(output, commands.Label{
@@ -390,6 +479,10 @@ func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([
 			// ignore for now
 			// TODO: can these be used?
 		case "run":
+			if childHeredocs, ok := heredocs[child.StartLine]; ok && child.Next != nil && !child.Attributes["json"] {
+				output = append(output, heredocRun(child.Next.Value, child.Original, childHeredocs))
+				continue
+			}
 			current := child.Next
 			for {
 				if current == nil {
@@ -413,10 +506,18 @@ func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([
 			}
 			output = append(output, shell)
 		case "stopsignal":
-			// TODO: incorporate because the OS service manager can take advantage of this
+			if child.Next == nil {
+				return nil, nil, fmt.Errorf("expected stopsignal value")
+			}
+			stopSignal, stopSignalErr := bcCommands.NewStopSignal(child.Next.Value)
+			if stopSignalErr != nil {
+				return output, lines, fmt.Errorf("stopsignal at %d: %+v", child.StartLine, stopSignalErr)
+			}
+			stopSignal.OriginalCommand = child.Original
+			output = append(output, stopSignal)
 		case "user":
 			if child.Next == nil {
-				return nil, fmt.Errorf("expected user value")
+				return nil, nil, fmt.Errorf("expected user value")
 			}
 			output = append(output, commands.User{Value: child.Next.Value, OriginalCommand: child.Original})
 		case "volume":
@@ -432,13 +533,15 @@ func ReadFromParserResult(parserResult *parser.Result, originalSource string) ([
 			output = append(output, vols)
 		case "workdir":
 			if child.Next == nil {
-				return nil, fmt.Errorf("expected workdir value")
+				return nil, nil, fmt.Errorf("expected workdir value")
 			}
 			output = append(output, commands.Workdir{Value: child.Next.Value, OriginalCommand: child.Original})
 		}
 	}
 
-	return output, nil
+	fillLines()
+
+	return output, lines, nil
 }
 
 func readExcludes(dockerfilePath string) ([]string, error) {
@@ -464,3 +567,23 @@ func readExcludes(dockerfilePath string) ([]string, error) {
 	}
 	return excludePatterns, nil
 }
+
+// normalizeExposedPort lower cases the protocol suffix of the exposed port: 53/UDP becomes 53/udp.
+func normalizeExposedPort(input string) string {
+	if idx := strings.LastIndex(input, "/"); idx > -1 {
+		return input[0:idx] + strings.ToLower(input[idx:])
+	}
+	return input
+}
+
+// validateCopyTarget validates the sources and the target of an ADD or COPY command.
+// Like with Docker, the target of multiple sources must be a directory ending with /.
+func validateCopyTarget(values []string) error {
+	if len(values) < 2 {
+		return fmt.Errorf("source and target required")
+	}
+	if len(values) > 2 && !strings.HasSuffix(values[len(values)-1], "/") {
+		return fmt.Errorf("the target of multiple sources must be a directory ending with /")
+	}
+	return nil
+}
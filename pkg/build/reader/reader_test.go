@@ -1,9 +1,16 @@
 package reader
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
 	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	bcCommands "github.com/combust-labs/firebuild/pkg/build/commands"
 )
 
 func TestReadAddChownFromBytes(t *testing.T) {
@@ -38,3 +45,433 @@ func TestReadAddChownFromBytes(t *testing.T) {
 var dockerfileAddCopyChown = `FROM scracth
 ADD --chown=1:2 . .
 COPY --chown=1:2 . .`
+
+func TestReadStopSignalFromBytes(t *testing.T) {
+	for input, expected := range map[string]string{
+		"9":       "SIGKILL",
+		"SIGKILL": "SIGKILL",
+		"sigquit": "SIGQUIT",
+		"USR1":    "SIGUSR1",
+		// the real-time signals:
+		"SIGRTMIN+3":  "SIGRTMIN+3",
+		"rtmax-2":     "SIGRTMAX-2",
+		"36":          "SIGRTMIN+2",
+		"SIGRTMIN+20": "SIGRTMAX-10",
+		// the build arg reference is expanded by the build:
+		"$SIG": "$SIG",
+	} {
+		cmds, err := ReadFromBytes([]byte(fmt.Sprintf("FROM scratch\nSTOPSIGNAL %s", input)))
+		if err != nil {
+			t.Fatal("Expected dockefile to parse but received an error", err)
+		}
+		foundStopSignal := false
+		for _, cmd := range cmds {
+			if tcmd, ok := cmd.(bcCommands.StopSignal); ok {
+				foundStopSignal = true
+				if tcmd.Value != expected {
+					t.Fatalf("Expected STOPSIGNAL %q for input %q, got %q", expected, input, tcmd.Value)
+				}
+			}
+		}
+		if !foundStopSignal {
+			t.Fatal("Expected STOPSIGNAL command for input", input)
+		}
+	}
+
+	for _, input := range []string{"0", "999", "SIGNOPE", "SIGRTMIN+31", "SIGRTMAX+1"} {
+		if _, err := ReadFromBytes([]byte(fmt.Sprintf("FROM scratch\nSTOPSIGNAL %s", input))); err == nil {
+			t.Fatal("Expected invalid STOPSIGNAL to fail for input", input)
+		}
+	}
+}
+
+func TestReadExposeRetainsProtocol(t *testing.T) {
+	cmds, err := ReadFromBytes([]byte("FROM scratch\nEXPOSE 80 53/udp 514/TCP 8000-8010/udp"))
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	exposed := []string{}
+	for _, cmd := range cmds {
+		if tcmd, ok := cmd.(commands.Expose); ok {
+			exposed = append(exposed, tcmd.RawValue)
+		}
+	}
+	expected := []string{"80", "53/udp", "514/tcp", "8000-8010/udp"}
+	if fmt.Sprintf("%v", exposed) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("Expected exposed ports %v, got %v", expected, exposed)
+	}
+}
+
+func TestCommandLines(t *testing.T) {
+	readResult, err := ReadFromString(dockerfileMultiStage, "")
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	expected := []int{1, 2, 3, 5, 6, 7, 7}
+	if len(readResult.CommandLines()) != len(expected) {
+		t.Fatalf("Expected %d command lines, got %d", len(expected), len(readResult.CommandLines()))
+	}
+	for idx, line := range expected {
+		if readResult.CommandLines()[idx] != line {
+			t.Fatalf("Expected command %d at line %d, got %d", idx, line, readResult.CommandLines()[idx])
+		}
+	}
+}
+
+var dockerfileMultiStage = `ARG VERSION=1
+FROM alpine:3.13 as builder
+RUN echo build
+
+FROM alpine:3.13
+COPY --from=builder /etc/hosts /etc/hosts
+ENV A=1 B=2`
+
+func TestReadFromRelativePathInDifferentWorkingDirectory(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("Expected working directory, got error", err)
+	}
+	defer os.Chdir(cwd)
+
+	contextDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("Expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(contextDir)
+	workDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("Expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := ioutil.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte(dockerfileCopyResource), 0644); err != nil {
+		t.Fatal("Expected Dockerfile to be written, got error", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(contextDir, "resource"), []byte("resource"), 0644); err != nil {
+		t.Fatal("Expected resource to be written, got error", err)
+	}
+
+	if err := os.Chdir(workDir); err != nil {
+		t.Fatal("Expected working directory change, got error", err)
+	}
+	relativeDockerfile, err := filepath.Rel(workDir, filepath.Join(contextDir, "Dockerfile"))
+	if err != nil {
+		t.Fatal("Expected relative path, got error", err)
+	}
+
+	readResult, err := ReadFromString(relativeDockerfile, workDir)
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	if readResult.ContextDirectory() != contextDir {
+		t.Fatalf("Expected context directory %q, got %q", contextDir, readResult.ContextDirectory())
+	}
+
+	// the resources are resolved relative to the Dockerfile, wherever the working directory is:
+	if err := os.Chdir(cwd); err != nil {
+		t.Fatal("Expected working directory change, got error", err)
+	}
+	for _, cmd := range readResult.Commands() {
+		if tcmd, ok := cmd.(commands.Copy); ok {
+			if tcmd.OriginalSource != filepath.Join(contextDir, "Dockerfile") {
+				t.Fatalf("Expected absolute original source, got %q", tcmd.OriginalSource)
+			}
+			resolved, err := resources.NewDefaultResolver().ResolveCopy(tcmd)
+			if err != nil {
+				t.Fatal("Expected COPY resource to resolve, got error", err)
+			}
+			if len(resolved) != 1 || resolved[0].ResolvedURIOrPath() != filepath.Join(contextDir, "resource") {
+				t.Fatalf("Expected COPY resource resolved from the Dockerfile directory, got %v", resolved)
+			}
+		}
+	}
+}
+
+var dockerfileCopyResource = `FROM alpine:3.13
+COPY resource /etc/resource`
+
+func TestReadEscapeDirective(t *testing.T) {
+	for _, dockerfile := range []string{dockerfileDefaultEscape, dockerfileBacktickEscape} {
+		readResult, err := ReadFromString(dockerfile, "")
+		if err != nil {
+			t.Fatal("Expected dockefile to parse but received an error", err)
+		}
+		runs := []commands.Run{}
+		for _, cmd := range readResult.Commands() {
+			if tcmd, ok := cmd.(commands.Run); ok {
+				runs = append(runs, tcmd)
+			}
+		}
+		if len(runs) != 2 {
+			t.Fatalf("Expected 2 RUN commands, got %d", len(runs))
+		}
+		if runs[0].Command != `mkdir -p C:\dir     && echo done` {
+			t.Fatalf("Expected continued RUN command, got %q", runs[0].Command)
+		}
+		if runs[1].Command != "echo last" {
+			t.Fatalf("Expected RUN command after the continued RUN, got %q", runs[1].Command)
+		}
+		expectedLines := []int{3, 4, 6}
+		for idx, line := range expectedLines {
+			if readResult.CommandLines()[idx] != line {
+				t.Fatalf("Expected command %d at line %d, got %d", idx, line, readResult.CommandLines()[idx])
+			}
+		}
+	}
+
+	readResult, err := ReadFromString(dockerfileDefaultEscape, "")
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	if readResult.EscapeToken() != '\\' {
+		t.Fatalf("Expected default escape token, got %q", readResult.EscapeToken())
+	}
+	readResult, err = ReadFromString(dockerfileBacktickEscape, "")
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	if readResult.EscapeToken() != '`' {
+		t.Fatalf("Expected backtick escape token, got %q", readResult.EscapeToken())
+	}
+}
+
+var dockerfileDefaultEscape = `# syntax=docker/dockerfile:1
+
+FROM alpine:3.13
+RUN mkdir -p C:\dir \
+    && echo done
+RUN echo last`
+
+var dockerfileBacktickEscape = "# escape=`\n" +
+	"\n" +
+	"FROM alpine:3.13\n" +
+	"RUN mkdir -p C:\\dir `\n" +
+	"    && echo done\n" +
+	"RUN echo last"
+
+func TestReadHeredocRun(t *testing.T) {
+	readResult, err := ReadFromString(dockerfileHeredocRun, "")
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	runs := []commands.Run{}
+	for _, cmd := range readResult.Commands() {
+		if tcmd, ok := cmd.(commands.Run); ok {
+			runs = append(runs, tcmd)
+		}
+	}
+	if len(runs) != 4 {
+		t.Fatalf("Expected 4 RUN commands, got %d", len(runs))
+	}
+	// a here-document only is the script:
+	if runs[0].Command != "set -e\napk add --no-cache curl\n\necho \"installed\"\n" {
+		t.Fatalf("Expected the here-document script, got %q", runs[0].Command)
+	}
+	if runs[0].OriginalCommand != "RUN <<EOF\nset -e\napk add --no-cache curl\n\necho \"installed\"\nEOF" {
+		t.Fatalf("Expected the original command with the here-document, got %q", runs[0].OriginalCommand)
+	}
+	// leading tabs are removed for <<-:
+	if runs[1].Command != "echo one\necho two\n" {
+		t.Fatalf("Expected the here-document script without the leading tabs, got %q", runs[1].Command)
+	}
+	// a command with a here-document is passed to the shell as is:
+	if runs[2].Command != "python3 <<'PY' > /tmp/out\nprint(\"hello\")\nPY" {
+		t.Fatalf("Expected the command with the here-document, got %q", runs[2].Command)
+	}
+	if runs[3].Command != "echo done" {
+		t.Fatalf("Expected the RUN command after the here-documents, got %q", runs[3].Command)
+	}
+	expectedLines := []int{1, 2, 8, 12, 15}
+	for idx, line := range expectedLines {
+		if readResult.CommandLines()[idx] != line {
+			t.Fatalf("Expected command %d at line %d, got %d", idx, line, readResult.CommandLines()[idx])
+		}
+	}
+
+	if _, err := ReadFromBytes([]byte("FROM alpine:3.13\nRUN <<EOF\necho never terminated\n")); err == nil {
+		t.Fatal("Expected the unterminated here-document to fail")
+	}
+}
+
+func TestReadHeredocMarkerDetection(t *testing.T) {
+	// the << outside of the here-documents does not start a here-document:
+	for _, command := range []string{
+		"echo $((1<<SHIFT))",
+		"echo \"a<<b\"",
+		"echo 'a<<b'",
+		"if (( x << 2 )); then echo shifted; fi",
+		"cat <<<\"here string\"",
+		"echo a <<b",
+	} {
+		cmds, err := ReadFromBytes([]byte(fmt.Sprintf("FROM alpine:3.13\nRUN %s\nRUN echo done\n", command)))
+		if err != nil {
+			t.Fatalf("Expected %q to parse but received an error %v", command, err)
+		}
+		if len(cmds) != 3 {
+			t.Fatalf("Expected 3 commands for %q, got %d", command, len(cmds))
+		}
+		if run, ok := cmds[1].(commands.Run); !ok || run.Command != command {
+			t.Fatalf("Expected the plain RUN command %q, got %+v", command, cmds[1])
+		}
+	}
+
+	// a marker on any line of a continued command:
+	cmds, err := ReadFromBytes([]byte("FROM alpine:3.13\nRUN cat <<EOF \\\n  > /etc/motd\nhello\nEOF\nRUN echo done\n"))
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	if len(cmds) != 3 {
+		t.Fatalf("Expected 3 commands, got %d", len(cmds))
+	}
+	if run, ok := cmds[1].(commands.Run); !ok || run.Command != "cat <<EOF   > /etc/motd\nhello\nEOF" {
+		t.Fatalf("Expected the continued command with the here-document, got %+v", cmds[1])
+	}
+
+	if _, err := ReadFromBytes([]byte("FROM alpine:3.13\nCOPY <<EOF /etc/motd\nnever terminated\n")); err == nil {
+		t.Fatal("Expected the unterminated COPY here-document to fail")
+	}
+}
+
+func TestReadHeredocCopy(t *testing.T) {
+	cmds, err := ReadFromBytes([]byte(dockerfileHeredocCopy))
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	runs := []commands.Run{}
+	for _, cmd := range cmds {
+		switch tcmd := cmd.(type) {
+		case commands.Copy:
+			t.Fatalf("Expected the here-document COPY to be written with a RUN command, got %+v", tcmd)
+		case commands.Run:
+			runs = append(runs, tcmd)
+		}
+	}
+	if len(runs) != 2 {
+		t.Fatalf("Expected 2 RUN commands, got %d", len(runs))
+	}
+	expected := `mkdir -p '/etc/app' && printf '%b' 'name=app\nquote='\''single'\''\n' > '/etc/app/app.conf' && chown 'app:app' '/etc/app/app.conf'`
+	if runs[0].Command != expected {
+		t.Fatalf("Expected the inline file written by the RUN command, got %q", runs[0].Command)
+	}
+	// the guest processes the command as a RUN command:
+	if runs[0].OriginalCommand != "RUN "+expected {
+		t.Fatalf("Expected the RUN original command, got %q", runs[0].OriginalCommand)
+	}
+	expected = `mkdir -p '/usr/local/bin' && printf '%b' '#!/bin/sh\necho start\n' > '/usr/local/bin/start.sh'`
+	if runs[1].Command != expected {
+		t.Fatalf("Expected the inline file named after the here-document, got %q", runs[1].Command)
+	}
+
+	if _, err := ReadFromBytes([]byte("FROM alpine:3.13\nCOPY <<EOF file /etc/\ncontent\nEOF\n")); err == nil {
+		t.Fatal("Expected the here-document COPY with a file source to fail")
+	}
+}
+
+func TestReadHeredocCopyLeadingDash(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("Expected temp directory but received an error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := "---\nkey: 50%\npath: C:\\dir\\new\n\t-n\n"
+	target := filepath.Join(tempDir, "config", "app.yaml")
+	cmds, err := ReadFromBytes([]byte(fmt.Sprintf("FROM alpine:3.13\nCOPY <<EOF %s\n%sEOF\n", target, content)))
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	run, ok := cmds[1].(commands.Run)
+	if !ok {
+		t.Fatalf("Expected the here-document COPY to be written with a RUN command, got %+v", cmds[1])
+	}
+	// the command must work with the shells of the common base images:
+	for _, shell := range []string{"sh", "bash", "dash"} {
+		if _, err := exec.LookPath(shell); err != nil {
+			continue
+		}
+		os.RemoveAll(filepath.Join(tempDir, "config"))
+		if output, err := exec.Command(shell, "-c", run.Command).CombinedOutput(); err != nil {
+			t.Fatalf("Expected the %s command to succeed, got %v: %s", shell, err, string(output))
+		}
+		written, err := ioutil.ReadFile(target)
+		if err != nil {
+			t.Fatalf("Expected the %s command to write the file, got %v", shell, err)
+		}
+		if string(written) != content {
+			t.Fatalf("Expected the %s command to write %q, got %q", shell, content, string(written))
+		}
+	}
+}
+
+func TestReadCopyMultipleSources(t *testing.T) {
+	cmds, err := ReadFromBytes([]byte(dockerfileMultipleSources))
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	copies, adds := []commands.Copy{}, []commands.Add{}
+	for _, cmd := range cmds {
+		switch tcmd := cmd.(type) {
+		case commands.Add:
+			adds = append(adds, tcmd)
+		case commands.Copy:
+			copies = append(copies, tcmd)
+		}
+	}
+	if len(copies) != 3 {
+		t.Fatalf("Expected 3 COPY commands, got %d", len(copies))
+	}
+	for idx, source := range []string{"a.txt", "b.txt", "c.txt"} {
+		if copies[idx].Source != source || copies[idx].Target != "/dest/" {
+			t.Fatalf("Expected COPY %s to /dest/, got %s to %s", source, copies[idx].Source, copies[idx].Target)
+		}
+		if copies[idx].UserFromLocalChown == nil || copies[idx].UserFromLocalChown.Value != "app" {
+			t.Fatalf("Expected COPY %s to have the chown user", source)
+		}
+	}
+	if len(adds) != 2 {
+		t.Fatalf("Expected 2 ADD commands, got %d", len(adds))
+	}
+	for idx, source := range []string{"d.txt", "e.txt"} {
+		if adds[idx].Source != source || adds[idx].Target != "/other/" {
+			t.Fatalf("Expected ADD %s to /other/, got %s to %s", source, adds[idx].Source, adds[idx].Target)
+		}
+	}
+
+	if _, err := ReadFromBytes([]byte("FROM alpine:3.13\nCOPY a.txt b.txt /dest\n")); err == nil {
+		t.Fatal("Expected COPY with multiple sources and a file target to fail")
+	}
+	if _, err := ReadFromBytes([]byte("FROM alpine:3.13\nADD a.txt b.txt c.txt /dest\n")); err == nil {
+		t.Fatal("Expected ADD with multiple sources and a file target to fail")
+	}
+}
+
+var dockerfileMultipleSources = `FROM alpine:3.13
+COPY --chown=app a.txt b.txt c.txt /dest/
+ADD d.txt e.txt /other/
+`
+
+var dockerfileHeredocRun = "FROM alpine:3.13\n" +
+	"RUN <<EOF\n" +
+	"set -e\n" +
+	"apk add --no-cache curl\n" +
+	"\n" +
+	"echo \"installed\"\n" +
+	"EOF\n" +
+	"RUN <<-EOF\n" +
+	"\techo one\n" +
+	"\techo two\n" +
+	"\tEOF\n" +
+	"RUN python3 <<'PY' > /tmp/out\n" +
+	"print(\"hello\")\n" +
+	"PY\n" +
+	"RUN echo done\n"
+
+var dockerfileHeredocCopy = "FROM alpine:3.13\n" +
+	"COPY --chown=app:app <<EOF /etc/app/app.conf\n" +
+	"name=app\n" +
+	"quote='single'\n" +
+	"EOF\n" +
+	"COPY <<start.sh /usr/local/bin/\n" +
+	"#!/bin/sh\n" +
+	"echo start\n" +
+	"start.sh\n"
@@ -0,0 +1,116 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+)
+
+type cachedResolution struct {
+	resolved []resources.ResolvedResource
+	modTimes map[string]time.Time
+}
+
+type cachingResolver struct {
+	sync.Mutex
+	cache      map[string]*cachedResolution
+	underlying resources.Resolver
+}
+
+// NewCachingResolver returns a resolver memoizing the resources resolved by the underlying resolver
+// by the Dockerfile and the source path so a source used by multiple ADD and COPY commands
+// is resolved once per build. The cached resources are resolved again when the modification time
+// of any resolved local path or of the directory containing the source changes.
+func NewCachingResolver(underlying resources.Resolver) resources.Resolver {
+	return &cachingResolver{
+		cache:      map[string]*cachedResolution{},
+		underlying: underlying,
+	}
+}
+
+// ResolveAdd resolves an ADD command resource.
+func (r *cachingResolver) ResolveAdd(res commands.Add) ([]resources.ResolvedResource, error) {
+	user := res.User
+	if res.UserFromLocalChown != nil {
+		user = *res.UserFromLocalChown
+	}
+	return r.resolve("ADD", res.OriginalSource, res.Source, res.Target, res.Workdir, user, func() ([]resources.ResolvedResource, error) {
+		return r.underlying.ResolveAdd(res)
+	})
+}
+
+// ResolveCopy resolves a COPY command resource.
+func (r *cachingResolver) ResolveCopy(res commands.Copy) ([]resources.ResolvedResource, error) {
+	user := res.User
+	if res.UserFromLocalChown != nil {
+		user = *res.UserFromLocalChown
+	}
+	return r.resolve("COPY", res.OriginalSource, res.Source, res.Target, res.Workdir, user, func() ([]resources.ResolvedResource, error) {
+		return r.underlying.ResolveCopy(res)
+	})
+}
+
+func (r *cachingResolver) resolve(kind, originalSource, source, target string, workdir commands.Workdir, user commands.User, f func() ([]resources.ResolvedResource, error)) ([]resources.ResolvedResource, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := kind + "|" + originalSource + "|" + source
+	if cached, ok := r.cache[key]; ok && !cached.isModified() {
+		// the cached resources were resolved for another command, the target comes from this command:
+		return retargetResources(cached.resolved, target, workdir, user), nil
+	}
+
+	resolved, err := f()
+	if err != nil {
+		delete(r.cache, key)
+		return nil, err
+	}
+
+	modTimes := map[string]time.Time{}
+	localPaths := []string{}
+	if originalSource != "" && !isURL(originalSource) && !isURL(source) {
+		localPaths = append(localPaths, filepath.Dir(filepath.Join(filepath.Dir(originalSource), source)))
+	}
+	for _, resource := range resolved {
+		if !isURL(resource.ResolvedURIOrPath()) {
+			localPaths = append(localPaths, resource.ResolvedURIOrPath())
+		}
+	}
+	for _, localPath := range localPaths {
+		if statResult, statErr := os.Stat(localPath); statErr == nil {
+			modTimes[localPath] = statResult.ModTime()
+		}
+	}
+	r.cache[key] = &cachedResolution{resolved: resolved, modTimes: modTimes}
+
+	return resolved, nil
+}
+
+// isModified returns true if any of the local paths changed since the resources were resolved.
+func (c *cachedResolution) isModified() bool {
+	for localPath, modTime := range c.modTimes {
+		statResult, statErr := os.Stat(localPath)
+		if statErr != nil || !statResult.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func retargetResources(input []resources.ResolvedResource, target string, workdir commands.Workdir, user commands.User) []resources.ResolvedResource {
+	output := []resources.ResolvedResource{}
+	for _, resource := range input {
+		if resource.IsDir() {
+			output = append(output, resources.NewResolvedDirectoryResourceWithPath(resource.TargetMode(),
+				resource.ResolvedURIOrPath(), resource.SourcePath(), target, workdir, user))
+			continue
+		}
+		output = append(output, resources.NewResolvedFileResourceWithPath(resource.Contents,
+			resource.TargetMode(), resource.SourcePath(), target, workdir, user, resource.ResolvedURIOrPath()))
+	}
+	return output
+}
@@ -0,0 +1,78 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/build/resources"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingResolver struct {
+	resources.Resolver
+	calls int
+}
+
+func (r *countingResolver) ResolveAdd(res commands.Add) ([]resources.ResolvedResource, error) {
+	r.calls = r.calls + 1
+	return r.Resolver.ResolveAdd(res)
+}
+
+func (r *countingResolver) ResolveCopy(res commands.Copy) ([]resources.ResolvedResource, error) {
+	r.calls = r.calls + 1
+	return r.Resolver.ResolveCopy(res)
+}
+
+func TestCachingResolver(t *testing.T) {
+	tempDir := mustExcludesContext(t, "resource")
+	defer os.RemoveAll(tempDir)
+
+	underlying := &countingResolver{Resolver: resources.NewDefaultResolver()}
+	resolver := NewCachingResolver(underlying)
+
+	copy := commands.Copy{
+		OriginalSource: filepath.Join(tempDir, "Dockerfile"),
+		Source:         "resource",
+		Target:         "/first",
+	}
+	first, err := resolver.ResolveCopy(copy)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(first))
+	assert.Equal(t, "/first", first[0].TargetPath())
+
+	copy.Target = "/second"
+	copy.UserFromLocalChown = &commands.User{Value: "app"}
+	second, err := resolver.ResolveCopy(copy)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, underlying.calls)
+	assert.Equal(t, 1, len(second))
+	assert.Equal(t, "/second", second[0].TargetPath())
+	assert.Equal(t, "app", second[0].TargetUser().Value)
+	assert.Equal(t, first[0].ResolvedURIOrPath(), second[0].ResolvedURIOrPath())
+
+	reader, err := second[0].Contents()
+	assert.Nil(t, err)
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("resource"), content)
+
+	// ADD is cached separately:
+	_, err = resolver.ResolveAdd(commands.Add{OriginalSource: copy.OriginalSource, Source: "resource", Target: "/third"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, underlying.calls)
+
+	// a modified resource is resolved again:
+	modTime := time.Now().Add(time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(tempDir, "resource"), modTime, modTime))
+	_, err = resolver.ResolveCopy(copy)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, underlying.calls)
+	_, err = resolver.ResolveCopy(copy)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, underlying.calls)
+}
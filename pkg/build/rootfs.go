@@ -2,6 +2,7 @@ package build
 
 import (
 	"fmt"
+	"net/http"
 	"path/filepath"
 	"strings"
 
@@ -9,6 +10,8 @@ import (
 	"github.com/combust-labs/firebuild-shared/build/resources"
 	"github.com/combust-labs/firebuild-shared/build/rootfs"
 	"github.com/combust-labs/firebuild-shared/env"
+	bcCommands "github.com/combust-labs/firebuild/pkg/build/commands"
+	"github.com/combust-labs/firebuild/pkg/utils"
 	"github.com/docker/docker/pkg/fileutils"
 	"github.com/hashicorp/go-hclog"
 )
@@ -24,10 +27,14 @@ type Build interface {
 	Volumes() []string
 	WithBuildArgs(map[string]string) Build
 	WithExcludes([]string) Build
+	WithInternalPaths(...string) Build
 	WithLogger(hclog.Logger) Build
+	WithMaxResourceSize(int64) Build
 	WithPostBuildCommands(...commands.Run) Build
 	WithPreBuildCommands(...commands.Run) Build
 	WithResolver(resources.Resolver) Build
+	WithRetryConfig(utils.RetryConfig) Build
+	WithSourceLocations(bcCommands.SourceLocations) Build
 }
 
 type defaultBuild struct {
@@ -39,17 +46,25 @@ type defaultBuild struct {
 	currentEnv        map[string]string
 	currentMetadata   map[string]string
 	currentShell      commands.Shell
+	currentStopSignal bcCommands.StopSignal
 	currentUser       commands.User
 	currentWorkdir    commands.Workdir
 
 	exposedPorts      []string
 	excludes          []string
 	from              commands.From
+	globalArgs        env.BuildEnv
+	inStage           bool
 	instructions      []interface{}
+	internalPaths     []string
 	isDependencyBuild bool
 	logger            hclog.Logger
+	maxResourceSize   int64
 	resolver          resources.Resolver
 
+	instructionLocations []bcCommands.SourceLocation
+	sourceLocations      bcCommands.SourceLocations
+
 	postBuildCommands []commands.Run
 	preBuildCommands  []commands.Run
 
@@ -72,19 +87,19 @@ func (b *defaultBuild) CreateContext(dependencies rootfs.Resources) (*rootfs.Wor
 	b.logger.Info("building from", "base", b.from.BaseImage)
 
 	// validate resources first:
-	for _, command := range b.instructions {
+	for idx, command := range b.instructions {
 		switch tcommand := command.(type) {
 		case commands.Add:
 			resolvedResource, err := b.resolver.ResolveAdd(tcommand)
 			if err != nil {
-				b.logger.Error("failed resolving ADD resource", "reason", err)
+				b.instructionLogger(idx).Error("failed resolving ADD resource", "reason", err)
 				return nil, err
 			}
 			ctx.ResourcesResolved[tcommand.Source] = resolvedResource
 		case commands.Copy:
 			resolvedResource, err := b.resolver.ResolveCopy(tcommand)
 			if err != nil {
-				b.logger.Error("failed resolving COPY resource", "reason", err)
+				b.instructionLogger(idx).Error("failed resolving COPY resource", "reason", err)
 				return nil, err
 			}
 			ctx.ResourcesResolved[tcommand.Source] = resolvedResource
@@ -108,31 +123,47 @@ func (b *defaultBuild) CreateContext(dependencies rootfs.Resources) (*rootfs.Wor
 		return true
 	}
 
-	for _, command := range b.instructions {
+	for idx, command := range b.instructions {
 		switch tcommand := command.(type) {
 		case commands.Add:
-			if patternMatcherFunc(tcommand.Source) {
-				b.logger.Debug("skipping excluded path for PutResource ADD", "source", tcommand.Source)
-				continue
-			}
-			if _ /* resource */, ok := ctx.ResourcesResolved[tcommand.Source]; ok {
+			if resolved, ok := ctx.ResourcesResolved[tcommand.Source]; ok {
+				included, err := b.excludeInternalPaths(idx, tcommand.OriginalSource, tcommand.Source, resolved)
+				if err != nil {
+					b.instructionLogger(idx).Error("failed matching ADD resource against build cache paths", "source", tcommand.Source, "reason", err)
+					return nil, err
+				}
+				included, err = excludeResources(patternMatcher, tcommand.OriginalSource, tcommand.Source, included)
+				if err != nil {
+					b.instructionLogger(idx).Error("failed matching ADD resource against excludes", "source", tcommand.Source, "reason", err)
+					return nil, err
+				}
+				included, err = guardResources(b.logger, b.maxResourceSize, included)
+				if err != nil {
+					b.instructionLogger(idx).Error("ADD resource rejected", "source", tcommand.Source, "reason", err)
+					return nil, fmt.Errorf("%s%v", b.instructionLocationPrefix(idx), err)
+				}
+				if len(included) == 0 {
+					b.logger.Debug("skipping excluded path for PutResource ADD", "source", tcommand.Source)
+					continue
+				}
+				ctx.ResourcesResolved[tcommand.Source] = included
 				b.logger.Info("Putting ADD resource", "source", tcommand.Source)
 				ctx.ExecutableCommands = append(ctx.ExecutableCommands, tcommand)
 			} else {
-				b.logger.Error("ADD resource required but not resolved", "source", tcommand.Source)
+				b.instructionLogger(idx).Error("ADD resource required but not resolved", "source", tcommand.Source)
 			}
 		case commands.Copy:
-			if patternMatcherFunc(tcommand.Source) {
-				b.logger.Debug("skipping excluded path for PutResource COPY", "source", tcommand.Source)
-				continue
-			}
 			// dependency resources exist for COPY commands only:
 			if tcommand.Stage != "" {
+				if patternMatcherFunc(tcommand.Source) {
+					b.logger.Debug("skipping excluded path for PutResource COPY", "source", tcommand.Source)
+					continue
+				}
 				// we need to locate a dependency resource
 				dependencyResources, ok := dependencies[tcommand.Stage]
 				if !ok {
-					b.logger.Error("PutResource COPY resource failed, no dependency resource stage", "source", tcommand.Source, "stage", tcommand.Stage)
-					return nil, fmt.Errorf("no dependency stage %s", tcommand.Stage)
+					b.instructionLogger(idx).Error("PutResource COPY resource failed, no dependency resource stage", "source", tcommand.Source, "stage", tcommand.Stage)
+					return nil, fmt.Errorf("%sno dependency stage %s", b.instructionLocationPrefix(idx), tcommand.Stage)
 				}
 				resourceWasProcessed := false
 				for _, dependencyResource := range dependencyResources {
@@ -159,15 +190,35 @@ func (b *defaultBuild) CreateContext(dependencies rootfs.Resources) (*rootfs.Wor
 					}
 				}
 				if !resourceWasProcessed {
-					b.logger.Error("COPY resource required from stage but not resolved", "source", tcommand.Source, "stage", tcommand.Stage)
+					b.instructionLogger(idx).Error("COPY resource required from stage but not resolved", "source", tcommand.Source, "stage", tcommand.Stage)
 				}
 				continue
 			}
-			if _ /* resource */, ok := ctx.ResourcesResolved[tcommand.Source]; ok {
+			if resolved, ok := ctx.ResourcesResolved[tcommand.Source]; ok {
+				included, err := b.excludeInternalPaths(idx, tcommand.OriginalSource, tcommand.Source, resolved)
+				if err != nil {
+					b.instructionLogger(idx).Error("failed matching COPY resource against build cache paths", "source", tcommand.Source, "reason", err)
+					return nil, err
+				}
+				included, err = excludeResources(patternMatcher, tcommand.OriginalSource, tcommand.Source, included)
+				if err != nil {
+					b.instructionLogger(idx).Error("failed matching COPY resource against excludes", "source", tcommand.Source, "reason", err)
+					return nil, err
+				}
+				included, err = guardResources(b.logger, b.maxResourceSize, included)
+				if err != nil {
+					b.instructionLogger(idx).Error("COPY resource rejected", "source", tcommand.Source, "reason", err)
+					return nil, fmt.Errorf("%s%v", b.instructionLocationPrefix(idx), err)
+				}
+				if len(included) == 0 {
+					b.logger.Debug("skipping excluded path for PutResource COPY", "source", tcommand.Source)
+					continue
+				}
+				ctx.ResourcesResolved[tcommand.Source] = included
 				b.logger.Info("Putting COPY resource", "source", tcommand.Source)
 				ctx.ExecutableCommands = append(ctx.ExecutableCommands, tcommand)
 			} else {
-				b.logger.Error("COPY resource required but not resolved", "source", tcommand.Source)
+				b.instructionLogger(idx).Error("COPY resource required but not resolved", "source", tcommand.Source)
 			}
 
 		case commands.Run:
@@ -192,19 +243,36 @@ func (b *defaultBuild) CreateContext(dependencies rootfs.Resources) (*rootfs.Wor
 
 func (b *defaultBuild) AddInstructions(instructions ...interface{}) error {
 	for _, input := range instructions {
+		// the location must be resolved in order of appearance, before the command is modified:
+		location := b.sourceLocations.Locate(input)
 		switch tinput := input.(type) {
 		case commands.Add:
 			tinput.User = b.currentUser
 			tinput.Workdir = b.currentWorkdir
-			b.instructions = append(b.instructions, tinput)
+			b.appendInstruction(tinput, location)
 		case commands.Arg:
 			argValue, hadValue := tinput.Value()
+			if !b.inStage {
+				// an ARG before the first FROM is a global build arg,
+				// usable in FROM and in the stage only when declared again:
+				if buildArgValue, ok := b.buildArgs[tinput.Key()]; ok {
+					b.globalArgs.Put(tinput.Key(), buildArgValue)
+				} else if hadValue {
+					b.globalArgs.Put(tinput.Key(), argValue)
+				}
+				continue
+			}
 			if buildArgValue, ok := b.buildArgs[tinput.Key()]; ok {
 				argValue = buildArgValue
-			} else {
-				if !hadValue {
+			} else if !hadValue {
+				globalArgValue, ok := b.globalArgs.Snapshot()[tinput.Key()]
+				if !ok {
+					if location.IsKnown() {
+						return fmt.Errorf("%s: build arg %q: no value", location, tinput.Key())
+					}
 					return fmt.Errorf("build arg %q: no value", tinput.Key())
 				}
+				argValue = globalArgValue
 			}
 			key, value := b.buildEnv.Put(tinput.Key(), argValue)
 			b.currentArgs[key] = value
@@ -213,7 +281,7 @@ func (b *defaultBuild) AddInstructions(instructions ...interface{}) error {
 		case commands.Copy:
 			tinput.User = b.currentUser
 			tinput.Workdir = b.currentWorkdir
-			b.instructions = append(b.instructions, tinput)
+			b.appendInstruction(tinput, location)
 		case commands.Entrypoint:
 			tinput.Env = b.currentEnv
 			tinput.Shell = b.currentShell
@@ -226,26 +294,49 @@ func (b *defaultBuild) AddInstructions(instructions ...interface{}) error {
 		case commands.Expose:
 			b.exposedPorts = append(b.exposedPorts, tinput.RawValue)
 		case commands.From:
+			// the args declared in the previous stage are not in scope of the new stage:
+			b.buildEnv = env.NewBuildEnv()
+			for key, value := range b.currentEnv {
+				b.buildEnv.Put(key, value)
+			}
+			b.currentArgs = map[string]string{}
+			b.inStage = true
+			tinput.BaseImage = b.globalArgs.Expand(tinput.BaseImage)
 			b.isDependencyBuild = tinput.StageName != ""
 			b.from = tinput
 		case commands.Label:
 			b.currentMetadata[tinput.Key] = b.buildEnv.Expand(tinput.Value)
 		case commands.Run:
-			tinput.Args = b.currentArgs
+			// the args in scope at this RUN, a later ARG must not change them:
+			tinput.Args = map[string]string{}
+			for key, value := range b.currentArgs {
+				tinput.Args[key] = value
+			}
 			tinput.Env = b.currentEnv
 			tinput.Shell = b.currentShell
 			tinput.User = b.currentUser
 			tinput.Workdir = b.currentWorkdir
 			tinput.Command = b.buildEnv.Expand(tinput.Command)
-			b.instructions = append(b.instructions, tinput)
+			b.appendInstruction(tinput, location)
 		case commands.Shell:
 			b.currentShell = tinput
+		case bcCommands.StopSignal:
+			if tinput.ReferencesArgs() {
+				value, err := bcCommands.NormalizeStopSignal(b.buildEnv.Expand(tinput.Value))
+				if err != nil {
+					b.logger.Warn("STOPSIGNAL is invalid after expanding the build args, falling back to the default",
+						"value", tinput.Value, "default", bcCommands.DefaultStopSignal, "reason", err)
+					value = bcCommands.DefaultStopSignal
+				}
+				tinput.Value = value
+			}
+			b.currentStopSignal = tinput
 		case commands.User:
 			b.currentUser = tinput
 		case commands.Volume:
 			tinput.User = b.currentUser
 			tinput.Workdir = b.currentWorkdir
-			b.instructions = append(b.instructions, tinput)
+			b.appendInstruction(tinput, location)
 		case commands.Workdir:
 			if strings.HasPrefix(tinput.Value, "/") {
 				b.currentWorkdir = tinput
@@ -262,6 +353,7 @@ func (b *defaultBuild) EntrypointInfo() *EntrypointInfo {
 	return &EntrypointInfo{
 		Cmd:        b.currentCmd,
 		Entrypoint: b.currentEntrypoint,
+		StopSignal: b.currentStopSignal,
 	}
 }
 
@@ -291,11 +383,21 @@ func (b *defaultBuild) WithExcludes(input []string) Build {
 	return b
 }
 
+func (b *defaultBuild) WithInternalPaths(input ...string) Build {
+	b.internalPaths = append(b.internalPaths, input...)
+	return b
+}
+
 func (b *defaultBuild) WithLogger(input hclog.Logger) Build {
 	b.logger = input
 	return b
 }
 
+func (b *defaultBuild) WithMaxResourceSize(input int64) Build {
+	b.maxResourceSize = input
+	return b
+}
+
 func (b *defaultBuild) WithPostBuildCommands(cmds ...commands.Run) Build {
 	b.postBuildCommands = append(b.postBuildCommands, cmds...)
 	return b
@@ -310,6 +412,41 @@ func (b *defaultBuild) WithResolver(input resources.Resolver) Build {
 	return b
 }
 
+// WithRetryConfig sets the resolver retrying the failed ADD and COPY URL downloads,
+// the retries are logged with the logger of the build.
+func (b *defaultBuild) WithRetryConfig(input utils.RetryConfig) Build {
+	client := &http.Client{Transport: utils.NewRetryingTransport(nil, input, b.logger)}
+	b.resolver = NewCachingResolver(NewDownloadingResolver(resources.NewDefaultResolver(), client))
+	return b
+}
+
+func (b *defaultBuild) WithSourceLocations(input bcCommands.SourceLocations) Build {
+	b.sourceLocations = input
+	return b
+}
+
+func (b *defaultBuild) appendInstruction(instruction interface{}, location bcCommands.SourceLocation) {
+	b.instructions = append(b.instructions, instruction)
+	b.instructionLocations = append(b.instructionLocations, location)
+}
+
+// instructionLogger returns a logger pointing at the Dockerfile location of the instruction, if known.
+func (b *defaultBuild) instructionLogger(idx int) hclog.Logger {
+	location := b.instructionLocations[idx]
+	if !location.IsKnown() {
+		return b.logger
+	}
+	return b.logger.With("source-file", location.SourceFile, "line", location.Line)
+}
+
+func (b *defaultBuild) instructionLocationPrefix(idx int) string {
+	location := b.instructionLocations[idx]
+	if !location.IsKnown() {
+		return ""
+	}
+	return location.String() + ": "
+}
+
 // NewDefaultBuild returns an instance of the default Build implementation.
 func NewDefaultBuild() Build {
 	return &defaultBuild{
@@ -323,10 +460,14 @@ func NewDefaultBuild() Build {
 		currentUser:       commands.User{Value: "0:0"},
 		currentWorkdir:    commands.Workdir{Value: "/"},
 		exposedPorts:      []string{},
+		globalArgs:        env.NewBuildEnv(),
 		instructions:      []interface{}{},
 		logger:            hclog.Default(),
-		resolver:          resources.NewDefaultResolver(),
+		resolver:          NewCachingResolver(resources.NewDefaultResolver()),
 		volumes:           []string{},
+
+		instructionLocations: []bcCommands.SourceLocation{},
+		sourceLocations:      bcCommands.NoSourceLocations(),
 	}
 }
 
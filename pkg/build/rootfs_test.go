@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/combust-labs/firebuild-shared/build/commands"
@@ -198,6 +199,210 @@ func TestContextBuilderSingleStageWithResources(t *testing.T) {
 	<-testServer.FinishedNotify()
 }
 
+func TestContextBuilderReportsSourceLocation(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dockerfilePath := filepath.Join(tempDir, "Dockerfile")
+	rootfs.MustPutTestResource(t, dockerfilePath, []byte(testDockerfileMissingArg))
+
+	readResult, err := reader.ReadFromString(dockerfilePath, tempDir)
+	if err != nil {
+		t.Fatal("expected Dockerfile to be read, got error", err)
+	}
+
+	stages, errs := stage.ReadStages(readResult.Commands())
+	if len(errs) > 0 {
+		t.Fatal("expected no errors in stage reader, got", errs)
+	}
+
+	contextBuilder := NewDefaultBuild().WithSourceLocations(readResult.SourceLocations())
+	addErr := contextBuilder.AddInstructions(stages.Unnamed()[0].Commands()...)
+	assert.NotNil(t, addErr)
+	assert.Equal(t, fmt.Sprintf("%s:4: build arg \"MISSING\": no value", dockerfilePath), addErr.Error())
+}
+
+func TestContextBuilderArgScoping(t *testing.T) {
+	readResult, err := reader.ReadFromBytes([]byte(testDockerfileArgScoping))
+	if err != nil {
+		t.Fatal("expected Dockerfile to be read, got error", err)
+	}
+
+	contextBuilder := NewDefaultBuild().WithBuildArgs(map[string]string{"ALPINE_VERSION": "3.14"})
+	if err := contextBuilder.AddInstructions(readResult...); err != nil {
+		t.Fatal("expected commands to be added, got error", err)
+	}
+	assert.Equal(t, "alpine:3.14", contextBuilder.From().BaseImage)
+
+	runs := []commands.Run{}
+	for _, instruction := range contextBuilder.(*defaultBuild).instructions {
+		if run, ok := instruction.(commands.Run); ok {
+			runs = append(runs, run)
+		}
+	}
+	assert.Equal(t, 3, len(runs))
+	// the global arg is in scope only when declared again in the stage:
+	assert.Equal(t, map[string]string{"STAGE_ARG": "builder"}, runs[0].Args)
+	assert.Equal(t, "echo builder ${ALPINE_VERSION}", runs[0].Command)
+	// the args of the previous stage are not in scope:
+	assert.Equal(t, map[string]string{"ALPINE_VERSION": "3.14"}, runs[1].Args)
+	assert.Equal(t, "echo ${STAGE_ARG} 3.14", runs[1].Command)
+	// the stage arg redefined:
+	assert.Equal(t, map[string]string{"ALPINE_VERSION": "3.14", "STAGE_ARG": "main"}, runs[2].Args)
+	assert.Equal(t, "echo main", runs[2].Command)
+}
+
+func TestContextBuilderStopSignalArgs(t *testing.T) {
+	for sig, expected := range map[string]string{
+		"rtmin+2": "SIGRTMIN+2",
+		"9":       "SIGKILL",
+		// invalid after the expansion, falls back to the default:
+		"NOPE": "SIGTERM",
+	} {
+		readResult, err := reader.ReadFromBytes([]byte("FROM alpine:3.13\nARG SIG\nSTOPSIGNAL $SIG\n"))
+		if err != nil {
+			t.Fatal("expected Dockerfile to be read, got error", err)
+		}
+		contextBuilder := NewDefaultBuild().WithBuildArgs(map[string]string{"SIG": sig})
+		if err := contextBuilder.AddInstructions(readResult...); err != nil {
+			t.Fatal("expected commands to be added, got error", err)
+		}
+		assert.Equal(t, expected, contextBuilder.EntrypointInfo().StopSignal.Value)
+	}
+}
+
+func TestContextBuilderHeredocRunIsSingleCommand(t *testing.T) {
+	readResult, err := reader.ReadFromBytes([]byte("FROM alpine:3.13\nRUN <<EOF\nset -e\necho one\necho two\nEOF\n"))
+	if err != nil {
+		t.Fatal("expected Dockerfile to be read, got error", err)
+	}
+	contextBuilder := NewDefaultBuild()
+	if err := contextBuilder.AddInstructions(readResult...); err != nil {
+		t.Fatal("expected commands to be added, got error", err)
+	}
+	buildCtx, err := contextBuilder.CreateContext(make(rootfs.Resources))
+	if err != nil {
+		t.Fatal("expected build context to be created, got error", err)
+	}
+	assert.Equal(t, 1, len(buildCtx.ExecutableCommands))
+	run, ok := buildCtx.ExecutableCommands[0].(commands.Run)
+	assert.True(t, ok)
+	assert.Equal(t, "set -e\necho one\necho two\n", run.Command)
+}
+
+func TestContextBuilderCopyPreservesDirectoryStructure(t *testing.T) {
+	// without the excludes, the server walks the directory,
+	// with the excludes, the directory is expanded by the build:
+	t.Run("directory", func(t *testing.T) {
+		mustCopyDirectoryStructure(t, "")
+	})
+	t.Run("directory with excludes", func(t *testing.T) {
+		mustCopyDirectoryStructure(t, "**/*.tmp\n")
+	})
+}
+
+func mustCopyDirectoryStructure(t *testing.T, dockerignore string) {
+	logger := hclog.Default()
+	logger.SetLevel(hclog.Debug)
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dockerfilePath := filepath.Join(tempDir, "Dockerfile")
+	rootfs.MustPutTestResource(t, dockerfilePath, []byte(testDockerfileDirectoryStructure))
+	if dockerignore != "" {
+		rootfs.MustPutTestResource(t, filepath.Join(tempDir, ".dockerignore"), []byte(dockerignore))
+	}
+	sourceFiles := map[string]string{
+		"top.txt":                "top",
+		"level1/one.txt":         "one",
+		"level1/level2/two.txt":  "two",
+		"level1/level2/skip.tmp": "temporary",
+		"other/level3/three.txt": "three",
+	}
+	for path, content := range sourceFiles {
+		rootfs.MustPutTestResource(t, filepath.Join(tempDir, "dir", path), []byte(content))
+	}
+
+	readResult, err := reader.ReadFromString(dockerfilePath, tempDir)
+	if err != nil {
+		t.Fatal("expected Dockerfile to be read, got error", err)
+	}
+	contextBuilder := NewDefaultBuild().WithExcludes(readResult.ExcludePatterns())
+	if err := contextBuilder.AddInstructions(readResult.Commands()...); err != nil {
+		t.Fatal("expected commands to be added, got error", err)
+	}
+	buildCtx, err := contextBuilder.CreateContext(make(rootfs.Resources))
+	if err != nil {
+		t.Fatal("expected build context to be created, got error", err)
+	}
+
+	testServer, testClient, cancelFunc := rootfs.MustStartTestGRPCServer(t, logger, buildCtx)
+	defer cancelFunc()
+	if err := testClient.Commands(); err != nil {
+		t.Fatal("GRPC client Commands() opErr", err)
+	}
+
+	// the guest writes the resources under the target paths:
+	guestRoot := filepath.Join(tempDir, "guest")
+	for _, target := range []string{"/target", "/nested/target"} {
+		copyCommand, ok := testClient.NextCommand().(commands.Copy)
+		if !ok {
+			t.Fatal("expected COPY command")
+		}
+		resourceChannel, err := testClient.Resource(copyCommand.Source)
+		if err != nil {
+			t.Fatal("expected resource channel for COPY command, got error", err)
+		}
+		for item := range resourceChannel {
+			resource, ok := item.(resources.ResolvedResource)
+			if !ok {
+				t.Fatal("expected resource, got", item)
+			}
+			guestPath := filepath.Join(guestRoot, resource.TargetPath())
+			if resource.IsDir() {
+				assert.Nil(t, os.MkdirAll(guestPath, fs.ModePerm))
+				continue
+			}
+			contents, err := rootfs.MustReadFromReader(resource.Contents())
+			assert.Nil(t, err)
+			rootfs.MustPutTestResource(t, guestPath, contents)
+		}
+
+		guestFiles := map[string]string{}
+		walkErr := filepath.Walk(filepath.Join(guestRoot, target), func(path string, info fs.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			relativePath, _ := filepath.Rel(filepath.Join(guestRoot, target), path)
+			guestFiles[filepath.ToSlash(relativePath)] = string(contents)
+			return nil
+		})
+		assert.Nil(t, walkErr)
+		expectedFiles := map[string]string{}
+		for path, content := range sourceFiles {
+			if dockerignore == "" || !strings.HasSuffix(path, ".tmp") {
+				expectedFiles[path] = content
+			}
+		}
+		assert.Equal(t, expectedFiles, guestFiles, "guest layout of %s", target)
+	}
+	assert.Nil(t, testClient.NextCommand())
+
+	testClient.Success()
+	<-testServer.FinishedNotify()
+}
+
 func TestDockerignoreMatches(t *testing.T) {
 	patternMatcher, err := fileutils.NewPatternMatcher([]string{
 		".DS_Store",
@@ -232,6 +437,10 @@ func TestDockerignoreMatches(t *testing.T) {
 	}
 }
 
+const testDockerfileDirectoryStructure = `FROM alpine:3.13
+COPY dir/ /target/
+COPY dir /nested/target`
+
 const testDockerfileSingleStage = `FROM alpine:3.13
 ARG PARAM1=value
 ENV ENVPARAM1=envparam1
@@ -252,3 +461,20 @@ COPY resource2 /target/resource2
 COPY --from=builder /etc/test /etc/test
 RUN cp /dir/${ENVPARAM1} \
 	&& call --arg=${PARAM1}`
+
+const testDockerfileMissingArg = `FROM alpine:3.13
+RUN mkdir -p /dir \
+	&& echo 1
+ARG MISSING
+RUN echo ${MISSING}`
+
+const testDockerfileArgScoping = `ARG ALPINE_VERSION=3.13
+FROM alpine:${ALPINE_VERSION} as builder
+ARG STAGE_ARG=builder
+RUN echo ${STAGE_ARG} ${ALPINE_VERSION}
+
+FROM alpine:${ALPINE_VERSION}
+ARG ALPINE_VERSION
+RUN echo ${STAGE_ARG} ${ALPINE_VERSION}
+ARG STAGE_ARG=main
+RUN echo ${STAGE_ARG}`
@@ -1,19 +1,33 @@
 package stage
 
 import (
+	"strings"
+
 	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild-shared/env"
 	bcErrors "github.com/combust-labs/firebuild/pkg/build/errors"
+	"github.com/docker/distribution/reference"
 )
 
 // ReadStages reads the stages out of the source commands.
 func ReadStages(inputs []interface{}) (Stages, []error) {
+	return ReadStagesWithBuildArgs(inputs, map[string]string{})
+}
+
+// ReadStagesWithBuildArgs reads the stages out of the source commands.
+// The ARG commands before the first FROM are global build args, the FROM base images
+// are expanded with the global build args, the build args override the global defaults.
+// A FROM referencing an undefined build arg is reported as an error and the stage is invalid.
+// A COPY --from referencing neither a named stage nor a valid image reference is reported as an error.
+func ReadStagesWithBuildArgs(inputs []interface{}, buildArgs map[string]string) (Stages, []error) {
 	stages := newStages()
 	errs := []error{}
 
 	danglingCommands := []interface{}{}
+	globalArgs := env.NewBuildEnv()
 
 	for _, input := range inputs {
-		switch input.(type) {
+		switch tinput := input.(type) {
 		case commands.From:
 			// a FROM command resets the processing stage
 			stages.closePrevious()
@@ -21,13 +35,23 @@ func ReadStages(inputs []interface{}) (Stages, []error) {
 			for _, danglingCommand := range danglingCommands {
 				stages.addCommand(danglingCommand)
 			}
-			stages.addCommand(input)
+			tinput.BaseImage = globalArgs.Expand(tinput.BaseImage)
+			if strings.Contains(tinput.BaseImage, "$") {
+				errs = append(errs, &bcErrors.UnresolvedBuildArgError{Command: tinput})
+				tinput.BaseImage = ""
+			}
+			stages.addCommand(tinput)
 		default:
 			if !stages.addCommand(input) {
 				// if there is an ARG, ENV or LABEL prior to any FROM,
 				// remember it and add these to any further stage first
-				switch input.(type) {
+				switch tinput := input.(type) {
 				case commands.Arg:
+					if value, ok := buildArgs[tinput.Key()]; ok {
+						globalArgs.Put(tinput.Key(), value)
+					} else if value, hadValue := tinput.Value(); hadValue {
+						globalArgs.Put(tinput.Key(), value)
+					}
 					danglingCommands = append(danglingCommands, input)
 				case commands.Env:
 					danglingCommands = append(danglingCommands, input)
@@ -40,7 +64,35 @@ func ReadStages(inputs []interface{}) (Stages, []error) {
 		}
 	}
 	stages.closePrevious()
-	return stages, errs
+	return stages, append(errs, validateCopySources(stages)...)
+}
+
+// IsExternalImage returns true if the COPY --from value references an image
+// instead of a named stage of the Dockerfile.
+func IsExternalImage(stages Stages, source string) bool {
+	if stages.NamedStage(source) != nil {
+		return false
+	}
+	_, err := reference.ParseNormalizedNamed(source)
+	return err == nil
+}
+
+// validateCopySources returns an error for every COPY --from referencing
+// neither a named stage nor a valid image reference.
+func validateCopySources(stages Stages) []error {
+	errs := []error{}
+	names := []string{}
+	for _, st := range stages.Named() {
+		names = append(names, st.Name())
+	}
+	for _, st := range stages.All() {
+		for _, cmd := range st.Commands() {
+			if tcmd, ok := cmd.(commands.Copy); ok && tcmd.Stage != "" && stages.NamedStage(tcmd.Stage) == nil && !IsExternalImage(stages, tcmd.Stage) {
+				errs = append(errs, &bcErrors.UnknownCopySourceError{Command: tcmd, Source: tcmd.Stage, Stages: names})
+			}
+		}
+	}
+	return errs
 }
 
 // Stages represents all build stages parsed out of the Dockerfile.
@@ -2,8 +2,11 @@ package stage
 
 import (
 	"sort"
+	"strings"
 	"testing"
 
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	bcErrors "github.com/combust-labs/firebuild/pkg/build/errors"
 	"github.com/combust-labs/firebuild/pkg/build/reader"
 )
 
@@ -76,3 +79,99 @@ COPY --from=builder /go/src/github.com/grepplabs/kafka-proxy/build /opt/kafka-pr
 ENTRYPOINT ["/opt/kafka-proxy/bin/kafka-proxy"]
 CMD ["--help"]
 `
+
+func TestGlobalArgsInFrom(t *testing.T) {
+	inputs, err := reader.ReadFromBytes([]byte(dockerfileGlobalArgs))
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+
+	scs, errs := ReadStages(inputs)
+	if len(errs) > 0 {
+		t.Fatal("Stages reader returned errors", errs)
+	}
+	if base := stageBaseImage(scs.NamedStage("builder")); base != "golang:1.16-alpine3.13" {
+		t.Fatalf("Expected builder base image expanded with the global args, got %q", base)
+	}
+	if base := stageBaseImage(scs.Unnamed()[0]); base != "alpine:3.13" {
+		t.Fatalf("Expected main base image expanded with the global args, got %q", base)
+	}
+
+	scs, errs = ReadStagesWithBuildArgs(inputs, map[string]string{"ALPINE_VERSION": "3.14"})
+	if len(errs) > 0 {
+		t.Fatal("Stages reader returned errors", errs)
+	}
+	if base := stageBaseImage(scs.NamedStage("builder")); base != "golang:1.16-alpine3.14" {
+		t.Fatalf("Expected builder base image expanded with the build arg, got %q", base)
+	}
+	if base := stageBaseImage(scs.Unnamed()[0]); base != "alpine:3.14" {
+		t.Fatalf("Expected main base image expanded with the build arg, got %q", base)
+	}
+
+	undefined, err := reader.ReadFromBytes([]byte("ARG VERSION\nFROM alpine:${VERSION}\n"))
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	scs, errs = ReadStages(undefined)
+	if len(errs) != 1 {
+		t.Fatalf("Expected an unresolved build arg error, got %+v", errs)
+	}
+	if scs.Unnamed()[0].IsValid() {
+		t.Fatal("Expected stage with an unresolved base image to be invalid")
+	}
+	scs, errs = ReadStagesWithBuildArgs(undefined, map[string]string{"VERSION": "3.13"})
+	if len(errs) > 0 {
+		t.Fatal("Stages reader returned errors", errs)
+	}
+	if base := stageBaseImage(scs.Unnamed()[0]); base != "alpine:3.13" {
+		t.Fatalf("Expected base image expanded with the build arg, got %q", base)
+	}
+}
+
+func stageBaseImage(st Stage) string {
+	for _, cmd := range st.Commands() {
+		if from, ok := cmd.(commands.From); ok {
+			return from.BaseImage
+		}
+	}
+	return ""
+}
+
+var dockerfileGlobalArgs = `ARG GO_VERSION=1.16
+ARG ALPINE_VERSION=3.13
+FROM golang:${GO_VERSION}-alpine${ALPINE_VERSION} as builder
+RUN go version
+
+FROM alpine:${ALPINE_VERSION}
+COPY --from=builder /go/bin /usr/bin
+`
+
+func TestCopyFromUnknownSource(t *testing.T) {
+	inputs, err := reader.ReadFromBytes([]byte(`FROM golang:1.16 as builder
+RUN go version
+
+FROM alpine:3.13
+COPY --from=Buidler /go/bin /usr/bin
+COPY --from=nginx:1.21 /etc/nginx /etc/nginx
+`))
+	if err != nil {
+		t.Fatal("Expected dockefile to parse but received an error", err)
+	}
+	scs, errs := ReadStages(inputs)
+	if len(errs) != 1 {
+		t.Fatalf("Expected an error for the invalid COPY --from only, got %+v", errs)
+	}
+	typoErr, ok := errs[0].(*bcErrors.UnknownCopySourceError)
+	if !ok || typoErr.Source != "Buidler" {
+		t.Fatalf("Expected the invalid source to be reported, got %+v", errs[0])
+	}
+	if !strings.Contains(typoErr.Error(), `no stage named "Buidler" and not a valid image reference, named stages: builder`) {
+		t.Fatalf("Expected the error to list the named stages, got %q", typoErr.Error())
+	}
+	if !IsExternalImage(scs, "nginx:1.21") {
+		t.Fatal("Expected nginx:1.21 to be an external image")
+	}
+	if IsExternalImage(scs, "builder") {
+		t.Fatal("Expected builder to be a named stage")
+	}
+}
@@ -0,0 +1,205 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/combust-labs/firebuild-shared/build/commands"
+	"github.com/combust-labs/firebuild/pkg/containers"
+	docker "github.com/docker/docker/client"
+	"github.com/hashicorp/go-hclog"
+)
+
+// StageCacheImageName is the Docker image name of the cached dependency stage images.
+// The images are tagged with the stage cache key.
+const StageCacheImageName = "firebuild-stage"
+
+// StageCachePolicy is the eviction policy of the dependency stage image cache.
+type StageCachePolicy struct {
+	// MaxImages is the maximum number of the cached images, 0 means no limit.
+	MaxImages int
+	// TTL is how long a cached image is kept since it was last used, 0 means forever.
+	TTL time.Duration
+}
+
+type stageCacheEntry struct {
+	key      string
+	lastUsed time.Time
+}
+
+func stageCacheTag(key string) string {
+	return fmt.Sprintf("%s:%s", StageCacheImageName, key)
+}
+
+// stageCacheKey computes the cache key of the stage from the stage Dockerfile
+// and the contents of the ADD and COPY sources from the context directory.
+// The base image is identified by the FROM reference only, an updated image
+// behind the same reference does not invalidate the cache.
+// Images built for an explicit platform are cached separately from the host platform images.
+func (ddb *defaultDependencyBuild) stageCacheKey() (string, error) {
+	hash := sha256.New()
+	if ddb.dockerConfig.Platform != "" {
+		fmt.Fprintf(hash, "platform:%s\n", ddb.dockerConfig.Platform)
+	}
+	for _, line := range ddb.getDependencyDockerfileContent() {
+		fmt.Fprintf(hash, "%s\n", line)
+	}
+	for _, cmd := range ddb.stage.Commands() {
+		source := ""
+		switch tcmd := cmd.(type) {
+		case commands.Add:
+			source = tcmd.Source
+		case commands.Copy:
+			if tcmd.Stage != "" {
+				continue
+			}
+			source = tcmd.Source
+		default:
+			continue
+		}
+		if strings.Contains(source, "://") {
+			// remote resources are identified by the URL:
+			fmt.Fprintf(hash, "url:%s\n", source)
+			continue
+		}
+		if err := hashContextSource(hash, ddb.contextDirectory, source); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashContextSource writes the paths, modes and contents of the files matching the source to the hash.
+func hashContextSource(hash io.Writer, contextDirectory, source string) error {
+	matches, err := filepath.Glob(filepath.Join(contextDirectory, source))
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		fmt.Fprintf(hash, "missing:%s\n", source)
+		return nil
+	}
+	sort.Strings(matches)
+	for _, match := range matches {
+		walkErr := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			relativePath, err := filepath.Rel(contextDirectory, path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s:%s\n", relativePath, info.Mode())
+			switch {
+			case info.Mode()&fs.ModeSymlink != 0:
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hash, "link:%s\n", target)
+			case info.Mode().IsRegular():
+				file, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				_, copyErr := io.Copy(hash, file)
+				file.Close()
+				if copyErr != nil {
+					return copyErr
+				}
+			}
+			return nil
+		})
+		if walkErr != nil {
+			return walkErr
+		}
+	}
+	return nil
+}
+
+// touchStageCacheEntry records the use of the cached image, the modification time
+// of the index entry is the last use time.
+func touchStageCacheEntry(indexDirectory, key string) error {
+	if err := os.MkdirAll(indexDirectory, 0755); err != nil {
+		return err
+	}
+	entryPath := filepath.Join(indexDirectory, key)
+	now := time.Now()
+	if err := os.Chtimes(entryPath, now, now); err == nil {
+		return nil
+	}
+	return ioutil.WriteFile(entryPath, []byte{}, 0644)
+}
+
+// selectStageCacheEvictions returns the keys of the entries to evict:
+// the entries not used within the TTL and the least recently used entries above the maximum.
+func selectStageCacheEvictions(entries []stageCacheEntry, policy StageCachePolicy, now time.Time) []string {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.After(entries[j].lastUsed)
+	})
+	evictions := []string{}
+	for idx, entry := range entries {
+		if policy.TTL > 0 && now.Sub(entry.lastUsed) > policy.TTL {
+			evictions = append(evictions, entry.key)
+			continue
+		}
+		if policy.MaxImages > 0 && idx >= policy.MaxImages {
+			evictions = append(evictions, entry.key)
+		}
+	}
+	return evictions
+}
+
+// EvictStageCache removes the cached dependency stage images according to the policy.
+// Cached images without an index entry are removed.
+// Returns the number of removed images.
+func EvictStageCache(ctx context.Context, client *docker.Client, logger hclog.Logger, indexDirectory string, policy StageCachePolicy) (int, error) {
+	tags, err := containers.ListImageTags(ctx, client, StageCacheImageName)
+	if err != nil {
+		return 0, err
+	}
+	entries := []stageCacheEntry{}
+	evictions := []string{}
+	for _, tag := range tags {
+		key := strings.TrimPrefix(tag, StageCacheImageName+":")
+		if key == tag {
+			continue
+		}
+		stat, err := os.Stat(filepath.Join(indexDirectory, key))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return 0, err
+			}
+			evictions = append(evictions, key)
+			continue
+		}
+		entries = append(entries, stageCacheEntry{key: key, lastUsed: stat.ModTime()})
+	}
+	evictions = append(evictions, selectStageCacheEvictions(entries, policy, time.Now())...)
+	removed := 0
+	for _, key := range evictions {
+		logger.Debug("evicting cached stage image", "key", key)
+		if err := containers.ImageRemove(ctx, client, logger, stageCacheTag(key), ""); err != nil {
+			return removed, err
+		}
+		if err := os.Remove(filepath.Join(indexDirectory, key)); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = removed + 1
+	}
+	return removed, nil
+}
@@ -0,0 +1,102 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/build/reader"
+	"github.com/combust-labs/firebuild/pkg/build/stage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStageCacheKey(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("Expected temp directory but received an error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	contextDir := filepath.Join(tempDir, "context")
+	assert.Nil(t, os.MkdirAll(filepath.Join(contextDir, "src"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(contextDir, "src", "main.go"), []byte("package main"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(contextDir, "README"), []byte("readme"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte(`FROM golang:1.16 as builder
+COPY src /src
+RUN go build -o /out/main /src/main.go
+
+FROM alpine:3.13
+COPY --from=builder /out/main /main
+`), 0644))
+
+	readResults, err := reader.ReadFromString(filepath.Join(contextDir, "Dockerfile"), tempDir)
+	assert.Nil(t, err)
+	stages, errs := stage.ReadStages(readResults.Commands())
+	assert.Empty(t, errs)
+	builderStage := stages.NamedStage("builder")
+	if builderStage == nil {
+		t.Fatal("Expected builder stage but found none")
+	}
+
+	stageKey := func() string {
+		key, err := NewDefaultDependencyBuild(builderStage, tempDir, contextDir).(*defaultDependencyBuild).stageCacheKey()
+		if err != nil {
+			t.Fatal("Expected stage cache key, got error", err)
+		}
+		return key
+	}
+
+	initialKey := stageKey()
+	assert.Equal(t, 64, len(initialKey))
+	assert.Equal(t, initialKey, stageKey(), "expected the key to be stable")
+
+	// files not used by the stage do not change the key:
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(contextDir, "README"), []byte("changed readme"), 0644))
+	assert.Equal(t, initialKey, stageKey())
+
+	// changed sources change the key:
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(contextDir, "src", "main.go"), []byte("package main // changed"), 0644))
+	changedKey := stageKey()
+	assert.NotEqual(t, initialKey, changedKey)
+
+	// new source files change the key:
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(contextDir, "src", "util.go"), []byte("package main"), 0644))
+	assert.NotEqual(t, changedKey, stageKey())
+}
+
+func TestSelectStageCacheEvictions(t *testing.T) {
+	now := time.Now()
+	entries := []stageCacheEntry{
+		{key: "expired", lastUsed: now.Add(-time.Hour * 48)},
+		{key: "newest", lastUsed: now.Add(-time.Minute)},
+		{key: "older", lastUsed: now.Add(-time.Hour * 3)},
+		{key: "old", lastUsed: now.Add(-time.Hour * 2)},
+	}
+
+	assert.Equal(t, []string{"expired"},
+		selectStageCacheEvictions(entries, StageCachePolicy{TTL: time.Hour * 24}, now))
+	assert.Equal(t, []string{"older", "expired"},
+		selectStageCacheEvictions(entries, StageCachePolicy{MaxImages: 2}, now))
+	assert.Equal(t, []string{"older", "expired"},
+		selectStageCacheEvictions(entries, StageCachePolicy{MaxImages: 2, TTL: time.Hour * 24}, now))
+	assert.Empty(t, selectStageCacheEvictions(entries, StageCachePolicy{}, now))
+}
+
+func TestTouchStageCacheEntry(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("Expected temp directory but received an error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	indexDir := filepath.Join(tempDir, "stage-cache")
+	assert.Nil(t, touchStageCacheEntry(indexDir, "key"))
+	past := time.Now().Add(-time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(indexDir, "key"), past, past))
+	assert.Nil(t, touchStageCacheEntry(indexDir, "key"))
+	stat, err := os.Stat(filepath.Join(indexDir, "key"))
+	assert.Nil(t, err)
+	assert.True(t, stat.ModTime().After(past.Add(time.Minute)), "expected the last use time to be updated")
+}
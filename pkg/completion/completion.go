@@ -0,0 +1,108 @@
+package completion
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/profiles"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/storage/resolver"
+	"github.com/combust-labs/firebuild/pkg/vmm"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/cobra"
+)
+
+// Func is the cobra dynamic completion function.
+type Func = func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective)
+
+// RootfsTags returns a completion function listing the rootfs tags stored
+// in the storage of the command. The storage is resolved from the profile, if given.
+// Completion is best effort, errors result in no completions.
+func RootfsTags(profilesConfig *configs.ProfileCommandConfig, storageResolver resolver.Resolver) Func {
+	return func(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		logger := hclog.NewNullLogger()
+		if profilesConfig.Profile != "" {
+			profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+			if err != nil {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			storageResolver.
+				WithConfigurationOverride(profile.GetMergedStorageConfig()).
+				WithTypeOverride(profile.Profile().StorageProvider)
+		}
+		storageImpl, err := storageResolver.GetStorageImpl(logger)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		listingImpl, ok := storageImpl.(storage.ListingProvider)
+		if !ok {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		items, err := listingImpl.ListRootfs()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		result := []string{}
+		// a tag is listed once for every architecture stored:
+		seen := map[string]bool{}
+		for _, item := range items {
+			tag := fmt.Sprintf("%s/%s:%s", item.Org, item.Image, item.Version)
+			if strings.HasPrefix(tag, toComplete) && !seen[tag] {
+				seen[tag] = true
+				result = append(result, tag)
+			}
+		}
+		return result, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// RunningVMMIDs returns a completion function listing the IDs of the VMMs
+// running from the run cache of the command, described by the rootfs tag.
+// The run cache is resolved from the profile, if given.
+// Completion is best effort, errors result in no completions.
+func RunningVMMIDs(profilesConfig *configs.ProfileCommandConfig, runCache *configs.RunCacheConfig) Func {
+	return func(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if profilesConfig.Profile != "" {
+			profile, err := profiles.ReadProfile(profilesConfig.Profile, profilesConfig.ProfileConfDir)
+			if err != nil {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			if err := profile.UpdateConfigs(runCache); err != nil {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+		}
+		return runningVMMIDs(runCache.LocationRuns(), toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func runningVMMIDs(runsDirectory, toComplete string) []string {
+	result := []string{}
+	fileInfos, err := ioutil.ReadDir(runsDirectory)
+	if err != nil {
+		return result
+	}
+	for _, fileInfo := range fileInfos {
+		if !strings.HasPrefix(fileInfo.Name(), toComplete) {
+			continue
+		}
+		vmmMetadata, hasMetadata, err := vmm.FetchMetadataIfExists(filepath.Join(runsDirectory, fileInfo.Name()))
+		if err != nil || !hasMetadata {
+			continue
+		}
+		if running, err := vmmMetadata.PID.IsRunning(); err != nil || !running {
+			continue
+		}
+		if vmmMetadata.Rootfs != nil {
+			result = append(result, fmt.Sprintf("%s\t%s/%s:%s", vmmMetadata.VMMID,
+				vmmMetadata.Rootfs.Image.Org, vmmMetadata.Rootfs.Image.Image, vmmMetadata.Rootfs.Image.Version))
+			continue
+		}
+		result = append(result, vmmMetadata.VMMID)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,104 @@
+package containers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// BaseOSDevNode is a character device node created in the /dev directory of the exported file system.
+type BaseOSDevNode struct {
+	Name  string
+	Mode  os.FileMode
+	Major uint32
+	Minor uint32
+}
+
+var (
+	// ImageBaseOSDevDirs are the directories created in the /dev directory of the exported file system.
+	ImageBaseOSDevDirs = []string{"pts", "shm"}
+	// ImageBaseOSDevNodes are the static device nodes created in the /dev directory of the exported file system.
+	// The /dev directory of the container is a runtime tmpfs and is never copied.
+	// The firebuild guest kernel mounts devtmpfs over /dev at boot (CONFIG_DEVTMPFS_MOUNT),
+	// the static nodes keep the console and the basic devices working with kernels
+	// built without it, until the init system mounts /dev.
+	ImageBaseOSDevNodes = []BaseOSDevNode{
+		{Name: "console", Mode: 0600, Major: 5, Minor: 1},
+		{Name: "null", Mode: 0666, Major: 1, Minor: 3},
+		{Name: "random", Mode: 0666, Major: 1, Minor: 8},
+		{Name: "tty", Mode: 0666, Major: 5, Minor: 0},
+		{Name: "urandom", Mode: 0666, Major: 1, Minor: 9},
+		{Name: "zero", Mode: 0666, Major: 1, Minor: 5},
+	}
+)
+
+// populateBaseOSDevDirectory creates the /dev directory of the exported file system
+// with the static device nodes. Anything other than the expected device node is replaced.
+func populateBaseOSDevDirectory(root string) error {
+	devDirectory := filepath.Join(root, "dev")
+	if err := os.MkdirAll(devDirectory, 0755); err != nil {
+		return err
+	}
+	for _, dir := range ImageBaseOSDevDirs {
+		if err := os.MkdirAll(filepath.Join(devDirectory, dir), 0755); err != nil {
+			return err
+		}
+	}
+	for _, node := range ImageBaseOSDevNodes {
+		nodePath := filepath.Join(devDirectory, node.Name)
+		if checkDevNode(nodePath, node) == nil {
+			continue
+		}
+		if err := os.RemoveAll(nodePath); err != nil {
+			return err
+		}
+		if err := syscall.Mknod(nodePath, syscall.S_IFCHR|uint32(node.Mode), int(mkdev(node.Major, node.Minor))); err != nil {
+			return fmt.Errorf("failed creating device node %q: %v", nodePath, err)
+		}
+		// mknod applies the umask:
+		if err := os.Chmod(nodePath, node.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckBaseOSDevDirectory verifies the /dev directory of the exported file system
+// contains the static device nodes.
+func CheckBaseOSDevDirectory(root string) error {
+	devDirectory := filepath.Join(root, "dev")
+	stat, err := os.Stat(devDirectory)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("%q is not a directory", devDirectory)
+	}
+	for _, node := range ImageBaseOSDevNodes {
+		if err := checkDevNode(filepath.Join(devDirectory, node.Name), node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkDevNode(path string, node BaseOSDevNode) error {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if stat.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("%q is not a character device", path)
+	}
+	if sysStat, ok := stat.Sys().(*syscall.Stat_t); ok && uint64(sysStat.Rdev) != mkdev(node.Major, node.Minor) {
+		return fmt.Errorf("%q is not the %d:%d device", path, node.Major, node.Minor)
+	}
+	return nil
+}
+
+// mkdev encodes the device number the way the Linux kernel does.
+func mkdev(major, minor uint32) uint64 {
+	return uint64(minor&0xff) | uint64(major&0xfff)<<8 |
+		uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32
+}
@@ -0,0 +1,203 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/docker/docker/api/types"
+	docker "github.com/docker/docker/client"
+	dockerArchive "github.com/docker/docker/pkg/archive"
+	"github.com/hashicorp/go-hclog"
+)
+
+// Base OS file system export modes.
+const (
+	// BaseOSExportModeAuto copies the file system with the image tools,
+	// falls back to the archive export when the tools are not available in the image.
+	BaseOSExportModeAuto = "auto"
+	// BaseOSExportModeArchive exports the file system via the Docker API, does not need any tools in the image.
+	BaseOSExportModeArchive = "archive"
+	// BaseOSExportModeExec copies the file system with the shell, find and tar of the image.
+	BaseOSExportModeExec = "exec"
+)
+
+// ImageBaseOSExportMountTargetPrefix is the prefix of the randomized default export mount target.
+const ImageBaseOSExportMountTargetPrefix = "/.firebuild-export-"
+
+var reMountTarget = regexp.MustCompile("^/[a-zA-Z0-9._-]+$")
+
+// ImageBaseOSExportRequiredTools are the tools the exec export mode requires in the image.
+var ImageBaseOSExportRequiredTools = []string{"find", "tar"}
+
+// BaseOSExportOptions configures the base OS file system export.
+type BaseOSExportOptions struct {
+	// Mode is one of the BaseOSExportMode values.
+	Mode string
+	// Shell is the full path to the shell in the image used by the exec export mode.
+	Shell string
+	// MountTarget is the path in the container under which the export directory is mounted.
+	// It must be a top level directory which does not exist in the image.
+	// If empty, a randomized path is used.
+	MountTarget string
+	// Platform is the os/arch platform of the image, empty for the host platform.
+	Platform string
+	// StopTimeout is the time the container is given to stop gracefully before it is killed.
+	StopTimeout time.Duration
+}
+
+// ErrorExportShellNotFound is returned when the base OS export shell does not exist in the image.
+type ErrorExportShellNotFound struct {
+	Image string
+	Shell string
+}
+
+func (e *ErrorExportShellNotFound) Error() string {
+	return fmt.Sprintf("export shell %q not found in image %q, use --export-shell to select a shell available in the image or --export-mode=%s", e.Shell, e.Image, BaseOSExportModeArchive)
+}
+
+// ErrorExportMountTargetExists is returned when the export mount target exists in the image.
+type ErrorExportMountTargetExists struct {
+	Image       string
+	MountTarget string
+}
+
+func (e *ErrorExportMountTargetExists) Error() string {
+	return fmt.Sprintf("export mount target %q exists in image %q, use --export-mount-target to select a path not used by the image", e.MountTarget, e.Image)
+}
+
+// ErrorExportToolsNotFound is returned when the tools required by the exec export mode do not exist in the image.
+type ErrorExportToolsNotFound struct {
+	Image string
+	Tools []string
+}
+
+func (e *ErrorExportToolsNotFound) Error() string {
+	return fmt.Sprintf("image %q does not provide %s required by the exec export, use --export-mode=%s",
+		e.Image, strings.Join(e.Tools, ", "), BaseOSExportModeArchive)
+}
+
+// DefaultBaseOSExportMountTarget returns a randomized export mount target unlikely to exist in any image.
+func DefaultBaseOSExportMountTarget() string {
+	return ImageBaseOSExportMountTargetPrefix + strings.ToLower(utils.RandStringWithDigitsBytes(16))
+}
+
+// ValidateBaseOSExportMountTarget verifies the export mount target is a top level directory
+// safe to use in the export commands and not one of the exported directories.
+func ValidateBaseOSExportMountTarget(mountTarget string) error {
+	if !reMountTarget.MatchString(mountTarget) || mountTarget == "/." || mountTarget == "/.." {
+		return fmt.Errorf("export mount target %q must be a top level directory, for example /firebuild-export", mountTarget)
+	}
+	for _, noCopyDir := range ImageBaseOSExportNoCopyDirs {
+		if mountTarget == noCopyDir {
+			return fmt.Errorf("export mount target %q can't be one of the base OS directories", mountTarget)
+		}
+	}
+	return nil
+}
+
+// baseOSExportCommands returns the exec export commands. The top level directories
+// are matched exactly so the mount target is never copied into itself
+// and no directory of the image is skipped by accident.
+func baseOSExportCommands(mountTarget string) []string {
+	return []string{
+		"for d in $(find / -mindepth 1 -maxdepth 1 -type d); do case \"$d\" in " +
+			mountTarget + ") ;; " +
+			strings.Join(ImageBaseOSExportNoCopyDirs, "|") + ") mkdir \"" + mountTarget + "$d\" ;; " +
+			"*) tar c \"$d\" | tar x -C \"" + mountTarget + "\" ;; " +
+			"esac; done",
+	}
+}
+
+// exportContainerArchive exports the file system of the container via the Docker API
+// and extracts it to the target directory. The container does not have to be running
+// and the image does not have to contain any tools. Only the directories
+// of the ImageBaseOSExportNoCopyDirs are extracted, without their contents.
+func exportContainerArchive(ctx context.Context, client *docker.Client, opLogger hclog.Logger, containerID, mountTarget, target string) error {
+	opLogger.Debug("exporting container file system archive")
+	reader, err := client.ContainerExport(ctx, containerID)
+	if err != nil {
+		return wrapTimeout(ctx, err)
+	}
+	defer reader.Close()
+	if err := dockerArchive.Untar(reader, target, &dockerArchive.TarOptions{
+		ExcludePatterns: archiveExportExcludes(mountTarget),
+	}); err != nil {
+		return wrapTimeout(ctx, err)
+	}
+	// the directories may not exist in the image at all:
+	for _, noCopyDir := range ImageBaseOSExportNoCopyDirs {
+		if err := os.MkdirAll(filepath.Join(target, noCopyDir), 0755); err != nil {
+			return err
+		}
+	}
+	opLogger.Debug("container file system archive exported")
+	return nil
+}
+
+// archiveExportExcludes returns the exclude prefixes of the archive export.
+// The archive entries are relative to the container root.
+func archiveExportExcludes(mountTarget string) []string {
+	excludes := []string{".dockerenv", strings.TrimPrefix(mountTarget, "/") + "/"}
+	for _, noCopyDir := range ImageBaseOSExportNoCopyDirs {
+		excludes = append(excludes, strings.TrimPrefix(noCopyDir, "/")+"/")
+	}
+	return excludes
+}
+
+// missingExportTools probes the running container for the tools required by the exec export mode.
+func missingExportTools(ctx context.Context, client *docker.Client, containerID, shell string) ([]string, error) {
+	missing := []string{}
+	for _, tool := range ImageBaseOSExportRequiredTools {
+		exitCode, err := execExitCode(ctx, client, containerID, []string{shell, "-c", "command -v " + tool})
+		if err != nil {
+			return nil, err
+		}
+		if exitCode != 0 {
+			missing = append(missing, tool)
+		}
+	}
+	return missing, nil
+}
+
+// execExitCode executes the command in the running container and returns the exit code.
+func execExitCode(ctx context.Context, client *docker.Client, containerID string, cmd []string) (int, error) {
+	execIDResponse, err := client.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return 0, wrapTimeout(ctx, err)
+	}
+	hijackedConn, err := client.ContainerExecAttach(ctx, execIDResponse.ID, types.ExecStartCheck{})
+	if err != nil {
+		return 0, wrapTimeout(ctx, err)
+	}
+	defer hijackedConn.Close()
+	if _, err := io.Copy(ioutil.Discard, hijackedConn.Reader); err != nil {
+		return 0, wrapTimeout(ctx, err)
+	}
+	// the exec may still be reported as running right after the output is closed:
+	for {
+		inspect, err := client.ContainerExecInspect(ctx, execIDResponse.ID)
+		if err != nil {
+			return 0, wrapTimeout(ctx, err)
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, wrapTimeout(ctx, ctx.Err())
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package containers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveExportExcludes(t *testing.T) {
+	assert.Equal(t, []string{".dockerenv", ".firebuild-export-abc/",
+		"boot/", "dev/", "opt/", "proc/", "run/", "srv/", "sys/", "tmp/"}, archiveExportExcludes("/.firebuild-export-abc"))
+}
+
+func TestBaseOSExportMountTarget(t *testing.T) {
+	first := DefaultBaseOSExportMountTarget()
+	assert.True(t, strings.HasPrefix(first, ImageBaseOSExportMountTargetPrefix))
+	assert.Nil(t, ValidateBaseOSExportMountTarget(first))
+	assert.NotEqual(t, first, DefaultBaseOSExportMountTarget())
+
+	for _, valid := range []string{"/export-rootfs", "/.firebuild-export-1a2b"} {
+		assert.Nil(t, ValidateBaseOSExportMountTarget(valid), valid)
+	}
+	for _, invalid := range []string{"", "/", "/.", "/..", "export", "/mnt/export", "/dev", "/tmp", "/export rootfs", "/export;rm"} {
+		assert.NotNil(t, ValidateBaseOSExportMountTarget(invalid), invalid)
+	}
+}
+
+func TestBaseOSExportCommands(t *testing.T) {
+	commands := baseOSExportCommands("/.firebuild-export-abc")
+	for _, command := range commands {
+		// nothing is removed from the image or the export:
+		assert.NotContains(t, command, "rm ")
+	}
+	// the mount target must be matched before the catch-all copy:
+	assert.Contains(t, commands[0], "case \"$d\" in /.firebuild-export-abc) ;; /boot|/dev|")
+}
+
+func TestImageBaseOSExportDistroless(t *testing.T) {
+
+	logger := hclog.Default()
+	logger.SetLevel(hclog.Debug)
+
+	dockerClient, err := GetDefaultClient()
+	assert.Nil(t, err)
+
+	// the fixture image has no shell, find or tar:
+	buildID := "distroless-export"
+	tagName := "distroless-export:build"
+	contextDir, err := filepath.Abs(filepath.Join("testdata", "distroless"))
+	assert.Nil(t, err)
+	if err := ImageBuild(context.Background(), dockerClient, logger, contextDir, "Dockerfile", tagName, buildID, "", false); err != nil {
+		t.Fatal("expected distroless fixture image to build, got error", err)
+	}
+	defer ImageRemove(context.Background(), dockerClient, logger, tagName, buildID)
+
+	exportDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(exportDir)
+
+	execErr := ImageBaseOSExport(context.Background(), dockerClient, logger, exportDir, tagName,
+		BaseOSExportOptions{Mode: BaseOSExportModeExec, Shell: "/bin/sh"}, opentracing.NoopTracer{}, nil)
+	assert.IsType(t, &ErrorExportShellNotFound{}, execErr)
+
+	autoErr := ImageBaseOSExport(context.Background(), dockerClient, logger, exportDir, tagName,
+		BaseOSExportOptions{Mode: BaseOSExportModeAuto, Shell: "/bin/sh"}, opentracing.NoopTracer{}, nil)
+	assert.Nil(t, autoErr)
+
+	content, err := ioutil.ReadFile(filepath.Join(exportDir, "etc", "hello.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello from a distroless image\n", string(content))
+	// the no copy directories are created empty:
+	bootEntries, err := ioutil.ReadDir(filepath.Join(exportDir, "boot"))
+	assert.Nil(t, err)
+	assert.Empty(t, bootEntries)
+	_, err = os.Stat(filepath.Join(exportDir, "proc"))
+	assert.Nil(t, err)
+	assert.Nil(t, CheckBaseOSDevDirectory(exportDir))
+}
+
+func TestImageBaseOSExportMountTargetConflict(t *testing.T) {
+
+	logger := hclog.Default()
+	logger.SetLevel(hclog.Debug)
+
+	dockerClient, err := GetDefaultClient()
+	assert.Nil(t, err)
+
+	// the fixture image has content under the former fixed mount target:
+	buildID := "conflict-export"
+	tagName := "conflict-export:build"
+	contextDir, err := filepath.Abs(filepath.Join("testdata", "conflict"))
+	assert.Nil(t, err)
+	if err := ImageBuild(context.Background(), dockerClient, logger, contextDir, "Dockerfile", tagName, buildID, "", false); err != nil {
+		t.Fatal("expected conflict fixture image to build, got error", err)
+	}
+	defer ImageRemove(context.Background(), dockerClient, logger, tagName, buildID)
+
+	exportDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(exportDir)
+
+	conflictErr := ImageBaseOSExport(context.Background(), dockerClient, logger, exportDir, tagName,
+		BaseOSExportOptions{Mode: BaseOSExportModeAuto, MountTarget: "/export-rootfs", Shell: "/bin/sh"}, opentracing.NoopTracer{}, nil)
+	assert.IsType(t, &ErrorExportMountTargetExists{}, conflictErr)
+
+	// the default mount target is randomized and the image content is exported:
+	assert.Nil(t, ImageBaseOSExport(context.Background(), dockerClient, logger, exportDir, tagName,
+		BaseOSExportOptions{Mode: BaseOSExportModeAuto, Shell: "/bin/sh"}, opentracing.NoopTracer{}, nil))
+	content, err := ioutil.ReadFile(filepath.Join(exportDir, "export-rootfs", "hello.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "image content under the legacy export mount target\n", string(content))
+}
+
+func TestPopulateBaseOSDevDirectory(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating device nodes requires root")
+	}
+
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(root)
+
+	assert.NotNil(t, CheckBaseOSDevDirectory(root), "expected missing /dev to fail the check")
+
+	// the Docker init layer leaves a regular file placeholder for the console:
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "dev"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "dev", "console"), []byte{}, 0644))
+	assert.NotNil(t, CheckBaseOSDevDirectory(root), "expected regular file console to fail the check")
+
+	assert.Nil(t, populateBaseOSDevDirectory(root))
+	assert.Nil(t, CheckBaseOSDevDirectory(root))
+	for _, dir := range ImageBaseOSDevDirs {
+		stat, err := os.Stat(filepath.Join(root, "dev", dir))
+		assert.Nil(t, err)
+		assert.True(t, stat.IsDir())
+	}
+	stat, err := os.Stat(filepath.Join(root, "dev", "null"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0666), stat.Mode().Perm())
+
+	// populating again keeps the existing nodes:
+	assert.Nil(t, populateBaseOSDevDirectory(root))
+	assert.Nil(t, CheckBaseOSDevDirectory(root))
+}
@@ -24,30 +24,33 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/strslice"
 	docker "github.com/docker/docker/client"
 	dockerArchive "github.com/docker/docker/pkg/archive"
 )
 
+// ImageBuildIDLabel is the label applied to the Docker images built by firebuild.
+// The label value is the ID of the build which created the image so the lookups
+// of concurrent builds do not pick up each other's images.
+const ImageBuildIDLabel = "com.combust-labs.firebuild.build-id"
+
+// ErrImageNotFound is returned when the Docker image lookup does not find the image.
+var ErrImageNotFound = errors.New("image not found")
+
 var (
-	// ContainerStopTimeout is the amount of time the container is given to stop gracefully.
-	ContainerStopTimeout = time.Duration(time.Second * 30)
-	// ImageBaseOSExportCommand is the command to execute when starting the base OS file system export container.
-	ImageBaseOSExportCommand = []string{"/bin/sh"}
-	// ImageBaseOSExportExecShell is the shell used to execute the docker exec commands.
-	ImageBaseOSExportExecShell = []string{"/bin/sh", "-c"}
 	// ImageBaseOSExportFsCopyExecTimeout is the amount of time the exec command has to work on the base operating system file system copy.
 	ImageBaseOSExportFsCopyExecTimeout = time.Duration(time.Second * 15)
-	// ImageBaseOSExportMountTarget is the path under which the volume where the file system is exported to will be mounted in the container.
-	ImageBaseOSExportMountTarget = "/export-rootfs"
 	// ImageBaseOSExportNoCopyDirs is a list of base operating system exported file system directories
 	// for which no contents must be copied, the directory must only be created.
 	// These are used in the following way:
 	// - for every directory in base OS file system root (`find / -maxdepth 1 -type d`)
 	//   - if directory exists in the list, just create the directory
-	//   - if directory does not exist in the list and is not /, copy complete by preserving inode attributes
-	ImageBaseOSExportNoCopyDirs = []string{"/boot", "/opt", "/proc", "/run", "/srv", "/sys", "/tmp"}
+	//   - if directory is the export mount target, skip it
+	//   - if directory does not exist in the list, copy complete by preserving inode attributes
+	// The /dev directory is populated with the ImageBaseOSDevNodes after the copy.
+	ImageBaseOSExportNoCopyDirs = []string{"/boot", "/dev", "/opt", "/proc", "/run", "/srv", "/sys", "/tmp"}
 )
 
 // GetDefaultClient returns a default instance of the Docker client.
@@ -57,9 +60,20 @@ func GetDefaultClient() (*docker.Client, error) {
 
 // FindImageIDByTag looks up the Docker image ID given a tag name.
 func FindImageIDByTag(ctx context.Context, client *docker.Client, requiredTag string) (string, error) {
-	images, err := client.ImageList(ctx, types.ImageListOptions{All: true})
+	return FindImageIDByTagAndBuildID(ctx, client, requiredTag, "")
+}
+
+// FindImageIDByTagAndBuildID looks up the Docker image ID given a tag name
+// considering only the images labelled with the build ID.
+// If the build ID is empty, all images are considered.
+func FindImageIDByTagAndBuildID(ctx context.Context, client *docker.Client, requiredTag, buildID string) (string, error) {
+	listOptions := types.ImageListOptions{All: true}
+	if buildID != "" {
+		listOptions.Filters = filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", ImageBuildIDLabel, buildID)))
+	}
+	images, err := client.ImageList(ctx, listOptions)
 	if err != nil {
-		return "", err
+		return "", wrapTimeout(ctx, err)
 	}
 	for _, img := range images {
 		for _, tag := range img.RepoTags {
@@ -68,7 +82,22 @@ func FindImageIDByTag(ctx context.Context, client *docker.Client, requiredTag st
 			}
 		}
 	}
-	return "", fmt.Errorf("image not found")
+	return "", ErrImageNotFound
+}
+
+// ListImageTags returns the tags of the Docker images matching the reference, for example an image name.
+func ListImageTags(ctx context.Context, client *docker.Client, reference string) ([]string, error) {
+	images, err := client.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", reference)),
+	})
+	if err != nil {
+		return nil, wrapTimeout(ctx, err)
+	}
+	tags := []string{}
+	for _, img := range images {
+		tags = append(tags, img.RepoTags...)
+	}
+	return tags, nil
 }
 
 // ImageBaseOSExport exports the base operating system file system.
@@ -77,10 +106,44 @@ func FindImageIDByTag(ctx context.Context, client *docker.Client, requiredTag st
 // contains the contents of the base OS Docker image.
 // The contents are copied via docker exec commands.
 // Once the file system is exported, the function stops the container and removes it.
+// The container runs the export shell and the copy commands are executed with `shell -c`.
+// If the image does not provide the shell or the tools, for example distroless images,
+// the auto export mode falls back to the archive export via the Docker API.
+// The /dev directory is not copied, it is populated with static device nodes and verified.
 func ImageBaseOSExport(ctx context.Context, client *docker.Client, logger hclog.Logger, path, tagName string,
-	tracer opentracing.Tracer, spanContext opentracing.SpanContext) error {
+	options BaseOSExportOptions, tracer opentracing.Tracer, spanContext opentracing.SpanContext) error {
+	if err := exportBaseOSFileSystem(ctx, client, logger, path, tagName, options, tracer, spanContext); err != nil {
+		return err
+	}
+	if err := populateBaseOSDevDirectory(path); err != nil {
+		logger.Error("failed populating the /dev directory", "tag-name", tagName, "reason", err)
+		return err
+	}
+	if err := CheckBaseOSDevDirectory(path); err != nil {
+		logger.Error("exported /dev directory is invalid", "tag-name", tagName, "reason", err)
+		return err
+	}
+	return nil
+}
+
+func exportBaseOSFileSystem(ctx context.Context, client *docker.Client, logger hclog.Logger, path, tagName string,
+	options BaseOSExportOptions, tracer opentracing.Tracer, spanContext opentracing.SpanContext) error {
 
-	opLogger := logger.With("tag-name", tagName)
+	exportShell := options.Shell
+	exportMode := options.Mode
+	if exportMode == "" {
+		exportMode = BaseOSExportModeAuto
+	}
+
+	mountTarget := options.MountTarget
+	if mountTarget == "" {
+		mountTarget = DefaultBaseOSExportMountTarget()
+	}
+	if err := ValidateBaseOSExportMountTarget(mountTarget); err != nil {
+		return err
+	}
+
+	opLogger := logger.With("tag-name", tagName, "export-shell", exportShell, "export-mode", exportMode, "mount-target", mountTarget, "platform", options.Platform)
 
 	cleanup := utils.NewDefers()
 	defer cleanup.CallAll()
@@ -88,7 +151,7 @@ func ImageBaseOSExport(ctx context.Context, client *docker.Client, logger hclog.
 	containerConfig := &container.Config{
 		OpenStdin: true,
 		Tty:       true,
-		Cmd:       strslice.StrSlice(ImageBaseOSExportCommand),
+		Cmd:       strslice.StrSlice([]string{exportShell}),
 		Image:     tagName,
 	}
 
@@ -97,25 +160,23 @@ func ImageBaseOSExport(ctx context.Context, client *docker.Client, logger hclog.
 			{
 				Type:   mount.TypeBind,
 				Source: path,
-				Target: ImageBaseOSExportMountTarget,
+				Target: mountTarget,
 			},
 		},
 	}
 
-	// Doing this export through TAR, without starting the container, is much more work
-	// than simply starting a container. When doing this by doing image export,
-	// there is a need to:
-	// - track links manually
-	// - track symlinks manually
-	// - all the chmod, chown, chtimes accounting
-	// - special devices aren't properly carried through
+	// The exec export is preferred, the copy runs inside of the container with the image tools.
+	// The archive export extracts the container export stream on the host, links, ownership,
+	// modes and special files are created by the host extraction running as root, it is the fallback
+	// for images without the tools required by the exec export.
+	// Both preserve device nodes and FIFOs outside of /dev, /dev itself is never copied.
 
 	opLogger.Debug("starting base OS Docker container for rootfs export")
 
-	containerCreateResponse, startErr := client.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, "")
+	containerCreateResponse, startErr := client.ContainerCreate(ctx, containerConfig, hostConfig, nil, dockerPlatform(options.Platform), "")
 	if startErr != nil {
 		opLogger.Error("failed creating a Docker container", "reason", startErr)
-		return startErr
+		return wrapTimeout(ctx, startErr)
 	}
 
 	cleanup.Add(func() {
@@ -126,33 +187,66 @@ func ImageBaseOSExport(ctx context.Context, client *docker.Client, logger hclog.
 	})
 
 	opLogger = opLogger.With("container-id", containerCreateResponse.ID)
-	opLogger.Debug("container started")
+	opLogger.Debug("container created")
+
+	// the mount target would hide the image contents at the same path:
+	if _, statErr := client.ContainerStatPath(ctx, containerCreateResponse.ID, mountTarget); statErr == nil {
+		opLogger.Error("export mount target exists in the image")
+		return &ErrorExportMountTargetExists{Image: tagName, MountTarget: mountTarget}
+	} else if !docker.IsErrNotFound(statErr) {
+		opLogger.Error("failed checking the export mount target", "reason", statErr)
+		return wrapTimeout(ctx, statErr)
+	}
+
+	if exportMode != BaseOSExportModeArchive {
+		// the container file system is available before the container is started,
+		// verify the shell exists so the start does not fail with a cryptic error:
+		if _, statErr := client.ContainerStatPath(ctx, containerCreateResponse.ID, exportShell); statErr != nil {
+			if !docker.IsErrNotFound(statErr) {
+				opLogger.Error("failed checking the export shell", "reason", statErr)
+				return wrapTimeout(ctx, statErr)
+			}
+			if exportMode == BaseOSExportModeExec {
+				opLogger.Error("export shell not found in the image")
+				return &ErrorExportShellNotFound{Image: tagName, Shell: exportShell}
+			}
+			opLogger.Info("export shell not found in the image, falling back to the archive export")
+			exportMode = BaseOSExportModeArchive
+		}
+	}
+
+	if exportMode == BaseOSExportModeArchive {
+		return exportContainerArchive(ctx, client, opLogger, containerCreateResponse.ID, mountTarget, path)
+	}
 
 	if err := client.ContainerStart(ctx, containerCreateResponse.ID, types.ContainerStartOptions{}); err != nil {
 		opLogger.Error("failed starting a Docker container", "reason", err)
-		return err
+		return wrapTimeout(ctx, err)
 	}
 
 	cleanup.Add(func() {
 		span := tracer.StartSpan("docker-stop-container", opentracing.ChildOf(spanContext))
 		span.SetTag("container-id", containerCreateResponse.ID)
-		stopContainer(context.Background(), client, logger, containerCreateResponse.ID)
+		stopContainer(context.Background(), client, logger, containerCreateResponse.ID, options.StopTimeout)
 		span.Finish()
 	})
 
-	bareList := strings.Join(ImageBaseOSExportNoCopyDirs, " ")
-	dirsNoCopyList := "LIST=\"" + strings.Join([]string{"/", ImageBaseOSExportMountTarget}, " ") + bareList + "\"; "
-	mkdirOnlyDirsStr := "LIST=\"" + bareList + "\"; "
-
-	commands := []string{
-		// these ones should have an empty directory only:
-		mkdirOnlyDirsStr + "for d in $(find / -maxdepth 1 -type d); do if echo $LIST | grep -w $d > /dev/null; then mkdir " + ImageBaseOSExportMountTarget + "${d}; fi; done; exit 0",
-		// these are the ones I want to copy, when they don't exist in the list:
-		dirsNoCopyList + "for d in $(find / -maxdepth 1 -type d); do if echo $LIST | grep -v -w $d > /dev/null; then tar c \"$d\" | tar x -C " + ImageBaseOSExportMountTarget + "; fi; done",
-		// clean up:
-		fmt.Sprintf("rm -r %s/%s", ImageBaseOSExportMountTarget, ImageBaseOSExportMountTarget),
+	missingTools, probeErr := missingExportTools(ctx, client, containerCreateResponse.ID, exportShell)
+	if probeErr != nil {
+		opLogger.Error("failed probing the export tools", "reason", probeErr)
+		return probeErr
+	}
+	if len(missingTools) > 0 {
+		if exportMode == BaseOSExportModeExec {
+			opLogger.Error("export tools not found in the image", "missing", strings.Join(missingTools, ","))
+			return &ErrorExportToolsNotFound{Image: tagName, Tools: missingTools}
+		}
+		opLogger.Info("export tools not found in the image, falling back to the archive export", "missing", strings.Join(missingTools, ","))
+		return exportContainerArchive(ctx, client, opLogger, containerCreateResponse.ID, mountTarget, path)
 	}
 
+	commands := baseOSExportCommands(mountTarget)
+
 	for idx, command := range commands {
 
 		opLogger.Debug(fmt.Sprintf("running exec %d of %d", idx+1, len(commands)))
@@ -160,14 +254,11 @@ func ImageBaseOSExport(ctx context.Context, client *docker.Client, logger hclog.
 		execIDResponse, execErr := client.ContainerExecCreate(ctx, containerCreateResponse.ID, types.ExecConfig{
 			AttachStdout: true,
 			AttachStderr: true,
-			Cmd: func() []string {
-				cmd := ImageBaseOSExportExecShell
-				return append(cmd, command)
-			}(),
+			Cmd:          []string{exportShell, "-c", command},
 		})
 		if execErr != nil {
 			opLogger.Error("error creating exec", "container-id", containerCreateResponse.ID, "reason", execErr)
-			return execErr
+			return wrapTimeout(ctx, execErr)
 		}
 
 		hijackedConn, execAttachErr := client.ContainerExecAttach(ctx, execIDResponse.ID, types.ExecStartCheck{
@@ -175,7 +266,7 @@ func ImageBaseOSExport(ctx context.Context, client *docker.Client, logger hclog.
 		})
 		if execAttachErr != nil {
 			opLogger.Error("error attaching exec", "reason", execAttachErr)
-			return execAttachErr
+			return wrapTimeout(ctx, execAttachErr)
 		}
 
 		chanDone := make(chan struct{}, 1)
@@ -225,14 +316,16 @@ func ImageBaseOSExport(ctx context.Context, client *docker.Client, logger hclog.
 }
 
 // ImageBuild builds a Docker image in the context os source directory, using Dockerfile from dockerfilePath
-// and tags the image as tag.
-func ImageBuild(ctx context.Context, client *docker.Client, logger hclog.Logger, source, dockerfilePath, tagName string) error {
+// and tags the image as tag. The image is labelled with the build ID.
+// If platform is not empty, the image is built for the platform, emulated if the platform differs from the host.
+// If keepContainers is true, the intermediate containers are not removed, even if the build fails.
+func ImageBuild(ctx context.Context, client *docker.Client, logger hclog.Logger, source, dockerfilePath, tagName, buildID, platform string, keepContainers bool) error {
 
 	if !strings.HasSuffix(source, "/") {
 		source = fmt.Sprintf("%s/", source)
 	}
 
-	opLogger := logger.With("dir-context", source, "dockerfile", dockerfilePath, "tag-name", tagName)
+	opLogger := logger.With("dir-context", source, "dockerfile", dockerfilePath, "tag-name", tagName, "build-id", buildID, "platform", platform)
 
 	// convert the context into a tar:
 	tar, err := dockerArchive.TarWithOptions(source, &dockerArchive.TarOptions{})
@@ -245,16 +338,18 @@ func ImageBuild(ctx context.Context, client *docker.Client, logger hclog.Logger,
 	// build the image:
 	buildResponse, buildErr := client.ImageBuild(ctx, tar, types.ImageBuildOptions{
 		Dockerfile:  dockerfilePath,
+		Labels:      map[string]string{ImageBuildIDLabel: buildID},
 		Tags:        []string{tagName},
-		ForceRemove: true,
-		Remove:      true,
+		ForceRemove: !keepContainers,
+		Remove:      !keepContainers,
+		Platform:    platform,
 	})
 	if buildErr != nil {
 		opLogger.Error("failed creating Docker image", "reason", buildErr)
-		return buildErr
+		return wrapTimeout(ctx, buildErr)
 	}
 
-	return processDockerOutput(opLogger, buildResponse.Body, dockerReaderStream())
+	return wrapTimeout(ctx, processDockerOutput(opLogger, buildResponse.Body, dockerReaderStream()))
 }
 
 // ImageExportStageDependentResources exports resources from a given Docker image indicated by tag.
@@ -265,9 +360,9 @@ func ImageBuild(ctx context.Context, client *docker.Client, logger hclog.Logger,
 // resource, the function does not return resolved resources pointing at directories.
 func ImageExportStageDependentResources(ctx context.Context, client *docker.Client, logger hclog.Logger,
 	stage stage.Stage,
-	exportsRoot string, externalCopies []commands.Copy, tagName string) ([]resources.ResolvedResource, error) {
+	exportsRoot string, externalCopies []commands.Copy, tagName, buildID string) ([]resources.ResolvedResource, error) {
 
-	opLogger := logger.With("exports-root", exportsRoot, "tag-name", tagName)
+	opLogger := logger.With("exports-root", exportsRoot, "tag-name", tagName, "build-id", buildID)
 
 	resolvedResources := []resources.ResolvedResource{}
 	opCopies := []*ImageResourceExportCommand{}
@@ -296,22 +391,53 @@ func ImageExportStageDependentResources(ctx context.Context, client *docker.Clie
 		return resolvedResources, err
 	}
 
-	return ImageExportResources(ctx, client, opLogger, exportsRoot, opCopies, tagName)
+	opLogger.Debug("exporting Docker stage build image")
+	imageID, err := FindImageIDByTagAndBuildID(ctx, client, tagName, buildID)
+	if err != nil {
+		opLogger.Error("failed fetching Docker image ID by tag", "reason", err)
+		return resolvedResources, err
+	}
+
+	exportedResources, err := imageExportResourcesByID(ctx, client, opLogger, exportsRoot, opCopies, imageID)
+	return exportedResources, wrapTimeout(ctx, err)
 }
 
 // ImageExportResources exports selected resources from a Docker image.
 func ImageExportResources(ctx context.Context, client *docker.Client, opLogger hclog.Logger,
 	exportsRoot string, opCopies []*ImageResourceExportCommand, tagName string) ([]resources.ResolvedResource, error) {
 
-	resolvedResources := []resources.ResolvedResource{}
-
-	opLogger.Debug("exporting Docker stage build image")
+	opLogger.Debug("exporting Docker image")
 	imageID, err := FindImageIDByTag(ctx, client, tagName)
 	if err != nil {
 		opLogger.Error("failed fetching Docker image ID by tag", "reason", err)
-		return resolvedResources, err
+		return []resources.ResolvedResource{}, err
 	}
 
+	exportedResources, err := imageExportResourcesByID(ctx, client, opLogger, exportsRoot, opCopies, imageID)
+	return exportedResources, wrapTimeout(ctx, err)
+}
+
+// ImageExportResourcesByReference exports selected resources from a Docker image
+// referenced by name, tag or digest, as understood by the Docker daemon.
+func ImageExportResourcesByReference(ctx context.Context, client *docker.Client, opLogger hclog.Logger,
+	exportsRoot string, opCopies []*ImageResourceExportCommand, refStr string) ([]resources.ResolvedResource, error) {
+
+	opLogger.Debug("exporting Docker image")
+	inspect, _, err := client.ImageInspectWithRaw(ctx, refStr)
+	if err != nil {
+		opLogger.Error("failed inspecting Docker image", "reason", err)
+		return []resources.ResolvedResource{}, wrapTimeout(ctx, err)
+	}
+
+	exportedResources, err := imageExportResourcesByID(ctx, client, opLogger, exportsRoot, opCopies, inspect.ID)
+	return exportedResources, wrapTimeout(ctx, err)
+}
+
+func imageExportResourcesByID(ctx context.Context, client *docker.Client, opLogger hclog.Logger,
+	exportsRoot string, opCopies []*ImageResourceExportCommand, imageID string) ([]resources.ResolvedResource, error) {
+
+	resolvedResources := []resources.ResolvedResource{}
+
 	opLogger = opLogger.With("image-id", imageID)
 
 	dockerFsReader, cleanupFunc, err := getImageReader(ctx, client, imageID)
@@ -496,23 +622,28 @@ func ImageExportResources(ctx context.Context, client *docker.Client, opLogger h
 	return resolvedResources, nil
 }
 
-// ImagePull pulls a Docker image.
-func ImagePull(ctx context.Context, client *docker.Client, logger hclog.Logger, refStr string) error {
-	response, err := client.ImagePull(ctx, refStr, types.ImagePullOptions{All: false})
+// ImagePull pulls a Docker image. If platform is not empty, the image variant for the platform is pulled.
+// The credentials of the image registry, if any, are used to authenticate with the registry.
+func ImagePull(ctx context.Context, client *docker.Client, logger hclog.Logger, refStr, platform string, auths *RegistryAuths) error {
+	registryAuth, err := auths.ForImage(refStr)
 	if err != nil {
 		return err
 	}
+	response, err := client.ImagePull(ctx, refStr, types.ImagePullOptions{All: false, Platform: platform, RegistryAuth: registryAuth})
+	if err != nil {
+		return wrapTimeout(ctx, err)
+	}
 	if err := processDockerOutput(logger.Named("image-pull"), response, dockerReaderStatus()); err != nil {
-		return err
+		return wrapTimeout(ctx, err)
 	}
 	return nil
 }
 
-// ImageRemove removes the Docker image using the tag name.
-func ImageRemove(ctx context.Context, client *docker.Client, logger hclog.Logger, tagName string) error {
-	opLogger := logger.With("tag-name", tagName)
+// ImageRemove removes the Docker image using the tag name and the build ID.
+func ImageRemove(ctx context.Context, client *docker.Client, logger hclog.Logger, tagName, buildID string) error {
+	opLogger := logger.With("tag-name", tagName, "build-id", buildID)
 	opLogger.Debug("removing Docker stage build image")
-	imageID, err := FindImageIDByTag(ctx, client, tagName)
+	imageID, err := FindImageIDByTagAndBuildID(ctx, client, tagName, buildID)
 	if err != nil {
 		opLogger.Error("failed fetching Docker image ID by tag", tagName, "reason", err)
 		return err
@@ -522,7 +653,7 @@ func ImageRemove(ctx context.Context, client *docker.Client, logger hclog.Logger
 		opLogger.Error("failed removing Docker image by",
 			"image-id", imageID,
 			"reason", err)
-		return err
+		return wrapTimeout(ctx, err)
 	}
 	for _, response := range responses {
 		opLogger.Debug("Docker image removal status",
@@ -552,6 +683,9 @@ func ReadImageConfig(ctx context.Context, client *docker.Client, opLogger hclog.
 	defer cleanupFunc()
 
 	jsonEntries := map[string]string{}
+	entrySizes := map[string]int64{}
+	// a layer shared within the image is saved once, other entries link to it:
+	entryLinks := map[string]string{}
 
 	for {
 		dockerFsHeader, dockerFsError := dockerFsReader.Next()
@@ -560,7 +694,16 @@ func ReadImageConfig(ctx context.Context, client *docker.Client, opLogger hclog.
 				break
 			}
 			opLogger.Error("error while reading exported Docker file system", "reason", dockerFsError)
-			return nil, dockerFsError
+			return nil, wrapTimeout(ctx, dockerFsError)
+		}
+
+		switch dockerFsHeader.Typeflag {
+		case tar.TypeReg:
+			entrySizes[dockerFsHeader.Name] = dockerFsHeader.Size
+		case tar.TypeSymlink:
+			entryLinks[dockerFsHeader.Name] = filepath.Join(filepath.Dir(dockerFsHeader.Name), dockerFsHeader.Linkname)
+		case tar.TypeLink:
+			entryLinks[dockerFsHeader.Name] = dockerFsHeader.Linkname
 		}
 
 		// only interested in json files in the top directory:
@@ -596,6 +739,21 @@ func ReadImageConfig(ctx context.Context, client *docker.Client, opLogger hclog.
 	}
 	response.Manifest = manifestsOutput[0]
 
+	response.LayerSizes = map[string]int64{}
+	for _, layer := range response.Manifest.Layers {
+		entry := layer
+		for i := 0; i < len(entryLinks); i++ {
+			target, ok := entryLinks[entry]
+			if !ok {
+				break
+			}
+			entry = target
+		}
+		if size, ok := entrySizes[entry]; ok {
+			response.LayerSizes[layer] = size
+		}
+	}
+
 	imageConfig, ok := jsonEntries[response.Manifest.Config]
 	if !ok {
 		return nil, fmt.Errorf("manifest.json declared %q as config but config not found in image", response.Manifest.Config)
@@ -632,10 +790,18 @@ func removeContainer(ctx context.Context, client *docker.Client, opLogger hclog.
 	}
 }
 
-func stopContainer(ctx context.Context, client *docker.Client, opLogger hclog.Logger, containerID string) {
-	opLogger.Debug("stopping container")
+// containerStopper is the subset of the Docker client required to stop a container.
+type containerStopper interface {
+	ContainerKill(ctx context.Context, containerID, signal string) error
+	ContainerStop(ctx context.Context, containerID string, timeout *time.Duration) error
+	ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error)
+}
+
+// stopContainer stops the container, the container is given the timeout to stop gracefully before it is killed.
+func stopContainer(ctx context.Context, client containerStopper, opLogger hclog.Logger, containerID string, timeout time.Duration) {
+	opLogger.Debug("stopping container", "timeout", timeout.String())
 	go func() {
-		if stopError := client.ContainerStop(ctx, containerID, &ContainerStopTimeout); stopError != nil {
+		if stopError := client.ContainerStop(ctx, containerID, &timeout); stopError != nil {
 			opLogger.Warn("problem stopping the container gracefully, killing", "reason", stopError)
 			if killError := client.ContainerKill(ctx, containerID, "SIGKILL"); killError != nil {
 				opLogger.Warn("container kill also returned an error", "reason", killError)
@@ -655,7 +821,7 @@ func stopContainer(ctx context.Context, client *docker.Client, opLogger hclog.Lo
 func getImageReader(ctx context.Context, client *docker.Client, imageID string) (*tar.Reader, func(), error) {
 	reader, err := client.ImageSave(ctx, []string{imageID})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, wrapTimeout(ctx, err)
 	}
 	return tar.NewReader(reader), func() { reader.Close() }, nil
 }
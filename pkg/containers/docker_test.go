@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/combust-labs/firebuild/pkg/build/reader"
@@ -23,10 +24,10 @@ func TestDockerImagePull(t *testing.T) {
 	dockerClient, err := GetDefaultClient()
 	assert.Nil(t, err)
 
-	pullExpectedErr := ImagePull(context.Background(), dockerClient, logger, "alpine/3.13")
+	pullExpectedErr := ImagePull(context.Background(), dockerClient, logger, "alpine/3.13", "", nil)
 	assert.NotNil(t, pullExpectedErr)
 
-	pullErr := ImagePull(context.Background(), dockerClient, logger, "alpine:3.13")
+	pullErr := ImagePull(context.Background(), dockerClient, logger, "alpine:3.13", "", nil)
 	assert.Nil(t, pullErr)
 
 }
@@ -58,7 +59,7 @@ func TestDockerfileFromHistoryWithResourceExport(t *testing.T) {
 	dockerClient, err := GetDefaultClient()
 	assert.Nil(t, err)
 
-	pullErr := ImagePull(context.Background(), dockerClient, logger, "jaegertracing/all-in-one:1.22")
+	pullErr := ImagePull(context.Background(), dockerClient, logger, "jaegertracing/all-in-one:1.22", "", nil)
 	assert.Nil(t, pullErr)
 
 	imageMetadata, readErr := ReadImageConfig(context.Background(), dockerClient, logger, "jaegertracing/all-in-one:1.22")
@@ -122,3 +123,60 @@ func TestDockerfileFromHistoryWithResourceExport(t *testing.T) {
 	}
 
 }
+
+func TestConcurrentBuildsImageLookupIsolation(t *testing.T) {
+
+	logger := hclog.Default()
+	logger.SetLevel(hclog.Debug)
+
+	dockerClient, err := GetDefaultClient()
+	assert.Nil(t, err)
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := ioutil.WriteFile(filepath.Join(tempDir, "Dockerfile"), []byte("FROM alpine:3.13\nRUN echo isolation"), fs.ModePerm); err != nil {
+		t.Fatal("expected Dockerfile to be written, got error", err)
+	}
+
+	buildIDs := []string{"isolation-build-a", "isolation-build-b"}
+	tagName := func(buildID string) string {
+		return buildID + ":build"
+	}
+
+	var wg sync.WaitGroup
+	buildErrs := make(chan error, len(buildIDs))
+	for _, buildID := range buildIDs {
+		wg.Add(1)
+		go func(buildID string) {
+			defer wg.Done()
+			buildErrs <- ImageBuild(context.Background(), dockerClient, logger, tempDir, "Dockerfile", tagName(buildID), buildID, "", false)
+		}(buildID)
+	}
+	wg.Wait()
+	close(buildErrs)
+	for buildErr := range buildErrs {
+		assert.Nil(t, buildErr)
+	}
+
+	defer func() {
+		for _, buildID := range buildIDs {
+			assert.Nil(t, ImageRemove(context.Background(), dockerClient, logger, tagName(buildID), buildID))
+		}
+	}()
+
+	for _, buildID := range buildIDs {
+		_, ownErr := FindImageIDByTagAndBuildID(context.Background(), dockerClient, tagName(buildID), buildID)
+		assert.Nil(t, ownErr)
+		for _, otherBuildID := range buildIDs {
+			if otherBuildID == buildID {
+				continue
+			}
+			_, otherErr := FindImageIDByTagAndBuildID(context.Background(), dockerClient, tagName(buildID), otherBuildID)
+			assert.NotNil(t, otherErr, "expected the image of one build not to be visible to another build")
+		}
+	}
+}
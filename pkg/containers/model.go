@@ -1,9 +1,29 @@
 package containers
 
+import "fmt"
+
 // DockerImageMetadata contains the Docker image manifest and config.
 type DockerImageMetadata struct {
 	Manifest *DockerImageManifest
 	Config   *DockerImageConfig
+	// LayerSizes maps the manifest layers to the uncompressed layer sizes in bytes.
+	LayerSizes map[string]int64
+}
+
+// LayersSize returns the total uncompressed size in bytes of the image layers.
+func (m *DockerImageMetadata) LayersSize() (int64, error) {
+	if m.Manifest == nil {
+		return 0, fmt.Errorf("image metadata without manifest")
+	}
+	total := int64(0)
+	for _, layer := range m.Manifest.Layers {
+		size, ok := m.LayerSizes[layer]
+		if !ok {
+			return 0, fmt.Errorf("size of the layer %q unknown", layer)
+		}
+		total = total + size
+	}
+	return total, nil
 }
 
 // DockerImageManifest is the Docker image manifest.
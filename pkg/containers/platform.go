@@ -0,0 +1,164 @@
+package containers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// BinfmtMiscRoot is the location of the mounted binfmt_misc file system.
+var BinfmtMiscRoot = "/proc/sys/fs/binfmt_misc"
+
+// SupportedPlatforms are the platforms firebuild can build for.
+var SupportedPlatforms = []string{"linux/amd64", "linux/arm64"}
+
+// platformArchAliases maps the architecture names commonly used by the tools
+// to the names used by Docker.
+var platformArchAliases = map[string]string{
+	"aarch64": "arm64",
+	"amd64":   "amd64",
+	"arm64":   "arm64",
+	"x86_64":  "amd64",
+}
+
+// qemuArchNames maps the Docker architecture to the name of the qemu-user binary.
+var qemuArchNames = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "aarch64",
+}
+
+// ErrorBinfmtNotConfigured is returned when the platform requires emulation
+// but the QEMU interpreter is not registered with binfmt_misc.
+type ErrorBinfmtNotConfigured struct {
+	Platform string
+	Reason   string
+}
+
+func (e *ErrorBinfmtNotConfigured) Error() string {
+	return fmt.Sprintf("platform '%s' requires QEMU emulation but binfmt_misc is not configured: %s; "+
+		"register the interpreters with 'docker run --privileged --rm tonistiigi/binfmt --install %s' "+
+		"or 'docker run --rm --privileged multiarch/qemu-user-static --reset -p yes' and retry",
+		e.Platform, e.Reason, platformArch(e.Platform))
+}
+
+// HostPlatform returns the platform of the host in the os/arch format.
+func HostPlatform() string {
+	return fmt.Sprintf("linux/%s", runtime.GOARCH)
+}
+
+// NormalizePlatform converts the platform to the os/arch format.
+// The input can be an architecture only or the os/arch pair, architecture aliases
+// like aarch64 or x86_64 are accepted. An empty platform normalizes to the host platform.
+func NormalizePlatform(platform string) (string, error) {
+	if platform == "" {
+		return HostPlatform(), nil
+	}
+	parts := strings.Split(strings.ToLower(platform), "/")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("platform '%s' invalid: expected os/arch", platform)
+	}
+	if len(parts) == 2 && parts[0] != "linux" {
+		return "", fmt.Errorf("platform '%s' invalid: only linux is supported", platform)
+	}
+	arch, ok := platformArchAliases[parts[len(parts)-1]]
+	if !ok {
+		return "", fmt.Errorf("platform '%s' invalid: unsupported architecture", platform)
+	}
+	return fmt.Sprintf("linux/%s", arch), nil
+}
+
+// PlatformArchitecture returns the Docker architecture of the platform, for example arm64.
+// An empty platform resolves to the architecture of the host.
+func PlatformArchitecture(platform string) (string, error) {
+	normalized, err := NormalizePlatform(platform)
+	if err != nil {
+		return "", err
+	}
+	return platformArch(normalized), nil
+}
+
+// PlatformRequiresEmulation returns true when the platform differs from the host platform.
+func PlatformRequiresEmulation(platform string) (bool, error) {
+	normalized, err := NormalizePlatform(platform)
+	if err != nil {
+		return false, err
+	}
+	return normalized != HostPlatform(), nil
+}
+
+// CheckBinfmtEmulation verifies that the binaries of the platform can be executed on this host.
+// Nothing is checked for the host platform. For other platforms, the QEMU interpreter
+// must be registered with binfmt_misc, enabled and loaded with the fix binary (F) flag
+// so the interpreter is available inside of the containers.
+func CheckBinfmtEmulation(platform string) error {
+	requiresEmulation, err := PlatformRequiresEmulation(platform)
+	if err != nil {
+		return err
+	}
+	if !requiresEmulation {
+		return nil
+	}
+	normalized, _ := NormalizePlatform(platform)
+
+	status, err := ioutil.ReadFile(filepath.Join(BinfmtMiscRoot, "status"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ErrorBinfmtNotConfigured{Platform: normalized, Reason: fmt.Sprintf("binfmt_misc not mounted at %s", BinfmtMiscRoot)}
+		}
+		return &ErrorBinfmtNotConfigured{Platform: normalized, Reason: err.Error()}
+	}
+	if strings.TrimSpace(string(status)) != "enabled" {
+		return &ErrorBinfmtNotConfigured{Platform: normalized, Reason: "binfmt_misc is disabled"}
+	}
+
+	entryName := fmt.Sprintf("qemu-%s", qemuArchNames[platformArch(normalized)])
+	entry, err := ioutil.ReadFile(filepath.Join(BinfmtMiscRoot, entryName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ErrorBinfmtNotConfigured{Platform: normalized, Reason: fmt.Sprintf("interpreter %s not registered", entryName)}
+		}
+		return &ErrorBinfmtNotConfigured{Platform: normalized, Reason: err.Error()}
+	}
+
+	enabled := false
+	flags := ""
+	for _, line := range strings.Split(string(entry), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "enabled" {
+			enabled = true
+		}
+		if strings.HasPrefix(line, "flags:") {
+			flags = strings.TrimSpace(strings.TrimPrefix(line, "flags:"))
+		}
+	}
+	if !enabled {
+		return &ErrorBinfmtNotConfigured{Platform: normalized, Reason: fmt.Sprintf("interpreter %s disabled", entryName)}
+	}
+	if !strings.Contains(flags, "F") {
+		return &ErrorBinfmtNotConfigured{Platform: normalized, Reason: fmt.Sprintf("interpreter %s registered without the F flag", entryName)}
+	}
+	return nil
+}
+
+// dockerPlatform returns the OCI platform for the container create call,
+// nil for an empty platform.
+func dockerPlatform(platform string) *specs.Platform {
+	if platform == "" {
+		return nil
+	}
+	normalized, err := NormalizePlatform(platform)
+	if err != nil {
+		return nil
+	}
+	return &specs.Platform{OS: "linux", Architecture: platformArch(normalized)}
+}
+
+func platformArch(platform string) string {
+	parts := strings.Split(platform, "/")
+	return parts[len(parts)-1]
+}
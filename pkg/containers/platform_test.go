@@ -0,0 +1,92 @@
+package containers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePlatform(t *testing.T) {
+	for input, expected := range map[string]string{
+		"":              "linux/" + runtime.GOARCH,
+		"arm64":         "linux/arm64",
+		"aarch64":       "linux/arm64",
+		"linux/aarch64": "linux/arm64",
+		"linux/AMD64":   "linux/amd64",
+		"x86_64":        "linux/amd64",
+	} {
+		platform, err := NormalizePlatform(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, expected, platform, input)
+	}
+	for _, input := range []string{"windows/amd64", "linux/arm64/v8", "linux/s390x", "mips"} {
+		_, err := NormalizePlatform(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestCheckBinfmtEmulation(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	previousRoot := BinfmtMiscRoot
+	BinfmtMiscRoot = tempDir
+	defer func() { BinfmtMiscRoot = previousRoot }()
+
+	foreignPlatform := "linux/arm64"
+	interpreter := "qemu-aarch64"
+	if runtime.GOARCH == "arm64" {
+		foreignPlatform = "linux/amd64"
+		interpreter = "qemu-x86_64"
+	}
+
+	// the host platform never requires emulation:
+	assert.Nil(t, CheckBinfmtEmulation(HostPlatform()))
+
+	// binfmt_misc not mounted:
+	assert.IsType(t, &ErrorBinfmtNotConfigured{}, CheckBinfmtEmulation(foreignPlatform))
+
+	writeFile := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile("status", "enabled\n")
+	// interpreter not registered:
+	assert.IsType(t, &ErrorBinfmtNotConfigured{}, CheckBinfmtEmulation(foreignPlatform))
+
+	writeFile(interpreter, "enabled\ninterpreter /usr/bin/"+interpreter+"-static\nflags: OC\noffset 0\n")
+	// interpreter registered without the fix binary flag:
+	assert.IsType(t, &ErrorBinfmtNotConfigured{}, CheckBinfmtEmulation(foreignPlatform))
+
+	writeFile(interpreter, "disabled\ninterpreter /usr/bin/"+interpreter+"-static\nflags: OCF\noffset 0\n")
+	assert.IsType(t, &ErrorBinfmtNotConfigured{}, CheckBinfmtEmulation(foreignPlatform))
+
+	writeFile(interpreter, "enabled\ninterpreter /usr/bin/"+interpreter+"-static\nflags: OCF\noffset 0\n")
+	assert.Nil(t, CheckBinfmtEmulation(foreignPlatform))
+
+	writeFile("status", "disabled\n")
+	assert.IsType(t, &ErrorBinfmtNotConfigured{}, CheckBinfmtEmulation(foreignPlatform))
+}
+
+func TestPlatformArchitecture(t *testing.T) {
+	for input, expected := range map[string]string{
+		"":              runtime.GOARCH,
+		"linux/aarch64": "arm64",
+		"x86_64":        "amd64",
+	} {
+		arch, err := PlatformArchitecture(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, expected, arch, input)
+	}
+	_, err := PlatformArchitecture("linux/s390x")
+	assert.NotNil(t, err)
+}
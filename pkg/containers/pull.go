@@ -0,0 +1,78 @@
+package containers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/utils"
+	docker "github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/hashicorp/go-hclog"
+)
+
+// ImagePullWithRetry pulls a Docker image, retries the pull on transient failures
+// like network errors, registry rate limits and server errors.
+// Authentication and image not found errors fail immediately.
+func ImagePullWithRetry(ctx context.Context, client *docker.Client, logger hclog.Logger, refStr, platform string, auths *RegistryAuths, retryConfig utils.RetryConfig) error {
+	return retryPull(ctx, logger.With("image", refStr), retryConfig, func(ctx context.Context) error {
+		return ImagePull(ctx, client, logger, refStr, platform, auths)
+	})
+}
+
+// ImageExists returns true if the image exists in the local Docker image store.
+func ImageExists(ctx context.Context, client *docker.Client, refStr string) (bool, error) {
+	if _, _, err := client.ImageInspectWithRaw(ctx, refStr); err != nil {
+		if docker.IsErrNotFound(err) {
+			return false, nil
+		}
+		return false, wrapTimeout(ctx, err)
+	}
+	return true, nil
+}
+
+func retryPull(ctx context.Context, logger hclog.Logger, retryConfig utils.RetryConfig, pull func(context.Context) error) error {
+	err := utils.RetryNotify(ctx, retryConfig, func(ctx context.Context) error {
+		if err := pull(ctx); err != nil {
+			if !IsRetryablePullError(ctx, err) {
+				return utils.Permanent(err)
+			}
+			return err
+		}
+		return nil
+	}, func(attempt int, backoff time.Duration, err error) {
+		logger.Warn("image pull failed, retrying", "attempt", attempt, "attempts", retryConfig.Attempts, "backoff", backoff, "reason", err)
+	})
+	return wrapTimeout(ctx, err)
+}
+
+// IsRetryablePullError returns true if the image pull error is transient and the pull can be retried.
+// Network errors, rate limits and server errors are retryable, authentication, authorization,
+// image not found and invalid reference errors are not. Nothing is retryable after the context is done.
+func IsRetryablePullError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if _, ok := err.(*ErrorTimeout); ok {
+		return false
+	}
+	message := strings.ToLower(err.Error())
+	// the daemon reports the registry rate limits as server or invalid parameter errors:
+	for _, fragment := range []string{"toomanyrequests", "too many requests", "rate limit"} {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+	if errdefs.IsNotFound(err) || errdefs.IsUnauthorized(err) || errdefs.IsForbidden(err) ||
+		errdefs.IsInvalidParameter(err) || errdefs.IsNotImplemented(err) {
+		return false
+	}
+	// the errors reported in the pull output stream carry the registry message only:
+	for _, fragment := range []string{"unauthorized", "denied", "authentication required",
+		"manifest unknown", "not found", "does not exist", "invalid reference format"} {
+		if strings.Contains(message, fragment) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/docker/docker/errdefs"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryablePullError(t *testing.T) {
+	ctx := context.Background()
+	for _, err := range []error{
+		&net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")},
+		errdefs.System(fmt.Errorf("received unexpected HTTP status: 503 Service Unavailable")),
+		errdefs.Unavailable(fmt.Errorf("registry unavailable")),
+		errdefs.InvalidParameter(fmt.Errorf("toomanyrequests: You have reached your pull rate limit")),
+		fmt.Errorf("unexpected EOF"),
+	} {
+		assert.True(t, IsRetryablePullError(ctx, err), err.Error())
+	}
+	for _, err := range []error{
+		errdefs.NotFound(fmt.Errorf("manifest for alpine:0.0 not found")),
+		errdefs.Unauthorized(fmt.Errorf("unauthorized")),
+		errdefs.Forbidden(fmt.Errorf("forbidden")),
+		fmt.Errorf("pull access denied for private/image, repository does not exist or may require 'docker login'"),
+		fmt.Errorf("unauthorized: authentication required"),
+		&ErrorTimeout{Operation: OperationPull, Timeout: time.Second},
+	} {
+		assert.False(t, IsRetryablePullError(ctx, err), err.Error())
+	}
+
+	cancelledCtx, cancelFunc := context.WithCancel(ctx)
+	cancelFunc()
+	assert.False(t, IsRetryablePullError(cancelledCtx, fmt.Errorf("unexpected EOF")))
+}
+
+func TestRetryPull(t *testing.T) {
+	logger := hclog.Default()
+	policy := utils.RetryConfig{Attempts: 4, InitialBackoff: time.Millisecond}
+
+	attempts := 0
+	err := retryPull(context.Background(), logger, policy, func(context.Context) error {
+		attempts = attempts + 1
+		if attempts < 3 {
+			return errdefs.System(fmt.Errorf("internal server error"))
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = retryPull(context.Background(), logger, policy, func(context.Context) error {
+		attempts = attempts + 1
+		return errdefs.System(fmt.Errorf("internal server error"))
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 4, attempts, "expected the first attempt and all retries")
+
+	attempts = 0
+	err = retryPull(context.Background(), logger, policy, func(context.Context) error {
+		attempts = attempts + 1
+		return errdefs.Unauthorized(fmt.Errorf("unauthorized"))
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts, "expected no retries for authentication errors")
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	attempts = 0
+	err = retryPull(ctx, logger, utils.RetryConfig{Attempts: 4, InitialBackoff: time.Hour}, func(context.Context) error {
+		attempts = attempts + 1
+		cancelFunc()
+		return fmt.Errorf("unexpected EOF")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts, "expected no retries after the context is cancelled")
+}
@@ -67,7 +67,7 @@ func processDockerOutput(logger hclog.Logger, reader io.ReadCloser, lineReader d
 	scanner := bufio.NewScanner(reader)
 	lastLine := ""
 	for scanner.Scan() {
-		lastLine := scanner.Text()
+		lastLine = scanner.Text()
 		printable := lineReader(lastLine)
 		if printable == nil {
 			logger.Warn("Docker output not a stream line, skipping")
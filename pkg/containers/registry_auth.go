@@ -0,0 +1,304 @@
+package containers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+)
+
+// DockerHubRegistry is the normalized hostname of the Docker Hub registry.
+const DockerHubRegistry = "docker.io"
+
+// dockerHubServerAddress is the server address the Docker CLI uses for the Docker Hub credentials.
+const dockerHubServerAddress = "https://index.docker.io/v1/"
+
+// credentialHelperTokenUsername is the username returned by the credential helpers for the identity tokens.
+const credentialHelperTokenUsername = "<token>"
+
+// RegistryAuths holds the registry credentials and the credential helpers
+// keyed by the normalized registry hostname.
+type RegistryAuths struct {
+	auths   map[string]types.AuthConfig
+	helpers map[string]credentialHelper
+}
+
+// credentialHelper is the credential helper name and the registry server address known to the helper.
+type credentialHelper struct {
+	name          string
+	serverAddress string
+}
+
+// NewRegistryAuths returns empty registry auths.
+func NewRegistryAuths() *RegistryAuths {
+	return &RegistryAuths{
+		auths:   map[string]types.AuthConfig{},
+		helpers: map[string]credentialHelper{},
+	}
+}
+
+// dockerConfigFile is the subset of the Docker CLI config.json used for the registry authentication.
+type dockerConfigFile struct {
+	Auths       map[string]dockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+type dockerConfigAuth struct {
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+}
+
+// NormalizeRegistryHostname returns the registry hostname without the scheme and the path.
+// Docker Hub aliases are normalized to docker.io.
+func NormalizeRegistryHostname(hostname string) string {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	hostname = strings.TrimPrefix(hostname, "https://")
+	hostname = strings.TrimPrefix(hostname, "http://")
+	hostname = strings.SplitN(hostname, "/", 2)[0]
+	switch hostname {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return DockerHubRegistry
+	}
+	return hostname
+}
+
+// RegistryHostname returns the normalized registry hostname of the image reference.
+func RegistryHostname(refStr string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(refStr)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid image reference '%s'", refStr)
+	}
+	return NormalizeRegistryHostname(reference.Domain(named)), nil
+}
+
+// DefaultDockerConfigPath returns the path of the Docker CLI config.json,
+// from the DOCKER_CONFIG environment variable, if set, or the home directory.
+func DefaultDockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// LoadDockerConfigAuths loads the registry credentials from the Docker CLI config.json.
+// The credentials stored by the credential helpers are resolved with the helper binaries
+// when an image from the registry is pulled. A missing file results in no credentials.
+func LoadDockerConfigAuths(path string) (*RegistryAuths, error) {
+	result := NewRegistryAuths()
+	if path == "" {
+		return result, nil
+	}
+	configBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, errors.Wrap(err, "failed reading Docker config")
+	}
+	config := &dockerConfigFile{}
+	if err := json.Unmarshal(configBytes, config); err != nil {
+		return nil, errors.Wrap(err, "failed parsing Docker config")
+	}
+	for hostname, entry := range config.Auths {
+		authConfig := types.AuthConfig{
+			Username:      entry.Username,
+			Password:      entry.Password,
+			IdentityToken: entry.IdentityToken,
+			RegistryToken: entry.RegistryToken,
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid auth of registry '%s' in Docker config", hostname)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid auth of registry '%s' in Docker config: expected username:password", hostname)
+			}
+			authConfig.Username = parts[0]
+			authConfig.Password = parts[1]
+		}
+		if authConfig.Username == "" && authConfig.Password == "" &&
+			authConfig.IdentityToken == "" && authConfig.RegistryToken == "" {
+			// the entry only marks the registry stored in the credentials store:
+			continue
+		}
+		result.Add(hostname, authConfig)
+	}
+	if config.CredsStore != "" {
+		for hostname := range config.Auths {
+			if _, ok := result.auths[NormalizeRegistryHostname(hostname)]; !ok {
+				result.helpers[NormalizeRegistryHostname(hostname)] = credentialHelper{name: config.CredsStore, serverAddress: hostname}
+			}
+		}
+	}
+	for hostname, helper := range config.CredHelpers {
+		result.helpers[NormalizeRegistryHostname(hostname)] = credentialHelper{name: helper, serverAddress: hostname}
+	}
+	return result, nil
+}
+
+// ResolveRegistryAuths loads the credentials from the Docker config and adds the hostname=username:password
+// registry auths and the hostname=token registry tokens, these take precedence over the Docker config.
+// If the Docker config path is empty, the default Docker config is used.
+func ResolveRegistryAuths(dockerConfigPath string, registryAuths, registryTokens []string) (*RegistryAuths, error) {
+	if dockerConfigPath == "" {
+		dockerConfigPath = DefaultDockerConfigPath()
+	}
+	result, err := LoadDockerConfigAuths(dockerConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, input := range registryAuths {
+		hostname, authConfig, err := ParseRegistryAuth(input)
+		if err != nil {
+			return nil, err
+		}
+		result.Add(hostname, authConfig)
+	}
+	for _, input := range registryTokens {
+		hostname, authConfig, err := ParseRegistryToken(input)
+		if err != nil {
+			return nil, err
+		}
+		result.Add(hostname, authConfig)
+	}
+	return result, nil
+}
+
+// credentialHelperAuth resolves the registry credentials with the docker-credential-<helper> binary.
+func credentialHelperAuth(helper, hostname string) (types.AuthConfig, error) {
+	command := exec.Command(fmt.Sprintf("docker-credential-%s", helper), "get")
+	command.Stdin = strings.NewReader(hostname)
+	stderr := &bytes.Buffer{}
+	command.Stderr = stderr
+	output, err := command.Output()
+	if err != nil {
+		return types.AuthConfig{}, fmt.Errorf("credential helper '%s' failed for registry '%s': %v: %s",
+			helper, hostname, err, strings.TrimSpace(stderr.String()))
+	}
+	credentials := struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}{}
+	if err := json.Unmarshal(output, &credentials); err != nil {
+		return types.AuthConfig{}, errors.Wrapf(err, "invalid credential helper '%s' output for registry '%s'", helper, hostname)
+	}
+	if credentials.Username == credentialHelperTokenUsername {
+		return types.AuthConfig{IdentityToken: credentials.Secret}, nil
+	}
+	return types.AuthConfig{Username: credentials.Username, Password: credentials.Secret}, nil
+}
+
+// Add adds the credentials of the registry, replaces the existing credentials
+// and the credential helper of the registry.
+func (a *RegistryAuths) Add(hostname string, authConfig types.AuthConfig) {
+	normalized := NormalizeRegistryHostname(hostname)
+	authConfig.ServerAddress = registryServerAddress(normalized)
+	a.auths[normalized] = authConfig
+	delete(a.helpers, normalized)
+}
+
+// ForImage returns the encoded registry auth for the image reference,
+// an empty string when there are no credentials for the image registry.
+// Nil registry auths have no credentials.
+func (a *RegistryAuths) ForImage(refStr string) (string, error) {
+	if a == nil || (len(a.auths) == 0 && len(a.helpers) == 0) {
+		return "", nil
+	}
+	hostname, err := RegistryHostname(refStr)
+	if err != nil {
+		return "", err
+	}
+	authConfig, ok, err := a.ForRegistry(hostname)
+	if err != nil || !ok {
+		return "", err
+	}
+	return EncodeRegistryAuth(authConfig)
+}
+
+// ForRegistry returns the credentials for the registry hostname,
+// false when there are no credentials for the registry.
+// Nil registry auths have no credentials.
+func (a *RegistryAuths) ForRegistry(hostname string) (types.AuthConfig, bool, error) {
+	if a == nil {
+		return types.AuthConfig{}, false, nil
+	}
+	hostname = NormalizeRegistryHostname(hostname)
+	// as in the Docker CLI, the credential helpers take precedence over the stored credentials:
+	if helper, ok := a.helpers[hostname]; ok {
+		authConfig, err := credentialHelperAuth(helper.name, helper.serverAddress)
+		if err != nil {
+			return types.AuthConfig{}, false, err
+		}
+		authConfig.ServerAddress = registryServerAddress(hostname)
+		return authConfig, true, nil
+	}
+	if authConfig, ok := a.auths[hostname]; ok {
+		return authConfig, true, nil
+	}
+	return types.AuthConfig{}, false, nil
+}
+
+func registryServerAddress(hostname string) string {
+	if hostname == DockerHubRegistry {
+		return dockerHubServerAddress
+	}
+	return hostname
+}
+
+// EncodeRegistryAuth encodes the credentials in the format of the Docker API X-Registry-Auth header.
+func EncodeRegistryAuth(authConfig types.AuthConfig) (string, error) {
+	authBytes, err := json.Marshal(authConfig)
+	if err != nil {
+		return "", errors.Wrap(err, "failed encoding registry auth")
+	}
+	return base64.URLEncoding.EncodeToString(authBytes), nil
+}
+
+// ParseRegistryAuth parses the hostname=username:password registry credentials.
+func ParseRegistryAuth(input string) (string, types.AuthConfig, error) {
+	hostname, credentials, err := splitRegistryFlag(input)
+	if err != nil {
+		return "", types.AuthConfig{}, err
+	}
+	parts := strings.SplitN(credentials, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", types.AuthConfig{}, fmt.Errorf("invalid registry auth for '%s': expected hostname=username:password", hostname)
+	}
+	return hostname, types.AuthConfig{Username: parts[0], Password: parts[1]}, nil
+}
+
+// ParseRegistryToken parses the hostname=token registry bearer token.
+func ParseRegistryToken(input string) (string, types.AuthConfig, error) {
+	hostname, token, err := splitRegistryFlag(input)
+	if err != nil {
+		return "", types.AuthConfig{}, err
+	}
+	return hostname, types.AuthConfig{RegistryToken: token}, nil
+}
+
+func splitRegistryFlag(input string) (string, string, error) {
+	parts := strings.SplitN(input, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid registry credentials: expected hostname=credentials")
+	}
+	return NormalizeRegistryHostname(parts[0]), parts[1], nil
+}
@@ -0,0 +1,116 @@
+package containers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryHostname(t *testing.T) {
+	for input, expected := range map[string]string{
+		"alpine:3.13":                                      "docker.io",
+		"jaegertracing/all-in-one:1.22":                    "docker.io",
+		"ghcr.io/combust-labs/image:1.0":                   "ghcr.io",
+		"localhost:5000/image":                             "localhost:5000",
+		"123.dkr.ecr.us-east-1.amazonaws.com/image:latest": "123.dkr.ecr.us-east-1.amazonaws.com",
+	} {
+		hostname, err := RegistryHostname(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, expected, hostname, input)
+	}
+	assert.Equal(t, "docker.io", NormalizeRegistryHostname("https://index.docker.io/v1/"))
+	assert.Equal(t, "ghcr.io", NormalizeRegistryHostname("https://GHCR.io"))
+}
+
+func TestParseRegistryFlags(t *testing.T) {
+	hostname, authConfig, err := ParseRegistryAuth("ghcr.io=user:pass:word")
+	assert.Nil(t, err)
+	assert.Equal(t, "ghcr.io", hostname)
+	assert.Equal(t, types.AuthConfig{Username: "user", Password: "pass:word"}, authConfig)
+
+	hostname, authConfig, err = ParseRegistryToken("https://index.docker.io/v1/=token")
+	assert.Nil(t, err)
+	assert.Equal(t, "docker.io", hostname)
+	assert.Equal(t, types.AuthConfig{RegistryToken: "token"}, authConfig)
+
+	for _, input := range []string{"ghcr.io", "=secret:secret", "ghcr.io=", "ghcr.io=secret"} {
+		_, _, err := ParseRegistryAuth(input)
+		assert.NotNil(t, err, input)
+		// the credentials must not leak into the error:
+		if err != nil {
+			assert.NotContains(t, err.Error(), "secret")
+		}
+	}
+}
+
+func TestResolveRegistryAuths(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// fake credential helper:
+	helperPath := filepath.Join(tempDir, "docker-credential-fake")
+	assert.Nil(t, ioutil.WriteFile(helperPath, []byte(`#!/bin/sh
+read server
+echo "{\"ServerURL\":\"$server\",\"Username\":\"AWS\",\"Secret\":\"ecr-password\"}"
+`), 0755))
+	previousPath := os.Getenv("PATH")
+	os.Setenv("PATH", tempDir+string(os.PathListSeparator)+previousPath)
+	defer os.Setenv("PATH", previousPath)
+
+	configPath := filepath.Join(tempDir, "config.json")
+	assert.Nil(t, ioutil.WriteFile(configPath, []byte(`{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("hub-user:hub-password"))+`"},
+		"ghcr.io": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("config-user:config-password"))+`"},
+		"quay.io": {"identitytoken": "quay-token"}
+	},
+	"credHelpers": {
+		"123.dkr.ecr.us-east-1.amazonaws.com": "fake"
+	}
+}`), 0644))
+
+	auths, err := ResolveRegistryAuths(configPath, []string{"ghcr.io=flag-user:flag-password"}, []string{"registry.example.com=bearer"})
+	assert.Nil(t, err)
+
+	decode := func(image string) types.AuthConfig {
+		encoded, err := auths.ForImage(image)
+		assert.Nil(t, err, image)
+		authConfig := types.AuthConfig{}
+		if encoded == "" {
+			return authConfig
+		}
+		decoded, err := base64.URLEncoding.DecodeString(encoded)
+		assert.Nil(t, err, image)
+		assert.Nil(t, json.Unmarshal(decoded, &authConfig), image)
+		return authConfig
+	}
+
+	assert.Equal(t, types.AuthConfig{Username: "hub-user", Password: "hub-password", ServerAddress: "https://index.docker.io/v1/"}, decode("alpine:3.13"))
+	assert.Equal(t, types.AuthConfig{Username: "flag-user", Password: "flag-password", ServerAddress: "ghcr.io"}, decode("ghcr.io/org/image:1.0"))
+	assert.Equal(t, types.AuthConfig{IdentityToken: "quay-token", ServerAddress: "quay.io"}, decode("quay.io/org/image"))
+	assert.Equal(t, types.AuthConfig{RegistryToken: "bearer", ServerAddress: "registry.example.com"}, decode("registry.example.com/image"))
+	assert.Equal(t, types.AuthConfig{Username: "AWS", Password: "ecr-password", ServerAddress: "123.dkr.ecr.us-east-1.amazonaws.com"},
+		decode("123.dkr.ecr.us-east-1.amazonaws.com/image:latest"))
+	assert.Equal(t, types.AuthConfig{}, decode("registry.other.com/image"))
+
+	var nilAuths *RegistryAuths
+	encoded, err := nilAuths.ForImage("alpine:3.13")
+	assert.Nil(t, err)
+	assert.Equal(t, "", encoded)
+
+	// missing Docker config results in no credentials:
+	auths, err = ResolveRegistryAuths(filepath.Join(tempDir, "missing.json"), nil, nil)
+	assert.Nil(t, err)
+	encoded, err = auths.ForImage("alpine:3.13")
+	assert.Nil(t, err)
+	assert.Equal(t, "", encoded)
+}
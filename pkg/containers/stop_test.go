@@ -0,0 +1,61 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopContainerHonorsTimeout(t *testing.T) {
+	stopper := newFakeContainerStopper(nil)
+	stopContainer(context.Background(), stopper, hclog.Default(), "container-id", time.Second*5)
+
+	assert.Equal(t, []time.Duration{time.Second * 5}, stopper.stopTimeouts)
+	assert.Empty(t, stopper.killSignals)
+}
+
+func TestStopContainerKillsOnStopError(t *testing.T) {
+	stopper := newFakeContainerStopper(fmt.Errorf("stop failed"))
+	stopContainer(context.Background(), stopper, hclog.Default(), "container-id", 0)
+
+	assert.Equal(t, []time.Duration{0}, stopper.stopTimeouts)
+	assert.Equal(t, []string{"SIGKILL"}, stopper.killSignals)
+}
+
+type fakeContainerStopper struct {
+	killSignals  []string
+	stopErr      error
+	stopTimeouts []time.Duration
+	chanStopped  chan container.ContainerWaitOKBody
+}
+
+func newFakeContainerStopper(stopErr error) *fakeContainerStopper {
+	return &fakeContainerStopper{
+		stopErr:     stopErr,
+		chanStopped: make(chan container.ContainerWaitOKBody, 1),
+	}
+}
+
+func (s *fakeContainerStopper) ContainerKill(ctx context.Context, containerID, signal string) error {
+	s.killSignals = append(s.killSignals, signal)
+	s.chanStopped <- container.ContainerWaitOKBody{StatusCode: 137}
+	return nil
+}
+
+func (s *fakeContainerStopper) ContainerStop(ctx context.Context, containerID string, timeout *time.Duration) error {
+	s.stopTimeouts = append(s.stopTimeouts, *timeout)
+	if s.stopErr != nil {
+		return s.stopErr
+	}
+	s.chanStopped <- container.ContainerWaitOKBody{}
+	return nil
+}
+
+func (s *fakeContainerStopper) ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	return s.chanStopped, make(chan error)
+}
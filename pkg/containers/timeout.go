@@ -0,0 +1,79 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	docker "github.com/docker/docker/client"
+)
+
+const (
+	// OperationBuild is the name of the Docker image build operation.
+	OperationBuild = "image build"
+	// OperationClient is the name of the Docker client API version negotiation operation.
+	OperationClient = "client version negotiation"
+	// OperationInspect is the name of the Docker image lookup and removal operation.
+	OperationInspect = "image inspect"
+	// OperationPull is the name of the Docker image pull operation.
+	OperationPull = "image pull"
+	// OperationSave is the name of the Docker image save and file system export operation.
+	OperationSave = "image save"
+)
+
+// ErrorTimeout is returned when a Docker operation did not finish within the timeout.
+type ErrorTimeout struct {
+	Operation string
+	Timeout   time.Duration
+}
+
+func (e *ErrorTimeout) Error() string {
+	return fmt.Sprintf("Docker %s did not finish within %v, is the Docker daemon responsive?", e.Operation, e.Timeout)
+}
+
+type operationContextKey struct{}
+
+type operationContextValue struct {
+	operation string
+	timeout   time.Duration
+}
+
+// NewOperationContext returns a context for the Docker operation which times out after the timeout.
+// A zero timeout does not time out.
+func NewOperationContext(parent context.Context, operation string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(parent, operationContextKey{}, &operationContextValue{operation: operation, timeout: timeout})
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// GetDefaultClientWithTimeout returns a default instance of the Docker client
+// with the API version negotiated with the Docker daemon within the timeout.
+func GetDefaultClientWithTimeout(timeout time.Duration) (*docker.Client, error) {
+	client, err := docker.NewClientWithOpts(docker.FromEnv)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancelFunc := NewOperationContext(context.Background(), OperationClient, timeout)
+	defer cancelFunc()
+	ping, err := client.Ping(ctx)
+	if err != nil {
+		client.Close()
+		return nil, wrapTimeout(ctx, err)
+	}
+	client.NegotiateAPIVersionPing(ping)
+	return client, nil
+}
+
+// wrapTimeout returns an *ErrorTimeout if the error occurred because the operation context timed out,
+// otherwise returns the original error.
+func wrapTimeout(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	if value, ok := ctx.Value(operationContextKey{}).(*operationContextValue); ok {
+		return &ErrorTimeout{Operation: value.operation, Timeout: value.timeout}
+	}
+	return err
+}
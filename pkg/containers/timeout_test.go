@@ -0,0 +1,36 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationContextTimeout(t *testing.T) {
+
+	ctx, cancelFunc := NewOperationContext(context.Background(), OperationPull, time.Millisecond)
+	defer cancelFunc()
+	<-ctx.Done()
+
+	err := wrapTimeout(ctx, ctx.Err())
+	assert.Equal(t, &ErrorTimeout{Operation: OperationPull, Timeout: time.Millisecond}, err)
+	assert.Equal(t, "Docker image pull did not finish within 1ms, is the Docker daemon responsive?", err.Error())
+}
+
+func TestOperationContextWithoutTimeout(t *testing.T) {
+
+	ctx, cancelFunc := NewOperationContext(context.Background(), OperationBuild, 0)
+	_, hasDeadline := ctx.Deadline()
+	assert.False(t, hasDeadline)
+
+	originalErr := fmt.Errorf("build failed")
+	assert.Equal(t, originalErr, wrapTimeout(ctx, originalErr))
+	assert.Nil(t, wrapTimeout(ctx, nil))
+
+	// a cancelled context is not a timeout:
+	cancelFunc()
+	assert.Equal(t, context.Canceled, wrapTimeout(ctx, ctx.Err()))
+}
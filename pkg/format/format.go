@@ -0,0 +1,59 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// indexExpressionRegex matches the field[index] expressions not supported by text/template.
+var indexExpressionRegex = regexp.MustCompile(`(\.[A-Za-z0-9_.]+)\[(\d+)\]`)
+
+// templateFuncs are the functions available to the templates, in addition to the text/template builtins.
+var templateFuncs = template.FuncMap{
+	"json": func(input interface{}) (string, error) {
+		bytes, err := json.Marshal(input)
+		if err != nil {
+			return "", err
+		}
+		return string(bytes), nil
+	},
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// Parse parses the Go text/template used to format the command output,
+// like docker inspect --format.
+// A missing map key is an error instead of printing <no value>.
+func Parse(text string) (*template.Template, error) {
+	tmpl, err := template.New("format").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		if match := indexExpressionRegex.FindStringSubmatch(text); match != nil {
+			return nil, errors.Wrapf(err, "template can't be parsed, use {{index %s %s}} instead of %s", match[1], match[2], match[0])
+		}
+		return nil, errors.Wrap(err, "template can't be parsed")
+	}
+	return tmpl, nil
+}
+
+// Execute executes the template with the data and returns the output.
+// The output is returned only if the template executes successfully.
+func Execute(tmpl *template.Template, data interface{}) (output string, err error) {
+	// the template functions are called by reflection, a panic must not crash the command:
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("template execution failed: %v", r)
+		}
+	}()
+	buffer := &bytes.Buffer{}
+	if err := tmpl.Execute(buffer, data); err != nil {
+		return "", errors.Wrap(err, "template execution failed")
+	}
+	return buffer.String(), nil
+}
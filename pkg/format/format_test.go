@@ -0,0 +1,92 @@
+package format_test
+
+import (
+	"testing"
+
+	"github.com/combust-labs/firebuild/pkg/format"
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatMetadata(t *testing.T) {
+	md := &metadata.MDRun{
+		VMMID: "vmm-id",
+		NetworkInterfaces: []metadata.MDNetworkInterafce{
+			{
+				StaticConfiguration: &metadata.MDNetStaticConfiguration{
+					MacAddress: "02:00:00:00:00:01",
+					IPConfiguration: &metadata.MDNetIPConfiguration{
+						IP:          "192.168.127.10",
+						Nameservers: []string{"1.1.1.1", "8.8.8.8"},
+					},
+				},
+			},
+		},
+	}
+
+	tmpl, err := format.Parse("{{(index .NetworkInterfaces 0).StaticConfiguration.IPConfiguration.IP}}")
+	assert.Nil(t, err)
+	output, err := format.Execute(tmpl, md)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.127.10", output)
+
+	tmpl, err = format.Parse(`{{.VMMID}} {{join (index .NetworkInterfaces 0).StaticConfiguration.IPConfiguration.Nameservers ","}} {{json (index .NetworkInterfaces 0).StaticConfiguration.MacAddress}}`)
+	assert.Nil(t, err)
+	output, err = format.Execute(tmpl, md)
+	assert.Nil(t, err)
+	assert.Equal(t, `vmm-id 1.1.1.1,8.8.8.8 "02:00:00:00:00:01"`, output)
+}
+
+func TestFormatDocumentedFields(t *testing.T) {
+	md := &metadata.MDRun{
+		VMMID:  "vmm-id",
+		Labels: map[string]string{"env": "prod"},
+		Rootfs: &metadata.MDRootfs{
+			Image: metadata.MDImage{Org: "tests", Image: "postgres", Version: "13"},
+		},
+		Drives: []models.Drive{
+			{DriveID: firecracker.String("1"), PathOnHost: firecracker.String("/rootfs")},
+		},
+	}
+	tmpl, err := format.Parse("{{.Rootfs.Image.Org}}/{{.Rootfs.Image.Image}}:{{.Rootfs.Image.Version}} {{.Labels.env}} {{range .Drives}}{{.DriveID}} {{.PathOnHost}}{{end}}")
+	assert.Nil(t, err)
+	output, err := format.Execute(tmpl, md)
+	assert.Nil(t, err)
+	assert.Equal(t, "tests/postgres:13 prod 1 /rootfs", output)
+}
+
+func TestFormatErrors(t *testing.T) {
+	// the docker like index expression is not valid, the error suggests the index function:
+	_, err := format.Parse("{{.NetworkInterfaces[0].StaticConfiguration}}")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "{{index .NetworkInterfaces 0}}")
+
+	_, err = format.Parse("{{.VMMID")
+	assert.NotNil(t, err)
+
+	md := &metadata.MDRun{}
+
+	// unknown field:
+	tmpl, err := format.Parse("{{.DoesNotExist}}")
+	assert.Nil(t, err)
+	_, err = format.Execute(tmpl, md)
+	assert.NotNil(t, err)
+
+	// out of range index:
+	tmpl, err = format.Parse("{{(index .NetworkInterfaces 0).StaticConfiguration}}")
+	assert.Nil(t, err)
+	_, err = format.Execute(tmpl, md)
+	assert.NotNil(t, err)
+
+	// missing map key:
+	tmpl, err = format.Parse(`{{index .Labels "missing"}}`)
+	assert.Nil(t, err)
+	_, err = format.Execute(tmpl, map[string]interface{}{"Labels": map[string]string{}})
+	assert.Nil(t, err, "index of a missing key returns the zero value")
+	tmpl, err = format.Parse(`{{.Labels.missing}}`)
+	assert.Nil(t, err)
+	_, err = format.Execute(tmpl, map[string]interface{}{"Labels": map[string]string{}})
+	assert.NotNil(t, err)
+}
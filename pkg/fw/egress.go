@@ -0,0 +1,282 @@
+package fw
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/flock"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EgressDefaultAllow allows the egress traffic not matching any egress rule.
+	EgressDefaultAllow = "allow"
+	// EgressDefaultDeny drops the egress traffic not matching any egress rule.
+	EgressDefaultDeny = "deny"
+)
+
+// EgressChainName returns the name of the filter table chain with the egress rules of the VMM.
+func EgressChainName(vmID string) string {
+	return egressChainPrefix + vmID
+}
+
+// EgressRule is an egress destination: a CIDR, optionally limited to a port or a port range
+// and a protocol.
+type EgressRule struct {
+	CIDR *net.IPNet
+	// Port is 0 for all ports.
+	Port    int
+	PortEnd int
+	// Protocol is empty for all protocols, with a port, the rule applies to tcp and udp.
+	Protocol string
+}
+
+var egressRuleRegex = regexp.MustCompile(`^(\[([^\]]+)\]|([^:\[\]]+))(:(\d{1,5})(-(\d{1,5}))?)?(/(tcp|udp))?$`)
+
+// EgressRuleFromString parses the egress rule, format: cidr[:port[-port]][/tcp|udp].
+// The IPv6 CIDR must be given in square brackets, for example: [2001:db8::/32]:443.
+// An IP address without the prefix length is a single host.
+func EgressRuleFromString(input string) (*EgressRule, error) {
+	matches := egressRuleRegex.FindStringSubmatch(strings.TrimSpace(input))
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("egress rule '%s' is invalid, expected format: cidr[:port[-port]][/tcp|udp]", input)
+	}
+	address := matches[2]
+	if address == "" {
+		address = matches[3]
+	}
+	if !strings.Contains(address, "/") {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			address = address + "/32"
+		} else {
+			address = address + "/128"
+		}
+	}
+	_, cidr, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "egress rule '%s' CIDR is invalid", input)
+	}
+	if matches[2] == "" && cidr.IP.To4() == nil {
+		return nil, fmt.Errorf("egress rule '%s' IPv6 CIDR must be given in square brackets", input)
+	}
+	rule := &EgressRule{CIDR: cidr, Protocol: matches[9]}
+	if matches[5] != "" {
+		if rule.Port, err = parseEgressPort(matches[5]); err != nil {
+			return nil, errors.Wrapf(err, "egress rule '%s' port is invalid", input)
+		}
+		rule.PortEnd = rule.Port
+		if matches[7] != "" {
+			if rule.PortEnd, err = parseEgressPort(matches[7]); err != nil {
+				return nil, errors.Wrapf(err, "egress rule '%s' port is invalid", input)
+			}
+			if rule.PortEnd < rule.Port {
+				return nil, fmt.Errorf("egress rule '%s' port range is invalid", input)
+			}
+		}
+	} else if rule.Protocol != "" {
+		return nil, fmt.Errorf("egress rule '%s' protocol requires a port", input)
+	}
+	return rule, nil
+}
+
+// Family returns the address family of the rule CIDR.
+func (r *EgressRule) Family() string {
+	return addressFamily(r.CIDR.IP.String())
+}
+
+// String returns the rule in the format accepted by EgressRuleFromString.
+func (r *EgressRule) String() string {
+	result := r.CIDR.String()
+	if r.Family() == FamilyIPv6 {
+		result = "[" + result + "]"
+	}
+	if r.Port > 0 {
+		result = result + ":" + portRangeString(r.Port, r.PortEnd, "-")
+	}
+	if r.Protocol != "" {
+		result = result + "/" + r.Protocol
+	}
+	return result
+}
+
+// ToRulespecs returns the egress chain rulespecs of the rule with the target, ACCEPT or DROP.
+func (r *EgressRule) ToRulespecs(vmID, target string) [][]string {
+	comment := []string{"-m", "comment", "--comment", fmt.Sprintf("firebuild:egress:%s", vmID)}
+	if r.Port == 0 {
+		return [][]string{append(append([]string{}, comment...), "-d", r.CIDR.String(), "-j", target)}
+	}
+	protocols := []string{r.Protocol}
+	if r.Protocol == "" {
+		protocols = []string{"tcp", "udp"}
+	}
+	rulespecs := [][]string{}
+	for _, protocol := range protocols {
+		rulespecs = append(rulespecs, append(append([]string{}, comment...),
+			"-d", r.CIDR.String(), "-p", protocol, "--dport", portRangeString(r.Port, r.PortEnd, ":"), "-j", target))
+	}
+	return rulespecs
+}
+
+// EgressPolicy is the egress policy of a VMM: the denied destinations are dropped,
+// the allowed destinations are accepted, the remaining traffic is handled by the default.
+type EgressPolicy struct {
+	Allow   []*EgressRule
+	Deny    []*EgressRule
+	Default string
+}
+
+// EgressManager applies the egress policy of a VMM as the iptables FORWARD rules
+// matching the traffic from the guest addresses.
+type EgressManager interface {
+	// Apply creates the egress chain of the VMM with the policy rules and jumps to it
+	// from the FORWARD chain for the traffic from the guest addresses.
+	Apply(*EgressPolicy) error
+	// Remove removes the jumps and the egress chain of the VMM.
+	Remove() error
+}
+
+type defaultEgressManager struct {
+	tables []*familyTables
+	vmID   string
+
+	lock               flock.Lock
+	lockAcquireTimeout time.Duration
+	chainName          string
+}
+
+// NewEgressManager returns an egress manager for the guest addresses of the VMM, one address per family.
+// The IPv6 rules are applied with ip6tables.
+func NewEgressManager(vmID string, ipAddresses ...string) (EgressManager, error) {
+	acquireTimeout, err := time.ParseDuration(utils.GetenvOrDefault(FirebuildFlockAcquireTimeoutEnvVarName, FirebuildFlockDefaultAcquireTimeout))
+	if err != nil {
+		return nil, err
+	}
+	tables := []*familyTables{}
+	for _, ipAddress := range ipAddresses {
+		family := addressFamily(ipAddress)
+		for _, existing := range tables {
+			if existing.family == family {
+				return nil, fmt.Errorf("more than one %s guest address given: %s and %s", family, existing.ipAddress, ipAddress)
+			}
+		}
+		protocol := iptables.ProtocolIPv4
+		if family == FamilyIPv6 {
+			protocol = iptables.ProtocolIPv6
+		}
+		ipt, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed creating %s tables handle", family)
+		}
+		tables = append(tables, &familyTables{family: family, ipt: ipt, ipAddress: ipAddress})
+	}
+	return &defaultEgressManager{tables: tables,
+		vmID:               vmID,
+		lock:               flock.New(utils.GetenvOrDefault(FirebuildFlockFileEnvVarName, FirebuildFlockDefaultFile)),
+		lockAcquireTimeout: acquireTimeout,
+		chainName:          EgressChainName(vmID)}, nil
+}
+
+// Apply creates the egress chain of the VMM with the policy rules and jumps to it
+// from the FORWARD chain for the traffic from the guest addresses.
+// The rules of a family without a guest address are skipped.
+func (m *defaultEgressManager) Apply(policy *EgressPolicy) error {
+
+	if err := m.lock.AcquireWithTimeout(m.lockAcquireTimeout); err != nil {
+		return err
+	}
+	defer m.lock.Release()
+
+	for _, t := range m.tables {
+		if err := ensureChain(t.ipt, "filter", m.chainName); err != nil {
+			return err
+		}
+		if err := t.ipt.ClearChain("filter", m.chainName); err != nil {
+			return err
+		}
+		// the replies to the published ports and to the allowed connections:
+		if err := t.ipt.Append("filter", m.chainName, "-m", "state", "--state", "ESTABLISHED,RELATED", "-j", "ACCEPT"); err != nil {
+			return errors.Wrap(err, "failed adding egress established rule")
+		}
+		for _, item := range []struct {
+			rules  []*EgressRule
+			target string
+		}{{policy.Deny, "DROP"}, {policy.Allow, "ACCEPT"}} {
+			for _, rule := range item.rules {
+				if rule.Family() != t.family {
+					continue
+				}
+				for _, rulespec := range rule.ToRulespecs(m.vmID, item.target) {
+					if err := t.ipt.Append("filter", m.chainName, rulespec...); err != nil {
+						return errors.Wrapf(err, "failed adding egress rule: %s", rule)
+					}
+				}
+			}
+		}
+		// with the default allow, the traffic continues through the FORWARD chain:
+		defaultTarget := "DROP"
+		if policy.Default == EgressDefaultAllow {
+			defaultTarget = "RETURN"
+		}
+		if err := t.ipt.Append("filter", m.chainName, "-j", defaultTarget); err != nil {
+			return errors.Wrap(err, "failed adding egress default rule")
+		}
+		// the policy must be evaluated before any rule accepting the forwarded traffic:
+		jumpRulespec := m.jumpRulespec(t.ipAddress)
+		exists, err := t.ipt.Exists("filter", "FORWARD", jumpRulespec...)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := t.ipt.Insert("filter", "FORWARD", 1, jumpRulespec...); err != nil {
+				return errors.Wrap(err, "failed adding egress jump rule")
+			}
+		}
+	}
+	return nil
+}
+
+// Remove removes the jumps and the egress chain of the VMM.
+func (m *defaultEgressManager) Remove() error {
+
+	if err := m.lock.AcquireWithTimeout(m.lockAcquireTimeout); err != nil {
+		return err
+	}
+	defer m.lock.Release()
+
+	for _, t := range m.tables {
+		if err := t.ipt.DeleteIfExists("filter", "FORWARD", m.jumpRulespec(t.ipAddress)...); err != nil {
+			return errors.Wrap(err, "failed removing egress jump rule")
+		}
+		exists, err := t.ipt.ChainExists("filter", m.chainName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err := t.ipt.ClearAndDeleteChain("filter", m.chainName); err != nil {
+				return errors.Wrap(err, "failed removing egress chain")
+			}
+		}
+	}
+	return nil
+}
+
+func (m *defaultEgressManager) jumpRulespec(ipAddress string) []string {
+	return []string{"-s", ipAddress, "-m", "comment", "--comment", fmt.Sprintf("firebuild:egress:%s", m.vmID), "-j", m.chainName}
+}
+
+func parseEgressPort(input string) (int, error) {
+	port, err := strconv.Atoi(input)
+	if err != nil {
+		return 0, err
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range", port)
+	}
+	return port, nil
+}
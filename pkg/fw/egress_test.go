@@ -0,0 +1,104 @@
+package fw
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEgressRuleFromString(t *testing.T) {
+	for input, expected := range map[string]string{
+		"10.0.0.0/8":           "10.0.0.0/8",
+		"10.1.2.3/8:443":       "10.0.0.0/8:443",
+		"1.1.1.1:53/udp":       "1.1.1.1/32:53/udp",
+		"192.168.0.0/16:80-90": "192.168.0.0/16:80-90",
+		"[2001:db8::/32]:443":  "[2001:db8::/32]:443",
+		"[2001:db8::1]:53/tcp": "[2001:db8::1/128]:53/tcp",
+		"0.0.0.0/0:443/tcp":    "0.0.0.0/0:443/tcp",
+	} {
+		rule, err := EgressRuleFromString(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, expected, rule.String())
+	}
+	for _, input := range []string{"", "10.0.0.0/33", "10.0.0.0/8:0", "10.0.0.0/8:70000",
+		"10.0.0.0/8:90-80", "10.0.0.0/8/tcp", "10.0.0.0/8:53/icmp", "2001:db8::/32:443", "example.com:443"} {
+		_, err := EgressRuleFromString(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestEgressRuleRulespecs(t *testing.T) {
+	rule, err := EgressRuleFromString("10.0.0.0/8")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"-m", "comment", "--comment", "firebuild:egress:testvm",
+		"-d", "10.0.0.0/8", "-j", "ACCEPT"}}, rule.ToRulespecs("testvm", "ACCEPT"))
+
+	rule, err = EgressRuleFromString("10.0.0.0/8:53")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"-m", "comment", "--comment", "firebuild:egress:testvm", "-d", "10.0.0.0/8", "-p", "tcp", "--dport", "53", "-j", "DROP"},
+		{"-m", "comment", "--comment", "firebuild:egress:testvm", "-d", "10.0.0.0/8", "-p", "udp", "--dport", "53", "-j", "DROP"},
+	}, rule.ToRulespecs("testvm", "DROP"))
+
+	rule, err = EgressRuleFromString("10.0.0.0/8:8000-8010/tcp")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"-m", "comment", "--comment", "firebuild:egress:testvm",
+		"-d", "10.0.0.0/8", "-p", "tcp", "--dport", "8000:8010", "-j", "ACCEPT"}}, rule.ToRulespecs("testvm", "ACCEPT"))
+}
+
+func TestEgressApplyAndRemove(t *testing.T) {
+
+	user, err := user.Current()
+	assert.Nil(t, err)
+	if user.Uid != "0" {
+		t.Skip("Skipping tests depending on sudo: run tests with sudo to have this test executed")
+	}
+	if _, err := exec.LookPath("iptables"); err != nil {
+		t.Skip("Skipping tests depending on iptables: iptables not found")
+	}
+
+	os.Setenv(FirebuildFlockFileEnvVarName, os.TempDir()+"/firebuild-egress-test.lock")
+
+	vmID := "testvm"
+	guestAddress := "192.168.127.10"
+	allowed, err := EgressRuleFromString("10.0.0.0/8:443/tcp")
+	assert.Nil(t, err)
+
+	mgr, err := NewEgressManager(vmID, guestAddress)
+	assert.Nil(t, err)
+	assert.Nil(t, mgr.Apply(&EgressPolicy{Allow: []*EgressRule{allowed}, Default: EgressDefaultDeny}))
+
+	ipt, err := iptables.New()
+	assert.Nil(t, err)
+
+	exists, err := ipt.ChainExists("filter", EgressChainName(vmID))
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = ipt.Exists("filter", "FORWARD", "-s", guestAddress, "-m", "comment", "--comment", "firebuild:egress:testvm", "-j", EgressChainName(vmID))
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = ipt.Exists("filter", EgressChainName(vmID), allowed.ToRulespecs(vmID, "ACCEPT")[0]...)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = ipt.Exists("filter", EgressChainName(vmID), "-j", "DROP")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	// applying again replaces the rules:
+	assert.Nil(t, mgr.Apply(&EgressPolicy{Default: EgressDefaultAllow}))
+	exists, err = ipt.Exists("filter", EgressChainName(vmID), allowed.ToRulespecs(vmID, "ACCEPT")[0]...)
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	assert.Nil(t, mgr.Remove())
+	exists, err = ipt.ChainExists("filter", EgressChainName(vmID))
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	exists, err = ipt.Exists("filter", "FORWARD", "-s", guestAddress, "-m", "comment", "--comment", "firebuild:egress:testvm", "-j", EgressChainName(vmID))
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
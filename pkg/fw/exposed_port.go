@@ -2,30 +2,81 @@ package fw
 
 import (
 	"fmt"
+	"net"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
-const defaultProtocol = "tcp"
+const (
+	protocolBoth = "both"
+	protocolTCP  = "tcp"
+	protocolUDP  = "udp"
+
+	defaultProtocol = protocolTCP
+
+	// minPort and maxPort are the bounds of the valid port numbers.
+	minPort = 1
+	maxPort = 65535
+	// unprivilegedPortStart is the first host port which can be bound without elevated privileges.
+	unprivilegedPortStart = 1024
+
+	// FamilyIPv4 is the IPv4 address family, published with iptables.
+	FamilyIPv4 = "ipv4"
+	// FamilyIPv6 is the IPv6 address family, published with ip6tables.
+	FamilyIPv6 = "ipv6"
+)
 
 // ExposedPort represents exposed port data used for iptables port publishing.
+// An exposed port may represent a range of ports, host and destination ranges are of equal length.
 type ExposedPort interface {
+	// Family returns the address family of the host address, empty when the port is published for every family.
+	Family() string
+	// HostAddress returns the host address to publish the port on or nil, if the port is published on all addresses.
+	HostAddress() *string
 	Interface() *string
 	HostPort() int
+	// HostPortEnd returns the last host port of the range, equal to HostPort() for a single port.
+	HostPortEnd() int
 	DestinationPort() int
+	// DestinationPortEnd returns the last destination port of the range, equal to DestinationPort() for a single port.
+	DestinationPortEnd() int
 	Protocol() string
 
+	// Expand returns ports which can be published with a single rule each.
+	Expand() []ExposedPort
+	// String returns the string representation of this port, parseable with ExposedPortFromString.
+	String() string
+
 	ToForwardRulespec(targetAddress string) []string
 	ToNATRulespec(targetAddress string) []string
 }
 
 type defaultExposedPort struct {
-	iface           *string
-	hostPort        int
-	destinationPort int
-	protocol        string
+	hostAddress        *string
+	iface              *string
+	hostPort           int
+	hostPortEnd        int
+	destinationPort    int
+	destinationPortEnd int
+	protocol           string
+	// protocolGiven is false when the input did not specify the protocol:
+	protocolGiven bool
+}
+
+// Family returns the address family of the host address, empty when the port is published for every family.
+func (p *defaultExposedPort) Family() string {
+	if p.hostAddress == nil {
+		return ""
+	}
+	return addressFamily(*p.hostAddress)
+}
+
+// HostAddress returns the host address to publish the port on or nil, if the port is published on all addresses.
+func (p *defaultExposedPort) HostAddress() *string {
+	return p.hostAddress
 }
 
 // Interface returns the exposed interface or nil, if port should be exposed on all interfaces.
@@ -38,23 +89,119 @@ func (p *defaultExposedPort) HostPort() int {
 	return p.hostPort
 }
 
+// HostPortEnd returns the last host port of the range, equal to HostPort() for a single port.
+func (p *defaultExposedPort) HostPortEnd() int {
+	return p.hostPortEnd
+}
+
 // DestinationPort returns the guest destination port value.
 func (p *defaultExposedPort) DestinationPort() int {
 	return p.destinationPort
 }
 
-// Protocol returns the protocol value: tcp or udp.
+// DestinationPortEnd returns the last destination port of the range, equal to DestinationPort() for a single port.
+func (p *defaultExposedPort) DestinationPortEnd() int {
+	return p.destinationPortEnd
+}
+
+// Protocol returns the protocol value: tcp, udp or both.
 func (p *defaultExposedPort) Protocol() string {
 	return p.protocol
 }
 
+// Expand returns ports which can be published with a single rule each.
+// A port published for both protocols expands to a tcp and an udp port.
+// A range with the same host and destination ports is published with a single multiport rule,
+// a range with different host and destination ports expands to a port per range item.
+func (p *defaultExposedPort) Expand() []ExposedPort {
+	if p.protocol != protocolBoth && p.hostPort == p.destinationPort {
+		return []ExposedPort{p}
+	}
+	protocols := []string{p.protocol}
+	if p.protocol == protocolBoth {
+		protocols = []string{protocolTCP, protocolUDP}
+	}
+	expanded := []ExposedPort{}
+	for _, protocol := range protocols {
+		if p.hostPort == p.destinationPort {
+			expanded = append(expanded, p.withProtocol(protocol))
+			continue
+		}
+		for offset := 0; offset <= p.hostPortEnd-p.hostPort; offset++ {
+			expanded = append(expanded, &defaultExposedPort{
+				hostAddress:        p.hostAddress,
+				iface:              p.iface,
+				hostPort:           p.hostPort + offset,
+				hostPortEnd:        p.hostPort + offset,
+				destinationPort:    p.destinationPort + offset,
+				destinationPortEnd: p.destinationPort + offset,
+				protocol:           protocol,
+				protocolGiven:      true,
+			})
+		}
+	}
+	return expanded
+}
+
+// String returns the string representation of this port, parseable with ExposedPortFromString.
+func (p *defaultExposedPort) String() string {
+	if target := p.hostTarget(); target != "" {
+		return fmt.Sprintf("%s:%s:%s/%s", target, p.hostPortString("-"), p.destinationPortString("-"), p.Protocol())
+	}
+	return fmt.Sprintf("%s:%s/%s", p.hostPortString("-"), p.destinationPortString("-"), p.Protocol())
+}
+
+// hostTarget returns the interface or the host address as given in the input,
+// IPv6 addresses are enclosed in square brackets.
+func (p *defaultExposedPort) hostTarget() string {
+	if p.hostAddress != nil {
+		if addressFamily(*p.hostAddress) == FamilyIPv6 {
+			return "[" + *p.hostAddress + "]"
+		}
+		return *p.hostAddress
+	}
+	if p.iface != nil {
+		return *p.iface
+	}
+	return ""
+}
+
+func (p *defaultExposedPort) withProtocol(protocol string) *defaultExposedPort {
+	return &defaultExposedPort{
+		hostAddress:        p.hostAddress,
+		iface:              p.iface,
+		hostPort:           p.hostPort,
+		hostPortEnd:        p.hostPortEnd,
+		destinationPort:    p.destinationPort,
+		destinationPortEnd: p.destinationPortEnd,
+		protocol:           protocol,
+		protocolGiven:      true,
+	}
+}
+
+func (p *defaultExposedPort) hostPortString(rangeSeparator string) string {
+	return portRangeString(p.hostPort, p.hostPortEnd, rangeSeparator)
+}
+
+func (p *defaultExposedPort) destinationPortString(rangeSeparator string) string {
+	return portRangeString(p.destinationPort, p.destinationPortEnd, rangeSeparator)
+}
+
+// toDportRulespec returns the destination port match, ranges are matched with multiport.
+func (p *defaultExposedPort) toDportRulespec() []string {
+	if p.hostPort == p.hostPortEnd {
+		return []string{"--dport", p.hostPortString(":")}
+	}
+	return []string{"-m", "multiport", "--dports", p.hostPortString(":")}
+}
+
 func (p *defaultExposedPort) toCommentValue() string {
-	return fmt.Sprintf("firebuild:%s:%d:%d:/%s", func() string {
-		if p.Interface() == nil {
-			return "*"
+	return fmt.Sprintf("firebuild:%s:%s:%s:/%s", func() string {
+		if target := p.hostTarget(); target != "" {
+			return target
 		}
-		return *p.Interface()
-	}(), p.HostPort(), p.DestinationPort(), p.Protocol())
+		return "*"
+	}(), p.hostPortString("-"), p.destinationPortString("-"), p.Protocol())
 }
 
 // ToForwardRulespec returns the forward chain rulespec for this port.
@@ -63,23 +210,41 @@ func (p *defaultExposedPort) ToForwardRulespec(targetAddress string) []string {
 	if p.Interface() != nil {
 		rulespec = append(rulespec, "-i", *p.Interface())
 	}
-	return append(rulespec, "-d", targetAddress, "--dport", fmt.Sprintf("%d", p.HostPort()), "-m", "state", "--state", "NEW,ESTABLISHED,RELATED", "-j", "ACCEPT")
+	rulespec = append(rulespec, "-d", targetAddress)
+	rulespec = append(rulespec, p.toDportRulespec()...)
+	return append(rulespec, "-m", "state", "--state", "NEW,ESTABLISHED,RELATED", "-j", "ACCEPT")
 }
 
 // ToNATRulespec returns the forward chain rulespec for this port.
+// A range must have the same host and destination ports, other ranges must be expanded first.
 func (p *defaultExposedPort) ToNATRulespec(targetAddress string) []string {
 	rulespec := []string{"-m", "comment", "--comment", p.toCommentValue(), "-p", p.Protocol()}
 	if p.Interface() != nil {
 		rulespec = append(rulespec, "-i", *p.Interface())
 	}
-	return append(rulespec, "--dport", fmt.Sprintf("%d", p.HostPort()), "-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%d", targetAddress, p.DestinationPort()))
+	if p.HostAddress() != nil {
+		rulespec = append(rulespec, "-d", *p.HostAddress())
+	}
+	rulespec = append(rulespec, p.toDportRulespec()...)
+	if p.hostPort != p.hostPortEnd {
+		// DNAT without a port keeps the original destination port:
+		return append(rulespec, "-j", "DNAT", "--to-destination", targetAddress)
+	}
+	if addressFamily(targetAddress) == FamilyIPv6 {
+		return append(rulespec, "-j", "DNAT", "--to-destination", fmt.Sprintf("[%s]:%d", targetAddress, p.DestinationPort()))
+	}
+	return append(rulespec, "-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%d", targetAddress, p.DestinationPort()))
 }
 
 var (
-	extractionRegex = regexp.MustCompile("^((.[^:]*):)?((\\d{2,5}):)?(\\d{2,5})(\\/[a-z]{3})?$")
+	portRangeRegex  = regexp.MustCompile("^\\d+(-\\d+)?$")
+	extractionRegex = regexp.MustCompile("^((\\[[0-9a-fA-F:.]+\\]|[^:\\[\\]]+):)?((\\d{1,5}(?:-\\d{1,5})?):)?(\\d{1,5}(?:-\\d{1,5})?)(\\/[a-z]{3,4})?$")
 )
 
 // ExposedPortFromString attempts to parse the input as an exposed port.
+// Ports may be given as ranges, for example 8000-8010 or 8000-8010:9000-9010.
+// Instead of the interface, a host address may be given, IPv6 addresses are enclosed
+// in square brackets, for example [2001:db8::1]:8080:80.
 func ExposedPortFromString(input string) (ExposedPort, error) {
 	matches := extractionRegex.FindAllStringSubmatch(input, -1)
 	if len(matches) == 0 {
@@ -100,40 +265,62 @@ func ExposedPortFromString(input string) (ExposedPort, error) {
 		}
 		newValues = append(newValues, v)
 	}
+	// numeric values are always ports, report out of range ports instead of
+	// parsing them as an interface name:
+	for _, v := range newValues {
+		if !portRangeRegex.MatchString(v) {
+			continue
+		}
+		if _, parseErr := parsedPortRangeOrError(v); parseErr != nil {
+			return nil, errors.Wrapf(parseErr, "input %q cannot be parsed as exposed value", input)
+		}
+	}
 
 	if len(newValues) == 4 {
 		// interface:host-port:dest-port:protocol format
-		intVal1, parseErr1 := parsedPortOrError(newValues[1])
-		intVal2, parseErr2 := parsedPortOrError(newValues[2])
+		range1, parseErr1 := parsedPortRangeOrError(newValues[1])
+		range2, parseErr2 := parsedPortRangeOrError(newValues[2])
 		if parseErr1 != nil || parseErr2 != nil { // both middle values must be valid port numbers
 			return nil, fmt.Errorf("input %q cannot be parsed as exposed value", input)
 		}
 		if !validProtocol(newValues[3]) {
 			return nil, fmt.Errorf("value %q is not a valid protocol", newValues[3])
 		}
-		return &defaultExposedPort{iface: pstring(newValues[0]), hostPort: intVal1, destinationPort: intVal2, protocol: newValues[3][1:]}, nil
+		return newExposedPort(pstring(newValues[0]), range1, range2, newValues[3][1:], true)
 	}
 
 	if len(newValues) == 3 {
 		// interface:host-port:dest-port format
 		// or
+		// interface:dest-port:protocol format
+		// or
 		// host-port:dest-port:protocol format
-		intVal1, parseErr1 := parsedPortOrError(newValues[0])
-		intVal2, parseErr2 := parsedPortOrError(newValues[1])
-		intVal3, parseErr3 := parsedPortOrError(newValues[2])
+		range1, parseErr1 := parsedPortRangeOrError(newValues[0])
+		range2, parseErr2 := parsedPortRangeOrError(newValues[1])
+		range3, parseErr3 := parsedPortRangeOrError(newValues[2])
+
+		if parseErr1 != nil && parseErr2 == nil && parseErr3 != nil { // interface:dest-port:protocol format
+			if !validProtocol(newValues[2]) {
+				return nil, fmt.Errorf("value %q is not a valid protocol", newValues[2])
+			}
+			return newExposedPort(pstring(newValues[0]), range2, range2, newValues[2][1:], true)
+		}
 
 		if parseErr1 != nil { // expected interface:host-port:dest-port format
 			if parseErr2 != nil || parseErr3 != nil { // but 1 and 2 failed as port values, no match
 				return nil, fmt.Errorf("input %q cannot be parsed as exposed value", input)
 			}
-			return &defaultExposedPort{iface: pstring(newValues[0]), hostPort: intVal2, destinationPort: intVal3, protocol: defaultProtocol}, nil
+			return newExposedPort(pstring(newValues[0]), range2, range3, defaultProtocol, false)
 		}
 
 		if parseErr3 != nil { // expected host-port:dest-port:protocol format
 			if parseErr1 != nil || parseErr2 != nil { // but 0 and 1 failed as port values, no match
 				return nil, fmt.Errorf("input %q cannot be parsed as exposed value", input)
 			}
-			return &defaultExposedPort{iface: nil, hostPort: intVal1, destinationPort: intVal2, protocol: newValues[2][1:]}, nil
+			if !validProtocol(newValues[2]) {
+				return nil, fmt.Errorf("value %q is not a valid protocol", newValues[2])
+			}
+			return newExposedPort(nil, range1, range2, newValues[2][1:], true)
 		}
 
 		return nil, fmt.Errorf("input %q cannot be parsed as exposed value", input)
@@ -147,22 +334,22 @@ func ExposedPortFromString(input string) (ExposedPort, error) {
 		// or
 		// dest-port:protocol format
 
-		intVal1, parseErr1 := parsedPortOrError(newValues[0])
-		intVal2, parseErr2 := parsedPortOrError(newValues[1])
+		range1, parseErr1 := parsedPortRangeOrError(newValues[0])
+		range2, parseErr2 := parsedPortRangeOrError(newValues[1])
 
 		if parseErr1 == nil && parseErr2 == nil { // host-port:dest-port format
-			return &defaultExposedPort{iface: nil, hostPort: intVal1, destinationPort: intVal2, protocol: defaultProtocol}, nil
+			return newExposedPort(nil, range1, range2, defaultProtocol, false)
 		}
 
 		if parseErr1 != nil && parseErr2 == nil { // interface:dest-port format
-			return &defaultExposedPort{iface: pstring(newValues[0]), hostPort: intVal2, destinationPort: intVal2, protocol: defaultProtocol}, nil
+			return newExposedPort(pstring(newValues[0]), range2, range2, defaultProtocol, false)
 		}
 
 		if parseErr1 == nil && parseErr2 != nil { // dest-port:protocol format
 			if !validProtocol(newValues[1]) {
 				return nil, fmt.Errorf("value %q is not a valid protocol", newValues[1])
 			}
-			return &defaultExposedPort{iface: nil, hostPort: intVal1, destinationPort: intVal1, protocol: newValues[1][1:]}, nil
+			return newExposedPort(nil, range1, range1, newValues[1][1:], true)
 		}
 
 		// both errors are not nil, invalid state:
@@ -171,33 +358,169 @@ func ExposedPortFromString(input string) (ExposedPort, error) {
 
 	if len(newValues) == 1 {
 		// dest-port only:
-		intVal, parseErr := parsedPortOrError(newValues[0])
+		portRange, parseErr := parsedPortRangeOrError(newValues[0])
 		if parseErr != nil {
 			return nil, parseErr
 		}
-		return &defaultExposedPort{iface: nil, hostPort: intVal, destinationPort: intVal, protocol: defaultProtocol}, nil
+		return newExposedPort(nil, portRange, portRange, defaultProtocol, false)
 	}
 
 	return nil, fmt.Errorf("input not valid")
 }
 
+// WithExposedProtocols returns the ports with the protocol defaulted to the protocol
+// of the exposed port with the same destination port, for ports parsed without a protocol.
+func WithExposedProtocols(ports []ExposedPort, exposed []ExposedPort) []ExposedPort {
+	exposedProtocols := map[int]string{}
+	for _, exposedPort := range exposed {
+		for port := exposedPort.DestinationPort(); port <= exposedPort.DestinationPortEnd(); port++ {
+			if current, ok := exposedProtocols[port]; ok && current != exposedPort.Protocol() {
+				// EXPOSE 53/tcp 53/udp:
+				exposedProtocols[port] = protocolBoth
+				continue
+			}
+			exposedProtocols[port] = exposedPort.Protocol()
+		}
+	}
+	output := []ExposedPort{}
+	for _, port := range ports {
+		defaultPort, ok := port.(*defaultExposedPort)
+		if !ok || defaultPort.protocolGiven {
+			output = append(output, port)
+			continue
+		}
+		if protocol, ok := exposedProtocols[port.DestinationPort()]; ok {
+			output = append(output, defaultPort.withProtocol(protocol))
+			continue
+		}
+		output = append(output, port)
+	}
+	return output
+}
+
+// IsPrivileged returns true when the port publishes a privileged host port, lower than 1024.
+// Binding a privileged port requires elevated privileges.
+func IsPrivileged(port ExposedPort) bool {
+	return port.HostPort() < unprivilegedPortStart
+}
+
+// CheckPrivilegedPorts returns an error for the first port publishing a privileged host port,
+// unless the privileged ports are allowed.
+func CheckPrivilegedPorts(ports []ExposedPort, allowPrivileged bool) error {
+	if allowPrivileged {
+		return nil
+	}
+	for _, port := range ports {
+		if IsPrivileged(port) {
+			return fmt.Errorf("port %s publishes privileged host port %d, host ports lower than %d require --allow-privileged-ports",
+				port.String(), port.HostPort(), unprivilegedPortStart)
+		}
+	}
+	return nil
+}
+
+type portRange struct {
+	start int
+	end   int
+}
+
+func newExposedPort(target *string, hostPorts, destinationPorts portRange, protocol string, protocolGiven bool) (ExposedPort, error) {
+	if hostPorts.end-hostPorts.start != destinationPorts.end-destinationPorts.start {
+		return nil, fmt.Errorf("host port range %s and destination port range %s are not of equal length",
+			portRangeString(hostPorts.start, hostPorts.end, "-"),
+			portRangeString(destinationPorts.start, destinationPorts.end, "-"))
+	}
+	iface, hostAddress, err := parsedHostTargetOrError(target)
+	if err != nil {
+		return nil, err
+	}
+	return &defaultExposedPort{
+		hostAddress:        hostAddress,
+		iface:              iface,
+		hostPort:           hostPorts.start,
+		hostPortEnd:        hostPorts.end,
+		destinationPort:    destinationPorts.start,
+		destinationPortEnd: destinationPorts.end,
+		protocol:           protocol,
+		protocolGiven:      protocolGiven,
+	}, nil
+}
+
+func portRangeString(start, end int, rangeSeparator string) string {
+	if start == end {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d%s%d", start, rangeSeparator, end)
+}
+
 func pstring(input string) *string {
 	return &input
 }
 
+// parsedHostTargetOrError returns the interface or the host address, the target is an address
+// when it parses as an IPv4 address or is enclosed in square brackets.
+func parsedHostTargetOrError(target *string) (*string, *string, error) {
+	if target == nil {
+		return nil, nil, nil
+	}
+	if strings.HasPrefix(*target, "[") {
+		address := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(*target, "["), "]"))
+		if address == nil || address.To4() != nil {
+			return nil, nil, fmt.Errorf("value %q is not a valid IPv6 address", *target)
+		}
+		return nil, pstring(address.String()), nil
+	}
+	if address := net.ParseIP(*target); address != nil {
+		if address.To4() == nil {
+			return nil, nil, fmt.Errorf("IPv6 address %q must be enclosed in square brackets", *target)
+		}
+		return nil, pstring(address.String()), nil
+	}
+	return target, nil, nil
+}
+
+// addressFamily returns the address family of the IP address.
+func addressFamily(address string) string {
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		return FamilyIPv6
+	}
+	return FamilyIPv4
+}
+
 func parsedPortOrError(input string) (int, error) {
 	intVal, parseErr := strconv.Atoi(input)
 	if parseErr != nil {
 		return 0, errors.Wrap(parseErr, "string is not a valid exposed port")
 	}
 	if !validPort(intVal) {
-		return 0, fmt.Errorf("value %d is not a valid port", intVal)
+		return 0, fmt.Errorf("port %d is out of range, must be between %d and %d", intVal, minPort, maxPort)
 	}
 	return intVal, nil
 }
+func parsedPortRangeOrError(input string) (portRange, error) {
+	parts := strings.Split(input, "-")
+	if len(parts) > 2 {
+		return portRange{}, fmt.Errorf("value %q is not a valid port range", input)
+	}
+	start, parseErr := parsedPortOrError(parts[0])
+	if parseErr != nil {
+		return portRange{}, parseErr
+	}
+	if len(parts) == 1 {
+		return portRange{start: start, end: start}, nil
+	}
+	end, parseErr := parsedPortOrError(parts[1])
+	if parseErr != nil {
+		return portRange{}, parseErr
+	}
+	if end < start {
+		return portRange{}, fmt.Errorf("value %q is not a valid port range", input)
+	}
+	return portRange{start: start, end: end}, nil
+}
 func validPort(v int) bool {
-	return v > 0 && v < 65535
+	return v >= minPort && v <= maxPort
 }
 func validProtocol(v string) bool {
-	return v == "/tcp" || v == "/udp"
+	return v == "/tcp" || v == "/udp" || v == "/both"
 }
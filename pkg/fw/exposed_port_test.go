@@ -1,6 +1,7 @@
 package fw
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -104,3 +105,203 @@ func TestExposedPortExtractSuccess(t *testing.T) {
 	assert.Equal(t, ep.Protocol(), defaultProtocol)
 
 }
+
+func TestExposedPortBothProtocols(t *testing.T) {
+
+	ep, err := ExposedPortFromString("eno1:8053:53/both")
+	assert.Nil(t, err)
+	assert.Equal(t, ep.Protocol(), "both")
+	assert.Equal(t, "eno1:8053:53/both", ep.String())
+
+	expanded := ep.Expand()
+	assert.Equal(t, 2, len(expanded))
+	assert.Equal(t, []string{"-m", "comment", "--comment", "firebuild:eno1:8053:53:/tcp",
+		"-p", "tcp", "-i", "eno1", "--dport", "8053",
+		"-j", "DNAT", "--to-destination", "127.0.0.1:53"}, expanded[0].ToNATRulespec("127.0.0.1"))
+	assert.Equal(t, []string{"-m", "comment", "--comment", "firebuild:eno1:8053:53:/udp",
+		"-p", "udp", "-i", "eno1", "--dport", "8053",
+		"-j", "DNAT", "--to-destination", "127.0.0.1:53"}, expanded[1].ToNATRulespec("127.0.0.1"))
+
+	udp, err := ExposedPortFromString("8053/udp")
+	assert.Nil(t, err)
+	assert.Equal(t, []ExposedPort{udp}, udp.Expand())
+	assert.Equal(t, "8053:8053/udp", udp.String())
+
+	_, err = ExposedPortFromString("8053:53/definitelynot")
+	assert.NotNil(t, err)
+}
+
+func TestExposedPortWithExposedProtocols(t *testing.T) {
+
+	exposed := []ExposedPort{}
+	for _, input := range []string{"53/udp", "514/tcp", "514/udp", "80"} {
+		ep, err := ExposedPortFromString(input)
+		assert.Nil(t, err)
+		exposed = append(exposed, ep)
+	}
+
+	ports := []ExposedPort{}
+	for _, input := range []string{"8053:53", "514", "8080:80", "9053:53/tcp", "443"} {
+		ep, err := ExposedPortFromString(input)
+		assert.Nil(t, err)
+		ports = append(ports, ep)
+	}
+
+	resolved := WithExposedProtocols(ports, exposed)
+	resolvedStrings := []string{}
+	for _, port := range resolved {
+		resolvedStrings = append(resolvedStrings, port.String())
+	}
+	assert.Equal(t, []string{"8053:53/udp", "514:514/both", "8080:80/tcp", "9053:53/tcp", "443:443/tcp"}, resolvedStrings)
+}
+
+func TestExposedPortRanges(t *testing.T) {
+
+	ep, err := ExposedPortFromString("8000-8010")
+	assert.Nil(t, err)
+	assert.Nil(t, ep.Interface())
+	assert.Equal(t, 8000, ep.HostPort())
+	assert.Equal(t, 8010, ep.HostPortEnd())
+	assert.Equal(t, 8000, ep.DestinationPort())
+	assert.Equal(t, 8010, ep.DestinationPortEnd())
+	assert.Equal(t, "8000-8010:8000-8010/tcp", ep.String())
+
+	// equal host and destination ranges are published with a single multiport rule:
+	assert.Equal(t, []ExposedPort{ep}, ep.Expand())
+	assert.Equal(t, []string{"-m", "comment", "--comment", "firebuild:*:8000-8010:8000-8010:/tcp",
+		"-p", "tcp", "-d", "127.0.0.1", "-m", "multiport", "--dports", "8000:8010",
+		"-m", "state", "--state", "NEW,ESTABLISHED,RELATED", "-j", "ACCEPT"}, ep.ToForwardRulespec("127.0.0.1"))
+	assert.Equal(t, []string{"-m", "comment", "--comment", "firebuild:*:8000-8010:8000-8010:/tcp",
+		"-p", "tcp", "-m", "multiport", "--dports", "8000:8010",
+		"-j", "DNAT", "--to-destination", "127.0.0.1"}, ep.ToNATRulespec("127.0.0.1"))
+
+	ep, err = ExposedPortFromString("eno1:8000-8002:9000-9002/udp")
+	assert.Nil(t, err)
+	assert.NotNil(t, ep.Interface())
+	assert.Equal(t, 8000, ep.HostPort())
+	assert.Equal(t, 8002, ep.HostPortEnd())
+	assert.Equal(t, 9000, ep.DestinationPort())
+	assert.Equal(t, 9002, ep.DestinationPortEnd())
+	assert.Equal(t, "udp", ep.Protocol())
+
+	// different host and destination ranges are published port by port:
+	expanded := ep.Expand()
+	assert.Equal(t, 3, len(expanded))
+	for idx, port := range expanded {
+		assert.Equal(t, []string{"-m", "comment", "--comment", fmt.Sprintf("firebuild:eno1:%d:%d:/udp", 8000+idx, 9000+idx),
+			"-p", "udp", "-i", "eno1", "--dport", fmt.Sprintf("%d", 8000+idx),
+			"-j", "DNAT", "--to-destination", fmt.Sprintf("127.0.0.1:%d", 9000+idx)}, port.ToNATRulespec("127.0.0.1"))
+	}
+
+	ep, err = ExposedPortFromString("8000-8001:9000-9001/both")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(ep.Expand()))
+
+	ep, err = ExposedPortFromString("8080:80")
+	assert.Nil(t, err)
+	assert.Equal(t, ep.HostPort(), ep.HostPortEnd())
+	assert.Equal(t, ep.DestinationPort(), ep.DestinationPortEnd())
+}
+
+func TestExposedPortRangesFail(t *testing.T) {
+
+	_, err1 := ExposedPortFromString("8000-8010:9000-9005")
+	assert.NotNil(t, err1)
+	assert.Equal(t, "host port range 8000-8010 and destination port range 9000-9005 are not of equal length", err1.Error())
+
+	_, err2 := ExposedPortFromString("8000:9000-9005/tcp")
+	assert.NotNil(t, err2)
+
+	_, err3 := ExposedPortFromString("eno1:8000-8010:9000/udp")
+	assert.NotNil(t, err3)
+
+	_, err4 := ExposedPortFromString("8010-8000")
+	assert.NotNil(t, err4)
+
+	_, err5 := ExposedPortFromString("8000-8010-8020")
+	assert.NotNil(t, err5)
+}
+
+func TestExposedPortBoundaries(t *testing.T) {
+	for _, input := range []string{"1", "1023", "1024", "65535", "65535:1/udp", "eno1:1:65535", "65530-65535"} {
+		_, err := ExposedPortFromString(input)
+		assert.Nil(t, err, fmt.Sprintf("expected %q to parse", input))
+	}
+	for _, input := range []string{"0", "65536", "0:80", "65536:80", "80:0/tcp", "eno1:65536:80", "eno1:80:0/udp", "65530-65536", "0-10"} {
+		_, err := ExposedPortFromString(input)
+		assert.NotNil(t, err, fmt.Sprintf("expected %q to fail", input))
+	}
+
+	_, err := ExposedPortFromString("65536:80")
+	assert.Contains(t, err.Error(), "port 65536 is out of range")
+}
+
+func TestExposedPortPrivileged(t *testing.T) {
+	privileged := []string{"1", "1023", "eno1:80:8080", "1000-1030:2000-2030"}
+	unprivileged := []string{"1024", "65535", "1024:80", "eno1:8080:80/udp"}
+	for _, input := range privileged {
+		port, err := ExposedPortFromString(input)
+		assert.Nil(t, err)
+		assert.True(t, IsPrivileged(port), fmt.Sprintf("expected %q to be privileged", input))
+		assert.NotNil(t, CheckPrivilegedPorts([]ExposedPort{port}, false))
+		assert.Nil(t, CheckPrivilegedPorts([]ExposedPort{port}, true))
+	}
+	for _, input := range unprivileged {
+		port, err := ExposedPortFromString(input)
+		assert.Nil(t, err)
+		assert.False(t, IsPrivileged(port), fmt.Sprintf("expected %q not to be privileged", input))
+		assert.Nil(t, CheckPrivilegedPorts([]ExposedPort{port}, false))
+	}
+}
+
+func TestExposedPortHostAddress(t *testing.T) {
+
+	ep, err := ExposedPortFromString("[2001:db8::1]:8080:80/tcp")
+	assert.Nil(t, err)
+	assert.Nil(t, ep.Interface())
+	assert.Equal(t, "2001:db8::1", *ep.HostAddress())
+	assert.Equal(t, FamilyIPv6, ep.Family())
+	assert.Equal(t, 8080, ep.HostPort())
+	assert.Equal(t, 80, ep.DestinationPort())
+	assert.Equal(t, "[2001:db8::1]:8080:80/tcp", ep.String())
+	assert.Equal(t, []string{"-m", "comment", "--comment", "firebuild:[2001:db8::1]:8080:80:/tcp",
+		"-p", "tcp", "-d", "2001:db8::1", "--dport", "8080",
+		"-j", "DNAT", "--to-destination", "[fd00::2]:80"}, ep.ToNATRulespec("fd00::2"))
+	assert.Equal(t, []string{"-m", "comment", "--comment", "firebuild:[2001:db8::1]:8080:80:/tcp",
+		"-p", "tcp", "-d", "fd00::2", "--dport", "8080",
+		"-m", "state", "--state", "NEW,ESTABLISHED,RELATED", "-j", "ACCEPT"}, ep.ToForwardRulespec("fd00::2"))
+
+	// the address is normalized:
+	ep, err = ExposedPortFromString("[2001:0db8:0000::0001]:53/udp")
+	assert.Nil(t, err)
+	assert.Equal(t, "[2001:db8::1]:53:53/udp", ep.String())
+
+	ep, err = ExposedPortFromString("[::]:8000-8010")
+	assert.Nil(t, err)
+	assert.Equal(t, "::", *ep.HostAddress())
+	assert.Equal(t, 8010, ep.HostPortEnd())
+
+	ep, err = ExposedPortFromString("192.168.0.10:8080:80")
+	assert.Nil(t, err)
+	assert.Nil(t, ep.Interface())
+	assert.Equal(t, "192.168.0.10", *ep.HostAddress())
+	assert.Equal(t, FamilyIPv4, ep.Family())
+	assert.Equal(t, []string{"-m", "comment", "--comment", "firebuild:192.168.0.10:8080:80:/tcp",
+		"-p", "tcp", "-d", "192.168.0.10", "--dport", "8080",
+		"-j", "DNAT", "--to-destination", "127.0.0.1:80"}, ep.ToNATRulespec("127.0.0.1"))
+
+	// an interface has no family, the port is published for every family:
+	ep, err = ExposedPortFromString("eno1:8080:80")
+	assert.Nil(t, err)
+	assert.Nil(t, ep.HostAddress())
+	assert.Equal(t, "", ep.Family())
+
+	ep, err = ExposedPortFromString("eno1:53/udp")
+	assert.Nil(t, err)
+	assert.Equal(t, "eno1:53:53/udp", ep.String())
+
+	for _, input := range []string{"[192.168.0.10]:8080:80", "[2001:db8::zz]:8080:80", "[eno1]:80", "2001:db8::1:8080:80", "[2001:db8::1:8080:80"} {
+		_, err := ExposedPortFromString(input)
+		assert.NotNil(t, err, fmt.Sprintf("expected %q to fail", input))
+	}
+}
@@ -37,14 +37,13 @@ const (
 type IPTManager interface {
 	// Publish publishes exposed ports. Creates a nat table chain if necessary.
 	Publish([]ExposedPort) error
-	// Unpublish removes exposed ports. Removes the nat table chain if necessary.
+	// Unpublish removes exposed ports. Removes the nat table chain once no ports are published.
 	Unpublish([]ExposedPort) error
 }
 
 type defaultManager struct {
-	ipt       *iptables.IPTables
-	vmID      string
-	ipAddress string
+	tables []*familyTables
+	vmID   string
 
 	lock               flock.Lock
 	lockAcquireTimeout time.Duration
@@ -52,31 +51,52 @@ type defaultManager struct {
 	natChainName       string
 }
 
+// familyTables is the iptables or ip6tables handle for the guest address of the family.
+type familyTables struct {
+	family    string
+	ipt       *iptables.IPTables
+	ipAddress string
+}
+
 // NewManager returns a publisher with configured firebuild filter chain in the filter table.
+// The ports are published to every guest address, IPv6 addresses are published with ip6tables.
 // If chain fails to initialize, returns an error.
 // Locking happens in:
 // - ensureFilterChain, called only when creating new manager
 // - in Publish
 // - in Unpublish
-func NewManager(vmID, ipAddress string) (IPTManager, error) {
+func NewManager(vmID string, ipAddresses ...string) (IPTManager, error) {
 
 	acquiteTimeout, err := time.ParseDuration(utils.GetenvOrDefault(FirebuildFlockAcquireTimeoutEnvVarName, FirebuildFlockDefaultAcquireTimeout))
 	if err != nil {
 		return nil, err
 	}
 
-	ipt, err := iptables.New()
-	if err != nil {
-		return nil, err
+	tables := []*familyTables{}
+	for _, ipAddress := range ipAddresses {
+		family := addressFamily(ipAddress)
+		for _, existing := range tables {
+			if existing.family == family {
+				return nil, fmt.Errorf("more than one %s guest address given: %s and %s", family, existing.ipAddress, ipAddress)
+			}
+		}
+		protocol := iptables.ProtocolIPv4
+		if family == FamilyIPv6 {
+			protocol = iptables.ProtocolIPv6
+		}
+		ipt, err := iptables.NewWithProtocol(protocol)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed creating %s tables handle", family)
+		}
+		tables = append(tables, &familyTables{family: family, ipt: ipt, ipAddress: ipAddress})
 	}
 
-	publisher := &defaultManager{ipt: ipt,
+	publisher := &defaultManager{tables: tables,
 		vmID:               vmID,
-		ipAddress:          ipAddress,
 		lock:               flock.New(utils.GetenvOrDefault(FirebuildFlockFileEnvVarName, FirebuildFlockDefaultFile)),
 		lockAcquireTimeout: acquiteTimeout,
 		filterChainName:    utils.GetenvOrDefault(FirebuildIptFilterChainNameEnvVarName, FirebuildIptDefaultFilterChainName),
-		natChainName:       fmt.Sprintf("FBD-%s", vmID)}
+		natChainName:       PortsChainName(vmID)}
 	if err := publisher.ensureFilterChain(); err != nil {
 		return nil, err
 	}
@@ -94,18 +114,24 @@ func (p *defaultManager) Publish(ports []ExposedPort) error {
 	if err := p.ensureNATChain(); err != nil {
 		return err
 	}
-	for _, port := range ports {
-		if err := p.ipt.AppendUnique("filter", p.filterChainName, port.ToForwardRulespec(p.ipAddress)...); err != nil {
-			return errors.Wrapf(err, "failed exposing filter table port: %s", port)
+	for _, port := range expandPorts(ports) {
+		tables, err := p.portTables(port)
+		if err != nil {
+			return err
 		}
-		if err := p.ipt.AppendUnique("nat", p.natChainName, port.ToNATRulespec(p.ipAddress)...); err != nil {
-			return errors.Wrapf(err, "failed exposing nat table port: %s", port)
+		for _, t := range tables {
+			if err := t.ipt.AppendUnique("filter", p.filterChainName, port.ToForwardRulespec(t.ipAddress)...); err != nil {
+				return errors.Wrapf(err, "failed exposing filter table port: %s", port)
+			}
+			if err := t.ipt.AppendUnique("nat", p.natChainName, port.ToNATRulespec(t.ipAddress)...); err != nil {
+				return errors.Wrapf(err, "failed exposing nat table port: %s", port)
+			}
 		}
 	}
 	return nil
 }
 
-// Unpublish removes exposed ports. Removes the nat table chain if necessary.
+// Unpublish removes exposed ports. Removes the nat table chain once no ports are published.
 func (p *defaultManager) Unpublish(ports []ExposedPort) error {
 
 	if err := p.lock.AcquireWithTimeout(p.lockAcquireTimeout); err != nil {
@@ -113,17 +139,37 @@ func (p *defaultManager) Unpublish(ports []ExposedPort) error {
 	}
 	defer p.lock.Release()
 
-	for _, port := range ports {
-		if err := p.ipt.DeleteIfExists("filter", p.filterChainName, port.ToForwardRulespec(p.ipAddress)...); err != nil {
-			return errors.Wrapf(err, "failed removing filter table port: %s", port)
-		}
-		if err := p.ipt.DeleteIfExists("nat", p.natChainName, port.ToNATRulespec(p.ipAddress)...); err != nil {
-			return errors.Wrapf(err, "failed removing nat table port: %s", port)
+	for _, port := range expandPorts(ports) {
+		for _, t := range p.tables {
+			// a port with a host address is published only for the family of the address:
+			if port.Family() != "" && port.Family() != t.family {
+				continue
+			}
+			if err := t.ipt.DeleteIfExists("filter", p.filterChainName, port.ToForwardRulespec(t.ipAddress)...); err != nil {
+				return errors.Wrapf(err, "failed removing filter table port: %s", port)
+			}
+			if err := t.ipt.DeleteIfExists("nat", p.natChainName, port.ToNATRulespec(t.ipAddress)...); err != nil {
+				return errors.Wrapf(err, "failed removing nat table port: %s", port)
+			}
 		}
 	}
 	return p.removeNATChain()
 }
 
+// portTables returns the tables to publish the port with, a port with a host address
+// is published only for the guest address of the same family.
+func (p *defaultManager) portTables(port ExposedPort) ([]*familyTables, error) {
+	if port.Family() == "" {
+		return p.tables, nil
+	}
+	for _, t := range p.tables {
+		if t.family == port.Family() {
+			return []*familyTables{t}, nil
+		}
+	}
+	return nil, fmt.Errorf("port %s can't be published: the VM has no %s address", port, port.Family())
+}
+
 func (p *defaultManager) ensureFilterChain() error {
 
 	if err := p.lock.AcquireWithTimeout(p.lockAcquireTimeout); err != nil {
@@ -131,35 +177,57 @@ func (p *defaultManager) ensureFilterChain() error {
 	}
 	defer p.lock.Release()
 
-	if err := ensureChain(p.ipt, "filter", p.filterChainName); err != nil {
-		return err
-	}
-	if err := p.ipt.AppendUnique("filter", "FORWARD", "-j", p.filterChainName); err != nil {
-		return err
+	for _, t := range p.tables {
+		if err := ensureChain(t.ipt, "filter", p.filterChainName); err != nil {
+			return err
+		}
+		if err := t.ipt.AppendUnique("filter", "FORWARD", "-j", p.filterChainName); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (p *defaultManager) ensureNATChain() error {
-	if err := ensureChain(p.ipt, "nat", p.natChainName); err != nil {
-		return err
-	}
-	if err := p.ipt.AppendUnique("nat", "PREROUTING", "-j", p.natChainName); err != nil {
-		return err
+	for _, t := range p.tables {
+		if err := ensureChain(t.ipt, "nat", p.natChainName); err != nil {
+			return err
+		}
+		if err := t.ipt.AppendUnique("nat", "PREROUTING", "-j", p.natChainName); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (p *defaultManager) removeNATChain() error {
-	if err := p.ipt.DeleteIfExists("nat", "PREROUTING", "-j", p.natChainName); err != nil {
-		return err
-	}
-	if err := removeChain(p.ipt, "nat", p.natChainName); err != nil {
-		return err
+	for _, t := range p.tables {
+		// other ports of the VM may still be published:
+		empty, err := isChainEmpty(t.ipt, "nat", p.natChainName)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			continue
+		}
+		if err := t.ipt.DeleteIfExists("nat", "PREROUTING", "-j", p.natChainName); err != nil {
+			return err
+		}
+		if err := removeChain(t.ipt, "nat", p.natChainName); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+func expandPorts(ports []ExposedPort) []ExposedPort {
+	expanded := []ExposedPort{}
+	for _, port := range ports {
+		expanded = append(expanded, port.Expand()...)
+	}
+	return expanded
+}
+
 func ensureChain(ipt *iptables.IPTables, table, name string) error {
 	exists, err := ipt.ChainExists(table, name)
 	if err != nil {
@@ -171,6 +239,20 @@ func ensureChain(ipt *iptables.IPTables, table, name string) error {
 	return nil
 }
 
+// isChainEmpty returns true if the chain does not exist or has no rules.
+func isChainEmpty(ipt *iptables.IPTables, table, name string) (bool, error) {
+	exists, err := ipt.ChainExists(table, name)
+	if err != nil || !exists {
+		return true, err
+	}
+	rules, err := ipt.List(table, name)
+	if err != nil {
+		return false, err
+	}
+	// the first rule is the chain definition:
+	return len(rules) <= 1, nil
+}
+
 func removeChain(ipt *iptables.IPTables, table, name string) error {
 	exists, err := ipt.ChainExists(table, name)
 	if err != nil {
@@ -0,0 +1,235 @@
+package fw
+
+import (
+	"strings"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/flock"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/pkg/errors"
+)
+
+const (
+	portsChainPrefix  = "FBD-"
+	egressChainPrefix = "FBE-"
+)
+
+// PortsChainName returns the name of the nat table chain with the published ports of the VMM.
+func PortsChainName(vmID string) string {
+	return portsChainPrefix + vmID
+}
+
+// VMMChainIDs returns the IDs of the VMMs with a published ports chain or an egress chain,
+// in iptables or ip6tables. The ip6tables chains are skipped if ip6tables is not available.
+func VMMChainIDs() ([]string, error) {
+	handles, err := orphanTables()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	for _, ipt := range handles {
+		for _, item := range []struct {
+			table  string
+			prefix string
+		}{{"nat", portsChainPrefix}, {"filter", egressChainPrefix}} {
+			chains, err := ipt.ListChains(item.table)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed listing %s table chains", item.table)
+			}
+			for _, chain := range chains {
+				if !strings.HasPrefix(chain, item.prefix) {
+					continue
+				}
+				vmID := strings.TrimPrefix(chain, item.prefix)
+				if vmID == "" || seen[vmID] {
+					continue
+				}
+				seen[vmID] = true
+				result = append(result, vmID)
+			}
+		}
+	}
+	return result, nil
+}
+
+// RemoveVMMChains removes the published ports chain and the egress chain of the VMM together with
+// the jumps to them and the firebuild filter chain rules forwarding the published ports to the VMM.
+// It cleans up after a VMM without the metadata, the published ports are resolved from the chain.
+// The forward rules of the keep addresses, the addresses of the running VMMs, are not removed.
+func RemoveVMMChains(vmID string, keepAddresses ...string) error {
+	handles, err := orphanTables()
+	if err != nil {
+		return err
+	}
+	acquireTimeout, err := time.ParseDuration(utils.GetenvOrDefault(FirebuildFlockAcquireTimeoutEnvVarName, FirebuildFlockDefaultAcquireTimeout))
+	if err != nil {
+		return err
+	}
+	lock := flock.New(utils.GetenvOrDefault(FirebuildFlockFileEnvVarName, FirebuildFlockDefaultFile))
+	if err := lock.AcquireWithTimeout(acquireTimeout); err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	keep := map[string]bool{}
+	for _, address := range keepAddresses {
+		keep[address] = true
+	}
+	filterChainName := utils.GetenvOrDefault(FirebuildIptFilterChainNameEnvVarName, FirebuildIptDefaultFilterChainName)
+
+	for _, ipt := range handles {
+		portsChain := PortsChainName(vmID)
+		exists, err := ipt.ChainExists("nat", portsChain)
+		if err != nil {
+			return err
+		}
+		if exists {
+			rules, err := ipt.List("nat", portsChain)
+			if err != nil {
+				return err
+			}
+			addresses := map[string]bool{}
+			for _, rule := range rules {
+				if address := dnatAddress(splitListedRule(rule)); address != "" && !keep[address] {
+					addresses[address] = true
+				}
+			}
+			if err := deleteMatchingRules(ipt, "filter", filterChainName, func(args []string) bool {
+				return addresses[destinationAddress(args)]
+			}); err != nil {
+				return errors.Wrap(err, "failed removing published port forward rules")
+			}
+			if err := deleteMatchingRules(ipt, "nat", "PREROUTING", func(args []string) bool {
+				return ruleValue(args, "-j") == portsChain
+			}); err != nil {
+				return errors.Wrap(err, "failed removing published ports jump rule")
+			}
+			if err := ipt.ClearAndDeleteChain("nat", portsChain); err != nil {
+				return errors.Wrap(err, "failed removing published ports chain")
+			}
+		}
+
+		egressChain := EgressChainName(vmID)
+		exists, err = ipt.ChainExists("filter", egressChain)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err := deleteMatchingRules(ipt, "filter", "FORWARD", func(args []string) bool {
+				return ruleValue(args, "-j") == egressChain
+			}); err != nil {
+				return errors.Wrap(err, "failed removing egress jump rule")
+			}
+			if err := ipt.ClearAndDeleteChain("filter", egressChain); err != nil {
+				return errors.Wrap(err, "failed removing egress chain")
+			}
+		}
+	}
+	return nil
+}
+
+// orphanTables returns the iptables handle and the ip6tables handle, if ip6tables is available.
+func orphanTables() ([]*iptables.IPTables, error) {
+	ipt, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed creating iptables handle")
+	}
+	handles := []*iptables.IPTables{ipt}
+	if ip6t, err := iptables.NewWithProtocol(iptables.ProtocolIPv6); err == nil {
+		handles = append(handles, ip6t)
+	}
+	return handles, nil
+}
+
+// deleteMatchingRules deletes the rules of the chain matching the predicate, does nothing if the chain does not exist.
+func deleteMatchingRules(ipt *iptables.IPTables, table, chain string, predicate func([]string) bool) error {
+	exists, err := ipt.ChainExists(table, chain)
+	if err != nil || !exists {
+		return err
+	}
+	rules, err := ipt.List(table, chain)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		args := splitListedRule(rule)
+		if len(args) == 0 || !predicate(args) {
+			continue
+		}
+		if err := ipt.DeleteIfExists(table, chain, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitListedRule splits the rule in the iptables -S format into the rulespec arguments,
+// without the -A chain prefix. The chain definitions, -N and -P, result in no arguments.
+func splitListedRule(rule string) []string {
+	args := []string{}
+	var current strings.Builder
+	inQuotes, hasToken := false, false
+	for idx := 0; idx < len(rule); idx++ {
+		c := rule[idx]
+		switch {
+		case c == '\\' && inQuotes && idx+1 < len(rule):
+			idx = idx + 1
+			current.WriteByte(rule[idx])
+		case c == '"':
+			inQuotes = !inQuotes
+			hasToken = true
+		case c == ' ' && !inQuotes:
+			if hasToken {
+				args = append(args, current.String())
+				current.Reset()
+				hasToken = false
+			}
+		default:
+			current.WriteByte(c)
+			hasToken = true
+		}
+	}
+	if hasToken {
+		args = append(args, current.String())
+	}
+	if len(args) < 2 || args[0] != "-A" {
+		return []string{}
+	}
+	return args[2:]
+}
+
+// ruleValue returns the value following the flag in the rulespec, empty if the flag is not given.
+func ruleValue(args []string, flag string) string {
+	for idx := 0; idx < len(args)-1; idx++ {
+		if args[idx] == flag {
+			return args[idx+1]
+		}
+	}
+	return ""
+}
+
+// dnatAddress returns the DNAT target address of the rulespec, without the port.
+func dnatAddress(args []string) string {
+	target := ruleValue(args, "--to-destination")
+	switch {
+	case strings.HasPrefix(target, "["):
+		if end := strings.Index(target, "]"); end > 0 {
+			return target[1:end]
+		}
+		return ""
+	case strings.Count(target, ":") == 1:
+		return target[:strings.Index(target, ":")]
+	}
+	return target
+}
+
+// destinationAddress returns the single address destination of the rulespec, without the prefix length.
+func destinationAddress(args []string) string {
+	destination := ruleValue(args, "-d")
+	for _, suffix := range []string{"/32", "/128"} {
+		destination = strings.TrimSuffix(destination, suffix)
+	}
+	return destination
+}
@@ -0,0 +1,34 @@
+package fw
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitListedRule(t *testing.T) {
+	assert.Equal(t, []string{}, splitListedRule("-N FBD-vmm"))
+	assert.Equal(t, []string{}, splitListedRule("-P FORWARD ACCEPT"))
+	assert.Equal(t,
+		[]string{"-s", "192.168.127.2/32", "-m", "comment", "--comment", "firebuild:egress:vmm", "-j", "FBE-vmm"},
+		splitListedRule(`-A FORWARD -s 192.168.127.2/32 -m comment --comment "firebuild:egress:vmm" -j FBE-vmm`))
+	assert.Equal(t,
+		[]string{"-m", "comment", "--comment", `a "quoted" comment`, "-j", "ACCEPT"},
+		splitListedRule(`-A FIREBUILD-FILTER -m comment --comment "a \"quoted\" comment" -j ACCEPT`))
+}
+
+func TestDNATAddress(t *testing.T) {
+	assert.Equal(t, "192.168.127.2",
+		dnatAddress(splitListedRule("-A FBD-vmm -i eno1 -p tcp -m tcp --dport 8080 -j DNAT --to-destination 192.168.127.2:80")))
+	assert.Equal(t, "fd00::2",
+		dnatAddress(splitListedRule("-A FBD-vmm -p tcp -m tcp --dport 8080 -j DNAT --to-destination [fd00::2]:80")))
+	assert.Equal(t, "fd00::2", dnatAddress([]string{"--to-destination", "fd00::2"}))
+	assert.Equal(t, "", dnatAddress(splitListedRule("-A FBD-vmm -j RETURN")))
+}
+
+func TestDestinationAddress(t *testing.T) {
+	assert.Equal(t, "192.168.127.2",
+		destinationAddress(splitListedRule("-A FIREBUILD-FILTER -d 192.168.127.2/32 -p tcp -m tcp --dport 80 -j ACCEPT")))
+	assert.Equal(t, "fd00::2", destinationAddress([]string{"-d", "fd00::2/128"}))
+	assert.Equal(t, "", destinationAddress([]string{"-j", "ACCEPT"}))
+}
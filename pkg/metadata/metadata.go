@@ -1,12 +1,24 @@
 package metadata
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/combust-labs/firebuild-mmds/mmds"
 	"github.com/combust-labs/firebuild/configs"
+	bcCommands "github.com/combust-labs/firebuild/pkg/build/commands"
+	"github.com/combust-labs/firebuild/pkg/naming"
 	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm/drives"
+	"github.com/combust-labs/firebuild/pkg/vmm/passthrough"
 	"github.com/combust-labs/firebuild/pkg/vmm/pid"
 	"github.com/firecracker-microvm/firecracker-go-sdk"
 	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
@@ -24,11 +36,20 @@ const (
 	MetadataTypeRun    = Type("run")
 )
 
+// VMM run states.
+const (
+	// StateDead is the state of a VMM with the recorded PID not pointing at a VMM process.
+	StateDead    = "dead"
+	StatePaused  = "paused"
+	StateRunning = "running"
+)
+
 // MDBaseOS is the base OS metadata.
 type MDBaseOS struct {
 	CreatedAtUTC int64             `json:"CreatedAtUTC" mapstructure:"CreatedAtUTC"`
 	Image        MDImage           `json:"Image" mapstructure:"Image"`
 	Labels       map[string]string `json:"Labels" mapstructure:"Labels"`
+	RootfsSHA256 string            `json:"RootfsSHA256,omitempty" mapstructure:"RootfsSHA256,omitempty"`
 	Type         Type              `json:"Type" mapstructure:"Type"`
 }
 
@@ -37,6 +58,9 @@ type MDImage struct {
 	Org     string `json:"Org" mapstructure:"Org"`
 	Image   string `json:"Image" mapstructure:"Image"`
 	Version string `json:"Version" mapstructure:"Version"`
+	// Arch is the architecture the image is built for, for example amd64 or arm64.
+	// Empty for the images built before the architecture was recorded.
+	Arch string `json:"Arch,omitempty" mapstructure:"Arch,omitempty"`
 }
 
 // MDNetIPConfiguration is the IP configuration of a running VMM.
@@ -78,15 +102,66 @@ type MDRootfsConfig struct {
 	PostBuildCommands []string          `json:"PostBuildCommands" mapstructure:"PostBuildCommands"`
 }
 
+// MDRootfsAttachment is a named file stored with the rootfs, outside of the file system.
+type MDRootfsAttachment struct {
+	Name   string `json:"Name" mapstructure:"Name"`
+	SHA256 string `json:"SHA256" mapstructure:"SHA256"`
+	Size   int64  `json:"Size" mapstructure:"Size"`
+}
+
+// NewMDRootfsAttachments returns the attachments metadata of the named local files, sorted by the name.
+func NewMDRootfsAttachments(attachments map[string]string) ([]MDRootfsAttachment, error) {
+	result := []MDRootfsAttachment{}
+	for name, localPath := range attachments {
+		file, err := os.Open(localPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed opening attachment %q", name)
+		}
+		hash := sha256.New()
+		size, err := io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed reading attachment %q", name)
+		}
+		result = append(result, MDRootfsAttachment{Name: name, SHA256: hex.EncodeToString(hash.Sum(nil)), Size: size})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// MDRootfsBuildStats records how long the rootfs build took.
+// The phase durations are the durations of the rootfs command tracing spans.
+type MDRootfsBuildStats struct {
+	DurationMs int64            `json:"DurationMs" mapstructure:"DurationMs"`
+	PhasesMs   map[string]int64 `json:"PhasesMs" mapstructure:"PhasesMs"`
+}
+
+// NewMDRootfsBuildStats returns new empty build stats.
+func NewMDRootfsBuildStats() *MDRootfsBuildStats {
+	return &MDRootfsBuildStats{PhasesMs: map[string]int64{}}
+}
+
+// RecordPhase records the duration of a build phase.
+func (s *MDRootfsBuildStats) RecordPhase(phase string, duration time.Duration) {
+	s.PhasesMs[phase] = duration.Milliseconds()
+}
+
 // MDRootfs represents a metadata of the rootfs.
 type MDRootfs struct {
+	Attachments    []MDRootfsAttachment           `json:"Attachments,omitempty" mapstructure:"Attachments,omitempty"`
 	BuildConfig    MDRootfsConfig                 `json:"BuildConfig" mapstructure:"BuildConfig"`
+	BuildStats     *MDRootfsBuildStats            `json:"BuildStats,omitempty" mapstructure:"BuildStats,omitempty"`
 	CreatedAtUTC   int64                          `json:"CreatedAtUTC" mapstructure:"CreatedAtUTC"`
 	EntrypointInfo *mmds.MMDSRootfsEntrypointInfo `json:"EntrypointInfo" mapstructure:"EntrypointInfo"`
 	Image          MDImage                        `json:"Image" mapstructure:"Image"`
 	Labels         map[string]string              `json:"Labels" mapstructure:"Labels"`
 	Parent         interface{}                    `json:"Parent" mapstructure:"Parent"`
 	Ports          []string                       `json:"Ports" mapstructure:"Ports"`
+	RootfsSHA256   string                         `json:"RootfsSHA256,omitempty" mapstructure:"RootfsSHA256,omitempty"`
+	ServiceEnvPath string                         `json:"ServiceEnvPath,omitempty" mapstructure:"ServiceEnvPath,omitempty"`
+	StopSignal     string                         `json:"StopSignal" mapstructure:"StopSignal"`
 	Tag            string                         `json:"Tag" mapstructure:"Tag"`
 	Type           Type                           `json:"Type" mapstructure:"Type"`
 	Volumes        []string                       `json:"Volumes" mapstructure:"Volumes"`
@@ -101,6 +176,58 @@ func MDRootfsFromInterface(input interface{}) (*MDRootfs, error) {
 	return mdrootfs, nil
 }
 
+// RetagRootfsMetadata returns the rootfs or base OS metadata updated with the new image.
+// The tag of the rootfs metadata is updated too, the architecture is retained.
+// Metadata of an unknown type is returned as is.
+func RetagRootfsMetadata(input interface{}, org, image, version string) (interface{}, error) {
+	typed := struct {
+		Type Type `mapstructure:"Type"`
+	}{}
+	if err := mapstructure.Decode(input, &typed); err != nil {
+		return nil, errors.Wrap(err, "failed decoding metadata type")
+	}
+	newImage := MDImage{Org: org, Image: image, Version: version}
+	switch typed.Type {
+	case MetadataTypeBaseOS:
+		mdBaseOS := &MDBaseOS{}
+		if err := mapstructure.Decode(input, mdBaseOS); err != nil {
+			return nil, errors.Wrap(err, "failed decoding base OS metadata")
+		}
+		newImage.Arch = mdBaseOS.Image.Arch
+		mdBaseOS.Image = newImage
+		return mdBaseOS, nil
+	case MetadataTypeRootfs:
+		mdRootfs, err := MDRootfsFromInterface(input)
+		if err != nil {
+			return nil, err
+		}
+		newImage.Arch = mdRootfs.Image.Arch
+		mdRootfs.Image = newImage
+		mdRootfs.Tag = fmt.Sprintf("%s/%s:%s", org, image, version)
+		return mdRootfs, nil
+	default:
+		return input, nil
+	}
+}
+
+// GuestStopSignal returns the signal the guest service manager should use
+// to stop the main process, SIGTERM if the Dockerfile did not define the STOPSIGNAL.
+func (r *MDRootfs) GuestStopSignal() string {
+	if r.StopSignal == "" {
+		return bcCommands.DefaultStopSignal
+	}
+	return r.StopSignal
+}
+
+// GuestServiceEnvPath returns the path of the entrypoint service env file written by the guest init,
+// the default path if the rootfs was built without --service-env-path.
+func (r *MDRootfs) GuestServiceEnvPath() string {
+	if r.ServiceEnvPath == "" {
+		return naming.ServiceEnvFile
+	}
+	return r.ServiceEnvPath
+}
+
 // MDRunConfigs contains the configuration of the running VMM.
 type MDRunConfigs struct {
 	CNI       *configs.CNIConfig                `json:"CNI" mapstructure:"CNI"`
@@ -117,19 +244,56 @@ type MDRunCNI struct {
 	NetNS    string `json:"NetNS" mapstructure:"NetNS"`
 }
 
+// MDRunEgress represents the egress policy applied to a running VMM.
+// The guest addresses are the addresses the egress rules were applied for,
+// the rules are removed for the same addresses.
+type MDRunEgress struct {
+	Addresses []string `json:"Addresses" mapstructure:"Addresses"`
+	Allow     []string `json:"Allow" mapstructure:"Allow"`
+	Default   string   `json:"Default" mapstructure:"Default"`
+	Deny      []string `json:"Deny" mapstructure:"Deny"`
+}
+
+// MDRunVsock represents the vsock device of a running VMM.
+// The path is the unix socket path in the jail, the host path is the path of the same socket on the host.
+type MDRunVsock struct {
+	CID      uint32 `json:"CID" mapstructure:"CID"`
+	HostPath string `json:"HostPath" mapstructure:"HostPath"`
+	Path     string `json:"Path" mapstructure:"Path"`
+}
+
+// MDRunSnapshot represents the snapshot of a VMM.
+// The snapshot files are stored in the VMM run cache directory.
+type MDRunSnapshot struct {
+	CreatedAtUTC int64  `json:"CreatedAtUTC" mapstructure:"CreatedAtUTC"`
+	MemFilePath  string `json:"MemFilePath" mapstructure:"MemFilePath"`
+	SnapshotPath string `json:"SnapshotPath" mapstructure:"SnapshotPath"`
+}
+
 // MDRun contains the runtime information about a VMM.
 type MDRun struct {
-	Bootstrap         *mmds.MMDSBootstrap  `json:"Bootstrap,omitempty" mapstructure:"Bootstrap,omitempty"`
-	CNI               MDRunCNI             `json:"CNI" mapstructure:"CNI"`
-	Configs           MDRunConfigs         `json:"Configs" mapstructure:"Configs"`
-	Drives            []models.Drive       `json:"Drivers" mapstructure:"Drives"`
-	NetworkInterfaces []MDNetworkInterafce `json:"NetworkInterfaces" mapstructure:"NetworkInterfaces"`
-	PID               pid.RunningVMMPID    `json:"Pid" mapstructure:"Pid"`
-	Rootfs            *MDRootfs            `json:"Rootfs" mapstructure:"Rootfs"`
-	RunCache          string               `json:"RunCache" mapstructure:"RunCache"`
-	StartedAtUTC      int64                `json:"StartedAtUTC" mapstructure:"StartedAtUTC"`
-	VMMID             string               `json:"VMMID" mapstructure:"VMMID"`
-	Type              Type                 `json:"Type" mapstructure:"Type"`
+	// AttachedDrives are the additional drives of the VMM with their host paths,
+	// the Drives record the paths in the jail.
+	AttachedDrives     []*drives.Drive       `json:"AttachedDrives,omitempty" mapstructure:"AttachedDrives,omitempty"`
+	Bootstrap          *mmds.MMDSBootstrap   `json:"Bootstrap,omitempty" mapstructure:"Bootstrap,omitempty"`
+	CNI                MDRunCNI              `json:"CNI" mapstructure:"CNI"`
+	Configs            MDRunConfigs          `json:"Configs" mapstructure:"Configs"`
+	Drives             []models.Drive        `json:"Drivers" mapstructure:"Drives"`
+	Egress             *MDRunEgress          `json:"Egress,omitempty" mapstructure:"Egress,omitempty"`
+	Labels             map[string]string     `json:"Labels,omitempty" mapstructure:"Labels,omitempty"`
+	MetricsPath        string                `json:"MetricsPath,omitempty" mapstructure:"MetricsPath,omitempty"`
+	MMDSVersion        string                `json:"MMDSVersion,omitempty" mapstructure:"MMDSVersion,omitempty"`
+	NetworkInterfaces  []MDNetworkInterafce  `json:"NetworkInterfaces" mapstructure:"NetworkInterfaces"`
+	PassthroughDevices []*passthrough.Device `json:"PassthroughDevices,omitempty" mapstructure:"PassthroughDevices,omitempty"`
+	PID                pid.RunningVMMPID     `json:"Pid" mapstructure:"Pid"`
+	Rootfs             *MDRootfs             `json:"Rootfs" mapstructure:"Rootfs"`
+	RunCache           string                `json:"RunCache" mapstructure:"RunCache"`
+	Snapshot           *MDRunSnapshot        `json:"Snapshot,omitempty" mapstructure:"Snapshot,omitempty"`
+	StartedAtUTC       int64                 `json:"StartedAtUTC" mapstructure:"StartedAtUTC"`
+	State              string                `json:"State,omitempty" mapstructure:"State,omitempty"`
+	VMMID              string                `json:"VMMID" mapstructure:"VMMID"`
+	Vsock              *MDRunVsock           `json:"Vsock,omitempty" mapstructure:"Vsock,omitempty"`
+	Type               Type                  `json:"Type" mapstructure:"Type"`
 }
 
 // AsMMDS converts the run metadata to MMDS metadata.
@@ -139,26 +303,14 @@ func (r *MDRun) AsMMDS() (interface{}, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed fetching merged env")
 	}
-	keys, err := r.Configs.RunConfig.PublicKeys()
+	userKeys, err := r.Configs.RunConfig.UserPublicKeys(r.Configs.Machine.SSHUser)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed fetching public keys")
 	}
 
-	entrypoitInfo := &mmds.MMDSRootfsEntrypointInfo{
-		Cmd:        r.Rootfs.EntrypointInfo.Cmd,
-		Entrypoint: r.Rootfs.EntrypointInfo.Entrypoint,
-		Env:        r.Rootfs.EntrypointInfo.Env,
-		Shell:      r.Rootfs.EntrypointInfo.Shell,
-		User:       r.Rootfs.EntrypointInfo.User,
-		Workdir:    r.Rootfs.EntrypointInfo.Workdir,
-	}
-	if len(r.Configs.RunConfig.CapturedCmd()) > 0 {
-		entrypoitInfo.Cmd = r.Configs.RunConfig.CapturedCmd()
-	}
-
-	entrypointJSON, err := entrypoitInfo.ToJsonString()
+	entrypointJSON, err := r.mmdsEntrypointInfo().toJSONString()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed fetching public keys")
+		return nil, errors.Wrap(err, "failed serializing entrypoint info")
 	}
 
 	metadata := &mmds.MMDSLatest{
@@ -212,16 +364,12 @@ func (r *MDRun) AsMMDS() (interface{}, error) {
 				ImageTag: r.Rootfs.Tag,
 				Users: func() map[string]*mmds.MMDSUser {
 					result := map[string]*mmds.MMDSUser{}
-					if r.Configs.Machine.SSHUser != "" {
-						result[r.Configs.Machine.SSHUser] = &mmds.MMDSUser{
-							SSHKeys: func() string {
-								resp := []string{}
-								for _, key := range keys {
-									resp = append(resp, string(utils.MarshalSSHPublicKey(key)))
-								}
-								return strings.Join(resp, "\n")
-							}(),
+					for user, keys := range userKeys {
+						resp := []string{}
+						for _, key := range keys {
+							resp = append(resp, string(utils.MarshalSSHPublicKey(key)))
 						}
+						result[user] = &mmds.MMDSUser{SSHKeys: strings.Join(resp, "\n")}
 					}
 					return result
 				}(),
@@ -231,6 +379,107 @@ func (r *MDRun) AsMMDS() (interface{}, error) {
 	return metadata.Serialize()
 }
 
+// PublishAddresses returns the guest IP addresses the ports are published to, one address per family.
+// The IPv6 addresses are skipped when the VMM was started with --publish-ipv4-only.
+func (r *MDRun) PublishAddresses() []string {
+	return r.guestAddresses(r.Configs.RunConfig != nil && r.Configs.RunConfig.PublishIPv4Only)
+}
+
+// IsPaused returns true if the VMM was paused with the pause command.
+func (r *MDRun) IsPaused() bool {
+	return r.State == StatePaused
+}
+
+// RunState returns the run state of the VMM, the VMMs started before the state was recorded are running.
+func (r *MDRun) RunState() string {
+	if r.State == "" {
+		return StateRunning
+	}
+	return r.State
+}
+
+// GuestAddresses returns the guest IP addresses of the VMM, one address per family.
+func (r *MDRun) GuestAddresses() []string {
+	return r.guestAddresses(false)
+}
+
+func (r *MDRun) guestAddresses(ipv4Only bool) []string {
+	addresses := []string{}
+	seenFamilies := map[bool]bool{}
+	for _, nic := range r.NetworkInterfaces {
+		if nic.StaticConfiguration == nil || nic.StaticConfiguration.IPConfiguration == nil {
+			continue
+		}
+		ip := net.ParseIP(nic.StaticConfiguration.IPConfiguration.IP)
+		if ip == nil {
+			continue
+		}
+		isIPv6 := ip.To4() == nil
+		if seenFamilies[isIPv6] || (isIPv6 && ipv4Only) {
+			continue
+		}
+		seenFamilies[isIPv6] = true
+		addresses = append(addresses, nic.StaticConfiguration.IPConfiguration.IP)
+	}
+	return addresses
+}
+
+// entrypointInfo returns the entrypoint info of the rootfs with the run overrides applied.
+// The command given on the command line replaces the CMD of the rootfs. The --arg values
+// are appended to the ENTRYPOINT, before the CMD. Without an ENTRYPOINT, the CMD is
+// the executed program so the values are appended to the CMD.
+// The stored rootfs entrypoint info is not modified.
+func (r *MDRun) entrypointInfo() *mmds.MMDSRootfsEntrypointInfo {
+	entrypointInfo := &mmds.MMDSRootfsEntrypointInfo{
+		Cmd:        r.Rootfs.EntrypointInfo.Cmd,
+		Entrypoint: r.Rootfs.EntrypointInfo.Entrypoint,
+		Env:        r.Rootfs.EntrypointInfo.Env,
+		Shell:      r.Rootfs.EntrypointInfo.Shell,
+		User:       r.Rootfs.EntrypointInfo.User,
+		Workdir:    r.Rootfs.EntrypointInfo.Workdir,
+	}
+	if len(r.Configs.RunConfig.CapturedCmd()) > 0 {
+		entrypointInfo.Cmd = r.Configs.RunConfig.CapturedCmd()
+	}
+	// copy before appending, the slices are shared with the rootfs and the run configuration:
+	if len(r.Configs.RunConfig.EntrypointArgs) > 0 {
+		if len(entrypointInfo.Entrypoint) > 0 {
+			entrypointInfo.Entrypoint = append(append([]string{}, entrypointInfo.Entrypoint...), r.Configs.RunConfig.EntrypointArgs...)
+		} else {
+			entrypointInfo.Cmd = append(append([]string{}, entrypointInfo.Cmd...), r.Configs.RunConfig.EntrypointArgs...)
+		}
+	}
+	return entrypointInfo
+}
+
+func (r *MDRun) mmdsEntrypointInfo() *mmdsEntrypointInfo {
+	return &mmdsEntrypointInfo{
+		MMDSRootfsEntrypointInfo: r.entrypointInfo(),
+		EnvFile:                  r.Rootfs.GuestServiceEnvPath(),
+		EnvFileMode:              fmt.Sprintf("%04o", naming.ServiceEnvFileMode),
+		StopSignal:               r.Rootfs.GuestStopSignal(),
+	}
+}
+
+// mmdsEntrypointInfo extends the MMDS entrypoint info with the stop signal
+// so the guest service manager can stop the main process with the configured signal,
+// and with the env file the guest init writes the service environment to.
+// The guest init creates the parent directories of the env file.
+type mmdsEntrypointInfo struct {
+	*mmds.MMDSRootfsEntrypointInfo
+	EnvFile     string `json:"EnvFile" mapstructure:"EnvFile"`
+	EnvFileMode string `json:"EnvFileMode" mapstructure:"EnvFileMode"`
+	StopSignal  string `json:"StopSignal" mapstructure:"StopSignal"`
+}
+
+func (inst *mmdsEntrypointInfo) toJSONString() (string, error) {
+	bytes, err := json.Marshal(inst)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
 // FcNetworkInterfacesToMetadata converts firecracker network interfaces to the metadata network interfaces.
 func FcNetworkInterfacesToMetadata(nifs firecracker.NetworkInterfaces) []MDNetworkInterafce {
 	response := []MDNetworkInterafce{}
@@ -0,0 +1,183 @@
+package metadata
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild-mmds/mmds"
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/tracing"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/hashicorp/go-hclog"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntrypointInfoComposition(t *testing.T) {
+	newRun := func(entrypoint, cmd []string, args ...string) *MDRun {
+		runConfig := configs.NewRunCommandConfig()
+		runConfig.FlagSet()
+		runConfig.CaptureCmd(args)
+		return &MDRun{
+			Configs: MDRunConfigs{RunConfig: runConfig},
+			Rootfs: &MDRootfs{
+				EntrypointInfo: &mmds.MMDSRootfsEntrypointInfo{
+					Cmd:        cmd,
+					Entrypoint: entrypoint,
+				},
+			},
+		}
+	}
+
+	// the stored entrypoint and CMD:
+	info := newRun([]string{"/docker-entrypoint.sh"}, []string{"postgres"}).entrypointInfo()
+	assert.Equal(t, []string{"/docker-entrypoint.sh"}, info.Entrypoint)
+	assert.Equal(t, []string{"postgres"}, info.Cmd)
+
+	// the arguments are appended to the entrypoint, the stored CMD is kept:
+	run := newRun([]string{"/docker-entrypoint.sh"}, []string{"postgres"}, "--arg", "-c", "--arg", "max_connections=200")
+	info = run.entrypointInfo()
+	assert.Equal(t, []string{"/docker-entrypoint.sh", "-c", "max_connections=200"}, info.Entrypoint)
+	assert.Equal(t, []string{"postgres"}, info.Cmd)
+	assert.Equal(t, []string{"/docker-entrypoint.sh"}, run.Rootfs.EntrypointInfo.Entrypoint, "expected the stored entrypoint to be unchanged")
+
+	// the captured command replaces the CMD, the arguments still go to the entrypoint:
+	info = newRun([]string{"/docker-entrypoint.sh"}, []string{"postgres"}, "--arg", "-v", "--", "psql", "-l").entrypointInfo()
+	assert.Equal(t, []string{"/docker-entrypoint.sh", "-v"}, info.Entrypoint)
+	assert.Equal(t, []string{"psql", "-l"}, info.Cmd)
+
+	// without an entrypoint, the CMD is the executed program:
+	run = newRun(nil, []string{"/usr/bin/server"}, "--arg", "--verbose")
+	info = run.entrypointInfo()
+	assert.Empty(t, info.Entrypoint)
+	assert.Equal(t, []string{"/usr/bin/server", "--verbose"}, info.Cmd)
+	assert.Equal(t, []string{"/usr/bin/server"}, run.Rootfs.EntrypointInfo.Cmd, "expected the stored CMD to be unchanged")
+}
+
+func TestServiceEnvPath(t *testing.T) {
+	runConfig := configs.NewRunCommandConfig()
+	runConfig.FlagSet()
+	run := &MDRun{
+		Configs: MDRunConfigs{RunConfig: runConfig},
+		Rootfs:  &MDRootfs{EntrypointInfo: &mmds.MMDSRootfsEntrypointInfo{}},
+	}
+
+	// the rootfs built before the path was recorded uses the default path:
+	info := run.mmdsEntrypointInfo()
+	assert.Equal(t, naming.ServiceEnvFile, info.EnvFile)
+	assert.Equal(t, "0600", info.EnvFileMode)
+
+	run.Rootfs.ServiceEnvPath = "/etc/conf.d/app"
+	jsonString, err := run.mmdsEntrypointInfo().toJSONString()
+	assert.Nil(t, err)
+	assert.Contains(t, jsonString, `"EnvFile":"/etc/conf.d/app"`)
+	assert.Contains(t, jsonString, `"EnvFileMode":"0600"`)
+}
+
+func TestPublishAddresses(t *testing.T) {
+	nic := func(ip string) MDNetworkInterafce {
+		return MDNetworkInterafce{
+			StaticConfiguration: &MDNetStaticConfiguration{
+				IPConfiguration: &MDNetIPConfiguration{IP: ip},
+			},
+		}
+	}
+	runConfig := configs.NewRunCommandConfig()
+	run := &MDRun{
+		Configs: MDRunConfigs{RunConfig: runConfig},
+		NetworkInterfaces: []MDNetworkInterafce{
+			nic("192.168.127.10"),
+			{StaticConfiguration: &MDNetStaticConfiguration{}},
+			nic("fd00::10"),
+			nic("192.168.127.11"),
+			nic("not-an-ip"),
+		},
+	}
+	assert.Equal(t, []string{"192.168.127.10", "fd00::10"}, run.PublishAddresses())
+
+	runConfig.PublishIPv4Only = true
+	assert.Equal(t, []string{"192.168.127.10"}, run.PublishAddresses())
+
+	assert.Equal(t, []string{}, (&MDRun{}).PublishAddresses())
+}
+
+func TestCanonicalRootfsMetadataJSON(t *testing.T) {
+	newRootfs := func() *MDRootfs {
+		return &MDRootfs{
+			BuildConfig: MDRootfsConfig{
+				BuildArgs:         map[string]string{"VERSION": "13", "ALPINE": "3.13", "USER": "postgres"},
+				PreBuildCommands:  []string{"apk update"},
+				PostBuildCommands: []string{"rm -rf /var/cache/apk"},
+			},
+			CreatedAtUTC: 1615513571,
+			EntrypointInfo: &mmds.MMDSRootfsEntrypointInfo{
+				Cmd: []string{"postgres"},
+				Env: map[string]string{"PGDATA": "/var/lib/postgresql/data", "LANG": "en_US.utf8"},
+			},
+			Image:  MDImage{Org: "tests", Image: "postgres", Version: "13"},
+			Labels: map[string]string{"maintainer": "tests", "env": "prod"},
+			Parent: &MDBaseOS{Image: MDImage{Org: "combust-labs", Image: "alpine", Version: "3.13"}, Type: "baseos"},
+			Type:   "rootfs",
+		}
+	}
+
+	expected, err := utils.CanonicalJSONIndent(newRootfs(), "", "  ")
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		output, err := utils.CanonicalJSONIndent(newRootfs(), "", "  ")
+		assert.Nil(t, err)
+		assert.Equal(t, string(expected), string(output))
+	}
+
+	// the metadata decoded from a stored file is serialized identically:
+	stored, err := json.Marshal(newRootfs())
+	assert.Nil(t, err)
+	decoded := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(stored, &decoded))
+	output, err := utils.CanonicalJSONIndent(decoded, "", "  ")
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(output))
+
+	// the keys are sorted, the struct fields are not:
+	assert.Less(t, strings.Index(string(expected), "PostBuildCommands"), strings.Index(string(expected), "PreBuildCommands"))
+}
+
+func TestRootfsBuildStats(t *testing.T) {
+	tracer, cleanupFunc, err := tracing.GetTracer(hclog.NewNullLogger(), configs.NewTracingConfig("test"))
+	assert.Nil(t, err)
+	defer cleanupFunc()
+
+	buildStats := NewMDRootfsBuildStats()
+	spanBuild := tracer.StartSpan("build-rootfs")
+	spanPhase := tracer.StartSpan("rootfs-copy", opentracing.ChildOf(spanBuild.Context()))
+	time.Sleep(time.Millisecond * 5)
+	spanPhase.Finish()
+	buildStats.RecordPhase("rootfs-copy", tracing.SpanDuration(spanPhase))
+	buildStats.DurationMs = time.Since(tracing.SpanStartTime(spanBuild)).Milliseconds()
+	spanBuild.Finish()
+
+	assert.GreaterOrEqual(t, buildStats.PhasesMs["rootfs-copy"], int64(5))
+	assert.GreaterOrEqual(t, buildStats.DurationMs, buildStats.PhasesMs["rootfs-copy"])
+
+	serialized, err := json.Marshal(&MDRootfs{BuildStats: buildStats})
+	assert.Nil(t, err)
+	decoded := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(serialized, &decoded))
+	mdRootfs, err := MDRootfsFromInterface(decoded)
+	assert.Nil(t, err)
+	assert.Equal(t, buildStats, mdRootfs.BuildStats)
+}
+
+func TestRunState(t *testing.T) {
+	md := &MDRun{}
+	assert.False(t, md.IsPaused())
+	assert.Equal(t, StateRunning, md.RunState())
+	md.State = StatePaused
+	assert.True(t, md.IsPaused())
+	assert.Equal(t, StatePaused, md.RunState())
+	md.State = StateRunning
+	assert.False(t, md.IsPaused())
+}
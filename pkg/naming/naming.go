@@ -5,16 +5,32 @@ import (
 )
 
 const (
+	// AttachmentsDirectoryName is the name of the directory in which the files attached to the rootfs are stored.
+	AttachmentsDirectoryName = "attachments"
 	// MetadataFileName is the name of the file in which the accompanying rootfs metadata is stored.
 	MetadataFileName = "metadata.json"
+	// MetricsFileName is the base name of the Firecracker metrics file in the jail root.
+	MetricsFileName = "metrics"
 	// RootfsEnvVarsFile is the location of the env variables
 	// extracted from the Docker build.
 	RootfsEnvVarsFile = "/etc/profile.d/rootfs-env.sh"
+	// RootfsBlobPointerFileName is the name of the file pointing a deduplicated rootfs at its blob.
+	RootfsBlobPointerFileName = "rootfs.blob"
 	// RootfsFileName is the base name of the root file system, as stored on disk.
 	RootfsFileName = "rootfs"
 	// RunEnvVarsFile is the location of the env variables
 	// extracted from the Docker build.
 	RunEnvVarsFile = "/etc/profile.d/run-env.sh"
+	// ServiceEnvFile is the default location of the env file of the entrypoint service
+	// written by the guest init, overridden with the rootfs --service-env-path.
+	ServiceEnvFile = "/etc/firebuild/cmd.env"
+	// ServiceEnvFileMode is the mode of the entrypoint service env file,
+	// the env file may contain secrets and is read by the init system running as root.
+	ServiceEnvFileMode = 0600
+	// SnapshotFileName is the base name of the VMM state snapshot file, as stored on disk.
+	SnapshotFileName = "snapshot"
+	// SnapshotMemFileName is the base name of the VMM memory snapshot file, as stored on disk.
+	SnapshotMemFileName = "snapshot.mem"
 
 	// ServiceInstallerFile is the installer file deployed during the rootfs build,
 	// when --service-file-installer is defined.
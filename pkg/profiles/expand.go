@@ -0,0 +1,38 @@
+package profiles
+
+import (
+	"reflect"
+
+	"github.com/combust-labs/firebuild/pkg/profiles/model"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// expandProfile expands the ${NAME} environment variable references in the string values
+// of the profile, including the storage provider string properties, see utils.ExpandEnv.
+func expandProfile(profile *model.Profile, lookup func(string) (string, bool)) error {
+	profileValue := reflect.ValueOf(profile).Elem()
+	profileType := profileValue.Type()
+	for idx := 0; idx < profileValue.NumField(); idx++ {
+		field := profileValue.Field(idx)
+		name := profileType.Field(idx).Tag.Get("mapstructure")
+		switch {
+		case field.Kind() == reflect.String:
+			expanded, err := utils.ExpandEnvWith(field.String(), lookup)
+			if err != nil {
+				return errors.Wrapf(err, "profile value '%s' could not be expanded", name)
+			}
+			field.SetString(expanded)
+		case field.Kind() == reflect.Map && field.Type().Elem().Kind() == reflect.String:
+			iter := field.MapRange()
+			for iter.Next() {
+				expanded, err := utils.ExpandEnvWith(iter.Value().String(), lookup)
+				if err != nil {
+					return errors.Wrapf(err, "profile value '%s' property '%s' could not be expanded", name, iter.Key().String())
+				}
+				field.SetMapIndex(iter.Key(), reflect.ValueOf(expanded))
+			}
+		}
+	}
+	return nil
+}
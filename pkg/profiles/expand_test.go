@@ -0,0 +1,74 @@
+package profiles
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileEnvironmentExpansion(t *testing.T) {
+	location, err := ioutil.TempDir("", "profiles")
+	assert.Nil(t, err)
+	defer os.RemoveAll(location)
+
+	os.Setenv("FIREBUILD_TEST_HOME", "/home/firebuild")
+	defer os.Unsetenv("FIREBUILD_TEST_HOME")
+	os.Setenv("FIREBUILD_TEST_CHROOT", "/srv/jailer")
+	defer os.Unsetenv("FIREBUILD_TEST_CHROOT")
+
+	writeTestProfile(t, location, "base", `{
+		"chroot-base": "${FIREBUILD_TEST_CHROOT}",
+		"run-cache": "${FIREBUILD_TEST_HOME}/.firebuild/run-cache",
+		"storage-provider": "directory",
+		"storage-profile-config-strings": {
+			"rootfs-storage-root": "${FIREBUILD_TEST_HOME}/rootfs",
+			"password": "pa$$word"
+		}
+	}`)
+	writeTestProfile(t, location, "child", `{
+		"inherits": "base",
+		"binary-jailer": "${FIREBUILD_TEST_HOME}/bin/jailer"
+	}`)
+
+	profile, err := ReadProfile("child", location)
+	assert.Nil(t, err)
+	merged := profile.Profile()
+	assert.Equal(t, "/srv/jailer", merged.ChrootBase)
+	assert.Equal(t, "/home/firebuild/.firebuild/run-cache", merged.RunCache)
+	assert.Equal(t, "/home/firebuild/bin/jailer", merged.BinaryJailer)
+	assert.Equal(t, map[string]interface{}{
+		"rootfs-storage-root": "/home/firebuild/rootfs",
+		"password":            "pa$word",
+	}, profile.GetMergedStorageConfig())
+}
+
+func TestProfileEnvironmentExpansionMissingVariable(t *testing.T) {
+	location, err := ioutil.TempDir("", "profiles")
+	assert.Nil(t, err)
+	defer os.RemoveAll(location)
+
+	os.Unsetenv("FIREBUILD_TEST_UNSET")
+	writeTestProfile(t, location, "missing", `{"run-cache": "${FIREBUILD_TEST_UNSET}/run-cache"}`)
+	writeTestProfile(t, location, "missing-property", `{
+		"storage-provider": "directory",
+		"storage-profile-config-strings": {"rootfs-storage-root": "${FIREBUILD_TEST_UNSET}/rootfs"}
+	}`)
+
+	_, err = ReadProfile("missing", location)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "profile value 'run-cache' could not be expanded")
+	assert.Contains(t, err.Error(), "environment variable 'FIREBUILD_TEST_UNSET'")
+
+	_, err = ReadProfile("missing-property", location)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "property 'rootfs-storage-root' could not be expanded")
+
+	// an empty variable is set:
+	os.Setenv("FIREBUILD_TEST_UNSET", "")
+	defer os.Unsetenv("FIREBUILD_TEST_UNSET")
+	profile, err := ReadProfile("missing", location)
+	assert.Nil(t, err)
+	assert.Equal(t, "/run-cache", profile.Profile().RunCache)
+}
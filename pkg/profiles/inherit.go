@@ -0,0 +1,109 @@
+package profiles
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/combust-labs/firebuild/pkg/profiles/model"
+	"github.com/pkg/errors"
+)
+
+// InheritanceChain returns the names of the profile and of the profiles it inherits from,
+// the profile first, the profile not inheriting from any other profile last.
+// Returns an error when a profile of the chain can't be read or when the inheritance is cyclic.
+//
+// When the profile is read, the chain is merged from the last profile to the first one.
+// Every value set in a profile file overrides the value inherited from the parent,
+// including the empty and false values; a value not set inherits the parent value. The storage provider properties
+// are merged property by property, unless the profile selects a different storage provider
+// than the parent, then the parent properties are not inherited.
+func InheritanceChain(name, location string) ([]string, error) {
+	chain, err := readInheritanceChain(name, location)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, item := range chain {
+		names = append(names, item.name)
+	}
+	return names, nil
+}
+
+type chainedProfile struct {
+	name    string
+	profile *model.Profile
+	// set contains the JSON keys present in the profile file:
+	set map[string]bool
+}
+
+func readInheritanceChain(name, location string) ([]*chainedProfile, error) {
+	chain := []*chainedProfile{}
+	visited := map[string]bool{}
+	current := strings.ToLower(name)
+	for {
+		if visited[current] {
+			names := []string{}
+			for _, item := range chain {
+				names = append(names, item.name)
+			}
+			return nil, errors.Errorf("profile inheritance cycle: %s -> %s", strings.Join(names, " -> "), current)
+		}
+		visited[current] = true
+		profile, set, err := readProfileFile(current, location)
+		if err != nil {
+			if len(chain) > 0 {
+				return nil, errors.Wrapf(err, "profile '%s' inherited by '%s'", current, chain[len(chain)-1].name)
+			}
+			return nil, err
+		}
+		chain = append(chain, &chainedProfile{name: current, profile: profile, set: set})
+		if profile.Inherits == "" {
+			return chain, nil
+		}
+		current = strings.ToLower(profile.Inherits)
+	}
+}
+
+func mergeInheritanceChain(chain []*chainedProfile) *model.Profile {
+	merged := &model.Profile{}
+	for idx := len(chain) - 1; idx >= 0; idx-- {
+		mergeProfile(merged, chain[idx].profile, chain[idx].set)
+	}
+	// the merged profile inherits from the direct parent:
+	merged.Inherits = chain[0].profile.Inherits
+	return merged
+}
+
+// mergeProfile overrides the values of the parent with the values set in the child,
+// the set contains the JSON keys of the child values, the non-empty values are always set.
+func mergeProfile(parent, child *model.Profile, set map[string]bool) {
+	if child.StorageProvider != "" && parent.StorageProvider != "" && child.StorageProvider != parent.StorageProvider {
+		parent.StorageProviderConfigStrings = nil
+		parent.StorageProviderConfigInt64s = nil
+	}
+	parentValue := reflect.ValueOf(parent).Elem()
+	childValue := reflect.ValueOf(child).Elem()
+	for idx := 0; idx < childValue.NumField(); idx++ {
+		childField := childValue.Field(idx)
+		parentField := parentValue.Field(idx)
+		if childField.IsZero() && !set[jsonKey(childValue.Type().Field(idx))] {
+			continue
+		}
+		if childField.Kind() == reflect.Map {
+			if parentField.IsNil() {
+				parentField.Set(reflect.MakeMap(childField.Type()))
+			}
+			iter := childField.MapRange()
+			for iter.Next() {
+				parentField.SetMapIndex(iter.Key(), iter.Value())
+			}
+			continue
+		}
+		parentField.Set(childField)
+	}
+}
+
+// jsonKey returns the JSON key of the struct field.
+func jsonKey(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
@@ -0,0 +1,169 @@
+package profiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestProfile(t *testing.T, location, name, content string) {
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(location, name), []byte(content), 0644))
+}
+
+func TestProfileInheritanceTwoLevels(t *testing.T) {
+	location, err := ioutil.TempDir("", "profiles")
+	assert.Nil(t, err)
+	defer os.RemoveAll(location)
+
+	writeTestProfile(t, location, "base", `{
+		"chroot-base": "/srv/jailer",
+		"run-cache": "/var/lib/firebuild",
+		"storage-provider": "directory",
+		"storage-profile-config-strings": {"rootfs-storage-root": "/firecracker/rootfs", "kernel-storage-root": "/firecracker/vmlinux"},
+		"tracing-enable": true,
+		"tracing-collector-host-port": "127.0.0.1:6831",
+		"mem": 256
+	}`)
+	writeTestProfile(t, location, "large", `{
+		"inherits": "base",
+		"storage-profile-config-strings": {"rootfs-storage-root": "/large/rootfs"},
+		"mem": 2048
+	}`)
+
+	profile, err := ReadProfile("large", location)
+	assert.Nil(t, err)
+	merged := profile.Profile()
+	// overridden by the child:
+	assert.Equal(t, int64(2048), merged.MachineMem)
+	// inherited from the parent:
+	assert.Equal(t, "/srv/jailer", merged.ChrootBase)
+	assert.Equal(t, "/var/lib/firebuild", merged.RunCache)
+	assert.Equal(t, "directory", merged.StorageProvider)
+	assert.True(t, merged.TracingEnable)
+	assert.Equal(t, "127.0.0.1:6831", merged.TracingCollectorHostPort)
+	assert.Equal(t, "base", merged.Inherits)
+	// storage properties merged property by property:
+	assert.Equal(t, map[string]interface{}{
+		"rootfs-storage-root": "/large/rootfs",
+		"kernel-storage-root": "/firecracker/vmlinux",
+	}, profile.GetMergedStorageConfig())
+
+	// the parent is not changed by the child:
+	parent, err := ReadProfile("base", location)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(256), parent.Profile().MachineMem)
+	assert.Equal(t, "/firecracker/rootfs", parent.Profile().StorageProviderConfigStrings["rootfs-storage-root"])
+}
+
+func TestProfileInheritanceThreeLevels(t *testing.T) {
+	location, err := ioutil.TempDir("", "profiles")
+	assert.Nil(t, err)
+	defer os.RemoveAll(location)
+
+	writeTestProfile(t, location, "base", `{
+		"run-cache": "/var/lib/firebuild",
+		"storage-provider": "directory",
+		"storage-profile-config-strings": {"rootfs-storage-root": "/firecracker/rootfs"},
+		"tracing-collector-host-port": "127.0.0.1:6831",
+		"cpu": 1
+	}`)
+	writeTestProfile(t, location, "team", `{
+		"inherits": "base",
+		"chroot-base": "/srv/team",
+		"cpu": 2
+	}`)
+	writeTestProfile(t, location, "ci", `{
+		"inherits": "Team",
+		"storage-provider": "oci",
+		"storage-profile-config-strings": {"repository": "registry.example.com/rootfs"},
+		"cpu": 4
+	}`)
+
+	chain, err := InheritanceChain("ci", location)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ci", "team", "base"}, chain)
+
+	profile, err := ReadProfile("ci", location)
+	assert.Nil(t, err)
+	merged := profile.Profile()
+	assert.Equal(t, int64(4), merged.MachineCPU)
+	assert.Equal(t, "/srv/team", merged.ChrootBase)
+	assert.Equal(t, "/var/lib/firebuild", merged.RunCache)
+	assert.Equal(t, "127.0.0.1:6831", merged.TracingCollectorHostPort)
+	// a different storage provider does not inherit the storage properties:
+	assert.Equal(t, "oci", merged.StorageProvider)
+	assert.Equal(t, map[string]interface{}{
+		"repository": "registry.example.com/rootfs",
+	}, profile.GetMergedStorageConfig())
+}
+
+func TestProfileInheritanceOverrideWithFalse(t *testing.T) {
+	location, err := ioutil.TempDir("", "profiles")
+	assert.Nil(t, err)
+	defer os.RemoveAll(location)
+
+	writeTestProfile(t, location, "base", `{
+		"tracing-enable": true,
+		"tracing-log-enable": true,
+		"kernel-args": "console=ttyS0",
+		"mem": 256
+	}`)
+	writeTestProfile(t, location, "quiet", `{
+		"inherits": "base",
+		"tracing-enable": false,
+		"kernel-args": ""
+	}`)
+
+	profile, err := ReadProfile("quiet", location)
+	assert.Nil(t, err)
+	merged := profile.Profile()
+	// set explicitly in the child:
+	assert.False(t, merged.TracingEnable)
+	assert.Equal(t, "", merged.MachineKernelArgs)
+	// not set in the child:
+	assert.True(t, merged.TracingLogEnable)
+	assert.Equal(t, int64(256), merged.MachineMem)
+
+	// the profile created with the flag set to false stores the false value:
+	createConfig := configs.NewProfileCreateConfig()
+	assert.Nil(t, createConfig.FlagSet().Parse([]string{"--inherits=base", "--tracing-log-enable=false"}))
+	assert.Nil(t, WriteProfileFile("created", location, createConfig))
+	profile, err = ReadProfile("created", location)
+	assert.Nil(t, err)
+	assert.True(t, profile.Profile().TracingEnable)
+	assert.False(t, profile.Profile().TracingLogEnable)
+}
+
+func TestProfileInheritanceErrors(t *testing.T) {
+	location, err := ioutil.TempDir("", "profiles")
+	assert.Nil(t, err)
+	defer os.RemoveAll(location)
+
+	writeTestProfile(t, location, "a", `{"inherits": "b"}`)
+	writeTestProfile(t, location, "b", `{"inherits": "c"}`)
+	writeTestProfile(t, location, "c", `{"inherits": "a"}`)
+	writeTestProfile(t, location, "self", `{"inherits": "self"}`)
+	writeTestProfile(t, location, "orphan", `{"inherits": "missing"}`)
+	writeTestProfile(t, location, "valid", `{"run-cache": "/var/lib/firebuild"}`)
+
+	_, err = ReadProfile("a", location)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "profile inheritance cycle: a -> b -> c -> a")
+
+	_, err = ReadProfile("self", location)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "profile inheritance cycle: self -> self")
+
+	_, err = ReadProfile("orphan", location)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "profile 'missing' inherited by 'orphan'")
+
+	// the profiles which can't be resolved are not listed:
+	names, err := ListProfiles(location)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"valid"}, names)
+}
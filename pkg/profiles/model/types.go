@@ -2,11 +2,19 @@ package model
 
 // Profile represents a serializable profile information.
 type Profile struct {
+	// Inherits is the name of the profile this profile extends.
+	Inherits string `json:"inherits,omitempty" mapstructure:"inherits"`
+
 	BinaryFirecracker string `json:"binary-firecracker,omitempty" mapstructure:"binary-firecracker"`
 	BinaryJailer      string `json:"binary-jailer,omitempty" mapstructure:"binary-jailer"`
 	ChrootBase        string `json:"chroot-base,omitempty" mapstructure:"chroot-base"`
 	RunCache          string `json:"run-cache,omitempty" mapstructure:"run-cache"`
 
+	MachineCPU         int64  `json:"cpu,omitempty" mapstructure:"cpu"`
+	MachineCPUTemplate string `json:"cpu-template,omitempty" mapstructure:"cpu-template"`
+	MachineKernelArgs  string `json:"kernel-args,omitempty" mapstructure:"kernel-args"`
+	MachineMem         int64  `json:"mem,omitempty" mapstructure:"mem"`
+
 	StorageProvider              string            `json:"storage-provider,omitempty" mapstructure:"storage-provider-type"`
 	StorageProviderConfigStrings map[string]string `json:"storage-profile-config-strings,omitempty" mapstructure:"storage-profile-config-strings"`
 	StorageProviderConfigInt64s  map[string]int64  `json:"storage-profile-config-int64,omitempty" mapstructure:"storage-profile-config-int64"`
@@ -33,24 +33,48 @@ func ListProfiles(location string) ([]string, error) {
 }
 
 // ReadProfile reads the profile information for a profile name and profile directory.
-// Name is always lowercase.
+// Name is always lowercase. The profiles inherited by the profile are read from the same
+// directory and merged, see InheritanceChain for the merge rules.
+// The ${NAME} references in the string values are expanded with the process environment,
+// a reference to a variable which is not set fails the read, $$ is a literal $.
 func ReadProfile(name, location string) (ResolvedProfile, error) {
+	chain, err := readInheritanceChain(name, location)
+	if err != nil {
+		return nil, err
+	}
+	return &defaultResolvedProfile{underlying: mergeInheritanceChain(chain)}, nil
+}
+
+// readProfileFile reads the profile file and returns the profile with the set of the keys
+// present in the file, a key present with the zero value overrides the inherited value.
+func readProfileFile(name, location string) (*model.Profile, map[string]bool, error) {
 	profilePath := filepath.Join(location, strings.ToLower(name))
 	if _, fileErr := utils.CheckIfExistsAndIsRegular(profilePath); fileErr != nil {
 		if os.IsNotExist(fileErr) {
-			return nil, errors.Wrap(fileErr, "profile does not exist")
+			return nil, nil, errors.Wrap(fileErr, "profile does not exist")
 		}
-		return nil, errors.Wrap(fileErr, "failed checking of profile path points to an existing file")
+		return nil, nil, errors.Wrap(fileErr, "failed checking of profile path points to an existing file")
 	}
 	profileBytes, readErr := ioutil.ReadFile(profilePath)
 	if readErr != nil {
-		return nil, errors.Wrap(readErr, "failed reading profile")
+		return nil, nil, errors.Wrap(readErr, "failed reading profile")
 	}
 	profile := &model.Profile{}
 	if jsonErr := json.Unmarshal(profileBytes, profile); jsonErr != nil {
-		return nil, errors.Wrap(jsonErr, "failed unmarshaling profile")
+		return nil, nil, errors.Wrap(jsonErr, "failed unmarshaling profile")
+	}
+	keys := map[string]json.RawMessage{}
+	if jsonErr := json.Unmarshal(profileBytes, &keys); jsonErr != nil {
+		return nil, nil, errors.Wrap(jsonErr, "failed unmarshaling profile")
+	}
+	set := map[string]bool{}
+	for key := range keys {
+		set[key] = true
+	}
+	if expandErr := expandProfile(profile, os.LookupEnv); expandErr != nil {
+		return nil, nil, expandErr
 	}
-	return &defaultResolvedProfile{underlying: profile}, nil
+	return profile, set, nil
 }
 
 // WriteProfileFile writes the profile to a file named wuth `name` in the `location` directory.
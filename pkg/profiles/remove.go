@@ -0,0 +1,66 @@
+package profiles
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/combust-labs/firebuild/pkg/profiles/model"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// InheritedBy returns the sorted names of the profiles directly inheriting from the named profile.
+// The profile files are not expanded, a profile which can't be read is skipped.
+func InheritedBy(name, location string) ([]string, error) {
+	result := []string{}
+	files, err := os.ReadDir(location)
+	if err != nil {
+		return result, errors.Wrap(err, "failed reading profiles directory")
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		profileBytes, readErr := ioutil.ReadFile(filepath.Join(location, f.Name()))
+		if readErr != nil {
+			continue
+		}
+		profile := &model.Profile{}
+		if jsonErr := json.Unmarshal(profileBytes, profile); jsonErr != nil {
+			continue
+		}
+		if strings.ToLower(profile.Inherits) == strings.ToLower(name) {
+			result = append(result, f.Name())
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// RemoveProfile removes the profile file named `name` from the `location` directory.
+// Name is always lowercase. A profile inherited by other profiles is not removed,
+// the inheriting profiles could not be read anymore.
+func RemoveProfile(name, location string) error {
+	profilePath := filepath.Join(location, strings.ToLower(name))
+	if _, fileErr := utils.CheckIfExistsAndIsRegular(profilePath); fileErr != nil {
+		if os.IsNotExist(fileErr) {
+			return errors.Wrap(fileErr, "profile does not exist")
+		}
+		return errors.Wrap(fileErr, "failed checking of profile path points to an existing file")
+	}
+	inheriting, err := InheritedBy(name, location)
+	if err != nil {
+		return err
+	}
+	if len(inheriting) > 0 {
+		return errors.Errorf("profile '%s' is inherited by: %s", strings.ToLower(name), strings.Join(inheriting, ", "))
+	}
+	if err := os.Remove(profilePath); err != nil {
+		return errors.Wrap(err, "failed removing profile")
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package profiles
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveProfile(t *testing.T) {
+	location, err := ioutil.TempDir("", "profiles")
+	assert.Nil(t, err)
+	defer os.RemoveAll(location)
+
+	writeTestProfile(t, location, "base", `{"run-cache": "/var/lib/firebuild"}`)
+	writeTestProfile(t, location, "large", `{"inherits": "base", "mem": 2048}`)
+	writeTestProfile(t, location, "small", `{"inherits": "BASE", "mem": 128}`)
+
+	inheriting, err := InheritedBy("base", location)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"large", "small"}, inheriting)
+
+	// a profile inherited by other profiles is not removed:
+	err = RemoveProfile("base", location)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "profile 'base' is inherited by: large, small")
+
+	assert.Nil(t, RemoveProfile("large", location))
+	assert.Nil(t, RemoveProfile("Small", location))
+	assert.Nil(t, RemoveProfile("base", location))
+
+	err = RemoveProfile("base", location)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "profile does not exist")
+
+	names, err := ListProfiles(location)
+	assert.Nil(t, err)
+	assert.Empty(t, names)
+}
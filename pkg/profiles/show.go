@@ -0,0 +1,38 @@
+package profiles
+
+import (
+	"strings"
+
+	"github.com/combust-labs/firebuild/pkg/profiles/model"
+)
+
+// EffectiveProfile is the configuration a command applies for a profile.
+type EffectiveProfile struct {
+	// Name is the name of the profile.
+	Name string `json:"name"`
+	// InheritanceChain lists the profile and the profiles it inherits from, the profile first.
+	InheritanceChain []string `json:"inheritance-chain"`
+	// Profile is the profile merged with the inherited profiles and with the environment variables expanded.
+	Profile *model.Profile `json:"profile"`
+	// StorageConfig is the storage provider configuration passed to the storage provider.
+	StorageConfig map[string]interface{} `json:"storage-config"`
+}
+
+// ReadEffectiveProfile reads the profile like ReadProfile and returns the effective configuration
+// together with the inheritance chain it was merged from.
+func ReadEffectiveProfile(name, location string) (*EffectiveProfile, error) {
+	chain, err := InheritanceChain(name, location)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := ReadProfile(name, location)
+	if err != nil {
+		return nil, err
+	}
+	return &EffectiveProfile{
+		Name:             strings.ToLower(name),
+		InheritanceChain: chain,
+		Profile:          profile.Profile(),
+		StorageConfig:    profile.GetMergedStorageConfig(),
+	}, nil
+}
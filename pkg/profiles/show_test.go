@@ -0,0 +1,42 @@
+package profiles
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadEffectiveProfile(t *testing.T) {
+	location, err := ioutil.TempDir("", "profiles")
+	assert.Nil(t, err)
+	defer os.RemoveAll(location)
+
+	os.Setenv("FIREBUILD_TEST_STORAGE_ROOT", "/mnt/storage")
+	defer os.Unsetenv("FIREBUILD_TEST_STORAGE_ROOT")
+
+	writeTestProfile(t, location, "base", `{
+		"storage-provider": "directory",
+		"storage-profile-config-strings": {"rootfs-storage-root": "${FIREBUILD_TEST_STORAGE_ROOT}/rootfs", "kernel-storage-root": "/firecracker/vmlinux"}
+	}`)
+	writeTestProfile(t, location, "large", `{
+		"inherits": "base",
+		"storage-profile-config-strings": {"kernel-storage-root": "${FIREBUILD_TEST_STORAGE_ROOT}/vmlinux"},
+		"mem": 2048
+	}`)
+
+	effective, err := ReadEffectiveProfile("Large", location)
+	assert.Nil(t, err)
+	assert.Equal(t, "large", effective.Name)
+	assert.Equal(t, []string{"large", "base"}, effective.InheritanceChain)
+	assert.Equal(t, int64(2048), effective.Profile.MachineMem)
+	assert.Equal(t, "directory", effective.Profile.StorageProvider)
+	assert.Equal(t, map[string]interface{}{
+		"rootfs-storage-root": "/mnt/storage/rootfs",
+		"kernel-storage-root": "/mnt/storage/vmlinux",
+	}, effective.StorageConfig)
+
+	_, err = ReadEffectiveProfile("missing", location)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,103 @@
+package remote
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsLock serializes the known hosts file updates of the connections in this process.
+var knownHostsLock = &sync.Mutex{}
+
+// ErrorHostKeyMismatch is returned when the presented host key does not match the known hosts entry.
+type ErrorHostKeyMismatch struct {
+	Host           string
+	KnownHostsFile string
+}
+
+func (e *ErrorHostKeyMismatch) Error() string {
+	return fmt.Sprintf("host key of '%s' does not match the key in '%s', the host may be impersonated; if the host keys changed legitimately, remove the stale entry", e.Host, e.KnownHostsFile)
+}
+
+// ErrorHostKeyUnknown is returned when strict host key checking is enabled and the host is not in the known hosts file.
+type ErrorHostKeyUnknown struct {
+	Host           string
+	KnownHostsFile string
+}
+
+func (e *ErrorHostKeyUnknown) Error() string {
+	return fmt.Sprintf("host '%s' not found in '%s' and strict host key checking is enabled", e.Host, e.KnownHostsFile)
+}
+
+// hostKeyCallback returns the host key callback for the connect configuration.
+func hostKeyCallback(config *ConnectConfig) (ssh.HostKeyCallback, error) {
+	if config.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	if config.KnownHostsFile == "" {
+		return nil, fmt.Errorf("known hosts file is required unless the host key verification is explicitly disabled")
+	}
+	if err := ensureKnownHostsFile(config.KnownHostsFile); err != nil {
+		return nil, err
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsLock.Lock()
+		defer knownHostsLock.Unlock()
+		// reload on every call so the keys appended by earlier connections are taken into account:
+		callback, err := knownhosts.New(config.KnownHostsFile)
+		if err != nil {
+			return errors.Wrap(err, "failed loading the known hosts file")
+		}
+		verifyErr := callback(hostname, remote, key)
+		if verifyErr == nil {
+			return nil
+		}
+		keyErr, ok := verifyErr.(*knownhosts.KeyError)
+		if !ok {
+			return verifyErr
+		}
+		if len(keyErr.Want) > 0 {
+			return &ErrorHostKeyMismatch{Host: hostname, KnownHostsFile: config.KnownHostsFile}
+		}
+		if config.StrictHostKeyChecking {
+			return &ErrorHostKeyUnknown{Host: hostname, KnownHostsFile: config.KnownHostsFile}
+		}
+		// trust on first use:
+		return appendKnownHost(config.KnownHostsFile, hostname, remote, key)
+	}, nil
+}
+
+func ensureKnownHostsFile(knownHostsFile string) error {
+	if err := os.MkdirAll(filepath.Dir(knownHostsFile), 0700); err != nil {
+		return errors.Wrap(err, "failed creating the known hosts file directory")
+	}
+	file, err := os.OpenFile(knownHostsFile, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed opening the known hosts file")
+	}
+	return file.Close()
+}
+
+func appendKnownHost(knownHostsFile, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if remote != nil {
+		if remoteAddress := knownhosts.Normalize(remote.String()); remoteAddress != addresses[0] {
+			addresses = append(addresses, remoteAddress)
+		}
+	}
+	file, err := os.OpenFile(knownHostsFile, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed opening the known hosts file for writing")
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintln(file, knownhosts.Line(addresses, key)); err != nil {
+		return errors.Wrap(err, "failed writing the known hosts file")
+	}
+	return nil
+}
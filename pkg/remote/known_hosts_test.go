@@ -0,0 +1,65 @@
+package remote
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostKeyCallback(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	newKey := func() ssh.PublicKey {
+		public, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ssh.NewPublicKey(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	hostKey := newKey()
+	otherKey := newKey()
+	remoteAddr := &net.TCPAddr{IP: net.ParseIP("192.168.127.10"), Port: 22}
+	knownHostsFile := filepath.Join(tempDir, "vmm", "known_hosts")
+
+	_, err = hostKeyCallback(&ConnectConfig{})
+	assert.NotNil(t, err, "expected an error without the known hosts file")
+
+	insecure, err := hostKeyCallback(&ConnectConfig{InsecureIgnoreHostKey: true})
+	assert.Nil(t, err)
+	assert.Nil(t, insecure("192.168.127.10:22", remoteAddr, hostKey))
+
+	strict, err := hostKeyCallback(&ConnectConfig{KnownHostsFile: knownHostsFile, StrictHostKeyChecking: true})
+	assert.Nil(t, err)
+	assert.IsType(t, &ErrorHostKeyUnknown{}, strict("192.168.127.10:22", remoteAddr, hostKey))
+
+	tofu, err := hostKeyCallback(&ConnectConfig{KnownHostsFile: knownHostsFile})
+	assert.Nil(t, err)
+	// first connect adds the key:
+	assert.Nil(t, tofu("192.168.127.10:22", remoteAddr, hostKey))
+	assert.Nil(t, tofu("192.168.127.10:22", remoteAddr, hostKey))
+	assert.Nil(t, strict("192.168.127.10:22", remoteAddr, hostKey))
+	// a different key for the known host is rejected in both modes:
+	assert.IsType(t, &ErrorHostKeyMismatch{}, tofu("192.168.127.10:22", remoteAddr, otherKey))
+	assert.IsType(t, &ErrorHostKeyMismatch{}, strict("192.168.127.10:22", remoteAddr, otherKey))
+
+	contents, err := ioutil.ReadFile(knownHostsFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(strings.Split(strings.TrimSpace(string(contents)), "\n")))
+}
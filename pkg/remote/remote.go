@@ -0,0 +1,364 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ResourceCopyBufferSize is the buffer size for the resource copy operations.
+const ResourceCopyBufferSize = 4 * 1024 * 1024
+
+// ConnectConfig is the remote connection configuration.
+type ConnectConfig struct {
+	Host string
+	Port int
+	User string
+	// IdentityFile is the path to the SSH private key,
+	// if empty, the keys from the SSH agent are used.
+	IdentityFile string
+	// PrivateKey is the PEM encoded SSH private key, takes precedence over the IdentityFile.
+	PrivateKey []byte
+	// KnownHostsFile is the path to the known hosts file used to verify the host key.
+	// Keys of the hosts not in the file are appended on the first connect,
+	// unless StrictHostKeyChecking is set.
+	KnownHostsFile string
+	// StrictHostKeyChecking rejects the hosts not in the known hosts file.
+	StrictHostKeyChecking bool
+	// InsecureIgnoreHostKey disables the host key verification.
+	InsecureIgnoreHostKey bool
+	Timeout               time.Duration
+}
+
+// Connected represents a connected remote VMM.
+type Connected interface {
+	// Close closes the remote connection.
+	Close() error
+	// Exec executes the command and streams its output to the writers.
+	// Returns the exit code of the remote command.
+	Exec(command string, stdout, stderr io.Writer) (int, error)
+	// GetResource downloads the remote file or directory to the local path.
+	// If the local path is an existing directory, the resource is downloaded into it.
+	// Directories are downloaded recursively, file modes are preserved.
+	GetResource(remotePath, localPath string) error
+	// PutResource uploads the local file or directory to the remote path.
+	// If the remote path is an existing directory, the resource is uploaded into it.
+	// Directories are uploaded recursively, file modes are preserved.
+	PutResource(localPath, remotePath string) error
+}
+
+// ConnectConfigFromRunMetadata returns the connect configuration
+// for the VMM described by the run metadata.
+func ConnectConfigFromRunMetadata(md *metadata.MDRun) (*ConnectConfig, error) {
+	if len(md.NetworkInterfaces) == 0 ||
+		md.NetworkInterfaces[0].StaticConfiguration == nil ||
+		md.NetworkInterfaces[0].StaticConfiguration.IPConfiguration == nil {
+		return nil, fmt.Errorf("VMM metadata does not contain the IP address")
+	}
+	config := &ConnectConfig{
+		Host: md.NetworkInterfaces[0].StaticConfiguration.IPConfiguration.IP,
+		Port: 22,
+	}
+	if md.Configs.Machine != nil {
+		config.User = md.Configs.Machine.SSHUser
+	}
+	return config, nil
+}
+
+type defaultConnected struct {
+	client *ssh.Client
+}
+
+// Connect connects to the remote VMM using SSH.
+func Connect(config *ConnectConfig) (Connected, error) {
+	authMethod, agentConn, err := authMethod(config)
+	if err != nil {
+		return nil, err
+	}
+	if agentConn != nil {
+		// the agent signs during the handshake only:
+		defer agentConn.Close()
+	}
+	hostKeyCallback, err := hostKeyCallback(config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := ssh.Dial("tcp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), &ssh.ClientConfig{
+		User:            config.User,
+		Auth:            []ssh.AuthMethod{authMethod},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         config.Timeout,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed connecting to the VMM")
+	}
+	return &defaultConnected{client: client}, nil
+}
+
+// ConnectWithRetry connects to the remote VMM, retrying until the connection
+// succeeds, the attempts are exhausted or the context is done. A booting VMM does not accept
+// the SSH connections and may not have the authorized keys deployed yet, all errors are retried.
+// Returns the last connection error.
+func ConnectWithRetry(ctx context.Context, config *ConnectConfig, retryConfig utils.RetryConfig) (Connected, error) {
+	var connected Connected
+	if err := utils.Retry(ctx, retryConfig, func(context.Context) error {
+		var err error
+		connected, err = Connect(config)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "VMM not reachable over SSH")
+	}
+	return connected, nil
+}
+
+// RunScript uploads the local script to the remote VMM, executes it
+// and streams its output to the writers. The uploaded script is removed afterwards.
+// Returns the exit code of the script.
+func RunScript(connected Connected, localPath string, stdout, stderr io.Writer) (int, error) {
+	remotePath := path.Join("/tmp", fmt.Sprintf("firebuild-script-%d", time.Now().UnixNano()))
+	if err := connected.PutResource(localPath, remotePath); err != nil {
+		return 1, errors.Wrap(err, "failed uploading script")
+	}
+	exitCode, err := connected.Exec(fmt.Sprintf("chmod +x %s && %s", remotePath, remotePath), stdout, stderr)
+	if _, cleanupErr := connected.Exec(fmt.Sprintf("rm -f %s", remotePath), ioutil.Discard, ioutil.Discard); cleanupErr != nil && err == nil {
+		err = errors.Wrap(cleanupErr, "failed removing script")
+	}
+	return exitCode, err
+}
+
+func (c *defaultConnected) Close() error {
+	return c.client.Close()
+}
+
+func (c *defaultConnected) Exec(command string, stdout, stderr io.Writer) (int, error) {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return 1, errors.Wrap(err, "failed creating SSH session")
+	}
+	defer session.Close()
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Run(command); err != nil {
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			return exitErr.ExitStatus(), nil
+		}
+		return 1, errors.Wrap(err, "failed executing remote command")
+	}
+	return 0, nil
+}
+
+// authMethod returns the SSH auth method of the config. When the keys come from the SSH agent,
+// the agent connection is returned too, the caller closes it once the handshake is done.
+func authMethod(config *ConnectConfig) (ssh.AuthMethod, io.Closer, error) {
+	if len(config.PrivateKey) > 0 {
+		signer, err := ssh.ParsePrivateKey(config.PrivateKey)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed parsing the SSH private key")
+		}
+		return ssh.PublicKeys(signer), nil, nil
+	}
+	if config.IdentityFile != "" {
+		keyBytes, err := ioutil.ReadFile(config.IdentityFile)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed reading the SSH identity file")
+		}
+		signer, err := ssh.ParsePrivateKey(keyBytes)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed parsing the SSH identity file")
+		}
+		return ssh.PublicKeys(signer), nil, nil
+	}
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, fmt.Errorf("no SSH identity file given and SSH_AUTH_SOCK not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed connecting to the SSH agent")
+	}
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), conn, nil
+}
+
+func (c *defaultConnected) GetResource(remotePath, localPath string) error {
+	client, err := sftp.NewClient(c.client)
+	if err != nil {
+		return errors.Wrap(err, "failed creating SFTP client")
+	}
+	defer client.Close()
+	return getResource(client, remotePath, localTarget(remotePath, localPath))
+}
+
+func (c *defaultConnected) PutResource(localPath, remotePath string) error {
+	client, err := sftp.NewClient(c.client)
+	if err != nil {
+		return errors.Wrap(err, "failed creating SFTP client")
+	}
+	defer client.Close()
+	return putResource(client, localPath, remoteTarget(client, localPath, remotePath))
+}
+
+// GetResourceWithRetry downloads the remote file or directory to the local path like GetResource.
+// The download failing on a connection error is retried over a new connection, the failures
+// like a missing file or a denied permission are not retried. The local target is resolved
+// before the first attempt so a directory created by a failed attempt does not change the target.
+func GetResourceWithRetry(ctx context.Context, config *ConnectConfig, retryConfig utils.RetryConfig, remotePath, localPath string) error {
+	localPath = localTarget(remotePath, localPath)
+	return transferWithRetry(ctx, config, retryConfig, func(client *sftp.Client) error {
+		return getResource(client, remotePath, localPath)
+	})
+}
+
+// PutResourceWithRetry uploads the local file or directory to the remote path like PutResource.
+// The upload failing on a connection error is retried over a new connection, the failures
+// like a missing file or a denied permission are not retried. The remote target is resolved
+// on the first connection so a directory created by a failed attempt does not change the target.
+func PutResourceWithRetry(ctx context.Context, config *ConnectConfig, retryConfig utils.RetryConfig, localPath, remotePath string) error {
+	resolved := false
+	return transferWithRetry(ctx, config, retryConfig, func(client *sftp.Client) error {
+		if !resolved {
+			remotePath = remoteTarget(client, localPath, remotePath)
+			resolved = true
+		}
+		return putResource(client, localPath, remotePath)
+	})
+}
+
+func transferWithRetry(ctx context.Context, config *ConnectConfig, retryConfig utils.RetryConfig, transfer func(*sftp.Client) error) error {
+	return utils.Retry(ctx, retryConfig, func(context.Context) error {
+		connected, err := Connect(config)
+		if err != nil {
+			return err
+		}
+		defer connected.Close()
+		client, err := sftp.NewClient(connected.(*defaultConnected).client)
+		if err != nil {
+			return errors.Wrap(err, "failed creating SFTP client")
+		}
+		defer client.Close()
+		if err := transfer(client); err != nil {
+			if !isRetryableTransferError(err) {
+				return utils.Permanent(err)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// isRetryableTransferError returns false for the errors of the local or the remote file system,
+// for example a missing file or a denied permission, the connection errors are retryable.
+func isRetryableTransferError(err error) bool {
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return false
+	}
+	var pathError *os.PathError
+	if errors.As(err, &pathError) {
+		return false
+	}
+	var statusError *sftp.StatusError
+	return !errors.As(err, &statusError)
+}
+
+// localTarget returns the local path of the downloaded resource. Like docker cp,
+// an existing directory receives the resource under its base name.
+func localTarget(remotePath, localPath string) string {
+	if stat, err := os.Stat(localPath); err == nil && stat.IsDir() {
+		return filepath.Join(localPath, path.Base(remotePath))
+	}
+	return localPath
+}
+
+// remoteTarget returns the remote path of the uploaded resource. Like docker cp,
+// an existing directory receives the resource under its base name.
+func remoteTarget(client *sftp.Client, localPath, remotePath string) string {
+	if stat, err := client.Stat(remotePath); err == nil && stat.IsDir() {
+		return path.Join(remotePath, filepath.Base(localPath))
+	}
+	return remotePath
+}
+
+func getResource(client *sftp.Client, remotePath, localPath string) error {
+	stat, err := client.Stat(remotePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed stat remote '%s'", remotePath)
+	}
+	if stat.IsDir() {
+		if err := os.MkdirAll(localPath, stat.Mode().Perm()); err != nil {
+			return errors.Wrapf(err, "failed creating local directory '%s'", localPath)
+		}
+		entries, err := client.ReadDir(remotePath)
+		if err != nil {
+			return errors.Wrapf(err, "failed listing remote directory '%s'", remotePath)
+		}
+		for _, entry := range entries {
+			if err := getResource(client, path.Join(remotePath, entry.Name()), filepath.Join(localPath, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return os.Chmod(localPath, stat.Mode().Perm())
+	}
+	source, err := client.Open(remotePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed opening remote '%s'", remotePath)
+	}
+	defer source.Close()
+	target, err := os.OpenFile(localPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, stat.Mode().Perm())
+	if err != nil {
+		return errors.Wrapf(err, "failed opening local '%s'", localPath)
+	}
+	defer target.Close()
+	if _, err := io.CopyBuffer(target, source, make([]byte, ResourceCopyBufferSize)); err != nil {
+		return errors.Wrapf(err, "failed downloading '%s'", remotePath)
+	}
+	return os.Chmod(localPath, stat.Mode().Perm())
+}
+
+func putResource(client *sftp.Client, localPath, remotePath string) error {
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed stat local '%s'", localPath)
+	}
+	if stat.IsDir() {
+		if err := client.MkdirAll(remotePath); err != nil {
+			return errors.Wrapf(err, "failed creating remote directory '%s'", remotePath)
+		}
+		entries, err := ioutil.ReadDir(localPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed listing local directory '%s'", localPath)
+		}
+		for _, entry := range entries {
+			if err := putResource(client, filepath.Join(localPath, entry.Name()), path.Join(remotePath, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return client.Chmod(remotePath, stat.Mode().Perm())
+	}
+	source, err := os.Open(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed opening local '%s'", localPath)
+	}
+	defer source.Close()
+	target, err := client.OpenFile(remotePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return errors.Wrapf(err, "failed opening remote '%s'", remotePath)
+	}
+	defer target.Close()
+	if _, err := io.CopyBuffer(target, source, make([]byte, ResourceCopyBufferSize)); err != nil {
+		return errors.Wrapf(err, "failed uploading '%s'", localPath)
+	}
+	return client.Chmod(remotePath, stat.Mode().Perm())
+}
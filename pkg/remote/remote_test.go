@@ -0,0 +1,156 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingConnected struct {
+	commands []string
+	uploads  map[string]string
+	exitCode int
+}
+
+func (c *recordingConnected) Close() error {
+	return nil
+}
+
+func (c *recordingConnected) Exec(command string, stdout, stderr io.Writer) (int, error) {
+	c.commands = append(c.commands, command)
+	if strings.HasPrefix(command, "rm ") {
+		return 0, nil
+	}
+	stdout.Write([]byte("output"))
+	return c.exitCode, nil
+}
+
+func (c *recordingConnected) GetResource(remotePath, localPath string) error {
+	return nil
+}
+
+func (c *recordingConnected) PutResource(localPath, remotePath string) error {
+	c.uploads[remotePath] = localPath
+	return nil
+}
+
+func TestRunScript(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	script := filepath.Join(tempDir, "provision.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho output\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	connected := &recordingConnected{uploads: map[string]string{}, exitCode: 3}
+	stdout := &bytes.Buffer{}
+	exitCode, err := RunScript(connected, script, stdout, ioutil.Discard)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, exitCode)
+	assert.Equal(t, "output", stdout.String())
+
+	assert.Len(t, connected.uploads, 1)
+	for remotePath, localPath := range connected.uploads {
+		assert.Equal(t, script, localPath)
+		assert.Equal(t, []string{"chmod +x " + remotePath + " && " + remotePath, "rm -f " + remotePath}, connected.commands)
+	}
+}
+
+func TestConnectWithRetryGivesUp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing accepts the SSH handshake once closed:
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	privateKey, err := utils.GenerateRSAPrivateKey(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	_, err = ConnectWithRetry(ctx, &ConnectConfig{
+		Host:                  "127.0.0.1",
+		Port:                  port,
+		User:                  "test",
+		PrivateKey:            utils.EncodePrivateKeyToPEM(privateKey),
+		InsecureIgnoreHostKey: true,
+		Timeout:               time.Millisecond * 100,
+	}, utils.RetryConfig{InitialBackoff: time.Millisecond * 50, MaxBackoff: time.Millisecond * 50})
+	assert.NotNil(t, err)
+}
+
+func TestConnectClosesAgentConnection(t *testing.T) {
+	agentListener, err := net.Listen("unix", filepath.Join(t.TempDir(), "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agentListener.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn, err := agentListener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// returns once the client closes the connection:
+		ioutil.ReadAll(conn)
+		close(closed)
+	}()
+	previous, hasPrevious := os.LookupEnv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", agentListener.Addr().String())
+	defer func() {
+		if hasPrevious {
+			os.Setenv("SSH_AUTH_SOCK", previous)
+		} else {
+			os.Unsetenv("SSH_AUTH_SOCK")
+		}
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	_, err = Connect(&ConnectConfig{
+		Host:                  "127.0.0.1",
+		Port:                  port,
+		User:                  "test",
+		InsecureIgnoreHostKey: true,
+		Timeout:               time.Millisecond * 100,
+	})
+	assert.NotNil(t, err)
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected the SSH agent connection to be closed")
+	}
+}
+
+func TestIsRetryableTransferError(t *testing.T) {
+	assert.False(t, isRetryableTransferError(os.ErrNotExist))
+	assert.False(t, isRetryableTransferError(errors.Wrap(&os.PathError{Op: "open", Path: "/tmp", Err: os.ErrPermission}, "failed opening local '/tmp'")))
+	assert.False(t, isRetryableTransferError(&sftp.StatusError{Code: 4}))
+	assert.True(t, isRetryableTransferError(errors.Wrap(io.EOF, "failed uploading '/tmp/file'")))
+	assert.True(t, isRetryableTransferError(sftp.ErrSSHFxConnectionLost))
+}
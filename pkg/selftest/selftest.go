@@ -0,0 +1,107 @@
+package selftest
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// StatusPassed is the status of a step which succeeded.
+	StatusPassed = "passed"
+	// StatusFailed is the status of a step which failed.
+	StatusFailed = "failed"
+	// StatusSkipped is the status of a step which was not executed.
+	StatusSkipped = "skipped"
+)
+
+// MarkerFile is the file written to the rootfs by the self-test Dockerfile.
+const MarkerFile = "/etc/firebuild-selftest"
+
+// Step is a step of the self-test.
+type Step struct {
+	Name string
+	// Teardown steps are executed even if a previous step failed.
+	Teardown bool
+	// Run executes the step, returns the error returned by Skip when the step has nothing to do.
+	Run func() error
+}
+
+// StepResult is the result of an executed or skipped step.
+type StepResult struct {
+	Step       string  `json:"Step"`
+	Status     string  `json:"Status"`
+	DurationMs float64 `json:"DurationMs"`
+	Reason     string  `json:"Reason,omitempty"`
+}
+
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return e.reason
+}
+
+// Skip returns an error marking the step as skipped with the reason.
+func Skip(reason string) error {
+	return &skipError{reason: reason}
+}
+
+// Execute executes the steps in order and returns the result of every step.
+// Once a step fails, the remaining steps are skipped, except of the teardown steps.
+// The result of every step is passed to the callback, if given, as soon as the step finishes.
+func Execute(steps []*Step, callback func(*StepResult)) []*StepResult {
+	results := []*StepResult{}
+	failed := ""
+	for _, step := range steps {
+		result := &StepResult{Step: step.Name}
+		if failed != "" && !step.Teardown {
+			result.Status = StatusSkipped
+			result.Reason = fmt.Sprintf("step %s failed", failed)
+		} else {
+			started := time.Now()
+			err := step.Run()
+			result.DurationMs = float64(time.Since(started)) / float64(time.Millisecond)
+			switch typed := err.(type) {
+			case nil:
+				result.Status = StatusPassed
+			case *skipError:
+				result.Status = StatusSkipped
+				result.Reason = typed.reason
+			default:
+				result.Status = StatusFailed
+				result.Reason = err.Error()
+				if failed == "" {
+					failed = step.Name
+				}
+			}
+		}
+		results = append(results, result)
+		if callback != nil {
+			callback(result)
+		}
+	}
+	return results
+}
+
+// Passed returns true if no step failed.
+func Passed(results []*StepResult) bool {
+	for _, result := range results {
+		if result.Status == StatusFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// RootfsDockerfile returns the trivial Dockerfile built by the self-test,
+// the marker is written to the MarkerFile and read back from the running VMM.
+func RootfsDockerfile(baseImage, marker string) string {
+	return fmt.Sprintf("FROM %s\nRUN echo %s > %s\n", baseImage, marker, MarkerFile)
+}
+
+// ReadinessScript returns the provisioning script executed by the run command once the VMM
+// accepts SSH connections, the run command succeeds only if the VMM is ready.
+func ReadinessScript() string {
+	return fmt.Sprintf("#!/bin/sh\ntest -f %s\n", MarkerFile)
+}
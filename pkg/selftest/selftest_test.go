@@ -0,0 +1,49 @@
+package selftest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecute(t *testing.T) {
+	executed := []string{}
+	step := func(name string, teardown bool, err error) *Step {
+		return &Step{Name: name, Teardown: teardown, Run: func() error {
+			executed = append(executed, name)
+			return err
+		}}
+	}
+	reported := 0
+	results := Execute([]*Step{
+		step("baseos", false, Skip("requires network access")),
+		step("rootfs", false, nil),
+		step("run", false, fmt.Errorf("boom")),
+		step("exec", false, nil),
+		step("kill", true, nil),
+		step("rm", true, fmt.Errorf("rm failed")),
+	}, func(*StepResult) { reported++ })
+
+	assert.Equal(t, []string{"baseos", "rootfs", "run", "kill", "rm"}, executed)
+	assert.Equal(t, 6, reported)
+	assert.False(t, Passed(results))
+
+	statuses := []string{}
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	assert.Equal(t, []string{StatusSkipped, StatusPassed, StatusFailed, StatusSkipped, StatusPassed, StatusFailed}, statuses)
+	assert.Equal(t, "requires network access", results[0].Reason)
+	assert.Equal(t, "boom", results[2].Reason)
+	assert.Equal(t, "step run failed", results[3].Reason)
+}
+
+func TestPassed(t *testing.T) {
+	assert.True(t, Passed([]*StepResult{{Status: StatusPassed}, {Status: StatusSkipped}}))
+	assert.False(t, Passed([]*StepResult{{Status: StatusPassed}, {Status: StatusFailed}}))
+}
+
+func TestRootfsDockerfile(t *testing.T) {
+	assert.Equal(t, "FROM alpine:3.13\nRUN echo marker > /etc/firebuild-selftest\n", RootfsDockerfile("alpine:3.13", "marker"))
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// hostArch is the architecture of the rootfs stored without the architecture
+// when its metadata does not state the architecture.
+var hostArch = runtime.GOARCH
+
+// WithArchitecture wraps the provider so the lookups with an architecture fall back to the rootfs
+// stored without the architecture, the rootfs stored before the architecture was recorded.
+// The providers store the rootfs of an architecture under its own key, the fallback applies only
+// when that rootfs does not exist and the rootfs without the architecture is built for the architecture:
+// the architecture stated by its metadata or, if not stated, the host architecture.
+// The optional listing and deduplicating capabilities of the provider are retained.
+func WithArchitecture(impl Provider) Provider {
+	wrapped := &archProvider{Provider: impl}
+	listing, isListing := impl.(ListingProvider)
+	deduplicating, isDeduplicating := impl.(DeduplicatingProvider)
+	switch {
+	case isListing && isDeduplicating:
+		return &struct {
+			*archProvider
+			ListingProvider
+			DeduplicatingProvider
+		}{wrapped, listing, deduplicating}
+	case isListing:
+		return &struct {
+			*archProvider
+			ListingProvider
+		}{wrapped, listing}
+	case isDeduplicating:
+		return &struct {
+			*archProvider
+			DeduplicatingProvider
+		}{wrapped, deduplicating}
+	default:
+		return wrapped
+	}
+}
+
+type archProvider struct {
+	Provider
+}
+
+// DeleteRootfs deletes the architecture specific rootfs, if it exists, or the rootfs without the architecture.
+func (p *archProvider) DeleteRootfs(input *RootfsLookup) (*RootfsDeleteResult, error) {
+	result, err := p.Provider.DeleteRootfs(input)
+	if input.Arch == "" || !errors.Is(err, ErrRootfsNotFound) {
+		return result, err
+	}
+	legacy, _, err := p.legacyLookup(input)
+	if err != nil {
+		return nil, err
+	}
+	return p.Provider.DeleteRootfs(legacy)
+}
+
+// FetchRootfs fetches the architecture specific rootfs or the rootfs without the architecture.
+func (p *archProvider) FetchRootfs(input *RootfsLookup) (RootfsResult, error) {
+	result, err := p.Provider.FetchRootfs(input)
+	if input.Arch == "" || !errors.Is(err, ErrRootfsNotFound) {
+		return result, err
+	}
+	legacy, _, err := p.legacyLookup(input)
+	if err != nil {
+		return nil, err
+	}
+	return p.Provider.FetchRootfs(legacy)
+}
+
+// FetchRootfsAttachment fetches the attachment of the architecture specific rootfs, if it exists,
+// or the attachment of the rootfs without the architecture.
+func (p *archProvider) FetchRootfsAttachment(input *RootfsLookup, name string, writer io.Writer) error {
+	resolved, err := p.resolve(input)
+	if err != nil {
+		return err
+	}
+	return p.Provider.FetchRootfsAttachment(resolved, name, writer)
+}
+
+// FetchRootfsMetadata fetches the metadata of the architecture specific rootfs or the rootfs without the architecture.
+func (p *archProvider) FetchRootfsMetadata(input *RootfsLookup) (interface{}, error) {
+	result, err := p.Provider.FetchRootfsMetadata(input)
+	if input.Arch == "" || !errors.Is(err, ErrRootfsNotFound) {
+		return result, err
+	}
+	_, result, err = p.legacyLookup(input)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// TagRootfs creates the architecture specific tag entry. The source is resolved like with FetchRootfsMetadata.
+func (p *archProvider) TagRootfs(input *RootfsTag) (*RootfsStoreResult, error) {
+	if input.Source == nil {
+		return p.Provider.TagRootfs(input)
+	}
+	source, err := p.resolve(input.Source)
+	if err != nil {
+		return nil, err
+	}
+	return p.Provider.TagRootfs(&RootfsTag{
+		Source:    source,
+		Metadata:  input.Metadata,
+		Overwrite: input.Overwrite,
+		Org:       input.Org,
+		Image:     input.Image,
+		Version:   input.Version,
+		Arch:      input.Arch,
+	})
+}
+
+// resolve returns the lookup of the architecture specific rootfs, if it exists,
+// or the lookup of the rootfs without the architecture.
+func (p *archProvider) resolve(input *RootfsLookup) (*RootfsLookup, error) {
+	if input.Arch == "" {
+		return input, nil
+	}
+	_, err := p.Provider.FetchRootfsMetadata(input)
+	if err == nil {
+		return input, nil
+	}
+	if !errors.Is(err, ErrRootfsNotFound) {
+		return nil, err
+	}
+	legacy, _, err := p.legacyLookup(input)
+	return legacy, err
+}
+
+// legacyLookup returns the lookup and the metadata of the rootfs stored without the architecture.
+// Returns an error wrapping ErrRootfsNotFound if the rootfs is built for another architecture.
+func (p *archProvider) legacyLookup(input *RootfsLookup) (*RootfsLookup, interface{}, error) {
+	legacy := &RootfsLookup{Org: input.Org, Image: input.Image, Version: input.Version}
+	md, err := p.Provider.FetchRootfsMetadata(legacy)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkMetadataArch(input, md); err != nil {
+		return nil, nil, err
+	}
+	return legacy, md, nil
+}
+
+// checkMetadataArch returns an error wrapping ErrRootfsNotFound when the rootfs stored without
+// the architecture is not built for the architecture looked up.
+func checkMetadataArch(input *RootfsLookup, md interface{}) error {
+	bytes, err := json.Marshal(md)
+	if err != nil {
+		return fmt.Errorf("failed serializing rootfs metadata: %w", err)
+	}
+	typed := struct {
+		Image struct {
+			Arch string `json:"Arch"`
+		} `json:"Image"`
+	}{}
+	if err := json.Unmarshal(bytes, &typed); err != nil {
+		return fmt.Errorf("failed decoding rootfs metadata: %w", err)
+	}
+	arch := typed.Image.Arch
+	if arch == "" {
+		// stored before the architecture was recorded, built on this host:
+		arch = hostArch
+	}
+	if arch != input.Arch {
+		return fmt.Errorf("rootfs %s/%s:%s is built for architecture %s, not %s: %w",
+			input.Org, input.Image, input.Version, arch, input.Arch, ErrRootfsNotFound)
+	}
+	return nil
+}
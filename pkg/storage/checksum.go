@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// RootfsSHA256MetadataKey is the metadata property recording the SHA-256 of the stored rootfs file.
+const RootfsSHA256MetadataKey = "RootfsSHA256"
+
+// ErrRootfsChecksumMismatch is returned when the rootfs file does not match the recorded SHA-256.
+var ErrRootfsChecksumMismatch = errors.New("rootfs checksum mismatch")
+
+// ErrRootfsChecksumMissing is returned when the rootfs metadata does not record the SHA-256,
+// the rootfs was stored before the checksum was recorded.
+var ErrRootfsChecksumMissing = errors.New("rootfs checksum not recorded")
+
+// FileSHA256 returns the hex encoded SHA-256 of the file. The file is streamed, not read into memory.
+func FileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// WithRootfsSHA256 returns the metadata with the SHA-256 of the rootfs file recorded.
+// The SHA-256 is always of the uncompressed rootfs file, the file the VMM uses.
+func WithRootfsSHA256(metadata interface{}, digest string) (interface{}, error) {
+	metadataJSONBytes, err := json.Marshal(&metadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed serializing rootfs metadata")
+	}
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(metadataJSONBytes, &result); err != nil {
+		return nil, errors.Wrap(err, "failed recording checksum in rootfs metadata")
+	}
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+	result[RootfsSHA256MetadataKey] = digest
+	return result, nil
+}
+
+// RootfsSHA256 returns the SHA-256 of the rootfs file recorded in the metadata, empty if not recorded.
+func RootfsSHA256(metadata interface{}) string {
+	metadataJSONBytes, err := json.Marshal(&metadata)
+	if err != nil {
+		return ""
+	}
+	typed := map[string]interface{}{}
+	if err := json.Unmarshal(metadataJSONBytes, &typed); err != nil {
+		return ""
+	}
+	digest, _ := typed[RootfsSHA256MetadataKey].(string)
+	return digest
+}
+
+// VerifyRootfs recomputes the SHA-256 of the resolved rootfs file and compares it with the SHA-256
+// recorded in the metadata. Returns ErrRootfsChecksumMissing if the metadata does not record the SHA-256
+// and ErrRootfsChecksumMismatch if the file does not match.
+func VerifyRootfs(result RootfsResult) error {
+	expected := RootfsSHA256(result.Metadata())
+	if expected == "" {
+		return ErrRootfsChecksumMissing
+	}
+	actual, err := FileSHA256(result.HostPath())
+	if err != nil {
+		return errors.Wrap(err, "failed calculating rootfs SHA-256")
+	}
+	if actual != expected {
+		return errors.Wrapf(ErrRootfsChecksumMismatch, "%s has SHA-256 %s, expected %s", result.HostPath(), actual, expected)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package directory
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// FetchRootfsAttachment writes the contents of the named attachment of a root file system to the writer.
+func (p *provider) FetchRootfsAttachment(q *storage.RootfsLookup, name string, writer io.Writer) error {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	p.logger.Debug("looking up rootfs attachment", "rootfs-id", rootfsID, "attachment", name)
+	tagDirectory := p.tagDirectory(q.Org, q.Image, q.Version, q.Arch)
+	if !isRootfsStored(tagDirectory) {
+		p.logger.Error("rootfs not found", "rootfs-id", rootfsID)
+		return errors.Wrapf(storage.ErrRootfsNotFound, "rootfs %s", rootfsID)
+	}
+	// the name must not escape the attachments directory:
+	if name == "" || filepath.Base(name) != name {
+		return errors.Wrapf(storage.ErrAttachmentNotFound, "attachment %q of rootfs %s", name, rootfsID)
+	}
+	attachmentFile, err := os.Open(filepath.Join(tagDirectory, naming.AttachmentsDirectoryName, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Wrapf(storage.ErrAttachmentNotFound, "attachment %q of rootfs %s", name, rootfsID)
+		}
+		p.logger.Error("error opening rootfs attachment", "reason", err, "rootfs-id", rootfsID, "attachment", name)
+		return errors.Wrap(err, "failed opening rootfs attachment")
+	}
+	defer attachmentFile.Close()
+	if _, err := io.Copy(writer, attachmentFile); err != nil {
+		return errors.Wrap(err, "failed reading rootfs attachment")
+	}
+	return nil
+}
+
+// storeAttachments replaces the attachments of the tag with the copies of the local files.
+func storeAttachments(tagDirectory string, attachments map[string]string) error {
+	attachmentsDirectory := filepath.Join(tagDirectory, naming.AttachmentsDirectoryName)
+	if err := os.RemoveAll(attachmentsDirectory); err != nil {
+		return err
+	}
+	if len(attachments) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(attachmentsDirectory, 0755); err != nil {
+		return err
+	}
+	for name, localPath := range attachments {
+		if err := copyFile(localPath, filepath.Join(attachmentsDirectory, name)); err != nil {
+			return errors.Wrapf(err, "failed copying attachment %q", name)
+		}
+	}
+	return nil
+}
+
+// linkAttachments replaces the attachments of the target tag with the links to the attachments of the source tag.
+func linkAttachments(sourceDirectory, targetDirectory string) error {
+	targetAttachmentsDirectory := filepath.Join(targetDirectory, naming.AttachmentsDirectoryName)
+	if err := os.RemoveAll(targetAttachmentsDirectory); err != nil {
+		return err
+	}
+	sourceAttachmentsDirectory := filepath.Join(sourceDirectory, naming.AttachmentsDirectoryName)
+	entries, err := ioutil.ReadDir(sourceAttachmentsDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err := utils.LinkOrCloneFile(filepath.Join(sourceAttachmentsDirectory, entry.Name()), filepath.Join(targetAttachmentsDirectory, entry.Name())); err != nil {
+			return errors.Wrapf(err, "failed linking attachment %q", entry.Name())
+		}
+	}
+	return nil
+}
+
+// removeAttachments removes the attachments of the tag and returns the number of bytes released,
+// the attachments still linked by other tags are not counted.
+func removeAttachments(tagDirectory string) (int64, error) {
+	attachmentsDirectory := filepath.Join(tagDirectory, naming.AttachmentsDirectoryName)
+	entries, err := ioutil.ReadDir(attachmentsDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	freed := int64(0)
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && utils.LinkCount(entry) <= 1 {
+			freed = freed + entry.Size()
+		}
+	}
+	return freed, os.RemoveAll(attachmentsDirectory)
+}
+
+func copyFile(source, target string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	targetFile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(targetFile, sourceFile); err != nil {
+		targetFile.Close()
+		return err
+	}
+	return targetFile.Close()
+}
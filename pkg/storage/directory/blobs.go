@@ -0,0 +1,209 @@
+package directory
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// blobsDirectoryName is the directory under the rootfs storage root
+// where the deduplicated rootfs files are stored by their SHA-256.
+// The leading dot keeps it out of the org namespace, an org name can't contain a dot.
+const blobsDirectoryName = ".blobs"
+
+var reBlobDigest = regexp.MustCompile("^[a-f0-9]{64}$")
+
+// DeduplicateRootfs converts the rootfs files stored in the full copy layout
+// to blobs. Rootfs files already pointing at a blob are skipped.
+func (p *provider) DeduplicateRootfs() (int, error) {
+	candidates := []string{}
+	walkErr := filepath.WalkDir(p.config.RootfsStorageRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (path == p.blobsRoot() || p.isAttachmentsDirectory(path)) {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || d.Name() != naming.RootfsFileName {
+			return nil
+		}
+		hasPointer, err := utils.PathExists(filepath.Join(filepath.Dir(path), naming.RootfsBlobPointerFileName))
+		if err != nil {
+			return err
+		}
+		if !hasPointer {
+			candidates = append(candidates, path)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return 0, errors.Wrap(walkErr, "failed listing rootfs files")
+	}
+	for idx, candidate := range candidates {
+		p.logger.Debug("deduplicating rootfs", "rootfs-path", candidate)
+		digest, err := storage.FileSHA256(candidate)
+		if err != nil {
+			p.logger.Error("error calculating rootfs SHA-256", "reason", err, "rootfs-path", candidate)
+			return idx, errors.Wrapf(err, "failed calculating SHA-256 of %q", candidate)
+		}
+		if err := p.storeBlob(candidate, digest, filepath.Dir(candidate)); err != nil {
+			p.logger.Error("error deduplicating rootfs", "reason", err, "rootfs-path", candidate)
+			return idx, errors.Wrapf(err, "failed deduplicating %q", candidate)
+		}
+		p.logger.Debug("rootfs deduplicated", "rootfs-path", candidate, "sha256", digest)
+	}
+	return len(candidates), nil
+}
+
+// isAttachmentsDirectory returns true for the attachments directory of a tag,
+// an attachment may have the name of the rootfs file.
+// The attachments of an architecture are one level deeper.
+func (p *provider) isAttachmentsDirectory(path string) bool {
+	relativePath, err := filepath.Rel(p.config.RootfsStorageRoot, path)
+	if err != nil {
+		return false
+	}
+	depth := len(strings.Split(relativePath, string(filepath.Separator)))
+	return filepath.Base(path) == naming.AttachmentsDirectoryName && (depth == 4 || depth == 5)
+}
+
+func (p *provider) blobsRoot() string {
+	return filepath.Join(p.config.RootfsStorageRoot, blobsDirectoryName)
+}
+
+// storeBlob moves the source file to the blob storage, unless a blob with the same
+// content already exists, points the tag directory at the blob and links the blob
+// as the rootfs of the tag directory. The digest is the SHA-256 of the source file.
+func (p *provider) storeBlob(source, digest, tagDirectory string) error {
+	blobPath := filepath.Join(p.blobsRoot(), digest)
+	blobExists, err := utils.PathExists(blobPath)
+	if err != nil {
+		return err
+	}
+	if blobExists {
+		p.logger.Debug("rootfs blob exists", "sha256", digest)
+		if err := os.Remove(source); err != nil {
+			return errors.Wrap(err, "failed removing duplicate rootfs")
+		}
+	} else {
+		if err := os.MkdirAll(p.blobsRoot(), 0755); err != nil {
+			return errors.Wrap(err, "failed creating blobs directory")
+		}
+		// never leave an incomplete blob under the final name:
+		tempBlobPath := blobPath + ".tmp"
+		if err := os.Rename(source, tempBlobPath); err != nil {
+			if moveErr := utils.MoveFile(source, tempBlobPath); moveErr != nil {
+				return errors.Wrap(moveErr, "failed moving rootfs to blobs")
+			}
+		}
+		if err := os.Rename(tempBlobPath, blobPath); err != nil {
+			return errors.Wrap(err, "failed finalizing rootfs blob")
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(tagDirectory, naming.RootfsBlobPointerFileName), []byte(digest)); err != nil {
+		return errors.Wrap(err, "failed writing rootfs blob pointer")
+	}
+	if err := utils.LinkOrCloneFile(blobPath, filepath.Join(tagDirectory, naming.RootfsFileName)); err != nil {
+		return errors.Wrap(err, "failed linking rootfs blob")
+	}
+	return nil
+}
+
+// resolveBlob ensures the rootfs of the tag directory is linked to the blob
+// the tag directory points at and returns the rootfs path.
+func (p *provider) resolveBlob(tagDirectory string) (string, error) {
+	pointerBytes, err := ioutil.ReadFile(filepath.Join(tagDirectory, naming.RootfsBlobPointerFileName))
+	if err != nil {
+		return "", err
+	}
+	digest := strings.TrimSpace(string(pointerBytes))
+	if !reBlobDigest.MatchString(digest) {
+		return "", fmt.Errorf("invalid rootfs blob pointer %q", digest)
+	}
+	blobStat, err := utils.CheckIfExistsAndIsRegular(filepath.Join(p.blobsRoot(), digest))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed resolving rootfs blob %s", digest)
+	}
+	rootfsPath := filepath.Join(tagDirectory, naming.RootfsFileName)
+	if rootfsStat, err := os.Stat(rootfsPath); err == nil && os.SameFile(blobStat, rootfsStat) {
+		return rootfsPath, nil
+	}
+	p.logger.Debug("linking rootfs blob", "sha256", digest, "rootfs-path", rootfsPath)
+	if err := utils.LinkOrCloneFile(filepath.Join(p.blobsRoot(), digest), rootfsPath); err != nil {
+		return "", errors.Wrap(err, "failed linking rootfs blob")
+	}
+	return rootfsPath, nil
+}
+
+// releaseBlob deletes the blob if no tag directory points at it anymore
+// and returns the number of freed bytes.
+func (p *provider) releaseBlob(digest string) (int64, error) {
+	errReferenced := errors.New("referenced")
+	walkErr := filepath.WalkDir(p.config.RootfsStorageRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (path == p.blobsRoot() || p.isAttachmentsDirectory(path)) {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || d.Name() != naming.RootfsBlobPointerFileName {
+			return nil
+		}
+		pointerBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(pointerBytes)) == digest {
+			return errReferenced
+		}
+		return nil
+	})
+	if walkErr == errReferenced {
+		p.logger.Debug("rootfs blob still referenced", "sha256", digest)
+		return 0, nil
+	}
+	if walkErr != nil {
+		return 0, errors.Wrap(walkErr, "failed listing rootfs blob pointers")
+	}
+	blobPath := filepath.Join(p.blobsRoot(), digest)
+	blobStat, err := os.Stat(blobPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if err := os.Remove(blobPath); err != nil {
+		return 0, err
+	}
+	p.logger.Debug("rootfs blob deleted", "sha256", digest)
+	return blobStat.Size(), nil
+}
+
+// unlinkRootfs removes the rootfs, the compressed rootfs and the blob pointer of the tag directory.
+// The rootfs may be a hard link to a blob so it must never be overwritten in place.
+func unlinkRootfs(tagDirectory string) error {
+	for _, name := range []string{naming.RootfsBlobPointerFileName, naming.RootfsFileName, compressedRootfsFileName} {
+		if err := os.Remove(filepath.Join(tagDirectory, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tempPath := path + ".tmp"
+	if err := ioutil.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
@@ -0,0 +1,178 @@
+package directory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupStoreAndFetch(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := New(hclog.Default())
+	assert.Nil(t, impl.Configure(map[string]interface{}{
+		"dedup":               "true",
+		"rootfs-storage-root": filepath.Join(tempDir, "rootfs"),
+	}))
+
+	for _, version := range []string{"1.0", "1.1"} {
+		localPath := filepath.Join(tempDir, "build-"+version)
+		assert.Nil(t, ioutil.WriteFile(localPath, []byte("same content"), 0644))
+		_, err := impl.StoreRootfsFile(&storage.RootfsStore{
+			LocalPath: localPath,
+			Metadata:  map[string]interface{}{"version": version},
+			Org:       "tests",
+			Image:     "image",
+			Version:   version,
+		})
+		assert.Nil(t, err)
+	}
+
+	blobs, err := ioutil.ReadDir(filepath.Join(tempDir, "rootfs", blobsDirectoryName))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(blobs), "expected identical rootfs files to be stored once")
+
+	first, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	assert.Nil(t, err)
+	second, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.1"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"version": "1.1", storage.RootfsSHA256MetadataKey: testSHA256([]byte("same content"))}, second.Metadata())
+
+	firstStat, err := os.Stat(first.HostPath())
+	assert.Nil(t, err)
+	secondStat, err := os.Stat(second.HostPath())
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(firstStat, secondStat), "expected the rootfs files to link the same blob")
+
+	// a missing link is restored from the blob:
+	assert.Nil(t, os.Remove(first.HostPath()))
+	first, err = impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	assert.Nil(t, err)
+	content, err := ioutil.ReadFile(first.HostPath())
+	assert.Nil(t, err)
+	assert.Equal(t, "same content", string(content))
+
+	// storing without dedup must not modify the shared blob:
+	plain := New(hclog.Default())
+	assert.Nil(t, plain.Configure(map[string]interface{}{
+		"rootfs-storage-root": filepath.Join(tempDir, "rootfs"),
+	}))
+	localPath := filepath.Join(tempDir, "build-plain")
+	assert.Nil(t, ioutil.WriteFile(localPath, []byte("other content"), 0644))
+	_, err = plain.StoreRootfsFile(&storage.RootfsStore{
+		LocalPath: localPath,
+		Org:       "tests",
+		Image:     "image",
+		Version:   "1.0",
+	})
+	assert.Nil(t, err)
+	content, err = ioutil.ReadFile(second.HostPath())
+	assert.Nil(t, err)
+	assert.Equal(t, "same content", string(content))
+}
+
+func TestDeduplicateRootfs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rootfsRoot := filepath.Join(tempDir, "rootfs")
+	for _, version := range []string{"1.0", "1.1", "2.0"} {
+		tagDirectory := filepath.Join(rootfsRoot, "tests", "image", version)
+		assert.Nil(t, os.MkdirAll(tagDirectory, 0755))
+		content := "same content"
+		if version == "2.0" {
+			content = "other content"
+		}
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(tagDirectory, "rootfs"), []byte(content), 0644))
+	}
+
+	impl := New(hclog.Default())
+	assert.Nil(t, impl.Configure(map[string]interface{}{
+		"rootfs-storage-root": rootfsRoot,
+	}))
+
+	converted, err := impl.(storage.DeduplicatingProvider).DeduplicateRootfs()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, converted)
+
+	blobs, err := ioutil.ReadDir(filepath.Join(rootfsRoot, blobsDirectoryName))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(blobs))
+
+	// converted rootfs files are skipped:
+	converted, err = impl.(storage.DeduplicatingProvider).DeduplicateRootfs()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, converted)
+
+	for _, version := range []string{"1.0", "1.1", "2.0"} {
+		result, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: version})
+		assert.Nil(t, err)
+		_, err = os.Stat(result.HostPath())
+		assert.Nil(t, err)
+	}
+}
+
+func TestDedupOrgNamedBlobs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := New(hclog.Default())
+	assert.Nil(t, impl.Configure(map[string]interface{}{
+		"dedup":               "true",
+		"rootfs-storage-root": filepath.Join(tempDir, "rootfs"),
+	}))
+
+	// the org named like the blob store must not share the blob store namespace:
+	for _, version := range []string{"1.0", "1.1"} {
+		localPath := filepath.Join(tempDir, "build-"+version)
+		assert.Nil(t, ioutil.WriteFile(localPath, []byte("same content"), 0644))
+		_, err := impl.StoreRootfsFile(&storage.RootfsStore{
+			LocalPath: localPath,
+			Org:       "blobs",
+			Image:     "image",
+			Version:   version,
+		})
+		assert.Nil(t, err)
+	}
+
+	listed, err := impl.(storage.ListingProvider).ListRootfs()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(listed))
+	for _, item := range listed {
+		assert.Equal(t, "blobs", item.Org)
+	}
+
+	// the blob still referenced by the other tag must not be deleted:
+	_, err = impl.DeleteRootfs(&storage.RootfsLookup{Org: "blobs", Image: "image", Version: "1.0"})
+	assert.Nil(t, err)
+	remaining, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "blobs", Image: "image", Version: "1.1"})
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(remaining.HostPath()))
+	remaining, err = impl.FetchRootfs(&storage.RootfsLookup{Org: "blobs", Image: "image", Version: "1.1"})
+	assert.Nil(t, err)
+	content, err := ioutil.ReadFile(remaining.HostPath())
+	assert.Nil(t, err)
+	assert.Equal(t, "same content", string(content))
+
+	// the last reference releases the blob:
+	_, err = impl.DeleteRootfs(&storage.RootfsLookup{Org: "blobs", Image: "image", Version: "1.1"})
+	assert.Nil(t, err)
+	blobs, err := ioutil.ReadDir(filepath.Join(tempDir, "rootfs", blobsDirectoryName))
+	assert.Nil(t, err)
+	assert.Empty(t, blobs)
+}
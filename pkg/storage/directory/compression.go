@@ -0,0 +1,230 @@
+package directory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	// CompressionNone stores the rootfs files as they are.
+	CompressionNone = "none"
+	// CompressionZstd stores the rootfs files compressed with zstd.
+	CompressionZstd = "zstd"
+
+	// compressedRootfsFileName is the name of the zstd compressed rootfs file in the tag directory.
+	compressedRootfsFileName = naming.RootfsFileName + ".zst"
+	// compressionMetadataKey is the metadata property recording the compression of the stored rootfs.
+	compressionMetadataKey = "Compression"
+	// sourceFileSuffix is the suffix of the file recording the compressed file the decompressed rootfs was created from.
+	sourceFileSuffix = ".source"
+	// sparseBlockSize is the size of the zero blocks skipped when writing the decompressed rootfs.
+	sparseBlockSize = 4096
+)
+
+// compressionLevels maps the configured compression level to the zstd encoder level.
+var compressionLevels = map[int]zstd.EncoderLevel{
+	1: zstd.SpeedFastest,
+	2: zstd.SpeedDefault,
+	3: zstd.SpeedBetterCompression,
+	4: zstd.SpeedBestCompression,
+}
+
+// validateCompression validates the compression and the compression level.
+func validateCompression(compression string, level int) error {
+	switch compression {
+	case "", CompressionNone:
+		return nil
+	case CompressionZstd:
+		if _, ok := compressionLevels[level]; !ok {
+			return fmt.Errorf("directory storage provider: compression level must be between 1 and %d", len(compressionLevels))
+		}
+		return nil
+	}
+	return fmt.Errorf("directory storage provider: compression must be %s or %s", CompressionNone, CompressionZstd)
+}
+
+// compressFile compresses the source file to the target file with zstd.
+// The target is written to a temporary file and renamed once complete.
+func compressFile(source, target string, level int) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return errors.Wrap(err, "failed opening source file")
+	}
+	defer sourceFile.Close()
+	tempFile, err := ioutil.TempFile(filepath.Dir(target), filepath.Base(target)+".compress-")
+	if err != nil {
+		return errors.Wrap(err, "failed creating compressed file")
+	}
+	// the temp file is renamed once complete, this is a noop then:
+	defer os.Remove(tempFile.Name())
+	encoder, err := zstd.NewWriter(tempFile, zstd.WithEncoderLevel(compressionLevels[level]))
+	if err != nil {
+		tempFile.Close()
+		return errors.Wrap(err, "failed creating zstd encoder")
+	}
+	if _, err := io.CopyBuffer(encoder, sourceFile, make([]byte, utils.RootFSCopyBufferSize)); err != nil {
+		encoder.Close()
+		tempFile.Close()
+		return errors.Wrap(err, "failed compressing file")
+	}
+	if err := encoder.Close(); err != nil {
+		tempFile.Close()
+		return errors.Wrap(err, "failed compressing file")
+	}
+	if err := tempFile.Close(); err != nil {
+		return errors.Wrap(err, "failed writing compressed file")
+	}
+	return os.Rename(tempFile.Name(), target)
+}
+
+// decompressFile decompresses the zstd compressed source file to the target file.
+// The zero blocks are not written, the target is a sparse file like the ext4 file it was created from.
+// The target is written to a temporary file and renamed once complete.
+func decompressFile(source, target string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return errors.Wrap(err, "failed opening compressed file")
+	}
+	defer sourceFile.Close()
+	decoder, err := zstd.NewReader(sourceFile)
+	if err != nil {
+		return errors.Wrap(err, "failed creating zstd decoder")
+	}
+	defer decoder.Close()
+	tempFile, err := ioutil.TempFile(filepath.Dir(target), filepath.Base(target)+".decompress-")
+	if err != nil {
+		return errors.Wrap(err, "failed creating decompressed file")
+	}
+	// the temp file is renamed once complete, this is a noop then:
+	defer os.Remove(tempFile.Name())
+	size, err := copySparse(tempFile, decoder)
+	if err == nil {
+		err = tempFile.Truncate(size)
+	}
+	if closeErr := tempFile.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed decompressing file")
+	}
+	return os.Rename(tempFile.Name(), target)
+}
+
+// copySparse copies the reader to the file, seeking over the zero blocks instead of writing them.
+// Returns the number of bytes copied, the caller truncates the file to this size
+// so the trailing zero blocks are accounted for.
+func copySparse(target *os.File, reader io.Reader) (int64, error) {
+	buffer := make([]byte, utils.RootFSCopyBufferSize)
+	zeroBlock := make([]byte, sparseBlockSize)
+	var offset int64
+	for {
+		read, readErr := io.ReadFull(reader, buffer)
+		for start := 0; start < read; start = start + sparseBlockSize {
+			end := start + sparseBlockSize
+			if end > read {
+				end = read
+			}
+			block := buffer[start:end]
+			if !bytes.Equal(block, zeroBlock[:len(block)]) {
+				if _, err := target.WriteAt(block, offset); err != nil {
+					return offset, err
+				}
+			}
+			offset = offset + int64(len(block))
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return offset, nil
+		}
+		if readErr != nil {
+			return offset, readErr
+		}
+	}
+}
+
+// compressionSourceStamp identifies the version of the compressed file.
+func compressionSourceStamp(path string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", stat.Size(), stat.ModTime().UnixNano()), nil
+}
+
+// decompressedRootfsPath returns the path of the decompressed copy of the compressed rootfs.
+func (p *provider) decompressedRootfsPath(org, image, version, arch string) string {
+	return filepath.Join(p.config.DecompressionCacheRoot, strings.ReplaceAll(org, "/", "_"), image, version, naming.RootfsFileName)
+}
+
+// resolveCompressedRootfs decompresses the compressed rootfs to the decompression cache,
+// unless the cached copy was created from the same compressed file.
+func (p *provider) resolveCompressedRootfs(org, image, version, arch string) (string, error) {
+	compressedPath := filepath.Join(p.tagDirectory(org, image, version, arch), compressedRootfsFileName)
+	if p.config.DecompressionCacheRoot == "" {
+		return "", fmt.Errorf("directory storage provider: decompression cache root is required for compressed rootfs")
+	}
+	stamp, err := compressionSourceStamp(compressedPath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed looking up compressed rootfs")
+	}
+	decompressedPath := p.decompressedRootfsPath(org, image, version, arch)
+	if _, statErr := utils.CheckIfExistsAndIsRegular(decompressedPath); statErr == nil {
+		if cachedStamp, readErr := ioutil.ReadFile(decompressedPath + sourceFileSuffix); readErr == nil && string(cachedStamp) == stamp {
+			p.logger.Debug("using decompressed rootfs", "decompressed-path", decompressedPath)
+			return decompressedPath, nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(decompressedPath), 0755); err != nil {
+		return "", errors.Wrap(err, "failed creating decompression cache directory")
+	}
+	p.logger.Debug("decompressing rootfs", "source", compressedPath, "decompressed-path", decompressedPath)
+	// remove the stale stamp before replacing the file, a failure in between must not leave a matching stamp behind:
+	os.Remove(decompressedPath + sourceFileSuffix)
+	if err := decompressFile(compressedPath, decompressedPath); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(decompressedPath+sourceFileSuffix, []byte(stamp), 0644); err != nil {
+		p.logger.Warn("error writing decompressed rootfs stamp", "reason", err, "decompressed-path", decompressedPath)
+	}
+	return decompressedPath, nil
+}
+
+// removeDecompressedRootfs removes the decompressed copy of the rootfs, if any.
+func (p *provider) removeDecompressedRootfs(org, image, version, arch string) {
+	if p.config.DecompressionCacheRoot == "" {
+		return
+	}
+	decompressedPath := p.decompressedRootfsPath(org, image, version, arch)
+	for _, path := range []string{decompressedPath, decompressedPath + sourceFileSuffix} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			p.logger.Warn("error removing decompressed rootfs", "reason", err, "decompressed-path", path)
+		}
+	}
+}
+
+// withCompression returns the metadata with the compression of the stored rootfs recorded.
+func withCompression(metadata interface{}, compression string) (interface{}, error) {
+	metadataJSONBytes, err := json.Marshal(&metadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed serializing rootfs metadata")
+	}
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(metadataJSONBytes, &result); err != nil {
+		return nil, errors.Wrap(err, "failed recording compression in rootfs metadata")
+	}
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+	result[compressionMetadataKey] = compression
+	return result, nil
+}
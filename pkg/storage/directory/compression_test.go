@@ -0,0 +1,170 @@
+package directory
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressedStoreAndFetch(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := New(hclog.Default())
+	assert.Nil(t, impl.Configure(map[string]interface{}{
+		"compression":              CompressionZstd,
+		"compression-level":        "3",
+		"decompression-cache-root": filepath.Join(tempDir, "cache"),
+		"rootfs-storage-root":      filepath.Join(tempDir, "rootfs"),
+	}))
+
+	content := testRootfsContent(1024 * 1024)
+	localPath := filepath.Join(tempDir, "build")
+	assert.Nil(t, ioutil.WriteFile(localPath, content, 0644))
+	result, err := impl.StoreRootfsFile(&storage.RootfsStore{
+		LocalPath: localPath,
+		Metadata:  map[string]interface{}{"version": "1.0"},
+		Org:       "tests",
+		Image:     "image",
+		Version:   "1.0",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, compressedRootfsFileName, filepath.Base(result.RootfsLocation))
+	_, err = os.Stat(filepath.Join(tempDir, "rootfs", "tests", "image", "1.0", compressedRootfsFileName))
+	assert.Nil(t, err)
+
+	fetched, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"version": "1.0", compressionMetadataKey: CompressionZstd,
+		storage.RootfsSHA256MetadataKey: testSHA256(content)}, fetched.Metadata())
+	assert.Nil(t, storage.VerifyRootfs(fetched), "expected the decompressed rootfs to match the recorded checksum")
+	fetchedContent, err := ioutil.ReadFile(fetched.HostPath())
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(content, fetchedContent), "expected the decompressed rootfs to match the stored rootfs")
+
+	// tagging keeps the rootfs compressed:
+	_, err = impl.TagRootfs(&storage.RootfsTag{
+		Source:   &storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"},
+		Org:      "tests",
+		Image:    "image",
+		Version:  "latest",
+		Metadata: map[string]interface{}{"version": "latest"},
+	})
+	assert.Nil(t, err)
+	tagged, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest"})
+	assert.Nil(t, err)
+	taggedContent, err := ioutil.ReadFile(tagged.HostPath())
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(content, taggedContent), "expected the decompressed tagged rootfs to match the stored rootfs")
+
+	// deleting removes the decompressed copy:
+	_, err = impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	assert.Nil(t, err)
+	_, err = os.Stat(fetched.HostPath())
+	assert.True(t, os.IsNotExist(err), "expected the decompressed rootfs to be removed")
+	list, err := impl.(storage.ListingProvider).ListRootfs()
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.RootfsLookup{{Org: "tests", Image: "image", Version: "latest"}}, list)
+}
+
+func TestCompressionConfiguration(t *testing.T) {
+	assert.NotNil(t, New(hclog.Default()).Configure(map[string]interface{}{
+		"compression": "gzip",
+	}))
+	assert.NotNil(t, New(hclog.Default()).Configure(map[string]interface{}{
+		"compression":       CompressionZstd,
+		"compression-level": "9",
+	}))
+	assert.NotNil(t, New(hclog.Default()).Configure(map[string]interface{}{
+		"compression": CompressionZstd,
+		"dedup":       "true",
+	}))
+	assert.Nil(t, New(hclog.Default()).Configure(map[string]interface{}{
+		"compression": CompressionNone,
+	}))
+}
+
+// BenchmarkStoreFetch compares storing and fetching an uncompressed and a compressed rootfs.
+// Reports the on disk size of the stored rootfs.
+func BenchmarkStoreFetch(b *testing.B) {
+	content := testRootfsContent(32 * 1024 * 1024)
+	for _, compression := range []string{CompressionNone, CompressionZstd} {
+		b.Run(compression, func(b *testing.B) {
+			tempDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				b.Fatal("expected temp directory to be created, got error", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			impl := New(hclog.NewNullLogger())
+			if err := impl.Configure(map[string]interface{}{
+				"compression":              compression,
+				"decompression-cache-root": filepath.Join(tempDir, "cache"),
+				"rootfs-storage-root":      filepath.Join(tempDir, "rootfs"),
+			}); err != nil {
+				b.Fatal("expected provider to be configured, got error", err)
+			}
+
+			var storedBytes int64
+			b.SetBytes(int64(len(content)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				localPath := filepath.Join(tempDir, "build")
+				if err := ioutil.WriteFile(localPath, content, 0644); err != nil {
+					b.Fatal("expected rootfs to be written, got error", err)
+				}
+				version := fmt.Sprintf("%d", i)
+				b.StartTimer()
+				result, err := impl.StoreRootfsFile(&storage.RootfsStore{
+					LocalPath: localPath,
+					Org:       "tests",
+					Image:     "image",
+					Version:   version,
+				})
+				if err != nil {
+					b.Fatal("expected rootfs to be stored, got error", err)
+				}
+				if _, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: version}); err != nil {
+					b.Fatal("expected rootfs to be fetched, got error", err)
+				}
+				b.StopTimer()
+				stat, err := os.Stat(result.RootfsLocation)
+				if err != nil {
+					b.Fatal("expected stored rootfs to exist, got error", err)
+				}
+				storedBytes = stat.Sys().(*syscall.Stat_t).Blocks * 512
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(storedBytes), "disk-bytes")
+		})
+	}
+}
+
+// testRootfsContent returns the content resembling a file system image:
+// mostly empty blocks with some random and some repetitive data.
+func testRootfsContent(size int) []byte {
+	content := make([]byte, size)
+	random := rand.New(rand.NewSource(1))
+	for offset := 0; offset < size; offset = offset + 64*1024 {
+		switch (offset / (64 * 1024)) % 4 {
+		case 0:
+			random.Read(content[offset : offset+16*1024])
+		case 1:
+			copy(content[offset:], bytes.Repeat([]byte("firebuild rootfs "), 1024))
+		}
+	}
+	return content
+}
@@ -7,8 +7,12 @@ import (
 
 // FlagProvider is the Keto provider.
 type flags struct {
-	KernelStorageRoot string
-	RootfsStorageRoot string
+	Compression            string
+	CompressionLevel       int
+	DecompressionCacheRoot string
+	Dedup                  bool
+	KernelStorageRoot      string
+	RootfsStorageRoot      string
 }
 
 // New returns an initialized instance of the flag provider.
@@ -18,6 +22,10 @@ func New() storage.FlagProvider {
 
 func (fp *flags) GetFlags() *pflag.FlagSet {
 	set := &pflag.FlagSet{}
+	set.StringVar(&fp.Compression, "storage-provider.directory.compression", "none", "Compression of the stored rootfs files: none or zstd")
+	set.IntVar(&fp.CompressionLevel, "storage-provider.directory.compression-level", 2, "zstd compression level, 1 (fastest) to 4 (best compression)")
+	set.StringVar(&fp.DecompressionCacheRoot, "storage-provider.directory.decompression-cache-root", "/var/lib/firebuild/rootfs-cache", "Full path to the root directory where the compressed rootfs files are decompressed to")
+	set.BoolVar(&fp.Dedup, "storage-provider.directory.dedup", false, "If set, rootfs files are stored once per content under the blobs directory of the rootfs storage")
 	set.StringVar(&fp.KernelStorageRoot, "storage-provider.directory.kernel-storage-root", "", "Full path to the root directory of the kernel storage")
 	set.StringVar(&fp.RootfsStorageRoot, "storage-provider.directory.rootfs-storage-root", "", "Full path to the root directory of the rootfs storage")
 	return set
@@ -25,7 +33,11 @@ func (fp *flags) GetFlags() *pflag.FlagSet {
 
 func (fp *flags) GetInitializedConfiguration() map[string]interface{} {
 	return map[string]interface{}{
-		"kernel-storage-root": fp.KernelStorageRoot,
-		"rootfs-storage-root": fp.RootfsStorageRoot,
+		"compression":              fp.Compression,
+		"compression-level":        fp.CompressionLevel,
+		"decompression-cache-root": fp.DecompressionCacheRoot,
+		"dedup":                    fp.Dedup,
+		"kernel-storage-root":      fp.KernelStorageRoot,
+		"rootfs-storage-root":      fp.RootfsStorageRoot,
 	}
 }
@@ -19,10 +19,17 @@ import (
 const providerName = "directory"
 
 type providerConfig struct {
-	KernelStorageRoot string `mapstructure:"kernel-storage-root"`
-	RootfsStorageRoot string `mapstructure:"rootfs-storage-root"`
+	Compression            string `mapstructure:"compression"`
+	CompressionLevel       int    `mapstructure:"compression-level"`
+	DecompressionCacheRoot string `mapstructure:"decompression-cache-root"`
+	Dedup                  bool   `mapstructure:"dedup"`
+	KernelStorageRoot      string `mapstructure:"kernel-storage-root"`
+	RootfsStorageRoot      string `mapstructure:"rootfs-storage-root"`
 }
 
+// defaultCompressionLevel is the compression level used when the level is not configured.
+const defaultCompressionLevel = 2
+
 type provider struct {
 	config *providerConfig
 	logger hclog.Logger
@@ -38,10 +45,20 @@ func New(logger hclog.Logger) storage.Provider {
 func (p *provider) Configure(mapConfig map[string]interface{}) error {
 	p.logger.Debug("configuring storage provider")
 	pConfig := &providerConfig{}
-	if err := mapstructure.Decode(&mapConfig, pConfig); err != nil {
+	// weak decoding, profiles store the dedup setting as a string:
+	if err := mapstructure.WeakDecode(&mapConfig, pConfig); err != nil {
 		p.logger.Error("error when decoding configuration", "reason", err)
 		return errors.Wrap(err, "failed decoding provider configuration")
 	}
+	if pConfig.CompressionLevel == 0 {
+		pConfig.CompressionLevel = defaultCompressionLevel
+	}
+	if err := validateCompression(pConfig.Compression, pConfig.CompressionLevel); err != nil {
+		return err
+	}
+	if pConfig.Compression == CompressionZstd && pConfig.Dedup {
+		return fmt.Errorf("directory storage provider: compression and dedup can't be combined")
+	}
 	p.config = pConfig
 	p.logger.Debug("storage provider configured")
 	return nil
@@ -68,36 +85,33 @@ func (p *provider) FetchKernel(q *storage.KernelLookup) (storage.KernelResult, e
 func (p *provider) FetchRootfs(q *storage.RootfsLookup) (storage.RootfsResult, error) {
 	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
 	p.logger.Debug("looking up rootfs", "rootfs-id", rootfsID)
-	rootfsPath := filepath.Join(p.config.RootfsStorageRoot,
-		strings.ReplaceAll(q.Org, "/", "_"), q.Image, q.Version, naming.RootfsFileName)
+	tagDirectory := p.tagDirectory(q.Org, q.Image, q.Version, q.Arch)
+	rootfsPath := filepath.Join(tagDirectory, naming.RootfsFileName)
+	if _, err := utils.CheckIfExistsAndIsRegular(filepath.Join(tagDirectory, compressedRootfsFileName)); err == nil {
+		resolvedPath, decompressErr := p.resolveCompressedRootfs(q.Org, q.Image, q.Version, q.Arch)
+		if decompressErr != nil {
+			p.logger.Error("error decompressing rootfs", "reason", decompressErr, "rootfs-id", rootfsID)
+			return nil, errors.Wrap(decompressErr, "failed decompressing rootfs")
+		}
+		rootfsPath = resolvedPath
+	} else if _, err := utils.CheckIfExistsAndIsRegular(filepath.Join(tagDirectory, naming.RootfsBlobPointerFileName)); err == nil {
+		resolvedPath, blobErr := p.resolveBlob(filepath.Dir(rootfsPath))
+		if blobErr != nil {
+			p.logger.Error("error resolving rootfs blob", "reason", blobErr, "rootfs-id", rootfsID)
+			return nil, errors.Wrap(blobErr, "failed resolving rootfs blob")
+		}
+		rootfsPath = resolvedPath
+	}
 	if _, err := utils.CheckIfExistsAndIsRegular(rootfsPath); err != nil {
 		p.logger.Error("error looking up rootfs", "reason", err, "rootfs-id", rootfsID)
-		return nil, errors.Wrap(err, "failed resolving rootfs file")
-	}
-	metadata := map[string]interface{}{}
-	metadataFilePath := filepath.Join(filepath.Dir(rootfsPath), naming.MetadataFileName)
-	hasMetadata := true
-	if _, err := utils.CheckIfExistsAndIsRegular(metadataFilePath); err != nil {
 		if os.IsNotExist(err) {
-			hasMetadata = false
-		} else {
-			p.logger.Error("error looking up rootfs metadata", "reason", err, "rootfs-id", rootfsID, "===============", os.IsNotExist(err))
-			return nil, err
+			return nil, errors.Wrapf(storage.ErrRootfsNotFound, "rootfs %s", rootfsID)
 		}
+		return nil, errors.Wrap(err, "failed resolving rootfs file")
 	}
-	if hasMetadata {
-		metadataFile, err := os.OpenFile(metadataFilePath, os.O_RDONLY, 0664)
-		if err != nil {
-			p.logger.Error("error opening rootfs metadata", "reason", err, "rootfs-id", rootfsID, "metadata-path", metadataFile)
-			return nil, errors.Wrap(err, "failed reading rootfs metadata")
-		}
-		defer metadataFile.Close()
-		if jsonErr := json.NewDecoder(metadataFile).Decode(&metadata); jsonErr != nil {
-			p.logger.Error("error reading rootfs metadata as JSON", "reason", err, "rootfs-id", rootfsID, "metadata-path", metadataFile)
-			return nil, errors.Wrap(err, "failed decoding rootfs metadata")
-		}
-	} else {
-		p.logger.Debug("rootfs without metadata", "rootfs-id", rootfsID)
+	metadata, err := p.readMetadata(tagDirectory, rootfsID)
+	if err != nil {
+		return nil, err
 	}
 	p.logger.Debug("rootfs located", "rootfs-id", rootfsID)
 	return &rootfsResult{
@@ -114,36 +128,402 @@ func (p *provider) StoreRootfsFile(input *storage.RootfsStore) (*storage.RootfsS
 
 	p.logger.Debug("storing rootfs", "rootfs-id", rootfsID)
 
-	targetFilePath := filepath.Join(p.config.RootfsStorageRoot,
-		strings.ReplaceAll(input.Org, "/", "_"), input.Image, input.Version, naming.RootfsFileName)
+	targetFilePath := filepath.Join(p.tagDirectory(input.Org, input.Image, input.Version, input.Arch), naming.RootfsFileName)
 	p.logger.Debug("ensuring rootfs parent directory exists", "rootfs-id", rootfsID, "directory", filepath.Dir(targetFilePath))
 	if err := os.MkdirAll(filepath.Dir(targetFilePath), 0755); err != nil {
 		p.logger.Error("error creating rootfs parent directory", "reason", err, "rootfs-id", rootfsID)
 		return nil, errors.Wrap(err, "failed creating target storage directory")
 	}
-	p.logger.Debug("moving rootfs", "rootfs-id", rootfsID,
-		"source", input.LocalPath,
-		"target", targetFilePath)
-	if moveErr := utils.MoveFile(input.LocalPath, targetFilePath); moveErr != nil {
-		p.logger.Error("error moving rootfs", "reason", moveErr, "rootfs-id", rootfsID)
-		return nil, errors.Wrap(moveErr, "failed moving source to destination")
+	if err := unlinkRootfs(filepath.Dir(targetFilePath)); err != nil {
+		p.logger.Error("error removing previous rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed removing previous rootfs")
+	}
+	// the checksum is of the rootfs file, before the rootfs is compressed:
+	digest, err := storage.FileSHA256(input.LocalPath)
+	if err != nil {
+		p.logger.Error("error calculating rootfs SHA-256", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed calculating rootfs SHA-256")
+	}
+	metadata, err := storage.WithRootfsSHA256(input.Metadata, digest)
+	if err != nil {
+		p.logger.Error("error recording rootfs SHA-256", "reason", err, "rootfs-id", rootfsID)
+		return nil, err
+	}
+	if p.config.Compression == CompressionZstd {
+		targetFilePath = filepath.Join(filepath.Dir(targetFilePath), compressedRootfsFileName)
+		p.logger.Debug("compressing rootfs", "rootfs-id", rootfsID,
+			"source", input.LocalPath,
+			"target", targetFilePath,
+			"level", p.config.CompressionLevel)
+		if compressErr := compressFile(input.LocalPath, targetFilePath, p.config.CompressionLevel); compressErr != nil {
+			p.logger.Error("error compressing rootfs", "reason", compressErr, "rootfs-id", rootfsID)
+			return nil, errors.Wrap(compressErr, "failed compressing rootfs")
+		}
+		// the source is moved in the other modes:
+		if removeErr := os.Remove(input.LocalPath); removeErr != nil {
+			p.logger.Warn("error removing compressed source rootfs", "reason", removeErr, "rootfs-id", rootfsID)
+		}
+		p.removeDecompressedRootfs(input.Org, input.Image, input.Version, input.Arch)
+		var compressionErr error
+		if metadata, compressionErr = withCompression(metadata, CompressionZstd); compressionErr != nil {
+			p.logger.Error("error recording rootfs compression", "reason", compressionErr, "rootfs-id", rootfsID)
+			return nil, compressionErr
+		}
+	} else if p.config.Dedup {
+		p.logger.Debug("storing rootfs blob", "rootfs-id", rootfsID,
+			"source", input.LocalPath)
+		if blobErr := p.storeBlob(input.LocalPath, digest, filepath.Dir(targetFilePath)); blobErr != nil {
+			p.logger.Error("error storing rootfs blob", "reason", blobErr, "rootfs-id", rootfsID)
+			return nil, errors.Wrap(blobErr, "failed storing rootfs blob")
+		}
+		p.logger.Debug("rootfs blob stored", "rootfs-id", rootfsID, "sha256", digest)
+	} else {
+		p.logger.Debug("moving rootfs", "rootfs-id", rootfsID,
+			"source", input.LocalPath,
+			"target", targetFilePath)
+		if moveErr := utils.MoveFile(input.LocalPath, targetFilePath); moveErr != nil {
+			p.logger.Error("error moving rootfs", "reason", moveErr, "rootfs-id", rootfsID)
+			return nil, errors.Wrap(moveErr, "failed moving source to destination")
+		}
 	}
 	result.RootfsLocation = targetFilePath
 
+	if err := storeAttachments(filepath.Dir(targetFilePath), input.Attachments); err != nil {
+		p.logger.Error("error storing rootfs attachments", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed storing rootfs attachments")
+	}
+
+	metadataFileName, err := p.writeMetadata(filepath.Dir(targetFilePath), metadata, rootfsID)
+	if err != nil {
+		// the rootfs is stored, the metadata is not essential:
+		return result, nil
+	}
+	result.MetadataLocation = metadataFileName
+
+	p.logger.Debug("rootfs stored", "rootfs-id", rootfsID)
+
+	return result, nil
+}
+
+// FetchRootfsMetadata fetches the metadata of a root file system by ID.
+func (p *provider) FetchRootfsMetadata(q *storage.RootfsLookup) (interface{}, error) {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	p.logger.Debug("looking up rootfs metadata", "rootfs-id", rootfsID)
+	tagDirectory := p.tagDirectory(q.Org, q.Image, q.Version, q.Arch)
+	if !isRootfsStored(tagDirectory) {
+		p.logger.Error("rootfs not found", "rootfs-id", rootfsID)
+		return nil, errors.Wrapf(storage.ErrRootfsNotFound, "rootfs %s", rootfsID)
+	}
+	return p.readMetadata(tagDirectory, rootfsID)
+}
+
+// TagRootfs creates a new tag entry pointing at the root file system of an existing tag.
+// The rootfs and the attachments are linked, deduplicated rootfs files point at the same blob.
+func (p *provider) TagRootfs(input *storage.RootfsTag) (*storage.RootfsStoreResult, error) {
+	sourceID := fmt.Sprintf("%s/%s:%s", input.Source.Org, input.Source.Image, input.Source.Version)
+	rootfsID := fmt.Sprintf("%s/%s:%s", input.Org, input.Image, input.Version)
+	result := &storage.RootfsStoreResult{
+		Provider: providerName,
+	}
+
+	p.logger.Debug("tagging rootfs", "rootfs-id", rootfsID, "source-rootfs-id", sourceID)
+
+	sourceDirectory := p.tagDirectory(input.Source.Org, input.Source.Image, input.Source.Version, input.Source.Arch)
+	targetDirectory := p.tagDirectory(input.Org, input.Image, input.Version, input.Arch)
+	if sourceDirectory == targetDirectory {
+		return nil, fmt.Errorf("source and target rootfs are the same")
+	}
+
+	sourceRootfsPath := filepath.Join(sourceDirectory, naming.RootfsFileName)
+	sourceCompressed, err := utils.PathExists(filepath.Join(sourceDirectory, compressedRootfsFileName))
+	if err != nil {
+		return nil, err
+	}
+	if sourceCompressed {
+		sourceRootfsPath = filepath.Join(sourceDirectory, compressedRootfsFileName)
+	}
+	sourcePointerPath := filepath.Join(sourceDirectory, naming.RootfsBlobPointerFileName)
+	sourceDeduplicated, err := utils.PathExists(sourcePointerPath)
+	if err != nil {
+		return nil, err
+	}
+	if sourceDeduplicated {
+		if sourceRootfsPath, err = p.resolveBlob(sourceDirectory); err != nil {
+			p.logger.Error("error resolving source rootfs blob", "reason", err, "source-rootfs-id", sourceID)
+			return nil, errors.Wrap(err, "failed resolving source rootfs blob")
+		}
+	}
+	if _, err := utils.CheckIfExistsAndIsRegular(sourceRootfsPath); err != nil {
+		p.logger.Error("error looking up source rootfs", "reason", err, "source-rootfs-id", sourceID)
+		if os.IsNotExist(err) {
+			return nil, errors.Wrapf(storage.ErrRootfsNotFound, "rootfs %s", sourceID)
+		}
+		return nil, errors.Wrap(err, "failed resolving source rootfs file")
+	}
+
+	targetExists := false
+	for _, name := range []string{naming.RootfsFileName, compressedRootfsFileName} {
+		exists, err := utils.PathExists(filepath.Join(targetDirectory, name))
+		if err != nil {
+			return nil, err
+		}
+		targetExists = targetExists || exists
+	}
+	targetRootfsPath := filepath.Join(targetDirectory, naming.RootfsFileName)
+	metadata := input.Metadata
+	if sourceCompressed {
+		targetRootfsPath = filepath.Join(targetDirectory, compressedRootfsFileName)
+		if metadata, err = withCompression(metadata, CompressionZstd); err != nil {
+			return nil, err
+		}
+	}
+	if targetExists && !input.Overwrite {
+		p.logger.Error("target rootfs exists", "rootfs-id", rootfsID)
+		return nil, errors.Wrapf(storage.ErrRootfsExists, "rootfs %s", rootfsID)
+	}
+
+	if err := os.MkdirAll(targetDirectory, 0755); err != nil {
+		p.logger.Error("error creating rootfs parent directory", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed creating target storage directory")
+	}
+	if err := unlinkRootfs(targetDirectory); err != nil {
+		p.logger.Error("error removing previous rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed removing previous rootfs")
+	}
+	if sourceDeduplicated {
+		pointerBytes, err := ioutil.ReadFile(sourcePointerPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed reading source rootfs blob pointer")
+		}
+		if err := writeFileAtomic(filepath.Join(targetDirectory, naming.RootfsBlobPointerFileName), pointerBytes); err != nil {
+			return nil, errors.Wrap(err, "failed writing rootfs blob pointer")
+		}
+	}
+	if err := utils.LinkOrCloneFile(sourceRootfsPath, targetRootfsPath); err != nil {
+		p.logger.Error("error linking rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed linking rootfs")
+	}
+	p.removeDecompressedRootfs(input.Org, input.Image, input.Version, input.Arch)
+	result.RootfsLocation = targetRootfsPath
+
+	if err := linkAttachments(sourceDirectory, targetDirectory); err != nil {
+		p.logger.Error("error linking rootfs attachments", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed linking rootfs attachments")
+	}
+
+	metadataFileName, err := p.writeMetadata(targetDirectory, metadata, rootfsID)
+	if err != nil {
+		return nil, err
+	}
+	result.MetadataLocation = metadataFileName
+
+	p.logger.Debug("rootfs tagged", "rootfs-id", rootfsID, "source-rootfs-id", sourceID)
+
+	return result, nil
+}
+
+// DeleteRootfs deletes the root file system, the attachments and the metadata of a tag.
+// A deduplicated rootfs blob is deleted when no other tag points at it.
+func (p *provider) DeleteRootfs(q *storage.RootfsLookup) (*storage.RootfsDeleteResult, error) {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	result := &storage.RootfsDeleteResult{
+		Provider: providerName,
+	}
+
+	p.logger.Debug("deleting rootfs", "rootfs-id", rootfsID)
+
+	tagDirectory := p.tagDirectory(q.Org, q.Image, q.Version, q.Arch)
+	rootfsPath := filepath.Join(tagDirectory, naming.RootfsFileName)
+	pointerPath := filepath.Join(tagDirectory, naming.RootfsBlobPointerFileName)
+
+	digest := ""
+	pointerBytes, err := ioutil.ReadFile(pointerPath)
+	if err == nil {
+		digest = strings.TrimSpace(string(pointerBytes))
+		if !reBlobDigest.MatchString(digest) {
+			return nil, fmt.Errorf("invalid rootfs blob pointer %q", digest)
+		}
+	} else if !os.IsNotExist(err) {
+		p.logger.Error("error reading rootfs blob pointer", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed reading rootfs blob pointer")
+	}
+
+	if _, err := os.Stat(filepath.Join(tagDirectory, compressedRootfsFileName)); err == nil {
+		rootfsPath = filepath.Join(tagDirectory, compressedRootfsFileName)
+	}
+	rootfsStat, err := os.Stat(rootfsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			p.logger.Error("error looking up rootfs", "reason", err, "rootfs-id", rootfsID)
+			return nil, errors.Wrap(err, "failed resolving rootfs file")
+		}
+		// a deduplicated rootfs link is restored on fetch, the pointer is enough:
+		if digest == "" {
+			return nil, errors.Wrapf(storage.ErrRootfsNotFound, "rootfs %s", rootfsID)
+		}
+		rootfsStat = nil
+	}
+
+	// a blob is accounted for when released, other links are shared with other tags:
+	if rootfsStat != nil && digest == "" && utils.LinkCount(rootfsStat) <= 1 {
+		result.FreedBytes = result.FreedBytes + rootfsStat.Size()
+	}
+	if err := unlinkRootfs(tagDirectory); err != nil {
+		p.logger.Error("error removing rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed removing rootfs")
+	}
+	p.removeDecompressedRootfs(q.Org, q.Image, q.Version, q.Arch)
+
+	attachmentsFreed, err := removeAttachments(tagDirectory)
+	if err != nil {
+		p.logger.Error("error removing rootfs attachments", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed removing rootfs attachments")
+	}
+	result.FreedBytes = result.FreedBytes + attachmentsFreed
+
+	metadataPath := filepath.Join(tagDirectory, naming.MetadataFileName)
+	if metadataStat, err := os.Stat(metadataPath); err == nil {
+		if err := os.Remove(metadataPath); err != nil {
+			p.logger.Error("error removing rootfs metadata", "reason", err, "rootfs-id", rootfsID)
+			return nil, errors.Wrap(err, "failed removing rootfs metadata")
+		}
+		result.FreedBytes = result.FreedBytes + metadataStat.Size()
+	}
+
+	if digest != "" {
+		freed, err := p.releaseBlob(digest)
+		if err != nil {
+			p.logger.Error("error releasing rootfs blob", "reason", err, "rootfs-id", rootfsID, "sha256", digest)
+			return nil, errors.Wrap(err, "failed releasing rootfs blob")
+		}
+		result.FreedBytes = result.FreedBytes + freed
+	}
+
+	// remove the emptied directories, up to the storage root:
+	for directory := tagDirectory; directory != filepath.Clean(p.config.RootfsStorageRoot); directory = filepath.Dir(directory) {
+		if err := os.Remove(directory); err != nil {
+			break
+		}
+	}
+
+	p.logger.Debug("rootfs deleted", "rootfs-id", rootfsID, "freed-bytes", result.FreedBytes)
+
+	return result, nil
+}
+
+func (p *provider) readMetadata(tagDirectory, rootfsID string) (map[string]interface{}, error) {
+	metadata := map[string]interface{}{}
+	metadataFilePath := filepath.Join(tagDirectory, naming.MetadataFileName)
+	if _, err := utils.CheckIfExistsAndIsRegular(metadataFilePath); err != nil {
+		if os.IsNotExist(err) {
+			p.logger.Debug("rootfs without metadata", "rootfs-id", rootfsID)
+			return metadata, nil
+		}
+		p.logger.Error("error looking up rootfs metadata", "reason", err, "rootfs-id", rootfsID)
+		return nil, err
+	}
+	metadataFile, err := os.OpenFile(metadataFilePath, os.O_RDONLY, 0664)
+	if err != nil {
+		p.logger.Error("error opening rootfs metadata", "reason", err, "rootfs-id", rootfsID, "metadata-path", metadataFilePath)
+		return nil, errors.Wrap(err, "failed reading rootfs metadata")
+	}
+	defer metadataFile.Close()
+	if jsonErr := json.NewDecoder(metadataFile).Decode(&metadata); jsonErr != nil {
+		p.logger.Error("error reading rootfs metadata as JSON", "reason", jsonErr, "rootfs-id", rootfsID, "metadata-path", metadataFilePath)
+		return nil, errors.Wrap(jsonErr, "failed decoding rootfs metadata")
+	}
+	return metadata, nil
+}
+
+func (p *provider) writeMetadata(tagDirectory string, metadata interface{}, rootfsID string) (string, error) {
 	p.logger.Debug("writing rootfs metadata", "rootfs-id", rootfsID)
-	metadataFileName := filepath.Join(filepath.Dir(targetFilePath), naming.MetadataFileName)
-	metadataJSONBytes, jsonErr := json.MarshalIndent(&input.Metadata, "", "  ")
+	metadataFileName := filepath.Join(tagDirectory, naming.MetadataFileName)
+	metadataJSONBytes, jsonErr := utils.CanonicalJSONIndent(&metadata, "", "  ")
 	if jsonErr != nil {
 		p.logger.Error("error serialzing rootfs metadata to JSON", "reason", jsonErr, "rootfs-id", rootfsID)
-		return result, nil
+		return "", errors.Wrap(jsonErr, "failed serializing rootfs metadata")
 	}
 	if writeErr := ioutil.WriteFile(metadataFileName, metadataJSONBytes, 0755); writeErr != nil {
 		p.logger.Error("error writing rootfs metadata to file", "reason", writeErr, "rootfs-id", rootfsID)
-		return result, nil
+		return "", errors.Wrap(writeErr, "failed writing rootfs metadata")
 	}
-	result.MetadataLocation = metadataFileName
+	return metadataFileName, nil
+}
 
-	p.logger.Debug("rootfs stored", "rootfs-id", rootfsID)
+// tagDirectory returns the directory of the tag entry, the tag entry of an architecture
+// is stored in the architecture directory of the version directory.
+func (p *provider) tagDirectory(org, image, version, arch string) string {
+	return filepath.Join(p.config.RootfsStorageRoot, strings.ReplaceAll(org, "/", "_"), image, version, arch)
+}
+
+// isRootfsStored returns true when the tag directory contains a rootfs,
+// a version directory may contain only the architecture directories.
+func isRootfsStored(tagDirectory string) bool {
+	// a deduplicated rootfs link is restored on fetch, the pointer is enough:
+	for _, name := range []string{naming.RootfsFileName, compressedRootfsFileName, naming.RootfsBlobPointerFileName} {
+		if _, err := os.Lstat(filepath.Join(tagDirectory, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
 
+// ListRootfs returns the tags of the stored rootfs files.
+func (p *provider) ListRootfs() ([]*storage.RootfsLookup, error) {
+	result := []*storage.RootfsLookup{}
+	orgs, err := readDirNames(p.config.RootfsStorageRoot)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed listing rootfs storage")
+	}
+	for _, org := range orgs {
+		if org == blobsDirectoryName {
+			continue
+		}
+		images, err := readDirNames(filepath.Join(p.config.RootfsStorageRoot, org))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed listing rootfs storage")
+		}
+		for _, image := range images {
+			versions, err := readDirNames(filepath.Join(p.config.RootfsStorageRoot, org, image))
+			if err != nil {
+				return nil, errors.Wrap(err, "failed listing rootfs storage")
+			}
+			for _, version := range versions {
+				versionDirectory := filepath.Join(p.config.RootfsStorageRoot, org, image, version)
+				if isRootfsStored(versionDirectory) {
+					result = append(result, &storage.RootfsLookup{Org: org, Image: image, Version: version})
+				}
+				archs, err := readDirNames(versionDirectory)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed listing rootfs storage")
+				}
+				for _, arch := range archs {
+					if arch == naming.AttachmentsDirectoryName || !isRootfsStored(filepath.Join(versionDirectory, arch)) {
+						continue
+					}
+					result = append(result, &storage.RootfsLookup{Org: org, Image: image, Version: version, Arch: arch})
+				}
+			}
+		}
+	}
 	return result, nil
 }
+
+// readDirNames returns the sorted names of the directories in the directory,
+// no names if the directory does not exist.
+func readDirNames(directory string) ([]string, error) {
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,532 @@
+package directory
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagRootfs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, dedup := range []string{"false", "true"} {
+		rootfsRoot := filepath.Join(tempDir, "rootfs-dedup-"+dedup)
+		impl := New(hclog.Default())
+		assert.Nil(t, impl.Configure(map[string]interface{}{
+			"dedup":               dedup,
+			"rootfs-storage-root": rootfsRoot,
+		}))
+
+		localPath := filepath.Join(tempDir, "build")
+		assert.Nil(t, ioutil.WriteFile(localPath, []byte("rootfs"), 0644))
+		_, err := impl.StoreRootfsFile(&storage.RootfsStore{
+			LocalPath: localPath,
+			Metadata:  map[string]interface{}{"Tag": "tests/image:1.0"},
+			Org:       "tests",
+			Image:     "image",
+			Version:   "1.0",
+		})
+		assert.Nil(t, err)
+
+		tagInput := &storage.RootfsTag{
+			Source:   &storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"},
+			Metadata: map[string]interface{}{"Tag": "tests/image:latest"},
+			Org:      "tests",
+			Image:    "image",
+			Version:  "latest",
+		}
+		_, err = impl.TagRootfs(tagInput)
+		assert.Nil(t, err)
+
+		metadata, err := impl.FetchRootfsMetadata(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest"})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"Tag": "tests/image:latest"}, metadata)
+
+		source, err := impl.FetchRootfs(tagInput.Source)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"Tag": "tests/image:1.0", storage.RootfsSHA256MetadataKey: testSHA256([]byte("rootfs"))}, source.Metadata())
+		target, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest"})
+		assert.Nil(t, err)
+		sourceStat, err := os.Stat(source.HostPath())
+		assert.Nil(t, err)
+		targetStat, err := os.Stat(target.HostPath())
+		assert.Nil(t, err)
+		assert.True(t, os.SameFile(sourceStat, targetStat), "expected the tag to link the source rootfs")
+
+		// the existing target is not overwritten without the overwrite flag:
+		_, err = impl.TagRootfs(tagInput)
+		assert.True(t, errors.Is(err, storage.ErrRootfsExists))
+		tagInput.Overwrite = true
+		_, err = impl.TagRootfs(tagInput)
+		assert.Nil(t, err)
+
+		_, err = impl.TagRootfs(&storage.RootfsTag{
+			Source:  &storage.RootfsLookup{Org: "tests", Image: "image", Version: "2.0"},
+			Org:     "tests",
+			Image:   "image",
+			Version: "other",
+		})
+		assert.NotNil(t, err, "expected tagging a missing rootfs to fail")
+	}
+}
+
+func TestListRootfs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := New(hclog.Default())
+	assert.Nil(t, impl.Configure(map[string]interface{}{
+		"dedup":               "true",
+		"rootfs-storage-root": filepath.Join(tempDir, "rootfs"),
+	}))
+
+	listResult, err := impl.(storage.ListingProvider).ListRootfs()
+	assert.Nil(t, err)
+	assert.Empty(t, listResult)
+
+	localPath := filepath.Join(tempDir, "build")
+	for _, version := range []string{"2.0", "1.0"} {
+		assert.Nil(t, ioutil.WriteFile(localPath, []byte("rootfs"), 0644))
+		_, err := impl.StoreRootfsFile(&storage.RootfsStore{
+			LocalPath: localPath,
+			Org:       "tests",
+			Image:     "image",
+			Version:   version,
+		})
+		assert.Nil(t, err)
+	}
+	// a directory without the rootfs is not listed:
+	assert.Nil(t, os.MkdirAll(filepath.Join(tempDir, "rootfs", "tests", "image", "3.0"), 0755))
+
+	listResult, err = impl.(storage.ListingProvider).ListRootfs()
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.RootfsLookup{
+		{Org: "tests", Image: "image", Version: "1.0"},
+		{Org: "tests", Image: "image", Version: "2.0"},
+	}, listResult)
+}
+
+func TestDeleteRootfs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rootfsRoot := filepath.Join(tempDir, "rootfs")
+	for _, dedup := range []string{"false", "true"} {
+		impl := New(hclog.Default())
+		assert.Nil(t, impl.Configure(map[string]interface{}{
+			"dedup":               dedup,
+			"rootfs-storage-root": rootfsRoot,
+		}))
+
+		localPath := filepath.Join(tempDir, "build")
+		assert.Nil(t, ioutil.WriteFile(localPath, []byte("rootfs"), 0644))
+		_, err := impl.StoreRootfsFile(&storage.RootfsStore{
+			LocalPath: localPath,
+			Org:       "tests",
+			Image:     "image",
+			Version:   "1.0",
+		})
+		assert.Nil(t, err)
+		_, err = impl.TagRootfs(&storage.RootfsTag{
+			Source:  &storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"},
+			Org:     "tests",
+			Image:   "image",
+			Version: "latest",
+		})
+		assert.Nil(t, err)
+
+		// the rootfs is still used by the other tag:
+		metadataStat, err := os.Stat(filepath.Join(rootfsRoot, "tests", "image", "1.0", naming.MetadataFileName))
+		assert.Nil(t, err)
+		result, err := impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+		assert.Nil(t, err)
+		assert.Equal(t, metadataStat.Size(), result.FreedBytes, "dedup: "+dedup)
+		_, err = impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest"})
+		assert.Nil(t, err)
+
+		result, err = impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest"})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len("rootfs")+len("null")), result.FreedBytes, "dedup: "+dedup)
+
+		_, err = impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest"})
+		assert.True(t, errors.Is(err, storage.ErrRootfsNotFound))
+
+		// only the blobs directory may remain:
+		entries, err := ioutil.ReadDir(rootfsRoot)
+		assert.Nil(t, err)
+		for _, entry := range entries {
+			assert.Equal(t, blobsDirectoryName, entry.Name())
+			blobs, err := ioutil.ReadDir(filepath.Join(rootfsRoot, entry.Name()))
+			assert.Nil(t, err)
+			assert.Empty(t, blobs)
+		}
+	}
+}
+
+func TestArchitectureLookup(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := storage.WithArchitecture(New(hclog.Default()))
+	assert.Nil(t, impl.Configure(map[string]interface{}{
+		"rootfs-storage-root": filepath.Join(tempDir, "rootfs"),
+	}))
+	_, isListing := impl.(storage.ListingProvider)
+	assert.True(t, isListing, "expected the listing capability to be retained")
+	_, isDeduplicating := impl.(storage.DeduplicatingProvider)
+	assert.True(t, isDeduplicating, "expected the deduplicating capability to be retained")
+
+	// the stored rootfs file is moved:
+	localPath := filepath.Join(tempDir, "build")
+	for _, arch := range []string{"amd64", "arm64"} {
+		assert.Nil(t, ioutil.WriteFile(localPath, []byte("rootfs"), 0644))
+		_, err := impl.StoreRootfsFile(&storage.RootfsStore{
+			LocalPath: localPath,
+			Metadata:  map[string]interface{}{"Image": map[string]interface{}{"Arch": arch}},
+			Org:       "tests",
+			Image:     "image",
+			Version:   "1.0",
+			Arch:      arch,
+		})
+		assert.Nil(t, err)
+	}
+	// stored before the architecture was recorded, with and without the architecture in the metadata:
+	for _, stored := range []struct {
+		version  string
+		metadata map[string]interface{}
+	}{
+		{"legacy", map[string]interface{}{"Image": map[string]interface{}{"Arch": "amd64"}}},
+		{"unknown", map[string]interface{}{}},
+	} {
+		assert.Nil(t, ioutil.WriteFile(localPath, []byte("rootfs"), 0644))
+		_, err = impl.StoreRootfsFile(&storage.RootfsStore{
+			LocalPath: localPath,
+			Metadata:  stored.metadata,
+			Org:       "tests",
+			Image:     "image",
+			Version:   stored.version,
+		})
+		assert.Nil(t, err)
+	}
+
+	listing, err := impl.(storage.ListingProvider).ListRootfs()
+	assert.Nil(t, err)
+	tags := []string{}
+	for _, item := range listing {
+		tags = append(tags, fmt.Sprintf("%s/%s", item.Version, item.Arch))
+	}
+	assert.Equal(t, []string{"1.0/amd64", "1.0/arm64", "legacy/", "unknown/"}, tags)
+	_, err = os.Stat(filepath.Join(tempDir, "rootfs", "tests", "image", "1.0", "arm64", naming.RootfsFileName))
+	assert.Nil(t, err, "expected the rootfs of the architecture in the architecture directory")
+
+	result, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0", Arch: "arm64"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"Image": map[string]interface{}{"Arch": "arm64"},
+		storage.RootfsSHA256MetadataKey: testSHA256([]byte("rootfs"))}, result.Metadata())
+
+	// the version directory holds only the architecture directories:
+	_, err = impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	assert.True(t, errors.Is(err, storage.ErrRootfsNotFound))
+
+	// the version without the architecture is used unless built for another architecture:
+	_, err = impl.FetchRootfsMetadata(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "legacy", Arch: "amd64"})
+	assert.Nil(t, err)
+	_, err = impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "legacy", Arch: "arm64"})
+	assert.True(t, errors.Is(err, storage.ErrRootfsNotFound))
+
+	// without the architecture in the metadata, only for the host architecture:
+	otherArch := "arm64"
+	if runtime.GOARCH == "arm64" {
+		otherArch = "amd64"
+	}
+	_, err = impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "unknown", Arch: runtime.GOARCH})
+	assert.Nil(t, err)
+	_, err = impl.FetchRootfsMetadata(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "unknown", Arch: otherArch})
+	assert.True(t, errors.Is(err, storage.ErrRootfsNotFound))
+	_, err = impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "unknown", Arch: otherArch})
+	assert.True(t, errors.Is(err, storage.ErrRootfsNotFound))
+
+	_, err = impl.TagRootfs(&storage.RootfsTag{
+		Source:   &storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0", Arch: "arm64"},
+		Metadata: map[string]interface{}{"Image": map[string]interface{}{"Arch": "arm64"}},
+		Org:      "tests",
+		Image:    "image",
+		Version:  "latest",
+		Arch:     "arm64",
+	})
+	assert.Nil(t, err)
+	_, err = impl.FetchRootfsMetadata(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest", Arch: "arm64"})
+	assert.Nil(t, err)
+	_, err = impl.FetchRootfsMetadata(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest", Arch: "amd64"})
+	assert.True(t, errors.Is(err, storage.ErrRootfsNotFound))
+
+	// the architectures of a version are deleted independently:
+	_, err = impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0", Arch: "arm64"})
+	assert.Nil(t, err)
+	_, err = impl.FetchRootfsMetadata(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0", Arch: "amd64"})
+	assert.Nil(t, err)
+
+	_, err = impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "legacy", Arch: "amd64"})
+	assert.Nil(t, err)
+	_, err = impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "legacy", Arch: "amd64"})
+	assert.True(t, errors.Is(err, storage.ErrRootfsNotFound))
+}
+
+func TestRootfsAttachments(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := New(hclog.Default())
+	assert.Nil(t, impl.Configure(map[string]interface{}{
+		"rootfs-storage-root": filepath.Join(tempDir, "rootfs"),
+	}))
+
+	localPath := filepath.Join(tempDir, "build")
+	localAttachment := filepath.Join(tempDir, "deploy.json")
+	assert.Nil(t, ioutil.WriteFile(localPath, []byte("rootfs"), 0644))
+	assert.Nil(t, ioutil.WriteFile(localAttachment, []byte(`{"replicas":3}`), 0644))
+	_, err = impl.StoreRootfsFile(&storage.RootfsStore{
+		Attachments: map[string]string{"deploy.json": localAttachment},
+		LocalPath:   localPath,
+		Org:         "tests",
+		Image:       "image",
+		Version:     "1.0",
+	})
+	assert.Nil(t, err)
+
+	source := &storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"}
+	buffer := bytes.NewBuffer([]byte{})
+	assert.Nil(t, impl.FetchRootfsAttachment(source, "deploy.json", buffer))
+	assert.Equal(t, `{"replicas":3}`, buffer.String())
+	assert.True(t, errors.Is(impl.FetchRootfsAttachment(source, "other.json", buffer), storage.ErrAttachmentNotFound))
+	assert.True(t, errors.Is(impl.FetchRootfsAttachment(source, "../1.0/rootfs", buffer), storage.ErrAttachmentNotFound))
+
+	_, err = impl.TagRootfs(&storage.RootfsTag{
+		Source:  source,
+		Org:     "tests",
+		Image:   "image",
+		Version: "latest",
+	})
+	assert.Nil(t, err)
+	buffer.Reset()
+	assert.Nil(t, impl.FetchRootfsAttachment(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest"}, "deploy.json", buffer))
+	assert.Equal(t, `{"replicas":3}`, buffer.String())
+
+	// the rootfs and the attachment are linked by the tag, only the metadata is freed with the source:
+	metadataStat, err := os.Stat(filepath.Join(tempDir, "rootfs", "tests", "image", "1.0", naming.MetadataFileName))
+	assert.Nil(t, err)
+	deleteResult, err := impl.DeleteRootfs(source)
+	assert.Nil(t, err)
+	assert.Equal(t, metadataStat.Size(), deleteResult.FreedBytes)
+	deleteResult, err = impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len("null")+len("rootfs")+len(`{"replicas":3}`)), deleteResult.FreedBytes)
+	exists, err := utils.PathExists(filepath.Join(tempDir, "rootfs", "tests"))
+	assert.Nil(t, err)
+	assert.False(t, exists, "expected the emptied directories to be removed")
+}
+
+func TestResolveRootfsVersion(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := storage.WithArchitecture(New(hclog.Default()))
+	assert.Nil(t, impl.Configure(map[string]interface{}{
+		"rootfs-storage-root": filepath.Join(tempDir, "rootfs"),
+	}))
+
+	localPath := filepath.Join(tempDir, "build")
+	for _, stored := range []struct{ image, version, arch, builtArch string }{
+		{"image", "1.9.0", "amd64", "amd64"},
+		{"image", "1.10.0", "amd64", "amd64"},
+		{"image", "1.11.0", "arm64", "arm64"},
+		{"image", "2.0.0", "", "amd64"},
+		{"image", "2.1.0", "", "arm64"},
+		{"image", "latest", "", "amd64"},
+		{"other", "1.12.0", "amd64", "amd64"},
+	} {
+		assert.Nil(t, ioutil.WriteFile(localPath, []byte("rootfs"), 0644))
+		_, err := impl.StoreRootfsFile(&storage.RootfsStore{
+			LocalPath: localPath,
+			Metadata:  map[string]interface{}{"Image": map[string]interface{}{"Arch": stored.builtArch}},
+			Org:       "tests",
+			Image:     stored.image,
+			Version:   stored.version,
+			Arch:      stored.arch,
+		})
+		assert.Nil(t, err)
+	}
+
+	version, err := storage.ResolveRootfsVersion(impl, &storage.RootfsLookup{Org: "tests", Image: "image", Version: "^1.2", Arch: "amd64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "1.10.0", version)
+
+	version, err = storage.ResolveRootfsVersion(impl, &storage.RootfsLookup{Org: "tests", Image: "image", Version: "^1.2", Arch: "arm64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "1.11.0", version)
+
+	version, err = storage.ResolveRootfsVersion(impl, &storage.RootfsLookup{Org: "tests", Image: "image", Version: "*", Arch: "amd64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "2.0.0", version)
+
+	version, err = storage.ResolveRootfsVersion(impl, &storage.RootfsLookup{Org: "tests", Image: "image", Version: "*", Arch: "arm64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "2.1.0", version)
+
+	_, err = storage.ResolveRootfsVersion(impl, &storage.RootfsLookup{Org: "tests", Image: "image", Version: "^3", Arch: "amd64"})
+	assert.NotNil(t, err)
+}
+
+func TestResolveLatestRootfsVersion(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := storage.WithArchitecture(New(hclog.Default()))
+	assert.Nil(t, impl.Configure(map[string]interface{}{
+		"rootfs-storage-root": filepath.Join(tempDir, "rootfs"),
+	}))
+
+	localPath := filepath.Join(tempDir, "build")
+	for _, stored := range []struct {
+		image, version, arch string
+		createdAt            int64
+	}{
+		{"semver", "1.9.0", "amd64", 300},
+		{"semver", "1.10.0", "amd64", 200},
+		{"semver", "2.0.0-rc.1", "amd64", 400},
+		{"semver", "3.0.0", "arm64", 500},
+		{"named", "buster", "amd64", 200},
+		{"named", "bullseye", "amd64", 300},
+		{"named", "bookworm", "arm64", 400},
+		{"tagged", "1.0.0", "amd64", 100},
+		{"tagged", "latest", "amd64", 50},
+	} {
+		assert.Nil(t, ioutil.WriteFile(localPath, []byte("rootfs"), 0644))
+		_, err := impl.StoreRootfsFile(&storage.RootfsStore{
+			LocalPath: localPath,
+			Metadata: map[string]interface{}{
+				"CreatedAtUTC": stored.createdAt,
+				"Image":        map[string]interface{}{"Arch": stored.arch},
+			},
+			Org:     "tests",
+			Image:   stored.image,
+			Version: stored.version,
+			Arch:    stored.arch,
+		})
+		assert.Nil(t, err)
+	}
+
+	// all semantic versions, the highest release version:
+	version, err := storage.ResolveLatestRootfsVersion(impl, &storage.RootfsLookup{Org: "tests", Image: "semver", Arch: "amd64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "1.10.0", version)
+
+	version, err = storage.ResolveLatestRootfsVersion(impl, &storage.RootfsLookup{Org: "tests", Image: "semver", Arch: "arm64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "3.0.0", version)
+
+	// not semantic versions, the most recently created:
+	version, err = storage.ResolveLatestRootfsVersion(impl, &storage.RootfsLookup{Org: "tests", Image: "named", Arch: "amd64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "bullseye", version)
+
+	// the rootfs stored with the latest version takes precedence:
+	version, err = storage.ResolveLatestRootfsVersion(impl, &storage.RootfsLookup{Org: "tests", Image: "tagged", Arch: "amd64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "latest", version)
+
+	_, err = storage.ResolveLatestRootfsVersion(impl, &storage.RootfsLookup{Org: "tests", Image: "missing", Arch: "amd64"})
+	assert.True(t, errors.Is(err, storage.ErrRootfsNotFound))
+
+	// the lookup resolution:
+	lookup, err := storage.ResolveRootfsLookup(impl, &storage.RootfsLookup{Org: "tests", Image: "named", Version: "", Arch: "amd64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "bullseye", lookup.Version)
+	lookup, err = storage.ResolveRootfsLookup(impl, &storage.RootfsLookup{Org: "tests", Image: "semver", Version: "~1.9", Arch: "amd64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "1.9.0", lookup.Version)
+	lookup, err = storage.ResolveRootfsLookup(impl, &storage.RootfsLookup{Org: "tests", Image: "named", Version: "buster", Arch: "amd64"})
+	assert.Nil(t, err)
+	assert.Equal(t, "buster", lookup.Version)
+}
+
+func TestVerifyRootfs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := New(hclog.Default())
+	assert.Nil(t, impl.Configure(map[string]interface{}{
+		"rootfs-storage-root": filepath.Join(tempDir, "rootfs"),
+	}))
+
+	localPath := filepath.Join(tempDir, "build")
+	assert.Nil(t, ioutil.WriteFile(localPath, []byte("rootfs"), 0644))
+	_, err = impl.StoreRootfsFile(&storage.RootfsStore{
+		LocalPath: localPath,
+		Metadata:  map[string]interface{}{"Tag": "tests/image:1.0"},
+		Org:       "tests",
+		Image:     "image",
+		Version:   "1.0",
+	})
+	assert.Nil(t, err)
+
+	result, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	assert.Nil(t, err)
+	assert.Equal(t, testSHA256([]byte("rootfs")), storage.RootfsSHA256(result.Metadata()))
+	assert.Nil(t, storage.VerifyRootfs(result))
+
+	// the corrupted rootfs fails the verification:
+	assert.Nil(t, ioutil.WriteFile(result.HostPath(), []byte("rootfz"), 0644))
+	result, err = impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(storage.VerifyRootfs(result), storage.ErrRootfsChecksumMismatch))
+
+	// the rootfs stored before the checksum was recorded:
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "rootfs", "tests", "image", "1.0", naming.MetadataFileName), []byte(`{"Tag":"tests/image:1.0"}`), 0644))
+	result, err = impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(storage.VerifyRootfs(result), storage.ErrRootfsChecksumMissing))
+}
+
+func testSHA256(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -1,6 +1,20 @@
 package storage
 
-import "github.com/spf13/pflag"
+import (
+	"errors"
+	"io"
+
+	"github.com/spf13/pflag"
+)
+
+// ErrRootfsExists is returned when a rootfs tag entry exists and must not be overwritten.
+var ErrRootfsExists = errors.New("rootfs exists")
+
+// ErrRootfsNotFound is returned when a rootfs tag entry does not exist.
+var ErrRootfsNotFound = errors.New("rootfs not found")
+
+// ErrAttachmentNotFound is returned when a rootfs does not have the named attachment.
+var ErrAttachmentNotFound = errors.New("attachment not found")
 
 // FlagProvider defines an interface for the policy storage provider flag handling.
 type FlagProvider interface {
@@ -20,20 +34,41 @@ type KernelResult interface {
 }
 
 // RootfsLookup is the rootfs query parameters configuration.
+// The rootfs of an architecture is stored under its own key, a provider wrapped
+// with WithArchitecture falls back to the rootfs stored without the architecture.
 type RootfsLookup struct {
 	Org     string
 	Image   string
 	Version string
+	Arch    string
 }
 
 // RootfsStore identifies rootfs storage arguments.
 type RootfsStore struct {
-	LocalPath string
-	Metadata  interface{}
+	// Attachments are the named files stored with the rootfs, the name maps to the local path.
+	// The attachment files are copied, not moved.
+	Attachments map[string]string
+	LocalPath   string
+	Metadata    interface{}
 
 	Org     string
 	Image   string
 	Version string
+	Arch    string
+}
+
+// RootfsTag identifies rootfs tagging arguments.
+// The new tag entry points at the rootfs and the attachments of the source and uses the metadata.
+type RootfsTag struct {
+	Source   *RootfsLookup
+	Metadata interface{}
+	// Overwrite allows replacing an existing tag entry.
+	Overwrite bool
+
+	Org     string
+	Image   string
+	Version string
+	Arch    string
 }
 
 // RootfsResult contains the information about the resolved rootfs.
@@ -42,6 +77,14 @@ type RootfsResult interface {
 	Metadata() interface{}
 }
 
+// RootfsDeleteResult contains the information about the deleted rootfs.
+type RootfsDeleteResult struct {
+	// FreedBytes is the number of bytes released by the storage.
+	// A rootfs still used by other tags is not counted.
+	FreedBytes int64
+	Provider   string
+}
+
 // RootfsStoreResult contains the information about the stored rootfs.
 type RootfsStoreResult struct {
 	MetadataLocation string
@@ -53,10 +96,35 @@ type RootfsStoreResult struct {
 type Provider interface {
 	Configure(map[string]interface{}) error
 
+	// DeleteRootfs deletes the root file system, the attachments and the metadata of a tag.
+	// Returns ErrRootfsNotFound if the tag entry does not exist.
+	DeleteRootfs(*RootfsLookup) (*RootfsDeleteResult, error)
+
 	// FetchKernel fetches a Linux Kernel by ID.
 	FetchKernel(*KernelLookup) (KernelResult, error)
 	// FetchRootfs fetches a root file system by ID.
 	FetchRootfs(*RootfsLookup) (RootfsResult, error)
+	// FetchRootfsAttachment writes the contents of the named attachment of a root file system to the writer.
+	// Returns ErrAttachmentNotFound if the root file system does not have the attachment.
+	FetchRootfsAttachment(*RootfsLookup, string, io.Writer) error
+	// FetchRootfsMetadata fetches the metadata of a root file system by ID, without fetching the root file system.
+	FetchRootfsMetadata(*RootfsLookup) (interface{}, error)
 
 	StoreRootfsFile(*RootfsStore) (*RootfsStoreResult, error)
+	// TagRootfs creates a new tag entry pointing at the root file system of an existing tag.
+	// Returns ErrRootfsExists if the tag entry exists and the overwrite is not allowed.
+	TagRootfs(*RootfsTag) (*RootfsStoreResult, error)
+}
+
+// DeduplicatingProvider is a storage provider capable of converting
+// the stored rootfs files to the deduplicated layout.
+type DeduplicatingProvider interface {
+	// DeduplicateRootfs converts the stored rootfs files and returns the number of converted files.
+	DeduplicateRootfs() (int, error)
+}
+
+// ListingProvider is a storage provider capable of listing the stored rootfs files.
+type ListingProvider interface {
+	// ListRootfs returns the tags of the stored rootfs files, sorted by org, image, version and architecture.
+	ListRootfs() ([]*RootfsLookup, error)
 }
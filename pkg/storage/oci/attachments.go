@@ -0,0 +1,68 @@
+package oci
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// FetchRootfsAttachment writes the contents of the named attachment of a root file system to the writer.
+// The attachment is not cached locally.
+func (p *provider) FetchRootfsAttachment(q *storage.RootfsLookup, name string, writer io.Writer) error {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	p.logger.Debug("looking up rootfs attachment", "rootfs-id", rootfsID, "attachment", name)
+	repository := p.repository(q.Org, q.Image, q.Arch)
+	m, err := p.rootfsManifest(repository, q.Version)
+	if err != nil {
+		p.logger.Error("error fetching rootfs manifest", "reason", err, "rootfs-id", rootfsID)
+		return err
+	}
+	for _, attachment := range attachmentLayers(m) {
+		if attachment.Annotations[annotationTitle] != name {
+			continue
+		}
+		if err := p.client.getBlob(repository, attachment.Digest, writer); err != nil {
+			p.logger.Error("error fetching rootfs attachment", "reason", err, "rootfs-id", rootfsID, "attachment", name)
+			return errors.Wrap(err, "failed fetching rootfs attachment")
+		}
+		return nil
+	}
+	return errors.Wrapf(storage.ErrAttachmentNotFound, "attachment %q of rootfs %s", name, rootfsID)
+}
+
+// pushAttachments pushes the attachment files as blobs and returns the layers, sorted by the name.
+func (p *provider) pushAttachments(repository string, attachments map[string]string) ([]descriptor, error) {
+	names := []string{}
+	for name := range attachments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	layers := []descriptor{}
+	for _, name := range names {
+		layer, err := fileDescriptor(attachments[name])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed checking attachment %q", name)
+		}
+		layer.MediaType = attachmentLayerMediaType
+		layer.Annotations = map[string]string{annotationTitle: name}
+		if err := p.pushFile(repository, layer, attachments[name]); err != nil {
+			return nil, errors.Wrapf(err, "failed pushing attachment %q", name)
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// attachmentLayers returns the attachment layers of the manifest.
+func attachmentLayers(m *manifest) []descriptor {
+	layers := []descriptor{}
+	for _, layer := range m.Layers {
+		if layer.MediaType == attachmentLayerMediaType {
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
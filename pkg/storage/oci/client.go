@@ -0,0 +1,613 @@
+package oci
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	manifestMediaType       = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// maxManifestSize limits the size of the manifest read from the registry.
+	maxManifestSize = 4 * 1024 * 1024
+	// defaultChunkSize is the size of the chunks of the chunked blob uploads,
+	// the blobs larger than a chunk are uploaded in chunks.
+	defaultChunkSize = 64 * 1024 * 1024
+
+	tokenClientID = "firebuild"
+)
+
+// descriptor is the OCI content descriptor.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// manifest is the OCI image manifest.
+type manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        descriptor        `json:"config"`
+	Layers        []descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// errorNotFound is returned when the manifest or the blob does not exist.
+type errorNotFound struct {
+	Repository string
+	Reference  string
+}
+
+func (e *errorNotFound) Error() string {
+	return fmt.Sprintf("%s@%s not found", e.Repository, e.Reference)
+}
+
+// authChallenge is the parsed WWW-Authenticate challenge of the registry.
+type authChallenge struct {
+	scheme string
+	params map[string]string
+}
+
+// client is a minimal OCI distribution API client, supports only the operations required by the provider.
+// The registry is pinged once to discover the authentication scheme, bearer tokens are requested per scope.
+type client struct {
+	auth       *types.AuthConfig
+	baseURL    *url.URL
+	chunkSize  int64
+	httpClient *http.Client
+
+	mutex     sync.Mutex
+	pinged    bool
+	challenge *authChallenge
+	tokens    map[string]string
+}
+
+// newClient returns a client of the registry host, the insecure client uses plain HTTP.
+// A nil auth config accesses the registry anonymously. The requests are sent with the HTTP client.
+func newClient(host string, insecure bool, auth *types.AuthConfig, httpClient *http.Client) (*client, error) {
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	baseURL, err := url.Parse(fmt.Sprintf("%s://%s", scheme, host))
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry %q: %v", host, err)
+	}
+	if baseURL.Host != host || baseURL.Path != "" {
+		return nil, fmt.Errorf("invalid registry %q: expected host[:port]", host)
+	}
+	return &client{
+		auth:       auth,
+		baseURL:    baseURL,
+		chunkSize:  defaultChunkSize,
+		httpClient: httpClient,
+		tokens:     map[string]string{},
+	}, nil
+}
+
+// getManifest returns the manifest and its digest, errorNotFound if the manifest does not exist.
+func (c *client) getManifest(repository, reference string) (*manifest, string, error) {
+	response, err := c.do(http.MethodGet, c.apiPath(repository, "manifests", reference), nil, acceptManifest, pullScope(repository))
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, "", &errorNotFound{Repository: repository, Reference: reference}
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, "", responseError(http.MethodGet, repository, reference, response)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxManifestSize))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed reading manifest")
+	}
+	result := &manifest{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, "", errors.Wrap(err, "failed decoding manifest")
+	}
+	digest := response.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = sha256Digest(body)
+	}
+	return result, digest, nil
+}
+
+// manifestExists returns true if the manifest exists.
+func (c *client) manifestExists(repository, reference string) (bool, error) {
+	response, err := c.do(http.MethodHead, c.apiPath(repository, "manifests", reference), nil, acceptManifest, pullScope(repository))
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, responseError(http.MethodHead, repository, reference, response)
+}
+
+// putManifest uploads the manifest and returns its digest.
+func (c *client) putManifest(repository, reference string, m *manifest) (string, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return "", errors.Wrap(err, "failed encoding manifest")
+	}
+	response, err := c.do(http.MethodPut, c.apiPath(repository, "manifests", reference), bytes.NewReader(body), func(request *http.Request) {
+		request.Header.Set("Content-Type", manifestMediaType)
+	}, pushScope(repository))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return "", responseError(http.MethodPut, repository, reference, response)
+	}
+	return sha256Digest(body), nil
+}
+
+// deleteManifest deletes the manifest by digest, errorNotFound if the manifest does not exist.
+func (c *client) deleteManifest(repository, digest string) error {
+	response, err := c.do(http.MethodDelete, c.apiPath(repository, "manifests", digest), nil, nil, pushScope(repository))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return &errorNotFound{Repository: repository, Reference: digest}
+	case http.StatusMethodNotAllowed:
+		return fmt.Errorf("registry does not allow deleting %s@%s", repository, digest)
+	}
+	return responseError(http.MethodDelete, repository, digest, response)
+}
+
+// blobExists returns true if the blob exists in the repository.
+func (c *client) blobExists(repository, digest string) (bool, error) {
+	response, err := c.do(http.MethodHead, c.apiPath(repository, "blobs", digest), nil, nil, pullScope(repository))
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, responseError(http.MethodHead, repository, digest, response)
+}
+
+// getBlob writes the blob to the writer, errorNotFound if the blob does not exist.
+// The downloaded content is verified against the digest.
+func (c *client) getBlob(repository, digest string, writer io.Writer) error {
+	response, err := c.do(http.MethodGet, c.apiPath(repository, "blobs", digest), nil, nil, pullScope(repository))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return &errorNotFound{Repository: repository, Reference: digest}
+	}
+	if response.StatusCode != http.StatusOK {
+		return responseError(http.MethodGet, repository, digest, response)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(writer, hash), response.Body); err != nil {
+		return err
+	}
+	if downloaded := "sha256:" + hex.EncodeToString(hash.Sum(nil)); downloaded != digest {
+		return fmt.Errorf("blob %s@%s digest mismatch: downloaded %s", repository, digest, downloaded)
+	}
+	return nil
+}
+
+// uploadBlob uploads the blob. A body readable at offsets larger than a chunk is uploaded in chunks,
+// each chunk is retried on its own. Other bodies are uploaded with a monolithic upload,
+// a seekable body is read again from the current offset when the upload is retried.
+func (c *client) uploadBlob(repository, digest string, body io.Reader, size int64) error {
+	response, err := c.do(http.MethodPost, c.apiPath(repository, "blobs", "uploads")+"/", nil, nil, pushScope(repository))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		return responseError(http.MethodPost, repository, digest, response)
+	}
+	if readerAt, ok := body.(io.ReaderAt); ok && size > c.chunkSize {
+		return c.uploadChunks(repository, digest, response, readerAt, size)
+	}
+	location, err := c.uploadLocation(response, digest)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPut, location, body)
+	if err != nil {
+		return err
+	}
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		if err := utils.SetReplayableBody(request, seeker); err != nil {
+			return err
+		}
+	}
+	request.ContentLength = size
+	request.Header.Set("Content-Type", "application/octet-stream")
+	response, err = c.send(request, pushScope(repository))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return responseError(http.MethodPut, repository, digest, response)
+	}
+	return nil
+}
+
+// uploadChunks uploads the blob of the started upload in chunks and completes the upload.
+// The upload is cancelled when a chunk fails.
+func (c *client) uploadChunks(repository, digest string, response *http.Response, body io.ReaderAt, size int64) error {
+	for offset := int64(0); offset < size; offset = offset + c.chunkSize {
+		length := c.chunkSize
+		if remaining := size - offset; remaining < length {
+			length = remaining
+		}
+		chunkResponse, err := c.uploadChunk(repository, response, io.NewSectionReader(body, offset, length), offset, length)
+		if err != nil {
+			c.cancelUpload(repository, response)
+			return err
+		}
+		if chunkResponse.StatusCode != http.StatusAccepted {
+			c.cancelUpload(repository, response)
+			return responseError(http.MethodPatch, repository, digest, chunkResponse)
+		}
+		// the next chunk is sent to the location of the last response:
+		response = chunkResponse
+	}
+	location, err := c.uploadLocation(response, digest)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPut, location, nil)
+	if err != nil {
+		return err
+	}
+	completeResponse, err := c.send(request, pushScope(repository))
+	if err != nil {
+		return err
+	}
+	defer completeResponse.Body.Close()
+	if completeResponse.StatusCode != http.StatusCreated {
+		return responseError(http.MethodPut, repository, digest, completeResponse)
+	}
+	return nil
+}
+
+// uploadChunk sends the chunk at the offset to the location of the upload response.
+// The chunk is read again when the request is retried. The response body is closed.
+func (c *client) uploadChunk(repository string, response *http.Response, chunk io.ReadSeeker, offset, length int64) (*http.Response, error) {
+	location, err := c.uploadLocation(response, "")
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodPatch, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.SetReplayableBody(request, chunk); err != nil {
+		return nil, err
+	}
+	request.ContentLength = length
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+length-1))
+	chunkResponse, err := c.send(request, pushScope(repository))
+	if err != nil {
+		return nil, err
+	}
+	chunkResponse.Body.Close()
+	return chunkResponse, nil
+}
+
+// mountBlob mounts the blob of another repository of the registry.
+// Returns false when the registry did not mount the blob, the blob has to be uploaded then.
+func (c *client) mountBlob(repository, fromRepository, digest string) (bool, error) {
+	query := url.Values{}
+	query.Set("mount", digest)
+	query.Set("from", fromRepository)
+	response, err := c.do(http.MethodPost, c.apiPath(repository, "blobs", "uploads")+"/?"+query.Encode(), nil, nil,
+		pushScope(repository), pullScope(fromRepository))
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusAccepted:
+		// the registry started a regular upload instead:
+		c.cancelUpload(repository, response)
+		return false, nil
+	}
+	return false, responseError(http.MethodPost, repository, digest, response)
+}
+
+// cancelUpload cancels the started upload, errors are ignored, the registry expires abandoned uploads.
+func (c *client) cancelUpload(repository string, response *http.Response) {
+	location, err := c.uploadLocation(response, "")
+	if err != nil {
+		return
+	}
+	request, err := http.NewRequest(http.MethodDelete, location, nil)
+	if err != nil {
+		return
+	}
+	if cancelResponse, err := c.send(request, pushScope(repository)); err == nil {
+		cancelResponse.Body.Close()
+	}
+}
+
+// uploadLocation resolves the upload URL of the upload response, with the digest query parameter, if given.
+func (c *client) uploadLocation(response *http.Response, digest string) (string, error) {
+	location, err := c.baseURL.Parse(response.Header.Get("Location"))
+	if err != nil || response.Header.Get("Location") == "" {
+		return "", fmt.Errorf("registry returned an invalid upload location %q", response.Header.Get("Location"))
+	}
+	if digest != "" {
+		query := location.Query()
+		query.Set("digest", digest)
+		location.RawQuery = query.Encode()
+	}
+	return location.String(), nil
+}
+
+// do sends the request to the API path of the registry.
+func (c *client) do(method, apiPath string, body io.Reader, modifier func(*http.Request), scopes ...string) (*http.Response, error) {
+	target, err := c.baseURL.Parse(apiPath)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if modifier != nil {
+		modifier(request)
+	}
+	return c.send(request, scopes...)
+}
+
+// send authorizes the request for the scopes and sends it.
+func (c *client) send(request *http.Request, scopes ...string) (*http.Response, error) {
+	authorization, err := c.authorization(scopes...)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	return c.httpClient.Do(request)
+}
+
+// authorization returns the Authorization header value for the scopes,
+// an empty string when the registry does not require authentication.
+func (c *client) authorization(scopes ...string) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.pinged {
+		if err := c.ping(); err != nil {
+			return "", err
+		}
+	}
+	if c.challenge == nil {
+		return "", nil
+	}
+	switch c.challenge.scheme {
+	case "basic":
+		if c.auth == nil || c.auth.Username == "" {
+			return "", nil
+		}
+		return "Basic " + basicCredentials(c.auth.Username, c.auth.Password), nil
+	case "bearer":
+		if c.auth != nil && c.auth.RegistryToken != "" {
+			return "Bearer " + c.auth.RegistryToken, nil
+		}
+		key := strings.Join(scopes, " ")
+		if token, ok := c.tokens[key]; ok {
+			return "Bearer " + token, nil
+		}
+		token, err := c.fetchToken(scopes)
+		if err != nil {
+			return "", err
+		}
+		c.tokens[key] = token
+		return "Bearer " + token, nil
+	}
+	return "", fmt.Errorf("registry authentication scheme %q not supported", c.challenge.scheme)
+}
+
+// ping discovers the authentication scheme of the registry.
+func (c *client) ping() error {
+	target, _ := c.baseURL.Parse("/v2/")
+	response, err := c.httpClient.Get(target.String())
+	if err != nil {
+		return errors.Wrap(err, "failed connecting to registry")
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		challenge, err := parseChallenge(response.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return err
+		}
+		c.challenge = challenge
+	default:
+		return responseError(http.MethodGet, "", "", response)
+	}
+	c.pinged = true
+	return nil
+}
+
+// fetchToken requests a bearer token for the scopes from the token server of the registry.
+// The identity token is exchanged with the OAuth2 refresh token grant, other credentials use basic authentication.
+func (c *client) fetchToken(scopes []string) (string, error) {
+	realm := c.challenge.params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("registry bearer challenge without realm")
+	}
+	realmURL, err := url.Parse(realm)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid registry token realm")
+	}
+	var request *http.Request
+	if c.auth != nil && c.auth.IdentityToken != "" {
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", c.auth.IdentityToken)
+		form.Set("client_id", tokenClientID)
+		form.Set("service", c.challenge.params["service"])
+		form.Set("scope", strings.Join(scopes, " "))
+		request, err = http.NewRequest(http.MethodPost, realmURL.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := realmURL.Query()
+		if service := c.challenge.params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		for _, scope := range scopes {
+			query.Add("scope", scope)
+		}
+		realmURL.RawQuery = query.Encode()
+		request, err = http.NewRequest(http.MethodGet, realmURL.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if c.auth != nil && c.auth.Username != "" {
+			request.SetBasicAuth(c.auth.Username, c.auth.Password)
+		}
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", errors.Wrap(err, "failed requesting registry token")
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		// the token server response may echo the credentials, do not include the body:
+		return "", fmt.Errorf("registry token request failed with status %d", response.StatusCode)
+	}
+	tokenResponse := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&tokenResponse); err != nil {
+		return "", errors.Wrap(err, "failed decoding registry token")
+	}
+	if tokenResponse.Token != "" {
+		return tokenResponse.Token, nil
+	}
+	if tokenResponse.AccessToken != "" {
+		return tokenResponse.AccessToken, nil
+	}
+	return "", fmt.Errorf("registry token response without token")
+}
+
+func (c *client) apiPath(repository, kind, reference string) string {
+	return fmt.Sprintf("/v2/%s/%s/%s", repository, kind, reference)
+}
+
+// parseChallenge parses the WWW-Authenticate header: scheme key="value", key="value".
+func parseChallenge(header string) (*authChallenge, error) {
+	header = strings.TrimSpace(header)
+	parts := strings.SplitN(header, " ", 2)
+	if parts[0] == "" {
+		return nil, fmt.Errorf("registry requires authentication but sent no challenge")
+	}
+	challenge := &authChallenge{
+		scheme: strings.ToLower(parts[0]),
+		params: map[string]string{},
+	}
+	if len(parts) == 1 {
+		return challenge, nil
+	}
+	rest := parts[1]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		equals := strings.Index(rest, "=")
+		if equals < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:equals]))
+		rest = rest[equals+1:]
+		value := ""
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid registry challenge %q", header)
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			value = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+		challenge.params[key] = value
+	}
+	return challenge, nil
+}
+
+func acceptManifest(request *http.Request) {
+	request.Header.Set("Accept", strings.Join([]string{manifestMediaType, dockerManifestMediaType}, ", "))
+}
+
+func pullScope(repository string) string {
+	return fmt.Sprintf("repository:%s:pull", repository)
+}
+
+func pushScope(repository string) string {
+	return fmt.Sprintf("repository:%s:pull,push", repository)
+}
+
+func basicCredentials(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func responseError(method, repository, reference string, response *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+	target := strings.TrimSuffix(fmt.Sprintf("%s@%s", repository, reference), "@")
+	if len(body) > 0 {
+		return fmt.Errorf("registry %s %q failed with status %d: %s", method, target, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return fmt.Errorf("registry %s %q failed with status %d", method, target, response.StatusCode)
+}
@@ -0,0 +1,49 @@
+package flags
+
+import (
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/spf13/pflag"
+)
+
+// FlagProvider is the OCI registry provider.
+type flags struct {
+	DialTimeout           time.Duration
+	DockerConfig          string
+	Insecure              bool
+	LocalCacheRoot        string
+	Namespace             string
+	Registry              string
+	ResponseHeaderTimeout time.Duration
+}
+
+// New returns an initialized instance of the flag provider.
+func New() storage.FlagProvider {
+	return &flags{}
+}
+
+func (fp *flags) GetFlags() *pflag.FlagSet {
+	set := &pflag.FlagSet{}
+	set.DurationVar(&fp.DialTimeout, "storage-provider.oci.dial-timeout", utils.DefaultHTTPDialTimeout, "Timeout of connecting to the registry")
+	set.StringVar(&fp.DockerConfig, "storage-provider.oci.docker-config", "", "Full path to the Docker CLI config.json with the registry credentials; default Docker config if empty")
+	set.BoolVar(&fp.Insecure, "storage-provider.oci.insecure", false, "Access the registry over plain HTTP, for local registries")
+	set.StringVar(&fp.LocalCacheRoot, "storage-provider.oci.local-cache-root", "/var/lib/firebuild/oci-cache", "Full path to the local directory caching the pulled kernels and rootfs files")
+	set.StringVar(&fp.Namespace, "storage-provider.oci.namespace", "", "Repository namespace of the kernel and rootfs artifacts")
+	set.StringVar(&fp.Registry, "storage-provider.oci.registry", "", "Registry host of the kernel and rootfs artifacts, format: host[:port]")
+	set.DurationVar(&fp.ResponseHeaderTimeout, "storage-provider.oci.response-header-timeout", utils.DefaultHTTPResponseHeaderTimeout, "Timeout of waiting for the registry response once the request is sent, the transfer itself is not limited")
+	return set
+}
+
+func (fp *flags) GetInitializedConfiguration() map[string]interface{} {
+	return map[string]interface{}{
+		"dial-timeout":            fp.DialTimeout,
+		"docker-config":           fp.DockerConfig,
+		"insecure":                fp.Insecure,
+		"local-cache-root":        fp.LocalCacheRoot,
+		"namespace":               fp.Namespace,
+		"registry":                fp.Registry,
+		"response-header-timeout": fp.ResponseHeaderTimeout,
+	}
+}
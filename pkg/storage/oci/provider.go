@@ -0,0 +1,586 @@
+package oci
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/containers"
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+const (
+	providerName = "oci"
+
+	kernelsRepository = "kernels"
+	kernelsCacheDir   = "kernels"
+	rootfsCacheDir    = "rootfs"
+	digestFileSuffix  = ".digest"
+
+	attachmentLayerMediaType = "application/vnd.firebuild.attachment.v1"
+	rootfsConfigMediaType    = "application/vnd.firebuild.rootfs.config.v1+json"
+	rootfsLayerMediaType     = "application/vnd.firebuild.rootfs.layer.v1.ext4"
+
+	annotationRefName = "org.opencontainers.image.ref.name"
+	annotationTitle   = "org.opencontainers.image.title"
+)
+
+type providerConfig struct {
+	DockerConfig   string `mapstructure:"docker-config"`
+	Insecure       bool   `mapstructure:"insecure"`
+	LocalCacheRoot string `mapstructure:"local-cache-root"`
+	Namespace      string `mapstructure:"namespace"`
+	Password       string `mapstructure:"password"`
+	Registry       string `mapstructure:"registry"`
+	Username       string `mapstructure:"username"`
+
+	DialTimeout           time.Duration `mapstructure:"dial-timeout"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"response-header-timeout"`
+
+	Retry utils.RetryConfig `mapstructure:"retry"`
+}
+
+type provider struct {
+	client *client
+	config *providerConfig
+	logger hclog.Logger
+}
+
+// New returns a new instance of the provider.
+func New(logger hclog.Logger) storage.Provider {
+	return &provider{
+		logger: logger,
+	}
+}
+
+// Configure configures the provider. If the credentials are not given
+// in the configuration, the credentials of the Docker config are used.
+func (p *provider) Configure(mapConfig map[string]interface{}) error {
+	p.logger.Debug("configuring storage provider")
+	pConfig := &providerConfig{}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		// weakly typed, the profile properties are strings:
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		WeaklyTypedInput: true,
+		Result:           pConfig,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed creating configuration decoder")
+	}
+	if err := decoder.Decode(mapConfig); err != nil {
+		p.logger.Error("error when decoding configuration", "reason", err)
+		return errors.Wrap(err, "failed decoding provider configuration")
+	}
+	if pConfig.Registry == "" {
+		return fmt.Errorf("oci storage provider: registry is required")
+	}
+	if pConfig.LocalCacheRoot == "" {
+		return fmt.Errorf("oci storage provider: local cache root is required")
+	}
+	pConfig.Namespace = strings.Trim(pConfig.Namespace, "/")
+
+	registryAuths, err := containers.ResolveRegistryAuths(pConfig.DockerConfig, []string{}, []string{})
+	if err != nil {
+		p.logger.Error("error when resolving registry credentials", "reason", err)
+		return errors.Wrap(err, "failed resolving registry credentials")
+	}
+	if pConfig.Username != "" {
+		registryAuths.Add(pConfig.Registry, types.AuthConfig{Username: pConfig.Username, Password: pConfig.Password})
+	}
+	var auth *types.AuthConfig
+	if authConfig, ok, err := registryAuths.ForRegistry(pConfig.Registry); err != nil {
+		p.logger.Error("error when resolving registry credentials", "reason", err)
+		return errors.Wrap(err, "failed resolving registry credentials")
+	} else if ok {
+		auth = &authConfig
+	}
+
+	if pConfig.Retry.Attempts == 0 {
+		pConfig.Retry = utils.DefaultRetryConfig()
+	}
+	if pConfig.DialTimeout == 0 {
+		pConfig.DialTimeout = utils.DefaultHTTPDialTimeout
+	}
+	if pConfig.ResponseHeaderTimeout == 0 {
+		pConfig.ResponseHeaderTimeout = utils.DefaultHTTPResponseHeaderTimeout
+	}
+	transport := utils.NewHTTPTransport(pConfig.DialTimeout, pConfig.ResponseHeaderTimeout)
+	httpClient := &http.Client{Transport: utils.NewRetryingTransport(transport, pConfig.Retry, p.logger)}
+	registryClient, err := newClient(registryAPIHost(pConfig.Registry), pConfig.Insecure, auth, httpClient)
+	if err != nil {
+		p.logger.Error("error when creating registry client", "reason", err)
+		return errors.Wrap(err, "failed creating registry client")
+	}
+	p.client = registryClient
+	p.config = pConfig
+	p.logger.Debug("storage provider configured")
+	return nil
+}
+
+// FetchKernel fetches a Linux Kernel by ID.
+// The kernel is the first layer of the <namespace>/kernels:<kernel-id> artifact.
+func (p *provider) FetchKernel(q *storage.KernelLookup) (storage.KernelResult, error) {
+	p.logger.Debug("looking up kernel", "kernel-id", q.ID)
+	repository := p.repository(kernelsRepository)
+	m, _, err := p.client.getManifest(repository, q.ID)
+	if err != nil {
+		p.logger.Error("error fetching kernel manifest", "reason", err, "kernel-id", q.ID, "repository", repository)
+		return nil, errors.Wrap(err, "failed resolving kernel")
+	}
+	if len(m.Layers) == 0 {
+		return nil, fmt.Errorf("kernel artifact %s:%s has no layers", repository, q.ID)
+	}
+	kernelPath := p.cachePath(kernelsCacheDir, repository, q.ID)
+	if err := p.fetchToCache(repository, m.Layers[0], kernelPath); err != nil {
+		p.logger.Error("error fetching kernel", "reason", err, "kernel-id", q.ID, "repository", repository)
+		return nil, errors.Wrap(err, "failed resolving kernel file")
+	}
+	// TODO: kernel metadata needs to be implemented
+	p.logger.Debug("kernel located", "kernel-id", q.ID)
+	metadata := map[string]interface{}{}
+	return &kernelResult{
+		hostPath: kernelPath,
+		metadata: metadata,
+	}, nil
+}
+
+// FetchRootfs fetches a root file system by ID.
+// The rootfs layer is pulled to the local cache, unless the cached file has the same digest.
+func (p *provider) FetchRootfs(q *storage.RootfsLookup) (storage.RootfsResult, error) {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	p.logger.Debug("looking up rootfs", "rootfs-id", rootfsID)
+	repository := p.repository(q.Org, q.Image, q.Arch)
+	m, err := p.rootfsManifest(repository, q.Version)
+	if err != nil {
+		p.logger.Error("error fetching rootfs manifest", "reason", err, "rootfs-id", rootfsID)
+		return nil, err
+	}
+	layer, err := rootfsLayer(m)
+	if err != nil {
+		p.logger.Error("error resolving rootfs layer", "reason", err, "rootfs-id", rootfsID)
+		return nil, err
+	}
+	rootfsPath := p.cachePath(rootfsCacheDir, repository, q.Version, naming.RootfsFileName)
+	if err := p.fetchToCache(repository, layer, rootfsPath); err != nil {
+		p.logger.Error("error fetching rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed resolving rootfs file")
+	}
+	metadata, err := p.rootfsMetadata(repository, m)
+	if err != nil {
+		p.logger.Error("error fetching rootfs metadata", "reason", err, "rootfs-id", rootfsID)
+		return nil, err
+	}
+	p.logger.Debug("rootfs located", "rootfs-id", rootfsID)
+	return &rootfsResult{
+		hostPath: rootfsPath,
+		metadata: metadata,
+	}, nil
+}
+
+// FetchRootfsMetadata fetches the metadata of a root file system by ID.
+// Only the manifest and the config are pulled.
+func (p *provider) FetchRootfsMetadata(q *storage.RootfsLookup) (interface{}, error) {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	p.logger.Debug("looking up rootfs metadata", "rootfs-id", rootfsID)
+	repository := p.repository(q.Org, q.Image, q.Arch)
+	m, err := p.rootfsManifest(repository, q.Version)
+	if err != nil {
+		p.logger.Error("error fetching rootfs manifest", "reason", err, "rootfs-id", rootfsID)
+		return nil, err
+	}
+	metadata, err := p.rootfsMetadata(repository, m)
+	if err != nil {
+		p.logger.Error("error fetching rootfs metadata", "reason", err, "rootfs-id", rootfsID)
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// StoreRootfsFile pushes the rootfs file as the layer, the attachments as the additional layers
+// and the metadata as the config of the <namespace>/<org>/<image>:<version> artifact. The local file is moved
+// to the local cache so it does not have to be pulled again on this host.
+func (p *provider) StoreRootfsFile(input *storage.RootfsStore) (*storage.RootfsStoreResult, error) {
+	rootfsID := fmt.Sprintf("%s/%s:%s", input.Org, input.Image, input.Version)
+	repository := p.repository(input.Org, input.Image, input.Arch)
+	result := &storage.RootfsStoreResult{
+		Provider: providerName,
+	}
+
+	p.logger.Debug("storing rootfs", "rootfs-id", rootfsID)
+
+	layer, err := fileDescriptor(input.LocalPath)
+	if err != nil {
+		p.logger.Error("error checking rootfs file", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed checking rootfs file")
+	}
+	layer.MediaType = rootfsLayerMediaType
+	layer.Annotations = map[string]string{annotationTitle: naming.RootfsFileName}
+
+	p.logger.Debug("pushing rootfs", "rootfs-id", rootfsID,
+		"source", input.LocalPath,
+		"digest", layer.Digest)
+	if err := p.pushFile(repository, layer, input.LocalPath); err != nil {
+		p.logger.Error("error pushing rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed pushing rootfs")
+	}
+	result.RootfsLocation = p.blobURI(repository, layer.Digest)
+
+	attachments, err := p.pushAttachments(repository, input.Attachments)
+	if err != nil {
+		p.logger.Error("error pushing rootfs attachments", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed pushing rootfs attachments")
+	}
+
+	// the rootfs layer is not compressed, the layer digest is the rootfs checksum:
+	metadata, err := storage.WithRootfsSHA256(input.Metadata, strings.TrimPrefix(layer.Digest, "sha256:"))
+	if err != nil {
+		p.logger.Error("error recording rootfs SHA-256", "reason", err, "rootfs-id", rootfsID)
+		return nil, err
+	}
+
+	config, err := p.pushMetadata(repository, metadata)
+	if err != nil {
+		p.logger.Error("error pushing rootfs metadata", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed pushing rootfs metadata")
+	}
+	result.MetadataLocation = p.blobURI(repository, config.Digest)
+
+	if _, err := p.client.putManifest(repository, input.Version, rootfsManifest(config, layer, input.Version, attachments...)); err != nil {
+		p.logger.Error("error pushing rootfs manifest", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed pushing rootfs manifest")
+	}
+
+	cachePath := p.cachePath(rootfsCacheDir, repository, input.Version, naming.RootfsFileName)
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		p.logger.Warn("error creating local cache directory", "reason", err, "rootfs-id", rootfsID)
+	} else if moveErr := utils.MoveFile(input.LocalPath, cachePath); moveErr != nil {
+		p.logger.Warn("error moving rootfs to local cache", "reason", moveErr, "rootfs-id", rootfsID)
+	} else if writeErr := ioutil.WriteFile(cachePath+digestFileSuffix, []byte(layer.Digest), 0644); writeErr != nil {
+		p.logger.Warn("error writing rootfs cache digest", "reason", writeErr, "rootfs-id", rootfsID)
+	}
+
+	p.logger.Debug("rootfs stored", "rootfs-id", rootfsID)
+
+	return result, nil
+}
+
+// TagRootfs creates a new tag entry pointing at the root file system of an existing tag.
+// The rootfs and the attachment layers are mounted from the source repository when the registry supports it,
+// otherwise it is streamed from the source repository, nothing is stored locally.
+func (p *provider) TagRootfs(input *storage.RootfsTag) (*storage.RootfsStoreResult, error) {
+	sourceID := fmt.Sprintf("%s/%s:%s", input.Source.Org, input.Source.Image, input.Source.Version)
+	rootfsID := fmt.Sprintf("%s/%s:%s", input.Org, input.Image, input.Version)
+	sourceRepository := p.repository(input.Source.Org, input.Source.Image, input.Source.Arch)
+	repository := p.repository(input.Org, input.Image, input.Arch)
+	result := &storage.RootfsStoreResult{
+		Provider: providerName,
+	}
+
+	p.logger.Debug("tagging rootfs", "rootfs-id", rootfsID, "source-rootfs-id", sourceID)
+
+	if sourceRepository == repository && input.Source.Version == input.Version {
+		return nil, fmt.Errorf("source and target rootfs are the same")
+	}
+
+	sourceManifest, err := p.rootfsManifest(sourceRepository, input.Source.Version)
+	if err != nil {
+		p.logger.Error("error fetching source rootfs manifest", "reason", err, "source-rootfs-id", sourceID)
+		return nil, err
+	}
+	layer, err := rootfsLayer(sourceManifest)
+	if err != nil {
+		p.logger.Error("error resolving source rootfs layer", "reason", err, "source-rootfs-id", sourceID)
+		return nil, err
+	}
+
+	exists, err := p.client.manifestExists(repository, input.Version)
+	if err != nil {
+		p.logger.Error("error looking up target rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed looking up target rootfs")
+	}
+	if exists && !input.Overwrite {
+		p.logger.Error("target rootfs exists", "rootfs-id", rootfsID)
+		return nil, errors.Wrapf(storage.ErrRootfsExists, "rootfs %s", rootfsID)
+	}
+
+	attachments := attachmentLayers(sourceManifest)
+	if sourceRepository != repository {
+		if err := p.copyBlob(sourceRepository, repository, layer); err != nil {
+			p.logger.Error("error copying rootfs", "reason", err, "rootfs-id", rootfsID, "source-rootfs-id", sourceID)
+			return nil, errors.Wrap(err, "failed copying rootfs")
+		}
+		for _, attachment := range attachments {
+			if err := p.copyBlob(sourceRepository, repository, attachment); err != nil {
+				p.logger.Error("error copying rootfs attachment", "reason", err, "rootfs-id", rootfsID, "source-rootfs-id", sourceID)
+				return nil, errors.Wrap(err, "failed copying rootfs attachment")
+			}
+		}
+	}
+	result.RootfsLocation = p.blobURI(repository, layer.Digest)
+
+	config, err := p.pushMetadata(repository, input.Metadata)
+	if err != nil {
+		p.logger.Error("error pushing rootfs metadata", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed pushing rootfs metadata")
+	}
+	result.MetadataLocation = p.blobURI(repository, config.Digest)
+
+	if _, err := p.client.putManifest(repository, input.Version, rootfsManifest(config, layer, input.Version, attachments...)); err != nil {
+		p.logger.Error("error pushing rootfs manifest", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed pushing rootfs manifest")
+	}
+
+	p.logger.Debug("rootfs tagged", "rootfs-id", rootfsID, "source-rootfs-id", sourceID)
+
+	return result, nil
+}
+
+// DeleteRootfs deletes the manifest of a tag and the locally cached copy.
+// The registry releases the blobs with its garbage collection, no freed bytes are reported.
+func (p *provider) DeleteRootfs(q *storage.RootfsLookup) (*storage.RootfsDeleteResult, error) {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	repository := p.repository(q.Org, q.Image, q.Arch)
+	result := &storage.RootfsDeleteResult{
+		Provider: providerName,
+	}
+
+	p.logger.Debug("deleting rootfs", "rootfs-id", rootfsID)
+
+	_, digest, err := p.client.getManifest(repository, q.Version)
+	if err != nil {
+		if _, ok := err.(*errorNotFound); ok {
+			return nil, errors.Wrapf(storage.ErrRootfsNotFound, "rootfs %s", rootfsID)
+		}
+		p.logger.Error("error looking up rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed resolving rootfs")
+	}
+
+	// every tag has its own manifest, deleting by digest does not delete other tags:
+	if err := p.client.deleteManifest(repository, digest); err != nil {
+		p.logger.Error("error deleting manifest", "reason", err, "rootfs-id", rootfsID, "digest", digest)
+		return nil, errors.Wrap(err, "failed deleting rootfs")
+	}
+
+	cachePath := p.cachePath(rootfsCacheDir, repository, q.Version, naming.RootfsFileName)
+	for _, path := range []string{cachePath, cachePath + digestFileSuffix} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			p.logger.Warn("error removing cached rootfs", "reason", err, "cache-path", path)
+		}
+	}
+
+	p.logger.Debug("rootfs deleted", "rootfs-id", rootfsID)
+
+	return result, nil
+}
+
+// rootfsManifest fetches the manifest of the rootfs tag, ErrRootfsNotFound if the tag does not exist.
+func (p *provider) rootfsManifest(repository, version string) (*manifest, error) {
+	m, _, err := p.client.getManifest(repository, version)
+	if err != nil {
+		if _, ok := err.(*errorNotFound); ok {
+			return nil, errors.Wrapf(storage.ErrRootfsNotFound, "rootfs %s:%s", repository, version)
+		}
+		return nil, errors.Wrap(err, "failed fetching rootfs manifest")
+	}
+	return m, nil
+}
+
+// rootfsMetadata fetches the metadata from the config of the rootfs manifest.
+// Artifacts pushed by other tools may have no firebuild config, the metadata is empty then.
+func (p *provider) rootfsMetadata(repository string, m *manifest) (map[string]interface{}, error) {
+	metadata := map[string]interface{}{}
+	if m.Config.MediaType != rootfsConfigMediaType {
+		p.logger.Debug("rootfs without metadata", "repository", repository)
+		return metadata, nil
+	}
+	buffer := bytes.NewBuffer([]byte{})
+	if err := p.client.getBlob(repository, m.Config.Digest, buffer); err != nil {
+		return nil, errors.Wrap(err, "failed fetching rootfs metadata")
+	}
+	if err := json.Unmarshal(buffer.Bytes(), &metadata); err != nil {
+		return nil, errors.Wrap(err, "failed decoding rootfs metadata")
+	}
+	return metadata, nil
+}
+
+// pushMetadata pushes the metadata as the rootfs config blob.
+func (p *provider) pushMetadata(repository string, metadata interface{}) (descriptor, error) {
+	metadataJSONBytes, err := utils.CanonicalJSONIndent(&metadata, "", "  ")
+	if err != nil {
+		return descriptor{}, errors.Wrap(err, "failed serializing rootfs metadata")
+	}
+	config := descriptor{
+		MediaType: rootfsConfigMediaType,
+		Digest:    sha256Digest(metadataJSONBytes),
+		Size:      int64(len(metadataJSONBytes)),
+	}
+	if err := p.client.uploadBlob(repository, config.Digest, bytes.NewReader(metadataJSONBytes), config.Size); err != nil {
+		return descriptor{}, err
+	}
+	return config, nil
+}
+
+// pushFile pushes the file as a blob, unless the repository already has the blob.
+func (p *provider) pushFile(repository string, blob descriptor, localPath string) error {
+	exists, err := p.client.blobExists(repository, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		p.logger.Debug("blob exists, skipping push", "repository", repository, "digest", blob.Digest)
+		return nil
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return p.client.uploadBlob(repository, blob.Digest, file, blob.Size)
+}
+
+// copyBlob copies the blob between the repositories of the registry.
+func (p *provider) copyBlob(sourceRepository, repository string, blob descriptor) error {
+	exists, err := p.client.blobExists(repository, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	mounted, err := p.client.mountBlob(repository, sourceRepository, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		return nil
+	}
+	p.logger.Debug("registry did not mount blob, streaming", "repository", repository, "source-repository", sourceRepository, "digest", blob.Digest)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(p.client.getBlob(sourceRepository, blob.Digest, writer))
+	}()
+	defer reader.Close()
+	return p.client.uploadBlob(repository, blob.Digest, reader, blob.Size)
+}
+
+// fetchToCache pulls the blob to the local cache path, unless the cached
+// file has the digest of the blob.
+func (p *provider) fetchToCache(repository string, blob descriptor, cachePath string) error {
+	if _, statErr := utils.CheckIfExistsAndIsRegular(cachePath); statErr == nil {
+		if cachedDigest, readErr := ioutil.ReadFile(cachePath + digestFileSuffix); readErr == nil && string(cachedDigest) == blob.Digest {
+			p.logger.Debug("using cached blob", "repository", repository, "digest", blob.Digest, "cache-path", cachePath)
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return errors.Wrap(err, "failed creating local cache directory")
+	}
+	p.logger.Debug("pulling blob", "repository", repository, "digest", blob.Digest, "cache-path", cachePath)
+	tempFile, err := ioutil.TempFile(filepath.Dir(cachePath), filepath.Base(cachePath)+".download-")
+	if err != nil {
+		return errors.Wrap(err, "failed creating download file")
+	}
+	// the temp file is renamed once complete, this is a noop then:
+	defer os.Remove(tempFile.Name())
+	err = p.client.getBlob(repository, blob.Digest, tempFile)
+	if closeErr := tempFile.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// remove the stale digest before replacing the file, a failure in between must not leave a matching digest behind:
+	os.Remove(cachePath + digestFileSuffix)
+	if err := os.Rename(tempFile.Name(), cachePath); err != nil {
+		return errors.Wrap(err, "failed moving download to local cache")
+	}
+	if err := ioutil.WriteFile(cachePath+digestFileSuffix, []byte(blob.Digest), 0644); err != nil {
+		p.logger.Warn("error writing cache digest", "reason", err, "repository", repository)
+	}
+	return nil
+}
+
+// repository returns the repository in the namespace, the rootfs of an architecture
+// is stored in the architecture repository of the image, for example org/image/arm64.
+func (p *provider) repository(elements ...string) string {
+	return strings.TrimPrefix(path.Join(append([]string{p.config.Namespace}, elements...)...), "/")
+}
+
+func (p *provider) cachePath(kind, repository string, elements ...string) string {
+	return filepath.Join(append([]string{p.config.LocalCacheRoot, kind,
+		strings.ReplaceAll(p.config.Registry, ":", "_"), filepath.FromSlash(repository)}, elements...)...)
+}
+
+func (p *provider) blobURI(repository, digest string) string {
+	return fmt.Sprintf("%s/%s@%s", p.config.Registry, repository, digest)
+}
+
+// rootfsManifest returns the manifest of the rootfs artifact. The tag is added as an annotation
+// so every tag has its own manifest, even when the tags share the metadata.
+// The attachments follow the rootfs layer.
+func rootfsManifest(config, layer descriptor, version string, attachments ...descriptor) *manifest {
+	return &manifest{
+		SchemaVersion: 2,
+		MediaType:     manifestMediaType,
+		Config:        config,
+		Layers:        append([]descriptor{layer}, attachments...),
+		Annotations:   map[string]string{annotationRefName: version},
+	}
+}
+
+// rootfsLayer returns the rootfs layer of the manifest. Artifacts pushed by other tools
+// are accepted when they have a single layer.
+func rootfsLayer(m *manifest) (descriptor, error) {
+	for _, layer := range m.Layers {
+		if layer.MediaType == rootfsLayerMediaType {
+			return layer, nil
+		}
+	}
+	if len(m.Layers) == 1 {
+		return m.Layers[0], nil
+	}
+	return descriptor{}, fmt.Errorf("rootfs layer not found in manifest with %d layers", len(m.Layers))
+}
+
+// fileDescriptor returns the digest and the size of the file.
+func fileDescriptor(path string) (descriptor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return descriptor{}, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return descriptor{}, err
+	}
+	return descriptor{
+		Digest: "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		Size:   size,
+	}, nil
+}
+
+// registryAPIHost returns the host serving the registry API,
+// Docker Hub serves the API from a different host.
+func registryAPIHost(registry string) string {
+	if containers.NormalizeRegistryHostname(registry) == containers.DockerHubRegistry {
+		return "registry-1.docker.io"
+	}
+	return registry
+}
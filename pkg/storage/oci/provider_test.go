@@ -0,0 +1,562 @@
+package oci
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+)
+
+func TestParseChallenge(t *testing.T) {
+	challenge, err := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:tests/image:pull,push"`)
+	if err != nil {
+		t.Fatal("Expected challenge, got error", err)
+	}
+	if challenge.scheme != "bearer" {
+		t.Fatalf("Unexpected scheme %q", challenge.scheme)
+	}
+	expected := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:tests/image:pull,push",
+	}
+	for k, v := range expected {
+		if challenge.params[k] != v {
+			t.Fatalf("Expected %s %q, got %q", k, v, challenge.params[k])
+		}
+	}
+	challenge, err = parseChallenge(`Basic realm=registry`)
+	if err != nil {
+		t.Fatal("Expected challenge, got error", err)
+	}
+	if challenge.scheme != "basic" || challenge.params["realm"] != "registry" {
+		t.Fatalf("Unexpected challenge %v", challenge)
+	}
+}
+
+func TestStoreAndFetch(t *testing.T) {
+	registry := newFakeRegistry("user", "secret")
+	httpServer := httptest.NewServer(registry)
+	defer httpServer.Close()
+	registry.realm = httpServer.URL + "/token"
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("Expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := newTestProvider(t, httpServer, tempDir, "secret")
+
+	registry.putBlob("firebuild/kernels", []byte("kernel"))
+	registry.putManifest("firebuild/kernels", "vmlinux-v5.8", fmt.Sprintf(`{"schemaVersion":2,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":%q,"size":2},"layers":[{"mediaType":"application/octet-stream","digest":%q,"size":6}]}`,
+		registry.putBlob("firebuild/kernels", []byte("{}")), sha256Digest([]byte("kernel"))))
+	kernel, err := impl.FetchKernel(&storage.KernelLookup{ID: "vmlinux-v5.8"})
+	if err != nil {
+		t.Fatal("Expected kernel, got error", err)
+	}
+	assertFileContent(t, kernel.HostPath(), "kernel")
+
+	localRootfs := filepath.Join(tempDir, "rootfs")
+	if err := ioutil.WriteFile(localRootfs, []byte("rootfs"), 0644); err != nil {
+		t.Fatal("Expected rootfs file, got error", err)
+	}
+	storeResult, err := impl.StoreRootfsFile(&storage.RootfsStore{
+		LocalPath: localRootfs,
+		Metadata:  map[string]interface{}{"key": "value"},
+		Org:       "tests",
+		Image:     "image",
+		Version:   "1.0",
+	})
+	if err != nil {
+		t.Fatal("Expected rootfs to be stored, got error", err)
+	}
+	expectedLocation := fmt.Sprintf("%s/firebuild/tests/image@%s", httpServer.Listener.Addr().String(), sha256Digest([]byte("rootfs")))
+	if storeResult.RootfsLocation != expectedLocation {
+		t.Fatalf("Unexpected rootfs location %q", storeResult.RootfsLocation)
+	}
+
+	rootfs, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	if err != nil {
+		t.Fatal("Expected rootfs, got error", err)
+	}
+	assertFileContent(t, rootfs.HostPath(), "rootfs")
+	if metadata, ok := rootfs.Metadata().(map[string]interface{}); !ok || metadata["key"] != "value" {
+		t.Fatalf("Unexpected rootfs metadata %v", rootfs.Metadata())
+	}
+	// the stored rootfs is already in the cache:
+	if registry.blobGets(sha256Digest([]byte("rootfs"))) != 0 {
+		t.Fatal("Expected the stored rootfs to be served from the local cache")
+	}
+
+	// a changed rootfs is pulled again:
+	if err := ioutil.WriteFile(localRootfs, []byte("rootfs-changed"), 0644); err != nil {
+		t.Fatal("Expected rootfs file, got error", err)
+	}
+	otherImpl := newTestProvider(t, httpServer, filepath.Join(tempDir, "other"), "secret")
+	if _, err := otherImpl.StoreRootfsFile(&storage.RootfsStore{
+		LocalPath: localRootfs,
+		Metadata:  map[string]interface{}{"key": "changed"},
+		Org:       "tests",
+		Image:     "image",
+		Version:   "1.0",
+	}); err != nil {
+		t.Fatal("Expected rootfs to be stored, got error", err)
+	}
+	rootfs, err = impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	if err != nil {
+		t.Fatal("Expected rootfs, got error", err)
+	}
+	assertFileContent(t, rootfs.HostPath(), "rootfs-changed")
+	metadata, err := impl.FetchRootfsMetadata(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	if err != nil {
+		t.Fatal("Expected rootfs metadata, got error", err)
+	}
+	if metadata.(map[string]interface{})["key"] != "changed" {
+		t.Fatalf("Unexpected rootfs metadata %v", metadata)
+	}
+
+	if _, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "2.0"}); !errors.Is(err, storage.ErrRootfsNotFound) {
+		t.Fatal("Expected a missing rootfs to fail with not found, got", err)
+	}
+
+	invalidImpl := newTestProvider(t, httpServer, tempDir, "invalid")
+	if _, err := invalidImpl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"}); err == nil {
+		t.Fatal("Expected invalid credentials to fail")
+	}
+}
+
+func TestTagAndDeleteRootfs(t *testing.T) {
+	registry := newFakeRegistry("user", "secret")
+	httpServer := httptest.NewServer(registry)
+	defer httpServer.Close()
+	registry.realm = httpServer.URL + "/token"
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("Expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := newTestProvider(t, httpServer, tempDir, "secret")
+
+	localRootfs := filepath.Join(tempDir, "rootfs")
+	if err := ioutil.WriteFile(localRootfs, []byte("rootfs"), 0644); err != nil {
+		t.Fatal("Expected rootfs file, got error", err)
+	}
+	if _, err := impl.StoreRootfsFile(&storage.RootfsStore{
+		LocalPath: localRootfs,
+		Metadata:  map[string]interface{}{"Tag": "tests/image:1.0"},
+		Org:       "tests",
+		Image:     "image",
+		Version:   "1.0",
+	}); err != nil {
+		t.Fatal("Expected rootfs to be stored, got error", err)
+	}
+
+	for _, mount := range []bool{true, false} {
+		registry.mount = mount
+		image := fmt.Sprintf("mount-%v", mount)
+		tagInput := &storage.RootfsTag{
+			Source:   &storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"},
+			Metadata: map[string]interface{}{"Tag": "tests/" + image + ":latest"},
+			Org:      "tests",
+			Image:    image,
+			Version:  "latest",
+		}
+		if _, err := impl.TagRootfs(tagInput); err != nil {
+			t.Fatal("Expected rootfs to be tagged, got error", err)
+		}
+		metadata, err := impl.FetchRootfsMetadata(&storage.RootfsLookup{Org: "tests", Image: image, Version: "latest"})
+		if err != nil {
+			t.Fatal("Expected rootfs metadata, got error", err)
+		}
+		if metadata.(map[string]interface{})["Tag"] != "tests/"+image+":latest" {
+			t.Fatalf("Unexpected rootfs metadata %v", metadata)
+		}
+		if _, err := impl.TagRootfs(tagInput); !errors.Is(err, storage.ErrRootfsExists) {
+			t.Fatal("Expected existing target to fail without overwrite, got", err)
+		}
+		tagInput.Overwrite = true
+		if _, err := impl.TagRootfs(tagInput); err != nil {
+			t.Fatal("Expected rootfs to be tagged with overwrite, got error", err)
+		}
+	}
+
+	// a tag in the same repository with the same metadata:
+	if _, err := impl.TagRootfs(&storage.RootfsTag{
+		Source:   &storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"},
+		Metadata: map[string]interface{}{"Tag": "tests/image:1.0"},
+		Org:      "tests",
+		Image:    "image",
+		Version:  "latest",
+	}); err != nil {
+		t.Fatal("Expected rootfs to be tagged, got error", err)
+	}
+
+	rootfs, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	if err != nil {
+		t.Fatal("Expected rootfs, got error", err)
+	}
+	if _, err := impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"}); err != nil {
+		t.Fatal("Expected rootfs to be deleted, got error", err)
+	}
+	if _, err := os.Stat(rootfs.HostPath()); !os.IsNotExist(err) {
+		t.Fatal("Expected the cached rootfs to be removed, got", err)
+	}
+	if _, err := impl.DeleteRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"}); !errors.Is(err, storage.ErrRootfsNotFound) {
+		t.Fatal("Expected a missing rootfs to fail with not found, got", err)
+	}
+	// the other tag of the repository is not deleted:
+	if _, err := impl.FetchRootfsMetadata(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "latest"}); err != nil {
+		t.Fatal("Expected the other tag to exist, got error", err)
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	registry := newFakeRegistry("user", "secret")
+	httpServer := httptest.NewServer(registry)
+	defer httpServer.Close()
+	registry.realm = httpServer.URL + "/token"
+
+	tempDir := t.TempDir()
+	impl := newTestProvider(t, httpServer, tempDir, "secret")
+	// small chunks to exercise the chunked upload:
+	impl.(*provider).client.chunkSize = 4
+
+	localRootfs := filepath.Join(tempDir, "rootfs")
+	if err := ioutil.WriteFile(localRootfs, []byte("chunked-rootfs"), 0644); err != nil {
+		t.Fatal("Expected rootfs file, got error", err)
+	}
+	// a failed chunk is sent again:
+	registry.chunkFailures = 1
+	if _, err := impl.StoreRootfsFile(&storage.RootfsStore{
+		LocalPath: localRootfs,
+		Metadata:  map[string]interface{}{},
+		Org:       "tests",
+		Image:     "image",
+		Version:   "1.0",
+	}); err != nil {
+		t.Fatal("Expected rootfs to be stored, got error", err)
+	}
+	if registry.blobs["firebuild/tests/image@"+sha256Digest([]byte("chunked-rootfs"))] == nil {
+		t.Fatal("Expected the chunks to store the whole rootfs")
+	}
+	if registry.patches < 4 {
+		t.Fatalf("Expected the rootfs to be uploaded in at least 4 chunks, got %d", registry.patches)
+	}
+	if len(registry.chunks) != 0 {
+		t.Fatal("Expected no chunked uploads left in progress", registry.chunks)
+	}
+
+	rootfs, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	if err != nil {
+		t.Fatal("Expected rootfs, got error", err)
+	}
+	assertFileContent(t, rootfs.HostPath(), "chunked-rootfs")
+}
+
+func newTestProvider(t *testing.T, httpServer *httptest.Server, tempDir, password string) storage.Provider {
+	t.Helper()
+	impl := New(hclog.Default())
+	if err := impl.Configure(map[string]interface{}{
+		"docker-config":    filepath.Join(tempDir, "docker-config.json"),
+		"insecure":         "true",
+		"local-cache-root": filepath.Join(tempDir, "cache"),
+		"namespace":        "firebuild",
+		"password":         password,
+		"registry":         httpServer.Listener.Addr().String(),
+		"username":         "user",
+		// the profile values are strings:
+		"dial-timeout":            "5s",
+		"response-header-timeout": "10s",
+	}); err != nil {
+		t.Fatal("Expected provider to be configured, got error", err)
+	}
+	return impl
+}
+
+func assertFileContent(t *testing.T, path, expected string) {
+	t.Helper()
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("Expected file to be readable, got error", err)
+	}
+	if string(bytes) != expected {
+		t.Fatalf("Expected file content %q, got %q", expected, string(bytes))
+	}
+}
+
+var fakeRegistryPath = regexp.MustCompile("^/v2/(.+)/(manifests|blobs)/([^/]+)/?$")
+
+// fakeRegistry is an in memory registry with bearer token authentication.
+type fakeRegistry struct {
+	sync.Mutex
+	username string
+	password string
+	realm    string
+	mount    bool
+
+	blobs     map[string][]byte
+	getCounts map[string]int
+	manifests map[string][]byte
+	uploads   int
+	// chunks are the data of the chunked uploads in progress by upload number:
+	chunks map[string][]byte
+	// chunkFailures fails the next chunk requests:
+	chunkFailures int
+	patches       int
+}
+
+func newFakeRegistry(username, password string) *fakeRegistry {
+	return &fakeRegistry{
+		username:  username,
+		password:  password,
+		mount:     true,
+		blobs:     map[string][]byte{},
+		chunks:    map[string][]byte{},
+		getCounts: map[string]int{},
+		manifests: map[string][]byte{},
+	}
+}
+
+func (r *fakeRegistry) putBlob(repository string, data []byte) string {
+	r.Lock()
+	defer r.Unlock()
+	digest := sha256Digest(data)
+	r.blobs[repository+"@"+digest] = data
+	return digest
+}
+
+func (r *fakeRegistry) putManifest(repository, reference string, data string) {
+	r.Lock()
+	defer r.Unlock()
+	r.manifests[repository+":"+reference] = []byte(data)
+	r.manifests[repository+"@"+sha256Digest([]byte(data))] = []byte(data)
+}
+
+func (r *fakeRegistry) blobGets(digest string) int {
+	r.Lock()
+	defer r.Unlock()
+	return r.getCounts[digest]
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if username, password, ok := req.BasicAuth(); !ok || username != r.username || password != r.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(fmt.Sprintf(`{"token":"token-%s"}`, strings.Join(req.URL.Query()["scope"], "|"))))
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer token-") {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q,service="fake"`, r.realm))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if req.URL.Path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/upload/") {
+		r.serveUpload(w, req)
+		return
+	}
+	match := fakeRegistryPath.FindStringSubmatch(req.URL.Path)
+	if match == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	repository, kind, reference := match[1], match[2], match[3]
+	if kind == "blobs" && reference == "uploads" {
+		r.serveUploadStart(w, req, repository)
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if kind == "manifests" {
+		key := repository + ":" + reference
+		if strings.HasPrefix(reference, "sha256:") {
+			key = repository + "@" + reference
+		}
+		switch req.Method {
+		case http.MethodPut:
+			data, _ := ioutil.ReadAll(req.Body)
+			r.manifests[key] = data
+			r.manifests[repository+"@"+sha256Digest(data)] = data
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			data, ok := r.manifests[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			for k, v := range r.manifests {
+				if strings.HasPrefix(k, repository+":") && string(v) == string(data) {
+					delete(r.manifests, k)
+				}
+			}
+			delete(r.manifests, key)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			data, ok := r.manifests[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", sha256Digest(data))
+			w.WriteHeader(http.StatusOK)
+			if req.Method == http.MethodGet {
+				w.Write(data)
+			}
+		}
+		return
+	}
+	data, ok := r.blobs[repository+"@"+reference]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		r.getCounts[reference]++
+		w.Write(data)
+	}
+}
+
+func (r *fakeRegistry) serveUploadStart(w http.ResponseWriter, req *http.Request, repository string) {
+	r.Lock()
+	defer r.Unlock()
+	if mount := req.URL.Query().Get("mount"); mount != "" && r.mount {
+		if data, ok := r.blobs[req.URL.Query().Get("from")+"@"+mount]; ok {
+			r.blobs[repository+"@"+mount] = data
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+	}
+	r.uploads++
+	w.Header().Set("Location", fmt.Sprintf("/upload/%d/%s", r.uploads, repository))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (r *fakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/upload/"), "/", 2)
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil || len(parts) != 2 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if req.Method == http.MethodPatch {
+		if r.chunkFailures > 0 {
+			r.chunkFailures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		uploaded := r.chunks[parts[0]]
+		if req.Header.Get("Content-Range") != fmt.Sprintf("%d-%d", len(uploaded), len(uploaded)+len(data)-1) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		r.chunks[parts[0]] = append(uploaded, data...)
+		r.patches++
+		w.Header().Set("Location", req.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	data = append(r.chunks[parts[0]], data...)
+	delete(r.chunks, parts[0])
+	digest := req.URL.Query().Get("digest")
+	if digest != sha256Digest(data) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.blobs[parts[1]+"@"+digest] = data
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TestRootfsAttachments(t *testing.T) {
+	registry := newFakeRegistry("user", "secret")
+	httpServer := httptest.NewServer(registry)
+	defer httpServer.Close()
+	registry.realm = httpServer.URL + "/token"
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("Expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	impl := newTestProvider(t, httpServer, tempDir, "secret")
+
+	localRootfs := filepath.Join(tempDir, "rootfs")
+	localAttachment := filepath.Join(tempDir, "deploy.json")
+	for path, content := range map[string]string{localRootfs: "rootfs", localAttachment: `{"replicas":3}`} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal("Expected file, got error", err)
+		}
+	}
+	if _, err := impl.StoreRootfsFile(&storage.RootfsStore{
+		Attachments: map[string]string{"deploy.json": localAttachment},
+		LocalPath:   localRootfs,
+		Metadata:    map[string]interface{}{"key": "value"},
+		Org:         "tests",
+		Image:       "image",
+		Version:     "1.0",
+	}); err != nil {
+		t.Fatal("Expected rootfs to be stored, got error", err)
+	}
+
+	// the attachment layer does not affect the rootfs:
+	rootfs, err := impl.FetchRootfs(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"})
+	if err != nil {
+		t.Fatal("Expected rootfs, got error", err)
+	}
+	assertFileContent(t, rootfs.HostPath(), "rootfs")
+
+	buffer := bytes.NewBuffer([]byte{})
+	if err := impl.FetchRootfsAttachment(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"}, "deploy.json", buffer); err != nil {
+		t.Fatal("Expected attachment, got error", err)
+	}
+	if buffer.String() != `{"replicas":3}` {
+		t.Fatalf("Unexpected attachment content %q", buffer.String())
+	}
+	if err := impl.FetchRootfsAttachment(&storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"}, "other.json", buffer); !errors.Is(err, storage.ErrAttachmentNotFound) {
+		t.Fatal("Expected a missing attachment to fail with not found, got", err)
+	}
+
+	// the tag in another repository has the attachment:
+	if _, err := impl.TagRootfs(&storage.RootfsTag{
+		Source:   &storage.RootfsLookup{Org: "tests", Image: "image", Version: "1.0"},
+		Metadata: map[string]interface{}{"key": "value"},
+		Org:      "tests",
+		Image:    "other",
+		Version:  "latest",
+	}); err != nil {
+		t.Fatal("Expected rootfs to be tagged, got error", err)
+	}
+	buffer.Reset()
+	if err := impl.FetchRootfsAttachment(&storage.RootfsLookup{Org: "tests", Image: "other", Version: "latest"}, "deploy.json", buffer); err != nil {
+		t.Fatal("Expected attachment of the tag, got error", err)
+	}
+	if buffer.String() != `{"replicas":3}` {
+		t.Fatalf("Unexpected attachment content %q", buffer.String())
+	}
+}
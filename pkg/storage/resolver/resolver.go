@@ -5,10 +5,15 @@ import (
 
 	"github.com/combust-labs/firebuild/pkg/storage"
 	"github.com/combust-labs/firebuild/pkg/storage/directory"
+	"github.com/combust-labs/firebuild/pkg/storage/oci"
+	"github.com/combust-labs/firebuild/pkg/storage/s3"
+	"github.com/combust-labs/firebuild/pkg/utils"
 	"github.com/hashicorp/go-hclog"
 	"github.com/pkg/errors"
 
 	directoryFlags "github.com/combust-labs/firebuild/pkg/storage/directory/flags"
+	ociFlags "github.com/combust-labs/firebuild/pkg/storage/oci/flags"
+	s3Flags "github.com/combust-labs/firebuild/pkg/storage/s3/flags"
 	"github.com/spf13/pflag"
 )
 
@@ -17,12 +22,18 @@ var (
 	StorageProvider = ""
 	// StorageDirectoryFlags provides the flags for the directory storage.
 	StorageDirectoryFlags = directoryFlags.New()
+	// StorageOCIFlags provides the flags for the OCI registry storage.
+	StorageOCIFlags = ociFlags.New()
+	// StorageS3Flags provides the flags for the S3 storage.
+	StorageS3Flags = s3Flags.New()
 )
 
 // AddStorageFlags sets up storage provider flags.
 func AddStorageFlags(set *pflag.FlagSet) {
-	set.StringVar(&StorageProvider, "storage-provider", "", "Storage provider to use")
+	set.StringVar(&StorageProvider, "storage-provider", "", "Storage provider to use: directory, oci or s3")
 	set.AddFlagSet(StorageDirectoryFlags.GetFlags())
+	set.AddFlagSet(StorageOCIFlags.GetFlags())
+	set.AddFlagSet(StorageS3Flags.GetFlags())
 }
 
 // Resolver resolves the storage resolver and configures the resolved provider.
@@ -31,11 +42,13 @@ type Resolver interface {
 	GetStorageImplWithProvider(logger hclog.Logger, provider string) (storage.Provider, error)
 	ResolveProvider(logger hclog.Logger, provider string, configProvider func() storage.FlagProvider) (storage.Provider, error)
 	WithConfigurationOverride(map[string]interface{}) Resolver
+	WithRetryConfig(utils.RetryConfig) Resolver
 	WithTypeOverride(string) Resolver
 }
 
 type defaultResolver struct {
 	extraConfig  map[string]interface{}
+	retryConfig  utils.RetryConfig
 	typeOverride string
 }
 
@@ -43,6 +56,7 @@ type defaultResolver struct {
 func NewDefaultResolver() Resolver {
 	return &defaultResolver{
 		extraConfig: map[string]interface{}{},
+		retryConfig: utils.DefaultRetryConfig(),
 	}
 }
 
@@ -58,6 +72,10 @@ func (r *defaultResolver) GetStorageImpl(logger hclog.Logger) (storage.Provider,
 		switch provider {
 		case "directory":
 			return StorageDirectoryFlags
+		case "oci":
+			return StorageOCIFlags
+		case "s3":
+			return StorageS3Flags
 		default:
 			return nil
 		}
@@ -70,6 +88,10 @@ func (r *defaultResolver) GetStorageImplWithProvider(logger hclog.Logger, provid
 		switch provider {
 		case "directory":
 			return StorageDirectoryFlags
+		case "oci":
+			return StorageOCIFlags
+		case "s3":
+			return StorageS3Flags
 		default:
 			return nil
 		}
@@ -82,6 +104,10 @@ func (r *defaultResolver) ResolveProvider(logger hclog.Logger, provider string,
 	switch provider {
 	case "directory":
 		impl = directory.New(logger)
+	case "oci":
+		impl = oci.New(logger)
+	case "s3":
+		impl = s3.New(logger)
 	}
 	if impl == nil {
 		return impl, fmt.Errorf("provider %s not known", provider)
@@ -90,10 +116,12 @@ func (r *defaultResolver) ResolveProvider(logger hclog.Logger, provider string,
 	for k, v := range r.extraConfig {
 		flagConfig[k] = v
 	}
+	// the remote storage providers retry the failed requests:
+	flagConfig["retry"] = r.retryConfig
 	if err := impl.Configure(flagConfig); err != nil {
 		return impl, errors.Wrap(err, "failed configuring provider")
 	}
-	return impl, nil
+	return storage.WithArchitecture(impl), nil
 }
 
 // WithConfigurationOverride adds properties to the configuration.
@@ -104,6 +132,12 @@ func (r *defaultResolver) WithConfigurationOverride(input map[string]interface{}
 	return r
 }
 
+// WithRetryConfig sets the retry configuration of the remote storage requests.
+func (r *defaultResolver) WithRetryConfig(input utils.RetryConfig) Resolver {
+	r.retryConfig = input
+	return r
+}
+
 // WithTypeOverride overrides the provider type to resolve.
 func (r *defaultResolver) WithTypeOverride(input string) Resolver {
 	r.typeOverride = input
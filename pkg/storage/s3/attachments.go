@@ -0,0 +1,134 @@
+package s3
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// FetchRootfsAttachment writes the contents of the named attachment of a root file system to the writer.
+// The attachment is not cached locally.
+func (p *provider) FetchRootfsAttachment(q *storage.RootfsLookup, name string, writer io.Writer) error {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	p.logger.Debug("looking up rootfs attachment", "rootfs-id", rootfsID, "attachment", name)
+	if _, err := p.client.headObject(p.rootfsKey(q.Org, q.Image, q.Version, q.Arch, naming.RootfsFileName)); err != nil {
+		p.logger.Error("error looking up rootfs", "reason", err, "rootfs-id", rootfsID)
+		return rootfsLookupError(err, rootfsID, "failed resolving rootfs")
+	}
+	// the name must not escape the attachments prefix:
+	if name == "" || path.Base(name) != name {
+		return errors.Wrapf(storage.ErrAttachmentNotFound, "attachment %q of rootfs %s", name, rootfsID)
+	}
+	attachmentKey := p.attachmentKey(q.Org, q.Image, q.Version, q.Arch, name)
+	if _, err := p.client.getObject(attachmentKey, writer); err != nil {
+		if _, ok := err.(*errorObjectNotFound); ok {
+			return errors.Wrapf(storage.ErrAttachmentNotFound, "attachment %q of rootfs %s", name, rootfsID)
+		}
+		p.logger.Error("error fetching rootfs attachment", "reason", err, "rootfs-id", rootfsID, "key", attachmentKey)
+		return errors.Wrap(err, "failed fetching rootfs attachment")
+	}
+	return nil
+}
+
+// storeAttachments uploads the attachments of the tag and deletes the previously stored attachments not given.
+func (p *provider) storeAttachments(org, image, version, arch string, attachments map[string]string) error {
+	for name, localPath := range attachments {
+		if err := p.putFile(p.attachmentKey(org, image, version, arch, name), localPath); err != nil {
+			return errors.Wrapf(err, "failed uploading attachment %q", name)
+		}
+	}
+	return p.deleteAttachments(org, image, version, arch, func(name string) bool {
+		_, ok := attachments[name]
+		return !ok
+	})
+}
+
+// copyAttachments replaces the attachments of the target tag with the copies of the attachments of the source tag.
+func (p *provider) copyAttachments(source *storage.RootfsLookup, org, image, version, arch string) error {
+	names, err := p.attachmentNames(source.Org, source.Image, source.Version, source.Arch)
+	if err != nil {
+		return err
+	}
+	copied := map[string]bool{}
+	for _, name := range names {
+		if err := p.client.copyObject(p.attachmentKey(source.Org, source.Image, source.Version, source.Arch, name),
+			p.attachmentKey(org, image, version, arch, name)); err != nil {
+			return errors.Wrapf(err, "failed copying attachment %q", name)
+		}
+		copied[name] = true
+	}
+	return p.deleteAttachments(org, image, version, arch, func(name string) bool {
+		return !copied[name]
+	})
+}
+
+// deleteAttachments deletes the attachments of the tag selected by the filter.
+func (p *provider) deleteAttachments(org, image, version, arch string, filter func(string) bool) error {
+	names, err := p.attachmentNames(org, image, version, arch)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !filter(name) {
+			continue
+		}
+		if err := p.client.deleteObject(p.attachmentKey(org, image, version, arch, name)); err != nil {
+			return errors.Wrapf(err, "failed deleting attachment %q", name)
+		}
+	}
+	return nil
+}
+
+// attachmentsSize returns the total size of the attachments of the tag.
+func (p *provider) attachmentsSize(org, image, version, arch string) (int64, error) {
+	names, err := p.attachmentNames(org, image, version, arch)
+	if err != nil {
+		return 0, err
+	}
+	size := int64(0)
+	for _, name := range names {
+		info, err := p.client.headObject(p.attachmentKey(org, image, version, arch, name))
+		if err != nil {
+			return 0, err
+		}
+		size = size + info.ContentLength
+	}
+	return size, nil
+}
+
+func (p *provider) attachmentNames(org, image, version, arch string) ([]string, error) {
+	prefix := p.attachmentKey(org, image, version, arch, "") + "/"
+	keys, err := p.client.listObjects(prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed listing attachments")
+	}
+	names := []string{}
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, prefix))
+	}
+	return names, nil
+}
+
+func (p *provider) putFile(key, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = p.client.putObject(key, file, stat.Size(), "application/octet-stream")
+	return err
+}
+
+func (p *provider) attachmentKey(org, image, version, arch, name string) string {
+	return p.rootfsKey(org, image, version, arch, path.Join(naming.AttachmentsDirectoryName, name))
+}
@@ -0,0 +1,454 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/utils"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	signingService   = "s3"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+
+	amzDateFormat   = "20060102T150405Z"
+	shortDateFormat = "20060102"
+)
+
+// emptyPayloadHash is the SHA256 of an empty request body.
+var emptyPayloadHash = hex.EncodeToString(sha256Bytes([]byte{}))
+
+// credentials are the AWS credentials used to sign the requests.
+type credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// objectInfo is the subset of the object metadata used by the provider.
+type objectInfo struct {
+	ContentLength int64
+	ETag          string
+}
+
+// listBucketResult is the subset of the ListObjectsV2 response used by the provider.
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// errorObjectNotFound is returned when the object does not exist.
+type errorObjectNotFound struct {
+	Key string
+}
+
+func (e *errorObjectNotFound) Error() string {
+	return fmt.Sprintf("object %q not found", e.Key)
+}
+
+// client is a minimal S3 client, supports only the operations required by the provider.
+// Requests are signed with AWS Signature Version 4.
+type client struct {
+	bucket      string
+	credentials credentials
+	endpoint    *url.URL
+	httpClient  *http.Client
+	pathStyle   bool
+	region      string
+	now         func() time.Time
+
+	// the objects larger than a part are uploaded in parts:
+	partSize int64
+	// the objects larger than the maximum copy size are copied in parts:
+	copyPartSize int64
+	maxCopySize  int64
+}
+
+// newClient returns a client of the bucket, the requests are sent with the HTTP client.
+func newClient(bucket, region, endpoint string, creds credentials, httpClient *http.Client) (*client, error) {
+	pathStyle := true
+	if endpoint == "" {
+		// AWS endpoint, use virtual hosted style addressing:
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+		pathStyle = false
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %v", endpoint, err)
+	}
+	if endpointURL.Scheme != "http" && endpointURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q: scheme must be http or https", endpoint)
+	}
+	return &client{
+		bucket:      bucket,
+		credentials: creds,
+		endpoint:    endpointURL,
+		httpClient:  httpClient,
+		pathStyle:   pathStyle,
+		region:      region,
+		now:         time.Now,
+
+		partSize:     defaultPartSize,
+		copyPartSize: defaultCopyPartSize,
+		maxCopySize:  defaultMaxCopySize,
+	}, nil
+}
+
+// headObject returns the object info, errorObjectNotFound if the object does not exist.
+func (c *client) headObject(key string) (*objectInfo, error) {
+	request, err := c.newRequest(http.MethodHead, key, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, &errorObjectNotFound{Key: key}
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, responseError(http.MethodHead, key, response)
+	}
+	return &objectInfo{
+		ContentLength: response.ContentLength,
+		ETag:          response.Header.Get("ETag"),
+	}, nil
+}
+
+// getObject writes the object body to the writer and returns the object info,
+// errorObjectNotFound if the object does not exist.
+func (c *client) getObject(key string, writer io.Writer) (*objectInfo, error) {
+	request, err := c.newRequest(http.MethodGet, key, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, &errorObjectNotFound{Key: key}
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, responseError(http.MethodGet, key, response)
+	}
+	if _, err := io.Copy(writer, response.Body); err != nil {
+		return nil, err
+	}
+	return &objectInfo{
+		ContentLength: response.ContentLength,
+		ETag:          response.Header.Get("ETag"),
+	}, nil
+}
+
+// putObject uploads the object and returns the ETag of the stored object.
+// The payload is not signed so large files can be streamed without reading them twice.
+// The object larger than the part size is uploaded in parts, a single upload is limited to 5GB.
+// The body is read again when the request is retried.
+func (c *client) putObject(key string, body io.ReaderAt, contentLength int64, contentType string) (string, error) {
+	if contentLength > c.partSize {
+		return c.putObjectMultipart(key, body, contentLength, contentType)
+	}
+	request, err := c.newRequest(http.MethodPut, key, nil, unsignedPayload)
+	if err != nil {
+		return "", err
+	}
+	if err := utils.SetReplayableBody(request, io.NewSectionReader(body, 0, contentLength)); err != nil {
+		return "", err
+	}
+	request.ContentLength = contentLength
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if err := c.sign(request, unsignedPayload); err != nil {
+		return "", err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", responseError(http.MethodPut, key, response)
+	}
+	return response.Header.Get("ETag"), nil
+}
+
+// copyObject copies the object within the bucket. S3 copies objects
+// up to 5GB in a single operation, the larger objects are copied in parts.
+func (c *client) copyObject(sourceKey, key string) error {
+	info, err := c.headObject(sourceKey)
+	if err != nil {
+		return err
+	}
+	if info.ContentLength > c.maxCopySize {
+		return c.copyObjectMultipart(sourceKey, key, info.ContentLength)
+	}
+	request, err := c.newRequest(http.MethodPut, key, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Amz-Copy-Source", c.copySource(sourceKey))
+	if err := c.sign(request, emptyPayloadHash); err != nil {
+		return err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return &errorObjectNotFound{Key: sourceKey}
+	}
+	if response.StatusCode != http.StatusOK {
+		return responseError(http.MethodPut, key, response)
+	}
+	// the copy may fail after the response status is sent, the error is in the body then:
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(string(body), "<Error>") {
+		return fmt.Errorf("S3 copy %q to %q failed: %s", sourceKey, key, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// copySource returns the X-Amz-Copy-Source header value of the object of the bucket.
+func (c *client) copySource(sourceKey string) string {
+	return (&url.URL{Path: "/" + c.bucket + "/" + strings.TrimPrefix(sourceKey, "/")}).EscapedPath()
+}
+
+// deleteObject deletes the object. Deleting a missing object is not an error.
+func (c *client) deleteObject(key string) error {
+	request, err := c.newRequest(http.MethodDelete, key, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK {
+		return responseError(http.MethodDelete, key, response)
+	}
+	return nil
+}
+
+// listObjects returns the keys of all objects with the prefix.
+func (c *client) listObjects(prefix string) ([]string, error) {
+	keys := []string{}
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		request, err := c.newRequestWithQuery(http.MethodGet, "", query, nil, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		response, err := c.httpClient.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusOK {
+			err := responseError(http.MethodGet, prefix, response)
+			response.Body.Close()
+			return nil, err
+		}
+		result := &listBucketResult{}
+		decodeErr := xml.NewDecoder(response.Body).Decode(result)
+		response.Body.Close()
+		if decodeErr != nil {
+			return nil, fmt.Errorf("failed decoding the S3 list response: %v", decodeErr)
+		}
+		for _, content := range result.Contents {
+			keys = append(keys, content.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+func (c *client) newRequest(method, key string, body io.Reader, payloadHash string) (*http.Request, error) {
+	return c.newRequestWithQuery(method, key, nil, body, payloadHash)
+}
+
+func (c *client) newRequestWithQuery(method, key string, query url.Values, body io.Reader, payloadHash string) (*http.Request, error) {
+	objectURL := *c.endpoint
+	objectPath := "/" + strings.TrimPrefix(key, "/")
+	if c.pathStyle {
+		objectPath = "/" + c.bucket + objectPath
+	}
+	objectURL.Path = strings.TrimSuffix(objectURL.Path, "/") + objectPath
+	objectURL.RawPath = ""
+	objectURL.RawQuery = query.Encode()
+	request, err := http.NewRequest(method, objectURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if method != http.MethodPut {
+		// PUT is signed once the content headers are set:
+		if err := c.sign(request, payloadHash); err != nil {
+			return nil, err
+		}
+	}
+	return request, nil
+}
+
+// sign signs the request using AWS Signature Version 4.
+func (c *client) sign(request *http.Request, payloadHash string) error {
+	if c.credentials.AccessKeyID == "" || c.credentials.SecretAccessKey == "" {
+		return fmt.Errorf("S3 credentials not configured")
+	}
+
+	now := c.now().UTC()
+	amzDate := now.Format(amzDateFormat)
+	shortDate := now.Format(shortDateFormat)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.credentials.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", c.credentials.SessionToken)
+	}
+
+	canonicalHeaders, signedHeaders := canonicalHeaders(request)
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		canonicalURI(request.URL),
+		canonicalQuery(request.URL),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{shortDate, c.region, signingService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		signingAlgorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(sha256Bytes([]byte(canonicalRequest))),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+c.credentials.SecretAccessKey), []byte(shortDate))
+	signingKey = hmacSHA256(signingKey, []byte(c.region))
+	signingKey = hmacSHA256(signingKey, []byte(signingService))
+	signingKey = hmacSHA256(signingKey, []byte("aws4_request"))
+	signature := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, c.credentials.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+func canonicalHeaders(request *http.Request) (string, string) {
+	headers := map[string]string{
+		"host": request.URL.Host,
+	}
+	for name, values := range request.Header {
+		lowerName := strings.ToLower(name)
+		if lowerName == "host" || lowerName == "range" || lowerName == "content-type" || strings.HasPrefix(lowerName, "x-amz-") {
+			trimmed := []string{}
+			for _, value := range values {
+				trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+			}
+			headers[lowerName] = strings.Join(trimmed, ",")
+		}
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := []string{}
+	for _, name := range names {
+		lines = append(lines, name+":"+headers[name]+"\n")
+	}
+	return strings.Join(lines, ""), strings.Join(names, ";")
+}
+
+func canonicalURI(u *url.URL) string {
+	segments := strings.Split(u.Path, "/")
+	for idx, segment := range segments {
+		segments[idx] = uriEncode(segment)
+	}
+	uri := strings.Join(segments, "/")
+	if uri == "" {
+		return "/"
+	}
+	return uri
+}
+
+func canonicalQuery(u *url.URL) string {
+	query := u.Query()
+	keys := []string{}
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes the value as required by the AWS Signature Version 4.
+func uriEncode(value string) string {
+	builder := strings.Builder{}
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' {
+			builder.WriteByte(b)
+		} else {
+			builder.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return builder.String()
+}
+
+func responseError(method, key string, response *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+	if len(body) > 0 {
+		return fmt.Errorf("S3 %s %q failed with status %d: %s", method, key, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return fmt.Errorf("S3 %s %q failed with status %d", method, key, response.StatusCode)
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func sha256Bytes(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
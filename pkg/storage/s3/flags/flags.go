@@ -0,0 +1,49 @@
+package flags
+
+import (
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/spf13/pflag"
+)
+
+// FlagProvider is the S3 provider.
+type flags struct {
+	Bucket                string
+	DialTimeout           time.Duration
+	Endpoint              string
+	LocalCacheRoot        string
+	Prefix                string
+	Region                string
+	ResponseHeaderTimeout time.Duration
+}
+
+// New returns an initialized instance of the flag provider.
+func New() storage.FlagProvider {
+	return &flags{}
+}
+
+func (fp *flags) GetFlags() *pflag.FlagSet {
+	set := &pflag.FlagSet{}
+	set.StringVar(&fp.Bucket, "storage-provider.s3.bucket", "", "Name of the S3 bucket of the kernel and rootfs storage")
+	set.DurationVar(&fp.DialTimeout, "storage-provider.s3.dial-timeout", utils.DefaultHTTPDialTimeout, "Timeout of connecting to the S3 endpoint")
+	set.StringVar(&fp.Endpoint, "storage-provider.s3.endpoint", "", "Custom S3 endpoint URL, for example MinIO, uses path style addressing; AWS regional endpoint if empty")
+	set.StringVar(&fp.LocalCacheRoot, "storage-provider.s3.local-cache-root", "/var/lib/firebuild/s3-cache", "Full path to the local directory caching the downloaded kernels and rootfs files")
+	set.StringVar(&fp.Prefix, "storage-provider.s3.prefix", "", "Key prefix of the kernel and rootfs storage")
+	set.StringVar(&fp.Region, "storage-provider.s3.region", "us-east-1", "Region of the S3 bucket")
+	set.DurationVar(&fp.ResponseHeaderTimeout, "storage-provider.s3.response-header-timeout", utils.DefaultHTTPResponseHeaderTimeout, "Timeout of waiting for the S3 response once the request is sent, the transfer itself is not limited")
+	return set
+}
+
+func (fp *flags) GetInitializedConfiguration() map[string]interface{} {
+	return map[string]interface{}{
+		"bucket":                  fp.Bucket,
+		"dial-timeout":            fp.DialTimeout,
+		"endpoint":                fp.Endpoint,
+		"local-cache-root":        fp.LocalCacheRoot,
+		"prefix":                  fp.Prefix,
+		"region":                  fp.Region,
+		"response-header-timeout": fp.ResponseHeaderTimeout,
+	}
+}
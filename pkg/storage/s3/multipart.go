@@ -0,0 +1,260 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/combust-labs/firebuild/pkg/utils"
+)
+
+const (
+	// maxPartCount is the maximum number of parts of a multipart upload.
+	maxPartCount = 10000
+	// defaultPartSize is the minimum part size of the multipart uploads,
+	// the objects larger than a part are uploaded in parts.
+	defaultPartSize = 64 * 1024 * 1024
+	// defaultMaxCopySize is the maximum size of an object copied in a single operation,
+	// the larger objects are copied in parts.
+	defaultMaxCopySize = 5 * 1024 * 1024 * 1024
+	// defaultCopyPartSize is the minimum part size of the multipart copies.
+	defaultCopyPartSize = 1024 * 1024 * 1024
+)
+
+// completedPart is the uploaded part listed by the CompleteMultipartUpload request.
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completeMultipartUploadResult struct {
+	ETag string `xml:"ETag"`
+}
+
+type copyPartResult struct {
+	ETag string `xml:"ETag"`
+}
+
+// putObjectMultipart uploads the object in parts and returns the ETag of the stored object.
+// Each part is retried on its own, the upload is aborted when a part fails.
+func (c *client) putObjectMultipart(key string, body io.ReaderAt, contentLength int64, contentType string) (string, error) {
+	uploadID, err := c.createMultipartUpload(key, contentType)
+	if err != nil {
+		return "", err
+	}
+	partSize := multipartPartSize(contentLength, c.partSize)
+	parts := []completedPart{}
+	for offset, number := int64(0), 1; offset < contentLength; offset, number = offset+partSize, number+1 {
+		length := partSize
+		if remaining := contentLength - offset; remaining < length {
+			length = remaining
+		}
+		etag, err := c.uploadPart(key, uploadID, number, io.NewSectionReader(body, offset, length), length)
+		if err != nil {
+			c.abortMultipartUpload(key, uploadID)
+			return "", err
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: etag})
+	}
+	etag, err := c.completeMultipartUpload(key, uploadID, parts)
+	if err != nil {
+		c.abortMultipartUpload(key, uploadID)
+		return "", err
+	}
+	return etag, nil
+}
+
+// copyObjectMultipart copies the object of the size within the bucket in parts.
+func (c *client) copyObjectMultipart(sourceKey, key string, size int64) error {
+	uploadID, err := c.createMultipartUpload(key, "")
+	if err != nil {
+		return err
+	}
+	partSize := multipartPartSize(size, c.copyPartSize)
+	parts := []completedPart{}
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+		last := offset + partSize - 1
+		if last >= size {
+			last = size - 1
+		}
+		etag, err := c.uploadPartCopy(sourceKey, key, uploadID, number, offset, last)
+		if err != nil {
+			c.abortMultipartUpload(key, uploadID)
+			return err
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: etag})
+	}
+	if _, err := c.completeMultipartUpload(key, uploadID, parts); err != nil {
+		c.abortMultipartUpload(key, uploadID)
+		return err
+	}
+	return nil
+}
+
+// createMultipartUpload starts the multipart upload and returns the upload ID.
+func (c *client) createMultipartUpload(key, contentType string) (string, error) {
+	request, err := c.newRequestWithQuery(http.MethodPost, key, url.Values{"uploads": []string{""}}, nil, emptyPayloadHash)
+	if err != nil {
+		return "", err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+		// the content type is signed:
+		if err := c.sign(request, emptyPayloadHash); err != nil {
+			return "", err
+		}
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", responseError(http.MethodPost, key, response)
+	}
+	result := &initiateMultipartUploadResult{}
+	if err := xml.NewDecoder(response.Body).Decode(result); err != nil {
+		return "", fmt.Errorf("failed decoding the S3 create multipart upload response: %v", err)
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("S3 create multipart upload %q returned no upload ID", key)
+	}
+	return result.UploadID, nil
+}
+
+// uploadPart uploads the part and returns its ETag, the part is read again when the request is retried.
+func (c *client) uploadPart(key, uploadID string, number int, body io.ReadSeeker, contentLength int64) (string, error) {
+	request, err := c.newRequestWithQuery(http.MethodPut, key, partQuery(uploadID, number), nil, unsignedPayload)
+	if err != nil {
+		return "", err
+	}
+	if err := utils.SetReplayableBody(request, body); err != nil {
+		return "", err
+	}
+	request.ContentLength = contentLength
+	if err := c.sign(request, unsignedPayload); err != nil {
+		return "", err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", responseError(http.MethodPut, key, response)
+	}
+	return response.Header.Get("ETag"), nil
+}
+
+// uploadPartCopy copies the byte range, first and last inclusive, of the source object as the part
+// and returns its ETag.
+func (c *client) uploadPartCopy(sourceKey, key, uploadID string, number int, first, last int64) (string, error) {
+	request, err := c.newRequestWithQuery(http.MethodPut, key, partQuery(uploadID, number), nil, emptyPayloadHash)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("X-Amz-Copy-Source", c.copySource(sourceKey))
+	request.Header.Set("X-Amz-Copy-Source-Range", fmt.Sprintf("bytes=%d-%d", first, last))
+	if err := c.sign(request, emptyPayloadHash); err != nil {
+		return "", err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return "", &errorObjectNotFound{Key: sourceKey}
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", responseError(http.MethodPut, key, response)
+	}
+	result := &copyPartResult{}
+	if err := decodeResult(response, result); err != nil {
+		return "", fmt.Errorf("S3 copy %q to %q part %d failed: %v", sourceKey, key, number, err)
+	}
+	return result.ETag, nil
+}
+
+// completeMultipartUpload completes the multipart upload and returns the ETag of the stored object.
+func (c *client) completeMultipartUpload(key, uploadID string, parts []completedPart) (string, error) {
+	body, err := xml.Marshal(&completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+	request, err := c.newRequestWithQuery(http.MethodPost, key, url.Values{"uploadId": []string{uploadID}},
+		bytes.NewReader(body), hex.EncodeToString(sha256Bytes(body)))
+	if err != nil {
+		return "", err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", responseError(http.MethodPost, key, response)
+	}
+	result := &completeMultipartUploadResult{}
+	if err := decodeResult(response, result); err != nil {
+		return "", fmt.Errorf("S3 complete multipart upload %q failed: %v", key, err)
+	}
+	return result.ETag, nil
+}
+
+// abortMultipartUpload aborts the multipart upload, errors are ignored,
+// the bucket lifecycle rules clean up the abandoned uploads.
+func (c *client) abortMultipartUpload(key, uploadID string) {
+	request, err := c.newRequestWithQuery(http.MethodDelete, key, url.Values{"uploadId": []string{uploadID}}, nil, emptyPayloadHash)
+	if err != nil {
+		return
+	}
+	if response, err := c.httpClient.Do(request); err == nil {
+		response.Body.Close()
+	}
+}
+
+// decodeResult decodes the XML result of the response. S3 may fail the operation
+// after the response status is sent, the error is in the body then.
+func decodeResult(response *http.Response, result interface{}) error {
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(string(body), "<Error>") {
+		return fmt.Errorf("%s", strings.TrimSpace(string(body)))
+	}
+	return xml.Unmarshal(body, result)
+}
+
+// multipartPartSize returns the part size of the multipart operation on an object of the size,
+// at least the minimum size and large enough to not exceed the maximum number of parts.
+func multipartPartSize(size, minimum int64) int64 {
+	if partSize := (size + maxPartCount - 1) / maxPartCount; partSize > minimum {
+		return partSize
+	}
+	return minimum
+}
+
+func partQuery(uploadID string, number int) url.Values {
+	return url.Values{
+		"partNumber": []string{strconv.Itoa(number)},
+		"uploadId":   []string{uploadID},
+	}
+}
@@ -0,0 +1,490 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/storage"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+const (
+	providerName = "s3"
+
+	kernelsKeyPrefix = "kernels"
+	rootfsKeyPrefix  = "rootfs"
+	etagFileSuffix   = ".etag"
+)
+
+type providerConfig struct {
+	AccessKeyID           string        `mapstructure:"access-key-id"`
+	Bucket                string        `mapstructure:"bucket"`
+	DialTimeout           time.Duration `mapstructure:"dial-timeout"`
+	Endpoint              string        `mapstructure:"endpoint"`
+	LocalCacheRoot        string        `mapstructure:"local-cache-root"`
+	Prefix                string        `mapstructure:"prefix"`
+	Region                string        `mapstructure:"region"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"response-header-timeout"`
+	SecretAccessKey       string        `mapstructure:"secret-access-key"`
+	SessionToken          string        `mapstructure:"session-token"`
+
+	Retry utils.RetryConfig `mapstructure:"retry"`
+}
+
+type provider struct {
+	client *client
+	config *providerConfig
+	logger hclog.Logger
+}
+
+// New returns a new instance of the provider.
+func New(logger hclog.Logger) storage.Provider {
+	return &provider{
+		logger: logger,
+	}
+}
+
+// Configure configures the provider. If the credentials are not given
+// in the configuration, the standard AWS environment variables are used.
+func (p *provider) Configure(mapConfig map[string]interface{}) error {
+	p.logger.Debug("configuring storage provider")
+	pConfig := &providerConfig{}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		// the profile properties are strings:
+		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+		Result:     pConfig,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed creating configuration decoder")
+	}
+	if err := decoder.Decode(mapConfig); err != nil {
+		p.logger.Error("error when decoding configuration", "reason", err)
+		return errors.Wrap(err, "failed decoding provider configuration")
+	}
+	if pConfig.Bucket == "" {
+		return fmt.Errorf("s3 storage provider: bucket is required")
+	}
+	if pConfig.Region == "" {
+		return fmt.Errorf("s3 storage provider: region is required")
+	}
+	if pConfig.LocalCacheRoot == "" {
+		return fmt.Errorf("s3 storage provider: local cache root is required")
+	}
+	creds := credentials{
+		AccessKeyID:     pConfig.AccessKeyID,
+		SecretAccessKey: pConfig.SecretAccessKey,
+		SessionToken:    pConfig.SessionToken,
+	}
+	if creds.AccessKeyID == "" && creds.SecretAccessKey == "" {
+		creds.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		creds.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		creds.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if pConfig.Retry.Attempts == 0 {
+		pConfig.Retry = utils.DefaultRetryConfig()
+	}
+	if pConfig.DialTimeout == 0 {
+		pConfig.DialTimeout = utils.DefaultHTTPDialTimeout
+	}
+	if pConfig.ResponseHeaderTimeout == 0 {
+		pConfig.ResponseHeaderTimeout = utils.DefaultHTTPResponseHeaderTimeout
+	}
+	transport := utils.NewHTTPTransport(pConfig.DialTimeout, pConfig.ResponseHeaderTimeout)
+	httpClient := &http.Client{Transport: utils.NewRetryingTransport(transport, pConfig.Retry, p.logger)}
+	s3Client, err := newClient(pConfig.Bucket, pConfig.Region, pConfig.Endpoint, creds, httpClient)
+	if err != nil {
+		p.logger.Error("error when creating S3 client", "reason", err)
+		return errors.Wrap(err, "failed creating S3 client")
+	}
+	p.client = s3Client
+	p.config = pConfig
+	p.logger.Debug("storage provider configured")
+	return nil
+}
+
+// FetchKernel fetches a Linux Kernel by ID.
+func (p *provider) FetchKernel(q *storage.KernelLookup) (storage.KernelResult, error) {
+	p.logger.Debug("looking up kernel", "kernel-id", q.ID)
+	kernelKey := p.objectKey(kernelsKeyPrefix, q.ID)
+	kernelPath := filepath.Join(p.config.LocalCacheRoot, kernelsKeyPrefix, q.ID)
+	if err := p.fetchToCache(kernelKey, kernelPath); err != nil {
+		p.logger.Error("error fetching kernel", "reason", err, "kernel-id", q.ID, "key", kernelKey)
+		return nil, errors.Wrap(err, "failed resolving kernel file")
+	}
+	// TODO: kernel metadata needs to be implemented
+	p.logger.Debug("kernel located", "kernel-id", q.ID)
+	metadata := map[string]interface{}{}
+	return &kernelResult{
+		hostPath: kernelPath,
+		metadata: metadata,
+	}, nil
+}
+
+// FetchRootfs fetches a root file system by ID.
+func (p *provider) FetchRootfs(q *storage.RootfsLookup) (storage.RootfsResult, error) {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	p.logger.Debug("looking up rootfs", "rootfs-id", rootfsID)
+	rootfsKey := p.rootfsKey(q.Org, q.Image, q.Version, q.Arch, naming.RootfsFileName)
+	rootfsPath := p.rootfsCachePath(q.Org, q.Image, q.Version, q.Arch, naming.RootfsFileName)
+	if err := p.fetchToCache(rootfsKey, rootfsPath); err != nil {
+		p.logger.Error("error fetching rootfs", "reason", err, "rootfs-id", rootfsID, "key", rootfsKey)
+		return nil, rootfsLookupError(err, rootfsID, "failed resolving rootfs file")
+	}
+	metadata := map[string]interface{}{}
+	metadataKey := p.rootfsKey(q.Org, q.Image, q.Version, q.Arch, naming.MetadataFileName)
+	metadataPath := p.rootfsCachePath(q.Org, q.Image, q.Version, q.Arch, naming.MetadataFileName)
+	if err := p.fetchToCache(metadataKey, metadataPath); err != nil {
+		if _, ok := err.(*errorObjectNotFound); !ok {
+			p.logger.Error("error fetching rootfs metadata", "reason", err, "rootfs-id", rootfsID, "key", metadataKey)
+			return nil, errors.Wrap(err, "failed fetching rootfs metadata")
+		}
+		p.logger.Debug("rootfs without metadata", "rootfs-id", rootfsID)
+	} else {
+		metadataBytes, err := ioutil.ReadFile(metadataPath)
+		if err != nil {
+			p.logger.Error("error reading rootfs metadata", "reason", err, "rootfs-id", rootfsID, "metadata-path", metadataPath)
+			return nil, errors.Wrap(err, "failed reading rootfs metadata")
+		}
+		if jsonErr := json.Unmarshal(metadataBytes, &metadata); jsonErr != nil {
+			p.logger.Error("error reading rootfs metadata as JSON", "reason", jsonErr, "rootfs-id", rootfsID, "metadata-path", metadataPath)
+			return nil, errors.Wrap(jsonErr, "failed decoding rootfs metadata")
+		}
+	}
+	p.logger.Debug("rootfs located", "rootfs-id", rootfsID)
+	return &rootfsResult{
+		hostPath: rootfsPath,
+		metadata: metadata,
+	}, nil
+}
+
+// StoreRootfsFile uploads the rootfs file, the attachments and the metadata. The local rootfs file is moved
+// to the local cache so it does not have to be downloaded again on this host.
+func (p *provider) StoreRootfsFile(input *storage.RootfsStore) (*storage.RootfsStoreResult, error) {
+	rootfsID := fmt.Sprintf("%s/%s:%s", input.Org, input.Image, input.Version)
+	result := &storage.RootfsStoreResult{
+		Provider: providerName,
+	}
+
+	p.logger.Debug("storing rootfs", "rootfs-id", rootfsID)
+
+	digest, err := storage.FileSHA256(input.LocalPath)
+	if err != nil {
+		p.logger.Error("error calculating rootfs SHA-256", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed calculating rootfs SHA-256")
+	}
+	metadata, err := storage.WithRootfsSHA256(input.Metadata, digest)
+	if err != nil {
+		p.logger.Error("error recording rootfs SHA-256", "reason", err, "rootfs-id", rootfsID)
+		return nil, err
+	}
+
+	rootfsKey := p.rootfsKey(input.Org, input.Image, input.Version, input.Arch, naming.RootfsFileName)
+	rootfsFile, err := os.Open(input.LocalPath)
+	if err != nil {
+		p.logger.Error("error opening rootfs file", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed opening rootfs file")
+	}
+	defer rootfsFile.Close()
+	rootfsStat, err := rootfsFile.Stat()
+	if err != nil {
+		p.logger.Error("error checking rootfs file", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed checking rootfs file")
+	}
+
+	p.logger.Debug("uploading rootfs", "rootfs-id", rootfsID,
+		"source", input.LocalPath,
+		"key", rootfsKey)
+	etag, err := p.client.putObject(rootfsKey, rootfsFile, rootfsStat.Size(), "application/octet-stream")
+	if err != nil {
+		p.logger.Error("error uploading rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed uploading rootfs")
+	}
+	rootfsFile.Close()
+	result.RootfsLocation = p.objectURI(rootfsKey)
+
+	cachePath := p.rootfsCachePath(input.Org, input.Image, input.Version, input.Arch, naming.RootfsFileName)
+	if moveErr := utils.MoveFile(input.LocalPath, cachePath); moveErr != nil {
+		p.logger.Warn("error moving rootfs to local cache", "reason", moveErr, "rootfs-id", rootfsID)
+	} else if etag != "" {
+		if writeErr := ioutil.WriteFile(cachePath+etagFileSuffix, []byte(etag), 0644); writeErr != nil {
+			p.logger.Warn("error writing rootfs cache ETag", "reason", writeErr, "rootfs-id", rootfsID)
+		}
+	}
+
+	if err := p.storeAttachments(input.Org, input.Image, input.Version, input.Arch, input.Attachments); err != nil {
+		p.logger.Error("error uploading rootfs attachments", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed uploading rootfs attachments")
+	}
+
+	p.logger.Debug("writing rootfs metadata", "rootfs-id", rootfsID)
+	metadataKey := p.rootfsKey(input.Org, input.Image, input.Version, input.Arch, naming.MetadataFileName)
+	metadataJSONBytes, jsonErr := utils.CanonicalJSONIndent(&metadata, "", "  ")
+	if jsonErr != nil {
+		p.logger.Error("error serialzing rootfs metadata to JSON", "reason", jsonErr, "rootfs-id", rootfsID)
+		return result, nil
+	}
+	if _, putErr := p.client.putObject(metadataKey, strings.NewReader(string(metadataJSONBytes)), int64(len(metadataJSONBytes)), "application/json"); putErr != nil {
+		p.logger.Error("error uploading rootfs metadata", "reason", putErr, "rootfs-id", rootfsID)
+		return result, nil
+	}
+	result.MetadataLocation = p.objectURI(metadataKey)
+
+	p.logger.Debug("rootfs stored", "rootfs-id", rootfsID)
+
+	return result, nil
+}
+
+// FetchRootfsMetadata fetches the metadata of a root file system by ID.
+func (p *provider) FetchRootfsMetadata(q *storage.RootfsLookup) (interface{}, error) {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	p.logger.Debug("looking up rootfs metadata", "rootfs-id", rootfsID)
+	if _, err := p.client.headObject(p.rootfsKey(q.Org, q.Image, q.Version, q.Arch, naming.RootfsFileName)); err != nil {
+		p.logger.Error("error looking up rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, rootfsLookupError(err, rootfsID, "failed resolving rootfs")
+	}
+	metadata := map[string]interface{}{}
+	metadataKey := p.rootfsKey(q.Org, q.Image, q.Version, q.Arch, naming.MetadataFileName)
+	buffer := bytes.NewBuffer([]byte{})
+	if _, err := p.client.getObject(metadataKey, buffer); err != nil {
+		if _, ok := err.(*errorObjectNotFound); ok {
+			p.logger.Debug("rootfs without metadata", "rootfs-id", rootfsID)
+			return metadata, nil
+		}
+		p.logger.Error("error fetching rootfs metadata", "reason", err, "rootfs-id", rootfsID, "key", metadataKey)
+		return nil, errors.Wrap(err, "failed fetching rootfs metadata")
+	}
+	if jsonErr := json.Unmarshal(buffer.Bytes(), &metadata); jsonErr != nil {
+		p.logger.Error("error reading rootfs metadata as JSON", "reason", jsonErr, "rootfs-id", rootfsID, "key", metadataKey)
+		return nil, errors.Wrap(jsonErr, "failed decoding rootfs metadata")
+	}
+	return metadata, nil
+}
+
+// TagRootfs creates a new tag entry pointing at the root file system of an existing tag.
+// The rootfs and the attachment objects are copied within the bucket, nothing is downloaded.
+func (p *provider) TagRootfs(input *storage.RootfsTag) (*storage.RootfsStoreResult, error) {
+	sourceID := fmt.Sprintf("%s/%s:%s", input.Source.Org, input.Source.Image, input.Source.Version)
+	rootfsID := fmt.Sprintf("%s/%s:%s", input.Org, input.Image, input.Version)
+	result := &storage.RootfsStoreResult{
+		Provider: providerName,
+	}
+
+	p.logger.Debug("tagging rootfs", "rootfs-id", rootfsID, "source-rootfs-id", sourceID)
+
+	sourceKey := p.rootfsKey(input.Source.Org, input.Source.Image, input.Source.Version, input.Source.Arch, naming.RootfsFileName)
+	rootfsKey := p.rootfsKey(input.Org, input.Image, input.Version, input.Arch, naming.RootfsFileName)
+	if sourceKey == rootfsKey {
+		return nil, fmt.Errorf("source and target rootfs are the same")
+	}
+
+	if _, err := p.client.headObject(rootfsKey); err == nil {
+		if !input.Overwrite {
+			p.logger.Error("target rootfs exists", "rootfs-id", rootfsID)
+			return nil, errors.Wrapf(storage.ErrRootfsExists, "rootfs %s", rootfsID)
+		}
+	} else if _, ok := err.(*errorObjectNotFound); !ok {
+		p.logger.Error("error looking up target rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed looking up target rootfs")
+	}
+
+	if err := p.client.copyObject(sourceKey, rootfsKey); err != nil {
+		p.logger.Error("error copying rootfs", "reason", err, "rootfs-id", rootfsID, "source-rootfs-id", sourceID)
+		return nil, rootfsLookupError(err, sourceID, "failed copying rootfs")
+	}
+	result.RootfsLocation = p.objectURI(rootfsKey)
+
+	if err := p.copyAttachments(input.Source, input.Org, input.Image, input.Version, input.Arch); err != nil {
+		p.logger.Error("error copying rootfs attachments", "reason", err, "rootfs-id", rootfsID, "source-rootfs-id", sourceID)
+		return nil, errors.Wrap(err, "failed copying rootfs attachments")
+	}
+
+	metadataKey := p.rootfsKey(input.Org, input.Image, input.Version, input.Arch, naming.MetadataFileName)
+	metadataJSONBytes, jsonErr := utils.CanonicalJSONIndent(&input.Metadata, "", "  ")
+	if jsonErr != nil {
+		p.logger.Error("error serialzing rootfs metadata to JSON", "reason", jsonErr, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(jsonErr, "failed serializing rootfs metadata")
+	}
+	if _, putErr := p.client.putObject(metadataKey, bytes.NewReader(metadataJSONBytes), int64(len(metadataJSONBytes)), "application/json"); putErr != nil {
+		p.logger.Error("error uploading rootfs metadata", "reason", putErr, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(putErr, "failed uploading rootfs metadata")
+	}
+	result.MetadataLocation = p.objectURI(metadataKey)
+
+	p.logger.Debug("rootfs tagged", "rootfs-id", rootfsID, "source-rootfs-id", sourceID)
+
+	return result, nil
+}
+
+// DeleteRootfs deletes the rootfs, attachment and metadata objects of a tag and the locally cached copies.
+func (p *provider) DeleteRootfs(q *storage.RootfsLookup) (*storage.RootfsDeleteResult, error) {
+	rootfsID := fmt.Sprintf("%s/%s:%s", q.Org, q.Image, q.Version)
+	result := &storage.RootfsDeleteResult{
+		Provider: providerName,
+	}
+
+	p.logger.Debug("deleting rootfs", "rootfs-id", rootfsID)
+
+	rootfsKey := p.rootfsKey(q.Org, q.Image, q.Version, q.Arch, naming.RootfsFileName)
+	rootfsInfo, err := p.client.headObject(rootfsKey)
+	if err != nil {
+		if _, ok := err.(*errorObjectNotFound); ok {
+			return nil, errors.Wrapf(storage.ErrRootfsNotFound, "rootfs %s", rootfsID)
+		}
+		p.logger.Error("error looking up rootfs", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed resolving rootfs")
+	}
+	result.FreedBytes = rootfsInfo.ContentLength
+
+	metadataKey := p.rootfsKey(q.Org, q.Image, q.Version, q.Arch, naming.MetadataFileName)
+	if metadataInfo, err := p.client.headObject(metadataKey); err == nil {
+		result.FreedBytes = result.FreedBytes + metadataInfo.ContentLength
+	}
+
+	if attachmentsSize, err := p.attachmentsSize(q.Org, q.Image, q.Version, q.Arch); err == nil {
+		result.FreedBytes = result.FreedBytes + attachmentsSize
+	}
+	if err := p.deleteAttachments(q.Org, q.Image, q.Version, q.Arch, func(string) bool { return true }); err != nil {
+		p.logger.Error("error deleting rootfs attachments", "reason", err, "rootfs-id", rootfsID)
+		return nil, errors.Wrap(err, "failed deleting rootfs attachments")
+	}
+
+	for _, key := range []string{metadataKey, rootfsKey} {
+		if err := p.client.deleteObject(key); err != nil {
+			p.logger.Error("error deleting object", "reason", err, "rootfs-id", rootfsID, "key", key)
+			return nil, errors.Wrap(err, "failed deleting rootfs")
+		}
+	}
+
+	for _, fileName := range []string{naming.RootfsFileName, naming.MetadataFileName} {
+		cachePath := p.rootfsCachePath(q.Org, q.Image, q.Version, q.Arch, fileName)
+		for _, path := range []string{cachePath, cachePath + etagFileSuffix} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				p.logger.Warn("error removing cached object", "reason", err, "cache-path", path)
+			}
+		}
+	}
+
+	p.logger.Debug("rootfs deleted", "rootfs-id", rootfsID, "freed-bytes", result.FreedBytes)
+
+	return result, nil
+}
+
+// ListRootfs returns the tags of the stored rootfs files.
+func (p *provider) ListRootfs() ([]*storage.RootfsLookup, error) {
+	prefix := p.objectKey(rootfsKeyPrefix) + "/"
+	keys, err := p.client.listObjects(prefix)
+	if err != nil {
+		p.logger.Error("error listing rootfs objects", "reason", err, "prefix", prefix)
+		return nil, errors.Wrap(err, "failed listing rootfs objects")
+	}
+	result := []*storage.RootfsLookup{}
+	for _, key := range keys {
+		parts := strings.Split(strings.TrimPrefix(key, prefix), "/")
+		switch {
+		case len(parts) == 4 && parts[3] == naming.RootfsFileName:
+			result = append(result, &storage.RootfsLookup{Org: parts[0], Image: parts[1], Version: parts[2]})
+		// the rootfs of an architecture, not an attachment named like the rootfs file:
+		case len(parts) == 5 && parts[4] == naming.RootfsFileName && parts[3] != naming.AttachmentsDirectoryName:
+			result = append(result, &storage.RootfsLookup{Org: parts[0], Image: parts[1], Version: parts[2], Arch: parts[3]})
+		}
+	}
+	// sorted by the tag, the keys of an architecture may sort before the rootfs key of the version:
+	sort.Slice(result, func(i, j int) bool {
+		left, right := result[i], result[j]
+		if left.Org != right.Org {
+			return left.Org < right.Org
+		}
+		if left.Image != right.Image {
+			return left.Image < right.Image
+		}
+		if left.Version != right.Version {
+			return left.Version < right.Version
+		}
+		return left.Arch < right.Arch
+	})
+	return result, nil
+}
+
+// fetchToCache downloads the object to the local cache path, unless the cached
+// file is up to date with the object.
+func (p *provider) fetchToCache(key, cachePath string) error {
+	info, err := p.client.headObject(key)
+	if err != nil {
+		return err
+	}
+	if info.ETag != "" {
+		if _, statErr := utils.CheckIfExistsAndIsRegular(cachePath); statErr == nil {
+			if cachedETag, readErr := ioutil.ReadFile(cachePath + etagFileSuffix); readErr == nil && string(cachedETag) == info.ETag {
+				p.logger.Debug("using cached object", "key", key, "cache-path", cachePath)
+				return nil
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return errors.Wrap(err, "failed creating local cache directory")
+	}
+	p.logger.Debug("downloading object", "key", key, "cache-path", cachePath)
+	tempFile, err := ioutil.TempFile(filepath.Dir(cachePath), filepath.Base(cachePath)+".download-")
+	if err != nil {
+		return errors.Wrap(err, "failed creating download file")
+	}
+	// the temp file is renamed once complete, this is a noop then:
+	defer os.Remove(tempFile.Name())
+	downloaded, err := p.client.getObject(key, tempFile)
+	if closeErr := tempFile.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// remove the stale ETag before replacing the file, a failure in between must not leave a matching ETag behind:
+	os.Remove(cachePath + etagFileSuffix)
+	if err := os.Rename(tempFile.Name(), cachePath); err != nil {
+		return errors.Wrap(err, "failed moving download to local cache")
+	}
+	if downloaded.ETag != "" {
+		if err := ioutil.WriteFile(cachePath+etagFileSuffix, []byte(downloaded.ETag), 0644); err != nil {
+			p.logger.Warn("error writing cache ETag", "reason", err, "key", key)
+		}
+	}
+	return nil
+}
+
+// rootfsLookupError returns the error wrapping ErrRootfsNotFound when the rootfs object does not exist.
+func rootfsLookupError(err error, rootfsID, message string) error {
+	if _, ok := err.(*errorObjectNotFound); ok {
+		return errors.Wrapf(storage.ErrRootfsNotFound, "rootfs %s", rootfsID)
+	}
+	return errors.Wrap(err, message)
+}
+
+func (p *provider) objectKey(elements ...string) string {
+	return strings.TrimPrefix(path.Join(append([]string{p.config.Prefix}, elements...)...), "/")
+}
+
+// rootfsKey returns the key of the rootfs file, the files of an architecture are stored under the version.
+func (p *provider) rootfsKey(org, image, version, arch, fileName string) string {
+	return p.objectKey(rootfsKeyPrefix, strings.ReplaceAll(org, "/", "_"), image, version, arch, fileName)
+}
+
+func (p *provider) rootfsCachePath(org, image, version, arch, fileName string) string {
+	return filepath.Join(p.config.LocalCacheRoot, rootfsKeyPrefix,
+		strings.ReplaceAll(org, "/", "_"), image, version, arch, fileName)
+}
+
+func (p *provider) objectURI(key string) string {
+	return fmt.Sprintf("s3://%s/%s", p.config.Bucket, key)
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/combust-labs/firebuild/pkg/utils"
+)
+
+// LatestVersion is the floating version resolved to the newest stored version of the rootfs.
+const LatestVersion = "latest"
+
+// IsFloatingVersion returns true when the version is not given or is the latest version.
+func IsFloatingVersion(version string) bool {
+	return version == "" || version == LatestVersion
+}
+
+// ResolveRootfsLookup returns the lookup with the version resolved to a stored version:
+// the latest version is resolved with ResolveLatestRootfsVersion, the version constraint
+// with ResolveRootfsVersion, an exact version is returned as is.
+func ResolveRootfsLookup(impl Provider, input *RootfsLookup) (*RootfsLookup, error) {
+	resolved := &RootfsLookup{Org: input.Org, Image: input.Image, Version: input.Version, Arch: input.Arch}
+	switch {
+	case IsFloatingVersion(input.Version):
+		version, err := ResolveLatestRootfsVersion(impl, input)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Version = version
+	case utils.IsVersionConstraint(input.Version):
+		version, err := ResolveRootfsVersion(impl, input)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Version = version
+	}
+	return resolved, nil
+}
+
+// ResolveLatestRootfsVersion returns the newest stored version of the rootfs of the lookup architecture.
+// A rootfs explicitly stored with the latest version takes precedence. Otherwise, when all stored versions
+// are semantic versions, the highest release version is resolved, else the version with the most recent
+// CreatedAtUTC of the rootfs metadata. Returns an error wrapping ErrRootfsNotFound if no version is stored.
+// The provider must be capable of listing the stored rootfs files.
+func ResolveLatestRootfsVersion(impl Provider, input *RootfsLookup) (string, error) {
+	if _, err := impl.FetchRootfsMetadata(&RootfsLookup{Org: input.Org, Image: input.Image, Version: LatestVersion, Arch: input.Arch}); err == nil {
+		return LatestVersion, nil
+	}
+	listingImpl, ok := impl.(ListingProvider)
+	if !ok {
+		return "", fmt.Errorf("storage provider does not support listing, the latest version of rootfs %s/%s can't be resolved", input.Org, input.Image)
+	}
+	items, err := listingImpl.ListRootfs()
+	if err != nil {
+		return "", err
+	}
+
+	type candidate struct {
+		version   string
+		createdAt int64
+	}
+	candidates := []*candidate{}
+	for _, version := range rootfsVersions(impl, items, input) {
+		if version == LatestVersion {
+			continue
+		}
+		md, err := impl.FetchRootfsMetadata(&RootfsLookup{Org: input.Org, Image: input.Image, Version: version, Arch: input.Arch})
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, &candidate{version: version, createdAt: metadataCreatedAt(md)})
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("rootfs %s/%s has no stored versions: %w", input.Org, input.Image, ErrRootfsNotFound)
+	}
+
+	versions := []string{}
+	allSemver := true
+	for _, item := range candidates {
+		versions = append(versions, item.version)
+		if _, err := utils.ParseSemver(item.version); err != nil {
+			allSemver = false
+		}
+	}
+	if allSemver {
+		if resolved, err := utils.ResolveVersionConstraint("*", versions); err == nil {
+			return resolved, nil
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].createdAt != candidates[j].createdAt {
+			return candidates[i].createdAt > candidates[j].createdAt
+		}
+		return utils.CompareVersions(candidates[i].version, candidates[j].version) > 0
+	})
+	return candidates[0].version, nil
+}
+
+// ResolveRootfsVersion returns the highest stored version of the rootfs satisfying the version constraint
+// of the lookup, for example ^1.2. Only the versions of the rootfs of the lookup architecture are considered.
+// The provider must be capable of listing the stored rootfs files.
+func ResolveRootfsVersion(impl Provider, input *RootfsLookup) (string, error) {
+	listingImpl, ok := impl.(ListingProvider)
+	if !ok {
+		return "", fmt.Errorf("storage provider does not support listing, the version constraint '%s' can't be resolved", input.Version)
+	}
+	items, err := listingImpl.ListRootfs()
+	if err != nil {
+		return "", err
+	}
+	resolved, err := utils.ResolveVersionConstraint(input.Version, rootfsVersions(impl, items, input))
+	if err != nil {
+		return "", fmt.Errorf("rootfs %s/%s:%s not resolved: %v", input.Org, input.Image, input.Version, err)
+	}
+	return resolved, nil
+}
+
+// rootfsVersions returns the listed versions of the rootfs of the lookup architecture.
+// A version stored without the architecture is included when the rootfs is built for the architecture.
+func rootfsVersions(impl Provider, items []*RootfsLookup, input *RootfsLookup) []string {
+	versions := []string{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		if item.Org != input.Org || item.Image != input.Image || seen[item.Version] {
+			continue
+		}
+		if item.Arch != input.Arch {
+			if item.Arch != "" {
+				continue
+			}
+			md, err := impl.FetchRootfsMetadata(item)
+			if err != nil || checkMetadataArch(input, md) != nil {
+				continue
+			}
+		}
+		seen[item.Version] = true
+		versions = append(versions, item.Version)
+	}
+	return versions
+}
+
+// metadataCreatedAt returns the CreatedAtUTC of the rootfs metadata, 0 if not recorded.
+func metadataCreatedAt(md interface{}) int64 {
+	bytes, err := json.Marshal(md)
+	if err != nil {
+		return 0
+	}
+	typed := struct {
+		CreatedAtUTC int64 `json:"CreatedAtUTC"`
+	}{}
+	if err := json.Unmarshal(bytes, &typed); err != nil {
+		return 0
+	}
+	return typed.CreatedAtUTC
+}
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uber/jaeger-client-go"
+)
+
+// SpanRecord is a finished span written to the spans file.
+type SpanRecord struct {
+	Operation string            `json:"Operation"`
+	StartTime time.Time         `json:"StartTime"`
+	Duration  time.Duration     `json:"Duration"`
+	Tags      map[string]string `json:"Tags,omitempty"`
+}
+
+type spansFileReporter struct {
+	sync.Mutex
+	file *os.File
+}
+
+// newSpansFileReporter returns a reporter appending the finished spans to the file as JSON lines.
+func newSpansFileReporter(path string) (jaeger.Reporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed opening tracing spans file")
+	}
+	return &spansFileReporter{file: file}, nil
+}
+
+func (r *spansFileReporter) Report(span *jaeger.Span) {
+	record := &SpanRecord{
+		Operation: span.OperationName(),
+		StartTime: span.StartTime(),
+		Duration:  span.Duration(),
+		Tags:      map[string]string{},
+	}
+	for k, v := range span.Tags() {
+		record.Tags[k] = fmt.Sprintf("%v", v)
+	}
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.file.Write(append(bytes, '\n'))
+}
+
+func (r *spansFileReporter) Close() {
+	r.Lock()
+	defer r.Unlock()
+	r.file.Close()
+}
+
+// ReadSpansFile reads the spans written with --tracing-spans-file.
+func ReadSpansFile(path string) ([]*SpanRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records := []*SpanRecord{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := &SpanRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, errors.Wrap(err, "failed parsing span record")
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
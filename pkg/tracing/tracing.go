@@ -1,6 +1,8 @@
 package tracing
 
 import (
+	"time"
+
 	"github.com/combust-labs/firebuild/configs"
 	"github.com/hashicorp/go-hclog"
 	"github.com/opentracing/opentracing-go"
@@ -9,15 +11,28 @@ import (
 )
 
 // GetTracer returns configured Jaeger reporter or null reporter, if tracer is disabled.
+// With --tracing-spans-file, the finished spans are also written to the file.
 func GetTracer(logger hclog.Logger, config *configs.TracingConfig) (opentracing.Tracer, func(), error) {
+	reporters := []jaeger.Reporter{}
+
+	if config.SpansFile != "" {
+		spansReporter, err := newSpansFileReporter(config.SpansFile)
+		if err != nil {
+			return nil, func() {}, err
+		}
+		reporters = append(reporters, spansReporter)
+	}
+
 	if config.Enable {
 		transport, err := jaeger.NewUDPTransport(config.HostPort, 0)
 		if err != nil {
+			for _, reporter := range reporters {
+				reporter.Close()
+			}
 			return nil, func() {}, errors.Wrap(err, "failed constructing jaeger UDP transport")
 		}
 		logAdapter := &adapter{log: logger}
 
-		reporters := []jaeger.Reporter{}
 		remoteReporterOptions := []jaeger.ReporterOption{}
 
 		if config.LogEnable {
@@ -26,19 +41,11 @@ func GetTracer(logger hclog.Logger, config *configs.TracingConfig) (opentracing.
 		}
 
 		reporters = append(reporters, jaeger.NewRemoteReporter(transport, remoteReporterOptions...))
-
-		reporter := jaeger.NewCompositeReporter(reporters...)
-		tracer, closer := jaeger.NewTracer(config.ApplicationName,
-			jaeger.NewConstSampler(true),
-			reporter,
-		)
-		return tracer, func() {
-			reporter.Close()
-			closer.Close()
-		}, nil
+	} else {
+		reporters = append(reporters, jaeger.NewNullReporter())
 	}
 
-	reporter := jaeger.NewNullReporter()
+	reporter := jaeger.NewCompositeReporter(reporters...)
 	tracer, closer := jaeger.NewTracer(config.ApplicationName,
 		jaeger.NewConstSampler(true),
 		reporter,
@@ -57,3 +64,19 @@ func ApplyTraceLogDiscovery(logger hclog.Logger, span opentracing.Span) (hclog.L
 	}
 	return logger, span
 }
+
+// SpanStartTime returns the start time of the span, zero time if the span is not a jaeger span.
+func SpanStartTime(span opentracing.Span) time.Time {
+	if jaegerSpan, ok := span.(*jaeger.Span); ok {
+		return jaegerSpan.StartTime()
+	}
+	return time.Time{}
+}
+
+// SpanDuration returns the duration of the finished span, 0 if the span is not a jaeger span.
+func SpanDuration(span opentracing.Span) time.Duration {
+	if jaegerSpan, ok := span.(*jaeger.Span); ok {
+		return jaegerSpan.Duration()
+	}
+	return 0
+}
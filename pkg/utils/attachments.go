@@ -0,0 +1,12 @@
+package utils
+
+import "regexp"
+
+// attachmentNameRegex allows letters, digits, dots, dashes and underscores,
+// the name must start with a letter or digit, maximum 128 characters.
+var attachmentNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._\-]{0,127}$`)
+
+// IsValidAttachmentName validates if a string is a valid rootfs attachment name.
+func IsValidAttachmentName(name string) bool {
+	return attachmentNameRegex.MatchString(name)
+}
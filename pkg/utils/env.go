@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ExpandEnv expands the ${NAME} references in the value with the process environment.
+// A referenced variable which is not set is an error, a variable set to an empty value expands to empty.
+// $$ is a literal $, a $ not followed by { or $ is kept as is.
+func ExpandEnv(value string) (string, error) {
+	return ExpandEnvWith(value, os.LookupEnv)
+}
+
+// ExpandEnvWith expands the ${NAME} references in the value with the lookup function,
+// see ExpandEnv for the syntax.
+func ExpandEnvWith(value string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+	result := strings.Builder{}
+	for idx := 0; idx < len(value); idx++ {
+		if value[idx] != '$' || idx == len(value)-1 {
+			result.WriteByte(value[idx])
+			continue
+		}
+		switch value[idx+1] {
+		case '$':
+			result.WriteByte('$')
+			idx++
+		case '{':
+			end := strings.IndexByte(value[idx+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in '%s'", value)
+			}
+			name := value[idx+2 : idx+2+end]
+			if name == "" {
+				return "", fmt.Errorf("empty variable reference in '%s'", value)
+			}
+			expanded, ok := lookup(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable '%s' referenced in '%s' is not set", name, value)
+			}
+			result.WriteString(expanded)
+			idx = idx + 2 + end
+		default:
+			result.WriteByte('$')
+		}
+	}
+	return result.String(), nil
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnvWith(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		value, ok := map[string]string{
+			"HOME":  "/home/firebuild",
+			"EMPTY": "",
+			"STAGE": "ci",
+		}[name]
+		return value, ok
+	}
+
+	for input, expected := range map[string]string{
+		"/var/lib/firebuild":           "/var/lib/firebuild",
+		"${HOME}/.firebuild/run-cache": "/home/firebuild/.firebuild/run-cache",
+		"${HOME}/rootfs/${STAGE}":      "/home/firebuild/rootfs/ci",
+		"/srv/${EMPTY}jailer":          "/srv/jailer",
+		"pa$$word":                     "pa$word",
+		"$${HOME}":                     "${HOME}",
+		"$HOME and trailing $":         "$HOME and trailing $",
+		"$$$${STAGE}":                  "$${STAGE}",
+		"$$${STAGE}":                   "$ci",
+	} {
+		output, err := ExpandEnvWith(input, lookup)
+		assert.Nil(t, err, input)
+		assert.Equal(t, expected, output, input)
+	}
+
+	_, err := ExpandEnvWith("${MISSING}/rootfs", lookup)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "environment variable 'MISSING' referenced in '${MISSING}/rootfs' is not set")
+
+	_, err = ExpandEnvWith("${HOME/rootfs", lookup)
+	assert.NotNil(t, err)
+	_, err = ExpandEnvWith("${}/rootfs", lookup)
+	assert.NotNil(t, err)
+}
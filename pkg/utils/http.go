@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultHTTPDialTimeout is the default timeout of establishing a connection.
+	DefaultHTTPDialTimeout = 30 * time.Second
+	// DefaultHTTPResponseHeaderTimeout is the default timeout of waiting for the response headers.
+	DefaultHTTPResponseHeaderTimeout = time.Minute
+)
+
+// NewHTTPTransport returns a transport like the default transport, with the connection dial timeout
+// and the timeout of waiting for the response headers once the request is written.
+// The response body is not bounded, large downloads and uploads are not cut off.
+func NewHTTPTransport(dialTimeout, responseHeaderTimeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+	return transport
+}
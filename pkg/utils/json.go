@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// CanonicalJSON serializes the input to JSON with the object keys sorted at every level.
+//
+// The encoding/json package writes the map keys sorted but the struct fields in the declaration order,
+// the same metadata serialized from a struct and from a map decoded from JSON, for example a stored
+// parent rootfs metadata, would differ. The canonical output is byte for byte identical
+// for identical inputs, regardless of the Go types used to hold them.
+func CanonicalJSON(input interface{}) ([]byte, error) {
+	generic, err := toGenericJSON(input)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
+
+// CanonicalJSONIndent is like CanonicalJSON but applies the indentation like json.MarshalIndent.
+func CanonicalJSONIndent(input interface{}, prefix, indent string) ([]byte, error) {
+	generic, err := toGenericJSON(input)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(generic, prefix, indent)
+}
+
+// toGenericJSON converts the input to maps, slices and JSON numbers, the maps are serialized with sorted keys.
+func toGenericJSON(input interface{}) (interface{}, error) {
+	jsonBytes, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	// numbers are written back exactly as they were serialized:
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// labelKeyRegex allows letters, digits, dots, dashes, underscores and slashes,
+// the key must start and end with a letter or digit, maximum 63 characters.
+var labelKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._/\-]{0,61}[a-zA-Z0-9])?$`)
+
+// IsValidLabelKey validates if a string is a valid label key.
+func IsValidLabelKey(key string) bool {
+	return labelKeyRegex.MatchString(key)
+}
+
+// ParseLabel parses a key=value label. The value may be empty.
+func ParseLabel(input string) (string, string, error) {
+	parts := strings.SplitN(input, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("label '%s' is invalid, expected format: key=value", input)
+	}
+	if !IsValidLabelKey(parts[0]) {
+		return "", "", fmt.Errorf("label key '%s' is invalid", parts[0])
+	}
+	return parts[0], parts[1], nil
+}
+
+// ParseLabelFilter parses a label filter: key=value matches the label value,
+// key matches any value of the label.
+func ParseLabelFilter(input string) (string, string, bool, error) {
+	if !strings.Contains(input, "=") {
+		if !IsValidLabelKey(input) {
+			return "", "", false, fmt.Errorf("label key '%s' is invalid", input)
+		}
+		return input, "", false, nil
+	}
+	key, value, err := ParseLabel(input)
+	return key, value, true, err
+}
+
+// MatchesLabelFilters returns true if the labels match all the filters.
+// Invalid filters do not match.
+func MatchesLabelFilters(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		key, value, hasValue, err := ParseLabelFilter(filter)
+		if err != nil {
+			return false
+		}
+		current, ok := labels[key]
+		if !ok || (hasValue && current != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseImageFilter parses an image filter: org=value matches the image org,
+// image=value matches the image name and label.key=value matches the image label value,
+// label.key matches any value of the image label.
+func ParseImageFilter(input string) (string, string, bool, error) {
+	if strings.HasPrefix(input, "label.") {
+		return ParseLabelFilter(strings.TrimPrefix(input, "label."))
+	}
+	parts := strings.SplitN(input, "=", 2)
+	if len(parts) != 2 || (parts[0] != "org" && parts[0] != "image") {
+		return "", "", false, fmt.Errorf("filter '%s' is invalid, expected format: org=value, image=value or label.key=value", input)
+	}
+	return parts[0], parts[1], true, nil
+}
+
+// MatchesImageFilters returns true if the image org, name and labels match all the filters.
+// Invalid filters do not match.
+func MatchesImageFilters(org, image string, labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		key, value, hasValue, err := ParseImageFilter(filter)
+		if err != nil {
+			return false
+		}
+		if strings.HasPrefix(filter, "label.") {
+			current, ok := labels[key]
+			if !ok || (hasValue && current != value) {
+				return false
+			}
+			continue
+		}
+		if (key == "org" && org != value) || (key == "image" && image != value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package utils
+
+import "testing"
+
+func TestLabelKeys(t *testing.T) {
+	for _, key := range []string{"env", "team.name", "com.example/owner", "a", "build_id-2"} {
+		if !IsValidLabelKey(key) {
+			t.Fatalf("expected label key %q to be valid", key)
+		}
+	}
+	for _, key := range []string{"", "-env", "env.", "with space", "key=value", "ünicode"} {
+		if IsValidLabelKey(key) {
+			t.Fatalf("expected label key %q to be invalid", key)
+		}
+	}
+	if _, _, err := ParseLabel("env"); err == nil {
+		t.Fatal("expected label without a value separator to be invalid")
+	}
+	key, value, err := ParseLabel("env=prod=eu")
+	if err != nil {
+		t.Fatal("expected label to parse, got error", err)
+	}
+	if key != "env" || value != "prod=eu" {
+		t.Fatalf("expected label split on the first separator, got: %q %q", key, value)
+	}
+}
+
+func TestLabelFilters(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": ""}
+	matching := [][]string{
+		{},
+		{"env"},
+		{"env=prod"},
+		{"env=prod", "team"},
+		{"team="},
+	}
+	for _, filters := range matching {
+		if !MatchesLabelFilters(labels, filters) {
+			t.Fatalf("expected labels to match filters %v", filters)
+		}
+	}
+	notMatching := [][]string{
+		{"env=dev"},
+		{"owner"},
+		{"env=prod", "owner"},
+		{"-invalid"},
+	}
+	for _, filters := range notMatching {
+		if MatchesLabelFilters(labels, filters) {
+			t.Fatalf("expected labels not to match filters %v", filters)
+		}
+	}
+}
+
+func TestImageFilters(t *testing.T) {
+	type image struct {
+		org, name string
+		labels    map[string]string
+	}
+	images := []image{
+		{"tests", "postgres", map[string]string{"env": "prod", "team": "data"}},
+		{"tests", "redis", map[string]string{"env": "dev"}},
+		{"combust-labs", "postgres", map[string]string{}},
+	}
+	cases := []struct {
+		filters  []string
+		expected []bool
+	}{
+		{[]string{}, []bool{true, true, true}},
+		{[]string{"org=tests"}, []bool{true, true, false}},
+		{[]string{"image=postgres"}, []bool{true, false, true}},
+		{[]string{"org=tests", "image=postgres"}, []bool{true, false, false}},
+		{[]string{"label.env=prod"}, []bool{true, false, false}},
+		{[]string{"label.env"}, []bool{true, true, false}},
+		{[]string{"org=tests", "label.team=data", "label.env=dev"}, []bool{false, false, false}},
+		{[]string{"version=13"}, []bool{false, false, false}},
+	}
+	for _, c := range cases {
+		for idx, img := range images {
+			if MatchesImageFilters(img.org, img.name, img.labels, c.filters) != c.expected[idx] {
+				t.Fatalf("expected image %s/%s match %v for filters %v", img.org, img.name, c.expected[idx], c.filters)
+			}
+		}
+	}
+	for _, filter := range []string{"org", "name=postgres", "label.-env=prod"} {
+		if _, _, _, err := ParseImageFilter(filter); err == nil {
+			t.Fatalf("expected filter %q to be invalid", filter)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemAvailableFrom(t *testing.T) {
+	meminfo := `MemTotal:       16314108 kB
+MemFree:         1146520 kB
+MemAvailable:    9876540 kB
+Buffers:          530616 kB
+Cached:          8021784 kB
+`
+	value, err := memAvailableFrom(bufio.NewScanner(strings.NewReader(meminfo)))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(9876540*1024), value)
+}
+
+func TestMemAvailableFromMissing(t *testing.T) {
+	// kernels older than 3.14 do not report MemAvailable:
+	meminfo := `MemTotal:       16314108 kB
+MemFree:         1146520 kB
+Buffers:          530616 kB
+`
+	_, err := memAvailableFrom(bufio.NewScanner(strings.NewReader(meminfo)))
+	assert.NotNil(t, err)
+}
+
+func TestMemAvailableFromMalformed(t *testing.T) {
+	meminfo := `MemTotal:       16314108 kB
+MemAvailable:    lots kB
+`
+	_, err := memAvailableFrom(bufio.NewScanner(strings.NewReader(meminfo)))
+	assert.NotNil(t, err)
+}
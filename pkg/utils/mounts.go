@@ -0,0 +1,381 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// MountTempDirPrefix is the prefix of the temporary directories holding the mount points
+// of the firebuild processes, the directory name is firebuild-mnt-<pid>-<random>.
+const MountTempDirPrefix = "firebuild-mnt-"
+
+var mountTempDirRegex = regexp.MustCompile(`^` + MountTempDirPrefix + `(\d+)-`)
+
+// MkdirMountTemp creates a temporary directory named after the current process.
+// Once the process exits, the mounts and the loop devices left under such directory are stale.
+func MkdirMountTemp() (string, error) {
+	return ioutil.TempDir("", fmt.Sprintf("%s%d-", MountTempDirPrefix, os.Getpid()))
+}
+
+// ErrMountFileInUse is returned when the file to mount is already mounted by a firebuild process.
+// Each VMM runs off its own copy of the rootfs file, mounting a shared file concurrently
+// would corrupt the file system.
+var ErrMountFileInUse = errors.New("file is mounted by another firebuild mount")
+
+// lockMountFile takes an exclusive lock of the file to mount, ErrMountFileInUse if the file is locked.
+// The lock is held until the file is unmounted and released when the process exits.
+func lockMountFile(file string) (*os.File, error) {
+	lockFile, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed opening the file to mount")
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lockFile.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errors.Wrapf(ErrMountFileInUse, "'%s'", file)
+		}
+		return nil, errors.Wrap(err, "failed locking the file to mount")
+	}
+	return lockFile, nil
+}
+
+// LoopOptions configures the loop device of a file mounted with MountWithLoopOptions.
+type LoopOptions struct {
+	// DirectIO enables the direct I/O of the loop device, the writes bypass the page cache of the backing file.
+	DirectIO bool
+	// SectorSize is the logical sector size of the loop device in bytes, 0 for the loop driver default.
+	SectorSize int
+}
+
+// IsDefault returns true if the options don't change the loop device set up by mount.
+func (o *LoopOptions) IsDefault() bool {
+	return o == nil || (!o.DirectIO && o.SectorSize == 0)
+}
+
+// Validate validates the loop options.
+func (o *LoopOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	switch o.SectorSize {
+	case 0, 512, 1024, 2048, 4096:
+	default:
+		return fmt.Errorf("loop device sector size must be 512, 1024, 2048 or 4096, got %d", o.SectorSize)
+	}
+	return nil
+}
+
+// MountWithLoopOptions sudo mounts a rootfs file at a location using a loop device with the options.
+// With the default options, the file is mounted with Mount. Otherwise, the loop device is set up
+// explicitly and detached by Umount. Like with Mount, the file can't be mounted again until unmounted.
+func MountWithLoopOptions(file, dir string, options *LoopOptions) error {
+	if options.IsDefault() {
+		return Mount(file, dir)
+	}
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	lockFile, err := lockMountFile(file)
+	if err != nil {
+		return err
+	}
+	command := "losetup --find --show"
+	if options.DirectIO {
+		command = command + " --direct-io=on"
+	}
+	if options.SectorSize > 0 {
+		command = fmt.Sprintf("%s --sector-size %d", command, options.SectorSize)
+	}
+	output, err := exec.Command("/bin/sh", "-c", fmt.Sprintf("sudo %s %s", command, file)).Output()
+	if err != nil {
+		lockFile.Close()
+		return errors.Wrap(err, "failed setting up loop device")
+	}
+	device := strings.TrimSpace(string(output))
+	if device == "" {
+		lockFile.Close()
+		return fmt.Errorf("loop device not reported by losetup")
+	}
+	exitCode, cmdErr := RunShellCommandSudo(fmt.Sprintf("mount %s %s", device, dir))
+	if cmdErr == nil && exitCode != 0 {
+		cmdErr = fmt.Errorf("command finished with non-zero exit code")
+	}
+	if cmdErr != nil {
+		DetachLoopDevice(device)
+		lockFile.Close()
+		return cmdErr
+	}
+	activeMounts.addMount(dir, &trackedMount{device: device, lockFile: lockFile})
+	return nil
+}
+
+// StaleMount is a mount point left under a temporary directory of an exited firebuild process.
+type StaleMount struct {
+	MountPoint string
+	Source     string
+	// TempDir is the temporary directory of the process, PID is the process ID.
+	TempDir string
+	PID     int
+}
+
+// StaleMounts returns the mount points left under the temporary directories of the exited firebuild processes,
+// the nested mount points first.
+func StaleMounts() ([]*StaleMount, error) {
+	file, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries, err := parseMounts(file)
+	if err != nil {
+		return nil, err
+	}
+	result := []*StaleMount{}
+	for _, entry := range entries {
+		tempDir, pid, ok := mountTempDirOwner(entry.mountPoint)
+		if !ok || processRunning(pid) {
+			continue
+		}
+		result = append(result, &StaleMount{MountPoint: entry.mountPoint, Source: entry.source, TempDir: tempDir, PID: pid})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return len(result[i].MountPoint) > len(result[j].MountPoint)
+	})
+	return result, nil
+}
+
+// StaleLoopDevice is a loop device, not mounted, backed by a file under a temporary directory
+// of an exited firebuild process.
+type StaleLoopDevice struct {
+	Device      string
+	BackingFile string
+	// TempDir is the temporary directory of the process, PID is the process ID.
+	TempDir string
+	PID     int
+}
+
+// StaleLoopDevices returns the loop devices backed by the files under the temporary directories
+// of the exited firebuild processes. The loop devices still mounted are not returned,
+// unmounting the stale mount releases them.
+func StaleLoopDevices() ([]*StaleLoopDevice, error) {
+	mounted := map[string]bool{}
+	if file, err := os.Open("/proc/self/mounts"); err == nil {
+		entries, parseErr := parseMounts(file)
+		file.Close()
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		for _, entry := range entries {
+			mounted[entry.source] = true
+		}
+	}
+	backingFiles, err := filepath.Glob("/sys/block/loop*/loop/backing_file")
+	if err != nil {
+		return nil, err
+	}
+	result := []*StaleLoopDevice{}
+	for _, backingFilePath := range backingFiles {
+		bytes, err := ioutil.ReadFile(backingFilePath)
+		if err != nil {
+			// the loop device was detached in the meantime:
+			continue
+		}
+		backingFile := strings.TrimSuffix(strings.TrimSpace(string(bytes)), " (deleted)")
+		device := "/dev/" + filepath.Base(filepath.Dir(filepath.Dir(backingFilePath)))
+		if mounted[device] {
+			continue
+		}
+		tempDir, pid, ok := mountTempDirOwner(backingFile)
+		if !ok || processRunning(pid) {
+			continue
+		}
+		result = append(result, &StaleLoopDevice{Device: device, BackingFile: backingFile, TempDir: tempDir, PID: pid})
+	}
+	return result, nil
+}
+
+// DetachLoopDevice sudo detaches a loop device.
+func DetachLoopDevice(device string) error {
+	exitCode, cmdErr := RunShellCommandSudo(fmt.Sprintf("losetup -d %s", device))
+	if cmdErr != nil {
+		return cmdErr
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("command finished with non-zero exit code")
+	}
+	return nil
+}
+
+type mountEntry struct {
+	source     string
+	mountPoint string
+}
+
+// parseMounts parses the mounts in the /proc/mounts format.
+func parseMounts(reader io.Reader) ([]*mountEntry, error) {
+	result := []*mountEntry{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		result = append(result, &mountEntry{source: unescapeMountField(fields[0]), mountPoint: unescapeMountField(fields[1])})
+	}
+	return result, scanner.Err()
+}
+
+// unescapeMountField replaces the octal escapes of the /proc/mounts fields, for example \040 for a space.
+func unescapeMountField(input string) string {
+	if !strings.Contains(input, `\`) {
+		return input
+	}
+	var builder strings.Builder
+	for idx := 0; idx < len(input); idx++ {
+		if input[idx] == '\\' && idx+4 <= len(input) {
+			if value, err := strconv.ParseUint(input[idx+1:idx+4], 8, 8); err == nil {
+				builder.WriteByte(byte(value))
+				idx = idx + 3
+				continue
+			}
+		}
+		builder.WriteByte(input[idx])
+	}
+	return builder.String()
+}
+
+// mountTempDirOwner returns the temporary directory of the firebuild process containing the path
+// and the process ID, false if the path is not under such directory.
+func mountTempDirOwner(path string) (string, int, bool) {
+	tempRoot, err := filepath.Abs(os.TempDir())
+	if err != nil {
+		return "", 0, false
+	}
+	relative, err := filepath.Rel(tempRoot, filepath.Clean(path))
+	if err != nil || relative == "." || strings.HasPrefix(relative, "..") {
+		return "", 0, false
+	}
+	topLevel := strings.Split(relative, string(filepath.Separator))[0]
+	matches := mountTempDirRegex.FindStringSubmatch(topLevel)
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	pid, err := strconv.Atoi(matches[1])
+	if err != nil || pid <= 0 {
+		return "", 0, false
+	}
+	return filepath.Join(tempRoot, topLevel), pid, true
+}
+
+// processRunning returns true if the process exists, also when not permitted to signal it.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// activeMounts tracks the mounts of the process so they are unmounted
+// when the process is interrupted or terminated before unmounting them.
+var activeMounts = &mountRegistry{dirs: map[string]*trackedMount{}}
+
+type mountRegistry struct {
+	sync.Mutex
+	dirs    map[string]*trackedMount
+	signals chan os.Signal
+}
+
+// trackedMount is a mount of the process.
+type trackedMount struct {
+	// device is the loop device set up explicitly, empty if set up by mount:
+	device string
+	// lockFile holds the lock of the mounted file, nil for tmpfs:
+	lockFile *os.File
+}
+
+func (r *mountRegistry) add(dir string) {
+	r.addMount(dir, &trackedMount{})
+}
+
+func (r *mountRegistry) addMount(dir string, mount *trackedMount) {
+	r.Lock()
+	defer r.Unlock()
+	if absDir, err := filepath.Abs(dir); err == nil {
+		dir = absDir
+	}
+	r.dirs[dir] = mount
+	if r.signals == nil {
+		r.signals = make(chan os.Signal, 1)
+		signal.Notify(r.signals, os.Interrupt, syscall.SIGTERM)
+		go r.unmountOnSignal(r.signals)
+	}
+}
+
+// remove stops tracking the mount point and releases the lock of the mounted file,
+// returns the loop device set up explicitly for the mount point, if any.
+func (r *mountRegistry) remove(dir string) string {
+	r.Lock()
+	defer r.Unlock()
+	if absDir, err := filepath.Abs(dir); err == nil {
+		dir = absDir
+	}
+	device := ""
+	if mount, ok := r.dirs[dir]; ok {
+		device = mount.device
+		if mount.lockFile != nil {
+			mount.lockFile.Close()
+		}
+	}
+	delete(r.dirs, dir)
+	if len(r.dirs) == 0 && r.signals != nil {
+		signal.Stop(r.signals)
+		close(r.signals)
+		r.signals = nil
+	}
+	return device
+}
+
+// unmountOnSignal unmounts the tracked mounts, the nested mounts first, detaches the loop devices
+// set up explicitly and delivers the signal again with the default handling.
+func (r *mountRegistry) unmountOnSignal(signals chan os.Signal) {
+	sig, ok := <-signals
+	if !ok {
+		return
+	}
+	r.Lock()
+	dirs := []string{}
+	for dir := range r.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+	for _, dir := range dirs {
+		if err := umount(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "failed unmounting %s on %v: %v\n", dir, sig, err)
+			continue
+		}
+		if device := r.dirs[dir].device; device != "" {
+			if err := DetachLoopDevice(device); err != nil {
+				fmt.Fprintf(os.Stderr, "failed detaching %s on %v: %v\n", device, sig, err)
+			}
+		}
+	}
+	r.Unlock()
+	signal.Reset(sig)
+	if sysSignal, ok := sig.(syscall.Signal); ok {
+		syscall.Kill(os.Getpid(), sysSignal)
+	}
+}
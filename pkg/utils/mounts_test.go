@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMounts(t *testing.T) {
+	entries, err := parseMounts(strings.NewReader(`proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/loop3 /tmp/firebuild-mnt-123-456/mount ext4 rw,relatime 0 0
+/dev/loop4 /tmp/with\040space ext4 rw,relatime 0 0
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "/dev/loop3", entries[1].source)
+	assert.Equal(t, "/tmp/firebuild-mnt-123-456/mount", entries[1].mountPoint)
+	assert.Equal(t, "/tmp/with space", entries[2].mountPoint)
+}
+
+func TestMountTempDirOwner(t *testing.T) {
+	tempRoot, err := filepath.Abs(os.TempDir())
+	assert.Nil(t, err)
+
+	tempDir := filepath.Join(tempRoot, fmt.Sprintf("%s123-456", MountTempDirPrefix))
+	owner, pid, ok := mountTempDirOwner(filepath.Join(tempDir, "mount"))
+	assert.True(t, ok)
+	assert.Equal(t, tempDir, owner)
+	assert.Equal(t, 123, pid)
+
+	for _, path := range []string{
+		tempRoot,
+		filepath.Join(tempRoot, "firebuild-bench123", "mount"),
+		filepath.Join(tempRoot, MountTempDirPrefix+"abc-456", "mount"),
+		filepath.Join("/var/lib", MountTempDirPrefix+"123-456", "mount"),
+	} {
+		_, _, ok := mountTempDirOwner(path)
+		assert.False(t, ok, "expected no owner for %s", path)
+	}
+}
+
+func TestMkdirMountTemp(t *testing.T) {
+	tempDir, err := MkdirMountTemp()
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	_, pid, ok := mountTempDirOwner(filepath.Join(tempDir, "mount"))
+	assert.True(t, ok)
+	assert.Equal(t, os.Getpid(), pid)
+	assert.True(t, processRunning(pid))
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip("true not available", err)
+	}
+	assert.False(t, processRunning(cmd.Process.Pid))
+}
+
+func TestLoopOptionsValidate(t *testing.T) {
+	assert.True(t, (*LoopOptions)(nil).IsDefault())
+	assert.True(t, (&LoopOptions{}).IsDefault())
+	assert.False(t, (&LoopOptions{DirectIO: true}).IsDefault())
+	assert.Nil(t, (&LoopOptions{SectorSize: 4096}).Validate())
+	assert.NotNil(t, (&LoopOptions{SectorSize: 3000}).Validate())
+}
+
+func TestMountWithLoopOptions(t *testing.T) {
+	skipWithoutLoopDevices(t)
+
+	tempDir, err := MkdirMountTemp()
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	rootfs, mountDir := prepareLoopRootfs(t, tempDir, 64)
+
+	assert.Nil(t, MountWithLoopOptions(rootfs, mountDir, &LoopOptions{DirectIO: true, SectorSize: 512}))
+	device := activeMounts.dirs[mountDir].device
+	if !assert.NotEmpty(t, device) {
+		Umount(mountDir)
+		return
+	}
+	dio, err := ioutil.ReadFile(filepath.Join("/sys/block", filepath.Base(device), "loop", "dio"))
+	assert.Nil(t, err)
+	assert.Equal(t, "1", strings.TrimSpace(string(dio)))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(mountDir, "file"), []byte("data"), 0644))
+
+	assert.Nil(t, Umount(mountDir))
+	_, err = os.Stat(filepath.Join("/sys/block", filepath.Base(device), "loop", "backing_file"))
+	assert.True(t, os.IsNotExist(err), "expected the loop device to be detached")
+}
+
+func TestLockMountFileConcurrent(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	rootfs := filepath.Join(tempDir, "rootfs")
+	assert.Nil(t, ioutil.WriteFile(rootfs, []byte{}, 0644))
+
+	const attempts = 8
+	locks := make(chan *os.File, attempts)
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lockFile, err := lockMountFile(rootfs)
+			if err != nil {
+				errs <- err
+				return
+			}
+			locks <- lockFile
+		}()
+	}
+	wg.Wait()
+	close(locks)
+	close(errs)
+
+	assert.Equal(t, 1, len(locks), "expected exactly one lock")
+	for err := range errs {
+		assert.True(t, errors.Is(err, ErrMountFileInUse), "expected file in use, got", err)
+	}
+	for lockFile := range locks {
+		lockFile.Close()
+	}
+	lockFile, err := lockMountFile(rootfs)
+	assert.Nil(t, err, "expected the lock to be released")
+	lockFile.Close()
+}
+
+func TestMountConcurrentSameFile(t *testing.T) {
+	skipWithoutLoopDevices(t)
+
+	tempDir, err := MkdirMountTemp()
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	rootfs, mountDir := prepareLoopRootfs(t, tempDir, 64)
+	otherMountDir := filepath.Join(tempDir, "other-mount")
+	assert.Nil(t, os.Mkdir(otherMountDir, 0755))
+
+	results := make(chan error, 2)
+	for _, dir := range []string{mountDir, otherMountDir} {
+		go func(dir string) {
+			results <- Mount(rootfs, dir)
+		}(dir)
+	}
+	succeeded := 0
+	for i := 0; i < 2; i++ {
+		if err := <-results; err == nil {
+			succeeded++
+		} else {
+			assert.True(t, errors.Is(err, ErrMountFileInUse), "expected file in use, got", err)
+		}
+	}
+	assert.Equal(t, 1, succeeded, "expected exactly one mount")
+	for _, dir := range []string{mountDir, otherMountDir} {
+		if activeMounts.dirs[dir] != nil {
+			assert.Nil(t, Umount(dir))
+		}
+	}
+
+	// unmounted, the file can be mounted again:
+	assert.Nil(t, Mount(rootfs, otherMountDir))
+	assert.Nil(t, Umount(otherMountDir))
+}
+
+// BenchmarkMountWrite measures writing the files to the mounted rootfs file and unmounting it,
+// the way the base OS file system is exported, with the different loop device options.
+// The 512 MiB file system has 4096 bytes blocks, the block size can't be smaller than the sector size.
+func BenchmarkMountWrite(b *testing.B) {
+	skipWithoutLoopDevices(b)
+
+	data := []byte(strings.Repeat("firebuild", 1024*1024/9+1))[:1024*1024]
+	for _, item := range []struct {
+		name    string
+		options *LoopOptions
+	}{
+		{"default", nil},
+		{"direct-io", &LoopOptions{DirectIO: true}},
+		{"direct-io-4096", &LoopOptions{DirectIO: true, SectorSize: 4096}},
+	} {
+		b.Run(item.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data) * 64))
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tempDir, err := MkdirMountTemp()
+				if err != nil {
+					b.Fatal("expected temp directory, got error", err)
+				}
+				rootfs, mountDir := prepareLoopRootfs(b, tempDir, 512)
+				b.StartTimer()
+				if err := MountWithLoopOptions(rootfs, mountDir, item.options); err != nil {
+					os.RemoveAll(tempDir)
+					b.Fatal("expected rootfs to mount, got error", err)
+				}
+				for file := 0; file < 64; file++ {
+					if err := ioutil.WriteFile(filepath.Join(mountDir, fmt.Sprintf("file-%d", file)), data, 0644); err != nil {
+						b.Error("expected file to be written, got error", err)
+						break
+					}
+				}
+				if err := Umount(mountDir); err != nil {
+					b.Error("expected rootfs to unmount, got error", err)
+				}
+				b.StopTimer()
+				os.RemoveAll(tempDir)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func skipWithoutLoopDevices(tb testing.TB) {
+	if os.Geteuid() != 0 {
+		tb.Skip("loop devices require root")
+	}
+	for _, command := range []string{"sudo", "losetup", "mkfs.ext4"} {
+		if _, err := exec.LookPath(command); err != nil {
+			tb.Skip(command+" not available", err)
+		}
+	}
+}
+
+func prepareLoopRootfs(tb testing.TB, tempDir string, sizeMBs int) (string, string) {
+	rootfs := filepath.Join(tempDir, "rootfs")
+	if err := CreateRootFSFile(rootfs, sizeMBs); err != nil {
+		tb.Fatal("expected rootfs file, got error", err)
+	}
+	if err := MkfsExt4(rootfs); err != nil {
+		tb.Fatal("expected file system, got error", err)
+	}
+	mountDir := filepath.Join(tempDir, "mount")
+	if err := os.Mkdir(mountDir, 0755); err != nil {
+		tb.Fatal("expected mount directory, got error", err)
+	}
+	return rootfs, mountDir
+}
@@ -8,6 +8,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 )
 
 // CheckIfExistsAndIsDirectory checks is a path points at a directory.
@@ -34,6 +37,35 @@ func CheckIfExistsAndIsRegular(path string) (fs.FileInfo, error) {
 	return stat, nil
 }
 
+// CheckIfDirectoryWritable checks if a path points at a directory in which files can be created.
+func CheckIfDirectoryWritable(path string) error {
+	if _, err := CheckIfExistsAndIsDirectory(path); err != nil {
+		return err
+	}
+	probe, err := ioutil.TempFile(path, ".firebuild-write-check-")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// ClosestExistingDirectory returns the path itself, if the path exists,
+// or the closest parent of the path which exists.
+func ClosestExistingDirectory(path string) string {
+	current := filepath.Clean(path)
+	for {
+		if _, err := os.Stat(current); err == nil {
+			return current
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return current
+		}
+		current = parent
+	}
+}
+
 // CopyFile copies a file at the source path to the dest path.
 func CopyFile(source, dest string, bufferSize int) error {
 	sourceFile, err := os.Open(source)
@@ -62,6 +94,19 @@ func CopyFile(source, dest string, bufferSize int) error {
 	return nil
 }
 
+// CheckExt4 uses e2fsck to force check the EXT4 file system in a given unmounted file.
+// Fails when the file system has errors, the file is not modified.
+func CheckExt4(path string) error {
+	exitCode, cmdErr := RunShellCommandNoSudo(fmt.Sprintf("e2fsck -f -n %s", path))
+	if cmdErr != nil {
+		return cmdErr
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("file system check finished with non-zero exit code %d", exitCode)
+	}
+	return nil
+}
+
 // CreateRootFSFile uses dd to create a rootfs file of given size at a given path.
 func CreateRootFSFile(path string, size int) error {
 	exitCode, cmdErr := RunShellCommandNoSudo(fmt.Sprintf("dd if=/dev/zero of=%s bs=1M count=%d", path, size))
@@ -74,6 +119,25 @@ func CreateRootFSFile(path string, size int) error {
 	return nil
 }
 
+// DirectoryFreeSpace returns the number of bytes available to an unprivileged user
+// on the file system the path resides on.
+func DirectoryFreeSpace(path string) (uint64, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// Ext4Size returns the size in bytes of the EXT4 file system in a given file.
+func Ext4Size(path string) (int64, error) {
+	output, err := exec.Command("dumpe2fs", "-h", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed reading file system superblock: %+v", err)
+	}
+	return parseExt4Size(string(output))
+}
+
 // GetenvOrDefault calls os>lookup for a key and returns a fallback only if variable wasn't set.
 func GetenvOrDefault(key, fallback string) string {
 	if v, ok := os.LookupEnv(key); ok {
@@ -82,6 +146,48 @@ func GetenvOrDefault(key, fallback string) string {
 	return fallback
 }
 
+// LinkCount returns the number of hard links of the file, 1 if unknown.
+func LinkCount(stat fs.FileInfo) uint64 {
+	if sysStat, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return uint64(sysStat.Nlink)
+	}
+	return 1
+}
+
+// LinkOrCloneFile creates the target as a hard link to the source. If the hard link
+// can't be created, for example when the paths are on different file systems,
+// the file is cloned with a copy-on-write reflink where supported, or copied otherwise.
+// An existing target is replaced.
+func LinkOrCloneFile(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	targetFile, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer targetFile.Close()
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, targetFile.Fd(), ioctlFileClone, sourceFile.Fd()); errno == 0 {
+		return nil
+	}
+	if _, err := io.Copy(targetFile, sourceFile); err != nil {
+		os.Remove(target)
+		return err
+	}
+	return nil
+}
+
 // MkfsExt4 uses mkfs.ext4 to create an EXT4 file system in a given file.
 func MkfsExt4(path string) error {
 	exitCode, cmdErr := RunShellCommandNoSudo(fmt.Sprintf("mkfs.ext4 %s", path))
@@ -95,14 +201,36 @@ func MkfsExt4(path string) error {
 }
 
 // Mount sudo mounts a rootfs file at a location.
+// The location is unmounted if the process is interrupted or terminated before Umount.
+// The file can't be mounted again until unmounted, ErrMountFileInUse is returned.
 func Mount(file, dir string) error {
+	lockFile, err := lockMountFile(file)
+	if err != nil {
+		return err
+	}
 	exitCode, cmdErr := RunShellCommandSudo(fmt.Sprintf("mount %s %s", file, dir))
+	if cmdErr == nil && exitCode != 0 {
+		cmdErr = fmt.Errorf("command finished with non-zero exit code")
+	}
+	if cmdErr != nil {
+		lockFile.Close()
+		return cmdErr
+	}
+	activeMounts.addMount(dir, &trackedMount{lockFile: lockFile})
+	return nil
+}
+
+// MountTmpfs sudo mounts a tmpfs of a given size in bytes at a location.
+// The location is unmounted if the process is interrupted or terminated before Umount.
+func MountTmpfs(dir string, sizeBytes int64) error {
+	exitCode, cmdErr := RunShellCommandSudo(fmt.Sprintf("mount -t tmpfs -o size=%d tmpfs %s", sizeBytes, dir))
 	if cmdErr != nil {
 		return cmdErr
 	}
 	if exitCode != 0 {
 		return fmt.Errorf("command finished with non-zero exit code")
 	}
+	activeMounts.add(dir)
 	return nil
 }
 
@@ -162,8 +290,57 @@ func RunShellCommandSudo(command string) (int, error) {
 	return runShellCommand(command, true)
 }
 
-// Umount sudo umounts a location.
+// ShrinkExt4 uses resize2fs to shrink the EXT4 file system in a given unmounted file
+// to the minimum size plus the free space in megabytes. The file is truncated to the file system size.
+func ShrinkExt4(path string, freeSpaceMBs int) error {
+	// resize2fs requires a freshly checked file system, exit code 1 means the errors were corrected:
+	exitCode, cmdErr := RunShellCommandNoSudo(fmt.Sprintf("e2fsck -f -y %s", path))
+	if cmdErr != nil {
+		return cmdErr
+	}
+	if exitCode > 1 {
+		return fmt.Errorf("file system check finished with exit code %d", exitCode)
+	}
+	exitCode, cmdErr = RunShellCommandNoSudo(fmt.Sprintf("resize2fs -M %s", path))
+	if cmdErr != nil {
+		return cmdErr
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("file system shrink finished with non-zero exit code %d", exitCode)
+	}
+	if freeSpaceMBs > 0 {
+		size, err := Ext4Size(path)
+		if err != nil {
+			return err
+		}
+		exitCode, cmdErr = RunShellCommandNoSudo(fmt.Sprintf("resize2fs %s %dK", path, size/1024+int64(freeSpaceMBs)*1024))
+		if cmdErr != nil {
+			return cmdErr
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("file system resize finished with non-zero exit code %d", exitCode)
+		}
+	}
+	size, err := Ext4Size(path)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(path, size)
+}
+
+// Umount sudo umounts a location. The loop device set up by MountWithLoopOptions is detached.
 func Umount(dir string) error {
+	if err := umount(dir); err != nil {
+		return err
+	}
+	// the loop devices set up by mount are detached by umount:
+	if device := activeMounts.remove(dir); device != "" {
+		return DetachLoopDevice(device)
+	}
+	return nil
+}
+
+func umount(dir string) error {
 	exitCode, cmdErr := RunShellCommandSudo(fmt.Sprintf("umount %s", dir))
 	if cmdErr != nil {
 		return cmdErr
@@ -174,8 +351,107 @@ func Umount(dir string) error {
 	return nil
 }
 
+// WriteFileToExt4 uses debugfs to write the host file to the EXT4 file system in a given unmounted file.
+// Missing parent directories are created, an existing file is replaced. The file is owned by root.
+func WriteFileToExt4(imagePath, hostPath, guestPath string, mode fs.FileMode) error {
+	if !filepath.IsAbs(guestPath) || strings.ContainsAny(guestPath, " \t\n\"") {
+		return fmt.Errorf("guest path must be an absolute path without whitespace, got %q", guestPath)
+	}
+	hostStat, err := os.Stat(hostPath)
+	if err != nil {
+		return err
+	}
+	guestPath = filepath.Clean(guestPath)
+	parents := []string{}
+	for parent := filepath.Dir(guestPath); parent != "/"; parent = filepath.Dir(parent) {
+		parents = append([]string{parent}, parents...)
+	}
+	commands := []string{}
+	// debugfs reports the errors for the existing directories and the missing file but carries on:
+	for _, parent := range parents {
+		commands = append(commands, fmt.Sprintf("mkdir %s", parent))
+	}
+	commands = append(commands,
+		fmt.Sprintf("rm %s", guestPath),
+		fmt.Sprintf("write %s %s", hostPath, guestPath),
+		fmt.Sprintf("sif %s mode 0%o", guestPath, uint32(syscall.S_IFREG)|uint32(mode.Perm())),
+		fmt.Sprintf("sif %s uid 0", guestPath),
+		fmt.Sprintf("sif %s gid 0", guestPath))
+	commandFile, err := ioutil.TempFile("", "debugfs-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(commandFile.Name())
+	if _, err := commandFile.WriteString(strings.Join(commands, "\n") + "\n"); err != nil {
+		commandFile.Close()
+		return err
+	}
+	if err := commandFile.Close(); err != nil {
+		return err
+	}
+	if output, err := exec.Command("debugfs", "-w", "-f", commandFile.Name(), imagePath).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed writing file with debugfs: %+v: %s", err, string(output))
+	}
+	// debugfs exits with zero on failed commands, verify the result:
+	output, err := exec.Command("debugfs", "-R", fmt.Sprintf("stat %s", guestPath), imagePath).Output()
+	if err != nil {
+		return fmt.Errorf("failed verifying file with debugfs: %+v", err)
+	}
+	size, err := parseExt4FileSize(string(output))
+	if err != nil {
+		return fmt.Errorf("file %s not written: %+v", guestPath, err)
+	}
+	if size != hostStat.Size() {
+		return fmt.Errorf("file %s written with size %d, expected %d", guestPath, size, hostStat.Size())
+	}
+	return nil
+}
+
 // --
 
+// parseExt4FileSize parses the file size from the debugfs stat output.
+func parseExt4FileSize(output string) (int64, error) {
+	fields := strings.Fields(output)
+	for i, field := range fields {
+		if field == "Size:" && i+1 < len(fields) {
+			return strconv.ParseInt(fields[i+1], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("file not found")
+}
+
+// parseExt4Size parses the file system size from the dumpe2fs -h output.
+func parseExt4Size(output string) (int64, error) {
+	var blockCount, blockSize int64
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		var target *int64
+		switch strings.TrimSpace(parts[0]) {
+		case "Block count":
+			target = &blockCount
+		case "Block size":
+			target = &blockSize
+		default:
+			continue
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s in file system superblock: %q", strings.TrimSpace(parts[0]), parts[1])
+		}
+		*target = value
+	}
+	if blockCount == 0 || blockSize == 0 {
+		return 0, fmt.Errorf("file system superblock has no block count or block size")
+	}
+	return blockCount * blockSize, nil
+}
+
+// ioctlFileClone is the Linux FICLONE ioctl request.
+const ioctlFileClone = 0x40049409
+
 func runShellCommand(command string, sudo bool) (int, error) {
 	if sudo {
 		command = fmt.Sprintf("sudo %s", command)
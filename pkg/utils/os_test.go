@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClosestExistingDirectory(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	assert.Equal(t, tempDir, ClosestExistingDirectory(tempDir))
+	assert.Equal(t, tempDir, ClosestExistingDirectory(filepath.Join(tempDir, "does", "not", "exist")))
+}
+
+func TestDirectoryWritableAndFreeSpace(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	assert.Nil(t, CheckIfDirectoryWritable(tempDir))
+	assert.NotNil(t, CheckIfDirectoryWritable(filepath.Join(tempDir, "does-not-exist")))
+
+	entries, err := ioutil.ReadDir(tempDir)
+	assert.Nil(t, err)
+	assert.Empty(t, entries, "expected the write check to not leave files behind")
+
+	available, err := DirectoryFreeSpace(tempDir)
+	assert.Nil(t, err)
+	assert.Greater(t, available, uint64(0))
+}
+
+func TestParseExt4Size(t *testing.T) {
+	size, err := parseExt4Size("Filesystem volume name:   <none>\nBlock count:              2048\nBlock size:               4096\n")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2048*4096), size)
+
+	_, err = parseExt4Size("Filesystem volume name:   <none>\n")
+	assert.NotNil(t, err)
+}
+
+func TestShrinkExt4(t *testing.T) {
+	for _, tool := range []string{"mkfs.ext4", "e2fsck", "resize2fs", "dumpe2fs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip("e2fsprogs not available", err)
+		}
+	}
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rootfs := filepath.Join(tempDir, "rootfs")
+	assert.Nil(t, CreateRootFSFile(rootfs, 64))
+	assert.Nil(t, MkfsExt4(rootfs))
+
+	assert.Nil(t, ShrinkExt4(rootfs, 0))
+	assert.Nil(t, CheckExt4(rootfs))
+	minimal, err := os.Stat(rootfs)
+	assert.Nil(t, err)
+	assert.Less(t, minimal.Size(), int64(64*1024*1024))
+	fsSize, err := Ext4Size(rootfs)
+	assert.Nil(t, err)
+	assert.Equal(t, minimal.Size(), fsSize, "expected the file to be truncated to the file system size")
+
+	// the same file system shrinks to the same minimum, the margin is added on top:
+	rootfsWithMargin := filepath.Join(tempDir, "rootfs-with-margin")
+	assert.Nil(t, CreateRootFSFile(rootfsWithMargin, 64))
+	assert.Nil(t, MkfsExt4(rootfsWithMargin))
+	assert.Nil(t, ShrinkExt4(rootfsWithMargin, 8))
+	assert.Nil(t, CheckExt4(rootfsWithMargin))
+	withMargin, err := os.Stat(rootfsWithMargin)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, withMargin.Size(), minimal.Size()+8*1024*1024)
+}
+
+func TestWriteFileToExt4(t *testing.T) {
+	for _, tool := range []string{"mkfs.ext4", "debugfs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip("e2fsprogs not available", err)
+		}
+	}
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	rootfs := filepath.Join(tempDir, "rootfs")
+	assert.Nil(t, CreateRootFSFile(rootfs, 16))
+	assert.Nil(t, MkfsExt4(rootfs))
+
+	hostFile := filepath.Join(tempDir, "seed")
+	for _, content := range []string{"first seed", "second, longer seed"} {
+		assert.Nil(t, ioutil.WriteFile(hostFile, []byte(content), 0644))
+		// the second write replaces the file:
+		assert.Nil(t, WriteFileToExt4(rootfs, hostFile, "/var/lib/systemd/random-seed", 0600))
+		output, err := exec.Command("debugfs", "-R", "cat /var/lib/systemd/random-seed", rootfs).Output()
+		assert.Nil(t, err)
+		assert.Equal(t, content, string(output))
+	}
+	output, err := exec.Command("debugfs", "-R", "stat /var/lib/systemd/random-seed", rootfs).Output()
+	assert.Nil(t, err)
+	assert.Contains(t, string(output), "Mode:  0600")
+
+	assert.NotNil(t, WriteFileToExt4(rootfs, hostFile, "relative/path", 0600))
+	assert.NotNil(t, WriteFileToExt4(filepath.Join(tempDir, "not-an-image"), hostFile, "/seed", 0600))
+}
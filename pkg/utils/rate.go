@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var bytesUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"kib": 1024,
+	"mib": 1024 * 1024,
+	"gib": 1024 * 1024 * 1024,
+}
+
+var rateRegex = regexp.MustCompile(`^(-?\d+)([a-z]*)(/s)?$`)
+
+// ParseBandwidth parses the bandwidth in bytes per second, for example: 10MiB/s, 500KB/s or 1048576.
+// The decimal KB, MB and GB and the binary KiB, MiB and GiB units are supported, the /s suffix is optional.
+// Zero means no limit.
+func ParseBandwidth(input string) (int64, error) {
+	value, unit, err := parseRate(input)
+	if err != nil {
+		return 0, err
+	}
+	return withBytesUnit("bandwidth", input, value, unit)
+}
+
+// ParseSize parses the size in bytes, for example: 10GiB, 500MB or 1048576.
+// The units are the units of ParseBandwidth.
+func ParseSize(input string) (int64, error) {
+	value, unit, err := parseRate(input)
+	if err != nil {
+		return 0, err
+	}
+	if strings.HasSuffix(strings.TrimSpace(input), "/s") {
+		return 0, fmt.Errorf("size '%s' is invalid", input)
+	}
+	return withBytesUnit("size", input, value, unit)
+}
+
+func withBytesUnit(kind, input string, value int64, unit string) (int64, error) {
+	multiplier, ok := bytesUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("%s '%s' has an unknown unit, expected one of: B, KB, MB, GB, KiB, MiB, GiB", kind, input)
+	}
+	if value > 0 && value > (1<<63-1)/multiplier {
+		return 0, fmt.Errorf("%s '%s' is out of range", kind, input)
+	}
+	return value * multiplier, nil
+}
+
+// ParseOpsRate parses the number of operations per second, for example: 1000/s or 1000.
+// Zero means no limit.
+func ParseOpsRate(input string) (int64, error) {
+	value, unit, err := parseRate(input)
+	if err != nil {
+		return 0, err
+	}
+	if unit != "" {
+		return 0, fmt.Errorf("operations rate '%s' must not have a unit", input)
+	}
+	return value, nil
+}
+
+func parseRate(input string) (int64, string, error) {
+	matches := rateRegex.FindStringSubmatch(strings.ToLower(strings.TrimSpace(input)))
+	if len(matches) == 0 {
+		return 0, "", fmt.Errorf("rate '%s' is invalid", input)
+	}
+	value, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("rate '%s' is invalid: %v", input, err)
+	}
+	if value < 0 {
+		return 0, "", fmt.Errorf("rate '%s' can't be negative", input)
+	}
+	return value, matches[2], nil
+}
@@ -0,0 +1,73 @@
+package utils
+
+import "testing"
+
+func TestParseBandwidth(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"0":        0,
+		"1048576":  1048576,
+		"100B/s":   100,
+		"10MiB/s":  10 * 1024 * 1024,
+		"10mib":    10 * 1024 * 1024,
+		"500KB/s":  500 * 1000,
+		"2GiB/s":   2 * 1024 * 1024 * 1024,
+		" 1Gb/s ":  1000 * 1000 * 1000,
+		"64KiB/s":  64 * 1024,
+		"1000kb/s": 1000 * 1000,
+	} {
+		value, err := ParseBandwidth(input)
+		if err != nil {
+			t.Fatalf("expected bandwidth %q to parse, got error: %v", input, err)
+		}
+		if value != expected {
+			t.Fatalf("expected bandwidth %q to be %d, got: %d", input, expected, value)
+		}
+	}
+	for _, input := range []string{"", "-1", "-10MiB/s", "10TiB/s", "10 MiB/s", "MiB/s", "1.5MiB/s", "10MiB/m", "99999999999GiB"} {
+		if _, err := ParseBandwidth(input); err == nil {
+			t.Fatalf("expected bandwidth %q to be rejected", input)
+		}
+	}
+}
+
+func TestParseOpsRate(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"0":      0,
+		"1000":   1000,
+		"1000/s": 1000,
+	} {
+		value, err := ParseOpsRate(input)
+		if err != nil {
+			t.Fatalf("expected operations rate %q to parse, got error: %v", input, err)
+		}
+		if value != expected {
+			t.Fatalf("expected operations rate %q to be %d, got: %d", input, expected, value)
+		}
+	}
+	for _, input := range []string{"", "-1", "-1/s", "10MiB/s", "fast"} {
+		if _, err := ParseOpsRate(input); err == nil {
+			t.Fatalf("expected operations rate %q to be rejected", input)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"4096":  4096,
+		"512MB": 512 * 1000 * 1000,
+		"10GiB": 10 * 1024 * 1024 * 1024,
+	} {
+		value, err := ParseSize(input)
+		if err != nil {
+			t.Fatalf("expected size %q to parse, got error: %v", input, err)
+		}
+		if value != expected {
+			t.Fatalf("expected size %q to be %d, got: %d", input, expected, value)
+		}
+	}
+	for _, input := range []string{"", "-1GiB", "10GiB/s", "10TiB"} {
+		if _, err := ParseSize(input); err == nil {
+			t.Fatalf("expected size %q to be rejected", input)
+		}
+	}
+}
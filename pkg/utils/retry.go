@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryConfig configures the retries of a failing operation.
+type RetryConfig struct {
+	// Attempts is the maximum number of attempts, including the first one.
+	// 1 disables the retries, 0 or less retries until the context is done.
+	Attempts int
+	// InitialBackoff is the delay before the first retry, doubled for every consecutive retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the upper bound of the delay, 0 for no bound.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the delay, between 0 and 1, by which the delay is randomly shortened
+	// so the operations failing at the same time are not retried at the same time.
+	Jitter float64
+}
+
+// DefaultRetryConfig returns the retry configuration of the network operations
+// used when the retries are not configured.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		Attempts:       4,
+		InitialBackoff: time.Second * 2,
+		Jitter:         0.1,
+		MaxBackoff:     time.Minute,
+	}
+}
+
+// Backoff returns the delay before the retry, the first retry is 0, without the jitter.
+func (c RetryConfig) Backoff(retry int) time.Duration {
+	backoff := c.InitialBackoff
+	for i := 0; i < retry; i++ {
+		if c.MaxBackoff > 0 && backoff >= c.MaxBackoff {
+			break
+		}
+		backoff = backoff * 2
+	}
+	if c.MaxBackoff > 0 && backoff > c.MaxBackoff {
+		return c.MaxBackoff
+	}
+	return backoff
+}
+
+// jittered returns the delay randomly shortened by up to the jitter fraction of the delay.
+func (c RetryConfig) jittered(backoff time.Duration) time.Duration {
+	if c.Jitter <= 0 || backoff <= 0 {
+		return backoff
+	}
+	jitter := c.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	return backoff - time.Duration(rand.Float64()*jitter*float64(backoff))
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks the error as not retryable, Retry returns the error immediately.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Retry calls the function until it succeeds, returns an error marked with Permanent,
+// the attempts are exhausted or the context is done. The function is always called at least once.
+// Returns the last error of the function.
+func Retry(ctx context.Context, config RetryConfig, fn func(context.Context) error) error {
+	return RetryNotify(ctx, config, fn, nil)
+}
+
+// RetryNotify is like Retry and calls the notify function with the failed attempt number,
+// starting with 1, the delay before the next attempt and the error of the failed attempt.
+func RetryNotify(ctx context.Context, config RetryConfig, fn func(context.Context) error, notify func(int, time.Duration, error)) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if permanent, ok := err.(*permanentError); ok {
+			return permanent.err
+		}
+		if (config.Attempts > 0 && attempt >= config.Attempts) || ctx.Err() != nil {
+			return err
+		}
+		backoff := config.jittered(config.Backoff(attempt - 1))
+		if notify != nil {
+			notify(attempt, backoff, err)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// errRetryableStatus is returned by the attempt which received a retryable status.
+var errRetryableStatus = errors.New("retryable response status")
+
+// NewRetryingTransport returns a transport retrying the requests failing with a network error,
+// a server error or a rate limit response. The request with a body is retried only if the body
+// can be read again, see SetReplayableBody. When the attempts are exhausted, the last response
+// or the last error is returned.
+func NewRetryingTransport(base http.RoundTripper, config RetryConfig, logger hclog.Logger) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+	return &retryingTransport{base: base, config: config, logger: logger}
+}
+
+type retryingTransport struct {
+	base   http.RoundTripper
+	config RetryConfig
+	logger hclog.Logger
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *retryingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	replayable := request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+	var response *http.Response
+	attempts := 0
+	err := RetryNotify(request.Context(), t.config, func(ctx context.Context) error {
+		attempts = attempts + 1
+		current := request
+		if attempts > 1 && request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return Permanent(err)
+			}
+			current = request.Clone(ctx)
+			current.Body = body
+		}
+		var err error
+		response, err = t.base.RoundTrip(current)
+		if err != nil {
+			if !replayable {
+				return Permanent(err)
+			}
+			return err
+		}
+		if replayable && IsRetryableStatus(response.StatusCode) {
+			return errRetryableStatus
+		}
+		return nil
+	}, func(attempt int, backoff time.Duration, err error) {
+		reason := err
+		if err == errRetryableStatus {
+			reason = fmt.Errorf("status %d", response.StatusCode)
+			// the response is replaced by the next attempt:
+			io.Copy(ioutil.Discard, io.LimitReader(response.Body, 4096))
+			response.Body.Close()
+		}
+		t.logger.Warn("request failed, retrying", "method", request.Method, "host", request.URL.Host,
+			"attempt", attempt, "attempts", t.config.Attempts, "backoff", backoff, "reason", reason)
+	})
+	if err == errRetryableStatus {
+		return response, nil
+	}
+	return response, err
+}
+
+// IsRetryableStatus returns true if the request failed with a transient status:
+// a rate limit, a server error or a timeout.
+func IsRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500
+}
+
+// SetReplayableBody sets the request body so the retrying transport can send the body again,
+// the body is read again from the current offset. The body is not closed by the transport.
+func SetReplayableBody(request *http.Request, body io.ReadSeeker) error {
+	offset, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	request.Body = ioutil.NopCloser(body)
+	request.GetBody = func() (io.ReadCloser, error) {
+		if _, err := body.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(body), nil
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryingTransport(t *testing.T) {
+	requests := 0
+	bodies := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = requests + 1
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRetryingTransport(nil, RetryConfig{Attempts: 4, InitialBackoff: time.Millisecond}, nil)}
+
+	request, err := http.NewRequest(http.MethodPut, server.URL, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, SetReplayableBody(request, bytes.NewReader([]byte("payload"))))
+	response, err := client.Do(request)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+
+	// the attempts exhausted, the last response is returned:
+	requests = 0
+	client = &http.Client{Transport: NewRetryingTransport(nil, RetryConfig{Attempts: 2, InitialBackoff: time.Millisecond}, nil)}
+	response, err = client.Get(server.URL)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	assert.Equal(t, 2, requests)
+
+	// the body which can't be read again is not retried:
+	requests = 0
+	response, err = client.Post(server.URL, "text/plain", ioutil.NopCloser(strings.NewReader("payload")))
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	assert.Equal(t, 1, requests)
+}
+
+func TestRetryingTransportClientErrorNotRetried(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = requests + 1
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: NewRetryingTransport(nil, RetryConfig{Attempts: 4, InitialBackoff: time.Millisecond}, nil)}
+	response, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	config := RetryConfig{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	for retry, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if backoff := config.Backoff(retry); backoff != expected {
+			t.Fatalf("expected backoff of retry %d to be %v, got: %v", retry, expected, backoff)
+		}
+	}
+	// without the upper bound:
+	config = RetryConfig{InitialBackoff: time.Second}
+	if backoff := config.Backoff(5); backoff != 32*time.Second {
+		t.Fatalf("expected unbounded backoff to be %v, got: %v", 32*time.Second, backoff)
+	}
+	// the jitter only shortens the delay:
+	config = RetryConfig{InitialBackoff: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if backoff := config.jittered(config.Backoff(1)); backoff > 2*time.Second || backoff < time.Second {
+			t.Fatalf("expected jittered backoff between 1s and 2s, got: %v", backoff)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	config := RetryConfig{Attempts: 4, InitialBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+
+	attempts := 0
+	err := Retry(context.Background(), config, func(context.Context) error {
+		attempts = attempts + 1
+		if attempts < 3 {
+			return fmt.Errorf("transient")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected retried operation to succeed, got error: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got: %d", attempts)
+	}
+
+	attempts = 0
+	backoffs := []time.Duration{}
+	err = RetryNotify(context.Background(), config, func(context.Context) error {
+		attempts = attempts + 1
+		return fmt.Errorf("attempt %d", attempts)
+	}, func(attempt int, backoff time.Duration, err error) {
+		backoffs = append(backoffs, backoff)
+	})
+	if err == nil || err.Error() != "attempt 4" {
+		t.Fatalf("expected the last error, got: %v", err)
+	}
+	if attempts != 4 {
+		t.Fatalf("expected all attempts, got: %d", attempts)
+	}
+	if fmt.Sprintf("%v", backoffs) != "[1ms 2ms 4ms]" {
+		t.Fatalf("expected backoff sequence, got: %v", backoffs)
+	}
+
+	attempts = 0
+	err = Retry(context.Background(), config, func(context.Context) error {
+		attempts = attempts + 1
+		return Permanent(fmt.Errorf("permanent"))
+	})
+	if err == nil || err.Error() != "permanent" {
+		t.Fatalf("expected the permanent error, got: %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected no retries of the permanent error, got attempts: %d", attempts)
+	}
+}
+
+func TestRetryContextCancellation(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	attempts := 0
+	err := Retry(ctx, RetryConfig{InitialBackoff: time.Hour}, func(context.Context) error {
+		attempts = attempts + 1
+		go cancelFunc()
+		return fmt.Errorf("transient")
+	})
+	if err == nil || err.Error() != "transient" {
+		t.Fatalf("expected the last error, got: %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected no retries after the context is cancelled, got attempts: %d", attempts)
+	}
+
+	// the retries until the context is done:
+	ctx, cancelFunc = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelFunc()
+	attempts = 0
+	started := time.Now()
+	err = Retry(ctx, RetryConfig{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}, func(context.Context) error {
+		attempts = attempts + 1
+		return fmt.Errorf("transient")
+	})
+	if err == nil {
+		t.Fatal("expected an error when the context is done")
+	}
+	if attempts < 2 {
+		t.Fatalf("expected retries until the context is done, got attempts: %d", attempts)
+	}
+	if time.Since(started) > time.Second {
+		t.Fatalf("expected retries to stop when the context is done, took: %v", time.Since(started))
+	}
+}
@@ -0,0 +1,326 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Semver is a parsed semantic version.
+type Semver struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	// parts is the number of the numeric version parts given in the input,
+	// the missing minor and patch versions are 0.
+	parts int
+}
+
+// ParseSemver parses the semantic version, for example 1.2.3, v1.2 or 1.0.0-rc.1.
+// The minor and patch versions are optional and default to 0. The build metadata is ignored.
+func ParseSemver(input string) (*Semver, error) {
+	version := strings.TrimPrefix(strings.TrimSpace(input), "v")
+	if idx := strings.Index(version, "+"); idx > -1 {
+		version = version[:idx]
+	}
+	result := &Semver{}
+	if idx := strings.Index(version, "-"); idx > -1 {
+		for _, identifier := range strings.Split(version[idx+1:], ".") {
+			if identifier == "" {
+				return nil, fmt.Errorf("version '%s' has an empty prerelease identifier", input)
+			}
+			result.Prerelease = append(result.Prerelease, identifier)
+		}
+		version = version[:idx]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("version '%s' has more than three parts", input)
+	}
+	numbers := []*uint64{&result.Major, &result.Minor, &result.Patch}
+	for idx, part := range parts {
+		if !isNumeric(part) {
+			return nil, fmt.Errorf("version '%s' part '%s' is not a number", input, part)
+		}
+		value, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("version '%s' part '%s' is not a number", input, part)
+		}
+		*numbers[idx] = value
+	}
+	result.parts = len(parts)
+	return result, nil
+}
+
+// String returns the MAJOR.MINOR.PATCH[-PRERELEASE] form of the version.
+func (v *Semver) String() string {
+	result := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		result = result + "-" + strings.Join(v.Prerelease, ".")
+	}
+	return result
+}
+
+// Compare returns -1, 0 or 1 when the version has lower, equal or higher precedence than the other version.
+// Like with the semantic versioning, a prerelease version has lower precedence than the release.
+func (v *Semver) Compare(other *Semver) int {
+	if c := compareUint(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(other.Prerelease) == 0:
+		return -1
+	}
+	for idx := 0; idx < len(v.Prerelease) && idx < len(other.Prerelease); idx++ {
+		if c := comparePrereleaseIdentifier(v.Prerelease[idx], other.Prerelease[idx]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(other.Prerelease)))
+}
+
+// CompareVersions compares the versions with the semantic versioning precedence
+// when both versions are semantic versions, lexically otherwise.
+func CompareVersions(a, b string) int {
+	semverA, errA := ParseSemver(a)
+	semverB, errB := ParseSemver(b)
+	if errA == nil && errB == nil {
+		if c := semverA.Compare(semverB); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// IsVersionConstraint returns true if the version is a constraint rather than a version:
+// the version starts with ^, ~, a comparison operator or contains an x or * wildcard part.
+func IsVersionConstraint(input string) bool {
+	if strings.HasPrefix(input, "^") || strings.HasPrefix(input, "~") ||
+		strings.HasPrefix(input, ">") || strings.HasPrefix(input, "<") || strings.HasPrefix(input, "=") {
+		return true
+	}
+	for _, part := range strings.Split(input, ".") {
+		if part == "x" || part == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// VersionConstraint is a parsed version constraint.
+type VersionConstraint struct {
+	original string
+	bounds   []versionBound
+}
+
+type versionBound struct {
+	operator string
+	version  *Semver
+}
+
+// ParseVersionConstraint parses the version constraint. Supported constraints:
+//   - ^1.2: compatible versions, >=1.2.0 and <2.0.0; ^0.2 is >=0.2.0 and <0.3.0
+//   - ~1.2: patch versions, >=1.2.0 and <1.3.0; ~1 is >=1.0.0 and <2.0.0
+//   - 1.x, 1.2.x, *: any version in place of the wildcard
+//   - >1.2, >=1.2, <2, <=2, =1.2.3: comparisons
+//
+// Multiple comma separated constraints must all match, for example >=1.2,<1.5.
+func ParseVersionConstraint(input string) (*VersionConstraint, error) {
+	result := &VersionConstraint{original: input}
+	for _, item := range strings.Split(input, ",") {
+		bounds, err := parseVersionBounds(strings.TrimSpace(item))
+		if err != nil {
+			return nil, fmt.Errorf("version constraint '%s' is invalid: %v", input, err)
+		}
+		result.bounds = append(result.bounds, bounds...)
+	}
+	return result, nil
+}
+
+// Matches returns true if the version satisfies the constraint.
+// The prerelease versions match only the constraints referring to a prerelease of the same version.
+func (c *VersionConstraint) Matches(version *Semver) bool {
+	if len(version.Prerelease) > 0 && !c.allowsPrerelease(version) {
+		return false
+	}
+	for _, bound := range c.bounds {
+		comparison := version.Compare(bound.version)
+		switch bound.operator {
+		case ">":
+			if comparison <= 0 {
+				return false
+			}
+		case ">=":
+			if comparison < 0 {
+				return false
+			}
+		case "<":
+			if comparison >= 0 {
+				return false
+			}
+		case "<=":
+			if comparison > 0 {
+				return false
+			}
+		default:
+			if comparison != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String returns the constraint as given.
+func (c *VersionConstraint) String() string {
+	return c.original
+}
+
+func (c *VersionConstraint) allowsPrerelease(version *Semver) bool {
+	for _, bound := range c.bounds {
+		if len(bound.version.Prerelease) > 0 &&
+			bound.version.Major == version.Major && bound.version.Minor == version.Minor && bound.version.Patch == version.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveVersionConstraint returns the highest of the versions satisfying the constraint.
+// The versions other than the semantic versions are skipped.
+func ResolveVersionConstraint(constraint string, versions []string) (string, error) {
+	parsedConstraint, err := ParseVersionConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+	resolved := ""
+	var resolvedSemver *Semver
+	for _, version := range versions {
+		semver, err := ParseSemver(version)
+		if err != nil || !parsedConstraint.Matches(semver) {
+			continue
+		}
+		if resolvedSemver == nil || semver.Compare(resolvedSemver) > 0 {
+			resolved, resolvedSemver = version, semver
+		}
+	}
+	if resolvedSemver == nil {
+		return "", fmt.Errorf("no version matches the constraint '%s'", constraint)
+	}
+	return resolved, nil
+}
+
+func parseVersionBounds(input string) ([]versionBound, error) {
+	if input == "" {
+		return nil, fmt.Errorf("empty constraint")
+	}
+	for _, operator := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(input, operator) {
+			version, err := ParseSemver(strings.TrimPrefix(input, operator))
+			if err != nil {
+				return nil, err
+			}
+			return []versionBound{{operator: operator, version: version}}, nil
+		}
+	}
+	if strings.HasPrefix(input, "^") || strings.HasPrefix(input, "~") {
+		lower, err := ParseSemver(input[1:])
+		if err != nil {
+			return nil, err
+		}
+		upper := &Semver{}
+		switch {
+		case strings.HasPrefix(input, "~") && lower.parts > 1:
+			upper.Major, upper.Minor = lower.Major, lower.Minor+1
+		case strings.HasPrefix(input, "~") || lower.Major > 0:
+			upper.Major = lower.Major + 1
+		case lower.Minor > 0 || lower.parts == 2:
+			upper.Minor = lower.Minor + 1
+		default:
+			upper.Minor, upper.Patch = lower.Minor, lower.Patch+1
+			if lower.parts == 1 {
+				upper.Major, upper.Minor, upper.Patch = 1, 0, 0
+			}
+		}
+		return []versionBound{{operator: ">=", version: lower}, {operator: "<", version: upper}}, nil
+	}
+	return parseWildcardBounds(input)
+}
+
+func parseWildcardBounds(input string) ([]versionBound, error) {
+	if !IsVersionConstraint(input) {
+		version, err := ParseSemver(input)
+		if err != nil {
+			return nil, err
+		}
+		return []versionBound{{operator: "=", version: version}}, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(input, "v"), ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("version '%s' has more than three parts", input)
+	}
+	fixed := []string{}
+	for _, part := range parts {
+		if part == "x" || part == "*" {
+			break
+		}
+		fixed = append(fixed, part)
+	}
+	for _, part := range parts[len(fixed):] {
+		if part != "x" && part != "*" {
+			return nil, fmt.Errorf("version '%s' has a number after a wildcard", input)
+		}
+	}
+	if len(fixed) == 0 {
+		return []versionBound{{operator: ">=", version: &Semver{}}}, nil
+	}
+	return parseVersionBounds("~" + strings.Join(fixed, "."))
+}
+
+func comparePrereleaseIdentifier(a, b string) int {
+	numericA, numericB := isNumeric(a), isNumeric(b)
+	switch {
+	case numericA && numericB:
+		valueA, _ := strconv.ParseUint(a, 10, 64)
+		valueB, _ := strconv.ParseUint(b, 10, 64)
+		return compareUint(valueA, valueB)
+	case numericA:
+		return -1
+	case numericB:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isNumeric(input string) bool {
+	if input == "" {
+		return false
+	}
+	for _, r := range input {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	for input, expected := range map[string]string{
+		"1.2.3":        "1.2.3",
+		"v1.2":         "1.2.0",
+		"13":           "13.0.0",
+		"1.0.0-rc.1":   "1.0.0-rc.1",
+		"1.0.0+build5": "1.0.0",
+	} {
+		version, err := ParseSemver(input)
+		if err != nil {
+			t.Fatalf("expected %q to parse, got error: %v", input, err)
+		}
+		if version.String() != expected {
+			t.Fatalf("expected %q to parse as %q, got: %q", input, expected, version.String())
+		}
+	}
+	for _, input := range []string{"", "latest", "1.2.3.4", "1.a", "1.0.0-", "buster-slim"} {
+		if _, err := ParseSemver(input); err == nil {
+			t.Fatalf("expected %q not to parse", input)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	versions := []string{"1.10.0", "latest", "1.9.0", "1.0.0", "1.0.0-rc.1", "1.0.0-rc.10", "1.0.0-beta", "2"}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return CompareVersions(versions[i], versions[j]) < 0
+	})
+	expected := []string{"1.0.0-beta", "1.0.0-rc.1", "1.0.0-rc.10", "1.0.0", "1.9.0", "1.10.0", "2", "latest"}
+	for idx := range expected {
+		if versions[idx] != expected[idx] {
+			t.Fatalf("expected versions sorted as %v, got: %v", expected, versions)
+		}
+	}
+}
+
+func TestResolveVersionConstraint(t *testing.T) {
+	versions := []string{"0.1.0", "0.2.1", "0.2.5", "1.2.0", "1.2.7", "1.9.0", "1.10.0", "2.0.0-rc.1", "2.0.0", "2.1.0", "latest"}
+	for constraint, expected := range map[string]string{
+		"^1.2":         "1.10.0",
+		"^1.2.8":       "1.10.0",
+		"~1.2":         "1.2.7",
+		"~1":           "1.10.0",
+		"^0.2":         "0.2.5",
+		"^0.1":         "0.1.0",
+		"1.x":          "1.10.0",
+		"1.2.x":        "1.2.7",
+		"*":            "2.1.0",
+		">=1.2,<1.10":  "1.9.0",
+		"<2":           "1.10.0",
+		"=1.2.0":       "1.2.0",
+		"2.0.0-rc.1":   "2.0.0-rc.1",
+		">=2.0.0-rc.1": "2.1.0",
+	} {
+		resolved, err := ResolveVersionConstraint(constraint, versions)
+		if err != nil {
+			t.Fatalf("expected constraint %q to resolve, got error: %v", constraint, err)
+		}
+		if resolved != expected {
+			t.Fatalf("expected constraint %q to resolve to %q, got: %q", constraint, expected, resolved)
+		}
+	}
+	// the prerelease versions don't satisfy the ranges:
+	if resolved, err := ResolveVersionConstraint("^2.0.0-rc.1,<2.0.0", versions); err != nil || resolved != "2.0.0-rc.1" {
+		t.Fatalf("expected prerelease constraint to resolve to the prerelease, got: %q, %v", resolved, err)
+	}
+	if _, err := ResolveVersionConstraint("^3", versions); err == nil {
+		t.Fatal("expected constraint without a matching version to fail")
+	}
+	for _, constraint := range []string{"^", "^a.b", "1.x.2", ">=1.2,", "~1.2.3.4"} {
+		if _, err := ParseVersionConstraint(constraint); err == nil {
+			t.Fatalf("expected constraint %q to be invalid", constraint)
+		}
+	}
+	for input, expected := range map[string]bool{"^1.2": true, "~1": true, ">=1": true, "1.x": true, "*": true, "1.2.3": false, "latest": false} {
+		if IsVersionConstraint(input) != expected {
+			t.Fatalf("expected %q constraint detection to be %v", input, expected)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package utils
+
+import "strings"
+
+// ShellQuote quotes the input for the POSIX shell.
+func ShellQuote(input string) string {
+	return "'" + strings.ReplaceAll(input, "'", `'\''`) + "'"
+}
+
+// ShellJoin quotes every argument for the POSIX shell and joins them into a command line,
+// the shell reads back the same arguments.
+func ShellJoin(args []string) string {
+	quoted := []string{}
+	for _, arg := range args {
+		quoted = append(quoted, ShellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellJoin(t *testing.T) {
+	args := []string{"sh", "-c", "echo a b", "it's", "$HOME", ""}
+	assert.Equal(t, `'sh' '-c' 'echo a b' 'it'\''s' '$HOME' ''`, ShellJoin(args))
+
+	// the shell reads back the same arguments:
+	output, err := exec.Command("sh", "-c", ShellJoin([]string{"printf", "[%s]", "a b", "it's", "$HOME", ""})).Output()
+	assert.Nil(t, err)
+	assert.Equal(t, "[a b][it's][$HOME][]", string(output))
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// GitHubKeysURL is the base URL of the GitHub public SSH keys endpoint,
+// the keys of a user are served at <GitHubKeysURL>/<username>.keys.
+var GitHubKeysURL = "https://github.com"
+
+// GitHubKeysTimeout is the maximum time to wait for the GitHub public SSH keys.
+var GitHubKeysTimeout = 10 * time.Second
+
+// SSHImportIDGitHubPrefix is the prefix of the GitHub SSH import ID.
+const SSHImportIDGitHubPrefix = "gh:"
+
+var gitHubUsernameRegex = regexp.MustCompile("^[a-zA-Z0-9](?:[a-zA-Z0-9]|-[a-zA-Z0-9]){0,38}$")
+
+// ParseSSHImportID parses the gh:username SSH import ID and returns the GitHub username.
+func ParseSSHImportID(importID string) (string, error) {
+	if !strings.HasPrefix(importID, SSHImportIDGitHubPrefix) {
+		return "", fmt.Errorf("SSH import ID '%s' not supported, expected gh:username", importID)
+	}
+	username := strings.TrimPrefix(importID, SSHImportIDGitHubPrefix)
+	if !gitHubUsernameRegex.MatchString(username) {
+		return "", fmt.Errorf("SSH import ID '%s' is not a valid GitHub username", importID)
+	}
+	return username, nil
+}
+
+var guestUsernameRegex = regexp.MustCompile("^[a-z_][a-z0-9_-]{0,31}$")
+
+// ParseUserIdentityFile parses the user:path user identity file and returns the guest user and the path.
+func ParseUserIdentityFile(input string) (string, string, error) {
+	parts := strings.SplitN(input, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("user identity file '%s' is invalid, expected user:path", input)
+	}
+	if !guestUsernameRegex.MatchString(parts[0]) {
+		return "", "", fmt.Errorf("user identity file '%s' does not have a valid user name", input)
+	}
+	return parts[0], parts[1], nil
+}
+
+// SSHPublicKeysFromDirectory reads all *.pub SSH public keys from the directory, in the file name order.
+func SSHPublicKeysFromDirectory(path string) ([]ssh.PublicKey, error) {
+	if _, err := CheckIfExistsAndIsDirectory(path); err != nil {
+		return nil, errors.Wrapf(err, "failed looking up the SSH key directory '%s'", path)
+	}
+	matches, err := filepath.Glob(filepath.Join(path, "*.pub"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed listing the SSH key directory '%s'", path)
+	}
+	sort.Strings(matches)
+	keys := []ssh.PublicKey{}
+	for _, match := range matches {
+		key, err := SSHPublicKeyFromFile(match)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed reading the SSH key file '%s'", match)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SSHPublicKeysFromGitHub fetches the public SSH keys of the GitHub user.
+// Fails when GitHub can't be reached, the user does not exist or has no keys.
+func SSHPublicKeysFromGitHub(username string) ([]ssh.PublicKey, error) {
+	client := &http.Client{Timeout: GitHubKeysTimeout}
+	url := fmt.Sprintf("%s/%s.keys", strings.TrimSuffix(GitHubKeysURL, "/"), username)
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed fetching SSH keys of GitHub user '%s'", username)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed fetching SSH keys of GitHub user '%s': %s", username, response.Status)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading SSH keys of GitHub user '%s'", username)
+	}
+	keys := []ssh.PublicKey{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		key, err := SSHPublicKeyFromBytes([]byte(line))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid SSH key of GitHub user '%s'", username)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("GitHub user '%s' has no SSH keys", username)
+	}
+	return keys, nil
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSSHPublicKey(t *testing.T) []byte {
+	privateKey, err := GenerateRSAPrivateKey(1024)
+	if err != nil {
+		t.Fatal("expected private key to be generated, got error", err)
+	}
+	publicKey, err := GetSSHKey(privateKey)
+	if err != nil {
+		t.Fatal("expected public key, got error", err)
+	}
+	return MarshalSSHPublicKey(publicKey)
+}
+
+func TestParseSSHImportID(t *testing.T) {
+	username, err := ParseSSHImportID("gh:some-user")
+	assert.Nil(t, err)
+	assert.Equal(t, "some-user", username)
+	for _, input := range []string{"some-user", "lp:some-user", "gh:", "gh:-user", "gh:some/user"} {
+		_, err := ParseSSHImportID(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestParseUserIdentityFile(t *testing.T) {
+	user, path, err := ParseUserIdentityFile("deploy:/home/me/.ssh/id_rsa.pub")
+	assert.Nil(t, err)
+	assert.Equal(t, "deploy", user)
+	assert.Equal(t, "/home/me/.ssh/id_rsa.pub", path)
+	for _, input := range []string{"deploy", "deploy:", ":/path", "Deploy:/path", "1user:/path", "de ploy:/path"} {
+		_, _, err := ParseUserIdentityFile(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestSSHPublicKeysFromDirectory(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	keys, err := SSHPublicKeysFromDirectory(tempDir)
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	for _, name := range []string{"id_a.pub", "id_b.pub"} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, name), testSSHPublicKey(t), 0644))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tempDir, "id_a"), []byte("not a public key"), 0600))
+
+	keys, err = SSHPublicKeysFromDirectory(tempDir)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 2)
+
+	_, err = SSHPublicKeysFromDirectory(filepath.Join(tempDir, "does-not-exist"))
+	assert.NotNil(t, err)
+}
+
+func TestSSHPublicKeysFromGitHub(t *testing.T) {
+	key1 := testSSHPublicKey(t)
+	key2 := testSSHPublicKey(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/some-user.keys":
+			fmt.Fprintf(w, "%s%s\n", key1, key2)
+		case "/no-keys.keys":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	previousURL := GitHubKeysURL
+	GitHubKeysURL = server.URL
+	defer func() { GitHubKeysURL = previousURL }()
+
+	keys, err := SSHPublicKeysFromGitHub("some-user")
+	assert.Nil(t, err)
+	assert.Len(t, keys, 2)
+
+	_, err = SSHPublicKeysFromGitHub("no-keys")
+	assert.NotNil(t, err)
+
+	_, err = SSHPublicKeysFromGitHub("unknown-user")
+	assert.NotNil(t, err)
+
+	// network failure:
+	server.Close()
+	_, err = SSHPublicKeysFromGitHub("some-user")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "some-user")
+}
@@ -1,21 +1,65 @@
 package utils
 
-import "regexp"
+import (
+	"regexp"
+	"strings"
+)
 
-const regexpString = "([a-z0-9\\-]{1,60})/([a-z0-9\\-]{1,60}):([a-z0-9.]{1,15})"
+const regexpString = "^([a-z0-9\\-]{1,60})/([a-z0-9\\-]{1,60}):([a-z0-9.]{1,15})$"
+const nameRegexpString = "^([a-z0-9\\-]{1,60})/([a-z0-9\\-]{1,60})$"
+
+// NormalizeTag returns the tag in the normalized, lowercase form.
+// Like the Docker repository names, the tags are stored in lowercase only
+// so a tag given with the uppercase characters resolves to the same rootfs.
+func NormalizeTag(input string) string {
+	return strings.ToLower(strings.TrimSpace(input))
+}
 
 // IsValidTag checks if the given image tag is valid.
+// The tag is validated in the normalized form.
 func IsValidTag(input string) bool {
 	re := regexp.MustCompile(regexpString)
-	return re.Match([]byte(input))
+	return re.Match([]byte(NormalizeTag(input)))
 }
 
-// TagDecompose decomposes the tag into the image components.
+// TagDecompose decomposes the tag into the normalized image components.
 func TagDecompose(input string) (bool, string, string, string) {
 	re := regexp.MustCompile(regexpString)
-	parts := re.FindSubmatch([]byte(input))
+	parts := re.FindSubmatch([]byte(NormalizeTag(input)))
 	if len(parts) == 4 { // must be 4:
 		return true, string(parts[1]), string(parts[2]), string(parts[3])
 	}
 	return false, "", "", ""
 }
+
+// TagWithDefaultVersion returns the tag with the version appended when the tag has no version,
+// for example tests/postgres becomes tests/postgres:latest.
+func TagWithDefaultVersion(input, version string) string {
+	if strings.Contains(input[strings.LastIndex(input, "/")+1:], ":") {
+		return input
+	}
+	return input + ":" + version
+}
+
+// ReferenceDecompose decomposes the rootfs reference into the normalized image components.
+// The reference is a tag with an optional version, the default version is returned for
+// a reference without the version. The version may be a version constraint, for example ^1.2.
+func ReferenceDecompose(input, defaultVersion string) (bool, string, string, string) {
+	normalized := NormalizeTag(input)
+	name, version := normalized, defaultVersion
+	if idx := strings.LastIndex(normalized, ":"); idx > strings.LastIndex(normalized, "/") {
+		name, version = normalized[:idx], normalized[idx+1:]
+	}
+	if !IsVersionConstraint(version) {
+		return TagDecompose(name + ":" + version)
+	}
+	if _, err := ParseVersionConstraint(version); err != nil {
+		return false, "", "", ""
+	}
+	re := regexp.MustCompile(nameRegexpString)
+	parts := re.FindStringSubmatch(name)
+	if len(parts) == 3 { // must be 3:
+		return true, parts[1], parts[2], version
+	}
+	return false, "", "", ""
+}
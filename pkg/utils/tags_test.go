@@ -25,3 +25,66 @@ func TestTagDecompose(t *testing.T) {
 		t.Fatalf("expected different than parsed: %q vs %q", expectedVer, version)
 	}
 }
+
+func TestTagNormalization(t *testing.T) {
+
+	ok, org, image, version := TagDecompose("Combust-Labs/Image-Name:V1.0")
+	if !ok {
+		t.Fatal("expected mixed-case tag to decompose")
+	}
+	if org != "combust-labs" || image != "image-name" || version != "v1.0" {
+		t.Fatalf("expected normalized components, got: %q %q %q", org, image, version)
+	}
+	if normalized := NormalizeTag(" Tests/Postgres:13 "); normalized != "tests/postgres:13" {
+		t.Fatalf("expected normalized tag, got: %q", normalized)
+	}
+	if !IsValidTag("TESTS/POSTGRES:13") {
+		t.Fatal("expected uppercase tag to be valid in the normalized form")
+	}
+	// the tag must match as a whole, not a valid part of it:
+	for _, input := range []string{"Tests/Ímage:1.0", "tests/image:1.0-rc", "registry/tests/image:1.0"} {
+		if IsValidTag(input) {
+			t.Fatalf("expected tag %q to be invalid", input)
+		}
+		if ok, _, _, _ := TagDecompose(input); ok {
+			t.Fatalf("expected tag %q not to decompose", input)
+		}
+	}
+}
+
+func TestTagWithDefaultVersion(t *testing.T) {
+	for input, expected := range map[string]string{
+		"tests/postgres":     "tests/postgres:latest",
+		"tests/postgres:13":  "tests/postgres:13",
+		"postgres":           "postgres:latest",
+		"tests/postgres:^13": "tests/postgres:^13",
+	} {
+		if result := TagWithDefaultVersion(input, "latest"); result != expected {
+			t.Fatalf("expected %q, got: %q", expected, result)
+		}
+	}
+}
+
+func TestReferenceDecompose(t *testing.T) {
+	for input, expected := range map[string][3]string{
+		"tests/postgres":            {"tests", "postgres", "latest"},
+		"Tests/Postgres:13":         {"tests", "postgres", "13"},
+		"tests/postgres:latest":     {"tests", "postgres", "latest"},
+		"tests/postgres:^1.2":       {"tests", "postgres", "^1.2"},
+		"tests/postgres:>=1.2,<1.5": {"tests", "postgres", ">=1.2,<1.5"},
+		"tests/postgres:1.x":        {"tests", "postgres", "1.x"},
+	} {
+		ok, org, image, version := ReferenceDecompose(input, "latest")
+		if !ok {
+			t.Fatalf("expected reference %q to decompose", input)
+		}
+		if org != expected[0] || image != expected[1] || version != expected[2] {
+			t.Fatalf("expected %v for %q, got: %q %q %q", expected, input, org, image, version)
+		}
+	}
+	for _, input := range []string{"postgres", "tests/postgres:", "tests/postgres:^x", "registry/tests/postgres:^1.2", "tests/postgres:1.0-rc"} {
+		if ok, _, _, _ := ReferenceDecompose(input, "latest"); ok {
+			t.Fatalf("expected reference %q not to decompose", input)
+		}
+	}
+}
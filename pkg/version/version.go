@@ -0,0 +1,83 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+const firecrackerSDKModule = "github.com/firecracker-microvm/firecracker-go-sdk"
+
+// The values are set by the main package from the ldflags.
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// Info is the version and build information of the binary.
+type Info struct {
+	Version               string `json:"Version"`
+	Commit                string `json:"Commit"`
+	BuildDate             string `json:"BuildDate"`
+	GoVersion             string `json:"GoVersion"`
+	Platform              string `json:"Platform"`
+	FirecrackerSDKVersion string `json:"FirecrackerSDKVersion"`
+}
+
+// Set sets the version, git commit and build date embedded in the binary.
+// Empty values are ignored.
+func Set(newVersion, newCommit, newBuildDate string) {
+	if newVersion != "" {
+		version = newVersion
+	}
+	if newCommit != "" {
+		commit = newCommit
+	}
+	if newBuildDate != "" {
+		buildDate = newBuildDate
+	}
+}
+
+// Get returns the version and build information.
+func Get() Info {
+	return Info{
+		Version:               version,
+		Commit:                commit,
+		BuildDate:             buildDate,
+		GoVersion:             runtime.Version(),
+		Platform:              fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+		FirecrackerSDKVersion: firecrackerSDKVersion(),
+	}
+}
+
+// String returns the multiline text representation of the build information.
+func (i Info) String() string {
+	return strings.Join([]string{
+		fmt.Sprintf("version:         %s", i.Version),
+		fmt.Sprintf("commit:          %s", i.Commit),
+		fmt.Sprintf("build date:      %s", i.BuildDate),
+		fmt.Sprintf("go version:      %s", i.GoVersion),
+		fmt.Sprintf("platform:        %s", i.Platform),
+		fmt.Sprintf("firecracker sdk: %s", i.FirecrackerSDKVersion),
+	}, "\n")
+}
+
+// firecrackerSDKVersion returns the version of the firecracker SDK module linked into the binary.
+func firecrackerSDKVersion() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range buildInfo.Deps {
+		if dep.Path != firecrackerSDKModule {
+			continue
+		}
+		if dep.Replace != nil {
+			return fmt.Sprintf("%s => %s %s", dep.Version, dep.Replace.Path, dep.Replace.Version)
+		}
+		return dep.Version
+	}
+	return "unknown"
+}
@@ -0,0 +1,27 @@
+package version
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSet(t *testing.T) {
+	previousVersion, previousCommit, previousBuildDate := version, commit, buildDate
+	defer func() { version, commit, buildDate = previousVersion, previousCommit, previousBuildDate }()
+
+	Set("", "", "")
+	info := Get()
+	assert.Equal(t, "dev", info.Version)
+	assert.Equal(t, "unknown", info.Commit)
+	assert.Equal(t, "unknown", info.BuildDate)
+
+	Set("v0.1.0", "abcdef", "2021-04-01T00:00:00Z")
+	info = Get()
+	assert.Equal(t, "v0.1.0", info.Version)
+	assert.Equal(t, "abcdef", info.Commit)
+	assert.Equal(t, "2021-04-01T00:00:00Z", info.BuildDate)
+	assert.NotEmpty(t, info.FirecrackerSDKVersion)
+	assert.True(t, strings.Contains(info.String(), "version:         v0.1.0"))
+}
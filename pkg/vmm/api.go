@@ -0,0 +1,70 @@
+package vmm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrVMMSocketNotFound is returned when the VMM API socket file does not exist,
+// the VMM is gone or the chroot was removed.
+var ErrVMMSocketNotFound = errors.New("VMM socket file not found")
+
+// fcAPIClient calls the Firecracker API endpoints
+// not exposed by the Firecracker SDK version in use.
+type fcAPIClient struct {
+	httpClient *http.Client
+}
+
+func newFcAPIClient(socketPath string) *fcAPIClient {
+	return &fcAPIClient{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// call calls the API endpoint, the body is sent as JSON, if not nil.
+// If the result is not nil, the response body is decoded into the result.
+func (c *fcAPIClient) call(ctx context.Context, method, path string, body, result interface{}) error {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "failed serializing request body")
+		}
+		bodyReader = bytes.NewReader(bodyBytes)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, "http://localhost"+path, bodyReader)
+	if err != nil {
+		return errors.Wrap(err, "failed creating request")
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s failed", method, path)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusMultipleChoices {
+		responseBytes, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, response.StatusCode, string(responseBytes))
+	}
+	if result != nil {
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+			return errors.Wrapf(err, "failed decoding %s %s response", method, path)
+		}
+	}
+	return nil
+}
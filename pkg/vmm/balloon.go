@@ -0,0 +1,89 @@
+package vmm
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/vmm/chroot"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/pkg/errors"
+)
+
+// AddBalloonHandlerName is the name of the handler attaching the balloon device.
+const AddBalloonHandlerName = "firebuild.AddBalloon"
+
+// BalloonStats represents the balloon device statistics.
+// The guest memory statistics are available only when the stats polling interval is set.
+type BalloonStats struct {
+	TargetPages        int64  `json:"target_pages"`
+	ActualPages        int64  `json:"actual_pages"`
+	TargetMib          int64  `json:"target_mib"`
+	ActualMib          int64  `json:"actual_mib"`
+	SwapIn             *int64 `json:"swap_in,omitempty"`
+	SwapOut            *int64 `json:"swap_out,omitempty"`
+	MajorFaults        *int64 `json:"major_faults,omitempty"`
+	MinorFaults        *int64 `json:"minor_faults,omitempty"`
+	FreeMemory         *int64 `json:"free_memory,omitempty"`
+	TotalMemory        *int64 `json:"total_memory,omitempty"`
+	AvailableMemory    *int64 `json:"available_memory,omitempty"`
+	DiskCaches         *int64 `json:"disk_caches,omitempty"`
+	HugetlbAllocations *int64 `json:"hugetlb_allocations,omitempty"`
+	HugetlbFailures    *int64 `json:"hugetlb_failures,omitempty"`
+}
+
+func (p *defaultProvider) BalloonStats(ctx context.Context, vmmID string) (*BalloonStats, error) {
+	stats := &BalloonStats{}
+	if err := p.apiClient(vmmID).call(ctx, http.MethodGet, "/balloon/statistics", nil, stats); err != nil {
+		return nil, errors.Wrap(err, "failed fetching balloon statistics")
+	}
+	return stats, nil
+}
+
+func (p *defaultProvider) UpdateBalloon(ctx context.Context, vmmID string, targetMib int64) error {
+	if err := p.apiClient(vmmID).call(ctx, http.MethodPatch, "/balloon", map[string]interface{}{
+		"amount_mib": targetMib,
+	}, nil); err != nil {
+		return errors.Wrap(err, "failed updating balloon")
+	}
+	return nil
+}
+
+func (p *defaultProvider) apiClient(vmmID string) *fcAPIClient {
+	machineChroot := chroot.NewWithLocation(chroot.LocationFromComponents(p.jailingFcConfig.ChrootBase,
+		p.jailingFcConfig.BinaryFirecracker,
+		vmmID))
+	return newFcAPIClient(machineChroot.SocketPath())
+}
+
+// existingAPIClient returns the API client of the VMM, ErrVMMSocketNotFound if the VMM socket file does not exist.
+func (p *defaultProvider) existingAPIClient(vmmID string) (*fcAPIClient, error) {
+	machineChroot := chroot.NewWithLocation(chroot.LocationFromComponents(p.jailingFcConfig.ChrootBase,
+		p.jailingFcConfig.BinaryFirecracker,
+		vmmID))
+	socketPath, hasSocket, err := machineChroot.SocketPathIfExists()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed checking if the VMM socket file exists")
+	}
+	if !hasSocket {
+		return nil, errors.Wrapf(ErrVMMSocketNotFound, "'%s' does not exist, the VMM metadata may be stale", socketPath)
+	}
+	return newFcAPIClient(socketPath), nil
+}
+
+// balloonHandler attaches the balloon device before the instance is started.
+func balloonHandler(machineConfig *configs.MachineConfig) firecracker.Handler {
+	return firecracker.Handler{
+		Name: AddBalloonHandlerName,
+		Fn: func(ctx context.Context, m *firecracker.Machine) error {
+			if err := newFcAPIClient(m.Cfg.SocketPath).call(ctx, http.MethodPut, "/balloon", map[string]interface{}{
+				"amount_mib":               machineConfig.BalloonTargetMib,
+				"deflate_on_oom":           machineConfig.BalloonDeflateOnOOM,
+				"stats_polling_interval_s": machineConfig.BalloonStatsPollingIntervalSeconds,
+			}, nil); err != nil {
+				return errors.Wrap(err, "failed attaching balloon device")
+			}
+			return nil
+		},
+	}
+}
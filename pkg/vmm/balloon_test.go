@@ -0,0 +1,69 @@
+package vmm
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/combust-labs/firebuild/configs"
+)
+
+func TestBalloonUpdateAndStats(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	jailingFcConfig := configs.NewJailingFirecrackerConfig()
+	jailingFcConfig.ChrootBase = tempDir
+	jailingFcConfig.BinaryFirecracker = "/usr/bin/firecracker"
+
+	socketDir := filepath.Join(tempDir, "firecracker", "vmmid", "root", "run")
+	if err := os.MkdirAll(socketDir, 0755); err != nil {
+		t.Fatal("expected socket dir, got error", err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(socketDir, "firecracker.socket"))
+	if err != nil {
+		t.Fatal("expected unix listener, got error", err)
+	}
+
+	updates := make(chan map[string]interface{}, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPatch && r.URL.Path == "/balloon":
+			body := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&body)
+			updates <- body
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && r.URL.Path == "/balloon/statistics":
+			w.Write([]byte(`{"target_pages":8192,"actual_pages":4096,"target_mib":32,"actual_mib":16,"free_memory":1024}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	provider := NewDefaultProvider(configs.NewCNIConfig(), jailingFcConfig, configs.NewMachineConfig())
+
+	if err := provider.UpdateBalloon(context.Background(), "vmmid", 32); err != nil {
+		t.Fatal("expected balloon update to succeed, got error", err)
+	}
+	if body := <-updates; body["amount_mib"] != float64(32) {
+		t.Fatal("unexpected balloon update body", body)
+	}
+
+	stats, err := provider.BalloonStats(context.Background(), "vmmid")
+	if err != nil {
+		t.Fatal("expected balloon stats, got error", err)
+	}
+	if stats.TargetMib != 32 || stats.ActualMib != 16 || stats.FreeMemory == nil || *stats.FreeMemory != 1024 || stats.TotalMemory != nil {
+		t.Fatal("unexpected balloon stats", stats)
+	}
+}
@@ -0,0 +1,71 @@
+package chroot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultProcDir = "/proc"
+
+// List returns the locations of all VMM chroots under the chroot base, for every Firecracker binary.
+// Returns no locations if the chroot base does not exist.
+func List(chrootBase string) ([]*Location, error) {
+	locations := []*Location{}
+	binaryDirs, err := ioutil.ReadDir(chrootBase)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return locations, nil
+		}
+		return nil, err
+	}
+	for _, binaryDir := range binaryDirs {
+		if !binaryDir.IsDir() {
+			continue
+		}
+		vmmDirs, err := ioutil.ReadDir(filepath.Join(chrootBase, binaryDir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, vmmDir := range vmmDirs {
+			if !vmmDir.IsDir() {
+				continue
+			}
+			locations = append(locations, LocationFromComponents(chrootBase, binaryDir.Name(), vmmDir.Name()))
+		}
+	}
+	return locations, nil
+}
+
+// RunningVMMIDs returns the IDs of the VMMs with a process jailed in a chroot under the chroot base.
+// The jailer changes the root of the Firecracker process to the root directory of the chroot
+// so the VMM is found even if it has no run cache entry.
+func RunningVMMIDs(chrootBase string) (map[string]bool, error) {
+	return runningVMMIDs(defaultProcDir, chrootBase)
+}
+
+func runningVMMIDs(procDir, chrootBase string) (map[string]bool, error) {
+	result := map[string]bool{}
+	processDirs, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Clean(chrootBase) + string(filepath.Separator)
+	for _, processDir := range processDirs {
+		if strings.Trim(processDir.Name(), "0123456789") != "" {
+			continue
+		}
+		root, err := os.Readlink(filepath.Join(procDir, processDir.Name(), "root"))
+		if err != nil || !strings.HasPrefix(root, base) {
+			// the process is gone or not ours to inspect
+			continue
+		}
+		// <chroot base>/<firecracker binary>/<vmm id>/root:
+		parts := strings.Split(strings.TrimPrefix(root, base), string(filepath.Separator))
+		if len(parts) == 3 && parts[2] == "root" {
+			result[parts[1]] = true
+		}
+	}
+	return result, nil
+}
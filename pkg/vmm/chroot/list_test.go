@@ -0,0 +1,54 @@
+package chroot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	chrootBase, err := ioutil.TempDir("", "chroot-list")
+	assert.Nil(t, err)
+	defer os.RemoveAll(chrootBase)
+
+	locations, err := List(filepath.Join(chrootBase, "missing"))
+	assert.Nil(t, err)
+	assert.Empty(t, locations)
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(chrootBase, "firecracker", "vmm1", "root"), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(chrootBase, "firecracker-v0.22", "vmm2"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(chrootBase, "firecracker", "not-a-vmm"), []byte{}, 0644))
+
+	locations, err = List(chrootBase)
+	assert.Nil(t, err)
+	ids := []string{}
+	for _, location := range locations {
+		ids = append(ids, location.VMMID)
+	}
+	assert.Equal(t, []string{"vmm1", "vmm2"}, ids)
+	assert.Equal(t, filepath.Join(chrootBase, "firecracker", "vmm1"), locations[0].FullPath())
+}
+
+func TestRunningVMMIDs(t *testing.T) {
+	procDir, err := ioutil.TempDir("", "chroot-proc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(procDir)
+
+	links := map[string]string{
+		"100":  "/srv/jailer/firecracker/vmm1/root",
+		"101":  "/",
+		"102":  "/srv/jailer/firecracker/vmm2/root/run",
+		"self": "/srv/jailer/firecracker/vmm3/root",
+	}
+	for pid, target := range links {
+		assert.Nil(t, os.MkdirAll(filepath.Join(procDir, pid), 0755))
+		assert.Nil(t, os.Symlink(target, filepath.Join(procDir, pid, "root")))
+	}
+
+	ids, err := runningVMMIDs(procDir, "/srv/jailer/")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"vmm1": true}, ids)
+}
@@ -0,0 +1,77 @@
+package cni
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/pkg/errors"
+)
+
+// cachedResultKind is the kind of the libcni cached attachment result.
+const cachedResultKind = "cniCacheV1"
+
+// CachedAttachment is a CNI network attachment of a VMM recorded in the CNI cache directory.
+type CachedAttachment struct {
+	ContainerID string
+	IfName      string
+	NetworkName string
+	// Path is the cache directory of the VMM.
+	Path    string
+	ModTime time.Time
+}
+
+type cachedResult struct {
+	Kind        string `json:"kind"`
+	ContainerID string `json:"containerId"`
+	IfName      string `json:"ifName"`
+	NetworkName string `json:"networkName"`
+}
+
+// CachedAttachments returns the network attachments cached in the VMM directories of the CNI cache directory.
+// The Firecracker SDK caches the results in a directory per VMM, the directories without cached results are skipped.
+func CachedAttachments(cniConfig *configs.CNIConfig) ([]*CachedAttachment, error) {
+	attachments := []*CachedAttachment{}
+	vmmDirs, err := ioutil.ReadDir(cniConfig.CacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return attachments, nil
+		}
+		return nil, errors.Wrapf(err, "--cni-cache-dir '%s' could not be read", cniConfig.CacheDir)
+	}
+	for _, vmmDir := range vmmDirs {
+		if !vmmDir.IsDir() {
+			continue
+		}
+		resultsDir := filepath.Join(cniConfig.CacheDir, vmmDir.Name(), "results")
+		resultFiles, err := ioutil.ReadDir(resultsDir)
+		if err != nil {
+			// not a VMM directory, for example the IPAM allocations directory:
+			continue
+		}
+		for _, resultFile := range resultFiles {
+			if resultFile.IsDir() {
+				continue
+			}
+			bytes, err := ioutil.ReadFile(filepath.Join(resultsDir, resultFile.Name()))
+			if err != nil {
+				return nil, errors.Wrapf(err, "CNI cached result '%s' could not be read", resultFile.Name())
+			}
+			result := &cachedResult{}
+			if err := json.Unmarshal(bytes, result); err != nil || result.Kind != cachedResultKind {
+				continue
+			}
+			attachments = append(attachments, &CachedAttachment{
+				ContainerID: result.ContainerID,
+				IfName:      result.IfName,
+				NetworkName: result.NetworkName,
+				Path:        filepath.Join(cniConfig.CacheDir, vmmDir.Name()),
+				ModTime:     vmmDir.ModTime(),
+			})
+		}
+	}
+	return attachments, nil
+}
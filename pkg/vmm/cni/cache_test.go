@@ -0,0 +1,39 @@
+package cni
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachedAttachments(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	cniConfig := &configs.CNIConfig{CacheDir: filepath.Join(tempDir, "cni")}
+
+	attachments, err := CachedAttachments(cniConfig)
+	assert.Nil(t, err)
+	assert.Empty(t, attachments)
+
+	resultsDir := filepath.Join(cniConfig.CacheDir, "vmm1", "results")
+	assert.Nil(t, os.MkdirAll(resultsDir, 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(resultsDir, "machines-vmm1-veth0"),
+		[]byte(`{"kind":"cniCacheV1","containerId":"vmm1","ifName":"veth0","networkName":"machines"}`), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(resultsDir, "garbage"), []byte(`not json`), 0600))
+	// the IPAM allocations are not attachments:
+	assert.Nil(t, os.MkdirAll(filepath.Join(cniConfig.CacheDir, "networks", "machines"), 0700))
+
+	attachments, err = CachedAttachments(cniConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(attachments))
+	assert.Equal(t, "vmm1", attachments[0].ContainerID)
+	assert.Equal(t, "veth0", attachments[0].IfName)
+	assert.Equal(t, "machines", attachments[0].NetworkName)
+	assert.Equal(t, filepath.Join(cniConfig.CacheDir, "vmm1"), attachments[0].Path)
+}
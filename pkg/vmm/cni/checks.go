@@ -0,0 +1,122 @@
+package cni
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/containernetworking/cni/libcni"
+	"github.com/pkg/errors"
+)
+
+// cacheDirMode is the mode of the CNI cache directory, the cached results
+// contain the network configuration of the VMMs and are readable by root only.
+const cacheDirMode = 0700
+
+// EnsureCacheDir creates the CNI cache directory if it does not exist.
+func EnsureCacheDir(cniConfig *configs.CNIConfig) error {
+	stat, err := os.Stat(cniConfig.CacheDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return errors.Wrapf(err, "--cni-cache-dir '%s' stat error", cniConfig.CacheDir)
+		}
+		if err := os.MkdirAll(cniConfig.CacheDir, cacheDirMode); err != nil {
+			return errors.Wrapf(err, "--cni-cache-dir '%s' could not be created", cniConfig.CacheDir)
+		}
+		return nil
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("--cni-cache-dir '%s' is not a directory", cniConfig.CacheDir)
+	}
+	return nil
+}
+
+// CheckNetwork checks if the configuration of the CNI network exists
+// in the CNI configuration directory and if the CNI binaries directory
+// contains all plugins required by the network.
+func CheckNetwork(cniConfig *configs.CNIConfig, netName string) error {
+	if netName == "" {
+		return fmt.Errorf("CNI network name can't be empty")
+	}
+	networkConfig, err := libcni.LoadConfList(cniConfig.ConfDir, netName)
+	if err != nil {
+		return errors.Wrapf(err, "CNI network '%s' configuration not found in --cni-conf-dir '%s'", netName, cniConfig.ConfDir)
+	}
+	return CheckPlugins(cniConfig, networkConfig)
+}
+
+// CheckPlugins checks if the CNI binaries directory contains all plugins,
+// including the IPAM plugins, required by the network.
+func CheckPlugins(cniConfig *configs.CNIConfig, networkConfig *libcni.NetworkConfigList) error {
+	if len(networkConfig.Plugins) == 0 {
+		return fmt.Errorf("CNI network '%s' has no plugins", networkConfig.Name)
+	}
+	required := []string{}
+	for _, plugin := range networkConfig.Plugins {
+		required = append(required, plugin.Network.Type)
+		if plugin.Network.IPAM.Type != "" {
+			required = append(required, plugin.Network.IPAM.Type)
+		}
+	}
+	missing := []string{}
+	for _, pluginType := range required {
+		stat, err := os.Stat(filepath.Join(cniConfig.BinDir, pluginType))
+		if err != nil || !stat.Mode().IsRegular() || stat.Mode().Perm()&0111 == 0 {
+			missing = append(missing, pluginType)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("CNI network '%s' requires plugins missing in --cni-bin-dir '%s': %s",
+			networkConfig.Name, cniConfig.BinDir, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Prepare creates the CNI cache directory if missing and checks the CNI network
+// before the VMM is started so a misconfigured CNI fails early with a clear error.
+func Prepare(cniConfig *configs.CNIConfig, netName string) error {
+	if err := EnsureCacheDir(cniConfig); err != nil {
+		return err
+	}
+	return CheckNetwork(cniConfig, netName)
+}
+
+// ListNetworks returns the names of the CNI networks configured in the CNI configuration directory.
+func ListNetworks(cniConfig *configs.CNIConfig) ([]string, error) {
+	files, err := libcni.ConfFiles(cniConfig.ConfDir, []string{".conf", ".conflist", ".json"})
+	if err != nil {
+		return nil, errors.Wrapf(err, "--cni-conf-dir '%s' could not be read", cniConfig.ConfDir)
+	}
+	names := []string{}
+	seen := map[string]bool{}
+	for _, file := range files {
+		name, err := networkName(file)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func networkName(file string) (string, error) {
+	if strings.HasSuffix(file, ".conflist") {
+		networkConfig, err := libcni.ConfListFromFile(file)
+		if err != nil {
+			return "", errors.Wrapf(err, "CNI configuration '%s' could not be parsed", file)
+		}
+		return networkConfig.Name, nil
+	}
+	networkConfig, err := libcni.ConfFromFile(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "CNI configuration '%s' could not be parsed", file)
+	}
+	return networkConfig.Network.Name, nil
+}
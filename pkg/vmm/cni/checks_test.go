@@ -0,0 +1,67 @@
+package cni
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfList = `{
+  "name": "machines",
+  "cniVersion": "0.4.0",
+  "plugins": [
+    {"type": "ptp", "ipam": {"type": "host-local", "subnet": "192.168.127.0/24"}},
+    {"type": "tc-redirect-tap"}
+  ]
+}`
+
+func TestPrepare(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	cniConfig := &configs.CNIConfig{
+		BinDir:   filepath.Join(tempDir, "bin"),
+		CacheDir: filepath.Join(tempDir, "cache", "cni"),
+		ConfDir:  filepath.Join(tempDir, "conf.d"),
+	}
+	assert.Nil(t, os.MkdirAll(cniConfig.BinDir, 0755))
+	assert.Nil(t, os.MkdirAll(cniConfig.ConfDir, 0755))
+
+	// no network configuration:
+	assert.NotNil(t, Prepare(cniConfig, "machines"))
+	stat, err := os.Stat(cniConfig.CacheDir)
+	assert.Nil(t, err)
+	assert.True(t, stat.IsDir())
+	assert.Equal(t, os.FileMode(cacheDirMode), stat.Mode().Perm())
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(cniConfig.ConfDir, "machines.conflist"), []byte(testConfList), 0644))
+	names, err := ListNetworks(cniConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"machines"}, names)
+
+	// no plugins:
+	err = Prepare(cniConfig, "machines")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "ptp, host-local, tc-redirect-tap")
+
+	// a plugin which is not executable:
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(cniConfig.BinDir, "ptp"), []byte{}, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(cniConfig.BinDir, "host-local"), []byte{}, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(cniConfig.BinDir, "tc-redirect-tap"), []byte{}, 0644))
+	err = Prepare(cniConfig, "machines")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "tc-redirect-tap")
+
+	assert.Nil(t, os.Chmod(filepath.Join(cniConfig.BinDir, "tc-redirect-tap"), 0755))
+	assert.Nil(t, Prepare(cniConfig, "machines"))
+
+	// the cache directory path points to a file:
+	assert.Nil(t, os.RemoveAll(cniConfig.CacheDir))
+	assert.Nil(t, ioutil.WriteFile(cniConfig.CacheDir, []byte{}, 0644))
+	assert.NotNil(t, EnsureCacheDir(cniConfig))
+}
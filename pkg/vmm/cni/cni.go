@@ -40,6 +40,7 @@ func CleanupCNI(logger hclog.Logger, cniConfig *configs.CNIConfig, vmmID, vethIf
 				"iface-cni-dir", ifaceCNIDir,
 				"reason", statErr)
 		}
+		return nil
 	}
 	if !ifaceCNIDirStat.IsDir() {
 		logger.Error("CNI directory path points to a file",
@@ -0,0 +1,112 @@
+package vmm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ConsoleCapture keeps the last lines written to the serial console of the VMM.
+// A failed boot is often visible only on the console so the captured lines
+// are included in the errors reported for the VMM.
+type ConsoleCapture struct {
+	sync.Mutex
+
+	lines    []string
+	maxLines int
+	partial  []byte
+}
+
+// NewConsoleCapture returns a console capture keeping up to maxLines last lines.
+func NewConsoleCapture(maxLines int) *ConsoleCapture {
+	return &ConsoleCapture{
+		lines:    []string{},
+		maxLines: maxLines,
+		partial:  []byte{},
+	}
+}
+
+// Write implements io.Writer.
+func (c *ConsoleCapture) Write(p []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.maxLines < 1 {
+		return len(p), nil
+	}
+	data := append(c.partial, p...)
+	for {
+		index := bytes.IndexByte(data, '\n')
+		if index < 0 {
+			break
+		}
+		c.appendLine(string(data[:index]))
+		data = data[index+1:]
+	}
+	// an unterminated line can grow without bound on a misbehaving console:
+	if len(data) > maxPartialLineBytes {
+		c.appendLine(string(data))
+		data = data[:0]
+	}
+	c.partial = append([]byte{}, data...)
+	return len(p), nil
+}
+
+// Lines returns the captured lines, oldest first.
+// The unterminated last line is included.
+func (c *ConsoleCapture) Lines() []string {
+	c.Lock()
+	defer c.Unlock()
+	result := append([]string{}, c.lines...)
+	if len(c.partial) > 0 {
+		result = append(result, string(c.partial))
+		if len(result) > c.maxLines {
+			result = result[len(result)-c.maxLines:]
+		}
+	}
+	return result
+}
+
+// WrapError returns the error with the captured lines appended to the message.
+// Returns the error unchanged when nothing was captured.
+func (c *ConsoleCapture) WrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	lines := c.Lines()
+	if len(lines) == 0 {
+		return err
+	}
+	return &ConsoleError{Err: err, Lines: lines}
+}
+
+func (c *ConsoleCapture) appendLine(line string) {
+	c.lines = append(c.lines, strings.TrimSuffix(line, "\r"))
+	if len(c.lines) > c.maxLines {
+		c.lines = c.lines[len(c.lines)-c.maxLines:]
+	}
+}
+
+// maxPartialLineBytes is the maximum length of a captured line.
+const maxPartialLineBytes = 4096
+
+// ConsoleError is an error decorated with the last lines of the serial console output.
+type ConsoleError struct {
+	Err   error
+	Lines []string
+}
+
+// Error implements error.
+func (e *ConsoleError) Error() string {
+	return fmt.Sprintf("%s\nlast %d lines of the VMM console output:\n%s", e.Err.Error(), len(e.Lines), strings.Join(e.Lines, "\n"))
+}
+
+// Unwrap returns the decorated error.
+func (e *ConsoleError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the decorated error, github.com/pkg/errors compatible.
+func (e *ConsoleError) Cause() error {
+	return e.Err
+}
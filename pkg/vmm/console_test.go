@@ -0,0 +1,45 @@
+package vmm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsoleCaptureKeepsLastLines(t *testing.T) {
+	capture := NewConsoleCapture(3)
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(capture, "line %d\r\n", i)
+	}
+	capture.Write([]byte("unterminated"))
+	assert.Equal(t, []string{"line 4", "line 5", "unterminated"}, capture.Lines())
+
+	capture.Write([]byte(" line\nline 7"))
+	assert.Equal(t, []string{"line 5", "unterminated line", "line 7"}, capture.Lines())
+}
+
+func TestConsoleCaptureWrapError(t *testing.T) {
+	bootErr := fmt.Errorf("boot failed")
+
+	empty := NewConsoleCapture(10)
+	assert.Equal(t, bootErr, empty.WrapError(bootErr))
+	assert.Nil(t, empty.WrapError(nil))
+
+	capture := NewConsoleCapture(10)
+	capture.Write([]byte("Kernel panic - not syncing: VFS: Unable to mount root fs\n"))
+	wrapped := capture.WrapError(bootErr)
+	assert.True(t, strings.HasPrefix(wrapped.Error(), "boot failed\n"))
+	assert.True(t, strings.HasSuffix(wrapped.Error(), "Kernel panic - not syncing: VFS: Unable to mount root fs"))
+	assert.Equal(t, bootErr, errors.Cause(wrapped))
+}
+
+func TestConsoleCaptureDisabled(t *testing.T) {
+	capture := NewConsoleCapture(0)
+	written, err := capture.Write([]byte("line\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, written)
+	assert.Empty(t, capture.Lines())
+}
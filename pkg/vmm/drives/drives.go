@@ -0,0 +1,190 @@
+package drives
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ModeReadOnly attaches the drive in the read only mode.
+	ModeReadOnly = "ro"
+	// ModeReadWrite attaches the drive in the read write mode.
+	ModeReadWrite = "rw"
+)
+
+// MinSizeBytes is the minimum size of a drive created on first use.
+const MinSizeBytes = 1024 * 1024
+
+// Drive is an additional block device attached to the VMM, next to the root device.
+type Drive struct {
+	// DriveID is the Firecracker drive ID, assigned when the drive is attached.
+	DriveID  string `json:"DriveID,omitempty" mapstructure:"DriveID,omitempty"`
+	HostPath string `json:"HostPath" mapstructure:"HostPath"`
+	Partuuid string `json:"Partuuid,omitempty" mapstructure:"Partuuid,omitempty"`
+	ReadOnly bool   `json:"ReadOnly" mapstructure:"ReadOnly"`
+	// SizeBytes is the size of the drive file created with an EXT4 file system on first use,
+	// 0 if the drive file must exist.
+	SizeBytes int64 `json:"SizeBytes,omitempty" mapstructure:"SizeBytes,omitempty"`
+	// Created is true if the drive file was created when the VMM was started.
+	Created bool `json:"Created,omitempty" mapstructure:"Created,omitempty"`
+}
+
+// Parse parses the drive, format: path:ro|rw[:partuuid][:size=<size>], for example:
+// /var/lib/data.ext4:rw or /var/lib/data.ext4:rw:size=10GiB.
+// The path must be absolute. With the size, a missing drive file is created on first use.
+func Parse(input string) (*Drive, error) {
+	parts := strings.Split(input, ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return nil, fmt.Errorf("drive '%s' is invalid, expected format: path:ro|rw[:partuuid][:size=<size>]", input)
+	}
+	if !filepath.IsAbs(parts[0]) {
+		return nil, fmt.Errorf("drive '%s' path must be absolute", input)
+	}
+	drive := &Drive{HostPath: filepath.Clean(parts[0])}
+	switch parts[1] {
+	case ModeReadOnly:
+		drive.ReadOnly = true
+	case ModeReadWrite:
+	default:
+		return nil, fmt.Errorf("drive '%s' mode must be %s or %s", input, ModeReadOnly, ModeReadWrite)
+	}
+	for idx, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "size="):
+			if idx != len(parts[2:])-1 {
+				return nil, fmt.Errorf("drive '%s' size must be given last", input)
+			}
+			size, err := utils.ParseSize(strings.TrimPrefix(part, "size="))
+			if err != nil {
+				return nil, errors.Wrapf(err, "drive '%s' size is invalid", input)
+			}
+			if size < MinSizeBytes {
+				return nil, fmt.Errorf("drive '%s' size must be at least %d bytes", input, MinSizeBytes)
+			}
+			drive.SizeBytes = size
+		case idx == 0 && part != "":
+			drive.Partuuid = part
+		default:
+			return nil, fmt.Errorf("drive '%s' is invalid, expected format: path:ro|rw[:partuuid][:size=<size>]", input)
+		}
+	}
+	if drive.ReadOnly && drive.SizeBytes > 0 {
+		return nil, fmt.Errorf("drive '%s' created on first use must be rw", input)
+	}
+	return drive, nil
+}
+
+// ParseAll parses the drives and assigns the drive IDs following the root device drive ID, 1.
+// The drives are linked into the jail by the file name so the file names must be unique
+// and different from the root file system file name.
+func ParseAll(inputs []string) ([]*Drive, error) {
+	result := []*Drive{}
+	fileNames := map[string]string{naming.RootfsFileName: "the root file system"}
+	for idx, input := range inputs {
+		drive, err := Parse(input)
+		if err != nil {
+			return nil, err
+		}
+		fileName := filepath.Base(drive.HostPath)
+		if existing, ok := fileNames[fileName]; ok {
+			return nil, fmt.Errorf("drive '%s' file name '%s' is used by %s", input, fileName, existing)
+		}
+		fileNames[fileName] = fmt.Sprintf("drive '%s'", input)
+		drive.DriveID = fmt.Sprintf("%d", idx+2)
+		result = append(result, drive)
+	}
+	return result, nil
+}
+
+// Prepare creates the drive file with an EXT4 file system if the file does not exist
+// and the drive has a size. Otherwise, the drive file must be an existing regular file.
+func (d *Drive) Prepare() error {
+	stat, err := os.Stat(d.HostPath)
+	if err == nil {
+		if !stat.Mode().IsRegular() {
+			return fmt.Errorf("drive '%s' is not a regular file", d.HostPath)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "drive '%s' stat error", d.HostPath)
+	}
+	if d.SizeBytes == 0 {
+		return fmt.Errorf("drive '%s' does not exist, give the size to create it", d.HostPath)
+	}
+	file, err := os.OpenFile(d.HostPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed creating drive '%s'", d.HostPath)
+	}
+	// sparse, the blocks are allocated when written:
+	truncateErr := file.Truncate(d.SizeBytes)
+	file.Close()
+	if truncateErr != nil {
+		os.Remove(d.HostPath)
+		return errors.Wrapf(truncateErr, "failed sizing drive '%s'", d.HostPath)
+	}
+	if err := utils.MkfsExt4(d.HostPath); err != nil {
+		os.Remove(d.HostPath)
+		return errors.Wrapf(err, "failed creating file system on drive '%s'", d.HostPath)
+	}
+	d.Created = true
+	return nil
+}
+
+// CheckLinkable returns an error if the drive file can't be hard linked into the directory.
+// The jailer links the drives into the jail so the drive file must be on the same file system.
+func (d *Drive) CheckLinkable(directory string) error {
+	driveStat, err := os.Stat(d.HostPath)
+	if err != nil {
+		return errors.Wrapf(err, "drive '%s' stat error", d.HostPath)
+	}
+	directoryStat, err := os.Stat(directory)
+	if err != nil {
+		return errors.Wrapf(err, "directory '%s' stat error", directory)
+	}
+	driveSys, driveOk := driveStat.Sys().(*syscall.Stat_t)
+	directorySys, directoryOk := directoryStat.Sys().(*syscall.Stat_t)
+	if !driveOk || !directoryOk {
+		return nil
+	}
+	if driveSys.Dev != directorySys.Dev {
+		return fmt.Errorf("drive '%s' must be on the file system of '%s', the drive is linked into the jail", d.HostPath, directory)
+	}
+	return nil
+}
+
+// String returns the drive in the format accepted by Parse.
+func (d *Drive) String() string {
+	mode := ModeReadWrite
+	if d.ReadOnly {
+		mode = ModeReadOnly
+	}
+	result := d.HostPath + ":" + mode
+	if d.Partuuid != "" {
+		result = result + ":" + d.Partuuid
+	}
+	if d.SizeBytes > 0 {
+		result = fmt.Sprintf("%s:size=%d", result, d.SizeBytes)
+	}
+	return result
+}
+
+// ToSDKDrive returns the Firecracker drive of the drive.
+func (d *Drive) ToSDKDrive() models.Drive {
+	return models.Drive{
+		DriveID:      firecracker.String(d.DriveID),
+		PathOnHost:   firecracker.String(d.HostPath),
+		IsRootDevice: firecracker.Bool(false),
+		IsReadOnly:   firecracker.Bool(d.ReadOnly),
+		Partuuid:     d.Partuuid,
+	}
+}
@@ -0,0 +1,87 @@
+package drives
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	drive, err := Parse("/data/disk.ext4:rw")
+	assert.Nil(t, err)
+	assert.Equal(t, "/data/disk.ext4", drive.HostPath)
+	assert.False(t, drive.ReadOnly)
+
+	drive, err = Parse("/data/disk.ext4:ro:abcd-01")
+	assert.Nil(t, err)
+	assert.True(t, drive.ReadOnly)
+	assert.Equal(t, "abcd-01", drive.Partuuid)
+	assert.Equal(t, "/data/disk.ext4:ro:abcd-01", drive.String())
+
+	drive, err = Parse("/data/disk.ext4:rw:size=10MiB")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10*1024*1024), drive.SizeBytes)
+	assert.Equal(t, "/data/disk.ext4:rw:size=10485760", drive.String())
+
+	for _, input := range []string{
+		"/data/disk.ext4",
+		"data/disk.ext4:rw",
+		"/data/disk.ext4:rx",
+		"/data/disk.ext4:ro:size=10MiB",
+		"/data/disk.ext4:rw:size=10MiB:abcd-01",
+		"/data/disk.ext4:rw:size=1KiB",
+		"/data/disk.ext4:rw:size=10MiB/s",
+	} {
+		_, err := Parse(input)
+		assert.NotNil(t, err, "expected an error for %s", input)
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	drives, err := ParseAll([]string{"/data/a.ext4:rw", "/data/b.ext4:ro"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(drives))
+	assert.Equal(t, "2", drives[0].DriveID)
+	assert.Equal(t, "3", drives[1].DriveID)
+	assert.False(t, *drives[0].ToSDKDrive().IsRootDevice)
+	assert.True(t, *drives[1].ToSDKDrive().IsReadOnly)
+
+	_, err = ParseAll([]string{"/data/a.ext4:rw", "/other/a.ext4:ro"})
+	assert.NotNil(t, err, "expected an error for the duplicate file name")
+	_, err = ParseAll([]string{"/data/rootfs:rw"})
+	assert.NotNil(t, err, "expected an error for the root file system file name")
+}
+
+func TestPrepare(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	existing := filepath.Join(tempDir, "existing.ext4")
+	assert.Nil(t, ioutil.WriteFile(existing, []byte{}, 0644))
+	drive := &Drive{HostPath: existing}
+	assert.Nil(t, drive.Prepare())
+	assert.False(t, drive.Created)
+	assert.Nil(t, drive.CheckLinkable(tempDir))
+
+	assert.NotNil(t, (&Drive{HostPath: filepath.Join(tempDir, "missing.ext4")}).Prepare(),
+		"expected an error for a missing drive without the size")
+	assert.NotNil(t, (&Drive{HostPath: tempDir}).Prepare(), "expected an error for a directory")
+
+	if _, err := exec.LookPath("mkfs.ext4"); err != nil {
+		t.Log("mkfs.ext4 not available, skipping drive creation")
+		return
+	}
+	created := &Drive{HostPath: filepath.Join(tempDir, "created.ext4"), SizeBytes: 8 * MinSizeBytes}
+	assert.Nil(t, created.Prepare())
+	assert.True(t, created.Created)
+	stat, err := os.Stat(created.HostPath)
+	assert.Nil(t, err)
+	assert.Equal(t, created.SizeBytes, stat.Size())
+}
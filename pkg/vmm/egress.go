@@ -0,0 +1,112 @@
+package vmm
+
+import (
+	"context"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/fw"
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+)
+
+// ApplyEgressPolicyHandlerName is the name of the handler applying the egress policy.
+const ApplyEgressPolicyHandlerName = "firebuild.ApplyEgressPolicy"
+
+// EgressPolicy returns the egress policy of the run configuration, nil if the egress traffic is not restricted.
+func EgressPolicy(runConfig *configs.RunCommandConfig) (*fw.EgressPolicy, error) {
+	if !runConfig.HasEgressPolicy() {
+		return nil, nil
+	}
+	policy := &fw.EgressPolicy{
+		Allow:   []*fw.EgressRule{},
+		Deny:    []*fw.EgressRule{},
+		Default: runConfig.EgressDefaultPolicy(),
+	}
+	for _, input := range runConfig.EgressAllow {
+		rule, err := fw.EgressRuleFromString(input)
+		if err != nil {
+			return nil, errors.Wrap(err, "--egress-allow invalid")
+		}
+		policy.Allow = append(policy.Allow, rule)
+	}
+	for _, input := range runConfig.EgressDeny {
+		rule, err := fw.EgressRuleFromString(input)
+		if err != nil {
+			return nil, errors.Wrap(err, "--egress-deny invalid")
+		}
+		policy.Deny = append(policy.Deny, rule)
+	}
+	return policy, nil
+}
+
+// ApplyEgressPolicy applies the egress policy for the guest addresses of the VMM
+// and records the applied policy in the metadata so it can be removed.
+func ApplyEgressPolicy(md *metadata.MDRun, policy *fw.EgressPolicy) error {
+	addresses := md.GuestAddresses()
+	if len(addresses) == 0 {
+		return errors.New("the VMM has no guest address")
+	}
+	egressManager, err := fw.NewEgressManager(md.VMMID, addresses...)
+	if err != nil {
+		return err
+	}
+	md.Egress = &metadata.MDRunEgress{
+		Addresses: addresses,
+		Allow:     egressRuleStrings(policy.Allow),
+		Default:   policy.Default,
+		Deny:      egressRuleStrings(policy.Deny),
+	}
+	if err := egressManager.Apply(policy); err != nil {
+		// remove what might have been applied:
+		if removeErr := egressManager.Remove(); removeErr != nil {
+			return errors.Wrapf(err, "egress policy cleanup failed: %v", removeErr)
+		}
+		md.Egress = nil
+		return err
+	}
+	return nil
+}
+
+// NewEgressPolicyHandler returns a handler applying the egress policy after the network is set up
+// and before the instance is started, the guest never sends a packet without the policy.
+// The guest addresses are read from the metadata, the handler must run after the metadata extractor.
+func NewEgressPolicyHandler(md *metadata.MDRun, policy *fw.EgressPolicy) firecracker.Handler {
+	return firecracker.Handler{
+		Name: ApplyEgressPolicyHandlerName,
+		Fn: func(ctx context.Context, m *firecracker.Machine) error {
+			if err := ApplyEgressPolicy(md, policy); err != nil {
+				return errors.Wrap(err, "egress policy not applied")
+			}
+			return nil
+		},
+	}
+}
+
+// RemoveEgressPolicy removes the egress policy of the VMM, if applied.
+// The policy recorded in the stored metadata takes precedence over the given metadata.
+func RemoveEgressPolicy(logger hclog.Logger, md *metadata.MDRun) {
+	if stored, hasMetadata, err := FetchMetadataIfExists(md.RunCache); err == nil && hasMetadata {
+		md = stored
+	}
+	if md.Egress == nil {
+		return
+	}
+	egressManager, err := fw.NewEgressManager(md.VMMID, md.Egress.Addresses...)
+	if err != nil {
+		logger.Warn("egress policy cleanup failed", "reason", err)
+		return
+	}
+	if err := egressManager.Remove(); err != nil {
+		logger.Warn("egress policy cleanup failed", "reason", err)
+	}
+}
+
+func egressRuleStrings(rules []*fw.EgressRule) []string {
+	result := []string{}
+	for _, rule := range rules {
+		result = append(result, rule.String())
+	}
+	return result
+}
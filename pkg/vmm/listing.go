@@ -0,0 +1,112 @@
+package vmm
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+)
+
+// ListedVMM is a VMM found in the run cache.
+type ListedVMM struct {
+	ID      string
+	Running bool
+	// Metadata is nil if the VMM has no metadata file.
+	Metadata *metadata.MDRun
+}
+
+// IsAlive checks if the recorded PID of the VMM points at a running Firecracker or jailer process.
+func IsAlive(md *metadata.MDRun) (bool, error) {
+	binaries := []string{}
+	if md.Configs.Jailer != nil {
+		binaries = append(binaries, md.Configs.Jailer.BinaryFirecracker, md.Configs.Jailer.BinaryJailer)
+	}
+	return md.PID.IsVMM(binaries...)
+}
+
+// State returns the state of the VMM: the recorded run state if the VMM is alive, dead otherwise.
+func State(md *metadata.MDRun, alive bool) string {
+	if !alive {
+		return metadata.StateDead
+	}
+	return md.RunState()
+}
+
+// SortListing sorts the listed VMMs by the rootfs image: created sorts by the image creation time,
+// size by the root drive size, name by the image tag and semver by the org and image, then by the
+// semantic version precedence of the image version, lexically if the versions aren't semantic versions. The VMMs without the metadata
+// are always listed last. An empty sort key keeps the order.
+func SortListing(items []*ListedVMM, by string, reverse bool) {
+	if by == "" {
+		return
+	}
+	sizes := map[string]int64{}
+	for _, item := range items {
+		if item.Metadata != nil {
+			sizes[item.ID] = RootDriveSize(item.Metadata)
+		}
+	}
+	less := func(a, b *ListedVMM) bool {
+		switch by {
+		case "created":
+			if a.Metadata.Rootfs.CreatedAtUTC != b.Metadata.Rootfs.CreatedAtUTC {
+				return a.Metadata.Rootfs.CreatedAtUTC < b.Metadata.Rootfs.CreatedAtUTC
+			}
+		case "size":
+			if sizes[a.ID] != sizes[b.ID] {
+				return sizes[a.ID] < sizes[b.ID]
+			}
+		case "semver":
+			imageA, imageB := a.Metadata.Rootfs.Image, b.Metadata.Rootfs.Image
+			if imageA.Org != imageB.Org {
+				return imageA.Org < imageB.Org
+			}
+			if imageA.Image != imageB.Image {
+				return imageA.Image < imageB.Image
+			}
+			if c := utils.CompareVersions(imageA.Version, imageB.Version); c != 0 {
+				return c < 0
+			}
+		default:
+			if ImageTag(a.Metadata.Rootfs.Image) != ImageTag(b.Metadata.Rootfs.Image) {
+				return ImageTag(a.Metadata.Rootfs.Image) < ImageTag(b.Metadata.Rootfs.Image)
+			}
+		}
+		return a.ID < b.ID
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if !hasRootfsMetadata(a) || !hasRootfsMetadata(b) {
+			return hasRootfsMetadata(a) && !hasRootfsMetadata(b)
+		}
+		if reverse {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+// ImageTag returns the org/image:version tag of the image.
+func ImageTag(image metadata.MDImage) string {
+	return fmt.Sprintf("%s/%s:%s", image.Org, image.Image, image.Version)
+}
+
+// RootDriveSize returns the size of the root drive file of the VMM, 0 if the file is gone.
+func RootDriveSize(md *metadata.MDRun) int64 {
+	for _, drive := range md.Drives {
+		if !firecracker.BoolValue(drive.IsRootDevice) {
+			continue
+		}
+		if statResult, err := os.Stat(firecracker.StringValue(drive.PathOnHost)); err == nil {
+			return statResult.Size()
+		}
+	}
+	return 0
+}
+
+func hasRootfsMetadata(item *ListedVMM) bool {
+	return item.Metadata != nil && item.Metadata.Rootfs != nil
+}
@@ -0,0 +1,95 @@
+package vmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/combust-labs/firebuild/pkg/vmm/pid"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortListing(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	listedVMM := func(id, org, image string, created int64, size int) *ListedVMM {
+		drivePath := filepath.Join(tempDir, id)
+		assert.Nil(t, ioutil.WriteFile(drivePath, make([]byte, size), 0644))
+		return &ListedVMM{ID: id, Metadata: &metadata.MDRun{
+			Drives: []models.Drive{{IsRootDevice: firecracker.Bool(true), PathOnHost: firecracker.String(drivePath)}},
+			Rootfs: &metadata.MDRootfs{
+				CreatedAtUTC: created,
+				Image:        metadata.MDImage{Org: org, Image: image, Version: "latest"},
+			},
+		}}
+	}
+
+	ids := func(items []*ListedVMM) []string {
+		result := []string{}
+		for _, item := range items {
+			result = append(result, item.ID)
+		}
+		return result
+	}
+
+	items := []*ListedVMM{
+		{ID: "no-metadata"},
+		listedVMM("vmm-1", "tests", "redis", 300, 10),
+		listedVMM("vmm-2", "tests", "postgres", 100, 30),
+		listedVMM("vmm-3", "combust-labs", "postgres", 200, 20),
+	}
+
+	SortListing(items, "", false)
+	assert.Equal(t, []string{"no-metadata", "vmm-1", "vmm-2", "vmm-3"}, ids(items))
+
+	SortListing(items, "created", false)
+	assert.Equal(t, []string{"vmm-2", "vmm-3", "vmm-1", "no-metadata"}, ids(items))
+
+	SortListing(items, "created", true)
+	assert.Equal(t, []string{"vmm-1", "vmm-3", "vmm-2", "no-metadata"}, ids(items))
+
+	SortListing(items, "size", false)
+	assert.Equal(t, []string{"vmm-1", "vmm-3", "vmm-2", "no-metadata"}, ids(items))
+
+	SortListing(items, "name", false)
+	assert.Equal(t, []string{"vmm-3", "vmm-2", "vmm-1", "no-metadata"}, ids(items))
+
+	SortListing(items, "name", true)
+	assert.Equal(t, []string{"vmm-1", "vmm-2", "vmm-3", "no-metadata"}, ids(items))
+
+	versioned := []*ListedVMM{
+		listedVMM("vmm-1", "tests", "postgres", 100, 10),
+		listedVMM("vmm-2", "tests", "postgres", 100, 10),
+		listedVMM("vmm-3", "tests", "postgres", 100, 10),
+		listedVMM("vmm-4", "tests", "postgres", 100, 10),
+	}
+	for idx, version := range []string{"1.10.0", "1.9.0", "latest", "1.9.1"} {
+		versioned[idx].Metadata.Rootfs.Image.Version = version
+	}
+
+	SortListing(versioned, "name", false)
+	assert.Equal(t, []string{"vmm-1", "vmm-2", "vmm-4", "vmm-3"}, ids(versioned))
+
+	SortListing(versioned, "semver", false)
+	assert.Equal(t, []string{"vmm-2", "vmm-4", "vmm-1", "vmm-3"}, ids(versioned))
+
+	SortListing(versioned, "semver", true)
+	assert.Equal(t, []string{"vmm-3", "vmm-1", "vmm-4", "vmm-2"}, ids(versioned))
+}
+
+func TestIsAliveState(t *testing.T) {
+	// the test process is not a VMM, its PID is treated like a reused PID:
+	md := &metadata.MDRun{PID: pid.RunningVMMPID{Pid: os.Getpid()}, State: metadata.StatePaused}
+	alive, err := IsAlive(md)
+	assert.Nil(t, err)
+	assert.False(t, alive)
+	assert.Equal(t, metadata.StateDead, State(md, alive))
+	assert.Equal(t, metadata.StatePaused, State(md, true))
+	assert.Equal(t, metadata.StateRunning, State(&metadata.MDRun{}, true))
+}
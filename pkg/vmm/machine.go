@@ -24,6 +24,8 @@ type StartedMachine interface {
 	Stop(context.Context) StoppedOK
 	// StopAndWait stops the VMM and waits for the VMM to stop, remote connected client may be nil.
 	StopAndWait(context.Context)
+	// Stopped returns true when the VMM was stopped with Stop, false when the VMM exited on its own.
+	Stopped() bool
 	// Wait awaits for the VMM exit.
 	Wait(context.Context)
 }
@@ -37,7 +39,9 @@ type defaultStartedMachine struct {
 
 	logger        hclog.Logger
 	machine       *firecracker.Machine
+	metricsPath   string
 	vethIfaceName string
+	vsockPath     string
 
 	wasStopped bool
 }
@@ -58,6 +62,16 @@ func (m *defaultStartedMachine) DecorateMetadata(md *metadata.MDRun) error {
 		return errors.Wrap(err, "machine pid read")
 	}
 	md.PID = pid.RunningVMMPID{Pid: machinePid}
+	// the started and the restored VMMs are running:
+	md.State = metadata.StateRunning
+	md.MetricsPath = m.metricsPath
+	if m.machineConfig.HasVsock() {
+		md.Vsock = &metadata.MDRunVsock{
+			CID:      m.machineConfig.VsockCID,
+			HostPath: m.vsockPath,
+			Path:     m.machineConfig.VsockPathOrDefault(),
+		}
+	}
 	return nil
 }
 
@@ -118,6 +132,12 @@ func (m *defaultStartedMachine) StopAndWait(ctx context.Context) {
 	m.machine.Wait(ctx)
 }
 
+func (m *defaultStartedMachine) Stopped() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.wasStopped
+}
+
 func (m *defaultStartedMachine) Wait(ctx context.Context) {
 	m.logger.Info("Waiting for machine to stop...")
 	m.machine.Wait(ctx)
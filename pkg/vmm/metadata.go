@@ -37,7 +37,7 @@ func FetchMetadataIfExists(cacheDirectory string) (*metadata.MDRun, bool, error)
 
 // WriteMetadataToFile writes a run metadata to file under the cache directory.
 func WriteMetadataToFile(md *metadata.MDRun) error {
-	mdBytes, jsonErr := json.Marshal(md)
+	mdBytes, jsonErr := utils.CanonicalJSON(md)
 	if jsonErr != nil {
 		return errors.Wrap(jsonErr, "failed serializing machine metadata to JSON")
 	}
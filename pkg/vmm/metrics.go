@@ -0,0 +1,150 @@
+package vmm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/pkg/errors"
+)
+
+// AddMetricsHandlerName is the name of the handler configuring the metrics file.
+const AddMetricsHandlerName = "firebuild.AddMetrics"
+
+// maxMetricsLineBytes is the maximum length of a single metrics line.
+const maxMetricsLineBytes = 1024 * 1024
+
+// MetricsCounters represents a group of Firecracker metrics counters.
+// Values other than numbers, for example the latency aggregates, are skipped.
+type MetricsCounters map[string]int64
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *MetricsCounters) UnmarshalJSON(data []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	result := MetricsCounters{}
+	for name, value := range raw {
+		var counter int64
+		if err := json.Unmarshal(value, &counter); err != nil {
+			continue
+		}
+		result[name] = counter
+	}
+	*c = result
+	return nil
+}
+
+// Metrics represents the CPU, block and network counters of a single Firecracker metrics flush.
+type Metrics struct {
+	UTCTimestampMs int64           `json:"utc_timestamp_ms"`
+	Block          MetricsCounters `json:"block"`
+	Net            MetricsCounters `json:"net"`
+	VCPU           MetricsCounters `json:"vcpu"`
+}
+
+// AggregateMetrics returns the sum of the counters of all metrics.
+// The timestamp is the latest timestamp.
+func AggregateMetrics(metrics ...*Metrics) *Metrics {
+	result := &Metrics{
+		Block: MetricsCounters{},
+		Net:   MetricsCounters{},
+		VCPU:  MetricsCounters{},
+	}
+	for _, item := range metrics {
+		if item.UTCTimestampMs > result.UTCTimestampMs {
+			result.UTCTimestampMs = item.UTCTimestampMs
+		}
+		result.Block.add(item.Block)
+		result.Net.add(item.Net)
+		result.VCPU.add(item.VCPU)
+	}
+	return result
+}
+
+func (c MetricsCounters) add(other MetricsCounters) {
+	for name, value := range other {
+		c[name] = c[name] + value
+	}
+}
+
+// ReadLatestMetrics reads the last complete metrics line written by Firecracker to the metrics file.
+func ReadLatestMetrics(path string) (*Metrics, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed opening metrics file")
+	}
+	defer file.Close()
+	var latest []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxMetricsLineBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		// the line being written right now may be incomplete:
+		if len(line) > 0 && json.Valid(line) {
+			latest = append(latest[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed reading metrics file")
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("metrics file '%s' has no metrics yet", path)
+	}
+	metrics := &Metrics{}
+	if err := json.Unmarshal(latest, metrics); err != nil {
+		return nil, errors.Wrap(err, "failed decoding metrics")
+	}
+	return metrics, nil
+}
+
+func (p *defaultProvider) FlushMetrics(ctx context.Context, vmmID string) error {
+	if err := p.apiClient(vmmID).call(ctx, http.MethodPut, "/actions", map[string]interface{}{
+		"action_type": "FlushMetrics",
+	}, nil); err != nil {
+		return errors.Wrap(err, "failed flushing metrics")
+	}
+	return nil
+}
+
+// metricsHandler creates the metrics file in the jail and configures the metrics before the instance is started.
+// Firecracker does not create the metrics file, the file must be writable by the jailer UID and GID.
+func metricsHandler() firecracker.Handler {
+	return firecracker.Handler{
+		Name: AddMetricsHandlerName,
+		Fn: func(ctx context.Context, m *firecracker.Machine) error {
+			metricsPath := filepath.Join(jailRoot(m), naming.MetricsFileName)
+			file, err := os.OpenFile(metricsPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				return errors.Wrap(err, "failed creating metrics file")
+			}
+			file.Close()
+			if err := os.Chown(metricsPath, *m.Cfg.JailerCfg.UID, *m.Cfg.JailerCfg.GID); err != nil {
+				return errors.Wrap(err, "failed changing metrics file owner")
+			}
+			// the path is relative to the jail root:
+			if err := newFcAPIClient(m.Cfg.SocketPath).call(ctx, http.MethodPut, "/metrics", map[string]interface{}{
+				"metrics_path": naming.MetricsFileName,
+			}, nil); err != nil {
+				return errors.Wrap(err, "failed configuring metrics")
+			}
+			return nil
+		},
+	}
+}
+
+// jailRoot returns the root directory of the jail of the machine.
+func jailRoot(m *firecracker.Machine) string {
+	return filepath.Join(m.Cfg.JailerCfg.ChrootBaseDir,
+		filepath.Base(m.Cfg.JailerCfg.ExecFile),
+		m.Cfg.JailerCfg.ID,
+		"root")
+}
@@ -0,0 +1,61 @@
+package vmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadLatestMetrics(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp directory to be created, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	metricsPath := filepath.Join(tempDir, "metrics")
+	_, err = ReadLatestMetrics(metricsPath)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(metricsPath, []byte{}, 0644))
+	_, err = ReadLatestMetrics(metricsPath)
+	assert.NotNil(t, err)
+
+	content := `{"utc_timestamp_ms":1000,"block":{"read_bytes":10},"net":{"rx_bytes_count":1},"vcpu":{"exit_io_in":1}}
+{"utc_timestamp_ms":2000,"block":{"read_bytes":20,"read_agg":{"min_us":1}},"net":{"rx_bytes_count":2},"vcpu":{"exit_io_in":2},"api_server":{"process_startup_time_us":5}}
+{"utc_timestamp_ms":3000,"block":{"read_by`
+	assert.Nil(t, ioutil.WriteFile(metricsPath, []byte(content), 0644))
+	metrics, err := ReadLatestMetrics(metricsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, &Metrics{
+		UTCTimestampMs: 2000,
+		Block:          MetricsCounters{"read_bytes": 20},
+		Net:            MetricsCounters{"rx_bytes_count": 2},
+		VCPU:           MetricsCounters{"exit_io_in": 2},
+	}, metrics)
+}
+
+func TestAggregateMetrics(t *testing.T) {
+	assert.Equal(t, &Metrics{
+		UTCTimestampMs: 2000,
+		Block:          MetricsCounters{"read_bytes": 30, "write_bytes": 5},
+		Net:            MetricsCounters{"rx_bytes_count": 3},
+		VCPU:           MetricsCounters{"exit_io_in": 2},
+	}, AggregateMetrics(&Metrics{
+		UTCTimestampMs: 2000,
+		Block:          MetricsCounters{"read_bytes": 10},
+		Net:            MetricsCounters{"rx_bytes_count": 1},
+		VCPU:           MetricsCounters{"exit_io_in": 2},
+	}, &Metrics{
+		UTCTimestampMs: 1000,
+		Block:          MetricsCounters{"read_bytes": 20, "write_bytes": 5},
+		Net:            MetricsCounters{"rx_bytes_count": 2},
+	}))
+
+	empty := AggregateMetrics()
+	assert.Equal(t, int64(0), empty.UTCTimestampMs)
+	assert.Empty(t, empty.Block)
+}
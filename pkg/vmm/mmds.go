@@ -0,0 +1,43 @@
+package vmm
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/pkg/errors"
+)
+
+// ConfigureMMDSHandlerName is the name of the handler configuring the MMDS version.
+const ConfigureMMDSHandlerName = "firebuild.ConfigureMMDS"
+
+// mmdsConfigHandler configures the MMDS version for the network interfaces allowing the MMDS requests.
+// The handler must run after the network interfaces are created.
+func mmdsConfigHandler(machineConfig *configs.MachineConfig) firecracker.Handler {
+	return firecracker.Handler{
+		Name: ConfigureMMDSHandlerName,
+		Fn: func(ctx context.Context, m *firecracker.Machine) error {
+			if err := newFcAPIClient(m.Cfg.SocketPath).call(ctx, http.MethodPut, "/mmds/config", map[string]interface{}{
+				"version":            machineConfig.MMDSVersionOrDefault(),
+				"network_interfaces": mmdsNetworkInterfaceIDs(m.Cfg.NetworkInterfaces),
+			}, nil); err != nil {
+				return errors.Wrap(err, "failed configuring MMDS")
+			}
+			return nil
+		},
+	}
+}
+
+// mmdsNetworkInterfaceIDs returns the IDs of the network interfaces allowing the MMDS requests.
+// The SDK numbers the network interfaces from 1, in the configuration order.
+func mmdsNetworkInterfaceIDs(ifaces firecracker.NetworkInterfaces) []string {
+	ids := []string{}
+	for idx, iface := range ifaces {
+		if iface.AllowMMDS {
+			ids = append(ids, strconv.Itoa(idx+1))
+		}
+	}
+	return ids
+}
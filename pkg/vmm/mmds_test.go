@@ -0,0 +1,17 @@
+package vmm
+
+import (
+	"testing"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMMDSNetworkInterfaceIDs(t *testing.T) {
+	assert.Equal(t, []string{}, mmdsNetworkInterfaceIDs(firecracker.NetworkInterfaces{}))
+	assert.Equal(t, []string{"1", "3"}, mmdsNetworkInterfaceIDs(firecracker.NetworkInterfaces{
+		{AllowMMDS: true},
+		{AllowMMDS: false},
+		{AllowMMDS: true},
+	}))
+}
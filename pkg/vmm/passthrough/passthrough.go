@@ -0,0 +1,113 @@
+package passthrough
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// DeviceTypeVFIO is the VFIO group device type.
+const DeviceTypeVFIO = "vfio"
+
+// vfioDirectory is the directory of the VFIO group devices.
+const vfioDirectory = "/dev/vfio"
+
+// ErrUnsupported is returned when the VMM is requested with passthrough devices.
+// Firecracker emulates a minimal set of virtio-mmio devices and has no PCI bus,
+// the host devices can't be attached to the guest.
+var ErrUnsupported = errors.New("device passthrough is not supported by the Firecracker VMM")
+
+// Device is a host device requested for the passthrough.
+type Device struct {
+	Path  string `json:"Path" mapstructure:"Path"`
+	Type  string `json:"Type" mapstructure:"Type"`
+	Major uint32 `json:"Major" mapstructure:"Major"`
+	Minor uint32 `json:"Minor" mapstructure:"Minor"`
+}
+
+// Resolve resolves the device at the path.
+// The device must be a VFIO group character device, the VFIO container is not a group.
+func Resolve(path string) (*Device, error) {
+	cleanPath := filepath.Clean(path)
+	if filepath.Dir(cleanPath) != vfioDirectory || filepath.Base(cleanPath) == "vfio" {
+		return nil, fmt.Errorf("device '%s' is not a VFIO group device, expected %s/<group>", path, vfioDirectory)
+	}
+	stat, err := os.Stat(cleanPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "device '%s' stat error", path)
+	}
+	if stat.Mode()&os.ModeDevice == 0 || stat.Mode()&os.ModeCharDevice == 0 {
+		return nil, fmt.Errorf("device '%s' is not a character device", path)
+	}
+	sysStat, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("device '%s' stat error: unsupported platform", path)
+	}
+	return &Device{
+		Path:  cleanPath,
+		Type:  DeviceTypeVFIO,
+		Major: major(uint64(sysStat.Rdev)),
+		Minor: minor(uint64(sysStat.Rdev)),
+	}, nil
+}
+
+// CheckAccess checks if the jailer UID and GID can read and write the device.
+// The jailer drops the privileges before executing Firecracker so the device
+// must be accessible by the jailer UID or GID.
+func (d *Device) CheckAccess(uid, gid int) error {
+	if uid == 0 {
+		return nil
+	}
+	stat, err := os.Stat(d.Path)
+	if err != nil {
+		return errors.Wrapf(err, "device '%s' stat error", d.Path)
+	}
+	sysStat, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("device '%s' stat error: unsupported platform", d.Path)
+	}
+	mode := stat.Mode().Perm()
+	switch {
+	case int(sysStat.Uid) == uid && mode&0600 == 0600:
+		return nil
+	case int(sysStat.Gid) == gid && mode&0060 == 0060:
+		return nil
+	case mode&0006 == 0006:
+		return nil
+	}
+	return fmt.Errorf("device '%s' is not accessible by the jailer uid %d and gid %d: owner %d:%d, mode %s",
+		d.Path, uid, gid, sysStat.Uid, sysStat.Gid, mode)
+}
+
+// ResolveAll resolves the devices and checks the access by the jailer UID and GID.
+func ResolveAll(paths []string, uid, gid int) ([]*Device, error) {
+	devices := []*Device{}
+	seen := map[string]bool{}
+	for _, path := range paths {
+		device, err := Resolve(path)
+		if err != nil {
+			return nil, err
+		}
+		if seen[device.Path] {
+			return nil, fmt.Errorf("device '%s' given more than once", path)
+		}
+		seen[device.Path] = true
+		if err := device.CheckAccess(uid, gid); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// major and minor follow the Linux encoding of the device numbers.
+func major(dev uint64) uint32 {
+	return uint32(((dev >> 8) & 0xfff) | ((dev >> 32) & 0xfffff000))
+}
+
+func minor(dev uint64) uint32 {
+	return uint32((dev & 0xff) | ((dev >> 12) & 0xffffff00))
+}
@@ -0,0 +1,53 @@
+package passthrough
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveRejectsNonVFIODevices(t *testing.T) {
+	for _, path := range []string{"/dev/null", "/dev/vfio/vfio", "/dev/vfio/../null", "/dev/vfio"} {
+		_, err := Resolve(path)
+		assert.NotNil(t, err, "expected an error for %s", path)
+	}
+	_, err := Resolve("/dev/vfio/65535")
+	assert.NotNil(t, err)
+}
+
+func TestDeviceNumbers(t *testing.T) {
+	stat, err := os.Stat("/dev/null")
+	if err != nil {
+		t.Skip("/dev/null not available", err)
+	}
+	rdev := uint64(stat.Sys().(*syscall.Stat_t).Rdev)
+	assert.Equal(t, uint32(1), major(rdev))
+	assert.Equal(t, uint32(3), minor(rdev))
+}
+
+func TestCheckAccess(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal("expected temp file, got error", err)
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+	assert.Nil(t, os.Chmod(tempFile.Name(), 0600))
+
+	device := &Device{Path: tempFile.Name()}
+	assert.Nil(t, device.CheckAccess(0, 0), "expected root to access the device")
+	assert.Nil(t, device.CheckAccess(os.Getuid(), 65534))
+	assert.NotNil(t, device.CheckAccess(os.Getuid()+1, 65534))
+
+	assert.Nil(t, os.Chmod(tempFile.Name(), 0666))
+	assert.Nil(t, device.CheckAccess(os.Getuid()+1, 65534))
+}
+
+func TestResolveAllWithoutDevices(t *testing.T) {
+	devices, err := ResolveAll([]string{}, 1000, 1000)
+	assert.Nil(t, err)
+	assert.Empty(t, devices)
+}
@@ -1,13 +1,27 @@
 package pid
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
+// waitInterval is how often Wait checks if the process is still running.
+const waitInterval = time.Millisecond * 100
+
+const defaultProcDir = "/proc"
+
+// vmmProcessNames are the base name prefixes of the processes a VMM PID points at,
+// the jailer execs Firecracker in place so the PID of a jailed VMM is the Firecracker process.
+var vmmProcessNames = []string{"firecracker", "jailer"}
+
 // RunningVMMPID represents a running VMM pid information.
 type RunningVMMPID struct {
 	Pid int `json:"Pid"`
@@ -42,25 +56,76 @@ func (p *RunningVMMPID) IsRunning() (bool, error) {
 	return false, err
 }
 
-// Wait waits for the process represented by this PID to exit.
+// IsVMM checks if the process identified by the PID is running and is a Firecracker or jailer process.
+// The PID of a VMM which died may be reused by an unrelated process, the process command line tells them apart.
+// The base names of the given binaries are accepted in addition to the default Firecracker and jailer names.
+func (p *RunningVMMPID) IsVMM(binaries ...string) (bool, error) {
+	running, err := p.IsRunning()
+	if err != nil || !running {
+		return false, err
+	}
+	return isVMMProcess(defaultProcDir, p.Pid, binaries)
+}
+
+func isVMMProcess(procDir string, pid int, binaries []string) (bool, error) {
+	cmdline, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// the process exited in the meantime:
+			return false, nil
+		}
+		return false, err
+	}
+	// the command line of a zombie process is empty:
+	program := filepath.Base(string(bytes.SplitN(cmdline, []byte{0}, 2)[0]))
+	if program == "." || program == "/" {
+		return false, nil
+	}
+	for _, name := range vmmProcessNames {
+		if strings.HasPrefix(program, name) {
+			return true, nil
+		}
+	}
+	for _, binary := range binaries {
+		if binary != "" && program == filepath.Base(binary) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Kill kills the process represented by this PID.
+func (p *RunningVMMPID) Kill() error {
+	if p.Pid <= 0 {
+		return fmt.Errorf("invalid pid %v", p.Pid)
+	}
+	proc, err := os.FindProcess(p.Pid)
+	if err != nil {
+		return err
+	}
+	return proc.Signal(syscall.SIGKILL)
+}
+
+// Wait waits for the process represented by this PID to exit, returns the context error if the process
+// is still running when the context is done.
 func (p *RunningVMMPID) Wait(ctx context.Context) error {
 	chanErr := make(chan error, 1)
 	go func() {
 		// the process is not something we have started so we can't just wait for it...
 		for {
 			if ctx.Err() != nil {
-				close(chanErr)
 				return
 			}
 			isRunning, err := p.IsRunning()
 			if err != nil {
 				chanErr <- err
-				break
+				return
 			}
-			if isRunning {
-				continue
+			if !isRunning {
+				close(chanErr)
+				return
 			}
-			time.Sleep(time.Second)
+			time.Sleep(waitInterval)
 		}
 	}()
 	select {
@@ -0,0 +1,94 @@
+package pid
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skip("sleep not available", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		// reap the child so it does not linger as a zombie:
+		cmd.Wait()
+		close(exited)
+	}()
+	defer cmd.Process.Kill()
+
+	running := &RunningVMMPID{Pid: cmd.Process.Pid}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancelFunc()
+	if err := running.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatal("expected running process wait to time out, got", err)
+	}
+
+	if err := running.Kill(); err != nil {
+		t.Fatal("expected process to be killed, got error", err)
+	}
+	<-exited
+
+	ctx, cancelFunc = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancelFunc()
+	if err := running.Wait(ctx); err != nil {
+		t.Fatal("expected exited process wait to succeed, got error", err)
+	}
+}
+
+func TestIsVMM(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skip("sleep not available", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	// a reused PID of an unrelated process is not a VMM:
+	isVMM, err := (&RunningVMMPID{Pid: cmd.Process.Pid}).IsVMM()
+	if err != nil || isVMM {
+		t.Fatal("expected sleep not to be a VMM, got", isVMM, err)
+	}
+
+	procDir, err := ioutil.TempDir("", "pid-proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(procDir)
+
+	cmdlines := map[int]string{
+		100: "/firecracker-v0.22.4-x86_64\x00--id\x00abc\x00",
+		101: "/usr/bin/jailer\x00--id\x00abc\x00",
+		102: "/opt/fc/custom-vmm\x00--id\x00abc\x00",
+		103: "/usr/sbin/nginx\x00-g\x00daemon off;\x00",
+		104: "",
+	}
+	for pid, cmdline := range cmdlines {
+		if err := os.MkdirAll(filepath.Join(procDir, strconv.Itoa(pid)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(procDir, strconv.Itoa(pid), "cmdline"), []byte(cmdline), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for pid, expected := range map[int]bool{100: true, 101: true, 102: true, 103: false, 104: false, 105: false} {
+		isVMM, err := isVMMProcess(procDir, pid, []string{"/opt/fc/custom-vmm"})
+		if err != nil {
+			t.Fatal("unexpected error for pid", pid, err)
+		}
+		if isVMM != expected {
+			t.Fatalf("expected pid %d VMM status %v, got %v", pid, expected, isVMM)
+		}
+	}
+}
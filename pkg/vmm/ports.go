@@ -0,0 +1,36 @@
+package vmm
+
+import (
+	"github.com/combust-labs/firebuild/pkg/fw"
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/hashicorp/go-hclog"
+)
+
+// UnpublishPorts unpublishes the ports of the VMM.
+// The ports can be changed with the publish command while the VMM runs
+// so the ports recorded in the stored metadata take precedence over the given metadata.
+func UnpublishPorts(logger hclog.Logger, md *metadata.MDRun) {
+	if stored, hasMetadata, err := FetchMetadataIfExists(md.RunCache); err == nil && hasMetadata {
+		md = stored
+	}
+	if len(md.Configs.RunConfig.Ports) == 0 || len(md.NetworkInterfaces) == 0 {
+		return
+	}
+	portsManager, managerErr := fw.NewManager(md.VMMID, md.PublishAddresses()...)
+	if managerErr != nil {
+		logger.Warn("port cleanup failed", "reason", managerErr)
+		return
+	}
+	ports := []fw.ExposedPort{}
+	for _, port := range md.Configs.RunConfig.Ports {
+		parsedPort, parseErr := fw.ExposedPortFromString(port)
+		if parseErr != nil {
+			logger.Warn("port cleanup: port failed to parse", "reason", parseErr, "raw-input", port)
+			continue
+		}
+		ports = append(ports, parsedPort)
+	}
+	if err := portsManager.Unpublish(ports); err != nil {
+		logger.Warn("port cleanup failed", "reason", err)
+	}
+}
@@ -0,0 +1,84 @@
+package vmm
+
+import (
+	"os"
+
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/combust-labs/firebuild/pkg/vmm/chroot"
+	"github.com/combust-labs/firebuild/pkg/vmm/cni"
+	"github.com/hashicorp/go-hclog"
+	"github.com/opentracing/opentracing-go"
+)
+
+// PurgeStopped removes the remains of the stopped VMM: the jail directory, the CNI network allocation,
+// the published ports, the egress policy and the run cache directory of the VMM.
+// The failures are logged, the remaining resources are removed regardless.
+func PurgeStopped(logger hclog.Logger, md *metadata.MDRun, runCacheDir string,
+	tracer opentracing.Tracer, spanContext opentracing.SpanContext) {
+
+	spanPurgeChroot := tracer.StartSpan("vmm-purge-chroot", opentracing.ChildOf(spanContext))
+	spanPurgeChroot.SetTag("vmm-id", md.VMMID)
+
+	if md.Configs.Jailer != nil {
+		chrootInst := chroot.NewWithLocation(chroot.LocationFromComponents(md.Configs.Jailer.ChrootBase,
+			md.Configs.Jailer.BinaryFirecracker,
+			md.VMMID))
+		chrootExists, chrootErr := chrootInst.Exists()
+
+		if chrootErr != nil {
+			spanPurgeChroot.SetBaggageItem("chroot-fetch-error", chrootErr.Error())
+			logger.Error("error while checking VMM chroot, skipping", "reason", chrootErr)
+		}
+
+		spanPurgeChroot.SetTag("chroot-existed", chrootExists)
+
+		if chrootErr == nil && chrootExists {
+			if err := chrootInst.RemoveAll(); err != nil {
+				spanPurgeChroot.SetBaggageItem("chroot-purge-error", err.Error())
+				logger.Error("error removing chroot directory fro stopped VMM", "reason", err)
+			}
+		}
+	}
+
+	spanPurgeChroot.Finish()
+
+	spanPurgeCNI := tracer.StartSpan("vmm-purge-cni", opentracing.ChildOf(spanPurgeChroot.Context()))
+	spanPurgeCNI.SetTag("vmm-id", md.VMMID)
+
+	if md.Configs.CNI != nil {
+		if err := cni.CleanupCNI(logger,
+			md.Configs.CNI,
+			md.VMMID, md.CNI.VethName,
+			md.CNI.NetName, md.CNI.NetNS); err != nil {
+			spanPurgeCNI.SetBaggageItem("cni-purge-error", err.Error())
+			logger.Error("failed cleaning up CNI", "reason", err)
+		}
+	}
+
+	spanPurgeCNI.Finish()
+
+	spanPurgeIPT := tracer.StartSpan("vmm-purge-ipt", opentracing.ChildOf(spanPurgeCNI.Context()))
+	spanPurgeIPT.SetTag("vmm-id", md.VMMID)
+
+	if md.Configs.RunConfig != nil {
+		UnpublishPorts(logger, md)
+	}
+
+	if md.Egress != nil {
+		logger.Info("removing egress policy")
+		RemoveEgressPolicy(logger, md)
+		logger.Info("egress policy removed")
+	}
+
+	spanPurgeIPT.Finish()
+
+	spanPurgeCache := tracer.StartSpan("vmm-purge-cache", opentracing.ChildOf(spanPurgeIPT.Context()))
+	spanPurgeCache.SetTag("vmm-id", md.VMMID)
+
+	if err := os.RemoveAll(runCacheDir); err != nil {
+		spanPurgeCache.SetBaggageItem("cache-purge-error", err.Error())
+		logger.Error("failed removing cache directroy", "reason", err, "path", runCacheDir)
+	}
+
+	spanPurgeCache.Finish()
+}
@@ -0,0 +1,126 @@
+package vmm
+
+import (
+	"context"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/pkg/errors"
+)
+
+// AddRateLimitersHandlerName is the name of the handler configuring the network and block device rate limiters.
+const AddRateLimitersHandlerName = "firebuild.AddRateLimiters"
+
+// rateLimiterRefillTimeMs is the refill time of the token buckets, the bucket size is the rate per second.
+const rateLimiterRefillTimeMs = 1000
+
+// HasRateLimiters returns true when the machine configuration limits the network or block devices.
+func HasRateLimiters(machineConfig *configs.MachineConfig) bool {
+	return machineConfig.DriveBandwidth != "" ||
+		machineConfig.DriveOps != "" ||
+		machineConfig.NetRxBandwidth != "" ||
+		machineConfig.NetTxBandwidth != ""
+}
+
+// DriveRateLimiter returns the block device rate limiter of the machine configuration, nil for no limit.
+func DriveRateLimiter(machineConfig *configs.MachineConfig) (*models.RateLimiter, error) {
+	bandwidth, err := bandwidthTokenBucket(machineConfig.DriveBandwidth)
+	if err != nil {
+		return nil, errors.Wrap(err, "--drive-bw invalid")
+	}
+	ops, err := opsTokenBucket(machineConfig.DriveOps)
+	if err != nil {
+		return nil, errors.Wrap(err, "--drive-ops invalid")
+	}
+	return rateLimiter(bandwidth, ops), nil
+}
+
+// NetworkRateLimiters returns the received and sent traffic rate limiters of the machine configuration,
+// nil for no limit.
+func NetworkRateLimiters(machineConfig *configs.MachineConfig) (*models.RateLimiter, *models.RateLimiter, error) {
+	rx, err := bandwidthTokenBucket(machineConfig.NetRxBandwidth)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "--net-rx-bw invalid")
+	}
+	tx, err := bandwidthTokenBucket(machineConfig.NetTxBandwidth)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "--net-tx-bw invalid")
+	}
+	return rateLimiter(rx, nil), rateLimiter(tx, nil), nil
+}
+
+// rateLimitersHandler sets the rate limiters of the drives and the network interfaces
+// before they are attached. The rate limiters are recorded in the run metadata with the drives
+// and the network interfaces.
+func rateLimitersHandler(machineConfig *configs.MachineConfig) firecracker.Handler {
+	return firecracker.Handler{
+		Name: AddRateLimitersHandlerName,
+		Fn: func(ctx context.Context, m *firecracker.Machine) error {
+			driveLimiter, err := DriveRateLimiter(machineConfig)
+			if err != nil {
+				return err
+			}
+			rxLimiter, txLimiter, err := NetworkRateLimiters(machineConfig)
+			if err != nil {
+				return err
+			}
+			if driveLimiter != nil {
+				for i := range m.Cfg.Drives {
+					m.Cfg.Drives[i].RateLimiter = driveLimiter
+				}
+			}
+			// the received traffic is the incoming traffic of the guest:
+			for i := range m.Cfg.NetworkInterfaces {
+				if rxLimiter != nil {
+					m.Cfg.NetworkInterfaces[i].InRateLimiter = rxLimiter
+				}
+				if txLimiter != nil {
+					m.Cfg.NetworkInterfaces[i].OutRateLimiter = txLimiter
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func bandwidthTokenBucket(input string) (*models.TokenBucket, error) {
+	if input == "" {
+		return nil, nil
+	}
+	value, err := utils.ParseBandwidth(input)
+	if err != nil {
+		return nil, err
+	}
+	return tokenBucket(value), nil
+}
+
+func opsTokenBucket(input string) (*models.TokenBucket, error) {
+	if input == "" {
+		return nil, nil
+	}
+	value, err := utils.ParseOpsRate(input)
+	if err != nil {
+		return nil, err
+	}
+	return tokenBucket(value), nil
+}
+
+// tokenBucket returns the token bucket refilled with the rate every second, nil for no limit.
+func tokenBucket(ratePerSecond int64) *models.TokenBucket {
+	if ratePerSecond == 0 {
+		return nil
+	}
+	return &models.TokenBucket{
+		RefillTime: firecracker.Int64(rateLimiterRefillTimeMs),
+		Size:       firecracker.Int64(ratePerSecond),
+	}
+}
+
+func rateLimiter(bandwidth, ops *models.TokenBucket) *models.RateLimiter {
+	if bandwidth == nil && ops == nil {
+		return nil
+	}
+	return &models.RateLimiter{Bandwidth: bandwidth, Ops: ops}
+}
@@ -0,0 +1,40 @@
+package vmm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitersHandler(t *testing.T) {
+	machineConfig := configs.NewMachineConfig()
+	assert.False(t, HasRateLimiters(machineConfig))
+
+	machineConfig.DriveBandwidth = "50MiB/s"
+	machineConfig.DriveOps = "1000/s"
+	machineConfig.NetRxBandwidth = "10MiB/s"
+	assert.True(t, HasRateLimiters(machineConfig))
+
+	m := &firecracker.Machine{Cfg: firecracker.Config{
+		Drives:            []models.Drive{{DriveID: firecracker.String("1")}},
+		NetworkInterfaces: []firecracker.NetworkInterface{{AllowMMDS: true}},
+	}}
+	assert.Nil(t, rateLimitersHandler(machineConfig).Fn(context.Background(), m))
+
+	assert.Equal(t, &models.RateLimiter{
+		Bandwidth: &models.TokenBucket{RefillTime: firecracker.Int64(1000), Size: firecracker.Int64(50 * 1024 * 1024)},
+		Ops:       &models.TokenBucket{RefillTime: firecracker.Int64(1000), Size: firecracker.Int64(1000)},
+	}, m.Cfg.Drives[0].RateLimiter)
+	assert.Equal(t, &models.RateLimiter{
+		Bandwidth: &models.TokenBucket{RefillTime: firecracker.Int64(1000), Size: firecracker.Int64(10 * 1024 * 1024)},
+	}, m.Cfg.NetworkInterfaces[0].InRateLimiter)
+	// not limited:
+	assert.Nil(t, m.Cfg.NetworkInterfaces[0].OutRateLimiter)
+
+	machineConfig.NetTxBandwidth = "-1"
+	assert.NotNil(t, rateLimitersHandler(machineConfig).Fn(context.Background(), m))
+}
@@ -0,0 +1,214 @@
+package vmm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/naming"
+	"github.com/combust-labs/firebuild/pkg/strategy/arbitrary"
+	"github.com/combust-labs/firebuild/pkg/utils"
+	"github.com/combust-labs/firebuild/pkg/vmm/chroot"
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/pkg/errors"
+)
+
+const (
+	// LinkSnapshotFilesHandlerName is the name of the handler linking the snapshot files to the jail.
+	LinkSnapshotFilesHandlerName = "firebuild.LinkSnapshotFiles"
+	// LoadSnapshotHandlerName is the name of the handler loading the snapshot into the VMM.
+	LoadSnapshotHandlerName = "firebuild.LoadSnapshot"
+)
+
+func (p *defaultProvider) CreateSnapshot(ctx context.Context, vmmID, memFilePath, snapshotPath string) error {
+
+	machineChroot := chroot.NewWithLocation(chroot.LocationFromComponents(p.jailingFcConfig.ChrootBase,
+		p.jailingFcConfig.BinaryFirecracker,
+		vmmID))
+
+	socketPath, hasSocket, err := machineChroot.SocketPathIfExists()
+	if err != nil {
+		return errors.Wrap(err, "failed checking if the VMM socket file exists")
+	}
+	if !hasSocket {
+		return fmt.Errorf("VMM socket file '%s' does not exist", socketPath)
+	}
+
+	client := newFcAPIClient(socketPath)
+
+	if err := client.patchVMState(ctx, "Paused"); err != nil {
+		return errors.Wrap(err, "failed pausing the VMM")
+	}
+
+	// the snapshot is written by the jailed Firecracker process
+	// so the paths are relative to the jail root:
+	if err := client.createSnapshot(ctx, naming.SnapshotFileName, naming.SnapshotMemFileName); err != nil {
+		if resumeErr := client.patchVMState(ctx, "Resumed"); resumeErr != nil {
+			p.logger.Error("failed resuming the VMM after failed snapshot", "reason", resumeErr)
+		}
+		return errors.Wrap(err, "failed creating the snapshot")
+	}
+
+	jailRoot := filepath.Join(machineChroot.FullPath(), "root")
+	if err := moveFile(filepath.Join(jailRoot, naming.SnapshotFileName), snapshotPath); err != nil {
+		return errors.Wrap(err, "failed moving the snapshot file")
+	}
+	if err := moveFile(filepath.Join(jailRoot, naming.SnapshotMemFileName), memFilePath); err != nil {
+		return errors.Wrap(err, "failed moving the snapshot memory file")
+	}
+
+	return nil
+}
+
+func (p *defaultProvider) Pause(ctx context.Context, vmmID string) error {
+	client, err := p.existingAPIClient(vmmID)
+	if err != nil {
+		return err
+	}
+	return client.patchVMState(ctx, "Paused")
+}
+
+func (p *defaultProvider) Resume(ctx context.Context, vmmID string) error {
+	client, err := p.existingAPIClient(vmmID)
+	if err != nil {
+		return err
+	}
+	return client.patchVMState(ctx, "Resumed")
+}
+
+func (p *defaultProvider) RestoreSnapshot(ctx context.Context, memFilePath, snapshotPath string) (StartedMachine, error) {
+
+	machineChroot := chroot.NewWithLocation(chroot.LocationFromComponents(p.jailingFcConfig.ChrootBase,
+		p.jailingFcConfig.BinaryFirecracker,
+		p.jailingFcConfig.VMMID()))
+
+	// the kernel is not required, the machine state comes from the snapshot:
+	restoreStrategy := arbitrary.NewStrategy(func() *arbitrary.HandlerPlacement {
+		return arbitrary.NewHandlerPlacement(linkSnapshotFilesHandler(memFilePath, snapshotPath),
+			firecracker.CreateLogFilesHandlerName)
+	})
+
+	fcConfig := configs.NewFcConfigProvider(p.jailingFcConfig, p.machineConfig).
+		WithCNIConfig(p.cniConfig).
+		WithConsoleOutput(p.consoleOutput).
+		WithDrives(p.drives).
+		WithHandlersAdapter(restoreStrategy).
+		WithVethIfaceName(p.vethIfaceName).
+		ToSDKConfig()
+	m, err := firecracker.NewMachine(ctx, fcConfig, p.machineOpts(machineChroot)...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed creating machine: %s", err)
+	}
+
+	// the network namespace and the tap device of the snapshotted VMM are reused
+	// and the machine configuration is a part of the snapshot:
+	m.Handlers.Validation = m.Handlers.Validation.Remove(firecracker.ValidateNetworkCfgHandlerName)
+	for _, name := range []string{
+		firecracker.SetupNetworkHandlerName,
+		firecracker.SetupKernelArgsHandlerName,
+		firecracker.CreateMachineHandlerName,
+		firecracker.CreateBootSourceHandlerName,
+		firecracker.AttachDrivesHandlerName,
+		firecracker.CreateNetworkInterfacesHandlerName,
+		firecracker.AddVsocksHandlerName,
+	} {
+		m.Handlers.FcInit = m.Handlers.FcInit.Remove(name)
+	}
+	// the metrics are not a part of the snapshot and must be configured before the snapshot is loaded:
+	m.Handlers.FcInit = m.Handlers.FcInit.AppendAfter(firecracker.BootstrapLoggingHandlerName, metricsHandler())
+	m.Handlers.FcInit = m.Handlers.FcInit.Append(loadSnapshotHandler())
+
+	// Start() would issue InstanceStart which is not allowed after loading a snapshot:
+	if err := m.Handlers.Run(ctx, m); err != nil {
+		return nil, fmt.Errorf("Failed to restore machine: %v", err)
+	}
+
+	return &defaultStartedMachine{
+		cniConfig:       p.cniConfig,
+		jailingFcConfig: p.jailingFcConfig,
+		machineConfig:   p.machineConfig,
+		logger:          p.logger,
+		machine:         m,
+		metricsPath:     filepath.Join(machineChroot.FullPath(), "root", naming.MetricsFileName),
+		vethIfaceName:   p.vethIfaceName,
+		vsockPath:       filepath.Join(machineChroot.FullPath(), "root", p.machineConfig.VsockPathOrDefault()),
+	}, nil
+}
+
+func linkSnapshotFilesHandler(memFilePath, snapshotPath string) firecracker.Handler {
+	return firecracker.Handler{
+		Name: LinkSnapshotFilesHandlerName,
+		Fn: func(ctx context.Context, m *firecracker.Machine) error {
+			jailRoot := jailRoot(m)
+			// the snapshot refers to the drives using the paths from the original jail:
+			for i, drive := range m.Cfg.Drives {
+				hostPath := firecracker.StringValue(drive.PathOnHost)
+				driveFileName := filepath.Base(hostPath)
+				if err := os.Link(hostPath, filepath.Join(jailRoot, driveFileName)); err != nil {
+					return err
+				}
+				m.Cfg.Drives[i].PathOnHost = firecracker.String(driveFileName)
+			}
+			for source, target := range map[string]string{
+				memFilePath:  naming.SnapshotMemFileName,
+				snapshotPath: naming.SnapshotFileName,
+			} {
+				if err := os.Link(source, filepath.Join(jailRoot, target)); err != nil {
+					return err
+				}
+				if err := os.Chown(filepath.Join(jailRoot, target), *m.Cfg.JailerCfg.UID, *m.Cfg.JailerCfg.GID); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func loadSnapshotHandler() firecracker.Handler {
+	return firecracker.Handler{
+		Name: LoadSnapshotHandlerName,
+		Fn: func(ctx context.Context, m *firecracker.Machine) error {
+			client := newFcAPIClient(m.Cfg.SocketPath)
+			if err := client.loadSnapshot(ctx, naming.SnapshotFileName, naming.SnapshotMemFileName); err != nil {
+				return errors.Wrap(err, "failed loading the snapshot")
+			}
+			return client.patchVMState(ctx, "Resumed")
+		},
+	}
+}
+
+func moveFile(source, target string) error {
+	if err := os.Rename(source, target); err == nil {
+		return nil
+	}
+	// the jail and the run cache may be on different devices:
+	if err := utils.CopyFile(source, target, utils.RootFSCopyBufferSize); err != nil {
+		return err
+	}
+	return os.Remove(source)
+}
+
+func (c *fcAPIClient) createSnapshot(ctx context.Context, snapshotPath, memFilePath string) error {
+	return c.call(ctx, http.MethodPut, "/snapshot/create", map[string]interface{}{
+		"snapshot_type": "Full",
+		"snapshot_path": snapshotPath,
+		"mem_file_path": memFilePath,
+	}, nil)
+}
+
+func (c *fcAPIClient) loadSnapshot(ctx context.Context, snapshotPath, memFilePath string) error {
+	return c.call(ctx, http.MethodPut, "/snapshot/load", map[string]interface{}{
+		"snapshot_path": snapshotPath,
+		"mem_file_path": memFilePath,
+	}, nil)
+}
+
+func (c *fcAPIClient) patchVMState(ctx context.Context, state string) error {
+	return c.call(ctx, http.MethodPatch, "/vm", map[string]interface{}{
+		"state": state,
+	}, nil)
+}
@@ -0,0 +1,93 @@
+package vmm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/combust-labs/firebuild/configs"
+)
+
+func TestFcAPIClientSnapshotCalls(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	socketPath := filepath.Join(tempDir, "firecracker.socket")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal("expected unix listener, got error", err)
+	}
+
+	type call struct {
+		method string
+		path   string
+		body   map[string]interface{}
+	}
+	calls := make(chan call, 10)
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		calls <- call{method: r.Method, path: r.URL.Path, body: body}
+		if r.URL.Path == "/snapshot/load" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"fault_message":"load failed"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := newFcAPIClient(socketPath)
+
+	if err := client.patchVMState(context.Background(), "Paused"); err != nil {
+		t.Fatal("expected pause to succeed, got error", err)
+	}
+	if c := <-calls; c.method != http.MethodPatch || c.path != "/vm" || c.body["state"] != "Paused" {
+		t.Fatal("unexpected pause call", c)
+	}
+
+	if err := client.createSnapshot(context.Background(), "snapshot", "snapshot.mem"); err != nil {
+		t.Fatal("expected snapshot to succeed, got error", err)
+	}
+	if c := <-calls; c.method != http.MethodPut || c.path != "/snapshot/create" ||
+		c.body["snapshot_type"] != "Full" || c.body["snapshot_path"] != "snapshot" || c.body["mem_file_path"] != "snapshot.mem" {
+		t.Fatal("unexpected snapshot call", c)
+	}
+
+	if err := client.loadSnapshot(context.Background(), "snapshot", "snapshot.mem"); err == nil {
+		t.Fatal("expected load to fail on error status")
+	}
+	<-calls
+}
+
+func TestProviderPauseResumeWithoutSocket(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	jailingFcConfig := configs.NewJailingFirecrackerConfig()
+	jailingFcConfig.ChrootBase = tempDir
+	jailingFcConfig.BinaryFirecracker = "/usr/bin/firecracker"
+
+	provider := NewDefaultProvider(configs.NewCNIConfig(), jailingFcConfig, configs.NewMachineConfig())
+
+	if err := provider.Pause(context.Background(), "vmmid"); !errors.Is(err, ErrVMMSocketNotFound) {
+		t.Fatal("expected pause to fail with socket not found, got", err)
+	}
+	if err := provider.Resume(context.Background(), "vmmid"); !errors.Is(err, ErrVMMSocketNotFound) {
+		t.Fatal("expected resume to fail with socket not found, got", err)
+	}
+}
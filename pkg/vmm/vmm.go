@@ -3,11 +3,18 @@ package vmm
 import (
 	"context"
 	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
 
 	"github.com/combust-labs/firebuild/configs"
+	"github.com/combust-labs/firebuild/pkg/naming"
 	"github.com/combust-labs/firebuild/pkg/vmm/chroot"
+	"github.com/combust-labs/firebuild/pkg/vmm/drives"
+	"github.com/combust-labs/firebuild/pkg/vmm/passthrough"
 	"github.com/firecracker-microvm/firecracker-go-sdk"
 	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -24,9 +31,28 @@ var (
 
 // Provider abstracts the configuration required to start a VMM.
 type Provider interface {
+	// BalloonStats returns the balloon statistics of the VMM identified by the VMM ID.
+	BalloonStats(ctx context.Context, vmmID string) (*BalloonStats, error)
+	// CreateSnapshot pauses the VMM identified by the VMM ID and creates a full snapshot.
+	// The snapshot files are moved to the given host paths, the VMM remains paused.
+	CreateSnapshot(ctx context.Context, vmmID, memFilePath, snapshotPath string) error
+	// FlushMetrics requests the VMM identified by the VMM ID to write the metrics to the metrics file.
+	FlushMetrics(ctx context.Context, vmmID string) error
+	// Pause pauses the VMM identified by the VMM ID.
+	Pause(ctx context.Context, vmmID string) error
+	// RestoreSnapshot starts the VMM in a fresh jailer chroot from the snapshot and resumes it.
+	RestoreSnapshot(ctx context.Context, memFilePath, snapshotPath string) (StartedMachine, error)
+	// Resume resumes the paused VMM identified by the VMM ID.
+	Resume(ctx context.Context, vmmID string) error
 	// Start starts the VMM.
 	Start(context.Context) (StartedMachine, error)
+	// UpdateBalloon updates the balloon target size of the VMM identified by the VMM ID.
+	UpdateBalloon(ctx context.Context, vmmID string, targetMib int64) error
 
+	// WithConsoleOutput copies the serial console output of the started VMM to the writer.
+	WithConsoleOutput(io.Writer) Provider
+	// WithDrives attaches the additional drives to the VMM, next to the root device.
+	WithDrives([]*drives.Drive) Provider
 	WithHandlersAdapter(firecracker.HandlersAdapter) Provider
 	WithVethIfaceName(string) Provider
 }
@@ -36,6 +62,8 @@ type defaultProvider struct {
 	jailingFcConfig *configs.JailingFirecrackerConfig
 	machineConfig   *configs.MachineConfig
 
+	consoleOutput   io.Writer
+	drives          []*drives.Drive
 	handlersAdapter firecracker.HandlersAdapter
 	logger          hclog.Logger
 	machine         *firecracker.Machine
@@ -57,28 +85,38 @@ func NewDefaultProvider(cniConfig *configs.CNIConfig, jailingFcConfig *configs.J
 
 func (p *defaultProvider) Start(ctx context.Context) (StartedMachine, error) {
 
+	// Firecracker has no PCI bus, the devices can't be attached:
+	if len(p.machineConfig.PassthroughDevices) > 0 {
+		return nil, errors.Wrapf(passthrough.ErrUnsupported, "requested devices: %s", strings.Join(p.machineConfig.PassthroughDevices, ", "))
+	}
+
 	machineChroot := chroot.NewWithLocation(chroot.LocationFromComponents(p.jailingFcConfig.JailerChrootDirectory(),
 		p.jailingFcConfig.BinaryFirecracker,
 		p.jailingFcConfig.VMMID()))
 
-	vmmLoggerEntry := logrus.NewEntry(logrus.New())
-	machineOpts := []firecracker.Opt{
-		firecracker.WithLogger(vmmLoggerEntry),
-	}
-
-	if p.machineConfig.LogFcHTTPCalls {
-		machineOpts = append(machineOpts, firecracker.
-			WithClient(firecracker.NewClient(machineChroot.SocketPath(), vmmLoggerEntry, true)))
-	}
-
 	fcConfig := configs.NewFcConfigProvider(p.jailingFcConfig, p.machineConfig).
+		WithCNIConfig(p.cniConfig).
+		WithConsoleOutput(p.consoleOutput).
+		WithDrives(p.drives).
 		WithHandlersAdapter(p.handlersAdapter).
 		WithVethIfaceName(p.vethIfaceName).
 		ToSDKConfig()
-	m, err := firecracker.NewMachine(ctx, fcConfig, machineOpts...)
+	m, err := firecracker.NewMachine(ctx, fcConfig, p.machineOpts(machineChroot)...)
 	if err != nil {
 		return nil, fmt.Errorf("Failed creating machine: %s", err)
 	}
+	if p.machineConfig.Balloon {
+		m.Handlers.FcInit = m.Handlers.FcInit.AppendAfter(firecracker.AddVsocksHandlerName, balloonHandler(p.machineConfig))
+	}
+	m.Handlers.FcInit = m.Handlers.FcInit.AppendAfter(firecracker.BootstrapLoggingHandlerName, metricsHandler())
+	// the rate limiters must be set before the drives and the network interfaces are attached:
+	if HasRateLimiters(p.machineConfig) {
+		m.Handlers.FcInit = m.Handlers.FcInit.AppendAfter(firecracker.CreateMachineHandlerName, rateLimitersHandler(p.machineConfig))
+	}
+	// V1 is the Firecracker default, the older Firecracker versions don't support the MMDS configuration:
+	if !p.machineConfig.NoMMDS && p.machineConfig.MMDSVersionOrDefault() != configs.MMDSVersionV1 {
+		m.Handlers.FcInit = m.Handlers.FcInit.AppendAfter(firecracker.CreateNetworkInterfacesHandlerName, mmdsConfigHandler(p.machineConfig))
+	}
 	if err := m.Start(ctx); err != nil {
 		return nil, fmt.Errorf("Failed to start machine: %v", err)
 	}
@@ -89,10 +127,34 @@ func (p *defaultProvider) Start(ctx context.Context) (StartedMachine, error) {
 		machineConfig:   p.machineConfig,
 		logger:          p.logger,
 		machine:         m,
+		metricsPath:     filepath.Join(machineChroot.FullPath(), "root", naming.MetricsFileName),
 		vethIfaceName:   p.vethIfaceName,
+		vsockPath:       filepath.Join(machineChroot.FullPath(), "root", p.machineConfig.VsockPathOrDefault()),
 	}, nil
 }
 
+func (p *defaultProvider) machineOpts(machineChroot chroot.Chroot) []firecracker.Opt {
+	vmmLoggerEntry := logrus.NewEntry(logrus.New())
+	machineOpts := []firecracker.Opt{
+		firecracker.WithLogger(vmmLoggerEntry),
+	}
+	if p.machineConfig.LogFcHTTPCalls {
+		machineOpts = append(machineOpts, firecracker.
+			WithClient(firecracker.NewClient(machineChroot.SocketPath(), vmmLoggerEntry, true)))
+	}
+	return machineOpts
+}
+
+func (p *defaultProvider) WithConsoleOutput(input io.Writer) Provider {
+	p.consoleOutput = input
+	return p
+}
+
+func (p *defaultProvider) WithDrives(input []*drives.Drive) Provider {
+	p.drives = input
+	return p
+}
+
 func (p *defaultProvider) WithHandlersAdapter(input firecracker.HandlersAdapter) Provider {
 	p.handlersAdapter = input
 	return p
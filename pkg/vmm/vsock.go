@@ -0,0 +1,71 @@
+package vmm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/pkg/errors"
+)
+
+// ErrNoVsock is returned when the VMM has no vsock device.
+var ErrNoVsock = errors.New("the VMM has no vsock device")
+
+// maxVsockHandshakeBytes is the maximum length of the vsock handshake response line.
+const maxVsockHandshakeBytes = 64
+
+// DialVsock connects to the guest port over the vsock device of the VMM.
+// The connection is made through the vsock unix socket on the host, Firecracker forwards it
+// to the guest process listening on the vsock port. The returned connection is ready for use.
+func DialVsock(ctx context.Context, md *metadata.MDRun, port uint32) (net.Conn, error) {
+	if md.Vsock == nil {
+		return nil, ErrNoVsock
+	}
+	return dialVsockSocket(ctx, md.Vsock.HostPath, port)
+}
+
+func dialVsockSocket(ctx context.Context, socketPath string, port uint32) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed connecting to the vsock socket")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := vsockHandshake(conn, port); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// vsockHandshake requests the guest port, Firecracker replies with OK and the host side port.
+// The response is read byte by byte so no guest data following the response is consumed.
+func vsockHandshake(conn net.Conn, port uint32) error {
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		return errors.Wrap(err, "failed writing the vsock connect request")
+	}
+	response := []byte{}
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return errors.Wrapf(err, "failed reading the vsock connect response, is the guest listening on port %d", port)
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		response = append(response, buf[0])
+		if len(response) > maxVsockHandshakeBytes {
+			return fmt.Errorf("vsock connect response too long")
+		}
+	}
+	if !strings.HasPrefix(string(response), "OK ") {
+		return fmt.Errorf("vsock connect to port %d failed: %q", port, string(response))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package vmm
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/combust-labs/firebuild/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialVsock(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal("expected temp dir, got error", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	socketPath := filepath.Join(tempDir, "vsock.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal("expected listener, got error", err)
+	}
+	defer listener.Close()
+
+	// the Firecracker side of the handshake, the guest listens only on port 1024:
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				request, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				if request != "CONNECT 1024\n" {
+					return
+				}
+				conn.Write([]byte("OK 1073741824\nhello"))
+			}(conn)
+		}
+	}()
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancelFunc()
+
+	_, err = DialVsock(ctx, &metadata.MDRun{}, 1024)
+	assert.Equal(t, ErrNoVsock, err)
+
+	md := &metadata.MDRun{Vsock: &metadata.MDRunVsock{CID: 3, HostPath: socketPath, Path: "/vsock.sock"}}
+	conn, err := DialVsock(ctx, md, 1024)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	data, err := ioutil.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	_, err = DialVsock(ctx, md, 1025)
+	assert.NotNil(t, err, "expected an error for a port the guest does not listen on")
+}